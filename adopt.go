@@ -0,0 +1,199 @@
+package targetsync
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// AdoptConfig configures adopting the targets already in the destination on
+// the first sync, so enabling targetsync on an existing destination doesn't
+// remove the targets missing from the source straight away
+type AdoptConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// GracePeriod is how long adopted targets are kept, 0 keeps them until
+	// they are released through the API
+	GracePeriod time.Duration `yaml:"grace_period"`
+}
+
+// Validate checks the AdoptConfig for errors
+func (c *AdoptConfig) Validate() error {
+	if c.GracePeriod < 0 {
+		return fmt.Errorf("Adopt grace_period must be >=0")
+	}
+	return nil
+}
+
+// AdoptedTarget is a destination target which was missing from the source on
+// the first sync, it isn't removed until its grace period ends or it is
+// released
+type AdoptedTarget struct {
+	Target *Target `json:"target"`
+	// Until is when the grace period ends, unset if the target is kept until
+	// released
+	Until *time.Time `json:"until,omitempty"`
+}
+
+// adoption holds the targets adopted by the Syncer, it outlives leadership so
+// a new leader loop doesn't adopt the targets again
+type adoption struct {
+	// done is whether the targets have been adopted
+	done    bool
+	targets map[string]*AdoptedTarget
+	// released is whether targets have been released (or their grace period
+	// has ended) since the last sync
+	released bool
+}
+
+// adoptTargets adopts the destination targets missing from the source on the
+// first sync, and forgets adopted targets which are now in the source
+func (s *Syncer) adoptTargets(srcMap, dstMap map[string]*Target) {
+	s.adoptLock.Lock()
+	defer s.adoptLock.Unlock()
+
+	if s.adoption.done || !s.Config.Adopt.Enabled {
+		s.forgetAdoptedLocked(srcMap)
+		return
+	}
+	s.adoption.done = true
+	s.adoption.targets = make(map[string]*AdoptedTarget)
+
+	var until *time.Time
+	if s.Config.Adopt.GracePeriod > 0 {
+		t := time.Now().Add(s.Config.Adopt.GracePeriod)
+		until = &t
+	}
+	var adopted []*Target
+	for ip, target := range dstMap {
+		if _, ok := srcMap[ip]; ok {
+			continue
+		}
+		s.adoption.targets[ip] = &AdoptedTarget{Target: target, Until: until}
+		adopted = append(adopted, target)
+	}
+	if len(adopted) == 0 {
+		return
+	}
+
+	msg := fmt.Sprintf("Adopted %d destination targets missing from the source, keeping them until released", len(adopted))
+	if until != nil {
+		msg = fmt.Sprintf("Adopted %d destination targets missing from the source, keeping them for %v", len(adopted), s.Config.Adopt.GracePeriod)
+	}
+	s.emit(Event{
+		Type:    EventTargetsAdopted,
+		Time:    time.Now(),
+		Message: msg,
+		Targets: adopted,
+	})
+}
+
+// forgetAdopted forgets adopted targets which have been added to the source,
+// so they are removed as usual once they are gone from it again
+func (s *Syncer) forgetAdopted(targets []*Target) {
+	srcMap := make(map[string]*Target, len(targets))
+	for _, target := range targets {
+		srcMap[target.IP] = target
+	}
+	s.adoptLock.Lock()
+	defer s.adoptLock.Unlock()
+	s.forgetAdoptedLocked(srcMap)
+}
+
+// forgetAdoptedLocked is forgetAdopted with the adoptLock held
+func (s *Syncer) forgetAdoptedLocked(srcMap map[string]*Target) {
+	for ip := range s.adoption.targets {
+		if _, ok := srcMap[ip]; ok {
+			delete(s.adoption.targets, ip)
+		}
+	}
+}
+
+// isAdopted returns whether the target with the IP is adopted, and within its
+// grace period
+func (s *Syncer) isAdopted(ip string) bool {
+	s.adoptLock.Lock()
+	defer s.adoptLock.Unlock()
+	adopted, ok := s.adoption.targets[ip]
+	return ok && (adopted.Until == nil || time.Now().Before(*adopted.Until))
+}
+
+// adoptionReleased returns whether any adopted targets have been released or
+// reached the end of their grace period since it was last called, in which
+// case they should be synced
+func (s *Syncer) adoptionReleased() bool {
+	s.adoptLock.Lock()
+	defer s.adoptLock.Unlock()
+	now := time.Now()
+	for ip, adopted := range s.adoption.targets {
+		if adopted.Until != nil && !now.Before(*adopted.Until) {
+			s.log().Infof("Grace period of adopted target ended: %v", adopted.Target)
+			delete(s.adoption.targets, ip)
+			s.adoption.released = true
+		}
+	}
+	released := s.adoption.released
+	s.adoption.released = false
+	return released
+}
+
+// Adopted returns the adopted targets, sorted by key
+func (s *Syncer) Adopted() []AdoptedTarget {
+	s.adoptLock.Lock()
+	defer s.adoptLock.Unlock()
+	adopted := make([]AdoptedTarget, 0, len(s.adoption.targets))
+	for _, target := range s.adoption.targets {
+		adopted = append(adopted, *target)
+	}
+	sort.Slice(adopted, func(i, j int) bool {
+		return adopted[i].Target.Key() < adopted[j].Target.Key()
+	})
+	return adopted
+}
+
+// ReleaseAdopted releases the adopted target with the IP (or all of them if
+// empty) so it is removed by the next sync, returning how many were released
+func (s *Syncer) ReleaseAdopted(ip string) int {
+	s.adoptLock.Lock()
+	defer s.adoptLock.Unlock()
+	released := 0
+	for adoptedIP, adopted := range s.adoption.targets {
+		if ip != "" && adoptedIP != ip {
+			continue
+		}
+		s.log().Infof("Releasing adopted target: %v", adopted.Target)
+		delete(s.adoption.targets, adoptedIP)
+		released++
+	}
+	if released > 0 {
+		s.adoption.released = true
+	}
+	return released
+}
+
+// adopted lists (GET) or releases (DELETE, optionally only the `?ip=`) the
+// adopted targets of the pair
+func (h *apiHandler) adopted(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, APIPrefix+"/adopted/")
+	for _, syncer := range h.syncers() {
+		if syncer.name() != name {
+			continue
+		}
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, http.StatusOK, syncer.Adopted())
+		case http.MethodDelete:
+			ip := r.URL.Query().Get("ip")
+			if syncer.ReleaseAdopted(ip) == 0 && ip != "" {
+				http.NotFound(w, r)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		}
+		return
+	}
+	http.NotFound(w, r)
+}
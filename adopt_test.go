@@ -0,0 +1,83 @@
+package targetsync
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAdoptTargets(t *testing.T) {
+	events := make(chanSink, 10)
+	syncer := &Syncer{
+		Name: "a",
+		Config: &SyncConfig{
+			LockOptions: LockOptions{Key: "a"},
+			Adopt:       AdoptConfig{Enabled: true},
+		},
+		Events: events,
+	}
+
+	srcMap := map[string]*Target{"1": {IP: "1"}}
+	dstMap := map[string]*Target{"1": {IP: "1"}, "2": {IP: "2"}, "3": {IP: "3"}}
+	syncer.adoptTargets(srcMap, dstMap)
+	if e := <-events; e.Type != EventTargetsAdopted || len(e.Targets) != 2 {
+		t.Fatalf("Unexpected event: %+v", e)
+	}
+	if syncer.isAdopted("1") || !syncer.isAdopted("2") || !syncer.isAdopted("3") {
+		t.Fatalf("Unexpected adopted targets: %v", syncer.Adopted())
+	}
+
+	// Only the first sync adopts targets, and targets added to the source
+	// are no longer adopted
+	srcMap["2"] = &Target{IP: "2"}
+	dstMap["4"] = &Target{IP: "4"}
+	syncer.adoptTargets(srcMap, dstMap)
+	if syncer.isAdopted("2") || syncer.isAdopted("4") || !syncer.isAdopted("3") {
+		t.Fatalf("Unexpected adopted targets: %v", syncer.Adopted())
+	}
+	if syncer.adoptionReleased() {
+		t.Fatalf("Expected no targets to be released")
+	}
+
+	h := NewAPIHandler([]*Syncer{syncer})
+	for _, tc := range []struct {
+		path string
+		code int
+	}{
+		{"/adopted/missing", http.StatusNotFound},
+		{"/adopted/a?ip=4", http.StatusNotFound},
+		{"/adopted/a?ip=3", http.StatusNoContent},
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, APIPrefix+tc.path, nil))
+		if w.Code != tc.code {
+			t.Fatalf("Unexpected status releasing %s: %d", tc.path, w.Code)
+		}
+	}
+	if syncer.isAdopted("3") || !syncer.adoptionReleased() || syncer.adoptionReleased() {
+		t.Fatalf("Expected target to be released once")
+	}
+}
+
+func TestAdoptGracePeriod(t *testing.T) {
+	syncer := &Syncer{
+		Config: &SyncConfig{
+			LockOptions: LockOptions{Key: "a"},
+			Adopt:       AdoptConfig{Enabled: true, GracePeriod: 50 * time.Millisecond},
+		},
+		Events: make(chanSink, 10),
+	}
+	syncer.adoptTargets(map[string]*Target{}, map[string]*Target{"1": {IP: "1"}})
+	if !syncer.isAdopted("1") || syncer.Adopted()[0].Until == nil {
+		t.Fatalf("Unexpected adopted targets: %v", syncer.Adopted())
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if syncer.isAdopted("1") {
+		t.Fatalf("Expected grace period to have ended")
+	}
+	if !syncer.adoptionReleased() || len(syncer.Adopted()) != 0 {
+		t.Fatalf("Expected target to be released at the end of its grace period")
+	}
+}
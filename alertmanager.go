@@ -0,0 +1,281 @@
+package targetsync
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultAlertmanagerResolveAfter is how long one-off alerts stay firing
+	// if `ResolveAfter` isn't set
+	defaultAlertmanagerResolveAfter = 15 * time.Minute
+	// defaultAlertmanagerResendInterval is how often firing sync failure
+	// alerts are resent if `ResendInterval` isn't set
+	defaultAlertmanagerResendInterval = time.Minute
+	// defaultAlertmanagerTimeout is the timeout for posting alerts if
+	// `Timeout` isn't set
+	defaultAlertmanagerTimeout = 10 * time.Second
+	// maxPendingAlerts is how many alerts can wait to be posted before the
+	// oldest are dropped
+	maxPendingAlerts = 1000
+)
+
+// alertNames are the names of the alerts fired for each event type, events
+// of other types don't fire alerts
+var alertNames = map[EventType]string{
+	EventSyncFailing:            "TargetsyncSyncFailing",
+	EventTargetCountAnomaly:     "TargetsyncTargetCountAnomaly",
+	EventRolloutAborted:         "TargetsyncRolloutAborted",
+	EventRemovalFailed:          "TargetsyncRemovalFailed",
+	EventSourceStale:            "TargetsyncSourceStale",
+	EventOwnershipConflict:      "TargetsyncOwnershipConflict",
+	EventMutationBudgetExceeded: "TargetsyncMutationBudgetExceeded",
+}
+
+// AlertmanagerConfig is the configuration for the Alertmanager EventSink
+type AlertmanagerConfig struct {
+	// URLs of the Alertmanagers, alerts are sent to all of them
+	URLs []string `yaml:"urls"`
+	// Labels added to every alert, e.g. a severity or team to route on
+	Labels map[string]string `yaml:"labels"`
+	// GeneratorURL is linked from the alerts, e.g. a dashboard
+	GeneratorURL string `yaml:"generator_url"`
+	// ResolveAfter is how long alerts for one-off events (e.g. an aborted
+	// rollout) stay firing, defaults to 15m
+	ResolveAfter time.Duration `yaml:"resolve_after"`
+	// ResendInterval is how often the alerts of pairs with failing syncs are
+	// resent to keep them firing, until the syncs recover. Defaults to 1m
+	ResendInterval time.Duration `yaml:"resend_interval"`
+	// Timeout for posting alerts to each Alertmanager, defaults to 10s
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// Validate checks the AlertmanagerConfig for errors
+func (c *AlertmanagerConfig) Validate() error {
+	for _, u := range c.URLs {
+		parsed, err := url.Parse(u)
+		if err != nil {
+			return fmt.Errorf("Invalid alertmanager url %q: %v", u, err)
+		}
+		if parsed.Scheme != "http" && parsed.Scheme != "https" {
+			return fmt.Errorf("Invalid alertmanager url %q: scheme must be http or https", u)
+		}
+	}
+	if c.ResolveAfter < 0 || c.ResendInterval < 0 || c.Timeout < 0 {
+		return fmt.Errorf("Alertmanager resolve_after, resend_interval and timeout must be >=0")
+	}
+	return nil
+}
+
+// alertmanagerAlert is an alert in the format of the Alertmanager v2 API
+type alertmanagerAlert struct {
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+	StartsAt     time.Time         `json:"startsAt"`
+	EndsAt       time.Time         `json:"endsAt"`
+	GeneratorURL string            `json:"generatorURL,omitempty"`
+}
+
+// NewAlertmanagerEventSink returns an AlertmanagerEventSink forwarding all
+// events to `next`, defaulting to the LogEventSink. Alerts are sent by `Run`.
+func NewAlertmanagerEventSink(cfg *AlertmanagerConfig, next EventSink) *AlertmanagerEventSink {
+	if next == nil {
+		next = LogEventSink{}
+	}
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = defaultAlertmanagerTimeout
+	}
+	return &AlertmanagerEventSink{
+		cfg:    *cfg,
+		next:   next,
+		client: &http.Client{Timeout: timeout},
+		firing: make(map[string]*alertmanagerAlert),
+		notify: make(chan struct{}, 1),
+	}
+}
+
+// AlertmanagerEventSink is an EventSink firing Alertmanager alerts for sync
+// pairs whose syncs keep failing and when the safety checks (target count
+// anomalies, aborted rollouts, failed removals, stale sources, ownership
+// conflicts and the mutation budget) trigger. Sync failure alerts keep firing until the pair's syncs
+// recover, the others resolve after `ResolveAfter`.
+type AlertmanagerEventSink struct {
+	cfg    AlertmanagerConfig
+	next   EventSink
+	client *http.Client
+
+	l sync.Mutex
+	// firing are the sync failure alerts, by lock key, which are resent
+	// until resolved
+	firing map[string]*alertmanagerAlert
+	// pending are the alerts waiting to be posted
+	pending []*alertmanagerAlert
+	// notify wakes `Run` when alerts are pending
+	notify chan struct{}
+}
+
+// Emit forwards the event and queues its alert, if any. This never blocks
+// on the Alertmanagers.
+func (s *AlertmanagerEventSink) Emit(e Event) {
+	s.next.Emit(e)
+
+	now := time.Now()
+	s.l.Lock()
+	switch e.Type {
+	case EventSyncFailing:
+		alert := s.alert(e, now)
+		alert.EndsAt = now.Add(3 * s.resendInterval())
+		s.firing[e.Key] = alert
+		s.queue(alert)
+	case EventSyncRecovered:
+		alert, ok := s.firing[e.Key]
+		if !ok {
+			s.l.Unlock()
+			return
+		}
+		delete(s.firing, e.Key)
+		resolved := *alert
+		resolved.EndsAt = now
+		s.queue(&resolved)
+	default:
+		if _, ok := alertNames[e.Type]; !ok {
+			s.l.Unlock()
+			return
+		}
+		alert := s.alert(e, now)
+		resolveAfter := s.cfg.ResolveAfter
+		if resolveAfter <= 0 {
+			resolveAfter = defaultAlertmanagerResolveAfter
+		}
+		alert.EndsAt = now.Add(resolveAfter)
+		s.queue(alert)
+	}
+	s.l.Unlock()
+
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+// alert returns the alert for the event
+func (s *AlertmanagerEventSink) alert(e Event, now time.Time) *alertmanagerAlert {
+	labels := make(map[string]string, len(s.cfg.Labels)+5)
+	for k, v := range s.cfg.Labels {
+		labels[k] = v
+	}
+	labels["alertname"] = alertNames[e.Type]
+	labels["pair"] = e.Name
+	labels["lock_key"] = e.Key
+	if e.Destination != "" {
+		labels["destination"] = e.Destination
+	}
+	if e.ErrorClass != "" {
+		labels["error_class"] = e.ErrorClass
+	}
+	startsAt := e.Time
+	if startsAt.IsZero() {
+		startsAt = now
+	}
+	return &alertmanagerAlert{
+		Labels:       labels,
+		Annotations:  map[string]string{"summary": e.Message},
+		StartsAt:     startsAt,
+		GeneratorURL: s.cfg.GeneratorURL,
+	}
+}
+
+// queue adds the alert to the pending alerts, the lock must be held
+func (s *AlertmanagerEventSink) queue(alert *alertmanagerAlert) {
+	if len(s.pending) >= maxPendingAlerts {
+		logger.Warnf("Too many pending alerts, dropping alert %s for %s", s.pending[0].Labels["alertname"], s.pending[0].Labels["pair"])
+		s.pending = s.pending[1:]
+	}
+	s.pending = append(s.pending, alert)
+}
+
+// resendInterval returns how often firing alerts are resent
+func (s *AlertmanagerEventSink) resendInterval() time.Duration {
+	if s.cfg.ResendInterval <= 0 {
+		return defaultAlertmanagerResendInterval
+	}
+	return s.cfg.ResendInterval
+}
+
+// Run posts the alerts to the Alertmanagers as they are emitted, and resends
+// the firing alerts, until the context is done
+func (s *AlertmanagerEventSink) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.resendInterval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.notify:
+			s.l.Lock()
+			alerts := s.pending
+			s.pending = nil
+			s.l.Unlock()
+			s.post(ctx, alerts)
+		case <-ticker.C:
+			now := time.Now()
+			s.l.Lock()
+			alerts := s.pending
+			s.pending = nil
+			for _, alert := range s.firing {
+				alert.EndsAt = now.Add(3 * s.resendInterval())
+				resent := *alert
+				alerts = append(alerts, &resent)
+			}
+			s.l.Unlock()
+			s.post(ctx, alerts)
+		}
+	}
+}
+
+// post sends the alerts to each of the Alertmanagers. Failures are only
+// logged, as firing alerts are resent anyway.
+func (s *AlertmanagerEventSink) post(ctx context.Context, alerts []*alertmanagerAlert) {
+	if len(alerts) == 0 {
+		return
+	}
+	b, err := json.Marshal(alerts)
+	if err != nil {
+		logger.Errorf("Error marshaling alerts: %v", err)
+		return
+	}
+	for _, u := range s.cfg.URLs {
+		if err := s.postTo(ctx, strings.TrimSuffix(u, "/")+"/api/v2/alerts", b); err != nil {
+			logger.Errorf("Error sending %d alerts to alertmanager %s: %v", len(alerts), u, err)
+		}
+	}
+}
+
+// postTo posts the marshaled alerts to the url
+func (s *AlertmanagerEventSink) postTo(ctx context.Context, u string, b []byte) error {
+	req, err := http.NewRequest(http.MethodPost, u, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
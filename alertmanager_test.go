@@ -0,0 +1,116 @@
+package targetsync
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSyncFailingEvents(t *testing.T) {
+	events := make(chanSink, 10)
+	syncer := &Syncer{
+		Name:        "a",
+		Destination: "aws:arn",
+		Config: &SyncConfig{
+			LockOptions:      LockOptions{Key: "a"},
+			FailureThreshold: time.Millisecond,
+		},
+		Events: events,
+	}
+
+	throttled := wrapError(ErrDestinationThrottled, fmt.Errorf("rate exceeded"))
+	syncer.trackFailure(throttled)
+	if len(events) != 0 {
+		t.Fatalf("Expected no event before the threshold, got %+v", <-events)
+	}
+	time.Sleep(2 * time.Millisecond)
+	syncer.trackFailure(throttled)
+	syncer.trackFailure(throttled)
+	e := <-events
+	if e.Type != EventSyncFailing || e.ErrorClass != "destination_throttled" || e.Destination != "aws:arn" {
+		t.Fatalf("Unexpected event: %+v", e)
+	}
+	if len(events) != 0 {
+		t.Fatalf("Expected a single sync_failing event, got %+v", <-events)
+	}
+
+	syncer.trackFailure(nil)
+	if e := <-events; e.Type != EventSyncRecovered {
+		t.Fatalf("Unexpected event: %+v", e)
+	}
+	syncer.trackFailure(nil)
+	if len(events) != 0 {
+		t.Fatalf("Expected no event while syncing, got %+v", <-events)
+	}
+}
+
+func TestAlertmanagerEventSink(t *testing.T) {
+	posted := make(chan []*alertmanagerAlert, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v2/alerts" {
+			t.Errorf("Unexpected path %s", r.URL.Path)
+		}
+		var alerts []*alertmanagerAlert
+		if err := json.NewDecoder(r.Body).Decode(&alerts); err != nil {
+			t.Errorf("Error decoding alerts: %v", err)
+		}
+		posted <- alerts
+	}))
+	defer srv.Close()
+
+	sink := NewAlertmanagerEventSink(&AlertmanagerConfig{
+		URLs:   []string{srv.URL + "/"},
+		Labels: map[string]string{"severity": "page"},
+	}, make(chanSink, 10))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go sink.Run(ctx)
+
+	next := func() *alertmanagerAlert {
+		select {
+		case alerts := <-posted:
+			if len(alerts) != 1 {
+				t.Fatalf("Expected a single alert, got %d", len(alerts))
+			}
+			return alerts[0]
+		case <-time.After(5 * time.Second):
+			t.Fatalf("No alert posted")
+		}
+		return nil
+	}
+
+	// Events without an alert are only forwarded
+	sink.Emit(Event{Type: EventTargetsAdded, Name: "a", Key: "a"})
+	sink.Emit(Event{Type: EventSyncFailing, Name: "a", Key: "a", Destination: "aws:arn", ErrorClass: "destination_throttled", Message: "failing"})
+	alert := next()
+	expected := map[string]string{
+		"alertname":   "TargetsyncSyncFailing",
+		"pair":        "a",
+		"lock_key":    "a",
+		"destination": "aws:arn",
+		"error_class": "destination_throttled",
+		"severity":    "page",
+	}
+	for k, v := range expected {
+		if alert.Labels[k] != v {
+			t.Fatalf("Expected label %s=%s, got %v", k, v, alert.Labels)
+		}
+	}
+	if alert.Annotations["summary"] != "failing" || !alert.EndsAt.After(time.Now()) {
+		t.Fatalf("Unexpected alert: %+v", alert)
+	}
+
+	sink.Emit(Event{Type: EventSyncRecovered, Name: "a", Key: "a"})
+	if alert := next(); alert.Labels["alertname"] != "TargetsyncSyncFailing" || alert.EndsAt.After(time.Now()) {
+		t.Fatalf("Expected resolved alert, got %+v", alert)
+	}
+
+	sink.Emit(Event{Type: EventRolloutAborted, Name: "a", Key: "a"})
+	if alert := next(); alert.Labels["alertname"] != "TargetsyncRolloutAborted" || alert.EndsAt.Sub(alert.StartsAt) != defaultAlertmanagerResolveAfter {
+		t.Fatalf("Unexpected alert: %+v", alert)
+	}
+}
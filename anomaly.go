@@ -0,0 +1,85 @@
+package targetsync
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// defaultAnomalySamples is the number of source updates in the baseline if
+// `Samples` isn't set
+const defaultAnomalySamples = 10
+
+// AnomalyConfig configures detection of anomalous changes in the number of
+// targets from the source
+type AnomalyConfig struct {
+	// MaxDeviationPercent is how far the number of source targets may
+	// deviate from the baseline before being flagged, 0 disables detection
+	MaxDeviationPercent int `yaml:"max_deviation_percent"`
+	// Samples is the number of source updates averaged for the baseline
+	Samples int `yaml:"samples"`
+	// Block anomalous updates from being synced to the destination. All
+	// updates are part of the baseline, so a sustained change is eventually
+	// accepted.
+	Block bool `yaml:"block"`
+}
+
+// anomalyDetector tracks a rolling baseline of the source target count
+type anomalyDetector struct {
+	cfg     AnomalyConfig
+	samples []int
+}
+
+func newAnomalyDetector(cfg AnomalyConfig) *anomalyDetector {
+	if cfg.Samples <= 0 {
+		cfg.Samples = defaultAnomalySamples
+	}
+	return &anomalyDetector{cfg: cfg}
+}
+
+// observe records the count and returns the baseline and whether the count
+// deviates too far from it
+func (d *anomalyDetector) observe(count int) (float64, bool) {
+	var baseline float64
+	for _, sample := range d.samples {
+		baseline += float64(sample)
+	}
+	if len(d.samples) > 0 {
+		baseline /= float64(len(d.samples))
+	}
+
+	d.samples = append(d.samples, count)
+	if len(d.samples) > d.cfg.Samples {
+		d.samples = d.samples[1:]
+	}
+
+	if d.cfg.MaxDeviationPercent <= 0 || baseline == 0 {
+		return baseline, false
+	}
+	deviation := math.Abs(float64(count)-baseline) / baseline * 100
+	return baseline, deviation > float64(d.cfg.MaxDeviationPercent)
+}
+
+// checkAnomaly checks the source targets against the baseline, returning
+// whether they should be blocked from being synced
+func (s *Syncer) checkAnomaly(d *anomalyDetector, targets []*Target) bool {
+	name := s.name()
+	sourceTargets.WithLabelValues(name).Set(float64(len(targets)))
+
+	baseline, anomalous := d.observe(len(targets))
+	if !anomalous {
+		return false
+	}
+
+	sourceTargetAnomaliesTotal.WithLabelValues(name).Inc()
+	action := "syncing anyway"
+	if d.cfg.Block {
+		action = "not syncing"
+	}
+	s.emit(Event{
+		Type:    EventTargetCountAnomaly,
+		Time:    time.Now(),
+		Message: fmt.Sprintf("Source has %d targets, deviating more than %d%% from the baseline of %.1f, %s", len(targets), d.cfg.MaxDeviationPercent, baseline, action),
+	})
+	return d.cfg.Block
+}
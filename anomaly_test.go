@@ -0,0 +1,30 @@
+package targetsync
+
+import "testing"
+
+func TestAnomalyDetector(t *testing.T) {
+	d := newAnomalyDetector(AnomalyConfig{
+		MaxDeviationPercent: 50,
+		Samples:             3,
+	})
+
+	tests := []struct {
+		count     int
+		anomalous bool
+	}{
+		// no baseline yet
+		{10, false},
+		{12, false},
+		{9, false},
+		// more than 50% below the baseline
+		{2, true},
+		// baseline is now (12+9+2)/3
+		{10, false},
+		{30, true},
+	}
+	for i, test := range tests {
+		if _, anomalous := d.observe(test.count); anomalous != test.anomalous {
+			t.Fatalf("Mismatch in anomaly %d count=%d expected=%v actual=%v", i, test.count, test.anomalous, anomalous)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package targetsync
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// APIPrefix is the path prefix of the current version of the admin API
+const APIPrefix = "/api/v1"
+
+// ReadyResponse is the response of the ready endpoint
+type ReadyResponse struct {
+	Ready bool `json:"ready"`
+	// NotReady are the names of the pairs which aren't ready
+	NotReady []string `json:"not_ready,omitempty"`
+}
+
+// IsReady returns whether the Syncer has started and its source is healthy
+func (s SyncerStatus) IsReady() bool {
+	return s.Started && s.SourceError == ""
+}
+
+// NewAPIHandler returns the handler for the admin API of the Syncers, the API
+// is described in api/openapi.yaml
+func NewAPIHandler(syncers []*Syncer) http.Handler {
+	return NewDynamicAPIHandler(func() []*Syncer { return syncers })
+}
+
+// NewDynamicAPIHandler returns the handler for the admin API of the Syncers
+// returned by `syncers`, for when pairs are started and stopped at runtime
+// (e.g. by the K8sServiceController)
+func NewDynamicAPIHandler(syncers func() []*Syncer) http.Handler {
+	h := &apiHandler{syncers: syncers}
+	mux := http.NewServeMux()
+	mux.HandleFunc(APIPrefix+"/ready", h.ready)
+	mux.HandleFunc(APIPrefix+"/status", h.status)
+	mux.HandleFunc(APIPrefix+"/status/", h.pairStatus)
+	mux.HandleFunc(APIPrefix+"/diff", h.diff)
+	mux.HandleFunc(APIPrefix+"/adopted/", h.adopted)
+	mux.HandleFunc(APIPrefix+"/budget/", h.budget)
+	mux.HandleFunc(APIPrefix+"/removals/", h.removals)
+	return mux
+}
+
+type apiHandler struct {
+	syncers func() []*Syncer
+}
+
+func (h *apiHandler) ready(w http.ResponseWriter, r *http.Request) {
+	resp := ReadyResponse{Ready: true}
+	for _, syncer := range h.syncers() {
+		if status := syncer.Status(); !status.IsReady() {
+			resp.Ready = false
+			resp.NotReady = append(resp.NotReady, status.Name)
+		}
+	}
+	code := http.StatusOK
+	if !resp.Ready {
+		code = http.StatusServiceUnavailable
+	}
+	writeJSON(w, code, resp)
+}
+
+func (h *apiHandler) status(w http.ResponseWriter, r *http.Request) {
+	syncers := h.syncers()
+	statuses := make([]SyncerStatus, len(syncers))
+	for i, syncer := range syncers {
+		statuses[i] = syncer.Status()
+	}
+	writeJSON(w, http.StatusOK, statuses)
+}
+
+func (h *apiHandler) pairStatus(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, APIPrefix+"/status/")
+	for _, syncer := range h.syncers() {
+		if status := syncer.Status(); status.Name == name {
+			writeJSON(w, http.StatusOK, status)
+			return
+		}
+	}
+	http.NotFound(w, r)
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logger.Errorf("Error encoding API response: %v", err)
+	}
+}
@@ -0,0 +1,209 @@
+package targetsync
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// defaultASGPollInterval is how often the ASGs are listed if `PollInterval`
+// isn't set
+const defaultASGPollInterval = 30 * time.Second
+
+const (
+	// MetaInstanceID is the source meta key for the EC2 instance ID
+	MetaInstanceID = "aws/instance-id"
+	// MetaAvailabilityZone is the source meta key for the availability zone
+	MetaAvailabilityZone = "aws/availability-zone"
+	// MetaInstanceType is the source meta key for the EC2 instance type
+	MetaInstanceType = "aws/instance-type"
+	// MetaVCPUs is the source meta key for the instance's vCPU count
+	MetaVCPUs = "aws/vcpus"
+)
+
+// NewASGSource returns a new source for the instances of AWS Auto Scaling Groups
+func NewASGSource(cfg *ASGConfig) (*ASGSource, error) {
+	sess, err := awsSession(cfg.Region, cfg.Credentials)
+	if err != nil {
+		return nil, err
+	}
+	return &ASGSource{
+		cfg:         cfg,
+		asg:         autoscaling.New(sess),
+		ec2:         ec2.New(sess),
+		sqs:         sqs.New(sess),
+		lastSuccess: time.Now(),
+	}, nil
+}
+
+// ASGSource is a TargetSource implementation for the InService instances of
+// AWS Auto Scaling Groups
+type ASGSource struct {
+	cfg *ASGConfig
+	asg *autoscaling.AutoScaling
+	ec2 *ec2.EC2
+	sqs *sqs.SQS
+
+	l sync.Mutex
+	// lastSuccess is the time of the last successful listing
+	lastSuccess time.Time
+	lastErr     error
+}
+
+// log returns the source's Logger
+func (s *ASGSource) log() Logger {
+	return loggerOr(s.cfg.Logger)
+}
+
+// Healthy to implement the `HealthChecker` interface, the source is unhealthy
+// if listing the instances has been failing for longer than `UnhealthyAfter`
+func (s *ASGSource) Healthy() error {
+	if s.cfg.UnhealthyAfter <= 0 {
+		return nil
+	}
+	s.l.Lock()
+	defer s.l.Unlock()
+	if since := time.Since(s.lastSuccess); since > s.cfg.UnhealthyAfter {
+		return fmt.Errorf("No successful ASG listing in %v: %v", since, s.lastErr)
+	}
+	return nil
+}
+
+// Subscribe lists the instances every `PollInterval`, or as soon as a
+// lifecycle notification is received on the SQS queue (if configured)
+func (s *ASGSource) Subscribe(ctx context.Context) (chan []*Target, error) {
+	interval := s.cfg.PollInterval
+	if interval <= 0 {
+		interval = defaultASGPollInterval
+	}
+
+	refreshCh := make(chan struct{}, 1)
+	if s.cfg.QueueURL != "" {
+		go watchSQS(ctx, s.sqs, s.cfg.QueueURL, "lifecycle notification", refreshCh)
+	}
+
+	// TODO: configurable size?
+	ch := make(chan []*Target, 100)
+	go func() {
+		defer close(ch)
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			targets, err := s.targets(ctx)
+			s.l.Lock()
+			s.lastErr = err
+			if err == nil {
+				s.lastSuccess = time.Now()
+			}
+			s.l.Unlock()
+
+			if err != nil {
+				s.log().Errorf("Error listing ASG instances: %v", err)
+			} else {
+				select {
+				case ch <- targets:
+				case <-ctx.Done():
+					return
+				}
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+			case <-refreshCh:
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// matches returns whether the group is one of the configured ASGs
+func (s *ASGSource) matches(group *autoscaling.Group) bool {
+	if len(s.cfg.Names) > 0 {
+		return true
+	}
+	for key, value := range s.cfg.Tags {
+		found := false
+		for _, tag := range group.Tags {
+			if aws.StringValue(tag.Key) == key && aws.StringValue(tag.Value) == value {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// targets returns a target for every InService instance of the ASGs
+func (s *ASGSource) targets(ctx context.Context) ([]*Target, error) {
+	input := &autoscaling.DescribeAutoScalingGroupsInput{}
+	if len(s.cfg.Names) > 0 {
+		input.AutoScalingGroupNames = aws.StringSlice(s.cfg.Names)
+	}
+
+	instanceIDs := make([]*string, 0)
+	if err := s.asg.DescribeAutoScalingGroupsPagesWithContext(ctx, input, func(page *autoscaling.DescribeAutoScalingGroupsOutput, _ bool) bool {
+		for _, group := range page.AutoScalingGroups {
+			if !s.matches(group) {
+				continue
+			}
+			for _, instance := range group.Instances {
+				if aws.StringValue(instance.LifecycleState) == autoscaling.LifecycleStateInService {
+					instanceIDs = append(instanceIDs, instance.InstanceId)
+				}
+			}
+		}
+		return true
+	}); err != nil {
+		return nil, wrapError(ErrSourceUnavailable, fmt.Errorf("Error describing ASGs: %v", err))
+	}
+
+	targets := make([]*Target, 0, len(instanceIDs))
+	if len(instanceIDs) == 0 {
+		return targets, nil
+	}
+	if err := s.ec2.DescribeInstancesPagesWithContext(ctx, &ec2.DescribeInstancesInput{
+		InstanceIds: instanceIDs,
+	}, func(page *ec2.DescribeInstancesOutput, _ bool) bool {
+		for _, reservation := range page.Reservations {
+			for _, instance := range reservation.Instances {
+				ip := aws.StringValue(instance.PrivateIpAddress)
+				if ip == "" {
+					continue
+				}
+				target := &Target{
+					IP:   ip,
+					Port: s.cfg.Port,
+					Meta: map[string]string{
+						MetaInstanceID:   aws.StringValue(instance.InstanceId),
+						MetaHostname:     aws.StringValue(instance.PrivateDnsName),
+						MetaInstanceType: aws.StringValue(instance.InstanceType),
+					},
+				}
+				if cpu := instance.CpuOptions; cpu != nil && cpu.CoreCount != nil {
+					vcpus := aws.Int64Value(cpu.CoreCount) * aws.Int64Value(cpu.ThreadsPerCore)
+					target.Meta[MetaVCPUs] = strconv.FormatInt(vcpus, 10)
+				}
+				if instance.Placement != nil {
+					target.Meta[MetaAvailabilityZone] = aws.StringValue(instance.Placement.AvailabilityZone)
+				}
+				targets = append(targets, target)
+			}
+		}
+		return true
+	}); err != nil {
+		return nil, wrapError(ErrSourceUnavailable, fmt.Errorf("Error describing instances: %v", err))
+	}
+	return targets, nil
+}
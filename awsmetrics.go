@@ -0,0 +1,98 @@
+package targetsync
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+// instrumentAWSSession adds handlers to the session recording the metrics of
+// every AWS API call made with it, per service and operation
+func instrumentAWSSession(sess *session.Session) {
+	sess.Handlers.CompleteAttempt.PushBackNamed(request.NamedHandler{
+		Name: "targetsync.AttemptMetrics",
+		Fn:   recordAWSAttempt,
+	})
+	sess.Handlers.Complete.PushBackNamed(request.NamedHandler{
+		Name: "targetsync.CallMetrics",
+		Fn:   recordAWSCall,
+	})
+}
+
+// awsErrorCode returns the aws error code of the error, `ok` if nil
+func awsErrorCode(err error) string {
+	if err == nil {
+		return "ok"
+	}
+	if aerr, ok := err.(awserr.Error); ok {
+		return aerr.Code()
+	}
+	return "unknown"
+}
+
+// isAWSThrottle returns whether the error is aws throttling the request
+func isAWSThrottle(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	if !ok {
+		return false
+	}
+	_, throttled := throttleCodes[aerr.Code()]
+	return throttled
+}
+
+// recordAWSAttempt counts throttled attempts, which the sdk may retry
+func recordAWSAttempt(r *request.Request) {
+	if !isAWSThrottle(r.Error) {
+		return
+	}
+	service, operation := r.ClientInfo.ServiceName, r.Operation.Name
+	awsAPIThrottlesTotal.WithLabelValues(service, operation).Inc()
+	logger.Debugf("AWS %s %s throttled on attempt %d: %v", service, operation, r.RetryCount+1, r.Error)
+	if stats := awsCallStatsFrom(r.Context()); stats != nil {
+		atomic.AddInt64(&stats.throttles, 1)
+	}
+}
+
+// recordAWSCall records the outcome and latency (including any retries) of a
+// call
+func recordAWSCall(r *request.Request) {
+	service, operation := r.ClientInfo.ServiceName, r.Operation.Name
+	awsAPICallsTotal.WithLabelValues(service, operation, awsErrorCode(r.Error)).Inc()
+	awsAPICallDuration.WithLabelValues(service, operation).Observe(time.Since(r.Time).Seconds())
+	if r.RetryCount > 0 {
+		awsAPIRetriesTotal.WithLabelValues(service, operation).Add(float64(r.RetryCount))
+		if stats := awsCallStatsFrom(r.Context()); stats != nil {
+			atomic.AddInt64(&stats.retries, int64(r.RetryCount))
+		}
+	}
+}
+
+// awsCallStats counts the throttled attempts and retries of the AWS calls
+// made with a context carrying it, so they can be attributed to a sync pair
+type awsCallStats struct {
+	throttles int64
+	retries   int64
+}
+
+type awsCallStatsKey struct{}
+
+// withAWSCallStats returns a context counting the throttles and retries of
+// the AWS calls made with it
+func withAWSCallStats(ctx context.Context) (context.Context, *awsCallStats) {
+	stats := &awsCallStats{}
+	return context.WithValue(ctx, awsCallStatsKey{}, stats), stats
+}
+
+func awsCallStatsFrom(ctx context.Context) *awsCallStats {
+	stats, _ := ctx.Value(awsCallStatsKey{}).(*awsCallStats)
+	return stats
+}
+
+// get returns the throttles and retries counted so far
+func (s *awsCallStats) get() (throttles, retries int64) {
+	return atomic.LoadInt64(&s.throttles), atomic.LoadInt64(&s.retries)
+}
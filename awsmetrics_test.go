@@ -0,0 +1,73 @@
+package targetsync
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+// throttlingDestination is a destination whose calls are throttled the given
+// number of times before succeeding
+type throttlingDestination struct {
+	*mockDestination
+	throttles int
+}
+
+func (d *throttlingDestination) GetTargets(ctx context.Context) ([]*Target, error) {
+	stats := awsCallStatsFrom(ctx)
+	if stats == nil {
+		return nil, fmt.Errorf("Expected the call to count throttles")
+	}
+	stats.throttles += int64(d.throttles)
+	stats.retries += int64(d.throttles)
+	return d.mockDestination.GetTargets(ctx)
+}
+
+func TestDestinationThrottled(t *testing.T) {
+	events := make(chanSink, 10)
+	dst := &throttlingDestination{mockDestination: newmockDestination()}
+	syncer := &Syncer{
+		Name:   "a",
+		Config: &SyncConfig{LockOptions: LockOptions{Key: "a"}},
+		Dst:    dst,
+		Events: events,
+	}
+	if _, err := syncer.getTargets(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(events) != 0 {
+		t.Fatalf("Expected no events without throttling, got %+v", <-events)
+	}
+
+	dst.throttles = 2
+	if _, err := syncer.getTargets(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	select {
+	case e := <-events:
+		if e.Type != EventDestinationThrottled || e.Message != "Destination get_targets was throttled 2 times (2 retries)" {
+			t.Fatalf("Unexpected event: %+v", e)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Expected a destination_throttled event")
+	}
+}
+
+func TestAWSErrorCode(t *testing.T) {
+	throttled := awserr.New("Throttling", "Rate exceeded", nil)
+	if code := awsErrorCode(throttled); code != "Throttling" || !isAWSThrottle(throttled) {
+		t.Fatalf("Expected a throttle, got %s", code)
+	}
+	if !IsErrorClass(wrapAWSError(throttled), ErrDestinationThrottled) {
+		t.Fatalf("Expected the throttle to be classified")
+	}
+	if code := awsErrorCode(nil); code != "ok" {
+		t.Fatalf("Expected ok, got %s", code)
+	}
+	if isAWSThrottle(fmt.Errorf("Throttling")) {
+		t.Fatalf("Only aws errors are throttles")
+	}
+}
@@ -0,0 +1,196 @@
+package targetsync
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultBudgetWindow is the window of the mutation budget if
+	// `MutationBudget.Window` isn't set
+	defaultBudgetWindow = time.Hour
+	// budgetPausedPoll is how often removals held by the mutation budget
+	// check whether mutations were resumed
+	budgetPausedPoll = 5 * time.Second
+)
+
+// MutationBudgetConfig limits how many targets may be added to and removed
+// from the destination within a rolling window. Once the budget is exceeded
+// all mutations are paused until resumed through the API, guarding against a
+// flapping source burning through the destination's API quota and
+// destabilizing the load balancer.
+type MutationBudgetConfig struct {
+	// MaxMutations is the most targets added and removed within the Window,
+	// 0 disables the budget
+	MaxMutations int `yaml:"max_mutations"`
+	// Window defaults to 1h
+	Window time.Duration `yaml:"window"`
+}
+
+// Validate checks the MutationBudgetConfig for errors
+func (c *MutationBudgetConfig) Validate() error {
+	if c.MaxMutations < 0 || c.Window < 0 {
+		return fmt.Errorf("Mutation budget max_mutations and window must be >=0")
+	}
+	return nil
+}
+
+// window returns the window of the budget
+func (c *MutationBudgetConfig) window() time.Duration {
+	if c.Window <= 0 {
+		return defaultBudgetWindow
+	}
+	return c.Window
+}
+
+// MutationBudget is the state of a Syncer's mutation budget
+type MutationBudget struct {
+	MaxMutations int `json:"max_mutations"`
+	// Window of the budget, as a duration string (e.g. `1h0m0s`)
+	Window string `json:"window"`
+	// Used is the number of targets mutated within the window
+	Used   int  `json:"used"`
+	Paused bool `json:"paused"`
+	// PausedAt is when the budget was exceeded, if paused
+	PausedAt *time.Time `json:"paused_at,omitempty"`
+}
+
+// mutation is a batch of targets mutated at a point in time
+type mutation struct {
+	time  time.Time
+	count int
+}
+
+// budget tracks the mutations within the budget's window
+type budget struct {
+	mutations []mutation
+	pausedAt  *time.Time
+}
+
+// used drops the mutations outside the window and returns the number of
+// targets mutated within it
+func (b *budget) used(window time.Duration, now time.Time) int {
+	i := 0
+	for i < len(b.mutations) && now.Sub(b.mutations[i].time) > window {
+		i++
+	}
+	b.mutations = b.mutations[i:]
+	used := 0
+	for _, m := range b.mutations {
+		used += m.count
+	}
+	return used
+}
+
+// spendBudget records the mutation of `n` targets, returning an error (and
+// pausing mutations) if it would exceed the mutation budget
+func (s *Syncer) spendBudget(n int) error {
+	cfg := &s.Config.MutationBudget
+	if cfg.MaxMutations <= 0 || n == 0 {
+		return nil
+	}
+	now := time.Now()
+	s.budgetLock.Lock()
+	if s.budget.pausedAt != nil {
+		s.budgetLock.Unlock()
+		return wrapError(ErrMutationBudgetExceeded, fmt.Errorf("Mutations are paused until resumed"))
+	}
+	used := s.budget.used(cfg.window(), now)
+	if used+n <= cfg.MaxMutations {
+		s.budget.mutations = append(s.budget.mutations, mutation{time: now, count: n})
+		mutationBudgetUsed.WithLabelValues(s.name()).Set(float64(used + n))
+		s.budgetLock.Unlock()
+		return nil
+	}
+	s.budget.pausedAt = &now
+	s.budgetLock.Unlock()
+
+	mutationsPaused.WithLabelValues(s.name()).Set(1)
+	err := wrapError(ErrMutationBudgetExceeded, fmt.Errorf("Mutating %d targets would exceed the budget of %d per %v (%d used), pausing mutations until resumed", n, cfg.MaxMutations, cfg.window(), used))
+	s.emit(Event{
+		Type:       EventMutationBudgetExceeded,
+		Time:       now,
+		Message:    err.Error(),
+		ErrorClass: ErrorClass(err),
+	})
+	return err
+}
+
+// budgetPaused returns whether mutations are paused by the mutation budget
+func (s *Syncer) budgetPaused() bool {
+	s.budgetLock.Lock()
+	defer s.budgetLock.Unlock()
+	return s.budget.pausedAt != nil
+}
+
+// budgetPausedSync returns whether syncs are skipped as mutations are paused,
+// a full sync is queued when they are resumed
+func (s *Syncer) budgetPausedSync() bool {
+	if !s.budgetPaused() {
+		return false
+	}
+	s.log().Debugf("Mutations are paused by the mutation budget, not syncing until resumed")
+	return true
+}
+
+// MutationBudget returns the state of the mutation budget
+func (s *Syncer) MutationBudget() MutationBudget {
+	cfg := &s.Config.MutationBudget
+	s.budgetLock.Lock()
+	defer s.budgetLock.Unlock()
+	b := MutationBudget{
+		MaxMutations: cfg.MaxMutations,
+		Window:       cfg.window().String(),
+		Used:         s.budget.used(cfg.window(), time.Now()),
+	}
+	if s.budget.pausedAt != nil {
+		pausedAt := *s.budget.pausedAt
+		b.Paused = true
+		b.PausedAt = &pausedAt
+	}
+	return b
+}
+
+// ResumeMutations resets the mutation budget, resuming mutations if they were
+// paused, and queues a full sync. Returns whether mutations were paused.
+func (s *Syncer) ResumeMutations() bool {
+	s.budgetLock.Lock()
+	paused := s.budget.pausedAt != nil
+	s.budget = budget{}
+	s.budgetLock.Unlock()
+
+	name := s.name()
+	mutationsPaused.WithLabelValues(name).Set(0)
+	mutationBudgetUsed.WithLabelValues(name).Set(0)
+	if paused {
+		s.log().Infof("Mutation budget reset, resuming mutations")
+		if s.Queue != nil {
+			s.Queue.Add(name)
+		}
+	}
+	return paused
+}
+
+// budget returns (GET) or resets (DELETE), resuming paused mutations, the
+// mutation budget of the pair
+func (h *apiHandler) budget(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, APIPrefix+"/budget/")
+	for _, syncer := range h.syncers() {
+		if syncer.name() != name {
+			continue
+		}
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, http.StatusOK, syncer.MutationBudget())
+		case http.MethodDelete:
+			syncer.ResumeMutations()
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		}
+		return
+	}
+	http.NotFound(w, r)
+}
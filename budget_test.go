@@ -0,0 +1,51 @@
+package targetsync
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMutationBudget(t *testing.T) {
+	events := make(chanSink, 10)
+	syncer := &Syncer{
+		Name: "a",
+		Config: &SyncConfig{
+			LockOptions:    LockOptions{Key: "a"},
+			MutationBudget: MutationBudgetConfig{MaxMutations: 3, Window: time.Minute},
+		},
+		Dst:    newmockDestination(),
+		Events: events,
+	}
+	ctx := context.Background()
+
+	if err := syncer.addTargets(ctx, []*Target{{IP: "1"}, {IP: "2"}}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	err := syncer.addTargets(ctx, []*Target{{IP: "3"}, {IP: "4"}})
+	if !IsErrorClass(err, ErrMutationBudgetExceeded) {
+		t.Fatalf("Expected the budget to be exceeded, got %v", err)
+	}
+	<-events
+	if e := <-events; e.Type != EventMutationBudgetExceeded {
+		t.Fatalf("Unexpected event: %+v", e)
+	}
+
+	// Everything is paused until resumed, even within the budget
+	if err := syncer.removeTargets(ctx, []*Target{{IP: "1"}}); !IsErrorClass(err, ErrMutationBudgetExceeded) {
+		t.Fatalf("Expected mutations to be paused, got %v", err)
+	}
+	if budget := syncer.MutationBudget(); !budget.Paused || budget.Used != 2 {
+		t.Fatalf("Unexpected budget: %+v", budget)
+	}
+
+	if !syncer.ResumeMutations() {
+		t.Fatalf("Expected mutations to have been paused")
+	}
+	if err := syncer.removeTargets(ctx, []*Target{{IP: "1"}}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if budget := syncer.MutationBudget(); budget.Paused || budget.Used != 1 {
+		t.Fatalf("Unexpected budget: %+v", budget)
+	}
+}
@@ -0,0 +1,115 @@
+package targetsync
+
+import (
+	"context"
+	"fmt"
+)
+
+// validateChain checks the chained destinations of the pair for errors
+func (c *PairConfig) validateChain() error {
+	for i, member := range c.Chain {
+		if len(member.Chain) > 0 {
+			return fmt.Errorf("Chained destination %d can't itself be a chain", i)
+		}
+		if typ, id := member.destinationIdentity(); typ == "aws" && id == "" {
+			return fmt.Errorf("Chained destination %d has no destination", i)
+		}
+		if err := member.Validate(); err != nil {
+			return fmt.Errorf("Invalid chained destination %d: %v", i, err)
+		}
+	}
+	return nil
+}
+
+// ChainMember is a destination in a ChainDestination
+type ChainMember struct {
+	// Name identifies the destination in errors and logs
+	Name string
+	Dst  TargetDestination
+}
+
+// NewChainDestination returns a ChainDestination of the destinations, in the
+// order targets are added to them
+func NewChainDestination(members []ChainMember) (*ChainDestination, error) {
+	if len(members) == 0 {
+		return nil, fmt.Errorf("No chained destinations defined")
+	}
+	return &ChainDestination{members: members}, nil
+}
+
+// ChainDestination is a TargetDestination maintaining the same targets in
+// several destinations which depend on each other, e.g. registering targets
+// in service discovery before the load balancer routes to them. Targets are
+// added to the destinations in order and removed in reverse order, so each
+// destination only has targets once those before it do.
+//
+// GetTargets returns the targets in every destination, so targets left in
+// only some of them (by a failed add) are added again and completed. Failed
+// removals are retried by the Syncer, and removals are idempotent.
+type ChainDestination struct {
+	members []ChainMember
+}
+
+// GetTargets returns the targets in all of the destinations, as returned by
+// the last destination (e.g. with its health)
+func (d *ChainDestination) GetTargets(ctx context.Context) ([]*Target, error) {
+	var counts map[string]int
+	var last []*Target
+	for i, member := range d.members {
+		targets, err := member.Dst.GetTargets(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("Error getting targets from chained destination %s: %v", member.Name, err)
+		}
+		if i == 0 {
+			counts = make(map[string]int, len(targets))
+		}
+		for _, target := range targets {
+			counts[target.Key()]++
+		}
+		last = targets
+	}
+
+	targets := make([]*Target, 0, len(last))
+	for _, target := range last {
+		if counts[target.Key()] == len(d.members) {
+			targets = append(targets, target)
+		}
+	}
+	return targets, nil
+}
+
+// AddTargets adds the targets to each destination in order, stopping at the
+// first failure
+func (d *ChainDestination) AddTargets(ctx context.Context, targets []*Target) error {
+	for _, member := range d.members {
+		if err := member.Dst.AddTargets(ctx, targets); err != nil {
+			return fmt.Errorf("Error adding targets to chained destination %s: %v", member.Name, err)
+		}
+	}
+	return nil
+}
+
+// RemoveTargets removes the targets from each destination in reverse order,
+// stopping at the first failure
+func (d *ChainDestination) RemoveTargets(ctx context.Context, targets []*Target) error {
+	for i := len(d.members) - 1; i >= 0; i-- {
+		member := d.members[i]
+		if err := member.Dst.RemoveTargets(ctx, targets); err != nil {
+			return fmt.Errorf("Error removing targets from chained destination %s: %v", member.Name, err)
+		}
+	}
+	return nil
+}
+
+// ReconcileSettings to implement the `SettingsDestination` interface, the
+// settings of each destination which has them are reconciled
+func (d *ChainDestination) ReconcileSettings(ctx context.Context) error {
+	for _, member := range d.members {
+		if settings, ok := member.Dst.(SettingsDestination); ok {
+			if err := settings.ReconcileSettings(ctx); err != nil {
+				return fmt.Errorf("Error reconciling settings of chained destination %s: %v", member.Name, err)
+			}
+		}
+	}
+	return nil
+}
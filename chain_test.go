@@ -0,0 +1,153 @@
+package targetsync
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// orderedDestination is a mockDestination recording the order of calls in
+// calls, and failing adds while failAdd is set
+type orderedDestination struct {
+	*mockDestination
+	name    string
+	calls   *[]string
+	failAdd bool
+}
+
+func (d *orderedDestination) AddTargets(ctx context.Context, targets []*Target) error {
+	*d.calls = append(*d.calls, "add "+d.name)
+	if d.failAdd {
+		return fmt.Errorf("add failed")
+	}
+	return d.mockDestination.AddTargets(ctx, targets)
+}
+
+func (d *orderedDestination) RemoveTargets(ctx context.Context, targets []*Target) error {
+	*d.calls = append(*d.calls, "remove "+d.name)
+	return d.mockDestination.RemoveTargets(ctx, targets)
+}
+
+func TestChainDestination(t *testing.T) {
+	var calls []string
+	discovery := &orderedDestination{mockDestination: newmockDestination(), name: "discovery", calls: &calls}
+	lb := &orderedDestination{mockDestination: newmockDestination(), name: "lb", calls: &calls}
+	dst, err := NewChainDestination([]ChainMember{
+		{Name: "discovery", Dst: discovery},
+		{Name: "lb", Dst: lb},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	ctx := context.Background()
+	a, b := &Target{IP: "1"}, &Target{IP: "2"}
+
+	// Adds are applied in order, removes in reverse
+	if err := dst.AddTargets(ctx, []*Target{a}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := dst.RemoveTargets(ctx, []*Target{a}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if expected := []string{"add discovery", "add lb", "remove lb", "remove discovery"}; !reflect.DeepEqual(calls, expected) {
+		t.Fatalf("Expected calls %v, got %v", expected, calls)
+	}
+
+	// A target only added to some destinations isn't in the chain, so it is
+	// added again
+	lb.failAdd = true
+	if err := dst.AddTargets(ctx, []*Target{b}); err == nil {
+		t.Fatalf("Expected the add to fail")
+	}
+	targets, err := dst.GetTargets(ctx)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(targets) != 0 {
+		t.Fatalf("Expected no targets in the chain, got %v", targets)
+	}
+
+	lb.failAdd = false
+	calls = nil
+	if err := dst.AddTargets(ctx, []*Target{b}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if expected := []string{"add discovery", "add lb"}; !reflect.DeepEqual(calls, expected) {
+		t.Fatalf("Expected calls %v, got %v", expected, calls)
+	}
+	targets, err = dst.GetTargets(ctx)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := equalTargets(targets, []*Target{b}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestChainConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+		err  bool
+	}{
+		{
+			name: "valid",
+			yaml: `
+credentials:
+  aws:
+    role_arn: arn:aws:iam::123456789012:role/targetsync
+chain:
+  - fake_destination:
+      enabled: true
+  - aws:
+      target_group_arn: arn:aws:elasticloadbalancing:us-east-1:123456789012:targetgroup/web/abc
+`,
+		},
+		{
+			name: "no destination",
+			yaml: `
+chain:
+  - fake_destination:
+      enabled: true
+  - syncer:
+      remove_delay: 1m
+`,
+			err: true,
+		},
+		{
+			name: "nested chain",
+			yaml: `
+chain:
+  - chain:
+      - fake_destination:
+          enabled: true
+`,
+			err: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pair := &PairConfig{}
+			if err := yaml.Unmarshal([]byte(test.yaml), pair); err != nil {
+				t.Fatalf("Error unmarshaling: %v", err)
+			}
+			err := pair.Validate()
+			if test.err {
+				if err == nil {
+					t.Fatalf("Expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			// The pair's credentials are used by chained destinations
+			if arn := pair.Chain[1].AWSConfig.Credentials.RoleARN; arn != pair.Credentials.AWS.RoleARN {
+				t.Fatalf("Expected the pair's credentials, got %q", arn)
+			}
+		})
+	}
+}
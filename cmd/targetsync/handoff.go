@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/wish/targetsync"
+)
+
+// handoffReadyTimeout is how long to wait for the syncers to be ready before
+// taking over from the previous process regardless
+const handoffReadyTimeout = time.Minute
+
+// runHandoff takes over the sync pairs of the previous process on the
+// `--handoff-socket` once the syncers are ready (waiting on the locks it
+// holds), and then serves the socket for the next process. Returns whether
+// this process's pairs were handed off to the next process.
+func runHandoff(ctx context.Context, store *targetsync.HandoffStateStore, syncers []*targetsync.Syncer, server *targetsync.HandoffServer) bool {
+	readyCtx, cancel := context.WithTimeout(ctx, handoffReadyTimeout)
+	for _, syncer := range syncers {
+		select {
+		case <-readyCtx.Done():
+		case <-syncer.Ready():
+		}
+	}
+	cancel()
+	if ctx.Err() != nil {
+		store.Complete()
+		return false
+	}
+	if err := store.Handoff(ctx, opts.HandoffSocket); err != nil {
+		logrus.Errorf("Error taking over from the previous process: %v", err)
+	}
+
+	if err := server.Serve(ctx); err != nil {
+		logrus.Errorf("Error serving handoff socket: %v", err)
+		return false
+	}
+	return ctx.Err() == nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/wish/targetsync"
+)
+
+var inventoryOpts struct {
+	Format  string        `long:"format" description:"output format" choice:"json" choice:"csv" default:"json"`
+	Output  string        `short:"o" long:"output" description:"file to write the inventory to, defaults to stdout"`
+	Timeout time.Duration `long:"timeout" description:"how long to wait for each pair's source and destination" default:"30s"`
+}
+
+// inventoryColumns are the columns of the CSV inventory, one row per target
+var inventoryColumns = []string{"pair", "side", "ip", "port", "health", "health_reason", "meta", "error"}
+
+// runInventory writes the current source and destination targets of every
+// sync pair. Nothing is changed in the destinations.
+func runInventory(ctx context.Context, cfg *targetsync.Config) error {
+	pairs := cfg.SyncPairs()
+	inventories := make([]*targetsync.Inventory, len(pairs))
+	for i, pairCfg := range pairs {
+		name := pairCfg.PairName()
+		inventories[i] = &targetsync.Inventory{Name: name, Time: time.Now()}
+		syncer, err := newSyncer(pairCfg, nil)
+		if err != nil {
+			logrus.Errorf("Error creating syncer %s: %v", name, err)
+			inventories[i].Error = err.Error()
+			continue
+		}
+		pairCtx, cancel := context.WithTimeout(ctx, inventoryOpts.Timeout)
+		inventory, err := syncer.Inventory(pairCtx)
+		cancel()
+		if err != nil {
+			logrus.Errorf("Error taking inventory of %s: %v", name, err)
+			inventories[i].Error = err.Error()
+			continue
+		}
+		logrus.Infof("Inventory of %s: %d source and %d destination targets", name, len(inventory.Source), len(inventory.Destination))
+		inventories[i] = inventory
+	}
+
+	w := io.Writer(os.Stdout)
+	if inventoryOpts.Output != "" {
+		f, err := os.Create(inventoryOpts.Output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	if inventoryOpts.Format == "csv" {
+		return writeInventoryCSV(w, inventories)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(inventories)
+}
+
+// writeInventoryCSV writes the inventories as CSV, with a row per target and
+// a row for each pair which failed
+func writeInventoryCSV(w io.Writer, inventories []*targetsync.Inventory) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(inventoryColumns); err != nil {
+		return err
+	}
+	for _, inventory := range inventories {
+		if inventory.Error != "" {
+			if err := cw.Write([]string{inventory.Name, "", "", "", "", "", "", inventory.Error}); err != nil {
+				return err
+			}
+			continue
+		}
+		for _, side := range []struct {
+			name    string
+			targets []*targetsync.Target
+		}{
+			{"source", inventory.Source},
+			{"destination", inventory.Destination},
+		} {
+			for _, target := range side.targets {
+				var health, reason string
+				if target.Health != nil {
+					health, reason = target.Health.State, target.Health.Reason
+				}
+				row := []string{inventory.Name, side.name, target.IP, strconv.Itoa(target.Port), health, reason, formatMeta(target.Meta), ""}
+				if err := cw.Write(row); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// formatMeta formats the meta as sorted `key=value` pairs separated by `;`
+func formatMeta(meta map[string]string) string {
+	pairs := make([]string, 0, len(meta))
+	for k, v := range meta {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", k, v))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ";")
+}
@@ -0,0 +1,18 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"fmt"
+	"net"
+)
+
+// listen listens on the TCP address, SO_REUSEPORT (for `--handoff-socket`)
+// isn't supported on Windows
+func listen(addr string, reusePort bool) (net.Listener, error) {
+	if reusePort {
+		return nil, fmt.Errorf("--handoff-socket isn't supported on Windows")
+	}
+	return net.Listen("tcp", addr)
+}
@@ -2,25 +2,51 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"fmt"
 	"net"
 	"net/http"
 	"os"
+	"sync"
 
 	flags "github.com/jessevdk/go-flags"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sirupsen/logrus"
 
 	"github.com/wish/targetsync"
 )
 
 var opts struct {
-	ConfigFile string `short:"c" long:"config" env:"CONFIG_FILE" description:"path to the config file" required:"true"`
-	LogLevel   string `long:"log-level" env:"LOG_LEVEL" description:"Log level" default:"info"`
-	BindAddr   string `long:"bind-address" env:"BIND_ADDRESS" description:"address for binding checks to"`
-	LocalAddr  string `long:"local-address" env:"LOCAL_ADDRESS" description:"address of this process"`
+	ConfigFile string   `short:"c" long:"config" env:"CONFIG_FILE" description:"path to the config file, a directory of config files or an s3://bucket/key URL" required:"true"`
+	LogLevel   string   `long:"log-level" env:"LOG_LEVEL" description:"Log level" default:"info"`
+	BindAddr   []string `long:"bind-address" env:"BIND_ADDRESS" env-delim:"," description:"address for binding checks to, may be repeated"`
+	LocalAddr  string   `long:"local-address" env:"LOCAL_ADDRESS" description:"address of this process"`
+	Force      bool     `long:"force" env:"FORCE" description:"take over destinations owned by another sync pair or deployment"`
+
+	HandoffSocket string `long:"handoff-socket" env:"HANDOFF_SOCKET" description:"unix socket for handing the sync pairs over between processes on upgrades, the bind addresses are bound with SO_REUSEPORT"`
+
+	TLSBindAddr     []string `long:"tls-bind-address" env:"TLS_BIND_ADDRESS" env-delim:"," description:"address for serving checks over TLS, may be repeated"`
+	TLSCertFile     string   `long:"tls-cert-file" env:"TLS_CERT_FILE" description:"TLS certificate, reloaded when modified"`
+	TLSKeyFile      string   `long:"tls-key-file" env:"TLS_KEY_FILE" description:"TLS private key, reloaded when modified"`
+	TLSClientCAFile string   `long:"tls-client-ca-file" env:"TLS_CLIENT_CA_FILE" description:"CA for verifying client certificates, if set clients must present one (mTLS)"`
+	TLSClientIDs    []string `long:"tls-client-spiffe-id" env:"TLS_CLIENT_SPIFFE_IDS" env-delim:"," description:"SPIFFE ID client certificates must have, may be repeated"`
 }
 
 func main() {
 	parser := flags.NewParser(&opts, flags.Default)
+	parser.SubcommandsOptional = true
+	if _, err := parser.AddCommand("snapshot", "save or restore destination targets", "", &snapshotOpts); err != nil {
+		logrus.Fatalf("Error adding snapshot command: %v", err)
+	}
+	if _, err := parser.AddCommand("service", "install or uninstall the Windows service", "", &serviceOpts); err != nil {
+		logrus.Fatalf("Error adding service command: %v", err)
+	}
+	if _, err := parser.AddCommand("inventory", "print the targets of every source and destination", "Prints the current targets (with their metadata and destination health) of every sync pair's source and destination, as JSON or CSV", &inventoryOpts); err != nil {
+		logrus.Fatalf("Error adding inventory command: %v", err)
+	}
+	if _, err := parser.AddCommand("selftest", "run non-destructive checks of every backend", "Checks each sync pair's source and destination can be read and a scratch lock acquired, printing a pass/fail matrix. Exits non-zero if any check fails, nothing is changed in the destinations", &selfTestOpts); err != nil {
+		logrus.Fatalf("Error adding selftest command: %v", err)
+	}
 	if _, err := parser.Parse(); err != nil {
 		// If the error was from the parser, then we can simply return
 		// as Parse() prints the error already
@@ -53,52 +79,520 @@ func main() {
 		logrus.Fatalf("Unable to load config: %v", err)
 	}
 
-	var src targetsync.TargetSourceLocker
-	// Create syncer
-	if cfg.ConsulConfig.ServiceName != "" {
-		src, err = targetsync.NewConsulSource(&cfg.ConsulConfig)
-		if err != nil {
-			logrus.Fatalf("Error creating consul source: %v", err)
+	// Run the inventory command, instead of the daemon, if given
+	if parser.Active != nil && parser.Active.Name == "inventory" {
+		if err := runInventory(ctx, cfg); err != nil {
+			logrus.Fatalf("Error running inventory: %v", err)
+		}
+		return
+	}
+
+	// Run the selftest command, instead of the daemon, if given
+	if parser.Active != nil && parser.Active.Name == "selftest" {
+		if err := runSelfTest(ctx, cfg); err != nil {
+			logrus.Fatalf("Self test failed: %v", err)
+		}
+		return
+	}
+
+	// Run the snapshot or service command, instead of the daemon, if given
+	if parser.Active != nil && parser.Active.Active != nil {
+		switch parser.Active.Active.Name {
+		case "save":
+			err = saveSnapshot(ctx, cfg, snapshotOpts.Save.File)
+		case "restore":
+			err = restoreSnapshot(ctx, cfg, snapshotOpts.Restore.File, snapshotOpts.Restore.AddOnly)
+		case "install":
+			err = installService(serviceOpts.Install.Name, serviceArgs())
+		case "uninstall":
+			err = uninstallService(serviceOpts.Uninstall.Name)
 		}
-	} else {
-		src, err = targetsync.NewK8sEndpointsSource(&cfg.K8sEndpointsConfig)
 		if err != nil {
-			logrus.Fatalf("Error creating k8s endpoints source: %v", err)
+			logrus.Fatalf("Error running %s %s: %v", parser.Active.Name, parser.Active.Active.Name, err)
 		}
+		return
 	}
 
-	dst, err := targetsync.NewAWSTargetGroup(&cfg.AWSConfig)
+	// When started by the Windows service manager, run as a service until it
+	// is stopped
+	isService, err := runService(serviceName, func(ctx context.Context) {
+		runDaemon(ctx, cfg)
+	})
 	if err != nil {
-		logrus.Fatalf("Error creating aws dest: %v", err)
+		logrus.Fatalf("Error running service: %v", err)
+	}
+	if isService {
+		return
 	}
 
-	syncer := &targetsync.Syncer{
-		Config:    &cfg.SyncConfig,
-		LocalAddr: opts.LocalAddr,
-		Locker:    src,
-		Src:       src,
-		Dst:       dst,
+	cancelOnSignal(cancel)
+	runDaemon(ctx, cfg)
+}
+
+// runDaemon runs the syncers until the context is done, or they are handed
+// off to a new process
+func runDaemon(ctx context.Context, cfg *targetsync.Config) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var pool *targetsync.WorkerPool
+	if cfg.WorkerPoolSize > 0 {
+		pool = targetsync.NewWorkerPool(cfg.WorkerPoolSize)
 	}
 
-	if opts.BindAddr != "" {
-		l, err := net.Listen("tcp", opts.BindAddr)
+	var events targetsync.EventSink
+	if len(cfg.EventsConfig.Kafka.Brokers) > 0 {
+		kafkaSink, err := targetsync.NewKafkaEventSink(&cfg.EventsConfig.Kafka)
 		if err != nil {
-			logrus.Fatalf("Error binding: %v", err)
+			logrus.Fatalf("Error creating kafka event sink: %v", err)
 		}
+		defer kafkaSink.Close()
+		events = kafkaSink
+	}
+	if len(cfg.EventsConfig.Alertmanager.URLs) > 0 {
+		alertSink := targetsync.NewAlertmanagerEventSink(&cfg.EventsConfig.Alertmanager, events)
+		go alertSink.Run(ctx)
+		events = alertSink
+	}
+	var history *targetsync.History
+	if cfg.History.Path != "" {
+		var err error
+		history, err = targetsync.NewHistory(&cfg.History, events)
+		if err != nil {
+			logrus.Fatalf("Error creating history: %v", err)
+		}
+		go history.Run(ctx)
+		events = history
+	}
+	eventStream := targetsync.NewEventStream(events)
+	events = eventStream
 
-		go func() {
-			http.HandleFunc("/ready", func(w http.ResponseWriter, r *http.Request) {
-				logrus.Infof("ready? %v", syncer.Started)
-				if !syncer.Started {
+	var state targetsync.StateStore
+	if cfg.State.URL != "" {
+		s3State, err := targetsync.NewS3StateStore(&cfg.State)
+		if err != nil {
+			logrus.Fatalf("Error creating state store: %v", err)
+		}
+		state = s3State
+	}
+	// With a handoff socket the syncers start with the state handed off by
+	// the previous process, if any
+	var handoff *targetsync.HandoffStateStore
+	if opts.HandoffSocket != "" {
+		handoff = targetsync.NewHandoffStateStore(state)
+		state = handoff
+	}
+
+	pairs := cfg.SyncPairs()
+	// The syncers' reconciles share a work queue, by default with a worker
+	// per pair
+	queueCfg := cfg.WorkQueue
+	if queueCfg.Workers == 0 {
+		queueCfg.Workers = len(pairs)
+	}
+	queue := targetsync.NewWorkQueue(&queueCfg)
+	go queue.Run(ctx)
+
+	syncers := make([]*targetsync.Syncer, len(pairs))
+	for i, pairCfg := range pairs {
+		syncer, err := newSyncer(pairCfg, events)
+		if err != nil {
+			logrus.Fatalf("Error creating syncer %s: %v", pairCfg.PairName(), err)
+		}
+		syncer.Pool = pool
+		syncer.Queue = queue
+		syncer.StateStore = state
+		syncers[i] = syncer
+	}
+
+	// allSyncers returns the syncers of the pairs defined and of those
+	// started by the k8s controller
+	allSyncers := func() []*targetsync.Syncer { return syncers }
+	var controller *targetsync.K8sServiceController
+	if cfg.K8sController.Enabled {
+		var err error
+		controller, err = targetsync.NewK8sServiceController(&cfg.K8sController, func(pairCfg *targetsync.PairConfig) (*targetsync.Syncer, error) {
+			syncer, err := newSyncer(pairCfg, events)
+			if err != nil {
+				return nil, err
+			}
+			syncer.Pool = pool
+			syncer.Queue = queue
+			syncer.StateStore = state
+			return syncer, nil
+		})
+		if err != nil {
+			logrus.Fatalf("Error creating k8s controller: %v", err)
+		}
+		allSyncers = func() []*targetsync.Syncer {
+			return append(append([]*targetsync.Syncer{}, syncers...), controller.Syncers()...)
+		}
+	}
+
+	listeners := make([]net.Listener, 0, len(opts.BindAddr)+len(opts.TLSBindAddr))
+	reusePort := opts.HandoffSocket != ""
+	for _, addr := range opts.BindAddr {
+		l, err := listen(addr, reusePort)
+		if err != nil {
+			logrus.Fatalf("Error binding %s: %v", addr, err)
+		}
+		listeners = append(listeners, l)
+	}
+	if len(opts.TLSBindAddr) > 0 {
+		if opts.TLSCertFile == "" || opts.TLSKeyFile == "" {
+			logrus.Fatalf("--tls-cert-file and --tls-key-file must be set to use --tls-bind-address")
+		}
+		tlsCfg, err := targetsync.NewServerTLSConfig(&targetsync.TLSConfig{
+			CAFile:    opts.TLSClientCAFile,
+			CertFile:  opts.TLSCertFile,
+			KeyFile:   opts.TLSKeyFile,
+			SPIFFEIDs: opts.TLSClientIDs,
+		})
+		if err != nil {
+			logrus.Fatalf("Error loading TLS config: %v", err)
+		}
+		for _, addr := range opts.TLSBindAddr {
+			l, err := listen(addr, reusePort)
+			if err != nil {
+				logrus.Fatalf("Error binding %s: %v", addr, err)
+			}
+			listeners = append(listeners, tls.NewListener(l, tlsCfg))
+		}
+	}
+
+	if len(listeners) > 0 {
+		http.Handle("/metrics", promhttp.Handler())
+		http.HandleFunc("/ready", func(w http.ResponseWriter, r *http.Request) {
+			for _, syncer := range allSyncers() {
+				if !syncer.Status().IsReady() {
+					logrus.Infof("ready? false")
 					http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+					return
 				}
-			})
-			logrus.Error(http.Serve(l, http.DefaultServeMux))
+			}
+			logrus.Infof("ready? true")
+		})
+		api := targetsync.NewDynamicAPIHandler(allSyncers)
+		http.Handle(targetsync.APIPrefix+"/", api)
+		http.Handle(targetsync.APIPrefix+"/events/stream", eventStream)
+		if history != nil {
+			http.Handle(targetsync.APIPrefix+"/history", history)
+		}
+		for _, syncer := range syncers {
+			if push, ok := syncer.Src.(*targetsync.PushSource); ok {
+				http.Handle(targetsync.APIPrefix+"/register/"+syncer.Name, push)
+			}
+		}
+		// unversioned status and diff routes, kept for compatibility
+		legacyStatus := func(w http.ResponseWriter, r *http.Request) {
+			r.URL.Path = targetsync.APIPrefix + r.URL.Path
+			api.ServeHTTP(w, r)
+		}
+		http.HandleFunc("/status", legacyStatus)
+		http.HandleFunc("/status/", legacyStatus)
+		http.HandleFunc("/diff", legacyStatus)
+		for _, l := range listeners {
+			go func(l net.Listener) {
+				logrus.Error(http.Serve(l, http.DefaultServeMux))
+			}(l)
+		}
+	}
+
+	go notifySystemd(ctx, syncers)
+
+	// Run, the syncers (and the controller's) are run until they are
+	// stopped or handed off
+	syncCtx, stopSyncers := context.WithCancel(ctx)
+	defer stopSyncers()
+	var wg, syncersWg sync.WaitGroup
+	if cfg.ConsulRegistration.Enabled {
+		// Waited on so the service is deregistered before exiting
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := targetsync.RunConsulRegistration(ctx, &cfg.ConsulRegistration, syncers); err != nil {
+				logrus.Errorf("Error registering in consul: %v", err)
+			}
 		}()
 	}
+	if controller != nil {
+		// Waited on so the controller's pairs are stopped before exiting
+		syncersWg.Add(1)
+		go func() {
+			defer syncersWg.Done()
+			controller.Run(syncCtx)
+		}()
+	}
+	for _, syncer := range syncers {
+		syncersWg.Add(1)
+		go func(syncer *targetsync.Syncer) {
+			defer syncersWg.Done()
+			if err := syncer.Run(syncCtx); err != nil {
+				logrus.Errorf("Error running targetSync: %v", err)
+			}
+		}(syncer)
+	}
+	if handoff != nil {
+		// Waited on so the handoff response is sent before exiting
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			server := &targetsync.HandoffServer{
+				Path:    opts.HandoffSocket,
+				Syncers: allSyncers,
+				Stop: func() {
+					stopSyncers()
+					syncersWg.Wait()
+				},
+			}
+			if runHandoff(ctx, handoff, syncers, server) {
+				logrus.Infof("Sync pairs handed off to the new process, exiting")
+				cancel()
+			}
+		}()
+	}
+	syncersWg.Wait()
+	wg.Wait()
+}
 
-	// Run
-	if err := syncer.Run(ctx); err != nil {
-		logrus.Errorf("Error running targetSync: %v", err)
+// sharedSources are the consul sources by subscription key, shared by all
+// pairs syncing the same service
+var sharedSources = make(map[string]*targetsync.SharedSource)
+
+// newSyncer creates the source, destination and Syncer for a sync pair
+func newSyncer(cfg *targetsync.PairConfig, events targetsync.EventSink) (*targetsync.Syncer, error) {
+	var err error
+	if cfg.SyncConfig.LockOptions.Identity == "" {
+		cfg.SyncConfig.LockOptions.Identity, err = os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("Unable to determine hostname for lock identity: %v", err)
+		}
+	}
+
+	if err := cfg.SyncConfig.ZoneAffinity.ResolveLocal(); err != nil {
+		return nil, fmt.Errorf("Unable to determine local zone: %v", err)
+	}
+	if err := cfg.SyncConfig.SelfExclusion.ResolveLocal(); err != nil {
+		return nil, fmt.Errorf("Unable to determine local IPs: %v", err)
+	}
+
+	var src targetsync.TargetSource
+	var locker targetsync.Locker
+	if cfg.FakeSourceConfig.Enabled {
+		fakeSrc := targetsync.NewFakeSource(&cfg.FakeSourceConfig)
+		src = fakeSrc
+		locker = fakeSrc
+	} else if cfg.ReplaySourceConfig.Enabled() {
+		replaySrc, err := targetsync.NewReplaySource(&cfg.ReplaySourceConfig)
+		if err != nil {
+			return nil, fmt.Errorf("Error creating replay source: %v", err)
+		}
+		src = replaySrc
+		locker = replaySrc
+	} else if len(cfg.ASGConfig.Names) > 0 || len(cfg.ASGConfig.Tags) > 0 || cfg.PushConfig.Enabled || cfg.PrometheusSDConfig.Enabled() {
+		if cfg.PushConfig.Enabled {
+			if len(opts.BindAddr) == 0 && len(opts.TLSBindAddr) == 0 {
+				return nil, fmt.Errorf("--bind-address or --tls-bind-address must be set to use the push source")
+			}
+			src = targetsync.NewPushSource(&cfg.PushConfig)
+		} else if cfg.PrometheusSDConfig.Enabled() {
+			src = targetsync.NewPrometheusSDSource(&cfg.PrometheusSDConfig)
+		} else {
+			src, err = targetsync.NewASGSource(&cfg.ASGConfig)
+			if err != nil {
+				return nil, fmt.Errorf("Error creating ASG source: %v", err)
+			}
+		}
+		// These sources can't lock, so consul is used for locking
+		consulLocker, err := targetsync.NewConsulSource(&cfg.ConsulConfig)
+		if err != nil {
+			return nil, fmt.Errorf("Error creating consul locker: %v", err)
+		}
+		consulLocker.Events = events
+		locker = consulLocker
+	} else if cfg.ConsulConfig.HasServices() {
+		consulSrc, err := targetsync.NewConsulSource(&cfg.ConsulConfig)
+		if err != nil {
+			return nil, fmt.Errorf("Error creating consul source: %v", err)
+		}
+		consulSrc.Events = events
+		// Pairs syncing the same service share a single watch of it
+		key := cfg.ConsulConfig.SubscriptionKey()
+		shared, ok := sharedSources[key]
+		if !ok {
+			shared = targetsync.NewSharedSource(consulSrc)
+			sharedSources[key] = shared
+		}
+		src = shared
+		locker = consulSrc
+	} else {
+		k8sSrc, err := targetsync.NewK8sEndpointsSource(&cfg.K8sEndpointsConfig)
+		if err != nil {
+			return nil, fmt.Errorf("Error creating k8s endpoints source: %v", err)
+		}
+		src = k8sSrc
+		locker = k8sSrc
+	}
+	if len(cfg.LockQuorum) > 0 {
+		lockers := []targetsync.Locker{locker}
+		for i, backendCfg := range cfg.LockQuorum {
+			backend, err := targetsync.NewLockBackend(backendCfg)
+			if err != nil {
+				return nil, fmt.Errorf("Error creating lock quorum backend %d: %v", i, err)
+			}
+			lockers = append(lockers, backend)
+		}
+		locker = targetsync.NewQuorumLocker(lockers)
+	}
+	if cfg.Record.Path != "" {
+		src, err = targetsync.NewRecordingSource(src, cfg.Record.Path)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	dst, err := newDestination(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	syncer := &targetsync.Syncer{
+		Name:        cfg.PairName(),
+		Destination: cfg.Destination(),
+		Config:      &cfg.SyncConfig,
+		LocalAddr:   opts.LocalAddr,
+		Locker:      locker,
+		Src:         src,
+		Dst:         dst,
+		Events:      events,
+	}
+	if opts.Force {
+		cfg.SyncConfig.Ownership.Force = true
+	}
+	if cfg.SyncConfig.Ownership.Enabled && cfg.SyncConfig.Ownership.ConsulKey != "" {
+		syncer.Ownership, err = targetsync.NewConsulOwnershipMarker(&cfg.ConsulConfig, cfg.SyncConfig.Ownership.ConsulKey)
+		if err != nil {
+			return nil, fmt.Errorf("Error creating consul ownership marker: %v", err)
+		}
+	}
+	if cfg.TriggerConfig.QueueURL != "" {
+		trigger, err := targetsync.NewSQSTrigger(&cfg.TriggerConfig)
+		if err != nil {
+			return nil, fmt.Errorf("Error creating reconcile trigger: %v", err)
+		}
+		syncer.Trigger = trigger
+	}
+	return syncer, nil
+}
+
+// newDestination creates the destination for a sync pair
+// traefikPaths are the paths already serving a traefik destination's config
+var traefikPaths = struct {
+	sync.Mutex
+	paths map[string]struct{}
+}{paths: make(map[string]struct{})}
+
+// handleTraefikPath serves the traefik destination's config on the path,
+// which mustn't already be served (registering it again would panic)
+func handleTraefikPath(path string, handler http.Handler) error {
+	traefikPaths.Lock()
+	defer traefikPaths.Unlock()
+	if _, ok := traefikPaths.paths[path]; ok {
+		return fmt.Errorf("Traefik http_path %s is already used by another pair", path)
+	}
+	traefikPaths.paths[path] = struct{}{}
+	http.Handle(path, handler)
+	return nil
+}
+
+func newDestination(cfg *targetsync.PairConfig) (targetsync.TargetDestination, error) {
+	var dst targetsync.TargetDestination
+	var err error
+	if len(cfg.Chain) > 0 {
+		members := make([]targetsync.ChainMember, len(cfg.Chain))
+		for i, memberCfg := range cfg.Chain {
+			memberDst, err := newDestination(memberCfg)
+			if err != nil {
+				return nil, fmt.Errorf("Error creating chained dest %d: %v", i, err)
+			}
+			members[i] = targetsync.ChainMember{Name: memberCfg.Destination(), Dst: memberDst}
+		}
+		dst, err = targetsync.NewChainDestination(members)
+		if err != nil {
+			return nil, fmt.Errorf("Error creating chain dest: %v", err)
+		}
+	} else if cfg.FakeDestinationConfig.Enabled {
+		dst = targetsync.NewFakeDestination(&cfg.FakeDestinationConfig)
+	} else if cfg.TraefikConfig.ServiceName != "" {
+		traefikDst, err := targetsync.NewTraefikDestination(&cfg.TraefikConfig)
+		if err != nil {
+			return nil, fmt.Errorf("Error creating traefik dest: %v", err)
+		}
+		if cfg.TraefikConfig.HTTPPath != "" {
+			if err := handleTraefikPath(cfg.TraefikConfig.HTTPPath, traefikDst); err != nil {
+				return nil, err
+			}
+		}
+		dst = traefikDst
+	} else if cfg.ConsulDestinationConfig.ServiceName != "" {
+		dst, err = targetsync.NewConsulDestination(&cfg.ConsulDestinationConfig)
+		if err != nil {
+			return nil, fmt.Errorf("Error creating consul dest: %v", err)
+		}
+	} else if cfg.K8sServiceEntryConfig.Name != "" {
+		dst, err = targetsync.NewK8sServiceEntryDestination(&cfg.K8sServiceEntryConfig)
+		if err != nil {
+			return nil, fmt.Errorf("Error creating k8s service entry dest: %v", err)
+		}
+	} else if cfg.GCEConfig.InstanceGroup != "" {
+		dst, err = targetsync.NewGCEInstanceGroup(&cfg.GCEConfig)
+		if err != nil {
+			return nil, fmt.Errorf("Error creating gce dest: %v", err)
+		}
+	} else if cfg.LinodeConfig.NodeBalancerID != 0 {
+		dst, err = targetsync.NewLinodeNodeBalancer(&cfg.LinodeConfig)
+		if err != nil {
+			return nil, fmt.Errorf("Error creating linode dest: %v", err)
+		}
+	} else if cfg.HetznerConfig.LoadBalancerID != 0 {
+		dst, err = targetsync.NewHetznerLoadBalancer(&cfg.HetznerConfig)
+		if err != nil {
+			return nil, fmt.Errorf("Error creating hetzner dest: %v", err)
+		}
+	} else if cfg.ScalewayConfig.BackendID != "" {
+		dst, err = targetsync.NewScalewayBackend(&cfg.ScalewayConfig)
+		if err != nil {
+			return nil, fmt.Errorf("Error creating scaleway dest: %v", err)
+		}
+	} else if cfg.OVHConfig.ServiceName != "" {
+		dst, err = targetsync.NewOVHFarm(&cfg.OVHConfig)
+		if err != nil {
+			return nil, fmt.Errorf("Error creating ovh dest: %v", err)
+		}
+	} else if cfg.OctaviaConfig.PoolID != "" {
+		dst, err = targetsync.NewOctaviaPool(&cfg.OctaviaConfig)
+		if err != nil {
+			return nil, fmt.Errorf("Error creating octavia dest: %v", err)
+		}
+	} else if cfg.RFC2136Config.Server != "" {
+		dst, err = targetsync.NewRFC2136Destination(&cfg.RFC2136Config)
+		if err != nil {
+			return nil, fmt.Errorf("Error creating rfc2136 dest: %v", err)
+		}
+	} else if cfg.GlobalAcceleratorConfig.EndpointGroupARN != "" {
+		dst, err = targetsync.NewGlobalAcceleratorEndpointGroup(&cfg.GlobalAcceleratorConfig)
+		if err != nil {
+			return nil, fmt.Errorf("Error creating global accelerator dest: %v", err)
+		}
+	} else {
+		if len(cfg.AWSConfig.Regions) > 0 {
+			dst, err = targetsync.NewAWSMultiRegionTargetGroup(&cfg.AWSConfig)
+		} else {
+			dst, err = targetsync.NewAWSTargetGroup(&cfg.AWSConfig)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Error creating aws dest: %v", err)
+		}
 	}
+	return dst, nil
 }
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/wish/targetsync"
+)
+
+var selfTestOpts struct {
+	Format  string        `long:"format" description:"output format" choice:"table" choice:"json" default:"table"`
+	Timeout time.Duration `long:"timeout" description:"how long to wait for each pair's checks" default:"30s"`
+}
+
+// selfTestChecks are the columns of the self test table
+var selfTestChecks = []string{targetsync.SelfTestSource, targetsync.SelfTestDestination, targetsync.SelfTestLock}
+
+// runSelfTest runs the self test of every sync pair, writing the results to
+// stdout. An error is returned if any pair failed.
+func runSelfTest(ctx context.Context, cfg *targetsync.Config) error {
+	pairs := cfg.SyncPairs()
+	results := make([]*targetsync.SelfTestResult, len(pairs))
+	failed := 0
+	for i, pairCfg := range pairs {
+		name := pairCfg.PairName()
+		syncer, err := newSyncer(pairCfg, nil)
+		if err != nil {
+			// The backends couldn't be created, e.g. invalid credentials
+			results[i] = &targetsync.SelfTestResult{
+				Name:   name,
+				Checks: []targetsync.SelfTestCheck{{Name: "create", Error: err.Error()}},
+			}
+			failed++
+			continue
+		}
+		pairCtx, cancel := context.WithTimeout(ctx, selfTestOpts.Timeout)
+		results[i] = syncer.SelfTest(pairCtx)
+		cancel()
+		if !results[i].Passed {
+			failed++
+		}
+		logrus.Debugf("Self test of %s passed: %v", name, results[i].Passed)
+	}
+
+	var err error
+	if selfTestOpts.Format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(results)
+	} else {
+		err = writeSelfTestTable(os.Stdout, results)
+	}
+	if err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d sync pairs failed", failed, len(pairs))
+	}
+	return nil
+}
+
+// writeSelfTestTable writes a row per pair with the result of each check,
+// followed by the errors of the failed checks
+func writeSelfTestTable(w io.Writer, results []*targetsync.SelfTestResult) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprint(tw, "PAIR")
+	for _, check := range selfTestChecks {
+		fmt.Fprintf(tw, "\t%s", check)
+	}
+	fmt.Fprintln(tw)
+
+	var errors []string
+	for _, result := range results {
+		fmt.Fprint(tw, result.Name)
+		checks := make(map[string]targetsync.SelfTestCheck, len(result.Checks))
+		for _, check := range result.Checks {
+			checks[check.Name] = check
+			if !check.Passed() {
+				errors = append(errors, fmt.Sprintf("%s %s: %s", result.Name, check.Name, check.Error))
+			}
+		}
+		for _, name := range selfTestChecks {
+			check, ok := checks[name]
+			switch {
+			case !ok:
+				fmt.Fprint(tw, "\t-")
+			case check.Skipped:
+				fmt.Fprint(tw, "\tskip")
+			case check.Passed():
+				fmt.Fprint(tw, "\tpass")
+			default:
+				fmt.Fprint(tw, "\tFAIL")
+			}
+		}
+		fmt.Fprintln(tw)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if len(errors) > 0 {
+		fmt.Fprintln(w)
+		for _, e := range errors {
+			fmt.Fprintln(w, e)
+		}
+	}
+	return nil
+}
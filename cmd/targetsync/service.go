@@ -0,0 +1,48 @@
+package main
+
+import "path/filepath"
+
+// serviceName is the default name of the Windows service
+const serviceName = "targetsync"
+
+var serviceOpts struct {
+	Install struct {
+		Name string `short:"n" long:"name" description:"name of the service" default:"targetsync"`
+	} `command:"install" description:"install the Windows service, run with the given options"`
+	Uninstall struct {
+		Name string `short:"n" long:"name" description:"name of the service" default:"targetsync"`
+	} `command:"uninstall" description:"uninstall the Windows service"`
+}
+
+// serviceArgs returns the options the installed service is run with, those
+// given when installing it
+func serviceArgs() []string {
+	config, err := filepath.Abs(opts.ConfigFile)
+	if err != nil {
+		config = opts.ConfigFile
+	}
+	args := []string{"--config", config, "--log-level", opts.LogLevel}
+	for _, addr := range opts.BindAddr {
+		args = append(args, "--bind-address", addr)
+	}
+	for _, addr := range opts.TLSBindAddr {
+		args = append(args, "--tls-bind-address", addr)
+	}
+	for _, opt := range []struct{ flag, value string }{
+		{"--local-address", opts.LocalAddr},
+		{"--tls-cert-file", opts.TLSCertFile},
+		{"--tls-key-file", opts.TLSKeyFile},
+		{"--tls-client-ca-file", opts.TLSClientCAFile},
+	} {
+		if opt.value != "" {
+			args = append(args, opt.flag, opt.value)
+		}
+	}
+	for _, id := range opts.TLSClientIDs {
+		args = append(args, "--tls-client-spiffe-id", id)
+	}
+	if opts.Force {
+		args = append(args, "--force")
+	}
+	return args
+}
@@ -0,0 +1,25 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+// runService is a no-op outside of Windows, the daemon is never run as a
+// Windows service
+func runService(name string, run func(context.Context)) (bool, error) {
+	return false, nil
+}
+
+// installService is only supported on Windows
+func installService(name string, args []string) error {
+	return fmt.Errorf("Services can only be installed on Windows, use systemd (see README)")
+}
+
+// uninstallService is only supported on Windows
+func uninstallService(name string) error {
+	return fmt.Errorf("Services can only be uninstalled on Windows")
+}
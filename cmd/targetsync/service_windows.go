@@ -0,0 +1,161 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/eventlog"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// runService runs the daemon as the Windows service `name` if the process was
+// started by the service manager, returning whether it was. Logs are also
+// written to the event log.
+func runService(name string, run func(context.Context)) (bool, error) {
+	interactive, err := svc.IsAnInteractiveSession()
+	if err != nil {
+		return false, err
+	}
+	if interactive {
+		return false, nil
+	}
+
+	elog, err := eventlog.Open(name)
+	if err != nil {
+		return true, err
+	}
+	defer elog.Close()
+	logrus.AddHook(&eventLogHook{log: elog})
+
+	return true, svc.Run(name, &service{run: run})
+}
+
+// service is the `svc.Handler` running the daemon until the service is
+// stopped
+type service struct {
+	run func(context.Context)
+}
+
+// Execute to implement the `svc.Handler` interface
+func (s *service) Execute(args []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.run(ctx)
+	}()
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+
+	for {
+		select {
+		case <-done:
+			// The daemon stopped on its own
+			return false, 1
+		case req := <-requests:
+			switch req.Cmd {
+			case svc.Interrogate:
+				status <- req.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				logrus.Infof("Service stop requested, shutting down")
+				status <- svc.Status{State: svc.StopPending}
+				cancel()
+				<-done
+				return false, 0
+			}
+		}
+	}
+}
+
+// eventLogHook is a logrus hook writing log entries to the event log
+type eventLogHook struct {
+	log *eventlog.Log
+}
+
+// Levels to implement the `logrus.Hook` interface, debug logs aren't written
+// to the event log
+func (h *eventLogHook) Levels() []logrus.Level {
+	return []logrus.Level{logrus.PanicLevel, logrus.FatalLevel, logrus.ErrorLevel, logrus.WarnLevel, logrus.InfoLevel}
+}
+
+// Fire to implement the `logrus.Hook` interface
+func (h *eventLogHook) Fire(entry *logrus.Entry) error {
+	msg, err := entry.String()
+	if err != nil {
+		return err
+	}
+	switch entry.Level {
+	case logrus.PanicLevel, logrus.FatalLevel, logrus.ErrorLevel:
+		return h.log.Error(1, msg)
+	case logrus.WarnLevel:
+		return h.log.Warning(1, msg)
+	default:
+		return h.log.Info(1, msg)
+	}
+}
+
+// installService installs the Windows service `name`, starting automatically
+// with the args, and registers it as an event log source
+func installService(name string, args []string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+
+	if s, err := m.OpenService(name); err == nil {
+		s.Close()
+		return fmt.Errorf("Service %s already exists", name)
+	}
+	s, err := m.CreateService(name, exe, mgr.Config{
+		DisplayName: name,
+		Description: "Syncs targets from a source to a destination",
+		StartType:   mgr.StartAutomatic,
+	}, args...)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	if err := eventlog.InstallAsEventCreate(name, eventlog.Error|eventlog.Warning|eventlog.Info); err != nil {
+		s.Delete()
+		return fmt.Errorf("Error installing event log source: %v", err)
+	}
+	logrus.Infof("Installed service %s running %s %v", name, exe, args)
+	return nil
+}
+
+// uninstallService removes the Windows service `name` and its event log
+// source
+func uninstallService(name string) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(name)
+	if err != nil {
+		return fmt.Errorf("Service %s is not installed: %v", name, err)
+	}
+	defer s.Close()
+	if err := s.Delete(); err != nil {
+		return err
+	}
+	if err := eventlog.Remove(name); err != nil {
+		return fmt.Errorf("Error removing event log source: %v", err)
+	}
+	logrus.Infof("Uninstalled service %s", name)
+	return nil
+}
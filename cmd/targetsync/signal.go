@@ -0,0 +1,30 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/sirupsen/logrus"
+)
+
+// shutdownSignals stop the daemon gracefully. On Windows only os.Interrupt
+// (ctrl-c and ctrl-break) is delivered, services are stopped by the service
+// manager instead (see `runService`).
+var shutdownSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+
+// cancelOnSignal cancels the context on the first shutdown signal, so the
+// syncers stop gracefully (releasing their locks). A second signal exits
+// immediately.
+func cancelOnSignal(cancel context.CancelFunc) {
+	ch := make(chan os.Signal, 2)
+	signal.Notify(ch, shutdownSignals...)
+	go func() {
+		sig := <-ch
+		logrus.Infof("Received %v, shutting down", sig)
+		cancel()
+		sig = <-ch
+		logrus.Fatalf("Received %v again, exiting", sig)
+	}()
+}
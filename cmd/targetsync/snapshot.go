@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/wish/targetsync"
+)
+
+var snapshotOpts struct {
+	Save struct {
+		File string `short:"f" long:"file" description:"file to save the snapshot to" required:"true"`
+	} `command:"save" description:"save the targets of every destination to a file"`
+	Restore struct {
+		File    string `short:"f" long:"file" description:"file to restore the snapshot from" required:"true"`
+		AddOnly bool   `long:"add-only" description:"only add missing targets, don't remove targets not in the snapshot"`
+	} `command:"restore" description:"restore the targets of every destination from a file"`
+}
+
+// saveSnapshot saves the targets of the destination of each sync pair to `path`
+func saveSnapshot(ctx context.Context, cfg *targetsync.Config, path string) error {
+	pairs := cfg.SyncPairs()
+	snapshots := make([]*targetsync.DestinationSnapshot, len(pairs))
+	for i, pairCfg := range pairs {
+		dst, err := newDestination(pairCfg)
+		if err != nil {
+			return err
+		}
+		key := pairCfg.SyncConfig.LockOptions.Key
+		snapshots[i], err = targetsync.TakeSnapshot(ctx, key, dst)
+		if err != nil {
+			return fmt.Errorf("Error getting targets for %s: %v", key, err)
+		}
+		logrus.Infof("Saved %d targets for %s", len(snapshots[i].Targets), key)
+	}
+
+	b, err := json.MarshalIndent(snapshots, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, b, 0644)
+}
+
+// restoreSnapshot restores the targets of the destination of each sync pair
+// from `path`. Syncers should be stopped first, otherwise they will simply
+// sync the destination back to the source.
+func restoreSnapshot(ctx context.Context, cfg *targetsync.Config, path string, addOnly bool) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var snapshots []*targetsync.DestinationSnapshot
+	if err := json.Unmarshal(b, &snapshots); err != nil {
+		return fmt.Errorf("Error loading snapshot: %v", err)
+	}
+	snapshotMap := make(map[string]*targetsync.DestinationSnapshot, len(snapshots))
+	for _, snapshot := range snapshots {
+		snapshotMap[snapshot.Key] = snapshot
+	}
+
+	for _, pairCfg := range cfg.SyncPairs() {
+		key := pairCfg.SyncConfig.LockOptions.Key
+		snapshot, ok := snapshotMap[key]
+		if !ok {
+			logrus.Warnf("No snapshot for %s, skipping", key)
+			continue
+		}
+		dst, err := newDestination(pairCfg)
+		if err != nil {
+			return err
+		}
+		added, removed, err := targetsync.RestoreSnapshot(ctx, dst, snapshot, addOnly)
+		if err != nil {
+			return fmt.Errorf("Error restoring targets for %s: %v", key, err)
+		}
+		logrus.Infof("Restored snapshot from %v for %s: added %d removed %d targets", snapshot.Time, key, len(added), len(removed))
+	}
+	return nil
+}
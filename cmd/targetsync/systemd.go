@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/coreos/go-systemd/daemon"
+	"github.com/sirupsen/logrus"
+
+	"github.com/wish/targetsync"
+)
+
+// notifySystemd signals systemd (for Type=notify units) once all syncers are
+// ready, and then sends watchdog heartbeats while all syncers are alive. This
+// is a no-op when not run under systemd.
+func notifySystemd(ctx context.Context, syncers []*targetsync.Syncer) {
+	for _, syncer := range syncers {
+		select {
+		case <-ctx.Done():
+			return
+		case <-syncer.Ready():
+		}
+	}
+	if ok, err := daemon.SdNotify(false, daemon.SdNotifyReady); err != nil {
+		logrus.Errorf("Error notifying systemd: %v", err)
+	} else if ok {
+		logrus.Infof("Notified systemd we are ready")
+	}
+
+	interval, err := daemon.SdWatchdogEnabled(false)
+	if err != nil {
+		logrus.Errorf("Error checking systemd watchdog: %v", err)
+		return
+	}
+	if interval == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			alive := true
+			for i, syncer := range syncers {
+				if !syncer.Alive(interval) {
+					logrus.Warnf("Syncer %d is not alive, skipping systemd watchdog", i)
+					alive = false
+				}
+			}
+			if alive {
+				if _, err := daemon.SdNotify(false, daemon.SdNotifyWatchdog); err != nil {
+					logrus.Errorf("Error sending systemd watchdog: %v", err)
+				}
+			}
+		}
+	}
+}
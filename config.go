@@ -1,63 +1,1415 @@
 package targetsync
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
 	consulApi "github.com/hashicorp/consul/api"
+	"github.com/scaleway/scaleway-sdk-go/scw"
 
 	yaml "gopkg.in/yaml.v2"
 )
 
-// ConfigFromFile Loads a config file from `path`
-func ConfigFromFile(path string) (*Config, error) {
-	// load the config file
-	cfg := &Config{
+// defaultPairConfig returns a PairConfig with the default options set
+func defaultPairConfig() PairConfig {
+	return PairConfig{
 		ConsulConfig: ConsulConfig{
-			ClientConfig: consulApi.DefaultConfig(),
+			ClientConfig:    consulApi.DefaultConfig(),
+			RetryBackoff:    time.Second,
+			MaxRetryBackoff: 30 * time.Second,
+		},
+		PushConfig: PushConfig{
+			DefaultTTL: 30 * time.Second,
 		},
+		TraefikConfig: TraefikConfig{
+			Protocol: TraefikProtocolHTTP,
+			Scheme:   "http",
+		},
+		K8sServiceEntryConfig: K8sServiceEntryConfig{
+			PortName: "http",
+			Protocol: "HTTP",
+			Location: "MESH_EXTERNAL",
+		},
+	}
+}
+
+// ConfigFromFile Loads a config file from `path`. If `path` is a directory all
+// of the yaml files within it are loaded as fragments and merged together, as
+// are the files matching the config file's `include` patterns. If
+// `path` is an S3 URL (`s3://bucket/key`) the object is loaded, with the
+// default AWS region and credentials.
+func ConfigFromFile(path string) (*Config, error) {
+	var cfg *Config
+	if isS3URL(path) {
+		b, err := getS3Object(context.Background(), path)
+		if err != nil {
+			return nil, fmt.Errorf("Error loading config: %v", err)
+		}
+		if cfg, err = parseConfig(path, b); err != nil {
+			return nil, err
+		}
+		if len(cfg.Include) > 0 {
+			return nil, wrapError(ErrConfigInvalid, fmt.Errorf("Includes aren't supported in configs loaded from S3"))
+		}
+	} else {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, fmt.Errorf("Error loading config: %v", err)
+		}
+		if info.IsDir() {
+			cfg, err = configFromDir(path)
+		} else if cfg, err = loadConfigFile(path); err == nil && len(cfg.Include) > 0 {
+			cfg, err = configWithIncludes(path, cfg)
+		}
+		if err != nil {
+			return nil, err
+		}
 	}
+
+	if err := cfg.expandPipelines(); err != nil {
+		return nil, wrapError(ErrConfigInvalid, err)
+	}
+	if err := cfg.resolveLockKeys(); err != nil {
+		return nil, wrapError(ErrConfigInvalid, err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, wrapError(ErrConfigInvalid, err)
+	}
+
+	return cfg, nil
+}
+
+// loadConfigFile loads a single config file without validating it
+func loadConfigFile(path string) (*Config, error) {
+	// load the config file
 	configBytes, err := ioutil.ReadFile(path)
 	if err != nil {
 		return nil, fmt.Errorf("Error loading config: %v", err)
 	}
-	err = yaml.Unmarshal([]byte(configBytes), &cfg)
+	return parseConfig(path, configBytes)
+}
+
+// parseConfig unmarshals the config loaded from `path`, without validating it
+func parseConfig(path string, configBytes []byte) (*Config, error) {
+	cfg := &Config{
+		PairConfig: defaultPairConfig(),
+	}
+	if err := yaml.Unmarshal(configBytes, &cfg); err != nil {
+		return nil, wrapError(ErrConfigInvalid, fmt.Errorf("Error unmarshaling config %s: %v", path, err))
+	}
+	return cfg, nil
+}
+
+// configFromDir loads all yaml files in `dir` (in lexical order) and merges
+// them into a single config. Each fragment contributes its sync pairs, global
+// options may only be set in a single fragment.
+func configFromDir(dir string) (*Config, error) {
+	files, err := ioutil.ReadDir(dir)
 	if err != nil {
-		return nil, fmt.Errorf("Error unmarshaling config: %v", err)
+		return nil, fmt.Errorf("Error loading config dir: %v", err)
 	}
 
-	if err := cfg.Validate(); err != nil {
+	m := &configMerger{merged: &Config{}}
+	for _, f := range files {
+		ext := filepath.Ext(f.Name())
+		if f.IsDir() || (ext != ".yaml" && ext != ".yml") {
+			continue
+		}
+		path := filepath.Join(dir, f.Name())
+		fragment, err := loadConfigFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if len(fragment.Include) > 0 {
+			return nil, wrapError(ErrConfigInvalid, fmt.Errorf("Includes are only supported in a config file, not in %s", path))
+		}
+		if err := m.merge(path, fragment, fragment.SyncPairs()); err != nil {
+			return nil, err
+		}
+	}
+
+	merged := m.merged
+	if len(merged.Pairs) == 0 && len(merged.Pipelines) == 0 && !merged.K8sController.Enabled {
+		return nil, wrapError(ErrConfigInvalid, fmt.Errorf("No config files found in %s", dir))
+	}
+	return merged, nil
+}
+
+// configWithIncludes merges the fragments matching the `Include` patterns of
+// the config loaded from `path` into it, as if they were all files of a
+// config directory. Relative patterns are relative to the directory of
+// `path`, and the matching fragments are merged in lexical order.
+func configWithIncludes(path string, cfg *Config) (*Config, error) {
+	m := &configMerger{merged: &Config{}}
+	// The including file's inline pair is only defined with a name or lock
+	// key, its globals and includes may be all it sets
+	if err := m.merge(path, cfg, cfg.definedPairs()); err != nil {
 		return nil, err
 	}
+	for _, pattern := range cfg.Include {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(filepath.Dir(path), pattern)
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, wrapError(ErrConfigInvalid, fmt.Errorf("Invalid include %q: %v", pattern, err))
+		}
+		if len(matches) == 0 {
+			return nil, wrapError(ErrConfigInvalid, fmt.Errorf("No config files match include %q", pattern))
+		}
+		for _, match := range matches {
+			fragment, err := loadConfigFile(match)
+			if err != nil {
+				return nil, err
+			}
+			if len(fragment.Include) > 0 {
+				return nil, wrapError(ErrConfigInvalid, fmt.Errorf("Nested includes aren't supported, %s is included by %s", match, path))
+			}
+			if err := m.merge(match, fragment, fragment.SyncPairs()); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return m.merged, nil
+}
 
-	return cfg, nil
+// configMerger merges config fragments into a single config
+type configMerger struct {
+	merged *Config
+	// globalsFrom is the fragment which set the global options, if any has
+	globalsFrom string
+}
+
+// merge merges the fragment loaded from `path` into the merged config, with
+// `pairs` being the sync pairs it defines if it has no pipelines
+func (m *configMerger) merge(path string, fragment *Config, pairs []*PairConfig) error {
+	merged := m.merged
+	if len(fragment.Pipelines) == 0 {
+		merged.Pairs = append(merged.Pairs, pairs...)
+	} else {
+		merged.Pairs = append(merged.Pairs, fragment.definedPairs()...)
+	}
+	merged.Pipelines = append(merged.Pipelines, fragment.Pipelines...)
+	for name, filter := range fragment.Filters {
+		if _, ok := merged.Filters[name]; ok {
+			return wrapError(ErrConfigInvalid, fmt.Errorf("Duplicate filter %q in %s", name, path))
+		}
+		if merged.Filters == nil {
+			merged.Filters = make(map[string]*FilterConfig)
+		}
+		merged.Filters[name] = filter
+	}
+
+	if fragment.hasGlobals() {
+		if m.globalsFrom != "" {
+			return wrapError(ErrConfigInvalid, fmt.Errorf("Global options set in both %s and %s", m.globalsFrom, path))
+		}
+		m.globalsFrom = path
+		merged.WorkerPoolSize = fragment.WorkerPoolSize
+		merged.WorkQueue = fragment.WorkQueue
+		merged.EventsConfig = fragment.EventsConfig
+		merged.ConsulRegistration = fragment.ConsulRegistration
+		merged.K8sController = fragment.K8sController
+		merged.State = fragment.State
+		merged.History = fragment.History
+	}
+	return nil
 }
 
 // Config for the targetsync
 type Config struct {
-	ConsulConfig       `yaml:"consul"`
-	AWSConfig          `yaml:"aws"`
-	K8sEndpointsConfig `yaml:"k8s_enpoints"`
+	// PairConfig defines a single sync pair inline, this is only used if no
+	// `Pairs` are defined
+	PairConfig `yaml:",inline"`
 
-	SyncConfig `yaml:"syncer"`
+	// Pairs defines multiple independent sync pairs
+	Pairs []*PairConfig `yaml:"pairs"`
+
+	// Pipelines sync a single source to many destinations, each is expanded
+	// into a sync pair per destination
+	Pipelines []*PipelineConfig `yaml:"pipelines"`
+	// Filters are the named filters which pipelines can reference
+	Filters map[string]*FilterConfig `yaml:"filters"`
+
+	// WorkerPoolSize limits the number of concurrent destination mutations
+	// across all syncers, 0 is unlimited
+	WorkerPoolSize int `yaml:"worker_pool_size"`
+
+	// WorkQueue configures the workers reconciling the sync pairs
+	WorkQueue WorkQueueConfig `yaml:"work_queue"`
+
+	// EventsConfig defines where events from all syncers are sent
+	EventsConfig `yaml:"events"`
+
+	// ConsulRegistration registers targetsync itself in consul
+	ConsulRegistration ConsulRegistrationConfig `yaml:"consul_registration"`
+
+	// K8sController runs a sync pair for each annotated k8s Service, in
+	// addition to the pairs defined
+	K8sController K8sControllerConfig `yaml:"k8s_controller"`
+
+	// State persists the runtime state of the pairs, e.g. in S3
+	State StateConfig `yaml:"state"`
+
+	// History keeps a local history of the pairs' membership changes
+	History HistoryConfig `yaml:"history"`
+
+	// Include are the paths (or glob patterns) of config fragments merged
+	// into the config file, relative to its directory. Only supported in a
+	// config file loaded from disk.
+	Include []string `yaml:"include"`
+}
+
+// hasGlobals returns whether any of the global (non sync pair) options are set
+func (c *Config) hasGlobals() bool {
+	return c.WorkerPoolSize != 0 || c.WorkQueue.isSet() || len(c.EventsConfig.Kafka.Brokers) > 0 || len(c.EventsConfig.Alertmanager.URLs) > 0 || c.ConsulRegistration.Enabled || c.K8sController.Enabled || c.State.URL != "" || c.History.Path != ""
+}
+
+// EventsConfig configures the EventSinks events are sent to, if none are
+// configured events are logged
+type EventsConfig struct {
+	Kafka KafkaConfig `yaml:"kafka"`
+	// Alertmanager fires alerts for persistent sync failures and the
+	// safety checks
+	Alertmanager AlertmanagerConfig `yaml:"alertmanager"`
+}
+
+// KafkaKeyScheme defines what the kafka messages are keyed by
+type KafkaKeyScheme string
+
+const (
+	// KafkaKeyNone sends messages without a key (spread across partitions)
+	KafkaKeyNone KafkaKeyScheme = "none"
+	// KafkaKeyEventType keys messages by the event type
+	KafkaKeyEventType KafkaKeyScheme = "event_type"
+	// KafkaKeyLockKey keys messages by the sync pair's lock key, this keeps
+	// the events of each sync pair in order
+	KafkaKeyLockKey KafkaKeyScheme = "lock_key"
+)
+
+// KafkaConfig is the configuration for the kafka EventSink
+type KafkaConfig struct {
+	Brokers   []string       `yaml:"brokers"`
+	Topic     string         `yaml:"topic"`
+	KeyScheme KafkaKeyScheme `yaml:"key_scheme"`
+	ClientID  string         `yaml:"client_id"`
+}
+
+// Validate checks the KafkaConfig for errors
+func (c *KafkaConfig) Validate() error {
+	if len(c.Brokers) == 0 {
+		return nil
+	}
+	if c.Topic == "" {
+		return fmt.Errorf("Kafka topic must be set")
+	}
+	switch c.KeyScheme {
+	case "", KafkaKeyNone, KafkaKeyEventType, KafkaKeyLockKey:
+	default:
+		return fmt.Errorf("Unknown kafka key_scheme %q", c.KeyScheme)
+	}
+	return nil
+}
+
+// UnmarshalYAML unmarshals the inline PairConfig and the global options. This
+// is required as the embedded PairConfig's UnmarshalYAML would otherwise be
+// promoted and used for the whole Config
+func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	if err := unmarshal(&c.PairConfig); err != nil {
+		return err
+	}
+	var globals struct {
+		Pairs              []*PairConfig            `yaml:"pairs"`
+		Pipelines          []*PipelineConfig        `yaml:"pipelines"`
+		Filters            map[string]*FilterConfig `yaml:"filters"`
+		WorkerPoolSize     int                      `yaml:"worker_pool_size"`
+		WorkQueue          WorkQueueConfig          `yaml:"work_queue"`
+		EventsConfig       EventsConfig             `yaml:"events"`
+		ConsulRegistration ConsulRegistrationConfig `yaml:"consul_registration"`
+		K8sController      K8sControllerConfig      `yaml:"k8s_controller"`
+		State              StateConfig              `yaml:"state"`
+		History            HistoryConfig            `yaml:"history"`
+		Include            []string                 `yaml:"include"`
+	}
+	if err := unmarshal(&globals); err != nil {
+		return err
+	}
+	c.Pairs = globals.Pairs
+	c.Pipelines = globals.Pipelines
+	c.Filters = globals.Filters
+	c.WorkerPoolSize = globals.WorkerPoolSize
+	c.WorkQueue = globals.WorkQueue
+	c.EventsConfig = globals.EventsConfig
+	c.ConsulRegistration = globals.ConsulRegistration
+	c.K8sController = globals.K8sController
+	c.State = globals.State
+	c.History = globals.History
+	c.Include = globals.Include
+	return nil
+}
+
+// PipelineConfig is the config for syncing a single source, through a chain
+// of filters, to many destinations
+type PipelineConfig struct {
+	Name string `yaml:"name"`
+	// Filters are the names of the filters applied (in order) to the targets
+	// from the source
+	Filters []string `yaml:"filters"`
+	// Destinations each set a `name` and the destination options, they may
+	// also override the syncer options. A destination's `match` is a filter
+	// applied after the pipeline's, routing only the matching targets to it
+	// (e.g. the targets on port 443 to one target group and those on 8443
+	// to another).
+	Destinations []yaml.MapSlice `yaml:"destinations"`
+
+	// pair is the rest of the pipeline's config (the source, credentials and
+	// syncer options), shared by all of the destinations
+	pair yaml.MapSlice
+}
+
+// UnmarshalYAML keeps the pipeline's pair options to unmarshal for each
+// destination
+func (c *PipelineConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain PipelineConfig
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	return unmarshal(&c.pair)
+}
+
+// pairs expands the pipeline into a sync pair per destination, named (and
+// locked) as `<pipeline>/<destination>`
+func (c *PipelineConfig) pairs(filters map[string]*FilterConfig) ([]*PairConfig, error) {
+	if c.Name == "" {
+		return nil, fmt.Errorf("Pipelines must have a name")
+	}
+	if len(c.Destinations) == 0 {
+		return nil, fmt.Errorf("Pipeline %s has no destinations", c.Name)
+	}
+
+	chain := make([]*FilterConfig, len(c.Filters))
+	for i, name := range c.Filters {
+		filter, ok := filters[name]
+		if !ok {
+			return nil, fmt.Errorf("Pipeline %s references unknown filter %q", c.Name, name)
+		}
+		chain[i] = filter
+	}
+
+	// Each destination is unmarshaled over the pipeline's own config
+	base, err := yaml.Marshal(c.pair)
+	if err != nil {
+		return nil, err
+	}
+	pairs := make([]*PairConfig, len(c.Destinations))
+	for i, dst := range c.Destinations {
+		dst, match, err := destinationMatch(dst)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid match of destination %d of pipeline %s: %v", i, c.Name, err)
+		}
+		dstBytes, err := yaml.Marshal(dst)
+		if err != nil {
+			return nil, err
+		}
+		pair := &PairConfig{}
+		if err := yaml.Unmarshal(base, pair); err != nil {
+			return nil, fmt.Errorf("Error unmarshaling pipeline %s: %v", c.Name, err)
+		}
+		pair.Name = ""
+		if err := yaml.Unmarshal(dstBytes, (*pipelineDestination)(pair)); err != nil {
+			return nil, fmt.Errorf("Error unmarshaling destination %d of pipeline %s: %v", i, c.Name, err)
+		}
+		if pair.Name == "" {
+			return nil, fmt.Errorf("Destination %d of pipeline %s must have a name", i, c.Name)
+		}
+		// Without a key each destination's key is generated from it
+		if pair.SyncConfig.LockOptions.Key != "" {
+			pair.SyncConfig.LockOptions.Key = pair.SyncConfig.LockOptions.Key + "/" + pair.Name
+		}
+		pair.Name = c.Name + "/" + pair.Name
+		pair.SyncConfig.Filters = chain
+		if match != nil {
+			pair.SyncConfig.Filters = append(append([]*FilterConfig{}, chain...), match)
+		}
+		pair.applyCredentials()
+		pairs[i] = pair
+	}
+	return pairs, nil
+}
+
+// destinationMatch returns the pipeline destination without its `match`, and
+// the match's filter (nil if it has none)
+func destinationMatch(dst yaml.MapSlice) (yaml.MapSlice, *FilterConfig, error) {
+	rest := make(yaml.MapSlice, 0, len(dst))
+	var match *FilterConfig
+	for _, item := range dst {
+		if key, ok := item.Key.(string); !ok || key != "match" {
+			rest = append(rest, item)
+			continue
+		}
+		b, err := yaml.Marshal(item.Value)
+		if err != nil {
+			return nil, nil, err
+		}
+		match = &FilterConfig{}
+		if err := yaml.UnmarshalStrict(b, match); err != nil {
+			return nil, nil, err
+		}
+		if err := match.Validate(); err != nil {
+			return nil, nil, err
+		}
+	}
+	return rest, match, nil
+}
+
+// pipelineDestination unmarshals a destination over the pipeline's config,
+// without resetting it to the defaults
+type pipelineDestination PairConfig
+
+// expandPipelines adds the sync pairs of all pipelines to the Pairs
+func (c *Config) expandPipelines() error {
+	if len(c.Pipelines) == 0 {
+		return nil
+	}
+	for name, filter := range c.Filters {
+		if err := filter.Validate(); err != nil {
+			return fmt.Errorf("Invalid filter %s: %v", name, err)
+		}
+	}
+	pairs := c.definedPairs()
+	for _, pipeline := range c.Pipelines {
+		pipelinePairs, err := pipeline.pairs(c.Filters)
+		if err != nil {
+			return err
+		}
+		pairs = append(pairs, pipelinePairs...)
+	}
+	c.Pairs = pairs
+	c.Pipelines = nil
+	return nil
+}
+
+// definedPairs returns the pairs defined, only including the inline pair if it
+// has a name or lock key as it is otherwise empty when pipelines are defined
+func (c *Config) definedPairs() []*PairConfig {
+	if len(c.Pairs) > 0 {
+		return c.Pairs
+	}
+	if c.PairConfig.Name != "" || c.PairConfig.SyncConfig.LockOptions.Key != "" {
+		return []*PairConfig{&c.PairConfig}
+	}
+	return nil
 }
 
+// SyncPairs returns the configs of all sync pairs defined. With the k8s
+// controller enabled the inline pair is only included if it has a name or lock
+// key, as all pairs may come from the controller.
+func (c *Config) SyncPairs() []*PairConfig {
+	if len(c.Pairs) > 0 {
+		return c.Pairs
+	}
+	if c.K8sController.Enabled {
+		return c.definedPairs()
+	}
+	return []*PairConfig{&c.PairConfig}
+}
+
+// Validate checks the global options and the config of all sync pairs
 func (c *Config) Validate() error {
+	if err := c.EventsConfig.Kafka.Validate(); err != nil {
+		return err
+	}
+	if err := c.EventsConfig.Alertmanager.Validate(); err != nil {
+		return err
+	}
+	if err := c.ConsulRegistration.Validate(); err != nil {
+		return err
+	}
+	if err := c.WorkQueue.Validate(); err != nil {
+		return err
+	}
+	if err := c.K8sController.Validate(); err != nil {
+		return err
+	}
+	if err := c.State.Validate(); err != nil {
+		return err
+	}
+	if err := c.History.Validate(); err != nil {
+		return err
+	}
+	pairs := c.SyncPairs()
+	names := make(map[string]struct{}, len(pairs))
+	traefikPaths := make(map[string]string)
+	for _, pair := range pairs {
+		if _, ok := names[pair.PairName()]; ok {
+			return fmt.Errorf("Duplicate pair name %q", pair.PairName())
+		}
+		names[pair.PairName()] = struct{}{}
+		for _, path := range pair.traefikHTTPPaths() {
+			if other, ok := traefikPaths[path]; ok {
+				return fmt.Errorf("Traefik http_path %s is used by both pair %s and %s", path, other, pair.PairName())
+			}
+			traefikPaths[path] = pair.PairName()
+		}
+		if err := pair.Validate(); err != nil {
+			return fmt.Errorf("Invalid config for pair %s: %v", pair.PairName(), err)
+		}
+	}
+	return nil
+}
+
+// PairConfig is the config for a single source to destination sync
+type PairConfig struct {
+	// Name of the pair, used to label metrics, logs, events and status.
+	// Defaults to the lock key, so must be set if pairs share a lock key
+	Name string `yaml:"name"`
+
+	// Credentials for the pair's backends, isolated from other pairs
+	Credentials CredentialsConfig `yaml:"credentials"`
+
+	ConsulConfig          `yaml:"consul"`
+	ASGConfig             `yaml:"asg"`
+	PushConfig            `yaml:"push"`
+	PrometheusSDConfig    `yaml:"prometheus_sd"`
+	FakeSourceConfig      `yaml:"fake_source"`
+	ReplaySourceConfig    `yaml:"replay_source"`
+	AWSConfig             `yaml:"aws"`
+	K8sEndpointsConfig    `yaml:"k8s_enpoints"`
+	TraefikConfig         `yaml:"traefik"`
+	OctaviaConfig         `yaml:"octavia"`
+	K8sServiceEntryConfig `yaml:"k8s_service_entry"`
+	LinodeConfig          `yaml:"linode"`
+	GCEConfig             `yaml:"gce"`
+	HetznerConfig         `yaml:"hetzner"`
+	ScalewayConfig        `yaml:"scaleway"`
+	OVHConfig             `yaml:"ovh"`
+
+	GlobalAcceleratorConfig `yaml:"global_accelerator"`
+	RFC2136Config           `yaml:"rfc2136"`
+
+	ConsulDestinationConfig `yaml:"consul_destination"`
+	FakeDestinationConfig   `yaml:"fake_destination"`
+
+	// Chain are destinations the targets are synced to in order, e.g. service
+	// discovery before the load balancer, instead of the pair's destination.
+	// Each is configured like a pair's destination, and targets are removed
+	// from them in reverse order.
+	Chain []*PairConfig `yaml:"chain"`
+
+	TriggerConfig `yaml:"trigger"`
+
+	// LockQuorum are additional backends the lock must also be held in
+	// before leading, only consul clusters (e.g. in other regions) are
+	// supported. The pair's own lock (from its source) is acquired first.
+	LockQuorum []*LockBackendConfig `yaml:"lock_quorum"`
+
+	// Record appends every update from the source to a file, for replaying
+	// with the `replay_source`
+	Record RecordConfig `yaml:"record"`
+
+	SyncConfig `yaml:"syncer"`
+}
+
+// PairName returns the name of the pair
+func (c *PairConfig) PairName() string {
+	if c.Name != "" {
+		return c.Name
+	}
+	return c.SyncConfig.LockOptions.Key
+}
+
+// UnmarshalYAML sets the default options before unmarshaling
+func (c *PairConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*c = defaultPairConfig()
+	type plain PairConfig
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	c.applyCredentials()
+	return nil
+}
+
+// applyCredentials sets the pair's credentials on its backend configs
+func (c *PairConfig) applyCredentials() {
+	c.ASGConfig.Credentials = c.Credentials.AWS
+	c.AWSConfig.Credentials = c.Credentials.AWS
+	c.TriggerConfig.Credentials = c.Credentials.AWS
+	c.GlobalAcceleratorConfig.Credentials = c.Credentials.AWS
+
+	if c.Credentials.Consul.Token != "" {
+		if c.ConsulConfig.ClientConfig == nil {
+			c.ConsulConfig.ClientConfig = consulApi.DefaultConfig()
+		}
+		if c.ConsulDestinationConfig.ClientConfig == nil {
+			c.ConsulDestinationConfig.ClientConfig = consulApi.DefaultConfig()
+		}
+		c.ConsulConfig.ClientConfig.Token = c.Credentials.Consul.Token
+		c.ConsulDestinationConfig.ClientConfig.Token = c.Credentials.Consul.Token
+	}
+	if c.Credentials.Consul.Namespace != "" {
+		c.ConsulConfig.Namespace = c.Credentials.Consul.Namespace
+		c.ConsulDestinationConfig.Namespace = c.Credentials.Consul.Namespace
+	}
+	if c.Credentials.Consul.Partition != "" {
+		c.ConsulConfig.Partition = c.Credentials.Consul.Partition
+		c.ConsulDestinationConfig.Partition = c.Credentials.Consul.Partition
+	}
+
+	// Chained destinations without their own credentials use the pair's
+	for _, member := range c.Chain {
+		if member.Credentials == (CredentialsConfig{}) {
+			member.Credentials = c.Credentials
+			member.applyCredentials()
+		}
+	}
+}
+
+// traefikHTTPPaths returns the paths the pair's traefik destinations serve
+// their config on
+func (c *PairConfig) traefikHTTPPaths() []string {
+	if len(c.Chain) > 0 {
+		var paths []string
+		for _, member := range c.Chain {
+			paths = append(paths, member.traefikHTTPPaths()...)
+		}
+		return paths
+	}
+	if c.TraefikConfig.ServiceName == "" || c.TraefikConfig.HTTPPath == "" {
+		return nil
+	}
+	return []string{c.TraefikConfig.HTTPPath}
+}
+
+// Validate checks the PairConfig for errors
+func (c *PairConfig) Validate() error {
+	if err := c.Credentials.Validate(); err != nil {
+		return err
+	}
+	if err := c.ConsulConfig.Validate(); err != nil {
+		return err
+	}
+	if err := c.ASGConfig.Validate(); err != nil {
+		return err
+	}
+	if err := c.PushConfig.Validate(); err != nil {
+		return err
+	}
+	if err := c.PrometheusSDConfig.Validate(); err != nil {
+		return err
+	}
+	if err := c.FakeSourceConfig.Validate(); err != nil {
+		return err
+	}
+	if err := c.ReplaySourceConfig.Validate(); err != nil {
+		return err
+	}
+	if err := c.FakeDestinationConfig.Validate(); err != nil {
+		return err
+	}
+	if err := c.AWSConfig.Validate(); err != nil {
+		return err
+	}
+	if err := c.LinodeConfig.Validate(); err != nil {
+		return err
+	}
+	if err := c.HetznerConfig.Validate(); err != nil {
+		return err
+	}
+	if err := c.ScalewayConfig.Validate(); err != nil {
+		return err
+	}
+	if err := c.OVHConfig.Validate(); err != nil {
+		return err
+	}
+	if err := c.GlobalAcceleratorConfig.Validate(); err != nil {
+		return err
+	}
+	if err := c.RFC2136Config.Validate(); err != nil {
+		return err
+	}
+	if err := c.validateChain(); err != nil {
+		return err
+	}
+	for _, backend := range c.LockQuorum {
+		if err := backend.Validate(); err != nil {
+			return err
+		}
+	}
 	return c.SyncConfig.Validate()
 }
 
+// CredentialsConfig holds the credentials of a sync pair
+type CredentialsConfig struct {
+	AWS    AWSCredentialsConfig    `yaml:"aws"`
+	Consul ConsulCredentialsConfig `yaml:"consul"`
+}
+
+// Validate checks the CredentialsConfig for errors
+func (c CredentialsConfig) Validate() error {
+	if c.AWS.ExternalID != "" && c.AWS.RoleARN == "" {
+		return fmt.Errorf("AWS role_arn must be set to use an external_id")
+	}
+	return nil
+}
+
+// AWSCredentialsConfig holds the AWS role to assume, if unset the default
+// credential chain is used
+type AWSCredentialsConfig struct {
+	RoleARN    string `yaml:"role_arn"`
+	ExternalID string `yaml:"external_id"`
+}
+
+// ConsulCredentialsConfig holds the consul ACL token, namespace and partition
+type ConsulCredentialsConfig struct {
+	Token string `yaml:"token"`
+	// Namespace and admin Partition (consul enterprise) to use for all
+	// requests
+	Namespace string `yaml:"namespace"`
+	Partition string `yaml:"partition"`
+}
+
 // ConsulConfig holds the configuration for the consul source
 type ConsulConfig struct {
 	ClientConfig *consulApi.Config `yaml:"client"`
-	ServiceName  string            `yaml:"service_name"`
-	Tag          string            `yaml:"tag"`
+	// TLS connects to consul over (mutual) TLS
+	TLS TLSConfig `yaml:"tls"`
+	// Namespace and admin Partition (consul enterprise) to use for the
+	// queries and the lock session
+	Namespace   string `yaml:"namespace"`
+	Partition   string `yaml:"partition"`
+	ServiceName string `yaml:"service_name"`
+	Tag         string `yaml:"tag"`
+	// ServiceNames are more services whose instances are merged with those
+	// of ServiceName into a single set of targets, e.g. for a target group
+	// shared by closely related services
+	ServiceNames []string `yaml:"service_names"`
+	// ServicePattern is a regular expression matching whole service names
+	// in the catalog, the instances of all matching services are merged
+	// with those of ServiceName and ServiceNames
+	ServicePattern string `yaml:"service_pattern"`
+
+	QueryMode   ConsulQueryMode   `yaml:"query_mode"`
+	Consistency ConsulConsistency `yaml:"consistency"`
+	// Connect syncs the address/port of the Connect sidecar proxies of the
+	// service, rather than the service itself, for mesh-fronted services
+	Connect bool `yaml:"connect"`
+	// Gateway syncs the addresses of the mesh or terminating gateway the
+	// service is reached through, rather than the service itself, while the
+	// service has instances
+	Gateway ConsulGatewayConfig `yaml:"gateway"`
+	// MaxStale is the max age of a stale read before it is retried against
+	// the leader, 0 accepts any staleness
+	MaxStale time.Duration `yaml:"max_stale"`
+
+	// WaitTime is the max time a blocking query waits for a change, 0 uses
+	// the consul default
+	WaitTime time.Duration `yaml:"wait_time"`
+	// RetryBackoff is the initial time to wait before retrying a failed
+	// query, doubling on each failure up to MaxRetryBackoff
+	RetryBackoff    time.Duration `yaml:"retry_backoff"`
+	MaxRetryBackoff time.Duration `yaml:"max_retry_backoff"`
+	// UnhealthyAfter is how long queries can fail before the source reports
+	// itself as unhealthy, 0 disables this
+	UnhealthyAfter time.Duration `yaml:"unhealthy_after"`
+
+	LoggerConfig `yaml:",inline"`
+}
+
+// Validate checks the ConsulConfig for errors
+func (c ConsulConfig) Validate() error {
+	switch c.QueryMode {
+	case "", ConsulQueryModeHealth, ConsulQueryModeCatalog, ConsulQueryModeAgent:
+	default:
+		return fmt.Errorf("Unknown consul query_mode %q", c.QueryMode)
+	}
+	if c.QueryMode == ConsulQueryModeAgent && c.Connect {
+		return fmt.Errorf("Consul connect can't be used with the agent query_mode")
+	}
+	switch c.Consistency {
+	case "", ConsulConsistencyDefault, ConsulConsistencyStale, ConsulConsistencyConsistent:
+	default:
+		return fmt.Errorf("Unknown consul consistency %q", c.Consistency)
+	}
+	if c.RetryBackoff <= 0 || c.MaxRetryBackoff < c.RetryBackoff {
+		return fmt.Errorf("Consul retry_backoff must be >0 and <= max_retry_backoff")
+	}
+	if err := c.Gateway.Validate(); err != nil {
+		return err
+	}
+	if c.Gateway.ServiceName != "" && c.Connect {
+		return fmt.Errorf("Consul connect and gateway can't both be set")
+	}
+	if c.ServicePattern != "" {
+		if _, err := compileServicePattern(c.ServicePattern); err != nil {
+			return fmt.Errorf("Invalid consul service_pattern %q: %v", c.ServicePattern, err)
+		}
+	}
+	if c.Gateway.ServiceName != "" && (len(c.ServiceNames) > 0 || c.ServicePattern != "") {
+		return fmt.Errorf("Consul gateway can't be used with service_names or service_pattern")
+	}
+	return c.TLS.Validate()
+}
+
+// HasServices returns whether any services are configured, so the consul
+// source is used
+func (c ConsulConfig) HasServices() bool {
+	return c.ServiceName != "" || len(c.ServiceNames) > 0 || c.ServicePattern != ""
+}
+
+// serviceNames returns the ServiceName and ServiceNames, without duplicates
+func (c ConsulConfig) serviceNames() []string {
+	var names []string
+	seen := make(map[string]struct{})
+	for _, name := range append([]string{c.ServiceName}, c.ServiceNames...) {
+		if _, ok := seen[name]; ok || name == "" {
+			continue
+		}
+		seen[name] = struct{}{}
+		names = append(names, name)
+	}
+	return names
+}
+
+// ConsulGatewayConfig configures the consul source to sync the instances of
+// the gateway a service is reached through
+type ConsulGatewayConfig struct {
+	// ServiceName of the gateway in consul, its passing instances are the
+	// targets
+	ServiceName string `yaml:"service_name"`
+	Tag         string `yaml:"tag"`
+	// TaggedAddress of the gateway instances to use (e.g. `wan` or `lan`),
+	// defaults to the service address
+	TaggedAddress string `yaml:"tagged_address"`
+}
+
+// Validate checks the ConsulGatewayConfig for errors
+func (c ConsulGatewayConfig) Validate() error {
+	if c.ServiceName == "" && (c.Tag != "" || c.TaggedAddress != "") {
+		return fmt.Errorf("Consul gateway service_name must be set")
+	}
+	return nil
+}
+
+// SubscriptionKey identifies the targets the consul source subscribes to,
+// sources with the same key can share a subscription (see `SharedSource`)
+func (c *ConsulConfig) SubscriptionKey() string {
+	client := c.ClientConfig
+	if client == nil {
+		client = consulApi.DefaultConfig()
+	}
+	return fmt.Sprintf("%q", []interface{}{
+		client.Address, client.Scheme, client.Datacenter, client.Token, c.TLS.key(),
+		c.Namespace, c.Partition, c.ServiceName, c.ServiceNames, c.ServicePattern, c.Tag, c.QueryMode, c.Consistency,
+		c.Connect, c.Gateway, c.MaxStale, c.WaitTime,
+	})
+}
+
+// ConsulQueryMode defines which consul endpoint is used to find targets
+type ConsulQueryMode string
+
+const (
+	// ConsulQueryModeHealth queries the health endpoint, returning only
+	// instances with passing checks (default)
+	ConsulQueryModeHealth ConsulQueryMode = "health"
+	// ConsulQueryModeCatalog queries the catalog, returning all registered
+	// instances regardless of health
+	ConsulQueryModeCatalog ConsulQueryMode = "catalog"
+	// ConsulQueryModeAgent queries the local agent, returning only the
+	// passing instances registered with it, without loading the servers.
+	// The agent is polled every `WaitTime` (10s by default).
+	ConsulQueryModeAgent ConsulQueryMode = "agent"
+)
+
+// ConsulConsistency is the consistency mode used for consul reads
+type ConsulConsistency string
+
+const (
+	// ConsulConsistencyDefault reads from the leader, which may be stale in
+	// rare cases during leader changes
+	ConsulConsistencyDefault ConsulConsistency = "default"
+	// ConsulConsistencyStale allows any server to service the read
+	ConsulConsistencyStale ConsulConsistency = "stale"
+	// ConsulConsistencyConsistent forces a fully consistent read
+	ConsulConsistencyConsistent ConsulConsistency = "consistent"
+)
+
+// ASGConfig holds the configuration for the AWS Auto Scaling Group source
+type ASGConfig struct {
+	Region string `yaml:"region"`
+	// Names of the ASGs, alternatively all ASGs with the Tags are used
+	Names []string          `yaml:"names"`
+	Tags  map[string]string `yaml:"tags"`
+	// Port to use for the targets
+	Port int `yaml:"port"`
+
+	// PollInterval is how often the ASGs are listed
+	PollInterval time.Duration `yaml:"poll_interval"`
+	// QueueURL of an SQS queue receiving the ASG lifecycle hook
+	// notifications, the ASGs are listed again as soon as one is received
+	QueueURL string `yaml:"sqs_queue_url"`
+	// UnhealthyAfter is how long listing can fail before the source reports
+	// itself as unhealthy, 0 disables this
+	UnhealthyAfter time.Duration `yaml:"unhealthy_after"`
+
+	// Credentials are set from the pair's credentials
+	Credentials AWSCredentialsConfig `yaml:"-"`
+
+	LoggerConfig `yaml:",inline"`
+}
+
+func (c ASGConfig) enabled() bool {
+	return len(c.Names) > 0 || len(c.Tags) > 0
+}
+
+// Validate checks the ASGConfig for errors
+func (c ASGConfig) Validate() error {
+	if c.enabled() && c.Port <= 0 {
+		return fmt.Errorf("ASG port must be set")
+	}
+	return nil
+}
+
+// TriggerConfig holds the configuration for the reconcile trigger
+type TriggerConfig struct {
+	Region string `yaml:"region"`
+	// QueueURL of an SQS queue, any message received on it forces an
+	// immediate reconcile. An EventBridge rule can target the queue.
+	QueueURL string `yaml:"sqs_queue_url"`
+
+	// Credentials are set from the pair's credentials
+	Credentials AWSCredentialsConfig `yaml:"-"`
+}
+
+// PushConfig holds the configuration for the push source, where targets
+// register themselves over HTTP
+type PushConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// DefaultTTL of registrations which don't specify a TTL
+	DefaultTTL time.Duration `yaml:"default_ttl"`
+	// MaxTTL caps the TTL of registrations, 0 is unlimited
+	MaxTTL time.Duration `yaml:"max_ttl"`
+
+	LoggerConfig `yaml:",inline"`
+}
+
+// Validate checks the PushConfig for errors
+func (c PushConfig) Validate() error {
+	if c.Enabled && c.DefaultTTL <= 0 {
+		return fmt.Errorf("Push default_ttl must be >0")
+	}
+	return nil
+}
+
+// PrometheusSDConfig holds the configuration for the Prometheus service
+// discovery source, reading a Prometheus HTTP SD endpoint and/or file_sd files
+type PrometheusSDConfig struct {
+	// URL of the HTTP SD endpoint
+	URL string `yaml:"url"`
+	// Headers are set on requests to the endpoint, e.g. an Authorization
+	// header
+	Headers map[string]string `yaml:"headers"`
+	// Files are file_sd files (.json, .yml or .yaml), which may be globs
+	Files []string `yaml:"files"`
+	// Port of targets without one in their address
+	Port int `yaml:"port"`
+
+	// RefreshInterval is how often the targets are fetched, defaults to 30s
+	RefreshInterval time.Duration `yaml:"refresh_interval"`
+	// Timeout for fetching the endpoint, defaults to 10s
+	Timeout time.Duration `yaml:"timeout"`
+	// UnhealthyAfter is how long refreshing can fail before the source
+	// reports itself as unhealthy, 0 disables this
+	UnhealthyAfter time.Duration `yaml:"unhealthy_after"`
+
+	LoggerConfig `yaml:",inline"`
+}
+
+// Enabled returns whether the Prometheus SD source is configured
+func (c PrometheusSDConfig) Enabled() bool {
+	return c.URL != "" || len(c.Files) > 0
+}
+
+// Validate checks the PrometheusSDConfig for errors
+func (c PrometheusSDConfig) Validate() error {
+	if c.URL != "" {
+		parsed, err := url.Parse(c.URL)
+		if err != nil {
+			return fmt.Errorf("Invalid prometheus_sd url %q: %v", c.URL, err)
+		}
+		if parsed.Scheme != "http" && parsed.Scheme != "https" {
+			return fmt.Errorf("Invalid prometheus_sd url %q: scheme must be http or https", c.URL)
+		}
+	}
+	for _, pattern := range c.Files {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("Invalid prometheus_sd files pattern %q: %v", pattern, err)
+		}
+	}
+	if c.Port < 0 || c.RefreshInterval < 0 || c.Timeout < 0 || c.UnhealthyAfter < 0 {
+		return fmt.Errorf("prometheus_sd port, refresh_interval, timeout and unhealthy_after must be >=0")
+	}
+	return nil
+}
+
+// FakeSourceConfig holds the configuration for the fake source, which
+// generates targets for soak testing
+type FakeSourceConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Targets is the number of targets to generate
+	Targets int `yaml:"targets"`
+	Port    int `yaml:"port"`
+	// ChurnPercent of the targets are replaced every ChurnInterval
+	ChurnPercent  int           `yaml:"churn_percent"`
+	ChurnInterval time.Duration `yaml:"churn_interval"`
+	// Latency of each update, and the probability (0-1) of it failing
+	Latency   time.Duration `yaml:"latency"`
+	ErrorRate float64       `yaml:"error_rate"`
+
+	LoggerConfig `yaml:",inline"`
+}
+
+// Validate checks the FakeSourceConfig for errors
+func (c FakeSourceConfig) Validate() error {
+	if c.ChurnPercent < 0 || c.ChurnPercent > 100 {
+		return fmt.Errorf("Fake source churn_percent must be between 0 and 100")
+	}
+	if c.ErrorRate < 0 || c.ErrorRate > 1 {
+		return fmt.Errorf("Fake source error_rate must be between 0 and 1")
+	}
+	return nil
+}
+
+// FakeDestinationConfig holds the configuration for the in-memory fake
+// destination
+type FakeDestinationConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Latency of each call, and the probability (0-1) of it failing
+	Latency   time.Duration `yaml:"latency"`
+	ErrorRate float64       `yaml:"error_rate"`
+}
+
+// Validate checks the FakeDestinationConfig for errors
+func (c FakeDestinationConfig) Validate() error {
+	if c.ErrorRate < 0 || c.ErrorRate > 1 {
+		return fmt.Errorf("Fake destination error_rate must be between 0 and 1")
+	}
+	return nil
+}
+
+// ConsulDestinationConfig holds the configuration for the consul destination
+type ConsulDestinationConfig struct {
+	ClientConfig *consulApi.Config `yaml:"client"`
+	// TLS connects to consul over (mutual) TLS
+	TLS TLSConfig `yaml:"tls"`
+	// Namespace and admin Partition (consul enterprise) to register in
+	Namespace string `yaml:"namespace"`
+	Partition string `yaml:"partition"`
+	// ServiceName to register the targets as
+	ServiceName string   `yaml:"service_name"`
+	Tags        []string `yaml:"tags"`
+	// NodePrefix is prepended to the target IP to name its external node
+	NodePrefix string `yaml:"node_prefix"`
 }
 
 // AWSConfig holds the configuration for the aws destination
 type AWSConfig struct {
 	TargetGroupARN   string `yaml:"target_group_arn"`
 	AvailabilityZone string `yaml:"availability_zone"`
+	Region           string `yaml:"region"`
+	// InferPort uses the target group's configured port for targets
+	// without one, instead of duplicating it in the source config. Targets
+	// on that port are reported by GetTargets without a port.
+	InferPort bool `yaml:"infer_port"`
+	// BatchSize is the most targets (de)registered per call, larger changes
+	// are split into multiple calls. Defaults to 500
+	BatchSize int `yaml:"batch_size"`
+	// Attributes are the target group attributes to manage, e.g. the
+	// deregistration delay, reset on full syncs if they drift
+	Attributes TargetGroupAttributesConfig `yaml:"attributes"`
+	// IPValidation rejects targets outside the ranges the target group
+	// accepts before registering them
+	IPValidation TargetIPValidationConfig `yaml:"ip_validation"`
+
+	// Regions defines a set of regional target groups to sync to, if set
+	// the single target group options above are ignored
+	Regions      []AWSRegionConfig `yaml:"regions"`
+	RegionPolicy RegionPolicy      `yaml:"region_policy"`
+	// RegionConcurrency is how many regions are called at once, defaults
+	// to all of them
+	RegionConcurrency int `yaml:"region_concurrency"`
+
+	// Credentials are set from the pair's credentials
+	Credentials AWSCredentialsConfig `yaml:"-"`
+
+	LoggerConfig `yaml:",inline"`
+}
+
+// Validate checks the AWSConfig for errors
+func (c AWSConfig) Validate() error {
+	if c.BatchSize < 0 {
+		return fmt.Errorf("aws batch_size must be >=0")
+	}
+	if err := c.Attributes.Validate(); err != nil {
+		return err
+	}
+	if err := c.IPValidation.Validate(); err != nil {
+		return err
+	}
+	if len(c.Regions) == 0 {
+		return nil
+	}
+	switch c.RegionPolicy {
+	case "", RegionPolicyMirror, RegionPolicyActive:
+	default:
+		return fmt.Errorf("Unknown region_policy %q", c.RegionPolicy)
+	}
+	for _, region := range c.Regions {
+		if region.TargetGroupARN == "" {
+			return fmt.Errorf("target_group_arn must be set for region %q", region.Region)
+		}
+	}
+	return nil
+}
+
+// RegionPolicy defines how targets are maintained across multiple regions
+type RegionPolicy string
+
+const (
+	// RegionPolicyMirror maintains all targets in every region (default)
+	RegionPolicyMirror RegionPolicy = "mirror"
+	// RegionPolicyActive maintains targets only in the first healthy region,
+	// previously active regions are drained
+	RegionPolicyActive RegionPolicy = "active"
+)
+
+// AWSRegionConfig holds the configuration for a single region of a
+// multi-region aws destination
+type AWSRegionConfig struct {
+	Region           string `yaml:"region"`
+	TargetGroupARN   string `yaml:"target_group_arn"`
+	AvailabilityZone string `yaml:"availability_zone"`
+
+	// HealthCheckURL is polled to determine if the region is healthy, a
+	// region without one is always considered healthy
+	HealthCheckURL string `yaml:"health_check_url"`
+}
+
+// GlobalAcceleratorEndpointType is the type of endpoint targets are resolved
+// to in a Global Accelerator endpoint group
+type GlobalAcceleratorEndpointType string
+
+const (
+	// GlobalAcceleratorEndpointTypeInstance resolves targets to EC2 instances
+	// by private IP (default)
+	GlobalAcceleratorEndpointTypeInstance GlobalAcceleratorEndpointType = "instance"
+	// GlobalAcceleratorEndpointTypeEIP resolves targets to Elastic IPs by
+	// public IP
+	GlobalAcceleratorEndpointTypeEIP GlobalAcceleratorEndpointType = "eip"
+)
+
+// matches returns whether the endpoint ID is of this type
+func (t GlobalAcceleratorEndpointType) matches(endpointID string) bool {
+	if t == GlobalAcceleratorEndpointTypeEIP {
+		return strings.HasPrefix(endpointID, "eipalloc-")
+	}
+	return strings.HasPrefix(endpointID, "i-")
+}
+
+// GlobalAcceleratorConfig holds the configuration for the AWS Global
+// Accelerator endpoint group destination
+type GlobalAcceleratorConfig struct {
+	EndpointGroupARN string                        `yaml:"endpoint_group_arn"`
+	EndpointType     GlobalAcceleratorEndpointType `yaml:"endpoint_type"`
+	// Region of the endpoint group, where the instances or addresses are
+	// looked up
+	Region string `yaml:"region"`
+	// Port of the targets, endpoint groups only track the endpoints
+	Port int `yaml:"port"`
+	// Weight to create endpoints with, if 0 the Global Accelerator default is
+	// used
+	Weight int `yaml:"weight"`
+	// ClientIPPreservation enables client IP preservation on the endpoints
+	ClientIPPreservation bool `yaml:"client_ip_preservation"`
+
+	// Credentials are set from the pair's credentials
+	Credentials AWSCredentialsConfig `yaml:"-"`
+
+	LoggerConfig `yaml:",inline"`
+}
+
+// Validate checks the GlobalAcceleratorConfig for errors
+func (c GlobalAcceleratorConfig) Validate() error {
+	if c.EndpointGroupARN == "" {
+		return nil
+	}
+	switch c.EndpointType {
+	case "", GlobalAcceleratorEndpointTypeInstance, GlobalAcceleratorEndpointTypeEIP:
+	default:
+		return fmt.Errorf("Unknown global_accelerator endpoint_type %q", c.EndpointType)
+	}
+	if c.Weight < 0 || c.Weight > 255 {
+		return fmt.Errorf("Global accelerator weight must be between 0 and 255")
+	}
+	return nil
+}
+
+// RFC2136RecordType is the type of DNS records maintained by the RFC2136
+// destination
+type RFC2136RecordType string
+
+const (
+	// RFC2136RecordTypeA maintains an A (or AAAA) record per target (default)
+	RFC2136RecordTypeA RFC2136RecordType = "A"
+	// RFC2136RecordTypeSRV maintains an SRV record per target, carrying the
+	// target's port and weight
+	RFC2136RecordTypeSRV RFC2136RecordType = "SRV"
+)
+
+// RFC2136Config holds the configuration for the RFC2136 dynamic DNS
+// destination
+type RFC2136Config struct {
+	// Server is the host:port of the DNS server to send updates to
+	Server string `yaml:"server"`
+	// Zone to update, and the Name (within it) of the records
+	Zone       string            `yaml:"zone"`
+	Name       string            `yaml:"name"`
+	RecordType RFC2136RecordType `yaml:"record_type"`
+	// TTL of the records, defaults to 1m
+	TTL time.Duration `yaml:"ttl"`
+	// Port of the targets for A records, which don't carry one
+	Port int `yaml:"port"`
+	// Priority and Weight of SRV records, the weight is overridable per
+	// target with the source meta `targetsync/weight`
+	Priority int `yaml:"priority"`
+	Weight   int `yaml:"weight"`
+	// Transport is udp (default) or tcp
+	Transport string        `yaml:"transport"`
+	Timeout   time.Duration `yaml:"timeout"`
+	// TSIG signs the updates, if a key_name is set
+	TSIG TSIGConfig `yaml:"tsig"`
+	// Public also writes the targets' public IPs to a public zone, for
+	// split-horizon DNS
+	Public RFC2136PublicConfig `yaml:"public"`
+
+	LoggerConfig `yaml:",inline"`
+}
+
+// RFC2136PublicConfig configures the public view of split-horizon DNS, each
+// target with a public IP in its source meta also has a record of it in the
+// public zone
+type RFC2136PublicConfig struct {
+	// Zone to update, and the Name (within it) of the public records. The
+	// public view is enabled if the zone is set
+	Zone string `yaml:"zone"`
+	Name string `yaml:"name"`
+	// Server to send the public zone's updates to, defaults to the server
+	Server string `yaml:"server"`
+	// TTL of the public records, defaults to the TTL
+	TTL time.Duration `yaml:"ttl"`
+	// IPMetaKey is the source meta holding the target's public IP, defaults
+	// to `targetsync/public-ip`. Targets without it only have internal
+	// records
+	IPMetaKey string `yaml:"ip_meta_key"`
+	// TSIG signs the public zone's updates, defaults to the tsig key
+	TSIG TSIGConfig `yaml:"tsig"`
+}
+
+// TSIGConfig holds the TSIG key used to sign dynamic DNS updates
+type TSIGConfig struct {
+	KeyName string `yaml:"key_name"`
+	// Secret is the base64 encoded key
+	Secret string `yaml:"secret"`
+	// Algorithm defaults to hmac-sha256
+	Algorithm string `yaml:"algorithm"`
+}
+
+// Validate checks the RFC2136Config for errors
+func (c RFC2136Config) Validate() error {
+	if c.Server == "" {
+		return nil
+	}
+	if c.Zone == "" || c.Name == "" {
+		return fmt.Errorf("RFC2136 zone and name must be set")
+	}
+	switch c.RecordType {
+	case "", RFC2136RecordTypeA, RFC2136RecordTypeSRV:
+	default:
+		return fmt.Errorf("Unknown rfc2136 record_type %q", c.RecordType)
+	}
+	switch c.Transport {
+	case "", "udp", "tcp":
+	default:
+		return fmt.Errorf("Unknown rfc2136 transport %q", c.Transport)
+	}
+	if c.Priority < 0 || c.Priority > 65535 || c.Weight < 0 || c.Weight > 65535 {
+		return fmt.Errorf("RFC2136 priority and weight must be between 0 and 65535")
+	}
+	if c.TSIG.KeyName != "" && c.TSIG.Secret == "" {
+		return fmt.Errorf("RFC2136 tsig secret must be set")
+	}
+	if c.Public.Zone != "" {
+		if c.Public.Name == "" {
+			return fmt.Errorf("RFC2136 public name must be set")
+		}
+		if c.Public.TSIG.KeyName != "" && c.Public.TSIG.Secret == "" {
+			return fmt.Errorf("RFC2136 public tsig secret must be set")
+		}
+	}
+	return nil
+}
+
+// TraefikConfig holds the configuration for the traefik destination
+type TraefikConfig struct {
+	// ServiceName is the name of the traefik service to populate
+	ServiceName string          `yaml:"service_name"`
+	Protocol    TraefikProtocol `yaml:"protocol"`
+	// Scheme used for the server urls of http services
+	Scheme string `yaml:"scheme"`
+
+	// FilePath is where to write the config for traefik's file provider
+	FilePath string `yaml:"file_path"`
+	// HTTPPath is the path on the bind address to serve the config on for
+	// traefik's HTTP provider, unique across the pairs
+	HTTPPath string `yaml:"http_path"`
+
+	LoggerConfig `yaml:",inline"`
+}
+
+// TraefikProtocol is the type of traefik service to generate
+type TraefikProtocol string
+
+const (
+	// TraefikProtocolHTTP generates an http service with server urls
+	TraefikProtocolHTTP TraefikProtocol = "http"
+	// TraefikProtocolTCP generates a tcp service with server addresses
+	TraefikProtocolTCP TraefikProtocol = "tcp"
+)
+
+// OctaviaConfig holds the configuration for the openstack octavia destination
+type OctaviaConfig struct {
+	// AuthURL is the keystone endpoint, if empty the standard OS_*
+	// environment variables are used for authentication
+	AuthURL     string `yaml:"auth_url"`
+	Username    string `yaml:"username"`
+	Password    string `yaml:"password"`
+	ProjectID   string `yaml:"project_id"`
+	ProjectName string `yaml:"project_name"`
+	DomainName  string `yaml:"domain_name"`
+	Region      string `yaml:"region"`
+
+	PoolID   string `yaml:"pool_id"`
+	SubnetID string `yaml:"subnet_id"`
+	// Weight of the members, if 0 the octavia default is used. Overridden by
+	// the weight metadata of the targets
+	Weight int `yaml:"weight"`
+
+	LoggerConfig `yaml:",inline"`
 }
 
 type K8sConfig struct {
@@ -70,18 +1422,402 @@ type K8sEndpointsConfig struct {
 	Name      string `yaml:"name"`
 	Namespace string `yaml:"namespace"`
 	Port      int    `yaml:"port"`
+
+	LoggerConfig `yaml:",inline"`
+}
+
+// GCEConfig holds the configuration for the GCE unmanaged instance group
+// destination
+type GCEConfig struct {
+	Project       string `yaml:"project"`
+	Zone          string `yaml:"zone"`
+	InstanceGroup string `yaml:"instance_group"`
+	// Port of the targets, instance groups only track instances
+	Port int `yaml:"port"`
+	// CredentialsFile is the service account key file, if empty the
+	// application default credentials are used
+	CredentialsFile string `yaml:"credentials_file"`
+
+	LoggerConfig `yaml:",inline"`
+}
+
+// LinodeRemoveMode defines how targets are removed from a NodeBalancer
+type LinodeRemoveMode string
+
+const (
+	// LinodeRemoveModeDelete deletes the node
+	LinodeRemoveModeDelete LinodeRemoveMode = "delete"
+	// LinodeRemoveModeDrain sets the node to drain, leaving it in place
+	LinodeRemoveModeDrain LinodeRemoveMode = "drain"
+)
+
+// LinodeConfig holds the configuration for the linode NodeBalancer destination
+type LinodeConfig struct {
+	// Token is the API token, if empty `LINODE_TOKEN` is used
+	Token          string `yaml:"token"`
+	NodeBalancerID int    `yaml:"nodebalancer_id"`
+	ConfigID       int    `yaml:"config_id"`
+	// Weight to create nodes with, if 0 the linode default is used
+	Weight     int              `yaml:"weight"`
+	RemoveMode LinodeRemoveMode `yaml:"remove_mode"`
+
+	LoggerConfig `yaml:",inline"`
+}
+
+// Validate checks the LinodeConfig for errors
+func (c *LinodeConfig) Validate() error {
+	switch c.RemoveMode {
+	case "", LinodeRemoveModeDelete, LinodeRemoveModeDrain:
+		return nil
+	default:
+		return fmt.Errorf("Unknown linode remove_mode %q", c.RemoveMode)
+	}
+}
+
+// HetznerTargetType defines how targets are added to a Hetzner Cloud Load
+// Balancer
+type HetznerTargetType string
+
+const (
+	// HetznerTargetTypeServer adds the cloud server with the target's IP
+	HetznerTargetTypeServer HetznerTargetType = "server"
+	// HetznerTargetTypeIP adds the target's IP, e.g. for dedicated servers
+	// connected through a vSwitch
+	HetznerTargetTypeIP HetznerTargetType = "ip"
+)
+
+// HetznerConfig holds the configuration for the Hetzner Cloud Load Balancer
+// destination
+type HetznerConfig struct {
+	// Token is the API token, if empty `HCLOUD_TOKEN` is used
+	Token          string            `yaml:"token"`
+	LoadBalancerID int               `yaml:"load_balancer_id"`
+	TargetType     HetznerTargetType `yaml:"target_type"`
+	// UsePrivateIP matches (and routes to) servers by their private network
+	// IP instead of their public IP
+	UsePrivateIP bool `yaml:"use_private_ip"`
+	// Port reported for the targets, the load balancer's services define the
+	// ports traffic is sent to
+	Port int `yaml:"port"`
+	// ListenPort of the service whose health checks are reported as the
+	// targets' health, if 0 targets are healthy when all services are
+	ListenPort int `yaml:"listen_port"`
+
+	LoggerConfig `yaml:",inline"`
+}
+
+// Validate checks the HetznerConfig for errors
+func (c *HetznerConfig) Validate() error {
+	switch c.TargetType {
+	case "", HetznerTargetTypeServer, HetznerTargetTypeIP:
+	default:
+		return fmt.Errorf("Unknown hetzner target_type %q", c.TargetType)
+	}
+	if c.TargetType == HetznerTargetTypeIP && c.UsePrivateIP {
+		return fmt.Errorf("Hetzner use_private_ip is only supported for server targets")
+	}
+	return nil
+}
+
+// ScalewayConfig holds the configuration for the Scaleway Load Balancer
+// backend destination
+type ScalewayConfig struct {
+	// AccessKey and SecretKey, if empty `SCW_ACCESS_KEY` and `SCW_SECRET_KEY`
+	// are used
+	AccessKey string `yaml:"access_key"`
+	SecretKey string `yaml:"secret_key"`
+	// Region of the load balancer, if empty `SCW_DEFAULT_REGION` is used
+	Region    string `yaml:"region"`
+	BackendID string `yaml:"backend_id"`
+	// LoadBalancerID of the backend, if set the servers' health checks are
+	// reported as the targets' health
+	LoadBalancerID string `yaml:"load_balancer_id"`
+
+	LoggerConfig `yaml:",inline"`
+}
+
+// Validate checks the ScalewayConfig for errors
+func (c *ScalewayConfig) Validate() error {
+	if (c.AccessKey == "") != (c.SecretKey == "") {
+		return fmt.Errorf("Scaleway access_key and secret_key must be set together")
+	}
+	if c.Region != "" {
+		if _, err := scw.ParseRegion(c.Region); err != nil {
+			return fmt.Errorf("Invalid scaleway region %q: %v", c.Region, err)
+		}
+	}
+	if c.LoadBalancerID != "" && c.BackendID == "" {
+		return fmt.Errorf("Scaleway load_balancer_id requires a backend_id")
+	}
+	return nil
+}
+
+// OVHFarmType is the protocol of an OVHcloud IP Load Balancer farm
+type OVHFarmType string
+
+const (
+	// OVHFarmTypeHTTP is an http farm (default)
+	OVHFarmTypeHTTP OVHFarmType = "http"
+	// OVHFarmTypeTCP is a tcp farm
+	OVHFarmTypeTCP OVHFarmType = "tcp"
+	// OVHFarmTypeUDP is a udp farm
+	OVHFarmTypeUDP OVHFarmType = "udp"
+)
+
+// OVHConfig holds the configuration for the OVHcloud IP Load Balancer farm
+// destination
+type OVHConfig struct {
+	// Endpoint of the API (e.g. ovh-eu, ovh-ca), defaults to ovh-eu
+	Endpoint string `yaml:"endpoint"`
+	// ApplicationKey, ApplicationSecret and ConsumerKey, if empty the
+	// `OVH_*` environment variables or ovh.conf are used
+	ApplicationKey    string `yaml:"application_key"`
+	ApplicationSecret string `yaml:"application_secret"`
+	ConsumerKey       string `yaml:"consumer_key"`
+	// ServiceName of the IP Load Balancer (e.g. loadbalancer-1234)
+	ServiceName string      `yaml:"service_name"`
+	FarmType    OVHFarmType `yaml:"farm_type"`
+	FarmID      int64       `yaml:"farm_id"`
+	// Weight to create servers with, if 0 the ovh default is used
+	Weight int `yaml:"weight"`
+}
+
+// Validate checks the OVHConfig for errors
+func (c *OVHConfig) Validate() error {
+	switch c.FarmType {
+	case "", OVHFarmTypeHTTP, OVHFarmTypeTCP, OVHFarmTypeUDP:
+	default:
+		return fmt.Errorf("Unknown ovh farm_type %q", c.FarmType)
+	}
+	if c.ServiceName != "" && c.FarmID == 0 {
+		return fmt.Errorf("OVH farm_id must be set")
+	}
+	return nil
+}
+
+// K8sServiceEntryConfig holds the configuration for the istio ServiceEntry
+// destination
+type K8sServiceEntryConfig struct {
+	K8sConfig `yaml:"k8s"`
+	Name      string `yaml:"name"`
+	Namespace string `yaml:"namespace"`
+	// Hosts of the ServiceEntry, only used when creating it
+	Hosts []string `yaml:"hosts"`
+	// PortNumber, PortName and Protocol define the ServiceEntry's port, the
+	// target's port is set as the endpoint's port for PortName
+	PortNumber int    `yaml:"port_number"`
+	PortName   string `yaml:"port_name"`
+	Protocol   string `yaml:"protocol"`
+	// Location is MESH_EXTERNAL or MESH_INTERNAL
+	Location string `yaml:"location"`
 }
 
+// RemoveRateConfig limits how many targets are removed per interval, so
+// removing many targets doesn't drop all of their (sticky) sessions at once
+type RemoveRateConfig struct {
+	// MaxTargets removed per Interval, 0 is unlimited
+	MaxTargets int           `yaml:"max_targets"`
+	Interval   time.Duration `yaml:"interval"`
+}
+
+// RemoveRetryConfig holds the options for retrying failed removals, the
+// backoff doubles on each failure
+type RemoveRetryConfig struct {
+	// InitialBackoff defaults to 1s, MaxBackoff to 1m
+	InitialBackoff time.Duration `yaml:"initial_backoff"`
+	MaxBackoff     time.Duration `yaml:"max_backoff"`
+	// MaxAttempts before giving up with a `removal_failed` event, defaults
+	// to 10
+	MaxAttempts int `yaml:"max_attempts"`
+}
+
+// RemoveMode defines how targets are removed from the destination
+type RemoveMode string
+
+const (
+	// RemoveModeRemove removes the targets from the destination (default)
+	RemoveModeRemove RemoveMode = "remove"
+	// RemoveModeDisable disables the targets, keeping their slot in the
+	// destination until they are re-enabled. The destination must implement
+	// `TargetAvailabilityDestination`
+	RemoveModeDisable RemoveMode = "disable"
+	// RemoveModeNone never removes targets, only reporting the removals
+	// (e.g. while another system still manages deregistration)
+	RemoveModeNone RemoveMode = "none"
+)
+
 // SyncConfig holds options for the Syncer
 type SyncConfig struct {
 	LockOptions `yaml:"lock_options"`
 
 	RemoveDelay time.Duration `yaml:"remove_delay"`
+	// DebounceWindow coalesces all source updates within the window after
+	// an update into a single sync, 0 disables debouncing
+	DebounceWindow time.Duration `yaml:"debounce_window"`
+	// FullSyncInterval is how often to do a full diff of source and
+	// destination when the source emits deltas
+	FullSyncInterval time.Duration `yaml:"full_sync_interval"`
+
+	Dampening DampeningConfig `yaml:"dampening"`
+	Probe     ProbeConfig     `yaml:"probe"`
+	Transform TransformConfig `yaml:"transform"`
+	Rollout   RolloutConfig   `yaml:"rollout"`
+	Anomaly   AnomalyConfig   `yaml:"anomaly"`
+	Drain     DrainConfig     `yaml:"drain"`
+	// Orchestration calls webhooks before removing and after adding
+	// targets, for deploy orchestration systems
+	Orchestration OrchestrationConfig `yaml:"orchestration"`
+	// RegistrationRefresh re-registers targets before their registrations
+	// expire
+	RegistrationRefresh RegistrationRefreshConfig `yaml:"registration_refresh"`
+	// Replace holds removals until the targets added in the same sync are
+	// healthy
+	Replace ReplaceConfig `yaml:"replace"`
+	// SourceCache keeps syncing the last known good targets through source
+	// outages, up to a max staleness
+	SourceCache SourceCacheConfig `yaml:"source_cache"`
+	// Expr selects and rewrites the targets with CEL expressions, applied
+	// after the Filters
+	Expr ExprConfig `yaml:"expr"`
+	// CapacityWeight sets the targets' weight from their capacity meta
+	CapacityWeight CapacityWeightConfig `yaml:"capacity_weight"`
+
+	// ZoneAffinity restricts the targets to a single zone or region
+	ZoneAffinity ZoneAffinityConfig `yaml:"zone_affinity"`
+	// SelfExclusion protects the local instance from being removed, or
+	// excludes it from the sync
+	SelfExclusion SelfExclusionConfig `yaml:"self_exclusion"`
+	// Filters is the filter chain of the pipeline the pair is part of,
+	// applied before the Transform
+	Filters []*FilterConfig `yaml:"-"`
+
+	// RemoveRate spreads removals of many targets over time
+	RemoveRate RemoveRateConfig `yaml:"remove_rate"`
+	// RemoveRetry controls the backoff of failed removals
+	RemoveRetry RemoveRetryConfig `yaml:"remove_retry"`
+	// RemoveQueueSize is how many removals can be waiting to be queued
+	// before syncs block on the removal queue, defaults to 100
+	RemoveQueueSize int `yaml:"remove_queue_size"`
+
+	// RemoveMode is how targets missing from the source are removed from
+	// the destination
+	RemoveMode RemoveMode `yaml:"remove_mode"`
+
+	// Ownership claims the destination for this pair, refusing to sync it
+	// if it is claimed by another
+	Ownership OwnershipConfig `yaml:"ownership"`
+	// Standby keeps followers warm for a fast takeover
+	Standby StandbyConfig `yaml:"standby"`
+	// MutationBudget pauses mutations once too many targets have been
+	// added and removed within a window, until resumed
+	MutationBudget MutationBudgetConfig `yaml:"mutation_budget"`
+	// Adopt keeps the destination targets missing from the source on the
+	// first sync, for a grace period or until released
+	Adopt AdoptConfig `yaml:"adopt"`
+
+	// Priority of this syncer's jobs in the shared worker pool, higher
+	// priorities are run first
+	Priority int `yaml:"priority"`
+	// MaxConcurrency limits this syncer's concurrent destination mutations,
+	// 0 is unlimited
+	MaxConcurrency int `yaml:"max_concurrency"`
+	// DestinationTimeout limits how long each call to the destination may
+	// take, defaults to 1m
+	DestinationTimeout time.Duration `yaml:"destination_timeout"`
+	// FailureThreshold is how long syncs must fail continuously before a
+	// sync_failing event is emitted, defaults to 5m
+	FailureThreshold time.Duration `yaml:"failure_threshold"`
+}
+
+// ProbeConfig holds the options for the convergence probe, which measures the
+// time from a target being added in the source until it is registered in the
+// destination
+type ProbeConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Interval to poll the destination for pending targets
+	Interval time.Duration `yaml:"interval"`
+}
+
+// DampeningConfig holds the options for coalescing source updates when the
+// source is flapping
+type DampeningConfig struct {
+	// MaxChanges is the number of changes allowed within Window before
+	// updates are coalesced, 0 disables dampening
+	MaxChanges int           `yaml:"max_changes"`
+	Window     time.Duration `yaml:"window"`
+	// SettleTime is how long the source must be unchanged before the
+	// coalesced update is applied, defaults to Window
+	SettleTime time.Duration `yaml:"settle_time"`
 }
 
 func (c SyncConfig) Validate() error {
 	if c.LockOptions.TTL <= time.Duration(0) {
 		return fmt.Errorf("TTL for locks must be >0")
 	}
-	return nil
+	if c.Dampening.MaxChanges > 0 && c.Dampening.Window <= time.Duration(0) {
+		return fmt.Errorf("Window for dampening must be >0")
+	}
+	if err := c.Rollout.Validate(); err != nil {
+		return err
+	}
+	if err := c.Drain.Validate(); err != nil {
+		return err
+	}
+	if err := c.Orchestration.Validate(); err != nil {
+		return err
+	}
+	if err := c.RegistrationRefresh.Validate(); err != nil {
+		return err
+	}
+	if err := c.Replace.Validate(); err != nil {
+		return err
+	}
+	if err := c.ZoneAffinity.Validate(); err != nil {
+		return err
+	}
+	if err := c.SelfExclusion.Validate(); err != nil {
+		return err
+	}
+	if err := c.Expr.Validate(); err != nil {
+		return err
+	}
+	if err := c.CapacityWeight.Validate(); err != nil {
+		return err
+	}
+	if err := c.SourceCache.Validate(); err != nil {
+		return err
+	}
+	if err := c.Adopt.Validate(); err != nil {
+		return err
+	}
+	if err := c.Standby.Validate(); err != nil {
+		return err
+	}
+	if err := c.MutationBudget.Validate(); err != nil {
+		return err
+	}
+	if c.RemoveDelay < 0 {
+		return fmt.Errorf("remove_delay must be >=0")
+	}
+	if c.FailureThreshold < 0 {
+		return fmt.Errorf("failure_threshold must be >=0")
+	}
+	if c.RemoveQueueSize < 0 {
+		return fmt.Errorf("remove_queue_size must be >=0")
+	}
+	if c.RemoveRetry.InitialBackoff < 0 || c.RemoveRetry.MaxBackoff < 0 || c.RemoveRetry.MaxAttempts < 0 {
+		return fmt.Errorf("initial_backoff, max_backoff and max_attempts for remove_retry must be >=0")
+	}
+	if c.RemoveRate.MaxTargets > 0 && c.RemoveRate.Interval <= 0 {
+		return fmt.Errorf("Interval for remove_rate must be >0")
+	}
+	if c.RemoveRetry.MaxBackoff > 0 && c.RemoveRetry.MaxBackoff < c.RemoveRetry.InitialBackoff {
+		return fmt.Errorf("max_backoff for remove_retry must be >= initial_backoff")
+	}
+	switch c.RemoveMode {
+	case "", RemoveModeRemove, RemoveModeDisable, RemoveModeNone:
+	default:
+		return fmt.Errorf("Unknown syncer remove_mode %q", c.RemoveMode)
+	}
+	return c.Transform.Validate()
 }
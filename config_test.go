@@ -0,0 +1,427 @@
+package targetsync
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+func TestConfigFromDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "targetsync")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	fragments := map[string]string{
+		"a.yaml": `
+name: a
+consul:
+  service_name: a
+syncer:
+  lock_options:
+    key: a
+    ttl: 10s
+`,
+		"b.yml": `
+worker_pool_size: 2
+work_queue:
+  workers: 3
+consul_registration:
+  enabled: true
+  service_name: targetsync
+pairs:
+  - name: b
+    consul:
+      service_name: b
+    syncer:
+      lock_options:
+        key: b
+        ttl: 10s
+  - name: c
+    consul:
+      service_name: c
+    syncer:
+      lock_options:
+        key: c
+        ttl: 10s
+`,
+		"README.md": "not a config",
+	}
+	for name, content := range fragments {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Error writing fragment: %v", err)
+		}
+	}
+
+	cfg, err := ConfigFromFile(dir)
+	if err != nil {
+		t.Fatalf("Error loading config dir: %v", err)
+	}
+
+	pairs := cfg.SyncPairs()
+	if len(pairs) != 3 {
+		t.Fatalf("Expected 3 pairs, got %d", len(pairs))
+	}
+	for i, name := range []string{"a", "b", "c"} {
+		if pairs[i].ConsulConfig.ServiceName != name {
+			t.Fatalf("Expected pair %d to be %s, got %s", i, name, pairs[i].ConsulConfig.ServiceName)
+		}
+		// defaults must be applied to every pair
+		if pairs[i].ConsulConfig.ClientConfig == nil {
+			t.Fatalf("Missing default consul client config for pair %s", name)
+		}
+	}
+	if cfg.WorkerPoolSize != 2 {
+		t.Fatalf("Expected worker_pool_size to be merged, got %d", cfg.WorkerPoolSize)
+	}
+	if cfg.WorkQueue.Workers != 3 {
+		t.Fatalf("Expected work_queue to be merged, got %+v", cfg.WorkQueue)
+	}
+	if !cfg.ConsulRegistration.Enabled || cfg.ConsulRegistration.ServiceName != "targetsync" {
+		t.Fatalf("Expected consul_registration to be merged, got %+v", cfg.ConsulRegistration)
+	}
+}
+
+func TestConfigIncludes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "targetsync")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.Mkdir(filepath.Join(dir, "pairs"), 0755); err != nil {
+		t.Fatalf("Error creating pairs dir: %v", err)
+	}
+
+	files := map[string]string{
+		"config.yaml": `
+worker_pool_size: 2
+include:
+  - pairs/*.yaml
+`,
+		"pairs/a.yaml": `
+name: a
+consul:
+  service_name: a
+syncer:
+  lock_options:
+    key: a
+    ttl: 10s
+`,
+		"pairs/b.yaml": `
+name: b
+consul:
+  service_name: b
+syncer:
+  lock_options:
+    key: b
+    ttl: 10s
+`,
+		"nested.yaml": `
+include:
+  - config.yaml
+`,
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Error writing config: %v", err)
+		}
+	}
+
+	cfg, err := ConfigFromFile(filepath.Join(dir, "config.yaml"))
+	if err != nil {
+		t.Fatalf("Error loading config: %v", err)
+	}
+	pairs := cfg.SyncPairs()
+	if len(pairs) != 2 {
+		t.Fatalf("Expected 2 pairs, got %d", len(pairs))
+	}
+	for i, name := range []string{"a", "b"} {
+		if pairs[i].Name != name {
+			t.Fatalf("Expected pair %d to be %s, got %s", i, name, pairs[i].Name)
+		}
+	}
+	if cfg.WorkerPoolSize != 2 {
+		t.Fatalf("Expected worker_pool_size to be kept, got %d", cfg.WorkerPoolSize)
+	}
+
+	if _, err := ConfigFromFile(filepath.Join(dir, "nested.yaml")); err == nil {
+		t.Fatalf("Expected an error for nested includes")
+	}
+}
+
+func TestConfigPairNames(t *testing.T) {
+	tests := []struct {
+		names []string
+		err   bool
+	}{
+		{names: []string{""}},
+		{names: []string{"a", "b"}},
+		// Unnamed pairs are named by their lock key
+		{names: []string{"a", ""}},
+		{names: []string{"", ""}},
+		{names: []string{"key1", ""}, err: true},
+		{names: []string{"a", "a"}, err: true},
+	}
+
+	for i, test := range tests {
+		cfg := &Config{}
+		for j, name := range test.names {
+			pair := defaultPairConfig()
+			pair.Name = name
+			pair.ConsulConfig.ServiceName = name
+			pair.SyncConfig.LockOptions = LockOptions{Key: fmt.Sprintf("key%d", j), TTL: time.Second}
+			cfg.Pairs = append(cfg.Pairs, &pair)
+		}
+		if err := cfg.Validate(); (err != nil) != test.err {
+			t.Fatalf("%d: unexpected validation result: %v", i, err)
+		}
+	}
+}
+
+func TestConfigTraefikPaths(t *testing.T) {
+	tests := []struct {
+		paths []string
+		err   bool
+	}{
+		{paths: []string{"/traefik/a", "/traefik/b"}},
+		{paths: []string{"/traefik", ""}},
+		{paths: []string{"/traefik", "/traefik"}, err: true},
+	}
+
+	for i, test := range tests {
+		cfg := &Config{}
+		for j, path := range test.paths {
+			pair := defaultPairConfig()
+			pair.Name = fmt.Sprintf("pair%d", j)
+			pair.ConsulConfig.ServiceName = "web"
+			pair.TraefikConfig.ServiceName = "web"
+			pair.TraefikConfig.HTTPPath = path
+			pair.SyncConfig.LockOptions = LockOptions{Key: fmt.Sprintf("key%d", j), TTL: time.Second}
+			cfg.Pairs = append(cfg.Pairs, &pair)
+		}
+		if err := cfg.Validate(); (err != nil) != test.err {
+			t.Fatalf("%d: unexpected validation result: %v", i, err)
+		}
+	}
+}
+
+func TestSyncConfigRemoveValidation(t *testing.T) {
+	tests := []struct {
+		cfg SyncConfig
+		err bool
+	}{
+		{cfg: SyncConfig{RemoveDelay: time.Minute, RemoveQueueSize: 1000}},
+		{cfg: SyncConfig{RemoveDelay: -time.Second}, err: true},
+		{cfg: SyncConfig{RemoveQueueSize: -1}, err: true},
+		{cfg: SyncConfig{RemoveRetry: RemoveRetryConfig{MaxAttempts: -1}}, err: true},
+	}
+
+	for i, test := range tests {
+		test.cfg.LockOptions = LockOptions{Key: "a", TTL: time.Second}
+		if err := test.cfg.Validate(); (err != nil) != test.err {
+			t.Fatalf("%d: unexpected validation result: %v", i, err)
+		}
+	}
+}
+
+func TestConsulGatewayValidation(t *testing.T) {
+	tests := []struct {
+		gateway ConsulGatewayConfig
+		connect bool
+		err     bool
+	}{
+		{},
+		{gateway: ConsulGatewayConfig{ServiceName: "mesh-gateway", TaggedAddress: "wan"}},
+		{gateway: ConsulGatewayConfig{TaggedAddress: "wan"}, err: true},
+		{gateway: ConsulGatewayConfig{ServiceName: "mesh-gateway"}, connect: true, err: true},
+	}
+
+	for i, test := range tests {
+		cfg := defaultPairConfig().ConsulConfig
+		cfg.Gateway = test.gateway
+		cfg.Connect = test.connect
+		if err := cfg.Validate(); (err != nil) != test.err {
+			t.Fatalf("%d: unexpected validation result: %v", i, err)
+		}
+	}
+}
+
+func TestConfigPipelines(t *testing.T) {
+	f, err := ioutil.TempFile("", "targetsync")
+	if err != nil {
+		t.Fatalf("Error creating temp file: %v", err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`
+filters:
+  web:
+    meta:
+      role: web
+  https:
+    static_port: 443
+pipelines:
+  - name: web
+    consul:
+      service_name: web
+    filters: [web, https]
+    syncer:
+      lock_options:
+        key: web
+        ttl: 10s
+      remove_delay: 20s
+    destinations:
+      - name: aws
+        aws:
+          target_group_arn: arn
+      - name: traefik
+        traefik:
+          service_name: web
+        syncer:
+          remove_delay: 5s
+`)
+	f.Close()
+
+	cfg, err := ConfigFromFile(f.Name())
+	if err != nil {
+		t.Fatalf("Error loading config: %v", err)
+	}
+	pairs := cfg.SyncPairs()
+	if len(pairs) != 2 {
+		t.Fatalf("Expected 2 pairs, got %d", len(pairs))
+	}
+	aws, traefik := pairs[0], pairs[1]
+	if aws.Name != "web/aws" || aws.SyncConfig.LockOptions.Key != "web/aws" {
+		t.Fatalf("Unexpected pair name=%s key=%s", aws.Name, aws.SyncConfig.LockOptions.Key)
+	}
+	if aws.ConsulConfig.ServiceName != "web" || aws.AWSConfig.TargetGroupARN != "arn" || aws.TraefikConfig.ServiceName != "" {
+		t.Fatalf("Unexpected source/destination for pair %s", aws.Name)
+	}
+	if aws.SyncConfig.RemoveDelay != 20*time.Second || traefik.SyncConfig.RemoveDelay != 5*time.Second {
+		t.Fatalf("Unexpected remove_delay %v and %v", aws.SyncConfig.RemoveDelay, traefik.SyncConfig.RemoveDelay)
+	}
+	if traefik.SyncConfig.LockOptions.TTL != 10*time.Second {
+		t.Fatalf("Syncer options not inherited from the pipeline")
+	}
+	if len(traefik.SyncConfig.Filters) != 2 || traefik.SyncConfig.Filters[1].StaticPort != 443 {
+		t.Fatalf("Unexpected filter chain: %+v", traefik.SyncConfig.Filters)
+	}
+}
+
+func TestConfigPipelineRoutes(t *testing.T) {
+	f, err := ioutil.TempFile("", "targetsync")
+	if err != nil {
+		t.Fatalf("Error creating temp file: %v", err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`
+pipelines:
+  - name: web
+    consul:
+      service_name: web
+    destinations:
+      - name: https
+        match:
+          ports: [443]
+        aws:
+          target_group_arn: arn:a
+      - name: admin
+        match:
+          ports: [8443]
+          meta:
+            role: admin
+        aws:
+          target_group_arn: arn:b
+`)
+	f.Close()
+
+	cfg, err := ConfigFromFile(f.Name())
+	if err != nil {
+		t.Fatalf("Error loading config: %v", err)
+	}
+	pairs := cfg.SyncPairs()
+	if len(pairs) != 2 {
+		t.Fatalf("Expected 2 pairs, got %d", len(pairs))
+	}
+
+	src := []*Target{
+		{IP: "10.0.0.1", Port: 443, Meta: map[string]string{}},
+		{IP: "10.0.0.1", Port: 8443, Meta: map[string]string{"role": "admin"}},
+		{IP: "10.0.0.2", Port: 8443, Meta: map[string]string{}},
+		{IP: "10.0.0.3", Port: 80, Meta: map[string]string{}},
+	}
+	routed := func(pair *PairConfig) []*Target {
+		targets := src
+		for _, filter := range pair.SyncConfig.Filters {
+			targets = filter.Apply(targets)
+		}
+		return targets
+	}
+	if err := equalTargets(routed(pairs[0]), src[:1]); err != nil {
+		t.Fatalf("Unexpected targets routed to %s: %v", pairs[0].Name, err)
+	}
+	if err := equalTargets(routed(pairs[1]), src[1:2]); err != nil {
+		t.Fatalf("Unexpected targets routed to %s: %v", pairs[1].Name, err)
+	}
+
+	// Typos in the match must not silently route every target
+	invalid := &PipelineConfig{
+		Name: "web",
+		Destinations: []yaml.MapSlice{{
+			yaml.MapItem{Key: "name", Value: "https"},
+			yaml.MapItem{Key: "match", Value: map[string]interface{}{"port": 443}},
+		}},
+	}
+	if _, err := invalid.pairs(nil); err == nil {
+		t.Fatalf("Expected an error for an unknown match option")
+	}
+}
+
+func TestLockKeyFromDestination(t *testing.T) {
+	newPair := func(arn, key, tmpl string) *PairConfig {
+		pair := defaultPairConfig()
+		pair.AWSConfig.TargetGroupARN = arn
+		pair.SyncConfig.LockOptions = LockOptions{Key: key, KeyTemplate: tmpl}
+		return &pair
+	}
+
+	a, b := newPair("arn:a", "", ""), newPair("arn:a", "", "")
+	for _, pair := range []*PairConfig{a, b} {
+		if err := pair.resolveLockKey(); err != nil {
+			t.Fatalf("Error resolving lock key: %v", err)
+		}
+	}
+	if a.SyncConfig.LockOptions.Key != b.SyncConfig.LockOptions.Key || !strings.HasPrefix(a.SyncConfig.LockOptions.Key, "targetsync/aws/") {
+		t.Fatalf("Expected the same generated keys, got %s and %s", a.SyncConfig.LockOptions.Key, b.SyncConfig.LockOptions.Key)
+	}
+	other := newPair("arn:b", "", "")
+	if err := other.resolveLockKey(); err != nil || other.SyncConfig.LockOptions.Key == a.SyncConfig.LockOptions.Key {
+		t.Fatalf("Expected a different key for another target group, got %s: %v", other.SyncConfig.LockOptions.Key, err)
+	}
+
+	tmpl := newPair("arn:a", "", "locks/{{.ID}}")
+	if err := tmpl.resolveLockKey(); err != nil || tmpl.SyncConfig.LockOptions.Key != "locks/arn:a" {
+		t.Fatalf("Unexpected templated key %s: %v", tmpl.SyncConfig.LockOptions.Key, err)
+	}
+	explicit := newPair("arn:a", "mine", "")
+	if err := explicit.resolveLockKey(); err != nil || explicit.SyncConfig.LockOptions.Key != "mine" {
+		t.Fatalf("Expected the explicit key to be kept, got %s: %v", explicit.SyncConfig.LockOptions.Key, err)
+	}
+	for _, bad := range []string{"{{.Missing}}", "{{"} {
+		if err := newPair("arn:a", "", bad).resolveLockKey(); err == nil {
+			t.Fatalf("Expected an error for template %q", bad)
+		}
+	}
+	// The fake destination has no identity to generate the key from
+	fake := newPair("", "", "locks/{{.ID}}")
+	fake.FakeDestinationConfig.Enabled = true
+	if err := fake.resolveLockKey(); err == nil {
+		t.Fatalf("Expected an error templating the key of the fake destination")
+	}
+}
@@ -2,15 +2,17 @@ package targetsync
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
 
 	consulApi "github.com/hashicorp/consul/api"
-	"github.com/sirupsen/logrus"
 )
 
 // NewConsulSource returns a new ConsulSource
 func NewConsulSource(cfg *ConsulConfig) (*ConsulSource, error) {
-	consulCfg := consulApi.DefaultConfig()
-	client, err := consulApi.NewClient(consulCfg)
+	client, err := consulClient(cfg.ClientConfig, &cfg.TLS, cfg.Namespace, cfg.Partition)
 	if err != nil {
 		return nil, err
 	}
@@ -19,27 +21,66 @@ func NewConsulSource(cfg *ConsulConfig) (*ConsulSource, error) {
 		cfg:          cfg,
 		client:       client,
 		healthClient: client.Health(),
+		lastSuccess:  time.Now(),
 	}, nil
 }
 
 // ConsulSource is an implementation for talkint to consul for both `TargetSource` and `Locker`
 type ConsulSource struct {
+	// Events receives lock related events, defaults to logging them
+	Events EventSink
+
 	cfg          *ConsulConfig
 	client       *consulApi.Client
 	healthClient *consulApi.Health
+
+	// heldLocks are the sessions of locks acquired with `TryLock` by key
+	heldLocksLock sync.Mutex
+	heldLocks     map[string]*heldLock
+
+	l sync.Mutex
+	// lastSuccess is the time of the last successful query
+	lastSuccess time.Time
+	lastErr     error
+	// lockAttempts are the times of the last attempt at each lock, by key
+	lockAttempts map[string]time.Time
 }
 
-// Lock to implement the Locker interface
-func (s *ConsulSource) Lock(ctx context.Context, opts *LockOptions) (<-chan bool, error) {
-	lock, err := s.client.LockOpts(&consulApi.LockOptions{
-		Key:        opts.Key,
-		SessionTTL: opts.TTL.String(),
-	})
-	if err != nil {
-		logrus.Errorf("Error creating consul lock: %v", err)
-		return nil, err
+// log returns the source's Logger
+func (s *ConsulSource) log() Logger {
+	return loggerOr(s.cfg.Logger)
+}
+
+// lockWatchWaitTime is the max wait of the blocking queries watching the lock
+// holder, the same as consul's own lock monitoring
+const lockWatchWaitTime = 15 * time.Second
+
+// Healthy to implement the `HealthChecker` interface, the source is unhealthy
+// if queries have been failing for longer than `UnhealthyAfter`
+func (s *ConsulSource) Healthy() error {
+	if s.cfg.UnhealthyAfter <= 0 {
+		return nil
+	}
+	s.l.Lock()
+	defer s.l.Unlock()
+	if since := time.Since(s.lastSuccess); since > s.cfg.UnhealthyAfter {
+		return wrapError(ErrSourceUnavailable, fmt.Errorf("No successful consul query in %v: %v", since, s.lastErr))
 	}
+	return nil
+}
 
+// recordQuery records the result of a query for `Healthy`
+func (s *ConsulSource) recordQuery(err error) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	if err == nil {
+		s.lastSuccess = time.Now()
+	}
+	s.lastErr = err
+}
+
+// Lock to implement the Locker interface
+func (s *ConsulSource) Lock(ctx context.Context, opts *LockOptions) (<-chan bool, error) {
 	lockedCh := make(chan bool, 1)
 
 	go func() {
@@ -47,40 +88,451 @@ func (s *ConsulSource) Lock(ctx context.Context, opts *LockOptions) (<-chan bool
 		defer close(lockedCh)
 		defer cancel()
 
+		go s.watchLockHolder(ctx, opts)
+
 		stopCh := make(chan struct{})
 		go func() {
 			<-ctx.Done()
 			close(stopCh)
 		}()
 		for {
+			lockAttemptsTotal.WithLabelValues(opts.name()).Inc()
+			s.recordLockAttempt(opts)
+
+			// We manage the session ourselves (instead of letting the lock
+			// create one) so we have visibility into the session renewals
+			sessionID, _, err := s.client.Session().Create(&consulApi.SessionEntry{
+				Name:     "targetsync lock " + opts.Key,
+				TTL:      opts.TTL.String(),
+				Behavior: consulApi.SessionBehaviorRelease,
+			}, nil)
+			if err != nil {
+				s.log().Errorf("Error creating consul session: %v", err)
+				return
+			}
+			sessionCtx, sessionCancel := context.WithCancel(ctx)
+			go s.renewSession(sessionCtx, opts, sessionID)
+
+			lock, err := s.client.LockOpts(&consulApi.LockOptions{
+				Key:     opts.Key,
+				Value:   []byte(opts.Identity),
+				Session: sessionID,
+			})
+			if err != nil {
+				s.log().Errorf("Error creating consul lock: %v", err)
+				sessionCancel()
+				return
+			}
+
 			lockCh, err := lock.Lock(stopCh)
 			if err != nil {
-				logrus.Errorf("Error acquiring lock: %v", err)
+				s.log().Errorf("Error acquiring lock: %v", err)
+				sessionCancel()
+				return
+			}
+			// A nil channel means we where stopped before acquiring the lock
+			if lockCh == nil {
+				sessionCancel()
 				return
 			}
 
 			// We have the lock, start things up
-			logrus.Infof("Lock acquired")
+			s.log().Infof("Lock acquired")
 			lockedCh <- true
 
 			select {
 			case <-ctx.Done():
-				logrus.Infof("Context done, stopping lock")
+				s.log().Infof("Context done, stopping lock")
+				sessionCancel()
 				return
 			case <-lockCh:
-				logrus.Infof("Lock lost")
+				s.log().Infof("Lock lost")
 				lockedCh <- false
 			}
+			sessionCancel()
 		}
 	}()
 
 	return lockedCh, nil
 }
 
+// heldLock is a lock acquired with `TryLock`
+type heldLock struct {
+	sessionID string
+	// cancel stops renewing (and destroys) the session
+	cancel context.CancelFunc
+}
+
+// TryLock to implement the `TryLocker` interface, the lock's session is
+// renewed in the background until `Unlock` is called
+func (s *ConsulSource) TryLock(ctx context.Context, opts *LockOptions) (bool, error) {
+	s.heldLocksLock.Lock()
+	defer s.heldLocksLock.Unlock()
+	if _, ok := s.heldLocks[opts.Key]; ok {
+		return true, nil
+	}
+
+	lockAttemptsTotal.WithLabelValues(opts.name()).Inc()
+	sessionID, _, err := s.client.Session().Create(&consulApi.SessionEntry{
+		Name:     "targetsync lock " + opts.Key,
+		TTL:      opts.TTL.String(),
+		Behavior: consulApi.SessionBehaviorRelease,
+	}, (&consulApi.WriteOptions{}).WithContext(ctx))
+	if err != nil {
+		return false, wrapError(ErrLockLost, fmt.Errorf("Error creating consul session: %w", err))
+	}
+	// The session outlives this call, so isn't bound to its context
+	sessionCtx, sessionCancel := context.WithCancel(context.Background())
+	go s.renewSession(sessionCtx, opts, sessionID)
+
+	// The lock flag is set so `Lock` callers contend for the same lock
+	acquired, _, err := s.client.KV().Acquire(&consulApi.KVPair{
+		Key:     opts.Key,
+		Value:   []byte(opts.Identity),
+		Flags:   consulApi.LockFlagValue,
+		Session: sessionID,
+	}, (&consulApi.WriteOptions{}).WithContext(ctx))
+	if err != nil {
+		sessionCancel()
+		return false, wrapError(ErrLockLost, fmt.Errorf("Error acquiring lock %s: %w", opts.Key, err))
+	}
+	if !acquired {
+		sessionCancel()
+		return false, nil
+	}
+
+	s.log().Infof("Lock %s acquired", opts.Key)
+	if s.heldLocks == nil {
+		s.heldLocks = make(map[string]*heldLock)
+	}
+	s.heldLocks[opts.Key] = &heldLock{sessionID: sessionID, cancel: sessionCancel}
+	return true, nil
+}
+
+// Unlock to implement the `TryLocker` interface
+func (s *ConsulSource) Unlock(ctx context.Context, opts *LockOptions) error {
+	s.heldLocksLock.Lock()
+	defer s.heldLocksLock.Unlock()
+	held, ok := s.heldLocks[opts.Key]
+	if !ok {
+		return wrapError(ErrLockLost, fmt.Errorf("Lock %s is not held", opts.Key))
+	}
+	delete(s.heldLocks, opts.Key)
+	// The session is destroyed regardless, which also releases the lock
+	defer held.cancel()
+
+	if _, _, err := s.client.KV().Release(&consulApi.KVPair{
+		Key:     opts.Key,
+		Flags:   consulApi.LockFlagValue,
+		Session: held.sessionID,
+	}, (&consulApi.WriteOptions{}).WithContext(ctx)); err != nil {
+		return fmt.Errorf("Error releasing lock %s: %w", opts.Key, err)
+	}
+	s.log().Infof("Lock %s released", opts.Key)
+	return nil
+}
+
+// LockInfo to implement the `TryLocker` interface
+func (s *ConsulSource) LockInfo(ctx context.Context, opts *LockOptions) (*LockInfo, error) {
+	queryOpts := &consulApi.QueryOptions{RequireConsistent: true}
+	pair, _, err := s.client.KV().Get(opts.Key, queryOpts.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	if pair == nil || pair.Session == "" {
+		return &LockInfo{}, nil
+	}
+	return &LockInfo{
+		Held:         true,
+		Holder:       string(pair.Value),
+		Session:      pair.Session,
+		FencingToken: pair.LockIndex,
+	}, nil
+}
+
+// recordLockAttempt records an attempt at (or check of) the lock, for
+// `LastLockAttempt`
+func (s *ConsulSource) recordLockAttempt(opts *LockOptions) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	if s.lockAttempts == nil {
+		s.lockAttempts = make(map[string]time.Time)
+	}
+	s.lockAttempts[opts.Key] = time.Now()
+}
+
+// LastLockAttempt to implement the `LockAttemptReporter` interface. While
+// following, the lock holder is checked at least every 15s, and the lock is
+// attempted as soon as the holder goes away
+func (s *ConsulSource) LastLockAttempt(opts *LockOptions) time.Time {
+	s.l.Lock()
+	defer s.l.Unlock()
+	return s.lockAttempts[opts.Key]
+}
+
+// standbyPrefix is the KV prefix of the standby heartbeats of the lock
+func standbyPrefix(opts *LockOptions) string {
+	return opts.Key + "/standbys/"
+}
+
+// PutStandby to implement the `StandbyRegistry` interface, the heartbeat is
+// stored as JSON under `<lock key>/standbys/<identity>`
+func (s *ConsulSource) PutStandby(ctx context.Context, opts *LockOptions, hb *StandbyHeartbeat) error {
+	b, err := json.Marshal(hb)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.KV().Put(&consulApi.KVPair{
+		Key:   standbyPrefix(opts) + hb.Identity,
+		Value: b,
+	}, (&consulApi.WriteOptions{}).WithContext(ctx))
+	return err
+}
+
+// DeleteStandby to implement the `StandbyRegistry` interface
+func (s *ConsulSource) DeleteStandby(ctx context.Context, opts *LockOptions, identity string) error {
+	_, err := s.client.KV().Delete(standbyPrefix(opts)+identity, (&consulApi.WriteOptions{}).WithContext(ctx))
+	return err
+}
+
+// Standbys to implement the `StandbyRegistry` interface, heartbeats which
+// can't be decoded are skipped
+func (s *ConsulSource) Standbys(ctx context.Context, opts *LockOptions) ([]*StandbyHeartbeat, error) {
+	pairs, _, err := s.client.KV().List(standbyPrefix(opts), (&consulApi.QueryOptions{}).WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	heartbeats := make([]*StandbyHeartbeat, 0, len(pairs))
+	for _, pair := range pairs {
+		var hb StandbyHeartbeat
+		if err := json.Unmarshal(pair.Value, &hb); err != nil {
+			s.log().Warnf("Error decoding standby heartbeat %s: %v", pair.Key, err)
+			continue
+		}
+		heartbeats = append(heartbeats, &hb)
+	}
+	return heartbeats, nil
+}
+
+// renewSession renews the session until the context is done, at which point
+// the session is destroyed
+func (s *ConsulSource) renewSession(ctx context.Context, opts *LockOptions, sessionID string) {
+	defer func() {
+		if _, err := s.client.Session().Destroy(sessionID, nil); err != nil {
+			s.log().Warnf("Error destroying consul session %s: %v", sessionID, err)
+		}
+	}()
+
+	t := time.NewTicker(opts.TTL / 2)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			entry, _, err := s.client.Session().Renew(sessionID, (&consulApi.WriteOptions{}).WithContext(ctx))
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				sessionRenewalFailuresTotal.WithLabelValues(opts.name()).Inc()
+				s.emit(Event{
+					Type:    EventSessionRenewalFailed,
+					Key:     opts.Key,
+					Name:    opts.name(),
+					Time:    time.Now(),
+					Message: fmt.Sprintf("Error renewing consul session %s for lock %s: %v", sessionID, opts.Key, err),
+				})
+				continue
+			}
+			// The session is gone, so is any lock held with it
+			if entry == nil {
+				sessionRenewalFailuresTotal.WithLabelValues(opts.name()).Inc()
+				s.emit(Event{
+					Type:    EventSessionRenewalFailed,
+					Key:     opts.Key,
+					Name:    opts.name(),
+					Time:    time.Now(),
+					Message: fmt.Sprintf("Consul session %s for lock %s expired", sessionID, opts.Key),
+				})
+				return
+			}
+			sessionRenewalsTotal.WithLabelValues(opts.name()).Inc()
+		}
+	}
+}
+
+// watchLockHolder tracks the holder of the lock key until the context is done
+func (s *ConsulSource) watchLockHolder(ctx context.Context, opts *LockOptions) {
+	var holder string
+	defer func() { setLockHolder(opts.name(), holder, "") }()
+
+	var waitIndex uint64
+	for {
+		queryOpts := (&consulApi.QueryOptions{WaitIndex: waitIndex, WaitTime: lockWatchWaitTime}).WithContext(ctx)
+		pair, meta, err := s.client.KV().Get(opts.Key, queryOpts)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			s.log().Debugf("Error watching lock holder of %s: %v", opts.Key, err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+		waitIndex = meta.LastIndex
+		s.recordLockAttempt(opts)
+
+		newHolder := ""
+		if pair != nil && pair.Session != "" {
+			newHolder = string(pair.Value)
+		}
+		if newHolder != holder {
+			s.log().Infof("Lock %s holder changed from %q to %q", opts.Key, holder, newHolder)
+			setLockHolder(opts.name(), holder, newHolder)
+			holder = newHolder
+		}
+	}
+}
+
+// emit sends the event to the EventSink
+func (s *ConsulSource) emit(e Event) {
+	if s.Events == nil {
+		LogEventSink{}.Emit(e)
+		return
+	}
+	s.Events.Emit(e)
+}
+
+// serviceQuery returns the query of the named service's targets for `watch`
+func (s *ConsulSource) serviceQuery(name string) func(*consulApi.QueryOptions) ([]*Target, *consulApi.QueryMeta, error) {
+	return func(queryOpts *consulApi.QueryOptions) ([]*Target, *consulApi.QueryMeta, error) {
+		return s.query(name, queryOpts)
+	}
+}
+
+// query fetches the current targets of the service using the configured
+// QueryMode. If the result of a stale read is older than `MaxStale` it is
+// retried against the leader
+func (s *ConsulSource) query(name string, queryOpts *consulApi.QueryOptions) ([]*Target, *consulApi.QueryMeta, error) {
+	targets, meta, err := s.queryOnce(name, queryOpts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if queryOpts.AllowStale && s.cfg.MaxStale > 0 && meta.LastContact > s.cfg.MaxStale {
+		s.log().Debugf("Stale read exceeded max_stale (%v > %v), retrying against leader", meta.LastContact, s.cfg.MaxStale)
+		consistentOpts := *queryOpts
+		consistentOpts.AllowStale = false
+		// don't block, we just want the current state from the leader
+		consistentOpts.WaitIndex = 0
+		return s.queryOnce(name, &consistentOpts)
+	}
+	return targets, meta, nil
+}
+
+// queryOnce fetches the current targets of the service using the configured
+// QueryMode. With `Connect` the service's sidecar proxies are queried instead
+// of the service.
+func (s *ConsulSource) queryOnce(name string, queryOpts *consulApi.QueryOptions) ([]*Target, *consulApi.QueryMeta, error) {
+	if s.cfg.QueryMode == ConsulQueryModeAgent {
+		return s.queryAgent(name, queryOpts)
+	}
+	if s.cfg.QueryMode == ConsulQueryModeCatalog {
+		catalogQuery := s.client.Catalog().Service
+		if s.cfg.Connect {
+			catalogQuery = s.client.Catalog().Connect
+		}
+		services, meta, err := catalogQuery(name, s.cfg.Tag, queryOpts)
+		if err != nil {
+			return nil, nil, err
+		}
+		targets := make([]*Target, len(services))
+		for i, service := range services {
+			addr := service.Address
+			if service.ServiceAddress != "" {
+				addr = service.ServiceAddress
+			}
+			targets[i] = &Target{
+				IP:   addr,
+				Port: service.ServicePort,
+				Meta: withHostname(service.ServiceMeta, service.Node),
+			}
+		}
+		return targets, meta, nil
+	}
+
+	healthQuery := s.healthClient.Service
+	if s.cfg.Connect {
+		healthQuery = s.healthClient.Connect
+	}
+	services, meta, err := healthQuery(name, s.cfg.Tag, true, queryOpts)
+	if err != nil {
+		return nil, nil, err
+	}
+	targets := make([]*Target, len(services))
+	for i, entry := range services {
+		addr := entry.Node.Address
+		if entry.Service.Address != "" {
+			addr = entry.Service.Address
+		}
+		targets[i] = &Target{
+			IP:   addr,
+			Port: entry.Service.Port,
+			Meta: withHostname(entry.Service.Meta, entry.Node.Node),
+		}
+	}
+	return targets, meta, nil
+}
+
+// withHostname returns a copy of the meta with the MetaHostname set
+func withHostname(meta map[string]string, hostname string) map[string]string {
+	withHostname := make(map[string]string, len(meta)+1)
+	for k, v := range meta {
+		withHostname[k] = v
+	}
+	if hostname != "" {
+		withHostname[MetaHostname] = hostname
+	}
+	return withHostname
+}
+
+// FencingToken to implement the `FencingLocker` interface, this is the
+// LockIndex of the lock key which is incremented on every acquisition
+func (s *ConsulSource) FencingToken(ctx context.Context, opts *LockOptions) (uint64, error) {
+	queryOpts := &consulApi.QueryOptions{RequireConsistent: true}
+	pair, _, err := s.client.KV().Get(opts.Key, queryOpts.WithContext(ctx))
+	if err != nil {
+		return 0, err
+	}
+	if pair == nil || pair.Session == "" {
+		return 0, wrapError(ErrLockLost, fmt.Errorf("Lock %s is not held", opts.Key))
+	}
+	return pair.LockIndex, nil
+}
+
 // Subscribe to implement the `TargetSource` interface
 func (s *ConsulSource) Subscribe(ctx context.Context) (chan []*Target, error) {
+	if s.cfg.Gateway.ServiceName != "" {
+		return s.subscribeGateway(ctx), nil
+	}
+	if len(s.cfg.ServiceNames) > 0 || s.cfg.ServicePattern != "" {
+		return s.subscribeAggregate(ctx)
+	}
+	return s.watch(ctx, s.cfg.ServiceName, s.serviceQuery(s.cfg.ServiceName)), nil
+}
+
+// watch runs the blocking query, sending the targets it returns on the
+// channel whenever they change
+func (s *ConsulSource) watch(ctx context.Context, name string, query func(*consulApi.QueryOptions) ([]*Target, *consulApi.QueryMeta, error)) chan []*Target {
 	queryOpts := &consulApi.QueryOptions{
-		WaitIndex: 0,
+		WaitIndex:         0,
+		WaitTime:          s.cfg.WaitTime,
+		AllowStale:        s.cfg.Consistency == ConsulConsistencyStale,
+		RequireConsistent: s.cfg.Consistency == ConsulConsistencyConsistent,
 	}
 	queryOpts = queryOpts.WithContext(ctx)
 
@@ -89,31 +541,35 @@ func (s *ConsulSource) Subscribe(ctx context.Context) (chan []*Target, error) {
 
 	go func(ch chan []*Target) {
 		defer close(ch)
+		backoff := s.cfg.RetryBackoff
 		for {
 			select {
 			case <-ctx.Done():
 				return
 			default:
 			}
-			services, meta, err := s.healthClient.Service(s.cfg.ServiceName, s.cfg.Tag, true, queryOpts)
+			targets, meta, err := query(queryOpts)
+			s.recordQuery(err)
 			if err != nil {
-				// TODO: sleep on failure with backoff etc.
+				if ctx.Err() != nil {
+					return
+				}
+				s.log().Warnf("Error querying consul for %s, retrying in %v: %v", name, backoff, err)
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(backoff):
+				}
+				backoff *= 2
+				if backoff > s.cfg.MaxRetryBackoff {
+					backoff = s.cfg.MaxRetryBackoff
+				}
 				continue
 			}
+			backoff = s.cfg.RetryBackoff
 
 			// If there was a change
 			if meta.LastIndex != queryOpts.WaitIndex {
-				targets := make([]*Target, len(services))
-				for i, entry := range services {
-					addr := entry.Node.Address
-					if entry.Service.Address != "" {
-						addr = entry.Service.Address
-					}
-					targets[i] = &Target{
-						IP:   addr,
-						Port: entry.Service.Port,
-					}
-				}
 				ch <- targets
 			}
 
@@ -121,5 +577,88 @@ func (s *ConsulSource) Subscribe(ctx context.Context) (chan []*Target, error) {
 		}
 	}(ch)
 
-	return ch, nil
+	return ch
+}
+
+// SubscribeDeltas to implement the `TargetDeltaSource` interface
+func (s *ConsulSource) SubscribeDeltas(ctx context.Context) (chan *TargetDelta, error) {
+	ch, err := s.Subscribe(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return deltasFromSnapshots(ctx, ch), nil
+}
+
+// subscribeGateway watches both the service and its gateway, sending the
+// gateway's instances while the service has instances (and none otherwise)
+func (s *ConsulSource) subscribeGateway(ctx context.Context) chan []*Target {
+	serviceCh := s.watch(ctx, s.cfg.ServiceName, s.serviceQuery(s.cfg.ServiceName))
+	gatewayCh := s.watch(ctx, s.cfg.Gateway.ServiceName, s.queryGateway)
+	ch := make(chan []*Target, 100)
+	go func() {
+		defer close(ch)
+		var services, gateways []*Target
+		var haveServices, haveGateways bool
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case targets, ok := <-serviceCh:
+				if !ok {
+					return
+				}
+				// Only the service's existence matters
+				if haveServices && (len(targets) > 0) == (len(services) > 0) {
+					services = targets
+					continue
+				}
+				services, haveServices = targets, true
+			case targets, ok := <-gatewayCh:
+				if !ok {
+					return
+				}
+				gateways, haveGateways = targets, true
+			}
+			if !haveServices || !haveGateways {
+				continue
+			}
+			if len(services) == 0 {
+				ch <- []*Target{}
+			} else {
+				ch <- gateways
+			}
+		}
+	}()
+	return ch
+}
+
+// queryGateway fetches the passing instances of the gateway, at their
+// `TaggedAddress` if set
+func (s *ConsulSource) queryGateway(queryOpts *consulApi.QueryOptions) ([]*Target, *consulApi.QueryMeta, error) {
+	gateway := &s.cfg.Gateway
+	entries, meta, err := s.healthClient.Service(gateway.ServiceName, gateway.Tag, true, queryOpts)
+	if err != nil {
+		return nil, nil, err
+	}
+	targets := make([]*Target, 0, len(entries))
+	for _, entry := range entries {
+		addr, port := entry.Node.Address, entry.Service.Port
+		if entry.Service.Address != "" {
+			addr = entry.Service.Address
+		}
+		if gateway.TaggedAddress != "" {
+			tagged, ok := entry.Service.TaggedAddresses[gateway.TaggedAddress]
+			if !ok {
+				s.log().Warnf("Gateway %s on %s has no %s address, skipping it", gateway.ServiceName, entry.Node.Node, gateway.TaggedAddress)
+				continue
+			}
+			addr, port = tagged.Address, tagged.Port
+		}
+		targets = append(targets, &Target{
+			IP:   addr,
+			Port: port,
+			Meta: withHostname(entry.Service.Meta, entry.Node.Node),
+		})
+	}
+	return targets, meta, nil
 }
@@ -0,0 +1,102 @@
+package targetsync
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"time"
+
+	consulApi "github.com/hashicorp/consul/api"
+)
+
+// defaultConsulAgentPollInterval is how often the local agent is polled with
+// the agent `QueryMode` if `WaitTime` isn't set
+const defaultConsulAgentPollInterval = 10 * time.Second
+
+// queryAgent fetches the passing instances of the service registered with the
+// local agent, those whose own and node checks are all passing. The agent's
+// endpoints don't support blocking queries, so after the first query the
+// agent is polled every `WaitTime`. The returned index only changes when the
+// targets do.
+func (s *ConsulSource) queryAgent(name string, queryOpts *consulApi.QueryOptions) ([]*Target, *consulApi.QueryMeta, error) {
+	if queryOpts.WaitIndex != 0 {
+		interval := s.cfg.WaitTime
+		if interval <= 0 {
+			interval = defaultConsulAgentPollInterval
+		}
+		select {
+		case <-queryOpts.Context().Done():
+			return nil, nil, queryOpts.Context().Err()
+		case <-time.After(interval):
+		}
+	}
+
+	agent := s.client.Agent()
+	self, err := agent.Self()
+	if err != nil {
+		return nil, nil, err
+	}
+	nodeName, _ := self["Config"]["NodeName"].(string)
+	nodeAddr, _ := self["Config"]["AdvertiseAddr"].(string)
+	services, err := agent.Services()
+	if err != nil {
+		return nil, nil, err
+	}
+	checks, err := agent.Checks()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Node checks (without a service) apply to all of the services
+	failing := make(map[string]struct{})
+	nodeFailing := false
+	for _, check := range checks {
+		if check.Status == consulApi.HealthPassing {
+			continue
+		}
+		if check.ServiceID == "" {
+			nodeFailing = true
+		}
+		failing[check.ServiceID] = struct{}{}
+	}
+
+	targets := make([]*Target, 0)
+	if !nodeFailing {
+		for id, service := range services {
+			if service.Service != name || (s.cfg.Tag != "" && !hasTag(service.Tags, s.cfg.Tag)) {
+				continue
+			}
+			if _, ok := failing[id]; ok {
+				continue
+			}
+			addr := nodeAddr
+			if service.Address != "" {
+				addr = service.Address
+			}
+			targets = append(targets, &Target{
+				IP:   addr,
+				Port: service.Port,
+				Meta: withHostname(service.Meta, nodeName),
+			})
+		}
+	}
+	return targets, &consulApi.QueryMeta{LastIndex: targetsIndex(targets)}, nil
+}
+
+// targetsIndex returns a (non-zero) hash of the targets, which only changes
+// when they do
+func targetsIndex(targets []*Target) uint64 {
+	keys := make([]string, len(targets))
+	for i, target := range targets {
+		keys[i] = fmt.Sprintf("%s %v", target.Key(), target.Meta)
+	}
+	sort.Strings(keys)
+	h := fnv.New64a()
+	for _, key := range keys {
+		fmt.Fprintln(h, key)
+	}
+	if index := h.Sum64(); index != 0 {
+		return index
+	}
+	return 1
+}
@@ -0,0 +1,23 @@
+package targetsync
+
+import "testing"
+
+func TestTargetsIndex(t *testing.T) {
+	a := &Target{IP: "10.0.0.1", Port: 80, Meta: map[string]string{"hostname": "a"}}
+	b := &Target{IP: "10.0.0.2", Port: 80, Meta: map[string]string{"hostname": "b"}}
+
+	if targetsIndex(nil) == 0 {
+		t.Fatalf("The index of no targets must not be 0")
+	}
+	// The index doesn't depend on the order of the targets
+	if targetsIndex([]*Target{a, b}) != targetsIndex([]*Target{b, a}) {
+		t.Fatalf("Index changed with the order of the targets")
+	}
+	if targetsIndex([]*Target{a, b}) == targetsIndex([]*Target{a}) {
+		t.Fatalf("Index didn't change when a target was removed")
+	}
+	moved := &Target{IP: "10.0.0.2", Port: 80, Meta: map[string]string{"hostname": "c"}}
+	if targetsIndex([]*Target{a, b}) == targetsIndex([]*Target{a, moved}) {
+		t.Fatalf("Index didn't change when a target's metadata did")
+	}
+}
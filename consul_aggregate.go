@@ -0,0 +1,250 @@
+package targetsync
+
+import (
+	"context"
+	"regexp"
+	"sort"
+	"time"
+
+	consulApi "github.com/hashicorp/consul/api"
+)
+
+// MetaConsulService is the target metadata key for the consul service the
+// target is an instance of, set when the instances of multiple services are
+// aggregated
+const MetaConsulService = "consul/service"
+
+// consulServiceUpdate is the targets of one of the aggregated services, from
+// the watch with the id
+type consulServiceUpdate struct {
+	name    string
+	id      int
+	targets []*Target
+}
+
+// consulServiceWatch is the watch of one of the aggregated services
+type consulServiceWatch struct {
+	id     int
+	cancel context.CancelFunc
+}
+
+// subscribeAggregate watches each of the `ServiceName`, `ServiceNames` and
+// the services in the catalog matching `ServicePattern`, sending their merged
+// instances. The merged targets are only sent once every service has been
+// queried, so a newly watched service doesn't momentarily drop the others.
+func (s *ConsulSource) subscribeAggregate(ctx context.Context) (chan []*Target, error) {
+	var pattern *regexp.Regexp
+	if s.cfg.ServicePattern != "" {
+		var err error
+		if pattern, err = compileServicePattern(s.cfg.ServicePattern); err != nil {
+			return nil, err
+		}
+	}
+	static := s.cfg.serviceNames()
+
+	var matchedCh chan []string
+	if pattern != nil {
+		matchedCh = s.watchCatalog(ctx, pattern)
+	}
+	updates := make(chan consulServiceUpdate)
+	ch := make(chan []*Target, 100)
+	go func() {
+		defer close(ch)
+		watches := make(map[string]*consulServiceWatch)
+		latest := make(map[string][]*Target)
+		defer func() {
+			for _, w := range watches {
+				w.cancel()
+			}
+		}()
+		nextID := 0
+		// setServices starts and stops the watches to match the services,
+		// returning whether any changed
+		setServices := func(matched []string) bool {
+			wanted := make(map[string]struct{}, len(static)+len(matched))
+			for _, name := range static {
+				wanted[name] = struct{}{}
+			}
+			for _, name := range matched {
+				wanted[name] = struct{}{}
+			}
+			changed := false
+			for name, w := range watches {
+				if _, ok := wanted[name]; !ok {
+					s.log().Infof("No longer aggregating consul service %s", name)
+					w.cancel()
+					delete(watches, name)
+					delete(latest, name)
+					changed = true
+				}
+			}
+			for name := range wanted {
+				if _, ok := watches[name]; ok {
+					continue
+				}
+				s.log().Infof("Aggregating consul service %s", name)
+				nextID++
+				watchCtx, cancel := context.WithCancel(ctx)
+				watches[name] = &consulServiceWatch{id: nextID, cancel: cancel}
+				go s.forwardService(watchCtx, name, nextID, updates)
+				changed = true
+			}
+			return changed
+		}
+		// Without a pattern only the static services are watched, otherwise
+		// the first catalog query sets the services even if none match
+		matchedOnce := pattern == nil
+		if pattern == nil {
+			setServices(nil)
+		}
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case matched, ok := <-matchedCh:
+				if !ok {
+					return
+				}
+				if !setServices(matched) && matchedOnce {
+					continue
+				}
+				matchedOnce = true
+			case update := <-updates:
+				// Ignore updates from stopped watches
+				if w, ok := watches[update.name]; !ok || w.id != update.id {
+					continue
+				}
+				latest[update.name] = update.targets
+			}
+			if len(latest) < len(watches) {
+				continue
+			}
+			select {
+			case ch <- mergeServiceTargets(latest):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// forwardService watches the service, sending its targets (labelled with the
+// service) as updates until the context is done
+func (s *ConsulSource) forwardService(ctx context.Context, name string, id int, updates chan<- consulServiceUpdate) {
+	for targets := range s.watch(ctx, name, s.serviceQuery(name)) {
+		for _, target := range targets {
+			target.Meta[MetaConsulService] = name
+		}
+		select {
+		case updates <- consulServiceUpdate{name: name, id: id, targets: targets}:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// mergeServiceTargets merges the targets of the services, a target which is
+// an instance of several services is only included once (labelled with the
+// first service by name)
+func mergeServiceTargets(services map[string][]*Target) []*Target {
+	names := make([]string, 0, len(services))
+	for name := range services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	merged := make([]*Target, 0)
+	seen := make(map[string]struct{})
+	for _, name := range names {
+		for _, target := range services[name] {
+			if _, ok := seen[target.Key()]; ok {
+				continue
+			}
+			seen[target.Key()] = struct{}{}
+			merged = append(merged, target)
+		}
+	}
+	return merged
+}
+
+// compileServicePattern compiles the `ServicePattern`, which must match the
+// whole service name
+func compileServicePattern(pattern string) (*regexp.Regexp, error) {
+	return regexp.Compile("^(?:" + pattern + ")$")
+}
+
+// watchCatalog runs a blocking query of the catalog's services, sending the
+// names of those matching the pattern (and the `Tag`, if set) whenever they
+// change
+func (s *ConsulSource) watchCatalog(ctx context.Context, pattern *regexp.Regexp) chan []string {
+	queryOpts := &consulApi.QueryOptions{
+		WaitTime:          s.cfg.WaitTime,
+		AllowStale:        s.cfg.Consistency == ConsulConsistencyStale,
+		RequireConsistent: s.cfg.Consistency == ConsulConsistencyConsistent,
+	}
+	queryOpts = queryOpts.WithContext(ctx)
+
+	ch := make(chan []string, 1)
+	go func() {
+		defer close(ch)
+		backoff := s.cfg.RetryBackoff
+		for {
+			services, meta, err := s.client.Catalog().Services(queryOpts)
+			s.recordQuery(err)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				s.log().Warnf("Error querying consul catalog for services matching %s, retrying in %v: %v", s.cfg.ServicePattern, backoff, err)
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(backoff):
+				}
+				backoff *= 2
+				if backoff > s.cfg.MaxRetryBackoff {
+					backoff = s.cfg.MaxRetryBackoff
+				}
+				continue
+			}
+			backoff = s.cfg.RetryBackoff
+
+			if meta.LastIndex != queryOpts.WaitIndex {
+				select {
+				case ch <- matchServices(services, pattern, s.cfg.Tag):
+				case <-ctx.Done():
+					return
+				}
+			}
+			queryOpts.WaitIndex = meta.LastIndex
+		}
+	}()
+	return ch
+}
+
+// matchServices returns the names of the catalog's services matching the
+// pattern, with the tag if set
+func matchServices(services map[string][]string, pattern *regexp.Regexp, tag string) []string {
+	var matched []string
+	for name, tags := range services {
+		if !pattern.MatchString(name) {
+			continue
+		}
+		if tag != "" && !hasTag(tags, tag) {
+			continue
+		}
+		matched = append(matched, name)
+	}
+	sort.Strings(matched)
+	return matched
+}
+
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
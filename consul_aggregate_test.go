@@ -0,0 +1,58 @@
+package targetsync
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestConsulAggregate(t *testing.T) {
+	cfg := defaultPairConfig().ConsulConfig
+	cfg.ServiceName = "web"
+	cfg.ServiceNames = []string{"web-canary", "web"}
+	cfg.ServicePattern = "web-[a-z]+"
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if names := cfg.serviceNames(); !reflect.DeepEqual(names, []string{"web", "web-canary"}) {
+		t.Fatalf("Unexpected service names %v", names)
+	}
+
+	pattern, _ := compileServicePattern(cfg.ServicePattern)
+	catalog := map[string][]string{
+		"web":         nil,
+		"web-canary":  {"primary"},
+		"web-blue":    {"primary"},
+		"web-blue-v2": {"primary"},
+		"api-web-a":   {"primary"},
+	}
+	// The pattern must match the whole name
+	if matched := matchServices(catalog, pattern, ""); !reflect.DeepEqual(matched, []string{"web-blue", "web-canary"}) {
+		t.Fatalf("Unexpected matched services %v", matched)
+	}
+	if matched := matchServices(catalog, pattern, "secondary"); len(matched) != 0 {
+		t.Fatalf("Expected no services with the tag, got %v", matched)
+	}
+
+	shared := &Target{IP: "1", Port: 80, Meta: map[string]string{MetaConsulService: "web"}}
+	merged := mergeServiceTargets(map[string][]*Target{
+		"web-canary": {{IP: "1", Port: 80, Meta: map[string]string{MetaConsulService: "web-canary"}}, {IP: "2", Port: 80}},
+		"web":        {shared},
+		"web-blue":   {},
+	})
+	if err := equalTargets(merged, []*Target{{IP: "1", Port: 80}, {IP: "2", Port: 80}}); err != nil {
+		t.Fatalf("Unexpected merged targets %v: %v", merged, err)
+	}
+	if merged[0] != shared {
+		t.Fatalf("Expected the target of the first service, got %v", merged[0].Meta)
+	}
+
+	cfg.ServicePattern = "web-("
+	if err := cfg.Validate(); err == nil {
+		t.Fatalf("Expected an error for an invalid pattern")
+	}
+	cfg.ServicePattern = ""
+	cfg.Gateway.ServiceName = "mesh-gateway"
+	if err := cfg.Validate(); err == nil {
+		t.Fatalf("Expected an error for a gateway with multiple services")
+	}
+}
@@ -0,0 +1,131 @@
+package targetsync
+
+import (
+	"context"
+	"fmt"
+
+	consulApi "github.com/hashicorp/consul/api"
+)
+
+const (
+	// consulExternalSourceMeta is the node/service meta key used to mark
+	// registrations managed by targetsync
+	consulExternalSourceMeta = "external-source"
+	consulExternalSource     = "targetsync"
+)
+
+// NewConsulDestination returns a new ConsulDestination
+func NewConsulDestination(cfg *ConsulDestinationConfig) (*ConsulDestination, error) {
+	client, err := consulClient(cfg.ClientConfig, &cfg.TLS, cfg.Namespace, cfg.Partition)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ConsulDestination{
+		cfg:     cfg,
+		catalog: client.Catalog(),
+	}, nil
+}
+
+// ConsulDestination is a TargetDestination implementation which registers the
+// targets as a service on external nodes in the consul catalog
+type ConsulDestination struct {
+	cfg     *ConsulDestinationConfig
+	catalog *consulApi.Catalog
+}
+
+// nodeName returns the name of the external node for a target
+func (d *ConsulDestination) nodeName(target *Target) string {
+	return d.cfg.NodePrefix + target.IP
+}
+
+// serviceID returns the ID of the service instance for a target
+func (d *ConsulDestination) serviceID(target *Target) string {
+	return fmt.Sprintf("%s-%s", d.cfg.ServiceName, target.Key())
+}
+
+// GetTargets returns the current set of targets at the destination
+func (d *ConsulDestination) GetTargets(ctx context.Context) ([]*Target, error) {
+	queryOpts := &consulApi.QueryOptions{
+		NodeMeta: map[string]string{consulExternalSourceMeta: consulExternalSource},
+	}
+	services, _, err := d.catalog.Service(d.cfg.ServiceName, "", queryOpts.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	targets := make([]*Target, len(services))
+	for i, service := range services {
+		addr := service.Address
+		if service.ServiceAddress != "" {
+			addr = service.ServiceAddress
+		}
+		targets[i] = &Target{
+			IP:   addr,
+			Port: service.ServicePort,
+		}
+	}
+	return targets, nil
+}
+
+// AddTargets registers each target on its own external node
+func (d *ConsulDestination) AddTargets(ctx context.Context, targets []*Target) error {
+	writeOpts := (&consulApi.WriteOptions{}).WithContext(ctx)
+	for _, target := range targets {
+		reg := &consulApi.CatalogRegistration{
+			Node:    d.nodeName(target),
+			Address: target.IP,
+			NodeMeta: map[string]string{
+				consulExternalSourceMeta: consulExternalSource,
+				"external-node":          "true",
+			},
+			Service: &consulApi.AgentService{
+				ID:      d.serviceID(target),
+				Service: d.cfg.ServiceName,
+				Tags:    d.cfg.Tags,
+				Address: target.IP,
+				Port:    target.Port,
+				Meta: map[string]string{
+					consulExternalSourceMeta: consulExternalSource,
+				},
+			},
+		}
+		if _, err := d.catalog.Register(reg, writeOpts); err != nil {
+			return fmt.Errorf("Error registering %s: %v", target.Key(), err)
+		}
+	}
+	return nil
+}
+
+// RemoveTargets deregisters the service instance for each target, and the
+// target's external node once it has no services left
+func (d *ConsulDestination) RemoveTargets(ctx context.Context, targets []*Target) error {
+	writeOpts := (&consulApi.WriteOptions{}).WithContext(ctx)
+	for _, target := range targets {
+		dereg := &consulApi.CatalogDeregistration{
+			Node:      d.nodeName(target),
+			ServiceID: d.serviceID(target),
+		}
+		if _, err := d.catalog.Deregister(dereg, writeOpts); err != nil {
+			return fmt.Errorf("Error deregistering %s: %v", target.Key(), err)
+		}
+		if err := d.removeEmptyNode(ctx, d.nodeName(target)); err != nil {
+			return fmt.Errorf("Error deregistering the node of %s: %v", target.Key(), err)
+		}
+	}
+	return nil
+}
+
+// removeEmptyNode deregisters the external node if it has no services left,
+// only nodes registered by targetsync are deregistered
+func (d *ConsulDestination) removeEmptyNode(ctx context.Context, name string) error {
+	node, _, err := d.catalog.Node(name, (&consulApi.QueryOptions{}).WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	if node == nil || node.Node == nil || len(node.Services) > 0 || node.Node.Meta[consulExternalSourceMeta] != consulExternalSource {
+		return nil
+	}
+	_, err = d.catalog.Deregister(&consulApi.CatalogDeregistration{Node: name}, (&consulApi.WriteOptions{}).WithContext(ctx))
+	return err
+}
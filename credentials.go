@@ -0,0 +1,189 @@
+package targetsync
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	consulApi "github.com/hashicorp/consul/api"
+)
+
+// clientCache holds the AWS sessions and consul clients shared by all sync
+// pairs with the same credentials, so each set of credentials is only
+// constructed once
+var clientCache = struct {
+	sync.Mutex
+	aws    map[awsSessionKey]*session.Session
+	consul map[consulClientKey]*consulApi.Client
+}{
+	aws:    make(map[awsSessionKey]*session.Session),
+	consul: make(map[consulClientKey]*consulApi.Client),
+}
+
+type awsSessionKey struct {
+	region string
+	creds  AWSCredentialsConfig
+}
+
+// awsSession returns the (cached) session for the region, assuming the role
+// in `creds` if set. The role is only assumed once the session is first used,
+// and is refreshed before it expires.
+func awsSession(region string, creds AWSCredentialsConfig) (*session.Session, error) {
+	clientCache.Lock()
+	defer clientCache.Unlock()
+
+	key := awsSessionKey{region: region, creds: creds}
+	if sess, ok := clientCache.aws[key]; ok {
+		return sess, nil
+	}
+
+	awsCfg := aws.NewConfig()
+	if region != "" {
+		awsCfg = awsCfg.WithRegion(region)
+	}
+	sess, err := session.NewSession(awsCfg)
+	if err != nil {
+		return nil, err
+	}
+	if creds.RoleARN != "" {
+		sess = sess.Copy(&aws.Config{
+			Credentials: stscreds.NewCredentials(sess, creds.RoleARN, func(p *stscreds.AssumeRoleProvider) {
+				if creds.ExternalID != "" {
+					p.ExternalID = aws.String(creds.ExternalID)
+				}
+			}),
+		})
+	}
+	instrumentAWSSession(sess)
+	clientCache.aws[key] = sess
+	return sess, nil
+}
+
+type consulClientKey struct {
+	address    string
+	scheme     string
+	datacenter string
+	token      string
+	namespace  string
+	partition  string
+	tls        consulApi.TLSConfig
+	mtls       string
+}
+
+// consulClient returns the (cached) consul client for the config, defaulting
+// to `consulApi.DefaultConfig()`. Requests are made in the (enterprise)
+// `namespace` and admin `partition` if set, and over (mutual) TLS if `tlsCfg`
+// is set. Clients with a custom HttpClient or HttpAuth aren't cached.
+func consulClient(cfg *consulApi.Config, tlsCfg *TLSConfig, namespace, partition string) (*consulApi.Client, error) {
+	if cfg == nil {
+		cfg = consulApi.DefaultConfig()
+	}
+	// Copy the config, as NewClient modifies it
+	clientCfg := *cfg
+	cfg = &clientCfg
+	cacheable := cfg.HttpClient == nil && cfg.HttpAuth == nil
+	if tlsCfg == nil {
+		tlsCfg = &TLSConfig{}
+	}
+
+	clientCache.Lock()
+	defer clientCache.Unlock()
+
+	key := consulClientKey{
+		address:    cfg.Address,
+		scheme:     cfg.Scheme,
+		datacenter: cfg.Datacenter,
+		token:      cfg.Token,
+		namespace:  namespace,
+		partition:  partition,
+		tls:        cfg.TLSConfig,
+		mtls:       tlsCfg.key(),
+	}
+	if cacheable {
+		if client, ok := clientCache.consul[key]; ok {
+			return client, nil
+		}
+	}
+
+	if tlsCfg.enabled() && cfg.HttpClient == nil {
+		host, _, err := net.SplitHostPort(cfg.Address)
+		if err != nil {
+			host = cfg.Address
+		}
+		clientTLS, err := newClientTLSConfig(tlsCfg, host)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Scheme = "https"
+		cfg.HttpClient = &http.Client{
+			Transport: &http.Transport{
+				Proxy:               http.ProxyFromEnvironment,
+				TLSClientConfig:     clientTLS,
+				TLSHandshakeTimeout: 10 * time.Second,
+				IdleConnTimeout:     90 * time.Second,
+				MaxIdleConnsPerHost: 2,
+			},
+		}
+	}
+
+	if namespace != "" || partition != "" {
+		httpClient := cfg.HttpClient
+		if httpClient == nil {
+			var err error
+			if httpClient, err = consulApi.NewHttpClient(cfg.Transport, cfg.TLSConfig); err != nil {
+				return nil, err
+			}
+		}
+		transport := httpClient.Transport
+		if transport == nil {
+			transport = http.DefaultTransport
+		}
+		cfg.HttpClient = &http.Client{
+			Transport: &tenancyTransport{
+				namespace: namespace,
+				partition: partition,
+				next:      transport,
+			},
+			Timeout: httpClient.Timeout,
+		}
+	}
+
+	client, err := consulApi.NewClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if cacheable {
+		clientCache.consul[key] = client
+	}
+	return client, nil
+}
+
+// tenancyTransport sets the consul namespace and partition on all requests,
+// as the consul client doesn't support them
+type tenancyTransport struct {
+	namespace string
+	partition string
+	next      http.RoundTripper
+}
+
+func (t *tenancyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// RoundTrippers must not modify the request
+	r := new(http.Request)
+	*r = *req
+	u := *req.URL
+	r.URL = &u
+
+	q := u.Query()
+	if t.namespace != "" {
+		q.Set("ns", t.namespace)
+	}
+	if t.partition != "" {
+		q.Set("partition", t.partition)
+	}
+	u.RawQuery = q.Encode()
+	return t.next.RoundTrip(r)
+}
@@ -0,0 +1,103 @@
+package targetsync
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	consulApi "github.com/hashicorp/consul/api"
+	yaml "gopkg.in/yaml.v2"
+)
+
+func TestPairCredentials(t *testing.T) {
+	var pair PairConfig
+	if err := yaml.Unmarshal([]byte(`
+credentials:
+  aws:
+    role_arn: arn:aws:iam::123456789012:role/a
+  consul:
+    token: secret
+    namespace: team-a
+`), &pair); err != nil {
+		t.Fatalf("Error unmarshaling: %v", err)
+	}
+	if pair.AWSConfig.Credentials.RoleARN != "arn:aws:iam::123456789012:role/a" {
+		t.Fatalf("Role not set on AWS config: %+v", pair.AWSConfig.Credentials)
+	}
+	if pair.ConsulConfig.ClientConfig.Token != "secret" || pair.ConsulDestinationConfig.ClientConfig.Token != "secret" {
+		t.Fatalf("Token not set on consul configs")
+	}
+	if pair.ConsulDestinationConfig.Namespace != "team-a" {
+		t.Fatalf("Namespace not set on consul destination config")
+	}
+
+	a, err := awsSession("us-west-2", pair.Credentials.AWS)
+	if err != nil {
+		t.Fatalf("Error creating session: %v", err)
+	}
+	b, _ := awsSession("us-west-2", pair.Credentials.AWS)
+	c, _ := awsSession("us-west-2", AWSCredentialsConfig{})
+	if a != b {
+		t.Fatalf("Session for the same credentials not cached")
+	}
+	if a == c {
+		t.Fatalf("Session shared across credentials")
+	}
+}
+
+func TestConsulClientTenancy(t *testing.T) {
+	var namespace, partition, token string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		namespace = r.URL.Query().Get("ns")
+		partition = r.URL.Query().Get("partition")
+		token = r.Header.Get("X-Consul-Token")
+		w.Write([]byte(`"127.0.0.1:8300"`))
+	}))
+	defer srv.Close()
+
+	cfg := consulApi.DefaultConfig()
+	cfg.Address = strings.TrimPrefix(srv.URL, "http://")
+	cfg.Token = "secret"
+	client, err := consulClient(cfg, nil, "team-a", "a")
+	if err != nil {
+		t.Fatalf("Error creating client: %v", err)
+	}
+	if _, err := client.Status().Leader(); err != nil {
+		t.Fatalf("Error querying consul: %v", err)
+	}
+	if namespace != "team-a" || partition != "a" || token != "secret" {
+		t.Fatalf("Unexpected namespace=%q partition=%q token=%q", namespace, partition, token)
+	}
+
+	if cached, _ := consulClient(cfg, nil, "team-a", "a"); cached != client {
+		t.Fatalf("Client for the same credentials not cached")
+	}
+	if other, _ := consulClient(cfg, nil, "team-b", "a"); other == client {
+		t.Fatalf("Client shared across namespaces")
+	}
+}
+
+func TestInlinePairCredentials(t *testing.T) {
+	f, err := ioutil.TempFile("", "targetsync")
+	if err != nil {
+		t.Fatalf("Error creating temp file: %v", err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`
+credentials:
+  aws:
+    role_arn: arn:aws:iam::123456789012:role/a
+`)
+	f.Close()
+
+	cfg, err := loadConfigFile(f.Name())
+	if err != nil {
+		t.Fatalf("Error loading config: %v", err)
+	}
+	if cfg.PairConfig.AWSConfig.Credentials.RoleARN != "arn:aws:iam::123456789012:role/a" {
+		t.Fatalf("Role not set on AWS config of the inline pair")
+	}
+}
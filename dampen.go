@@ -0,0 +1,186 @@
+package targetsync
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// targetSetKey returns a comparable key for a set of targets
+func targetSetKey(targets []*Target) map[string]struct{} {
+	m := make(map[string]struct{}, len(targets))
+	for _, target := range targets {
+		m[target.Key()] = struct{}{}
+	}
+	return m
+}
+
+// targetSetsEqual returns whether the two sets contain the same targets
+func targetSetsEqual(a, b map[string]struct{}) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k := range a {
+		if _, ok := b[k]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// dampen wraps the source channel applying the hysteresis defined in
+// `DampeningConfig`. Updates are passed through as-is until the source changes
+// more than `MaxChanges` times within `Window`, at which point updates are
+// coalesced and only the latest snapshot is applied once the source has been
+// stable for `SettleTime`.
+func (s *Syncer) dampen(ctx context.Context, srcCh chan []*Target) chan []*Target {
+	cfg := s.Config.Dampening
+	if cfg.MaxChanges <= 0 {
+		return srcCh
+	}
+	settleTime := cfg.SettleTime
+	if settleTime <= 0 {
+		settleTime = cfg.Window
+	}
+
+	ch := make(chan []*Target, 1)
+	go func() {
+		defer close(ch)
+
+		var (
+			last     map[string]struct{}
+			changes  []time.Time
+			flapping bool
+			pending  []*Target
+		)
+
+		t := time.NewTimer(time.Hour)
+		t.Stop()
+		defer t.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case targets, ok := <-srcCh:
+				if !ok {
+					return
+				}
+				current := targetSetKey(targets)
+				changed := last != nil && !targetSetsEqual(last, current)
+				last = current
+
+				now := time.Now()
+				if changed {
+					changes = append(changes, now)
+					for len(changes) > 0 && now.Sub(changes[0]) > cfg.Window {
+						changes = changes[1:]
+					}
+
+					if !flapping && len(changes) > cfg.MaxChanges {
+						flapping = true
+						s.emit(Event{
+							Type:    EventFlappingDetected,
+							Time:    now,
+							Message: fmt.Sprintf("Source changed %d times in %v, coalescing updates", len(changes), cfg.Window),
+							Targets: targets,
+						})
+					}
+				}
+
+				if !flapping {
+					select {
+					case ch <- targets:
+					case <-ctx.Done():
+						return
+					}
+					continue
+				}
+
+				// Hold the latest snapshot until the source has settled
+				pending = targets
+				if changed {
+					if !t.Stop() {
+						select {
+						case <-t.C:
+						default:
+						}
+					}
+					t.Reset(settleTime)
+				}
+			case <-t.C:
+				s.log().Infof("Source stabilized, applying coalesced update")
+				flapping = false
+				changes = changes[:0]
+				select {
+				case ch <- pending:
+				case <-ctx.Done():
+					return
+				}
+				pending = nil
+			}
+		}
+	}()
+	return ch
+}
+
+// debounce wraps the source channel coalescing all updates received within
+// `DebounceWindow` of the first one into a single update of the latest snapshot
+func (s *Syncer) debounce(ctx context.Context, srcCh chan []*Target) chan []*Target {
+	window := s.Config.DebounceWindow
+	if window <= 0 {
+		return srcCh
+	}
+
+	ch := make(chan []*Target, 1)
+	go func() {
+		defer close(ch)
+
+		var (
+			pending  []*Target
+			waiting  bool
+			received int
+		)
+		t := time.NewTimer(time.Hour)
+		t.Stop()
+		defer t.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case targets, ok := <-srcCh:
+				if !ok {
+					return
+				}
+				pending = targets
+				received++
+				if !waiting {
+					waiting = true
+					t.Reset(window)
+				}
+			case <-t.C:
+				s.log().Debugf("Applying %d source updates debounced over %v", received, window)
+				select {
+				case ch <- pending:
+				case <-ctx.Done():
+					return
+				}
+				pending = nil
+				waiting = false
+				received = 0
+			}
+		}
+	}()
+	return ch
+}
+
+// dampenDeltas applies `dampen` and `debounce` to the deltas of a
+// TargetDeltaSource, by applying them to the snapshots accumulated from the
+// deltas and diffing those again
+func (s *Syncer) dampenDeltas(ctx context.Context, deltaCh chan *TargetDelta) chan *TargetDelta {
+	if s.Config.Dampening.MaxChanges <= 0 && s.Config.DebounceWindow <= 0 {
+		return deltaCh
+	}
+	return deltasFromSnapshots(ctx, s.debounce(ctx, s.dampen(ctx, snapshotsFromDeltas(ctx, deltaCh))))
+}
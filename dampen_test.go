@@ -0,0 +1,183 @@
+package targetsync
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+type recordingSink struct {
+	events []Event
+}
+
+func (r *recordingSink) Emit(e Event) {
+	r.events = append(r.events, e)
+}
+
+func TestDampen(t *testing.T) {
+	sink := &recordingSink{}
+	syncer := &Syncer{
+		Config: &SyncConfig{
+			Dampening: DampeningConfig{
+				MaxChanges: 2,
+				Window:     time.Second,
+				SettleTime: 200 * time.Millisecond,
+			},
+		},
+		Events: sink,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srcCh := make(chan []*Target)
+	ips := "abcdefgh"
+	ch := syncer.dampen(ctx, srcCh)
+
+	// Changes below the threshold are passed straight through
+	for i := 0; i < 3; i++ {
+		srcCh <- []*Target{{IP: ips[i : i+1]}}
+		select {
+		case <-ch:
+		case <-time.After(time.Second):
+			t.Fatalf("update %d not passed through", i)
+		}
+	}
+
+	// The next change trips the threshold, so updates are coalesced
+	for i := 0; i < 5; i++ {
+		srcCh <- []*Target{{IP: ips[i+3 : i+4]}}
+	}
+	if len(sink.events) != 1 || sink.events[0].Type != EventFlappingDetected {
+		t.Fatalf("expected a single flapping event, got %+v", sink.events)
+	}
+
+	select {
+	case targets := <-ch:
+		if len(targets) != 1 || targets[0].IP != "h" {
+			t.Fatalf("expected latest snapshot, got %+v", targets)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("coalesced update never applied")
+	}
+}
+
+func TestDebounce(t *testing.T) {
+	syncer := &Syncer{
+		Config: &SyncConfig{
+			DebounceWindow: 200 * time.Millisecond,
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srcCh := make(chan []*Target)
+	ch := syncer.debounce(ctx, srcCh)
+
+	ips := "abcde"
+	for i := range ips {
+		srcCh <- []*Target{{IP: ips[i : i+1]}}
+	}
+
+	select {
+	case targets := <-ch:
+		if len(targets) != 1 || targets[0].IP != "e" {
+			t.Fatalf("expected latest snapshot, got %+v", targets)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("debounced update never applied")
+	}
+
+	select {
+	case targets := <-ch:
+		t.Fatalf("expected a single update, got another %+v", targets)
+	case <-time.After(300 * time.Millisecond):
+	}
+}
+
+func TestDampenDeltas(t *testing.T) {
+	sink := &recordingSink{}
+	syncer := &Syncer{
+		Config: &SyncConfig{
+			Dampening: DampeningConfig{
+				MaxChanges: 1,
+				Window:     time.Second,
+				SettleTime: 200 * time.Millisecond,
+			},
+		},
+		Events: sink,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	src := newmockDeltaSource()
+	deltaCh, _ := src.SubscribeDeltas(ctx)
+	ch := syncer.dampenDeltas(ctx, deltaCh)
+	expect := func(added, removed []*Target) {
+		select {
+		case delta := <-ch:
+			if err := equalTargets(added, delta.Added); err != nil {
+				t.Fatalf("Mismatch in added targets: %v", err)
+			}
+			if err := equalTargets(removed, delta.Removed); err != nil {
+				t.Fatalf("Mismatch in removed targets: %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("delta not applied")
+		}
+	}
+
+	a, b := &Target{IP: "a"}, &Target{IP: "b"}
+	src.ch <- []*Target{a}
+	expect([]*Target{a}, nil)
+	src.ch <- []*Target{a, b}
+	expect([]*Target{b}, nil)
+
+	// b flapping trips the threshold, so the deltas are coalesced into the
+	// net change once the source settles
+	src.ch <- []*Target{a}
+	src.ch <- []*Target{a, b}
+	src.ch <- []*Target{b}
+	expect(nil, []*Target{a})
+	if len(sink.events) != 1 || sink.events[0].Type != EventFlappingDetected {
+		t.Fatalf("expected a single flapping event, got %+v", sink.events)
+	}
+}
+
+func TestDebounceDeltas(t *testing.T) {
+	syncer := &Syncer{
+		Config: &SyncConfig{
+			DebounceWindow: 200 * time.Millisecond,
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	src := newmockDeltaSource()
+	deltaCh, _ := src.SubscribeDeltas(ctx)
+	ch := syncer.dampenDeltas(ctx, deltaCh)
+
+	// The deltas within the window are coalesced into their net change
+	a, b, c := &Target{IP: "a"}, &Target{IP: "b"}, &Target{IP: "c"}
+	src.ch <- []*Target{a}
+	src.ch <- []*Target{a, b}
+	src.ch <- []*Target{b, c}
+
+	select {
+	case delta := <-ch:
+		if err := equalTargets([]*Target{b, c}, delta.Added); err != nil || len(delta.Removed) != 0 {
+			t.Fatalf("expected the net delta, got %+v", delta)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("debounced delta never applied")
+	}
+
+	select {
+	case delta := <-ch:
+		t.Fatalf("expected a single delta, got another %+v", delta)
+	case <-time.After(300 * time.Millisecond):
+	}
+}
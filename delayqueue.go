@@ -0,0 +1,433 @@
+package targetsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jacksontj/lane"
+)
+
+var (
+	// ErrRemovalNotPending is returned when cancelling the removal of a
+	// target which isn't waiting to be removed
+	ErrRemovalNotPending = errors.New("removal not pending")
+	// ErrRemovalQueueStopped is returned by a DelayQueue which has stopped,
+	// e.g. as the lock was lost
+	ErrRemovalQueueStopped = errors.New("removal queue stopped")
+)
+
+// DelayQueue removes targets from the Syncer's destination once their remove
+// delay has passed, to avoid issues where a target is "flapping" in the
+// source. It is run by the leader, and the pending removals can be listed,
+// cancelled and flushed while it runs, e.g. by operators during incidents.
+type DelayQueue struct {
+	s        *Syncer
+	removeCh chan *Target
+	addCh    chan *Target
+	// requests are run by the queue's loop, which owns the state below
+	requests chan func()
+	done     chan struct{}
+
+	itemMap map[string]*lane.Item
+	q       *lane.PQueue
+	// targets currently being drained, and those which have been drained and
+	// are waiting to be removed
+	draining  map[string]*Target
+	drained   map[string]struct{}
+	drainedCh chan *Target
+	// failures is the number of failed removals of each target
+	failures map[string]int
+	// nextBatchAt is the earliest time the next batch can be removed, to
+	// limit removals to the `RemoveRate`
+	nextBatchAt time.Time
+	timer       *time.Timer
+}
+
+// newDelayQueue returns a DelayQueue for the Syncer, scheduling the removals
+// sent on removeCh and cancelling those of the targets sent on addCh
+func newDelayQueue(s *Syncer, removeCh, addCh chan *Target) *DelayQueue {
+	return &DelayQueue{
+		s:         s,
+		removeCh:  removeCh,
+		addCh:     addCh,
+		requests:  make(chan func()),
+		done:      make(chan struct{}),
+		itemMap:   make(map[string]*lane.Item),
+		q:         lane.NewPQueue(lane.MINPQ),
+		draining:  make(map[string]*Target),
+		drained:   make(map[string]struct{}),
+		drainedCh: make(chan *Target),
+		failures:  make(map[string]int),
+		timer:     time.NewTimer(time.Hour),
+	}
+}
+
+// Pending returns the targets waiting to be removed, sorted by when they are
+// due. Cancelled removals are included, with `Cancelled` set.
+func (d *DelayQueue) Pending() []*PendingRemoval {
+	return d.s.pendingRemovals()
+}
+
+// Cancel cancels the removal of the target with the key, it is kept in the
+// destination until it is flushed or the source has it again.
+// ErrRemovalNotPending is returned if it isn't waiting to be removed.
+func (d *DelayQueue) Cancel(ctx context.Context, key string) error {
+	var err error
+	if doErr := d.do(ctx, func() {
+		removal := d.s.pendingRemoval(key)
+		_, draining := d.draining[key]
+		if removal == nil && !draining {
+			err = ErrRemovalNotPending
+			return
+		}
+		if removal != nil && removal.Cancelled {
+			return
+		}
+		target := d.draining[key]
+		if removal != nil {
+			target = removal.Target
+		}
+		d.unschedule(key)
+		delete(d.draining, key)
+		delete(d.drained, key)
+		delete(d.failures, key)
+		d.s.cancelPendingRemoval(target)
+		d.s.log().Infof("Removal of target cancelled: %v", target)
+		d.resetTimer(0)
+	}); doErr != nil {
+		return doErr
+	}
+	return err
+}
+
+// Flush schedules the removal of the target with the key (or all targets if
+// empty) for now, including cancelled removals, returning how many were
+// flushed. Targets are still drained, and removed within the `RemoveRate`
+// and mutation budget.
+func (d *DelayQueue) Flush(ctx context.Context, key string) (int, error) {
+	flushed := 0
+	err := d.do(ctx, func() {
+		now := time.Now()
+		for _, removal := range d.s.pendingRemovals() {
+			targetKey := removal.Target.Key()
+			if key != "" && targetKey != key {
+				continue
+			}
+			// Draining targets are removed as soon as they are drained
+			if _, ok := d.draining[targetKey]; ok {
+				continue
+			}
+			d.unschedule(targetKey)
+			d.schedule(removal.Target, now)
+			flushed++
+		}
+		if flushed > 0 {
+			d.s.log().Infof("Flushed %d pending removals", flushed)
+			d.resetTimer(0)
+		}
+	})
+	return flushed, err
+}
+
+// do runs fn in the queue's loop
+func (d *DelayQueue) do(ctx context.Context, fn func()) error {
+	ran := make(chan struct{})
+	select {
+	case d.requests <- func() {
+		fn()
+		close(ran)
+	}:
+	case <-d.done:
+		return ErrRemovalQueueStopped
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	<-ran
+	return nil
+}
+
+func (d *DelayQueue) resetTimer(dur time.Duration) {
+	if !d.timer.Stop() {
+		select {
+		case <-d.timer.C:
+		default:
+		}
+	}
+	d.timer.Reset(dur)
+}
+
+// schedule queues the target to be removed at `at`
+func (d *DelayQueue) schedule(target *Target, at time.Time) {
+	d.itemMap[target.Key()] = d.q.Push(target, at.Unix())
+	d.s.setPendingRemoval(target, at)
+}
+
+// unschedule removes the target with the key from the queue, if queued
+func (d *DelayQueue) unschedule(key string) {
+	if item, ok := d.itemMap[key]; ok {
+		d.q.Remove(item)
+		delete(d.itemMap, key)
+	}
+}
+
+// run removes the targets as they are due until the context is done
+func (d *DelayQueue) run(ctx context.Context) {
+	defer close(d.done)
+	s := d.s
+	maxAttempts := s.Config.RemoveRetry.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = defaultRemoveMaxAttempts
+	}
+
+	// Resume the removals pending from the last leader (e.g. restored from
+	// the persisted state)
+	for _, removal := range s.pendingRemovals() {
+		if !removal.Cancelled {
+			d.schedule(removal.Target, removal.At)
+		}
+	}
+	if headItem, headAt := d.q.Head(); headItem != nil {
+		d.resetTimer(time.Until(time.Unix(headAt, 0)))
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case req := <-d.requests:
+			req()
+		case toRemove, ok := <-d.removeCh:
+			if !ok {
+				continue
+			}
+			if _, ok := d.itemMap[toRemove.Key()]; ok {
+				// Already scheduled, don't push the removal back
+				continue
+			}
+			if _, ok := d.draining[toRemove.Key()]; ok {
+				continue
+			}
+			if removal := s.pendingRemoval(toRemove.Key()); removal != nil && removal.Cancelled {
+				s.log().Debugf("Not removing target whose removal was cancelled: %v", toRemove)
+				continue
+			}
+			if s.Config.SelfExclusion.isLocal(toRemove.IP, s.LocalAddr) {
+				s.log().Debugf("Not removing local instance from destination: %v", toRemove)
+				continue
+			}
+			delay := s.removeDelay(toRemove)
+			s.log().Debugf("Scheduling target for removal (%s) from destination in %v: %v", removalReason(toRemove), delay, toRemove)
+			now := time.Now()
+			removeUnixTime := now.Add(delay).Unix()
+			if headItem, headAt := d.q.Head(); headItem == nil || removeUnixTime < headAt {
+				d.resetTimer(delay)
+			}
+			d.schedule(toRemove, now.Add(delay))
+		case toAdd, ok := <-d.addCh:
+			if !ok {
+				continue
+			}
+			key := toAdd.Key()
+			if _, ok := d.itemMap[key]; ok {
+				s.log().Debugf("Removing target from removal queue as it was re-added: %v", toAdd)
+				d.unschedule(key)
+			}
+			if _, ok := d.draining[key]; ok {
+				s.log().Debugf("Target re-added while draining, cancelling removal: %v", toAdd)
+				delete(d.draining, key)
+			}
+			s.clearPendingRemoval(key)
+			delete(d.drained, key)
+			delete(d.failures, key)
+		case target := <-d.drainedCh:
+			key := target.Key()
+			// If it isn't draining anymore it was re-added
+			if _, ok := d.draining[key]; !ok {
+				continue
+			}
+			delete(d.draining, key)
+			d.drained[key] = struct{}{}
+			d.schedule(target, time.Now())
+			d.resetTimer(0)
+		case <-d.timer.C:
+			d.removeDue(ctx, maxAttempts)
+		}
+	}
+}
+
+// removeDue removes the targets which are due for removal, in a batch
+func (d *DelayQueue) removeDue(ctx context.Context, maxAttempts int) {
+	s := d.s
+	// Check if there is an item at head, and if the time is past then
+	// do the removal
+	headItem, headUnixTime := d.q.Head()
+	s.log().Debugf("Processing target removal: %v", headItem)
+	now := time.Now()
+	nowUnix := now.Unix()
+
+	// Hold the removals while the mutation budget is exceeded
+	if headItem != nil && s.budgetPaused() {
+		d.resetTimer(budgetPausedPoll)
+		return
+	}
+
+	// Wait out the RemoveRate interval since the last batch
+	if now.Before(d.nextBatchAt) {
+		d.resetTimer(d.nextBatchAt.Sub(now))
+		return
+	}
+
+	// Collect the targets due for removal (up to the RemoveRate
+	// limit) so they are removed in a single batch
+	var batch []*Target
+	for headItem != nil && headUnixTime <= nowUnix {
+		if limit := s.Config.RemoveRate.MaxTargets; limit > 0 && len(batch) >= limit {
+			break
+		}
+		target := headItem.(*Target)
+		key := target.Key()
+		d.q.Pop()
+		delete(d.itemMap, key)
+		if _, ok := d.drained[key]; !ok && s.Config.Drain.enabled() && s.Config.RemoveMode != RemoveModeNone {
+			// Drain in the background, the target is queued for
+			// removal again once drained
+			d.draining[key] = target
+			go func() {
+				s.drain(ctx, target)
+				select {
+				case d.drainedCh <- target:
+				case <-ctx.Done():
+				}
+			}()
+		} else {
+			batch = append(batch, target)
+		}
+		headItem, headUnixTime = d.q.Head()
+	}
+	if len(batch) > 0 {
+		if err := s.removeTargets(ctx, batch); err != nil {
+			s.log().Warnf("Error removing targets from destination: %v", err)
+			var deadLetters []*Target
+			for _, target := range batch {
+				key := target.Key()
+				d.failures[key]++
+				if d.failures[key] >= maxAttempts {
+					deadLetters = append(deadLetters, target)
+					delete(d.failures, key)
+					delete(d.drained, key)
+					s.clearPendingRemoval(key)
+					continue
+				}
+				// Round up, as the queue has second granularity
+				retryAt := now.Add(s.removeRetryBackoff(d.failures[key]) + time.Second - 1)
+				d.schedule(target, retryAt)
+			}
+			if len(deadLetters) > 0 {
+				s.emit(Event{
+					Type:       EventRemovalFailed,
+					Time:       now,
+					Message:    fmt.Sprintf("Giving up removing %d targets (%s) from destination after %d attempts: %v", len(deadLetters), summarizeReasons(deadLetters), maxAttempts, err),
+					ErrorClass: ErrorClass(err),
+					Targets:    deadLetters,
+				})
+			}
+		} else {
+			s.log().Debugf("Target removal successful: %v", batch)
+			for _, target := range batch {
+				delete(d.drained, target.Key())
+				delete(d.failures, target.Key())
+				s.clearPendingRemoval(target.Key())
+			}
+			if s.Config.RemoveRate.MaxTargets > 0 {
+				d.nextBatchAt = now.Add(s.Config.RemoveRate.Interval)
+			}
+		}
+		headItem, headUnixTime = d.q.Head()
+	}
+	// If there is still an item in the queue, reset the timer
+	if headItem != nil {
+		next := time.Unix(headUnixTime, 0)
+		if next.Before(d.nextBatchAt) {
+			next = d.nextBatchAt
+		}
+		d.resetTimer(next.Sub(now))
+	}
+}
+
+// RemovalQueue returns the Syncer's DelayQueue, or nil if it isn't leader
+func (s *Syncer) RemovalQueue() *DelayQueue {
+	s.removalsLock.Lock()
+	defer s.removalsLock.Unlock()
+	return s.removalQueue
+}
+
+// runRemovalQueue runs the DelayQueue of the leader until the context is done
+func (s *Syncer) runRemovalQueue(ctx context.Context, removeCh, addCh chan *Target) {
+	q := newDelayQueue(s, removeCh, addCh)
+	s.removalsLock.Lock()
+	s.removalQueue = q
+	s.removalsLock.Unlock()
+	defer func() {
+		s.removalsLock.Lock()
+		if s.removalQueue == q {
+			s.removalQueue = nil
+		}
+		s.removalsLock.Unlock()
+	}()
+	q.run(ctx)
+}
+
+// removals lists (GET) the pending removals of the pair, cancels (DELETE) the
+// removal of the `?key=` target, or flushes (POST `/flush`, optionally only
+// the `?key=` target) them
+func (h *apiHandler) removals(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, APIPrefix+"/removals/")
+	flush := strings.HasSuffix(name, "/flush")
+	name = strings.TrimSuffix(name, "/flush")
+	for _, syncer := range h.syncers() {
+		if syncer.name() != name {
+			continue
+		}
+		key := r.URL.Query().Get("key")
+		switch {
+		case r.Method == http.MethodGet && !flush:
+			writeJSON(w, http.StatusOK, syncer.pendingRemovals())
+			return
+		case r.Method == http.MethodDelete && !flush && key != "":
+		case r.Method == http.MethodPost && flush:
+		default:
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		q := syncer.RemovalQueue()
+		if q == nil {
+			http.Error(w, "Not the leader of the pair", http.StatusConflict)
+			return
+		}
+		var err error
+		if flush {
+			var flushed int
+			if flushed, err = q.Flush(r.Context(), key); err == nil && flushed == 0 && key != "" {
+				err = ErrRemovalNotPending
+			}
+		} else {
+			err = q.Cancel(r.Context(), key)
+		}
+		switch err {
+		case nil:
+			w.WriteHeader(http.StatusNoContent)
+		case ErrRemovalNotPending:
+			http.NotFound(w, r)
+		case ErrRemovalQueueStopped:
+			http.Error(w, "Not the leader of the pair", http.StatusConflict)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	http.NotFound(w, r)
+}
@@ -0,0 +1,77 @@
+package targetsync
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestDelayQueueCancelFlush(t *testing.T) {
+	dst := newmockDestination()
+	a, b := &Target{IP: "1"}, &Target{IP: "2"}
+	dst.AddTargets(nil, []*Target{a, b})
+	syncer := &Syncer{
+		Config: &SyncConfig{
+			LockOptions: LockOptions{Key: "a"},
+			RemoveDelay: time.Hour,
+		},
+		Dst: dst,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	removeCh := make(chan *Target)
+	go syncer.runRemovalQueue(ctx, removeCh, make(chan *Target))
+	removeCh <- a
+	removeCh <- b
+
+	var q *DelayQueue
+	for deadline := time.Now().Add(5 * time.Second); q == nil || len(q.Pending()) != 2; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Removals weren't scheduled")
+		}
+		q = syncer.RemovalQueue()
+	}
+
+	if err := q.Cancel(ctx, a.Key()); err != nil {
+		t.Fatalf("Unexpected error cancelling: %v", err)
+	}
+	if err := q.Cancel(ctx, "3:0"); err != ErrRemovalNotPending {
+		t.Fatalf("Expected ErrRemovalNotPending, got %v", err)
+	}
+	// A cancelled removal isn't scheduled again
+	removeCh <- a
+	if removal := syncer.pendingRemoval(a.Key()); removal == nil || !removal.Cancelled {
+		t.Fatalf("Expected the removal of %s to be cancelled, got %+v", a.Key(), removal)
+	}
+
+	// Flushing removes the pending target, but not the cancelled one
+	if n, err := q.Flush(ctx, b.Key()); err != nil || n != 1 {
+		t.Fatalf("Expected 1 removal flushed, got %d: %v", n, err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		targets, _ := dst.GetTargets(nil)
+		if err := equalTargets(targets, []*Target{a}); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Flushed removal wasn't removed, destination has %v", targets)
+		}
+	}
+
+	// The cancelled removal is kept until the source has the target again
+	syncer.releaseCancelledRemovals(map[string]*Target{a.IP: a})
+	if removals := q.Pending(); len(removals) != 0 {
+		t.Fatalf("Expected no pending removals, got %v", removals)
+	}
+
+	cancel()
+	for deadline := time.Now().Add(5 * time.Second); syncer.RemovalQueue() != nil; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Removal queue wasn't stopped")
+		}
+	}
+	if _, err := q.Flush(context.Background(), ""); err != ErrRemovalQueueStopped {
+		t.Fatalf("Expected ErrRemovalQueueStopped, got %v", err)
+	}
+}
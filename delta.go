@@ -0,0 +1,95 @@
+package targetsync
+
+import "context"
+
+// deltasFromSnapshots converts a channel of full snapshots into a channel of
+// deltas between consecutive snapshots, the first delta contains the whole
+// initial snapshot
+func deltasFromSnapshots(ctx context.Context, srcCh chan []*Target) chan *TargetDelta {
+	ch := make(chan *TargetDelta, cap(srcCh))
+	go func() {
+		defer close(ch)
+		var prev map[string]*Target
+		for {
+			var targets []*Target
+			select {
+			case <-ctx.Done():
+				return
+			case t, ok := <-srcCh:
+				if !ok {
+					return
+				}
+				targets = t
+			}
+
+			next := make(map[string]*Target, len(targets))
+			for _, target := range targets {
+				next[target.IP] = target
+			}
+
+			delta := &TargetDelta{}
+			for ip, target := range next {
+				if _, ok := prev[ip]; !ok {
+					delta.Added = append(delta.Added, target)
+				}
+			}
+			for ip, target := range prev {
+				if _, ok := next[ip]; !ok {
+					delta.Removed = append(delta.Removed, target)
+				}
+			}
+			first := prev == nil
+			prev = next
+
+			if !first && len(delta.Added) == 0 && len(delta.Removed) == 0 {
+				continue
+			}
+			select {
+			case ch <- delta:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+// snapshotsFromDeltas converts a channel of deltas into a channel of the full
+// snapshots accumulated from them, the first delta must contain the whole
+// initial snapshot
+func snapshotsFromDeltas(ctx context.Context, deltaCh chan *TargetDelta) chan []*Target {
+	ch := make(chan []*Target, cap(deltaCh))
+	go func() {
+		defer close(ch)
+		current := make(map[string]*Target)
+		for {
+			var delta *TargetDelta
+			select {
+			case <-ctx.Done():
+				return
+			case d, ok := <-deltaCh:
+				if !ok {
+					return
+				}
+				delta = d
+			}
+
+			for _, target := range delta.Removed {
+				delete(current, target.IP)
+			}
+			for _, target := range delta.Added {
+				current[target.IP] = target
+			}
+			targets := make([]*Target, 0, len(current))
+			for _, target := range current {
+				targets = append(targets, target)
+			}
+			select {
+			case ch <- targets:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch
+}
@@ -0,0 +1,122 @@
+package targetsync
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// diffTimeout limits how long computing the diff of each pair may take
+const diffTimeout = 30 * time.Second
+
+// SyncDiff is the difference between the source and destination of a pair, as
+// it would be applied by the next sync
+type SyncDiff struct {
+	// Name of the sync pair
+	Name string `json:"name"`
+	// Converged is whether the destination matches the source
+	Converged bool `json:"converged"`
+	// Add and Remove are the targets the sync would add and remove,
+	// including removals waiting out the `RemoveDelay`
+	Add    []*Target `json:"add"`
+	Remove []*Target `json:"remove"`
+	// Error is set if the diff couldn't be computed
+	Error string `json:"error,omitempty"`
+}
+
+// DiffResponse is the response of the diff endpoint
+type DiffResponse struct {
+	// Converged is whether all of the pairs are converged
+	Converged bool       `json:"converged"`
+	Pairs     []SyncDiff `json:"pairs"`
+}
+
+// currentSource returns the current source targets, with the transforms
+// applied, from a new subscription to the source
+func (s *Syncer) currentSource(ctx context.Context) ([]*Target, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	srcCh, err := s.Src.Subscribe(ctx)
+	if err != nil {
+		return nil, wrapError(ErrSourceUnavailable, err)
+	}
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case targets, ok := <-srcCh:
+		if !ok {
+			return nil, wrapError(ErrSourceUnavailable, fmt.Errorf("Source channel closed"))
+		}
+		return s.transform(targets), nil
+	}
+}
+
+// Diff computes the difference between the current source targets and the
+// destination, without changing the destination
+func (s *Syncer) Diff(ctx context.Context) (*SyncDiff, error) {
+	srcTargets, err := s.currentSource(ctx)
+	if err != nil {
+		return nil, err
+	}
+	dstTargets, err := s.getTargets(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	srcMap := make(map[string]*Target, len(srcTargets))
+	for _, target := range srcTargets {
+		srcMap[target.IP] = target
+	}
+	dstMap := make(map[string]*Target, len(dstTargets))
+	for _, target := range dstTargets {
+		dstMap[target.IP] = target
+	}
+
+	diff := &SyncDiff{
+		Name:   s.name(),
+		Add:    make([]*Target, 0),
+		Remove: make([]*Target, 0),
+	}
+	for ip, target := range srcMap {
+		if _, ok := dstMap[ip]; !ok {
+			diff.Add = append(diff.Add, target)
+		}
+	}
+	for ip, target := range dstMap {
+		if _, ok := srcMap[ip]; !ok && !s.Config.SelfExclusion.isLocal(ip, s.LocalAddr) && !s.isAdopted(ip) {
+			diff.Remove = append(diff.Remove, target)
+		}
+	}
+	diff.Converged = len(diff.Add) == 0 && len(diff.Remove) == 0
+	return diff, nil
+}
+
+// diff serves the diffs of all pairs, or the pair named by `?pair=`
+func (h *apiHandler) diff(w http.ResponseWriter, r *http.Request) {
+	pair := r.URL.Query().Get("pair")
+	syncers := h.syncers()
+	resp := DiffResponse{Converged: true, Pairs: make([]SyncDiff, 0, len(syncers))}
+	for _, syncer := range syncers {
+		if pair != "" && syncer.name() != pair {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), diffTimeout)
+		diff, err := syncer.Diff(ctx)
+		cancel()
+		if err != nil {
+			diff = &SyncDiff{Name: syncer.name(), Error: err.Error()}
+		}
+		resp.Converged = resp.Converged && diff.Converged
+		resp.Pairs = append(resp.Pairs, *diff)
+	}
+	if pair != "" && len(resp.Pairs) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	code := http.StatusOK
+	if !resp.Converged {
+		code = http.StatusServiceUnavailable
+	}
+	writeJSON(w, code, resp)
+}
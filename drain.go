@@ -0,0 +1,103 @@
+package targetsync
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+)
+
+// defaultDrainTimeout is how long to wait for a target to drain if
+// `Timeout` isn't set
+const defaultDrainTimeout = 30 * time.Second
+
+// DrainConfig configures a hook which is called for each target before it is
+// removed from the destination, so it can stop accepting new work first
+type DrainConfig struct {
+	// HTTPPath is POSTed to on the target itself, a 2xx response confirms
+	// the target has drained
+	HTTPPath string `yaml:"http_path"`
+	// HTTPPort to POST to, defaults to the target's port
+	HTTPPort int `yaml:"http_port"`
+	// Command is run with TARGET_IP and TARGET_PORT set in its environment,
+	// exiting 0 confirms the target has drained
+	Command string `yaml:"command"`
+	// Timeout is how long to wait for the confirmation, the target is
+	// removed regardless once it passes
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+func (c DrainConfig) enabled() bool {
+	return c.HTTPPath != "" || c.Command != ""
+}
+
+func (c DrainConfig) Validate() error {
+	if c.HTTPPath != "" && c.Command != "" {
+		return fmt.Errorf("Only one of http_path and command may be set for drain")
+	}
+	if c.Timeout < 0 {
+		return fmt.Errorf("Timeout for drain must be >=0")
+	}
+	return nil
+}
+
+// drain calls the drain hook for the target and waits for it to confirm.
+// Failures are only logged, as the target is being removed either way.
+func (s *Syncer) drain(ctx context.Context, target *Target) {
+	cfg := s.Config.Drain
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = defaultDrainTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var err error
+	if cfg.Command != "" {
+		err = drainCommand(ctx, cfg.Command, target)
+	} else {
+		err = drainHTTP(ctx, cfg, target)
+	}
+	if err != nil {
+		s.log().Warnf("Error draining target %v, removing anyways: %v", target, err)
+		return
+	}
+	s.log().Debugf("Target drained: %v", target)
+}
+
+func drainHTTP(ctx context.Context, cfg DrainConfig, target *Target) error {
+	port := cfg.HTTPPort
+	if port == 0 {
+		port = target.Port
+	}
+	u := "http://" + net.JoinHostPort(target.IP, strconv.Itoa(port)) + cfg.HTTPPath
+	req, err := http.NewRequest(http.MethodPost, u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("Unexpected status from drain endpoint: %s", resp.Status)
+	}
+	return nil
+}
+
+func drainCommand(ctx context.Context, command string, target *Target) error {
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", command)
+	cmd.Env = append(os.Environ(),
+		"TARGET_IP="+target.IP,
+		"TARGET_PORT="+strconv.Itoa(target.Port),
+	)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%v: %s", err, out)
+	}
+	return nil
+}
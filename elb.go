@@ -3,18 +3,29 @@ package targetsync
 import (
 	"context"
 	"fmt"
+	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
-	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/elbv2"
 )
 
+// defaultAWSBatchSize is the most targets (de)registered per call if
+// `BatchSize` isn't set
+const defaultAWSBatchSize = 500
+
 // NewAWSTargetGroup returns a new AWS target group destination
 func NewAWSTargetGroup(cfg *AWSConfig) (*AWSTargetGroup, error) {
 	// TODO: verify that this client is good at creation time (ping or something)
+	sess, err := awsSession(cfg.Region, cfg.Credentials)
+	if err != nil {
+		return nil, err
+	}
 	return &AWSTargetGroup{
-		svc: elbv2.New(session.New()),
+		svc: elbv2.New(sess),
+		ec2: ec2.New(sess),
 		cfg: cfg,
 	}, nil
 }
@@ -22,10 +33,100 @@ func NewAWSTargetGroup(cfg *AWSConfig) (*AWSTargetGroup, error) {
 // AWSTargetGroup is a TargetDestination implementation for AWS target groups
 type AWSTargetGroup struct {
 	svc *elbv2.ELBV2
+	ec2 *ec2.EC2
 	cfg *AWSConfig
+
+	l sync.Mutex
+	// port and protocol of the target group, looked up if `InferPort` is set
+	port     int
+	protocol string
+	// attributesChecked is the time the attributes were last reconciled
+	attributesChecked time.Time
+	// ranges are the ranges the target group accepts, looked up if
+	// `IPValidation` is enabled, at rangesChecked
+	ranges        *targetRanges
+	rangesChecked time.Time
 }
 
-// GetTargets returns the current set of targets at the destination
+// log returns the destination's Logger
+func (tg *AWSTargetGroup) log() Logger {
+	return loggerOr(tg.cfg.Logger)
+}
+
+// defaultPort returns the target group's configured port, it is only looked
+// up once
+func (tg *AWSTargetGroup) defaultPort(ctx context.Context) (int, error) {
+	tg.l.Lock()
+	defer tg.l.Unlock()
+	if tg.port > 0 {
+		return tg.port, nil
+	}
+
+	result, err := tg.svc.DescribeTargetGroupsWithContext(ctx, &elbv2.DescribeTargetGroupsInput{
+		TargetGroupArns: []*string{aws.String(tg.cfg.TargetGroupARN)},
+	})
+	if err != nil {
+		return 0, wrapAWSError(err)
+	}
+	if len(result.TargetGroups) == 0 || result.TargetGroups[0].Port == nil {
+		return 0, wrapError(ErrConfigInvalid, fmt.Errorf("Target group %s has no port", tg.cfg.TargetGroupARN))
+	}
+	group := result.TargetGroups[0]
+	tg.port = int(aws.Int64Value(group.Port))
+	tg.protocol = aws.StringValue(group.Protocol)
+	tg.log().Infof("Using port %d (%s) of target group %s for targets without a port", tg.port, tg.protocol, tg.cfg.TargetGroupARN)
+	return tg.port, nil
+}
+
+// SelfTest to implement the `SelfTester` interface, the target group must
+// exist and be described
+func (tg *AWSTargetGroup) SelfTest(ctx context.Context) error {
+	result, err := tg.svc.DescribeTargetGroupsWithContext(ctx, &elbv2.DescribeTargetGroupsInput{
+		TargetGroupArns: []*string{aws.String(tg.cfg.TargetGroupARN)},
+	})
+	if err != nil {
+		return wrapAWSError(err)
+	}
+	if len(result.TargetGroups) == 0 {
+		return wrapError(ErrConfigInvalid, fmt.Errorf("Target group %s doesn't exist", tg.cfg.TargetGroupARN))
+	}
+	return nil
+}
+
+// withDefaultPort sets the target group's port on any targets without one, if
+// `InferPort` is set
+func (tg *AWSTargetGroup) withDefaultPort(ctx context.Context, targets []*Target) ([]*Target, error) {
+	if !tg.cfg.InferPort {
+		return targets, nil
+	}
+	result := make([]*Target, len(targets))
+	for i, target := range targets {
+		result[i] = target
+		if target.Port != 0 {
+			continue
+		}
+		port, err := tg.defaultPort(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("Error looking up target group port: %w", err)
+		}
+		withPort := *target
+		withPort.Port = port
+		result[i] = &withPort
+	}
+	return result, nil
+}
+
+// batches splits the targets into batches of at most `BatchSize`
+func (tg *AWSTargetGroup) batches(targets []*Target) [][]*Target {
+	size := tg.cfg.BatchSize
+	if size <= 0 {
+		size = defaultAWSBatchSize
+	}
+	return chunkTargets(targets, size)
+}
+
+// GetTargets returns the current set of targets at the destination.
+// DescribeTargetHealth isn't paginated, all targets are returned at once.
 func (tg *AWSTargetGroup) GetTargets(ctx context.Context) ([]*Target, error) {
 	input := &elbv2.DescribeTargetHealthInput{
 		TargetGroupArn: aws.String(tg.cfg.TargetGroupARN),
@@ -49,26 +150,65 @@ func (tg *AWSTargetGroup) GetTargets(ctx context.Context) ([]*Target, error) {
 			// Message from an error.
 			fmt.Println(err.Error())
 		}
-		return nil, err
+		return nil, wrapAWSError(err)
+	}
+
+	// Targets on the default port are reported without one, to match the
+	// source targets
+	defaultPort := 0
+	if tg.cfg.InferPort {
+		if defaultPort, err = tg.defaultPort(ctx); err != nil {
+			return nil, fmt.Errorf("Error looking up target group port: %w", err)
+		}
 	}
 
-	targets := make([]*Target, 0)
+	targets := make([]*Target, 0, len(result.TargetHealthDescriptions))
 	for _, targetHealthDecription := range result.TargetHealthDescriptions {
 		if tg.cfg.AvailabilityZone == "" ||
 			*targetHealthDecription.Target.AvailabilityZone == tg.cfg.AvailabilityZone {
-			targets = append(targets, &Target{
+			target := &Target{
 				IP:   *targetHealthDecription.Target.Id,
 				Port: int(*targetHealthDecription.Target.Port),
-			})
+			}
+			if target.Port == defaultPort {
+				target.Port = 0
+			}
+			if health := targetHealthDecription.TargetHealth; health != nil {
+				target.Health = &TargetHealth{
+					State:       aws.StringValue(health.State),
+					Reason:      aws.StringValue(health.Reason),
+					Description: aws.StringValue(health.Description),
+				}
+			}
+			targets = append(targets, target)
 		}
 	}
 
 	return targets, nil
 }
 
-// AddTargets simply adds the targets described
+// AddTargets registers the targets, in batches of at most `BatchSize`. With
+// `IPValidation` enabled, targets outside the target group's ranges are
+// rejected, the others are still registered.
 func (tg *AWSTargetGroup) AddTargets(ctx context.Context, targets []*Target) error {
+	targets, err := tg.withDefaultPort(ctx, targets)
+	if err != nil {
+		return err
+	}
+	targets, rejectErr := tg.validateIPs(ctx, targets)
+	if rejectErr != nil && !IsErrorClass(rejectErr, ErrTargetRejected) {
+		return rejectErr
+	}
+	for _, batch := range tg.batches(targets) {
+		if err := tg.registerTargets(ctx, batch); err != nil {
+			return err
+		}
+	}
+	return rejectErr
+}
 
+// registerTargets registers the targets in a single call
+func (tg *AWSTargetGroup) registerTargets(ctx context.Context, targets []*Target) error {
 	input := &elbv2.RegisterTargetsInput{
 		TargetGroupArn: aws.String(tg.cfg.TargetGroupARN),
 		Targets:        tg.TargetToTargetDescription(targets),
@@ -95,13 +235,27 @@ func (tg *AWSTargetGroup) AddTargets(ctx context.Context, targets []*Target) err
 			// Message from an error.
 			fmt.Println(err.Error())
 		}
-		return err
+		return wrapAWSError(err)
 	}
 	return nil
 }
 
-// RemoveTargets simply removes the targets described
+// RemoveTargets deregisters the targets, in batches of at most `BatchSize`
 func (tg *AWSTargetGroup) RemoveTargets(ctx context.Context, targets []*Target) error {
+	targets, err := tg.withDefaultPort(ctx, targets)
+	if err != nil {
+		return err
+	}
+	for _, batch := range tg.batches(targets) {
+		if err := tg.deregisterTargets(ctx, batch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deregisterTargets deregisters the targets in a single call
+func (tg *AWSTargetGroup) deregisterTargets(ctx context.Context, targets []*Target) error {
 	input := &elbv2.DeregisterTargetsInput{
 		TargetGroupArn: aws.String(tg.cfg.TargetGroupARN),
 		Targets:        tg.TargetToTargetDescription(targets),
@@ -124,7 +278,7 @@ func (tg *AWSTargetGroup) RemoveTargets(ctx context.Context, targets []*Target)
 			// Message from an error.
 			fmt.Println(err.Error())
 		}
-		return err
+		return wrapAWSError(err)
 	}
 
 	return nil
@@ -144,3 +298,41 @@ func (tg *AWSTargetGroup) TargetToTargetDescription(targets []*Target) []*elbv2.
 	}
 	return descs
 }
+
+// ClaimOwnership to implement the `OwnershipMarker` interface, the owner is
+// recorded in the target group's `OwnershipTag` tag
+func (tg *AWSTargetGroup) ClaimOwnership(ctx context.Context, owner string, force bool) (string, error) {
+	result, err := tg.svc.DescribeTagsWithContext(ctx, &elbv2.DescribeTagsInput{
+		ResourceArns: []*string{aws.String(tg.cfg.TargetGroupARN)},
+	})
+	if err != nil {
+		return "", wrapAWSError(err)
+	}
+	for _, desc := range result.TagDescriptions {
+		for _, tag := range desc.Tags {
+			if aws.StringValue(tag.Key) != OwnershipTag {
+				continue
+			}
+			current := aws.StringValue(tag.Value)
+			if current == owner {
+				return "", nil
+			}
+			if !force {
+				return current, nil
+			}
+			tg.log().Warnf("Taking over ownership of target group %s from %s", tg.cfg.TargetGroupARN, current)
+		}
+	}
+
+	_, err = tg.svc.AddTagsWithContext(ctx, &elbv2.AddTagsInput{
+		ResourceArns: []*string{aws.String(tg.cfg.TargetGroupARN)},
+		Tags: []*elbv2.Tag{{
+			Key:   aws.String(OwnershipTag),
+			Value: aws.String(owner),
+		}},
+	})
+	if err != nil {
+		return "", wrapAWSError(err)
+	}
+	return "", nil
+}
@@ -0,0 +1,208 @@
+package targetsync
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+)
+
+// defaultAttributesInterval is the least time between checks of the target
+// group attributes if `Attributes.Interval` isn't set
+const defaultAttributesInterval = time.Minute
+
+// Stickiness types of target groups
+const (
+	StickinessLBCookie  = "lb_cookie"
+	StickinessAppCookie = "app_cookie"
+	StickinessSourceIP  = "source_ip"
+)
+
+// TargetGroupAttributesConfig is the target group attributes managed by the
+// aws destination. Unset attributes are left as they are, set ones are
+// checked on full syncs and reset if they have drifted (e.g. changed in the
+// console).
+type TargetGroupAttributesConfig struct {
+	// DeregistrationDelay is how long connections to deregistered targets
+	// are drained for
+	DeregistrationDelay *time.Duration `yaml:"deregistration_delay"`
+	// SlowStart is how long new targets ramp up their share of requests
+	// for, 0 disables slow start
+	SlowStart  *time.Duration    `yaml:"slow_start"`
+	Stickiness *StickinessConfig `yaml:"stickiness"`
+	// Extra are any other attributes by key, e.g.
+	// `load_balancing.algorithm.type`
+	Extra map[string]string `yaml:"extra"`
+	// Interval is the least time between checks of the attributes,
+	// defaults to 1m
+	Interval time.Duration `yaml:"interval"`
+}
+
+// StickinessConfig is the stickiness attributes of a target group
+type StickinessConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Type is one of lb_cookie, app_cookie or source_ip
+	Type string `yaml:"type"`
+	// CookieDuration is how long requests are routed to the same target
+	// for, with the cookie types
+	CookieDuration time.Duration `yaml:"cookie_duration"`
+	// CookieName is the application's cookie, with the app_cookie type
+	CookieName string `yaml:"cookie_name"`
+}
+
+// Validate checks the TargetGroupAttributesConfig for errors
+func (c *TargetGroupAttributesConfig) Validate() error {
+	if c.DeregistrationDelay != nil && (*c.DeregistrationDelay < 0 || *c.DeregistrationDelay > time.Hour) {
+		return fmt.Errorf("Target group deregistration_delay must be between 0s and 1h")
+	}
+	if c.SlowStart != nil && *c.SlowStart != 0 && (*c.SlowStart < 30*time.Second || *c.SlowStart > 15*time.Minute) {
+		return fmt.Errorf("Target group slow_start must be 0s or between 30s and 15m")
+	}
+	if c.Interval < 0 {
+		return fmt.Errorf("Target group attributes interval must be >=0")
+	}
+	if c.Stickiness == nil {
+		return nil
+	}
+	switch c.Stickiness.Type {
+	case StickinessLBCookie, StickinessSourceIP:
+	case StickinessAppCookie:
+		if c.Stickiness.CookieName == "" {
+			return fmt.Errorf("Target group stickiness cookie_name must be set with the app_cookie type")
+		}
+	case "":
+		if c.Stickiness.Enabled {
+			return fmt.Errorf("Target group stickiness type must be set")
+		}
+	default:
+		return fmt.Errorf("Unknown target group stickiness type %q", c.Stickiness.Type)
+	}
+	if c.Stickiness.CookieDuration < 0 {
+		return fmt.Errorf("Target group stickiness cookie_duration must be >=0")
+	}
+	return nil
+}
+
+// enabled returns whether any attributes are managed
+func (c *TargetGroupAttributesConfig) enabled() bool {
+	return c.DeregistrationDelay != nil || c.SlowStart != nil || c.Stickiness != nil || len(c.Extra) > 0
+}
+
+// desired returns the managed attributes by key, in the format of the aws api
+func (c *TargetGroupAttributesConfig) desired() map[string]string {
+	seconds := func(d time.Duration) string {
+		return strconv.FormatInt(int64(d/time.Second), 10)
+	}
+	attrs := make(map[string]string, len(c.Extra)+5)
+	for k, v := range c.Extra {
+		attrs[k] = v
+	}
+	if c.DeregistrationDelay != nil {
+		attrs["deregistration_delay.timeout_seconds"] = seconds(*c.DeregistrationDelay)
+	}
+	if c.SlowStart != nil {
+		attrs["slow_start.duration_seconds"] = seconds(*c.SlowStart)
+	}
+	if sticky := c.Stickiness; sticky != nil {
+		attrs["stickiness.enabled"] = strconv.FormatBool(sticky.Enabled)
+		if sticky.Type != "" {
+			attrs["stickiness.type"] = sticky.Type
+		}
+		switch sticky.Type {
+		case StickinessLBCookie:
+			if sticky.CookieDuration > 0 {
+				attrs["stickiness.lb_cookie.duration_seconds"] = seconds(sticky.CookieDuration)
+			}
+		case StickinessAppCookie:
+			attrs["stickiness.app_cookie.cookie_name"] = sticky.CookieName
+			if sticky.CookieDuration > 0 {
+				attrs["stickiness.app_cookie.duration_seconds"] = seconds(sticky.CookieDuration)
+			}
+		}
+	}
+	return attrs
+}
+
+// attributeDrift returns the desired attributes which differ from the current
+// ones, sorted by key
+func attributeDrift(desired, current map[string]string) []string {
+	var drifted []string
+	for k, v := range desired {
+		if current[k] != v {
+			drifted = append(drifted, k)
+		}
+	}
+	sort.Strings(drifted)
+	return drifted
+}
+
+// ReconcileSettings to implement the `SettingsDestination` interface, the
+// managed target group attributes which have drifted are reset. The
+// attributes are checked at most every `Attributes.Interval`.
+func (tg *AWSTargetGroup) ReconcileSettings(ctx context.Context) error {
+	attrsCfg := &tg.cfg.Attributes
+	if !attrsCfg.enabled() {
+		return nil
+	}
+	interval := attrsCfg.Interval
+	if interval <= 0 {
+		interval = defaultAttributesInterval
+	}
+	tg.l.Lock()
+	if time.Since(tg.attributesChecked) < interval {
+		tg.l.Unlock()
+		return nil
+	}
+	tg.l.Unlock()
+
+	result, err := tg.svc.DescribeTargetGroupAttributesWithContext(ctx, &elbv2.DescribeTargetGroupAttributesInput{
+		TargetGroupArn: aws.String(tg.cfg.TargetGroupARN),
+	})
+	if err != nil {
+		return wrapAWSError(err)
+	}
+	current := make(map[string]string, len(result.Attributes))
+	for _, attr := range result.Attributes {
+		current[aws.StringValue(attr.Key)] = aws.StringValue(attr.Value)
+	}
+
+	desired := attrsCfg.desired()
+	drifted := attributeDrift(desired, current)
+	if len(drifted) > 0 {
+		attrs := make([]*elbv2.TargetGroupAttribute, len(drifted))
+		for i, k := range drifted {
+			tg.log().Warnf("Target group %s attribute %s is %q, resetting to %q", tg.cfg.TargetGroupARN, k, current[k], desired[k])
+			targetGroupAttributeDriftTotal.WithLabelValues(tg.cfg.TargetGroupARN, k).Inc()
+			attrs[i] = &elbv2.TargetGroupAttribute{
+				Key:   aws.String(k),
+				Value: aws.String(desired[k]),
+			}
+		}
+		if _, err := tg.svc.ModifyTargetGroupAttributesWithContext(ctx, &elbv2.ModifyTargetGroupAttributesInput{
+			TargetGroupArn: aws.String(tg.cfg.TargetGroupARN),
+			Attributes:     attrs,
+		}); err != nil {
+			return wrapAWSError(err)
+		}
+	}
+
+	tg.l.Lock()
+	tg.attributesChecked = time.Now()
+	tg.l.Unlock()
+	return nil
+}
+
+// ReconcileSettings to implement the `SettingsDestination` interface, the
+// attributes of every region's target group are reconciled
+func (m *AWSMultiRegionTargetGroup) ReconcileSettings(ctx context.Context) error {
+	return m.forRegions(m.regions, func(_ int, region *awsRegion) error {
+		if err := region.tg.ReconcileSettings(ctx); err != nil {
+			return fmt.Errorf("Error reconciling target group attributes in region %s: %v", region.cfg.Region, err)
+		}
+		return nil
+	})
+}
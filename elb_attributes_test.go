@@ -0,0 +1,59 @@
+package targetsync
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTargetGroupAttributes(t *testing.T) {
+	delay := 30 * time.Second
+	cfg := &TargetGroupAttributesConfig{
+		DeregistrationDelay: &delay,
+		Stickiness: &StickinessConfig{
+			Enabled:        true,
+			Type:           StickinessLBCookie,
+			CookieDuration: time.Hour,
+		},
+		Extra: map[string]string{"load_balancing.algorithm.type": "least_outstanding_requests"},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	desired := cfg.desired()
+	expected := map[string]string{
+		"deregistration_delay.timeout_seconds":  "30",
+		"stickiness.enabled":                    "true",
+		"stickiness.type":                       "lb_cookie",
+		"stickiness.lb_cookie.duration_seconds": "3600",
+		"load_balancing.algorithm.type":         "least_outstanding_requests",
+	}
+	if !reflect.DeepEqual(desired, expected) {
+		t.Fatalf("Expected %v, got %v", expected, desired)
+	}
+
+	// Unmanaged attributes are ignored
+	current := map[string]string{
+		"deregistration_delay.timeout_seconds":  "300",
+		"stickiness.enabled":                    "true",
+		"stickiness.type":                       "lb_cookie",
+		"stickiness.lb_cookie.duration_seconds": "3600",
+		"load_balancing.algorithm.type":         "round_robin",
+		"slow_start.duration_seconds":           "60",
+	}
+	drifted := attributeDrift(desired, current)
+	if !reflect.DeepEqual(drifted, []string{"deregistration_delay.timeout_seconds", "load_balancing.algorithm.type"}) {
+		t.Fatalf("Unexpected drifted attributes: %v", drifted)
+	}
+
+	slowStart := 10 * time.Second
+	for _, invalid := range []*TargetGroupAttributesConfig{
+		{SlowStart: &slowStart},
+		{Stickiness: &StickinessConfig{Enabled: true}},
+		{Stickiness: &StickinessConfig{Enabled: true, Type: StickinessAppCookie}},
+	} {
+		if err := invalid.Validate(); err == nil {
+			t.Fatalf("Expected error validating %+v", invalid)
+		}
+	}
+}
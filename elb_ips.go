@@ -0,0 +1,181 @@
+package targetsync
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+)
+
+// ipRangesInterval is how long the target group's ranges are cached for, so
+// CIDRs associated with the VPC are picked up without a restart
+const ipRangesInterval = 10 * time.Minute
+
+// outsideVPCRanges are the ranges of targets outside the target group's VPC
+// (e.g. peered VPCs or on-premise networks) which can be registered with the
+// `all` availability zone
+var outsideVPCRanges = []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "100.64.0.0/10"}
+
+// TargetIPValidationConfig is the validation of target IPs before they are
+// registered with an `ip` target group. Targets outside the ranges AWS
+// accepts are rejected with an ErrTargetRejected error listing them, instead
+// of failing the whole registration.
+type TargetIPValidationConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// AllowedCIDRs are ranges allowed in addition to the VPC's CIDRs
+	AllowedCIDRs []string `yaml:"allowed_cidrs"`
+}
+
+// Validate checks the TargetIPValidationConfig for errors
+func (c *TargetIPValidationConfig) Validate() error {
+	for _, cidr := range c.AllowedCIDRs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return fmt.Errorf("Invalid ip_validation allowed_cidrs entry %q: %v", cidr, err)
+		}
+	}
+	return nil
+}
+
+// targetRanges are the ranges of IPs a target group accepts
+type targetRanges struct {
+	// ipv6 is whether the target group's IP address type is ipv6, only
+	// addresses of its type are accepted
+	ipv6   bool
+	ranges []*net.IPNet
+}
+
+// newTargetRanges returns the ranges of the CIDRs for the IP address type
+func newTargetRanges(ipAddressType string, cidrs []string) (*targetRanges, error) {
+	r := &targetRanges{ipv6: ipAddressType == elbv2.TargetGroupIpAddressTypeEnumIpv6}
+	for _, cidr := range cidrs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		r.ranges = append(r.ranges, ipNet)
+	}
+	return r, nil
+}
+
+// contains returns whether the IP is of the target group's type and within
+// one of its ranges
+func (r *targetRanges) contains(ip net.IP) bool {
+	if ip == nil || (ip.To4() == nil) != r.ipv6 {
+		return false
+	}
+	for _, ipNet := range r.ranges {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// partition splits the targets into those within the ranges and those
+// outside them
+func (r *targetRanges) partition(targets []*Target) (valid, rejected []*Target) {
+	for _, target := range targets {
+		if r.contains(net.ParseIP(target.IP)) {
+			valid = append(valid, target)
+		} else {
+			rejected = append(rejected, target)
+		}
+	}
+	return valid, rejected
+}
+
+func (r *targetRanges) String() string {
+	cidrs := make([]string, len(r.ranges))
+	for i, ipNet := range r.ranges {
+		cidrs[i] = ipNet.String()
+	}
+	return strings.Join(cidrs, ", ")
+}
+
+// ipRanges returns the ranges the target group accepts, or nil if it doesn't
+// have `ip` targets. They are looked up at most every ipRangesInterval.
+func (tg *AWSTargetGroup) ipRanges(ctx context.Context) (*targetRanges, error) {
+	tg.l.Lock()
+	defer tg.l.Unlock()
+	if !tg.rangesChecked.IsZero() && time.Since(tg.rangesChecked) < ipRangesInterval {
+		return tg.ranges, nil
+	}
+
+	result, err := tg.svc.DescribeTargetGroupsWithContext(ctx, &elbv2.DescribeTargetGroupsInput{
+		TargetGroupArns: []*string{aws.String(tg.cfg.TargetGroupARN)},
+	})
+	if err != nil {
+		return nil, wrapAWSError(err)
+	}
+	if len(result.TargetGroups) == 0 {
+		return nil, fmt.Errorf("Target group %s doesn't exist", tg.cfg.TargetGroupARN)
+	}
+	group := result.TargetGroups[0]
+	if aws.StringValue(group.TargetType) != elbv2.TargetTypeEnumIp {
+		tg.ranges, tg.rangesChecked = nil, time.Now()
+		return nil, nil
+	}
+
+	ipAddressType := aws.StringValue(group.IpAddressType)
+	cidrs := append([]string(nil), tg.cfg.IPValidation.AllowedCIDRs...)
+	vpcs, err := tg.ec2.DescribeVpcsWithContext(ctx, &ec2.DescribeVpcsInput{
+		VpcIds: []*string{group.VpcId},
+	})
+	if err != nil {
+		return nil, wrapAWSError(err)
+	}
+	for _, vpc := range vpcs.Vpcs {
+		for _, assoc := range vpc.CidrBlockAssociationSet {
+			if aws.StringValue(assoc.CidrBlockState.State) == ec2.VpcCidrBlockStateCodeAssociated {
+				cidrs = append(cidrs, aws.StringValue(assoc.CidrBlock))
+			}
+		}
+		for _, assoc := range vpc.Ipv6CidrBlockAssociationSet {
+			if aws.StringValue(assoc.Ipv6CidrBlockState.State) == ec2.VpcCidrBlockStateCodeAssociated {
+				cidrs = append(cidrs, aws.StringValue(assoc.Ipv6CidrBlock))
+			}
+		}
+	}
+	if tg.cfg.AvailabilityZone == "all" {
+		cidrs = append(cidrs, outsideVPCRanges...)
+	}
+
+	ranges, err := newTargetRanges(ipAddressType, cidrs)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing ranges of target group %s: %v", tg.cfg.TargetGroupARN, err)
+	}
+	tg.ranges, tg.rangesChecked = ranges, time.Now()
+	tg.log().Debugf("Target group %s (%s) accepts targets in %s", tg.cfg.TargetGroupARN, ipAddressType, ranges)
+	return ranges, nil
+}
+
+// validateIPs returns the targets within the ranges the target group
+// accepts, and an ErrTargetRejected error listing the others, if
+// `IPValidation` is enabled
+func (tg *AWSTargetGroup) validateIPs(ctx context.Context, targets []*Target) ([]*Target, error) {
+	if !tg.cfg.IPValidation.Enabled || len(targets) == 0 {
+		return targets, nil
+	}
+	ranges, err := tg.ipRanges(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Error looking up target group ranges: %v", err)
+	}
+	if ranges == nil {
+		return targets, nil
+	}
+	valid, rejected := ranges.partition(targets)
+	if len(rejected) == 0 {
+		return valid, nil
+	}
+	keys := make([]string, len(rejected))
+	for i, target := range rejected {
+		keys[i] = target.Key()
+	}
+	return valid, wrapError(ErrTargetRejected, fmt.Errorf("%d targets are outside the ranges of target group %s (%s): %s",
+		len(rejected), tg.cfg.TargetGroupARN, ranges, strings.Join(keys, ", ")))
+}
@@ -0,0 +1,44 @@
+package targetsync
+
+import (
+	"testing"
+)
+
+func TestTargetRanges(t *testing.T) {
+	ranges, err := newTargetRanges("ipv4", []string{"10.0.0.0/16", "2600:1f14::/56"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	inVPC := &Target{IP: "10.0.1.1", Port: 80}
+	outside := &Target{IP: "10.1.0.1", Port: 80}
+	ipv6 := &Target{IP: "2600:1f14::1", Port: 80}
+	invalid := &Target{IP: "i-0123456789", Port: 80}
+	valid, rejected := ranges.partition([]*Target{inVPC, outside, ipv6, invalid})
+	if err := equalTargets(valid, []*Target{inVPC}); err != nil {
+		t.Fatalf("Unexpected valid targets: %v", err)
+	}
+	// IPv6 targets are rejected by ipv4 target groups, even within the VPC
+	if err := equalTargets(rejected, []*Target{outside, ipv6, invalid}); err != nil {
+		t.Fatalf("Unexpected rejected targets: %v", err)
+	}
+
+	ranges, err = newTargetRanges("ipv6", []string{"10.0.0.0/16", "2600:1f14::/56"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	valid, _ = ranges.partition([]*Target{inVPC, ipv6})
+	if err := equalTargets(valid, []*Target{ipv6}); err != nil {
+		t.Fatalf("Unexpected valid targets: %v", err)
+	}
+}
+
+func TestTargetIPValidationConfig(t *testing.T) {
+	cfg := &TargetIPValidationConfig{Enabled: true, AllowedCIDRs: []string{"192.168.0.0/24"}}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	cfg.AllowedCIDRs = append(cfg.AllowedCIDRs, "192.168.0.0")
+	if err := cfg.Validate(); err == nil {
+		t.Fatalf("Expected an error for an invalid CIDR")
+	}
+}
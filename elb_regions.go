@@ -0,0 +1,383 @@
+package targetsync
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// NewAWSMultiRegionTargetGroup returns a destination that maintains targets
+// across the regional target groups defined in `cfg.Regions`
+func NewAWSMultiRegionTargetGroup(cfg *AWSConfig) (*AWSMultiRegionTargetGroup, error) {
+	if len(cfg.Regions) == 0 {
+		return nil, fmt.Errorf("No regions defined")
+	}
+
+	policy := cfg.RegionPolicy
+	if policy == "" {
+		policy = RegionPolicyMirror
+	}
+
+	regions := make([]*awsRegion, len(cfg.Regions))
+	for i, regionCfg := range cfg.Regions {
+		tg, err := NewAWSTargetGroup(&AWSConfig{
+			TargetGroupARN:   regionCfg.TargetGroupARN,
+			AvailabilityZone: regionCfg.AvailabilityZone,
+			Region:           regionCfg.Region,
+			InferPort:        cfg.InferPort,
+			BatchSize:        cfg.BatchSize,
+			Attributes:       cfg.Attributes,
+			IPValidation:     cfg.IPValidation,
+			Credentials:      cfg.Credentials,
+			LoggerConfig:     cfg.LoggerConfig,
+		})
+		if err != nil {
+			return nil, err
+		}
+		regions[i] = &awsRegion{
+			cfg: regionCfg,
+			tg:  tg,
+		}
+	}
+
+	concurrency := cfg.RegionConcurrency
+	if concurrency <= 0 {
+		concurrency = len(regions)
+	}
+
+	return &AWSMultiRegionTargetGroup{
+		policy:      policy,
+		regions:     regions,
+		client:      http.DefaultClient,
+		concurrency: concurrency,
+		logger:      cfg.Logger,
+	}, nil
+}
+
+type awsRegion struct {
+	cfg AWSRegionConfig
+	tg  SettingsDestination
+
+	l sync.Mutex
+	// targets are the keys of the targets registered in the region as of the
+	// last GetTargets and the mutations since, nil if they aren't known
+	targets map[string]struct{}
+}
+
+// setTargets records the targets registered in the region
+func (r *awsRegion) setTargets(targets []*Target) {
+	r.l.Lock()
+	defer r.l.Unlock()
+	r.targets = targetSetKey(targets)
+}
+
+// filterTargets returns the targets which are (or aren't) registered in the
+// region, all of them if the region's targets aren't known
+func (r *awsRegion) filterTargets(targets []*Target, registered bool) []*Target {
+	r.l.Lock()
+	defer r.l.Unlock()
+	if r.targets == nil {
+		return targets
+	}
+	filtered := make([]*Target, 0, len(targets))
+	for _, target := range targets {
+		if _, ok := r.targets[target.Key()]; ok == registered {
+			filtered = append(filtered, target)
+		}
+	}
+	return filtered
+}
+
+// updateTargets records the targets as added to (or removed from) the region
+func (r *awsRegion) updateTargets(targets []*Target, added bool) {
+	r.l.Lock()
+	defer r.l.Unlock()
+	if r.targets == nil {
+		return
+	}
+	for _, target := range targets {
+		if added {
+			r.targets[target.Key()] = struct{}{}
+		} else {
+			delete(r.targets, target.Key())
+		}
+	}
+}
+
+// AWSMultiRegionTargetGroup is a TargetDestination implementation spanning
+// target groups in multiple regions. With the mirror policy every region
+// gets all targets, with the active policy only the first healthy region
+// (in config order) is maintained. The active region is re-evaluated on
+// every call to GetTargets, and the previously active regions are drained
+// after a failover.
+type AWSMultiRegionTargetGroup struct {
+	policy  RegionPolicy
+	regions []*awsRegion
+	client  *http.Client
+	// concurrency is how many regions are called at once
+	concurrency int
+
+	l      sync.Mutex
+	active *awsRegion
+	// draining are the previously active regions whose targets are still
+	// to be removed
+	draining []*awsRegion
+	// partial are the targets registered in only some of the regions with
+	// the mirror policy, as of the last GetTargets
+	partial []*Target
+
+	logger Logger
+}
+
+// log returns the destination's Logger
+func (m *AWSMultiRegionTargetGroup) log() Logger {
+	return loggerOr(m.logger)
+}
+
+// healthy checks the health signal for the given region
+func (m *AWSMultiRegionTargetGroup) healthy(ctx context.Context, region *awsRegion) bool {
+	if region.cfg.HealthCheckURL == "" {
+		return true
+	}
+	req, err := http.NewRequest(http.MethodGet, region.cfg.HealthCheckURL, nil)
+	if err != nil {
+		m.log().Errorf("Error creating health check request for region %s: %v", region.cfg.Region, err)
+		return false
+	}
+	resp, err := m.client.Do(req.WithContext(ctx))
+	if err != nil {
+		m.log().Warnf("Health check failed for region %s: %v", region.cfg.Region, err)
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode >= 200 && resp.StatusCode < 300
+}
+
+// selectActive picks the first healthy region as the active one, the
+// previously active region is drained
+func (m *AWSMultiRegionTargetGroup) selectActive(ctx context.Context) (*awsRegion, error) {
+	for _, region := range m.regions {
+		if m.healthy(ctx, region) {
+			m.setActive(region)
+			return region, nil
+		}
+	}
+	return nil, fmt.Errorf("No healthy regions")
+}
+
+// setActive sets the active region, queueing the previously active region to
+// be drained
+func (m *AWSMultiRegionTargetGroup) setActive(region *awsRegion) {
+	m.l.Lock()
+	defer m.l.Unlock()
+	if m.active == region {
+		return
+	}
+	m.log().Infof("Active region is now %s", region.cfg.Region)
+	draining := make([]*awsRegion, 0, len(m.draining)+1)
+	for _, r := range m.draining {
+		if r != region {
+			draining = append(draining, r)
+		}
+	}
+	if m.active != nil {
+		draining = append(draining, m.active)
+	}
+	m.draining = draining
+	m.active = region
+}
+
+// drain removes all targets from the previously active regions, regions
+// which fail to drain are retried on the next call
+func (m *AWSMultiRegionTargetGroup) drain(ctx context.Context) {
+	m.l.Lock()
+	draining := m.draining
+	m.l.Unlock()
+	if len(draining) == 0 {
+		return
+	}
+
+	drained := make([]bool, len(draining))
+	m.forRegions(draining, func(i int, region *awsRegion) error {
+		targets, err := region.tg.GetTargets(ctx)
+		if err != nil {
+			m.log().Warnf("Error getting targets to drain from region %s: %v", region.cfg.Region, err)
+			return nil
+		}
+		if len(targets) > 0 {
+			if err := region.tg.RemoveTargets(ctx, targets); err != nil {
+				m.log().Warnf("Error draining targets from region %s: %v", region.cfg.Region, err)
+				return nil
+			}
+			m.log().Infof("Drained %d targets from previously active region %s", len(targets), region.cfg.Region)
+		}
+		drained[i] = true
+		return nil
+	})
+
+	m.l.Lock()
+	defer m.l.Unlock()
+	remaining := make([]*awsRegion, 0, len(m.draining))
+	for _, region := range m.draining {
+		done := false
+		for i, r := range draining {
+			if r == region && drained[i] {
+				done = true
+			}
+		}
+		// Regions which became active again are no longer drained
+		if !done && region != m.active {
+			remaining = append(remaining, region)
+		}
+	}
+	m.draining = remaining
+}
+
+// forRegions calls `fn` for each of the regions, up to `concurrency` at once,
+// returning the first error
+func (m *AWSMultiRegionTargetGroup) forRegions(regions []*awsRegion, fn func(int, *awsRegion) error) error {
+	sem := make(chan struct{}, m.concurrency)
+	errs := make([]error, len(regions))
+	var wg sync.WaitGroup
+	for i, region := range regions {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, region *awsRegion) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			errs[i] = fn(i, region)
+		}(i, region)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// targetRegions returns the regions that mutations should be applied to
+func (m *AWSMultiRegionTargetGroup) targetRegions() ([]*awsRegion, error) {
+	if m.policy == RegionPolicyMirror {
+		return m.regions, nil
+	}
+
+	m.l.Lock()
+	defer m.l.Unlock()
+	if m.active == nil {
+		return nil, fmt.Errorf("No active region selected")
+	}
+	return []*awsRegion{m.active}, nil
+}
+
+// GetTargets returns the current set of targets at the destination. In mirror
+// mode the targets registered in every region are returned, those registered
+// in only some of them are returned by `PartialTargets`. In active mode the
+// active region's targets are returned, once the previously active regions
+// have been drained.
+func (m *AWSMultiRegionTargetGroup) GetTargets(ctx context.Context) ([]*Target, error) {
+	if m.policy == RegionPolicyActive {
+		region, err := m.selectActive(ctx)
+		if err != nil {
+			return nil, err
+		}
+		m.drain(ctx)
+		return region.tg.GetTargets(ctx)
+	}
+
+	regionTargets := make([][]*Target, len(m.regions))
+	if err := m.forRegions(m.regions, func(i int, region *awsRegion) error {
+		targets, err := region.tg.GetTargets(ctx)
+		if err != nil {
+			return err
+		}
+		regionTargets[i] = targets
+		region.setTargets(targets)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	targets, partial := splitTargets(regionTargets)
+	m.l.Lock()
+	m.partial = partial
+	m.l.Unlock()
+	return targets, nil
+}
+
+// PartialTargets to implement the `PartialDestination` interface, the
+// targets registered in only some of the regions with the mirror policy
+func (m *AWSMultiRegionTargetGroup) PartialTargets() []*Target {
+	m.l.Lock()
+	defer m.l.Unlock()
+	return m.partial
+}
+
+// splitTargets returns the targets in all of the sets, and those in only
+// some of them
+func splitTargets(sets [][]*Target) (all, partial []*Target) {
+	targetMap := make(map[string]*Target)
+	counts := make(map[string]int)
+	for _, targets := range sets {
+		for key := range targetSetKey(targets) {
+			counts[key]++
+		}
+		for _, target := range targets {
+			targetMap[target.Key()] = target
+		}
+	}
+
+	all = make([]*Target, 0, len(targetMap))
+	for key, target := range targetMap {
+		if counts[key] == len(sets) {
+			all = append(all, target)
+		} else {
+			partial = append(partial, target)
+		}
+	}
+	return all, partial
+}
+
+// AddTargets adds the targets to all regions covered by the policy, skipping
+// the regions they are already registered in. The regions are updated
+// concurrently, but all of them are done before returning so adds always
+// complete before any subsequent removal.
+func (m *AWSMultiRegionTargetGroup) AddTargets(ctx context.Context, targets []*Target) error {
+	regions, err := m.targetRegions()
+	if err != nil {
+		return err
+	}
+	return m.forRegions(regions, func(_ int, region *awsRegion) error {
+		missing := region.filterTargets(targets, false)
+		if len(missing) == 0 {
+			return nil
+		}
+		if err := region.tg.AddTargets(ctx, missing); err != nil {
+			return fmt.Errorf("Error adding targets in region %s: %v", region.cfg.Region, err)
+		}
+		region.updateTargets(missing, true)
+		return nil
+	})
+}
+
+// RemoveTargets removes the targets from all regions covered by the policy,
+// skipping the regions they aren't registered in
+func (m *AWSMultiRegionTargetGroup) RemoveTargets(ctx context.Context, targets []*Target) error {
+	regions, err := m.targetRegions()
+	if err != nil {
+		return err
+	}
+	return m.forRegions(regions, func(_ int, region *awsRegion) error {
+		registered := region.filterTargets(targets, true)
+		if len(registered) == 0 {
+			return nil
+		}
+		if err := region.tg.RemoveTargets(ctx, registered); err != nil {
+			return fmt.Errorf("Error removing targets in region %s: %v", region.cfg.Region, err)
+		}
+		region.updateTargets(registered, false)
+		return nil
+	})
+}
@@ -0,0 +1,148 @@
+package targetsync
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestMirrorPartialRegions(t *testing.T) {
+	a, b, c := &Target{IP: "1", Port: 80}, &Target{IP: "2", Port: 80}, &Target{IP: "3", Port: 80}
+	east, west := &awsRegion{}, &awsRegion{}
+	east.setTargets([]*Target{a, b})
+	west.setTargets([]*Target{a})
+
+	// Targets registered in only some of the regions are returned separately,
+	// so they are added to the other regions or removed
+	all, partial := splitTargets([][]*Target{{a, b}, {a}})
+	if err := equalTargets(all, []*Target{a}); err != nil {
+		t.Fatalf("Unexpected targets: %v", err)
+	}
+	if err := equalTargets(partial, []*Target{b}); err != nil {
+		t.Fatalf("Unexpected partial targets: %v", err)
+	}
+
+	// Each region is only sent the targets it is missing, or still has
+	added := []*Target{b, c}
+	if err := equalTargets(east.filterTargets(added, false), []*Target{c}); err != nil {
+		t.Fatalf("Unexpected targets added to east: %v", err)
+	}
+	if err := equalTargets(west.filterTargets(added, false), []*Target{b, c}); err != nil {
+		t.Fatalf("Unexpected targets added to west: %v", err)
+	}
+	removed := []*Target{b}
+	if err := equalTargets(east.filterTargets(removed, true), []*Target{b}); err != nil {
+		t.Fatalf("Unexpected targets removed from east: %v", err)
+	}
+	if registered := west.filterTargets(removed, true); len(registered) != 0 {
+		t.Fatalf("Unexpected targets removed from west: %v", registered)
+	}
+
+	// Mutations are recorded until the next GetTargets
+	west.updateTargets(added, true)
+	if missing := west.filterTargets(added, false); len(missing) != 0 {
+		t.Fatalf("Added targets not recorded: %v", missing)
+	}
+	east.updateTargets(removed, false)
+	if registered := east.filterTargets(removed, true); len(registered) != 0 {
+		t.Fatalf("Removed targets not recorded: %v", registered)
+	}
+
+	// Regions whose targets aren't known yet are sent all of them
+	if err := equalTargets((&awsRegion{}).filterTargets(added, true), added); err != nil {
+		t.Fatalf("Unexpected targets for an unknown region: %v", err)
+	}
+}
+
+// settingsMockDestination is a mockDestination without settings
+type settingsMockDestination struct {
+	*mockDestination
+}
+
+func (settingsMockDestination) ReconcileSettings(context.Context) error {
+	return nil
+}
+
+func TestMirrorRepairsRegions(t *testing.T) {
+	a, b := &Target{IP: "1", Port: 80}, &Target{IP: "2", Port: 80}
+	east := settingsMockDestination{newmockDestination()}
+	west := settingsMockDestination{newmockDestination()}
+	ctx := context.Background()
+	east.AddTargets(ctx, []*Target{a, b})
+	west.AddTargets(ctx, []*Target{a})
+	dst := &AWSMultiRegionTargetGroup{
+		policy: RegionPolicyMirror,
+		regions: []*awsRegion{
+			{cfg: AWSRegionConfig{Region: "east"}, tg: east},
+			{cfg: AWSRegionConfig{Region: "west"}, tg: west},
+		},
+		concurrency: 2,
+	}
+	syncer := &Syncer{
+		Config: &SyncConfig{LockOptions: LockOptions{Key: "regions"}},
+		Dst:    dst,
+	}
+	state := &leaderState{
+		removeCh: make(chan *Target, 10),
+		addCh:    make(chan *Target, 10),
+		known:    make(map[string]*Target),
+		aborted:  make(map[string]struct{}),
+	}
+
+	// b is in the source, so is added to the region missing it
+	if err := syncer.syncSnapshot(ctx, []*Target{a, b}, state); err != nil {
+		t.Fatalf("Error syncing: %v", err)
+	}
+	for _, region := range []settingsMockDestination{east, west} {
+		targets, _ := region.GetTargets(ctx)
+		if err := equalTargets(targets, []*Target{a, b}); err != nil {
+			t.Fatalf("Region not repaired: %v", err)
+		}
+	}
+}
+
+func TestActiveRegionFailover(t *testing.T) {
+	a := &Target{IP: "1", Port: 80}
+	east := settingsMockDestination{newmockDestination()}
+	west := settingsMockDestination{newmockDestination()}
+	ctx := context.Background()
+	dst := &AWSMultiRegionTargetGroup{
+		policy: RegionPolicyActive,
+		regions: []*awsRegion{
+			{cfg: AWSRegionConfig{Region: "east"}, tg: east},
+			{cfg: AWSRegionConfig{Region: "west"}, tg: west},
+		},
+		client:      &http.Client{Timeout: time.Second},
+		concurrency: 2,
+	}
+
+	if _, err := dst.GetTargets(ctx); err != nil {
+		t.Fatalf("Error getting targets: %v", err)
+	}
+	if err := dst.AddTargets(ctx, []*Target{a}); err != nil {
+		t.Fatalf("Error adding targets: %v", err)
+	}
+
+	// Fail over to west, east is drained
+	dst.regions[0].cfg.HealthCheckURL = "http://127.0.0.1:0/unhealthy"
+	targets, err := dst.GetTargets(ctx)
+	if err != nil {
+		t.Fatalf("Error getting targets: %v", err)
+	}
+	if len(targets) != 0 {
+		t.Fatalf("Unexpected targets in the new active region: %v", targets)
+	}
+	if err := dst.AddTargets(ctx, []*Target{a}); err != nil {
+		t.Fatalf("Error adding targets: %v", err)
+	}
+	if targets, _ := east.GetTargets(ctx); len(targets) != 0 {
+		t.Fatalf("Previously active region not drained: %v", targets)
+	}
+	if targets, _ := west.GetTargets(ctx); len(targets) != 1 {
+		t.Fatalf("Targets not added to the active region: %v", targets)
+	}
+	if len(dst.draining) != 0 {
+		t.Fatalf("Drained region still draining: %v", dst.draining)
+	}
+}
@@ -4,7 +4,6 @@ import (
 	"context"
 	"time"
 
-	"github.com/sirupsen/logrus"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
@@ -17,21 +16,26 @@ type K8sEndpointsSource struct {
 	clientset       *kubernetes.Clientset
 	name, namespace string
 	port            int
+	logger          Logger
+}
+
+// log returns the source's Logger
+func (s *K8sEndpointsSource) log() Logger {
+	return loggerOr(s.logger)
+}
+
+// k8sRestConfig returns the client config for the k8s api server
+func k8sRestConfig(cfg *K8sConfig) (*rest.Config, error) {
+	if cfg.InCluster {
+		return rest.InClusterConfig()
+	}
+	return clientcmd.BuildConfigFromFlags("", cfg.KubeConfigPath)
 }
 
 func NewK8sEndpointsSource(cfg *K8sEndpointsConfig) (*K8sEndpointsSource, error) {
-	var config *rest.Config
-	var err error
-	if cfg.K8sConfig.InCluster {
-		config, err = rest.InClusterConfig()
-		if err != nil {
-			return nil, err
-		}
-	} else {
-		config, err = clientcmd.BuildConfigFromFlags("", cfg.K8sConfig.KubeConfigPath)
-		if err != nil {
-			return nil, err
-		}
+	config, err := k8sRestConfig(&cfg.K8sConfig)
+	if err != nil {
+		return nil, err
 	}
 
 	c, err := kubernetes.NewForConfig(config)
@@ -44,6 +48,7 @@ func NewK8sEndpointsSource(cfg *K8sEndpointsConfig) (*K8sEndpointsSource, error)
 		name:      cfg.Name,
 		namespace: cfg.Namespace,
 		port:      cfg.Port,
+		logger:    cfg.Logger,
 	}, nil
 }
 
@@ -67,10 +72,14 @@ func (s *K8sEndpointsSource) Subscribe(ctx context.Context) (chan []*Target, err
 
 			for _, subset := range ends.Subsets {
 				for _, addr := range subset.Addresses {
-					targets = append(targets, &Target{
+					target := &Target{
 						IP:   addr.IP,
 						Port: s.port,
-					})
+					}
+					if addr.TargetRef != nil {
+						target.Meta = map[string]string{MetaHostname: addr.TargetRef.Name}
+					}
+					targets = append(targets, target)
 				}
 			}
 			ch <- targets
@@ -90,10 +99,15 @@ func (s *K8sEndpointsSource) Lock(ctx context.Context, opts *LockOptions) (<-cha
 			Namespace: leaseLockNamespace,
 		},
 		Client: s.clientset.CoreV1(),
+		LockConfig: resourcelock.ResourceLockConfig{
+			Identity: opts.Identity,
+		},
 	}
 
 	lockedCh := make(chan bool, 1)
+	lockAttemptsTotal.WithLabelValues(opts.name()).Inc()
 
+	var holder string
 	// start the leader election code loop
 	leaderelection.RunOrDie(ctx, leaderelection.LeaderElectionConfig{
 		Lock:            lock,
@@ -104,14 +118,18 @@ func (s *K8sEndpointsSource) Lock(ctx context.Context, opts *LockOptions) (<-cha
 		RetryPeriod:   2 * time.Second,
 		Callbacks: leaderelection.LeaderCallbacks{
 			OnStartedLeading: func(ctx context.Context) {
-				logrus.Infof("Lock acquired")
+				s.log().Infof("Lock acquired")
 				lockedCh <- true
 			},
 			OnStoppedLeading: func() {
-				logrus.Infof("Lock lost")
+				s.log().Infof("Lock lost")
 				lockedCh <- false
 			},
-			OnNewLeader: func(identity string) {},
+			OnNewLeader: func(identity string) {
+				s.log().Infof("Lock %s holder changed from %q to %q", opts.Key, holder, identity)
+				setLockHolder(opts.name(), holder, identity)
+				holder = identity
+			},
 		},
 	})
 
@@ -0,0 +1,102 @@
+package targetsync
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	// ErrLockLost is the class of errors caused by losing (or being unable
+	// to hold) the lock
+	ErrLockLost = errors.New("lock lost")
+	// ErrSourceUnavailable is the class of errors caused by the TargetSource
+	ErrSourceUnavailable = errors.New("source unavailable")
+	// ErrDestinationThrottled is the class of errors caused by the
+	// TargetDestination rate limiting our requests
+	ErrDestinationThrottled = errors.New("destination throttled")
+	// ErrConfigInvalid is the class of errors caused by a bad config
+	ErrConfigInvalid = errors.New("config invalid")
+	// ErrOwnershipConflict is the class of errors caused by the destination
+	// being owned by another sync pair or deployment
+	ErrOwnershipConflict = errors.New("ownership conflict")
+	// ErrMutationBudgetExceeded is the class of errors caused by mutations
+	// being paused by the mutation budget
+	ErrMutationBudgetExceeded = errors.New("mutation budget exceeded")
+	// ErrTargetRejected is the class of errors caused by targets the
+	// destination can't accept, e.g. IPs outside a target group's VPC
+	ErrTargetRejected = errors.New("target rejected")
+	// ErrHookRejected is the class of errors caused by an orchestration hook
+	// not acknowledging a mutation
+	ErrHookRejected = errors.New("hook rejected")
+)
+
+// Error is an error of a given class (one of the Err* sentinels) wrapping
+// the underlying cause
+type Error struct {
+	Class error
+	Err   error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%v: %v", e.Class, e.Err)
+}
+
+// Cause returns the underlying error
+func (e *Error) Cause() error {
+	return e.Err
+}
+
+// Unwrap returns the underlying error
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether the error is of class `target`
+func (e *Error) Is(target error) bool {
+	return e.Class == target
+}
+
+// wrapError wraps `err` in an Error of the given class
+func wrapError(class, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{Class: class, Err: err}
+}
+
+// IsErrorClass returns whether `err` is (or wraps) an Error of the given
+// class
+func IsErrorClass(err, class error) bool {
+	return errors.Is(err, class)
+}
+
+// ErrorClass returns the name of the class of `err` for labelling events and
+// alerts, e.g. `destination_throttled`, or `unknown` if it isn't (and doesn't
+// wrap) an Error
+func ErrorClass(err error) string {
+	var e *Error
+	if !errors.As(err, &e) || e.Class == nil {
+		return "unknown"
+	}
+	return strings.Replace(e.Class.Error(), " ", "_", -1)
+}
+
+// throttleCodes are the aws error codes returned when requests are throttled
+var throttleCodes = map[string]struct{}{
+	"Throttling":                             {},
+	"ThrottlingException":                    {},
+	"ThrottledException":                     {},
+	"RequestThrottledException":              {},
+	"TooManyRequestsException":               {},
+	"RequestLimitExceeded":                   {},
+	"ProvisionedThroughputExceededException": {},
+}
+
+// wrapAWSError classifies throttling errors from aws as ErrDestinationThrottled
+func wrapAWSError(err error) error {
+	if isAWSThrottle(err) {
+		return wrapError(ErrDestinationThrottled, err)
+	}
+	return err
+}
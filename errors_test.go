@@ -0,0 +1,43 @@
+package targetsync
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestErrorClass(t *testing.T) {
+	cause := fmt.Errorf("rate exceeded")
+	err := wrapError(ErrDestinationThrottled, cause)
+	tests := []struct {
+		err   error
+		class error
+		name  string
+	}{
+		{err: err, class: ErrDestinationThrottled, name: "destination_throttled"},
+		// Classified errors wrapped again keep their class
+		{err: fmt.Errorf("Error adding targets: %w", err), class: ErrDestinationThrottled, name: "destination_throttled"},
+		{err: fmt.Errorf("Destination add_targets timed out: %w", fmt.Errorf("batch 1: %w", err)), class: ErrDestinationThrottled, name: "destination_throttled"},
+		// The sentinels are their own class
+		{err: ErrLockLost, class: ErrLockLost, name: "unknown"},
+		// Formatted with %v the class is lost
+		{err: fmt.Errorf("Error adding targets: %v", err), name: "unknown"},
+		{err: cause, name: "unknown"},
+		{name: "unknown"},
+	}
+
+	for i, test := range tests {
+		if name := ErrorClass(test.err); name != test.name {
+			t.Fatalf("%d: expected class %s, got %s", i, test.name, name)
+		}
+		for _, class := range []error{ErrDestinationThrottled, ErrLockLost, ErrConfigInvalid} {
+			if IsErrorClass(test.err, class) != (class == test.class) {
+				t.Fatalf("%d: unexpected IsErrorClass(%v, %v)", i, test.err, class)
+			}
+		}
+	}
+
+	// The cause is still reachable through the class
+	if e := err.(*Error); e.Unwrap() != cause {
+		t.Fatalf("Unexpected cause: %v", e.Unwrap())
+	}
+}
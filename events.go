@@ -0,0 +1,95 @@
+package targetsync
+
+import "time"
+
+// EventType identifies the kind of an Event
+type EventType string
+
+const (
+	// EventFlappingDetected is emitted when the source changes too often and
+	// updates start being coalesced
+	EventFlappingDetected EventType = "flapping_detected"
+	// EventLockAcquired is emitted when this process becomes the leader
+	EventLockAcquired EventType = "lock_acquired"
+	// EventLockLost is emitted when this process stops being the leader
+	EventLockLost EventType = "lock_lost"
+	// EventSessionRenewalFailed is emitted when the lock's session could not
+	// be renewed
+	EventSessionRenewalFailed EventType = "session_renewal_failed"
+	// EventTargetsAdded is emitted when targets are added to the destination
+	EventTargetsAdded EventType = "targets_added"
+	// EventTargetsRemoved is emitted when targets are removed from the
+	// destination
+	EventTargetsRemoved EventType = "targets_removed"
+	// EventRolloutAborted is emitted when a gradual rollout is aborted due
+	// to the added targets being unhealthy
+	EventRolloutAborted EventType = "rollout_aborted"
+	// EventTargetCountAnomaly is emitted when the number of source targets
+	// deviates too far from the baseline
+	EventTargetCountAnomaly EventType = "target_count_anomaly"
+	// EventRemovalFailed is emitted when the removal of targets is given up
+	// on after `RemoveRetry.MaxAttempts` failures, the targets are left in
+	// the destination until the next sync schedules their removal again
+	EventRemovalFailed EventType = "removal_failed"
+	// EventRemovalSkipped is emitted instead of removing targets when the
+	// `RemoveMode` is none
+	EventRemovalSkipped EventType = "removal_skipped"
+	// EventSourceStale is emitted when the source has been stale for longer
+	// than `SourceCache.MaxStaleness`, and syncing is paused
+	EventSourceStale EventType = "source_stale"
+	// EventTargetsAdopted is emitted when the destination targets missing
+	// from the source are adopted on the first sync (see `AdoptConfig`)
+	EventTargetsAdopted EventType = "targets_adopted"
+	// EventOwnershipConflict is emitted when the destination is owned by
+	// another sync pair or deployment, and so isn't synced
+	EventOwnershipConflict EventType = "ownership_conflict"
+	// EventMutationBudgetExceeded is emitted when mutating the destination
+	// would exceed the mutation budget, and mutations are paused until
+	// resumed
+	EventMutationBudgetExceeded EventType = "mutation_budget_exceeded"
+	// EventSyncFailing is emitted when syncs of the destination have failed
+	// continuously for longer than `FailureThreshold`
+	EventSyncFailing EventType = "sync_failing"
+	// EventSyncRecovered is emitted on the first successful sync after an
+	// EventSyncFailing
+	EventSyncRecovered EventType = "sync_recovered"
+	// EventDestinationThrottled is emitted when the AWS API calls of a
+	// destination call are throttled, whether or not the retries succeed
+	EventDestinationThrottled EventType = "destination_throttled"
+)
+
+// Event is a notable occurrence within the Syncer
+type Event struct {
+	Type EventType `json:"type"`
+	// Name of the sync pair the event is from
+	Name string `json:"name"`
+	// Key is the lock key of the sync pair the event is from
+	Key string `json:"key"`
+	// Destination identifies the destination of the sync pair, as
+	// `type:id` (see `PairConfig.Destination`)
+	Destination string    `json:"destination,omitempty"`
+	Time        time.Time `json:"time"`
+	Message     string    `json:"message"`
+	// ErrorClass is the class of the error which caused the event, if any
+	// (see `ErrorClass`)
+	ErrorClass string    `json:"error_class,omitempty"`
+	Targets    []*Target `json:"targets,omitempty"`
+}
+
+// EventSink receives events emitted by the Syncer
+type EventSink interface {
+	Emit(Event)
+}
+
+// LogEventSink is an EventSink which simply logs the events
+type LogEventSink struct{}
+
+// Emit logs the event
+func (LogEventSink) Emit(e Event) {
+	switch e.Type {
+	case EventFlappingDetected, EventSessionRenewalFailed, EventRolloutAborted, EventTargetCountAnomaly, EventRemovalFailed, EventSourceStale, EventOwnershipConflict, EventMutationBudgetExceeded, EventSyncFailing, EventDestinationThrottled:
+		logger.Warnf("%s event for %s: %s", e.Type, e.Name, e.Message)
+	default:
+		logger.Infof("%s event for %s: %s", e.Type, e.Name, e.Message)
+	}
+}
@@ -0,0 +1,107 @@
+package targetsync
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// eventStreamKeepalive is how often a comment is sent to idle event streams so
+// proxies don't close them
+const eventStreamKeepalive = 15 * time.Second
+
+// NewEventStream returns a new EventStream, forwarding the events to `next`
+// (or logging them if nil)
+func NewEventStream(next EventSink) *EventStream {
+	if next == nil {
+		next = LogEventSink{}
+	}
+	return &EventStream{
+		next: next,
+		subs: make(map[chan Event]struct{}),
+	}
+}
+
+// EventStream is an EventSink which streams the events to HTTP clients as
+// server-sent events (see `ServeHTTP`)
+type EventStream struct {
+	next EventSink
+
+	l    sync.Mutex
+	subs map[chan Event]struct{}
+}
+
+// Emit sends the event to all subscribers and the next EventSink. Subscribers
+// which aren't keeping up miss events rather than blocking the Syncer.
+func (s *EventStream) Emit(e Event) {
+	s.next.Emit(e)
+
+	s.l.Lock()
+	defer s.l.Unlock()
+	for sub := range s.subs {
+		select {
+		case sub <- e:
+		default:
+		}
+	}
+}
+
+// subscribe returns a channel receiving all events from now on, it must be
+// unsubscribed when done
+func (s *EventStream) subscribe() chan Event {
+	sub := make(chan Event, 100)
+	s.l.Lock()
+	s.subs[sub] = struct{}{}
+	s.l.Unlock()
+	return sub
+}
+
+func (s *EventStream) unsubscribe(sub chan Event) {
+	s.l.Lock()
+	delete(s.subs, sub)
+	s.l.Unlock()
+}
+
+// ServeHTTP streams the events as server-sent events, with the event type as
+// the SSE event and the JSON encoded Event as the data. The `name` query
+// parameter limits the stream to the named sync pair.
+func (s *EventStream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	name := r.URL.Query().Get("name")
+
+	sub := s.subscribe()
+	defer s.unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepalive := time.NewTicker(eventStreamKeepalive)
+	defer keepalive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+		case e := <-sub:
+			if name != "" && e.Name != name {
+				continue
+			}
+			b, err := json.Marshal(e)
+			if err != nil {
+				logger.Errorf("Error encoding event: %v", err)
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, b)
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,159 @@
+package targetsync
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/checker/decls"
+)
+
+// exprCache holds the compiled CEL programs by expression, as the configs
+// holding them are copied and shared between syncers
+var exprCache = struct {
+	sync.Mutex
+	programs map[string]cel.Program
+}{
+	programs: make(map[string]cel.Program),
+}
+
+// compileExpr returns the (cached) program of the CEL expression, which is
+// evaluated with the `target` variable
+func compileExpr(expr string) (cel.Program, error) {
+	exprCache.Lock()
+	defer exprCache.Unlock()
+	if prg, ok := exprCache.programs[expr]; ok {
+		return prg, nil
+	}
+
+	env, err := cel.NewEnv(cel.Declarations(
+		decls.NewIdent("target", decls.NewMapType(decls.String, decls.Dyn), nil),
+	))
+	if err != nil {
+		return nil, err
+	}
+	ast, iss := env.Compile(expr)
+	if iss != nil && iss.Err() != nil {
+		return nil, iss.Err()
+	}
+	prg, err := env.Program(ast)
+	if err != nil {
+		return nil, err
+	}
+	exprCache.programs[expr] = prg
+	return prg, nil
+}
+
+// ExprConfig selects and rewrites targets with CEL expressions, evaluated
+// against each target as `target.ip`, `target.port` and `target.meta`, e.g.
+// `target.meta["env"] == "prod" && target.port != 0`. Expressions which fail
+// to evaluate (e.g. a missing meta key, check with `"env" in target.meta`)
+// are logged and treated as not matching, or leave the target unchanged.
+type ExprConfig struct {
+	// Match keeps only the targets for which the expression is true
+	Match string `yaml:"match"`
+	// Port sets the port of the targets to the result of the expression
+	Port string `yaml:"port"`
+	// Weight sets the weight (MetaWeight) of the targets to the result of the
+	// expression
+	Weight string `yaml:"weight"`
+}
+
+// Validate checks the expressions compile
+func (c *ExprConfig) Validate() error {
+	for _, e := range []struct{ name, expr string }{
+		{"match", c.Match},
+		{"port", c.Port},
+		{"weight", c.Weight},
+	} {
+		if e.expr == "" {
+			continue
+		}
+		if _, err := compileExpr(e.expr); err != nil {
+			return fmt.Errorf("Invalid %s expression %q: %v", e.name, e.expr, err)
+		}
+	}
+	return nil
+}
+
+// eval evaluates the expression against the target
+func (c *ExprConfig) eval(expr string, target *Target) (interface{}, error) {
+	prg, err := compileExpr(expr)
+	if err != nil {
+		return nil, err
+	}
+	meta := target.Meta
+	if meta == nil {
+		meta = map[string]string{}
+	}
+	out, _, err := prg.Eval(map[string]interface{}{
+		"target": map[string]interface{}{
+			"ip":   target.IP,
+			"port": int64(target.Port),
+			"meta": meta,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out.Value(), nil
+}
+
+// evalInt evaluates the expression against the target, which must return an
+// int
+func (c *ExprConfig) evalInt(expr string, target *Target) (int, error) {
+	v, err := c.eval(expr, target)
+	if err != nil {
+		return 0, err
+	}
+	i, ok := v.(int64)
+	if !ok {
+		return 0, fmt.Errorf("Expression %q returned %T, not an int", expr, v)
+	}
+	return int(i), nil
+}
+
+// Apply returns the targets matching the `Match` expression, with the
+// rewrites applied. The targets passed in are never modified.
+func (c *ExprConfig) Apply(targets []*Target) []*Target {
+	if c.Match == "" && c.Port == "" && c.Weight == "" {
+		return targets
+	}
+
+	transformed := make([]*Target, 0, len(targets))
+	for _, target := range targets {
+		if c.Match != "" {
+			v, err := c.eval(c.Match, target)
+			if err != nil {
+				logger.Warnf("Error evaluating match expression for %v, skipping target: %v", target, err)
+				continue
+			}
+			if match, ok := v.(bool); !ok || !match {
+				continue
+			}
+		}
+
+		t := *target
+		if c.Port != "" {
+			if port, err := c.evalInt(c.Port, target); err != nil {
+				logger.Warnf("Error evaluating port expression for %v: %v", target, err)
+			} else {
+				t.Port = port
+			}
+		}
+		if c.Weight != "" {
+			if weight, err := c.evalInt(c.Weight, target); err != nil {
+				logger.Warnf("Error evaluating weight expression for %v: %v", target, err)
+			} else {
+				t.Meta = make(map[string]string, len(target.Meta)+1)
+				for k, v := range target.Meta {
+					t.Meta[k] = v
+				}
+				t.Meta[MetaWeight] = strconv.Itoa(weight)
+			}
+		}
+		transformed = append(transformed, &t)
+	}
+	return transformed
+}
@@ -0,0 +1,37 @@
+package targetsync
+
+import "testing"
+
+func TestExpr(t *testing.T) {
+	cfg := &ExprConfig{
+		Match:  `"env" in target.meta && target.meta["env"] == "prod" && target.port != 0`,
+		Port:   `target.port + 1000`,
+		Weight: `target.meta["tier"] == "large" ? 4 : 1`,
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	src := []*Target{
+		{IP: "10.0.0.1", Port: 80, Meta: map[string]string{"env": "prod", "tier": "large"}},
+		{IP: "10.0.0.2", Port: 80, Meta: map[string]string{"env": "dev", "tier": "large"}},
+		{IP: "10.0.0.3", Port: 0, Meta: map[string]string{"env": "prod", "tier": "large"}},
+		{IP: "10.0.0.4", Port: 80},
+	}
+
+	targets := cfg.Apply(src)
+	if len(targets) != 1 || targets[0].IP != "10.0.0.1" {
+		t.Fatalf("Unexpected targets: %v", targets)
+	}
+	if targets[0].Port != 1080 || targetWeight(targets[0], 0) != 4 {
+		t.Fatalf("Expressions not applied: %+v", targets[0])
+	}
+	// the source targets must not be modified
+	if src[0].Port != 80 || src[0].Meta[MetaWeight] != "" {
+		t.Fatalf("Source target was modified: %+v", src[0])
+	}
+
+	cfg = &ExprConfig{Match: `target.port ==`}
+	if err := cfg.Validate(); err == nil {
+		t.Fatalf("Expected error for invalid expression")
+	}
+}
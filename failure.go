@@ -0,0 +1,56 @@
+package targetsync
+
+import (
+	"fmt"
+	"time"
+)
+
+// defaultFailureThreshold is how long syncs must fail before EventSyncFailing
+// is emitted if `FailureThreshold` isn't set
+const defaultFailureThreshold = 5 * time.Minute
+
+// trackFailure records the result of a sync, emitting EventSyncFailing once
+// syncs have failed continuously for the `FailureThreshold` and
+// EventSyncRecovered on the next successful sync
+func (s *Syncer) trackFailure(err error) {
+	now := time.Now()
+	s.statusLock.Lock()
+	if err == nil {
+		reported := s.failureReported
+		since := s.failingSince
+		s.failingSince = time.Time{}
+		s.failureReported = false
+		s.statusLock.Unlock()
+		if reported {
+			s.emit(Event{
+				Type:    EventSyncRecovered,
+				Time:    now,
+				Message: fmt.Sprintf("Syncs recovered after failing for %v", now.Sub(since).Round(time.Second)),
+			})
+		}
+		return
+	}
+
+	if s.failingSince.IsZero() {
+		s.failingSince = now
+	}
+	threshold := s.Config.FailureThreshold
+	if threshold <= 0 {
+		threshold = defaultFailureThreshold
+	}
+	failing := now.Sub(s.failingSince)
+	report := !s.failureReported && failing >= threshold
+	if report {
+		s.failureReported = true
+	}
+	s.statusLock.Unlock()
+
+	if report {
+		s.emit(Event{
+			Type:       EventSyncFailing,
+			Time:       now,
+			Message:    fmt.Sprintf("Syncs have been failing for %v: %v", failing.Round(time.Second), err),
+			ErrorClass: ErrorClass(err),
+		})
+	}
+}
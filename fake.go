@@ -0,0 +1,195 @@
+package targetsync
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+// defaultFakeChurnInterval is how often the fake source changes its targets
+// if `ChurnInterval` isn't set
+const defaultFakeChurnInterval = 10 * time.Second
+
+// fakeFault sleeps for the latency and then returns an error with the given
+// probability, to simulate a slow and unreliable backend
+func fakeFault(ctx context.Context, latency time.Duration, errorRate float64) error {
+	if latency > 0 {
+		select {
+		case <-time.After(latency):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if errorRate > 0 && rand.Float64() < errorRate {
+		return fmt.Errorf("Injected fake error")
+	}
+	return nil
+}
+
+// NewFakeSource returns a new fake source, for testing targetsync without any
+// real infrastructure
+func NewFakeSource(cfg *FakeSourceConfig) *FakeSource {
+	s := &FakeSource{cfg: cfg}
+	for i := 0; i < cfg.Targets; i++ {
+		s.targets = append(s.targets, s.newTarget())
+	}
+	return s
+}
+
+// FakeSource is a TargetSource and Locker implementation which generates
+// targets, replacing `ChurnPercent` of them every `ChurnInterval`. It always
+// holds the lock.
+type FakeSource struct {
+	cfg *FakeSourceConfig
+
+	l       sync.Mutex
+	next    uint32
+	targets []*Target
+	lastErr error
+}
+
+// log returns the source's Logger
+func (s *FakeSource) log() Logger {
+	return loggerOr(s.cfg.Logger)
+}
+
+// newTarget returns a target with the next IP in 10.0.0.0/8
+func (s *FakeSource) newTarget() *Target {
+	s.next++
+	ip := make(net.IP, 4)
+	n := 10<<24 | s.next&0xffffff
+	ip[0], ip[1], ip[2], ip[3] = byte(n>>24), byte(n>>16), byte(n>>8), byte(n)
+	return &Target{IP: ip.String(), Port: s.cfg.Port}
+}
+
+// churn replaces `ChurnPercent` of the targets with new ones
+func (s *FakeSource) churn() []*Target {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	n := len(s.targets) * s.cfg.ChurnPercent / 100
+	targets := make([]*Target, len(s.targets))
+	copy(targets, s.targets)
+	for _, i := range rand.Perm(len(targets))[:n] {
+		targets[i] = s.newTarget()
+	}
+	s.targets = targets
+	return targets
+}
+
+// Healthy to implement the `HealthChecker` interface, the source is unhealthy
+// while updates are failing
+func (s *FakeSource) Healthy() error {
+	s.l.Lock()
+	defer s.l.Unlock()
+	return s.lastErr
+}
+
+// Subscribe sends the targets every `ChurnInterval`
+func (s *FakeSource) Subscribe(ctx context.Context) (chan []*Target, error) {
+	interval := s.cfg.ChurnInterval
+	if interval <= 0 {
+		interval = defaultFakeChurnInterval
+	}
+
+	ch := make(chan []*Target, 1)
+	s.l.Lock()
+	ch <- s.targets
+	s.l.Unlock()
+
+	go func() {
+		defer close(ch)
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+			}
+
+			err := fakeFault(ctx, s.cfg.Latency, s.cfg.ErrorRate)
+			s.l.Lock()
+			s.lastErr = err
+			s.l.Unlock()
+			if err != nil {
+				s.log().Warnf("Fake source update failed: %v", err)
+				continue
+			}
+
+			select {
+			case ch <- s.churn():
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// Lock always acquires the lock immediately
+func (s *FakeSource) Lock(ctx context.Context, opts *LockOptions) (<-chan bool, error) {
+	ch := make(chan bool, 1)
+	ch <- true
+	return ch, nil
+}
+
+// NewFakeDestination returns a new in-memory fake destination
+func NewFakeDestination(cfg *FakeDestinationConfig) *FakeDestination {
+	return &FakeDestination{
+		cfg:     cfg,
+		targets: make(map[string]*Target),
+	}
+}
+
+// FakeDestination is an in-memory TargetDestination implementation, with
+// injectable latency and errors
+type FakeDestination struct {
+	cfg *FakeDestinationConfig
+
+	l       sync.Mutex
+	targets map[string]*Target
+}
+
+// GetTargets returns the current set of targets at the destination
+func (d *FakeDestination) GetTargets(ctx context.Context) ([]*Target, error) {
+	if err := fakeFault(ctx, d.cfg.Latency, d.cfg.ErrorRate); err != nil {
+		return nil, err
+	}
+	d.l.Lock()
+	defer d.l.Unlock()
+	targets := make([]*Target, 0, len(d.targets))
+	for _, target := range d.targets {
+		targets = append(targets, target)
+	}
+	return targets, nil
+}
+
+// AddTargets adds the targets
+func (d *FakeDestination) AddTargets(ctx context.Context, targets []*Target) error {
+	if err := fakeFault(ctx, d.cfg.Latency, d.cfg.ErrorRate); err != nil {
+		return err
+	}
+	d.l.Lock()
+	defer d.l.Unlock()
+	for _, target := range targets {
+		d.targets[target.Key()] = target
+	}
+	return nil
+}
+
+// RemoveTargets removes the targets
+func (d *FakeDestination) RemoveTargets(ctx context.Context, targets []*Target) error {
+	if err := fakeFault(ctx, d.cfg.Latency, d.cfg.ErrorRate); err != nil {
+		return err
+	}
+	d.l.Lock()
+	defer d.l.Unlock()
+	for _, target := range targets {
+		delete(d.targets, target.Key())
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package targetsync
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestFakeSource(t *testing.T) {
+	s := NewFakeSource(&FakeSourceConfig{
+		Targets:       10,
+		Port:          80,
+		ChurnPercent:  50,
+		ChurnInterval: 10 * time.Millisecond,
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ch, err := s.Subscribe(ctx)
+	if err != nil {
+		t.Fatalf("Error subscribing: %v", err)
+	}
+	first := <-ch
+	next := <-ch
+	if len(first) != 10 || len(next) != 10 {
+		t.Fatalf("Expected 10 targets, got %d and %d", len(first), len(next))
+	}
+
+	kept := 0
+	firstKeys := targetSetKey(first)
+	for _, target := range next {
+		if _, ok := firstKeys[target.Key()]; ok {
+			kept++
+		}
+	}
+	if kept != 5 {
+		t.Fatalf("Expected 5 targets to be kept after churn, got %d", kept)
+	}
+}
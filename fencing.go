@@ -0,0 +1,158 @@
+package targetsync
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+type fencingTokenKey struct{}
+
+// withFencingToken returns a context carrying the fencing token for the
+// currently held lock
+func withFencingToken(ctx context.Context, token uint64) context.Context {
+	return context.WithValue(ctx, fencingTokenKey{}, token)
+}
+
+// FencingTokenFromContext returns the fencing token of the lock held while
+// making a destination mutation. Destinations which support conditional
+// writes should use this to reject writes from a deposed leader.
+func FencingTokenFromContext(ctx context.Context) (uint64, bool) {
+	token, ok := ctx.Value(fencingTokenKey{}).(uint64)
+	return token, ok
+}
+
+// verifyFencingToken checks that the fencing token the leader was started
+// with still matches the current one from the Locker
+func (s *Syncer) verifyFencingToken(ctx context.Context) error {
+	token, ok := FencingTokenFromContext(ctx)
+	if !ok {
+		return nil
+	}
+	fencingLocker, ok := s.Locker.(FencingLocker)
+	if !ok {
+		return nil
+	}
+	current, err := fencingLocker.FencingToken(ctx, &s.Config.LockOptions)
+	if err != nil {
+		return wrapError(ErrLockLost, fmt.Errorf("Unable to verify fencing token: %v", err))
+	}
+	if current != token {
+		return wrapError(ErrLockLost, fmt.Errorf("Fencing token mismatch, held=%d current=%d", token, current))
+	}
+	return nil
+}
+
+// addTargets adds the targets to the destination once the fencing token has
+// been verified, then calls the post-add hook
+func (s *Syncer) addTargets(ctx context.Context, targets []*Target) error {
+	return s.runJob(ctx, func() error {
+		if err := s.verifyFencingToken(ctx); err != nil {
+			return err
+		}
+		if err := s.spendBudget(len(targets)); err != nil {
+			return err
+		}
+		if err := s.callDestination(ctx, "add_targets", func(ctx context.Context) error {
+			return s.Dst.AddTargets(ctx, targets)
+		}); err != nil {
+			return err
+		}
+		s.recordAdded(targets)
+		s.emit(Event{
+			Type:    EventTargetsAdded,
+			Time:    time.Now(),
+			Message: fmt.Sprintf("Added %d targets to destination", len(targets)),
+			Targets: targets,
+		})
+		s.postAdd(ctx, targets)
+		return nil
+	})
+}
+
+// unreportedRemovals returns the targets whose skipped removal (with the
+// none `RemoveMode`) hasn't been reported yet, and records them as reported
+func (s *Syncer) unreportedRemovals(targets []*Target) []*Target {
+	s.statusLock.Lock()
+	defer s.statusLock.Unlock()
+	if s.skippedRemovals == nil {
+		s.skippedRemovals = make(map[string]struct{})
+	}
+	var unreported []*Target
+	for _, target := range targets {
+		if _, ok := s.skippedRemovals[target.IP]; !ok {
+			s.skippedRemovals[target.IP] = struct{}{}
+			unreported = append(unreported, target)
+		}
+	}
+	return unreported
+}
+
+// forgetSkippedRemovals forgets the reported removals of the targets back in
+// the source or gone from the destination, so they are reported again if
+// they are to be removed again
+func (s *Syncer) forgetSkippedRemovals(srcMap, dstMap map[string]*Target) {
+	s.statusLock.Lock()
+	defer s.statusLock.Unlock()
+	for ip := range s.skippedRemovals {
+		_, inSrc := srcMap[ip]
+		_, inDst := dstMap[ip]
+		if inSrc || !inDst {
+			delete(s.skippedRemovals, ip)
+		}
+	}
+}
+
+// removeTargets removes (or disables, depending on the `RemoveMode`) the
+// targets from the destination once the fencing token has been verified and
+// the pre-remove hook has acknowledged the removal. With the none
+// `RemoveMode` the removal is only reported.
+func (s *Syncer) removeTargets(ctx context.Context, targets []*Target) error {
+	if s.Config.RemoveMode == RemoveModeNone {
+		if targets = s.unreportedRemovals(targets); len(targets) == 0 {
+			return nil
+		}
+		s.emit(Event{
+			Type:    EventRemovalSkipped,
+			Time:    time.Now(),
+			Message: fmt.Sprintf("Not removing %d targets (%s) from destination, remove_mode is none: %s", len(targets), summarizeReasons(targets), summarizeTargets(targets)),
+			Targets: targets,
+		})
+		return nil
+	}
+	return s.runJob(ctx, func() error {
+		if err := s.verifyFencingToken(ctx); err != nil {
+			return err
+		}
+		if err := s.spendBudget(len(targets)); err != nil {
+			return err
+		}
+		if err := s.preRemove(ctx, targets); err != nil {
+			return err
+		}
+		msg := fmt.Sprintf("Removed %d targets (%s) from destination", len(targets), summarizeReasons(targets))
+		if s.Config.RemoveMode == RemoveModeDisable {
+			msg = fmt.Sprintf("Disabled %d targets (%s) in destination", len(targets), summarizeReasons(targets))
+			if err := s.callDestination(ctx, "disable_targets", func(ctx context.Context) error {
+				return s.Dst.(TargetAvailabilityDestination).DisableTargets(ctx, targets)
+			}); err != nil {
+				return err
+			}
+		} else if err := s.callDestination(ctx, "remove_targets", func(ctx context.Context) error {
+			return s.Dst.RemoveTargets(ctx, targets)
+		}); err != nil {
+			return err
+		}
+		// Disabled targets stay registered in the destination
+		if s.Config.RemoveMode != RemoveModeDisable {
+			s.recordRemoved(targets)
+		}
+		s.emit(Event{
+			Type:    EventTargetsRemoved,
+			Time:    time.Now(),
+			Message: msg,
+			Targets: targets,
+		})
+		return nil
+	})
+}
@@ -0,0 +1,209 @@
+package targetsync
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	compute "google.golang.org/api/compute/v1"
+	"google.golang.org/api/option"
+)
+
+// gceOperationPollInterval is how often zone operations are polled for
+// completion
+const gceOperationPollInterval = time.Second
+
+// NewGCEInstanceGroup returns a new GCE unmanaged instance group destination
+func NewGCEInstanceGroup(cfg *GCEConfig) (*GCEInstanceGroup, error) {
+	// Without options the application default credentials are used
+	var opts []option.ClientOption
+	if cfg.CredentialsFile != "" {
+		opts = append(opts, option.WithCredentialsFile(cfg.CredentialsFile))
+	}
+
+	svc, err := compute.NewService(context.Background(), opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &GCEInstanceGroup{
+		svc: svc,
+		cfg: cfg,
+	}, nil
+}
+
+// GCEInstanceGroup is a TargetDestination implementation for the membership
+// of a GCE unmanaged instance group. Targets are resolved to instances by
+// their (primary) network IP.
+type GCEInstanceGroup struct {
+	svc *compute.Service
+	cfg *GCEConfig
+
+	l sync.Mutex
+	// instances maps instance IPs to self links and back
+	ipToInstance map[string]string
+	instanceToIP map[string]string
+}
+
+// log returns the destination's Logger
+func (g *GCEInstanceGroup) log() Logger {
+	return loggerOr(g.cfg.Logger)
+}
+
+// refreshInstances reloads the IP to instance mapping of the zone
+func (g *GCEInstanceGroup) refreshInstances(ctx context.Context) error {
+	ipToInstance := make(map[string]string)
+	instanceToIP := make(map[string]string)
+	err := g.svc.Instances.List(g.cfg.Project, g.cfg.Zone).Pages(ctx, func(page *compute.InstanceList) error {
+		for _, instance := range page.Items {
+			for _, iface := range instance.NetworkInterfaces {
+				ipToInstance[iface.NetworkIP] = instance.SelfLink
+			}
+			if len(instance.NetworkInterfaces) > 0 {
+				instanceToIP[instance.SelfLink] = instance.NetworkInterfaces[0].NetworkIP
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	g.l.Lock()
+	defer g.l.Unlock()
+	g.ipToInstance = ipToInstance
+	g.instanceToIP = instanceToIP
+	return nil
+}
+
+// instanceForIP returns the self link of the instance with the IP
+func (g *GCEInstanceGroup) instanceForIP(ctx context.Context, ip string) (string, error) {
+	g.l.Lock()
+	instance, ok := g.ipToInstance[ip]
+	g.l.Unlock()
+	if ok {
+		return instance, nil
+	}
+
+	// The instance may be new, reload and try again
+	if err := g.refreshInstances(ctx); err != nil {
+		return "", err
+	}
+	g.l.Lock()
+	defer g.l.Unlock()
+	instance, ok = g.ipToInstance[ip]
+	if !ok {
+		return "", fmt.Errorf("No instance found with IP %s in %s", ip, g.cfg.Zone)
+	}
+	return instance, nil
+}
+
+// GetTargets returns the instances in the group as targets
+func (g *GCEInstanceGroup) GetTargets(ctx context.Context) ([]*Target, error) {
+	var instances []string
+	req := &compute.InstanceGroupsListInstancesRequest{InstanceState: "ALL"}
+	err := g.svc.InstanceGroups.ListInstances(g.cfg.Project, g.cfg.Zone, g.cfg.InstanceGroup, req).Pages(ctx, func(page *compute.InstanceGroupsListInstances) error {
+		for _, item := range page.Items {
+			instances = append(instances, item.Instance)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	g.l.Lock()
+	missing := false
+	for _, instance := range instances {
+		if _, ok := g.instanceToIP[instance]; !ok {
+			missing = true
+			break
+		}
+	}
+	g.l.Unlock()
+	if missing {
+		if err := g.refreshInstances(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	g.l.Lock()
+	defer g.l.Unlock()
+	targets := make([]*Target, 0, len(instances))
+	for _, instance := range instances {
+		ip, ok := g.instanceToIP[instance]
+		if !ok {
+			g.log().Warnf("Skipping instance group member with unknown IP: %s", instance)
+			continue
+		}
+		targets = append(targets, &Target{
+			IP:   ip,
+			Port: g.cfg.Port,
+		})
+	}
+	return targets, nil
+}
+
+// instanceReferences resolves the targets to instance references
+func (g *GCEInstanceGroup) instanceReferences(ctx context.Context, targets []*Target) ([]*compute.InstanceReference, error) {
+	refs := make([]*compute.InstanceReference, len(targets))
+	for i, target := range targets {
+		instance, err := g.instanceForIP(ctx, target.IP)
+		if err != nil {
+			return nil, err
+		}
+		refs[i] = &compute.InstanceReference{Instance: instance}
+	}
+	return refs, nil
+}
+
+// AddTargets adds the targets' instances to the group
+func (g *GCEInstanceGroup) AddTargets(ctx context.Context, targets []*Target) error {
+	refs, err := g.instanceReferences(ctx, targets)
+	if err != nil {
+		return err
+	}
+	op, err := g.svc.InstanceGroups.AddInstances(g.cfg.Project, g.cfg.Zone, g.cfg.InstanceGroup, &compute.InstanceGroupsAddInstancesRequest{
+		Instances: refs,
+	}).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("Error adding instances to group: %v", err)
+	}
+	return g.waitOperation(ctx, op)
+}
+
+// RemoveTargets removes the targets' instances from the group
+func (g *GCEInstanceGroup) RemoveTargets(ctx context.Context, targets []*Target) error {
+	refs, err := g.instanceReferences(ctx, targets)
+	if err != nil {
+		return err
+	}
+	op, err := g.svc.InstanceGroups.RemoveInstances(g.cfg.Project, g.cfg.Zone, g.cfg.InstanceGroup, &compute.InstanceGroupsRemoveInstancesRequest{
+		Instances: refs,
+	}).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("Error removing instances from group: %v", err)
+	}
+	return g.waitOperation(ctx, op)
+}
+
+// waitOperation waits for the zone operation to complete
+func (g *GCEInstanceGroup) waitOperation(ctx context.Context, op *compute.Operation) error {
+	for op.Status != "DONE" {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(gceOperationPollInterval):
+		}
+		var err error
+		op, err = g.svc.ZoneOperations.Get(g.cfg.Project, g.cfg.Zone, op.Name).Context(ctx).Do()
+		if err != nil {
+			return err
+		}
+	}
+	if op.Error != nil && len(op.Error.Errors) > 0 {
+		return fmt.Errorf("Operation %s failed: %s", op.Name, op.Error.Errors[0].Message)
+	}
+	return nil
+}
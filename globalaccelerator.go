@@ -0,0 +1,268 @@
+package targetsync
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/globalaccelerator"
+)
+
+// globalAcceleratorRegion is the only region serving the Global Accelerator
+// API, regardless of the region of the endpoint group
+const globalAcceleratorRegion = "us-west-2"
+
+// NewGlobalAcceleratorEndpointGroup returns a new AWS Global Accelerator
+// endpoint group destination
+func NewGlobalAcceleratorEndpointGroup(cfg *GlobalAcceleratorConfig) (*GlobalAcceleratorEndpointGroup, error) {
+	gaSess, err := awsSession(globalAcceleratorRegion, cfg.Credentials)
+	if err != nil {
+		return nil, err
+	}
+	ec2Sess, err := awsSession(cfg.Region, cfg.Credentials)
+	if err != nil {
+		return nil, err
+	}
+	return &GlobalAcceleratorEndpointGroup{
+		svc:    globalaccelerator.New(gaSess),
+		ec2Svc: ec2.New(ec2Sess),
+		cfg:    cfg,
+	}, nil
+}
+
+// GlobalAcceleratorEndpointGroup is a TargetDestination implementation for
+// the endpoints of an AWS Global Accelerator endpoint group. Targets are
+// resolved to EC2 instances by private IP, or to Elastic IPs by public IP,
+// depending on the `EndpointType`.
+type GlobalAcceleratorEndpointGroup struct {
+	svc    *globalaccelerator.GlobalAccelerator
+	ec2Svc *ec2.EC2
+	cfg    *GlobalAcceleratorConfig
+
+	// updateLock serializes updates, as the endpoint group is updated by
+	// replacing all of its endpoints
+	updateLock sync.Mutex
+
+	l sync.Mutex
+	// ipToEndpoint maps target IPs to endpoint IDs and back
+	ipToEndpoint map[string]string
+	endpointToIP map[string]string
+}
+
+// log returns the destination's Logger
+func (g *GlobalAcceleratorEndpointGroup) log() Logger {
+	return loggerOr(g.cfg.Logger)
+}
+
+// resolve looks up the endpoint IDs of the IPs (if `byIP`) or the IPs of the
+// endpoint IDs, and caches the mapping
+func (g *GlobalAcceleratorEndpointGroup) resolve(ctx context.Context, values []string, byIP bool) error {
+	mapping := make(map[string]string, len(values))
+	if g.cfg.EndpointType == GlobalAcceleratorEndpointTypeEIP {
+		input := &ec2.DescribeAddressesInput{}
+		if byIP {
+			input.PublicIps = aws.StringSlice(values)
+		} else {
+			input.AllocationIds = aws.StringSlice(values)
+		}
+		result, err := g.ec2Svc.DescribeAddressesWithContext(ctx, input)
+		if err != nil {
+			return wrapAWSError(err)
+		}
+		for _, addr := range result.Addresses {
+			mapping[aws.StringValue(addr.PublicIp)] = aws.StringValue(addr.AllocationId)
+		}
+	} else {
+		input := &ec2.DescribeInstancesInput{}
+		if byIP {
+			input.Filters = []*ec2.Filter{{
+				Name:   aws.String("private-ip-address"),
+				Values: aws.StringSlice(values),
+			}}
+		} else {
+			input.InstanceIds = aws.StringSlice(values)
+		}
+		err := g.ec2Svc.DescribeInstancesPagesWithContext(ctx, input, func(page *ec2.DescribeInstancesOutput, _ bool) bool {
+			for _, reservation := range page.Reservations {
+				for _, instance := range reservation.Instances {
+					mapping[aws.StringValue(instance.PrivateIpAddress)] = aws.StringValue(instance.InstanceId)
+				}
+			}
+			return true
+		})
+		if err != nil {
+			return wrapAWSError(err)
+		}
+	}
+
+	g.l.Lock()
+	defer g.l.Unlock()
+	if g.ipToEndpoint == nil {
+		g.ipToEndpoint = make(map[string]string)
+		g.endpointToIP = make(map[string]string)
+	}
+	for ip, endpointID := range mapping {
+		g.ipToEndpoint[ip] = endpointID
+		g.endpointToIP[endpointID] = ip
+	}
+	return nil
+}
+
+// endpointIDs returns the endpoint IDs of the targets by IP
+func (g *GlobalAcceleratorEndpointGroup) endpointIDs(ctx context.Context, targets []*Target) (map[string]string, error) {
+	var missing []string
+	g.l.Lock()
+	for _, target := range targets {
+		if _, ok := g.ipToEndpoint[target.IP]; !ok {
+			missing = append(missing, target.IP)
+		}
+	}
+	g.l.Unlock()
+	// The instances or addresses may be new, look them up
+	if len(missing) > 0 {
+		if err := g.resolve(ctx, missing, true); err != nil {
+			return nil, err
+		}
+	}
+
+	g.l.Lock()
+	defer g.l.Unlock()
+	ids := make(map[string]string, len(targets))
+	for _, target := range targets {
+		endpointID, ok := g.ipToEndpoint[target.IP]
+		if !ok {
+			return nil, fmt.Errorf("No %s endpoint found with IP %s", g.cfg.EndpointType, target.IP)
+		}
+		ids[target.IP] = endpointID
+	}
+	return ids, nil
+}
+
+// describe returns the endpoints of the endpoint group
+func (g *GlobalAcceleratorEndpointGroup) describe(ctx context.Context) ([]*globalaccelerator.EndpointDescription, error) {
+	result, err := g.svc.DescribeEndpointGroupWithContext(ctx, &globalaccelerator.DescribeEndpointGroupInput{
+		EndpointGroupArn: aws.String(g.cfg.EndpointGroupARN),
+	})
+	if err != nil {
+		return nil, wrapAWSError(err)
+	}
+	return result.EndpointGroup.EndpointDescriptions, nil
+}
+
+// GetTargets returns the endpoints of the group as targets, endpoints of other
+// types (e.g. load balancers) are skipped
+func (g *GlobalAcceleratorEndpointGroup) GetTargets(ctx context.Context) ([]*Target, error) {
+	endpoints, err := g.describe(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var missing []string
+	g.l.Lock()
+	for _, endpoint := range endpoints {
+		endpointID := aws.StringValue(endpoint.EndpointId)
+		if _, ok := g.endpointToIP[endpointID]; !ok && g.cfg.EndpointType.matches(endpointID) {
+			missing = append(missing, endpointID)
+		}
+	}
+	g.l.Unlock()
+	if len(missing) > 0 {
+		if err := g.resolve(ctx, missing, false); err != nil {
+			return nil, err
+		}
+	}
+
+	g.l.Lock()
+	defer g.l.Unlock()
+	targets := make([]*Target, 0, len(endpoints))
+	for _, endpoint := range endpoints {
+		endpointID := aws.StringValue(endpoint.EndpointId)
+		ip, ok := g.endpointToIP[endpointID]
+		if !ok {
+			g.log().Debugf("Skipping endpoint group endpoint with unknown IP: %s", endpointID)
+			continue
+		}
+		targets = append(targets, &Target{
+			IP:   ip,
+			Port: g.cfg.Port,
+			Health: &TargetHealth{
+				State:       strings.ToLower(aws.StringValue(endpoint.HealthState)),
+				Description: aws.StringValue(endpoint.HealthReason),
+			},
+		})
+	}
+	return targets, nil
+}
+
+// update replaces the endpoints of the group with those returned by `modify`
+// from the current endpoints (by endpoint ID)
+func (g *GlobalAcceleratorEndpointGroup) update(ctx context.Context, modify func(map[string]*globalaccelerator.EndpointConfiguration)) error {
+	g.updateLock.Lock()
+	defer g.updateLock.Unlock()
+
+	endpoints, err := g.describe(ctx)
+	if err != nil {
+		return err
+	}
+	configs := make(map[string]*globalaccelerator.EndpointConfiguration, len(endpoints))
+	for _, endpoint := range endpoints {
+		configs[aws.StringValue(endpoint.EndpointId)] = &globalaccelerator.EndpointConfiguration{
+			EndpointId:                  endpoint.EndpointId,
+			Weight:                      endpoint.Weight,
+			ClientIPPreservationEnabled: endpoint.ClientIPPreservationEnabled,
+		}
+	}
+	modify(configs)
+
+	input := &globalaccelerator.UpdateEndpointGroupInput{
+		EndpointGroupArn:       aws.String(g.cfg.EndpointGroupARN),
+		EndpointConfigurations: make([]*globalaccelerator.EndpointConfiguration, 0, len(configs)),
+	}
+	for _, config := range configs {
+		input.EndpointConfigurations = append(input.EndpointConfigurations, config)
+	}
+	if _, err := g.svc.UpdateEndpointGroupWithContext(ctx, input); err != nil {
+		return wrapAWSError(err)
+	}
+	return nil
+}
+
+// AddTargets adds the targets' endpoints to the group, setting the weight of
+// any which are already in the group
+func (g *GlobalAcceleratorEndpointGroup) AddTargets(ctx context.Context, targets []*Target) error {
+	ids, err := g.endpointIDs(ctx, targets)
+	if err != nil {
+		return err
+	}
+	return g.update(ctx, func(configs map[string]*globalaccelerator.EndpointConfiguration) {
+		for _, target := range targets {
+			endpointID := ids[target.IP]
+			config := &globalaccelerator.EndpointConfiguration{
+				EndpointId: aws.String(endpointID),
+			}
+			if weight := targetWeight(target, g.cfg.Weight); weight > 0 {
+				config.Weight = aws.Int64(int64(weight))
+			}
+			if g.cfg.ClientIPPreservation {
+				config.ClientIPPreservationEnabled = aws.Bool(true)
+			}
+			configs[endpointID] = config
+		}
+	})
+}
+
+// RemoveTargets removes the targets' endpoints from the group
+func (g *GlobalAcceleratorEndpointGroup) RemoveTargets(ctx context.Context, targets []*Target) error {
+	ids, err := g.endpointIDs(ctx, targets)
+	if err != nil {
+		return err
+	}
+	return g.update(ctx, func(configs map[string]*globalaccelerator.EndpointConfiguration) {
+		for _, endpointID := range ids {
+			delete(configs, endpointID)
+		}
+	})
+}
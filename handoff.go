@@ -0,0 +1,202 @@
+package targetsync
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// handoffTimeout is the timeout for a handoff, from connecting to the
+// previous process to receiving its state
+const handoffTimeout = time.Minute
+
+// HandoffRequest is sent by a new process to the previous one to take over
+// its sync pairs
+type HandoffRequest struct {
+	// PID of the new process, for logging
+	PID int `json:"pid"`
+}
+
+// HandoffResponse is sent by the previous process once it has stopped its
+// sync pairs (releasing their locks), with their state
+type HandoffResponse struct {
+	// States are the states of the sync pairs by name
+	States map[string]*PersistedState `json:"states"`
+	Error  string                     `json:"error,omitempty"`
+}
+
+// HandoffServer hands the sync pairs of this process over to a new process
+// (e.g. an upgraded binary) connecting to the Unix socket at Path. The
+// syncers are stopped, releasing their locks to the new process's syncers
+// waiting on them, and their state (e.g. pending removals) is sent to it, so
+// the new process takes over without waiting out lock TTLs or remove delays.
+type HandoffServer struct {
+	Path string
+	// Syncers returns the syncers to hand over
+	Syncers func() []*Syncer
+	// Stop stops the syncers, returning once they have stopped
+	Stop func()
+}
+
+// Serve listens on the socket until the context is done or a handoff has
+// been served, which returns nil
+func (h *HandoffServer) Serve(ctx context.Context) error {
+	// Remove the socket of a previous process, which has been handed off
+	if err := os.Remove(h.Path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("Error removing stale handoff socket: %v", err)
+	}
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: h.Path, Net: "unix"})
+	if err != nil {
+		return err
+	}
+	// The next process replaces the socket before we close it
+	l.SetUnlinkOnClose(false)
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				os.Remove(h.Path)
+				return nil
+			}
+			return err
+		}
+		if h.serve(conn, l) {
+			return nil
+		}
+	}
+}
+
+// serve serves a handoff connection, returning whether the syncers were
+// handed off
+func (h *HandoffServer) serve(conn net.Conn, l net.Listener) bool {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(handoffTimeout))
+	var req HandoffRequest
+	if err := json.NewDecoder(conn).Decode(&req); err != nil {
+		logger.Warnf("Error reading handoff request: %v", err)
+		return false
+	}
+	logger.Infof("Handing off sync pairs to process %d", req.PID)
+
+	// Only a single handoff is served
+	l.Close()
+	syncers := h.Syncers()
+	h.Stop()
+	resp := HandoffResponse{States: make(map[string]*PersistedState, len(syncers))}
+	for _, syncer := range syncers {
+		state := syncer.snapshotState()
+		state.Time = time.Now()
+		resp.States[syncer.name()] = state
+	}
+	if err := json.NewEncoder(conn).Encode(&resp); err != nil {
+		logger.Errorf("Error sending handoff response: %v", err)
+	}
+	return true
+}
+
+// NewHandoffStateStore returns a HandoffStateStore falling back to the store,
+// which may be nil
+func NewHandoffStateStore(store StateStore) *HandoffStateStore {
+	return &HandoffStateStore{
+		Store: store,
+		done:  make(chan struct{}),
+	}
+}
+
+// HandoffStateStore is a StateStore returning the state handed off by the
+// previous process. Loads wait for the handoff to complete, so a syncer
+// which takes over the lock as the previous process releases it starts with
+// its state. Once a pair's handed off state has been loaded, or if there is
+// none, Store is used.
+type HandoffStateStore struct {
+	Store StateStore
+
+	once   sync.Once
+	done   chan struct{}
+	l      sync.Mutex
+	states map[string]*PersistedState
+}
+
+// Handoff takes over the sync pairs of the previous process listening on the
+// Unix socket at path, if any. It should be called once this process's
+// syncers are ready, so they are waiting on the locks when they are released.
+// Loads are released once it returns, even if it fails.
+func (h *HandoffStateStore) Handoff(ctx context.Context, path string) error {
+	defer h.Complete()
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, handoffTimeout)
+	defer cancel()
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "unix", path)
+	if err != nil {
+		// A stale socket, the previous process has exited
+		logger.Warnf("No process to take over from on %s: %v", path, err)
+		return nil
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if err := json.NewEncoder(conn).Encode(&HandoffRequest{PID: os.Getpid()}); err != nil {
+		return fmt.Errorf("Error sending handoff request: %v", err)
+	}
+	var resp HandoffResponse
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return fmt.Errorf("Error reading handoff response: %v", err)
+	}
+	if resp.Error != "" {
+		return fmt.Errorf("Handoff failed: %s", resp.Error)
+	}
+	h.l.Lock()
+	h.states = resp.States
+	h.l.Unlock()
+	logger.Infof("Took over %d sync pairs from the previous process", len(resp.States))
+	return nil
+}
+
+// Complete releases loads waiting for a handoff, e.g. when there is no
+// previous process
+func (h *HandoffStateStore) Complete() {
+	h.once.Do(func() { close(h.done) })
+}
+
+// LoadState to implement the `StateStore` interface
+func (h *HandoffStateStore) LoadState(ctx context.Context, name string) (*PersistedState, error) {
+	select {
+	case <-h.done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	h.l.Lock()
+	state, ok := h.states[name]
+	delete(h.states, name)
+	h.l.Unlock()
+	if ok {
+		return state, nil
+	}
+	if h.Store == nil {
+		return nil, nil
+	}
+	return h.Store.LoadState(ctx, name)
+}
+
+// SaveState to implement the `StateStore` interface
+func (h *HandoffStateStore) SaveState(ctx context.Context, name string, state *PersistedState) error {
+	if h.Store == nil {
+		return nil
+	}
+	return h.Store.SaveState(ctx, name, state)
+}
@@ -0,0 +1,81 @@
+package targetsync
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHandoff(t *testing.T) {
+	dir, err := ioutil.TempDir("", "handoff")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "handoff.sock")
+
+	old := &Syncer{Name: "a", Config: &SyncConfig{}}
+	target := &Target{IP: "1", Port: 80}
+	old.setPendingRemoval(target, time.Now().Add(time.Minute))
+	stopped := false
+	server := &HandoffServer{
+		Path:    path,
+		Syncers: func() []*Syncer { return []*Syncer{old} },
+		Stop:    func() { stopped = true },
+	}
+	served := make(chan error, 1)
+	go func() { served <- server.Serve(context.Background()) }()
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if _, err := os.Stat(path); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Handoff socket wasn't created")
+		}
+	}
+
+	store := NewHandoffStateStore(&memoryStateStore{states: map[string]*PersistedState{}})
+	ctx := context.Background()
+
+	// Loads wait for the handoff
+	timeoutCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if _, err := store.LoadState(timeoutCtx, "a"); err != context.DeadlineExceeded {
+		t.Fatalf("Expected the load to wait for the handoff, got %v", err)
+	}
+
+	if err := store.Handoff(ctx, path); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := <-served; err != nil {
+		t.Fatalf("Unexpected error serving: %v", err)
+	}
+	if !stopped {
+		t.Fatalf("Expected the syncers to be stopped before the handoff")
+	}
+
+	state, err := store.LoadState(ctx, "a")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if state == nil || len(state.Removals) != 1 || state.Removals[0].Target.Key() != target.Key() {
+		t.Fatalf("Expected the pending removal to be handed off, got %+v", state)
+	}
+	// Later loads use the store
+	if state, err := store.LoadState(ctx, "a"); err != nil || state != nil {
+		t.Fatalf("Expected no state from the store, got %+v: %v", state, err)
+	}
+}
+
+func TestHandoffNoPreviousProcess(t *testing.T) {
+	store := NewHandoffStateStore(nil)
+	if err := store.Handoff(context.Background(), filepath.Join(os.TempDir(), "targetsync-missing.sock")); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if state, err := store.LoadState(context.Background(), "a"); err != nil || state != nil {
+		t.Fatalf("Expected no state, got %+v: %v", state, err)
+	}
+}
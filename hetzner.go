@@ -0,0 +1,299 @@
+package targetsync
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+
+	"github.com/hetznercloud/hcloud-go/hcloud"
+)
+
+// NewHetznerLoadBalancer returns a new Hetzner Cloud Load Balancer destination
+func NewHetznerLoadBalancer(cfg *HetznerConfig) (*HetznerLoadBalancer, error) {
+	token := cfg.Token
+	if token == "" {
+		token = os.Getenv("HCLOUD_TOKEN")
+	}
+	if token == "" {
+		return nil, fmt.Errorf("Hetzner API token must be set")
+	}
+
+	return &HetznerLoadBalancer{
+		client: hcloud.NewClient(hcloud.WithToken(token), hcloud.WithApplication("targetsync", "")),
+		cfg:    cfg,
+	}, nil
+}
+
+// HetznerLoadBalancer is a TargetDestination implementation for the targets
+// of a Hetzner Cloud Load Balancer. With server targets, targets are resolved
+// to cloud servers by their public (or private network) IP, with IP targets
+// the target's IP is added as is.
+type HetznerLoadBalancer struct {
+	client *hcloud.Client
+	cfg    *HetznerConfig
+
+	l sync.Mutex
+	// ipToServer and serverToIP map target IPs to cloud servers and back
+	ipToServer map[string]*hcloud.Server
+	serverToIP map[int]string
+}
+
+// log returns the destination's Logger
+func (h *HetznerLoadBalancer) log() Logger {
+	return loggerOr(h.cfg.Logger)
+}
+
+// serverIP returns the IP targets of the server are matched by
+func (h *HetznerLoadBalancer) serverIP(server *hcloud.Server) net.IP {
+	if h.cfg.UsePrivateIP {
+		if len(server.PrivateNet) == 0 {
+			return nil
+		}
+		return server.PrivateNet[0].IP
+	}
+	return server.PublicNet.IPv4.IP
+}
+
+// resolve lists the servers of the project, and caches the IP mapping
+func (h *HetznerLoadBalancer) resolve(ctx context.Context) error {
+	servers, err := h.client.Server.All(ctx)
+	if err != nil {
+		return err
+	}
+
+	h.l.Lock()
+	defer h.l.Unlock()
+	h.ipToServer = make(map[string]*hcloud.Server, len(servers))
+	h.serverToIP = make(map[int]string, len(servers))
+	for _, server := range servers {
+		ip := h.serverIP(server)
+		if ip == nil {
+			continue
+		}
+		h.ipToServer[ip.String()] = server
+		h.serverToIP[server.ID] = ip.String()
+	}
+	return nil
+}
+
+// servers returns the cloud servers of the targets by IP
+func (h *HetznerLoadBalancer) servers(ctx context.Context, targets []*Target) (map[string]*hcloud.Server, error) {
+	missing := false
+	h.l.Lock()
+	for _, target := range targets {
+		if _, ok := h.ipToServer[target.IP]; !ok {
+			missing = true
+			break
+		}
+	}
+	h.l.Unlock()
+	// The servers may be new, look them up
+	if missing {
+		if err := h.resolve(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	h.l.Lock()
+	defer h.l.Unlock()
+	servers := make(map[string]*hcloud.Server, len(targets))
+	for _, target := range targets {
+		server, ok := h.ipToServer[target.IP]
+		if !ok {
+			return nil, fmt.Errorf("No server found with IP %s", target.IP)
+		}
+		servers[target.IP] = server
+	}
+	return servers, nil
+}
+
+// loadBalancer returns the load balancer with its current targets
+func (h *HetznerLoadBalancer) loadBalancer(ctx context.Context) (*hcloud.LoadBalancer, error) {
+	lb, _, err := h.client.LoadBalancer.GetByID(ctx, h.cfg.LoadBalancerID)
+	if err != nil {
+		return nil, err
+	}
+	if lb == nil {
+		return nil, fmt.Errorf("Load balancer %d not found", h.cfg.LoadBalancerID)
+	}
+	return lb, nil
+}
+
+// health returns the health of the target from the health checks of the
+// `ListenPort` service, or of all services if it isn't set
+func (h *HetznerLoadBalancer) health(target hcloud.LoadBalancerTarget) *TargetHealth {
+	state := ""
+	for _, status := range target.HealthStatus {
+		if h.cfg.ListenPort != 0 && status.ListenPort != h.cfg.ListenPort {
+			continue
+		}
+		switch status.Status {
+		case hcloud.LoadBalancerTargetHealthStatusStatusUnhealthy:
+			return &TargetHealth{
+				State:       healthStateUnhealthy,
+				Description: fmt.Sprintf("Unhealthy on port %d", status.ListenPort),
+			}
+		case hcloud.LoadBalancerTargetHealthStatusStatusHealthy:
+			state = healthStateHealthy
+		default:
+			if state == "" {
+				state = string(status.Status)
+			}
+		}
+	}
+	if state == "" {
+		return nil
+	}
+	return &TargetHealth{State: state}
+}
+
+// GetTargets returns the load balancer's targets of the `TargetType`, label
+// selector targets are skipped
+func (h *HetznerLoadBalancer) GetTargets(ctx context.Context) ([]*Target, error) {
+	lb, err := h.loadBalancer(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if h.cfg.TargetType != HetznerTargetTypeIP {
+		missing := false
+		h.l.Lock()
+		for _, target := range lb.Targets {
+			if target.Type != hcloud.LoadBalancerTargetTypeServer || target.Server == nil {
+				continue
+			}
+			if _, ok := h.serverToIP[target.Server.Server.ID]; !ok {
+				missing = true
+				break
+			}
+		}
+		h.l.Unlock()
+		if missing {
+			if err := h.resolve(ctx); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	h.l.Lock()
+	defer h.l.Unlock()
+	targets := make([]*Target, 0, len(lb.Targets))
+	for _, target := range lb.Targets {
+		var ip string
+		switch {
+		case h.cfg.TargetType == HetznerTargetTypeIP && target.Type == hcloud.LoadBalancerTargetTypeIP && target.IP != nil:
+			ip = target.IP.IP
+		case h.cfg.TargetType != HetznerTargetTypeIP && target.Type == hcloud.LoadBalancerTargetTypeServer && target.Server != nil:
+			var ok bool
+			if ip, ok = h.serverToIP[target.Server.Server.ID]; !ok {
+				h.log().Debugf("Skipping load balancer target with unknown server: %d", target.Server.Server.ID)
+				continue
+			}
+		default:
+			continue
+		}
+		targets = append(targets, &Target{
+			IP:     ip,
+			Port:   h.cfg.Port,
+			Health: h.health(target),
+		})
+	}
+	return targets, nil
+}
+
+// wait waits for the action to complete
+func (h *HetznerLoadBalancer) wait(ctx context.Context, action *hcloud.Action) error {
+	_, errCh := h.client.Action.WatchProgress(ctx, action)
+	return <-errCh
+}
+
+// hetznerTargetExists returns whether the error is the target already being a
+// target of the load balancer
+func hetznerTargetExists(err error) bool {
+	return hcloud.IsError(err, hcloud.ErrorCodeTargetAlreadyDefined)
+}
+
+// AddTargets adds the targets (or their servers) to the load balancer
+func (h *HetznerLoadBalancer) AddTargets(ctx context.Context, targets []*Target) error {
+	lb := &hcloud.LoadBalancer{ID: h.cfg.LoadBalancerID}
+
+	if h.cfg.TargetType == HetznerTargetTypeIP {
+		for _, target := range targets {
+			ip := net.ParseIP(target.IP)
+			if ip == nil {
+				return fmt.Errorf("Target %s is not an IP", target.IP)
+			}
+			action, _, err := h.client.LoadBalancer.AddIPTarget(ctx, lb, hcloud.LoadBalancerAddIPTargetOpts{IP: ip})
+			if hetznerTargetExists(err) {
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("Error adding target %s: %v", target.IP, err)
+			}
+			if err := h.wait(ctx, action); err != nil {
+				return fmt.Errorf("Error adding target %s: %v", target.IP, err)
+			}
+		}
+		return nil
+	}
+
+	servers, err := h.servers(ctx, targets)
+	if err != nil {
+		return err
+	}
+	for _, target := range targets {
+		action, _, err := h.client.LoadBalancer.AddServerTarget(ctx, lb, hcloud.LoadBalancerAddServerTargetOpts{
+			Server:       servers[target.IP],
+			UsePrivateIP: hcloud.Bool(h.cfg.UsePrivateIP),
+		})
+		if hetznerTargetExists(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("Error adding server target %s: %v", target.IP, err)
+		}
+		if err := h.wait(ctx, action); err != nil {
+			return fmt.Errorf("Error adding server target %s: %v", target.IP, err)
+		}
+	}
+	return nil
+}
+
+// RemoveTargets removes the targets (or their servers) from the load balancer
+func (h *HetznerLoadBalancer) RemoveTargets(ctx context.Context, targets []*Target) error {
+	lb := &hcloud.LoadBalancer{ID: h.cfg.LoadBalancerID}
+
+	if h.cfg.TargetType == HetznerTargetTypeIP {
+		for _, target := range targets {
+			ip := net.ParseIP(target.IP)
+			if ip == nil {
+				return fmt.Errorf("Target %s is not an IP", target.IP)
+			}
+			action, _, err := h.client.LoadBalancer.RemoveIPTarget(ctx, lb, ip)
+			if err != nil {
+				return fmt.Errorf("Error removing target %s: %v", target.IP, err)
+			}
+			if err := h.wait(ctx, action); err != nil {
+				return fmt.Errorf("Error removing target %s: %v", target.IP, err)
+			}
+		}
+		return nil
+	}
+
+	servers, err := h.servers(ctx, targets)
+	if err != nil {
+		return err
+	}
+	for _, target := range targets {
+		action, _, err := h.client.LoadBalancer.RemoveServerTarget(ctx, lb, servers[target.IP])
+		if err != nil {
+			return fmt.Errorf("Error removing server target %s: %v", target.IP, err)
+		}
+		if err := h.wait(ctx, action); err != nil {
+			return fmt.Errorf("Error removing server target %s: %v", target.IP, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,369 @@
+package targetsync
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	// defaultHistoryRetention is how long membership changes are kept if
+	// `Retention` isn't set
+	defaultHistoryRetention = 30 * 24 * time.Hour
+	// historyPruneInterval is how often changes older than the retention
+	// are deleted
+	historyPruneInterval = time.Hour
+	// maxPendingHistory is how many changes can wait to be written before
+	// new ones are dropped
+	maxPendingHistory = 10000
+	// defaultHistoryLimit is the most changes returned by a query if no
+	// `limit` is given
+	defaultHistoryLimit = 1000
+)
+
+// History changes
+const (
+	HistoryAdded   = "added"
+	HistoryRemoved = "removed"
+)
+
+// HistoryConfig configures the local history of membership changes
+type HistoryConfig struct {
+	// Path of the database file, the history is disabled if unset
+	Path string `yaml:"path"`
+	// Retention is how long changes are kept, defaults to 30 days
+	Retention time.Duration `yaml:"retention"`
+}
+
+// Validate checks the HistoryConfig for errors
+func (c *HistoryConfig) Validate() error {
+	if c.Retention < 0 {
+		return fmt.Errorf("History retention must be >=0")
+	}
+	return nil
+}
+
+// HistoryRecord is a single change to the membership of a pair's destination
+type HistoryRecord struct {
+	Time time.Time `json:"time"`
+	// Name of the sync pair
+	Name string `json:"name"`
+	// Change is added or removed
+	Change string `json:"change"`
+	// Target is the key of the target, e.g. `10.0.0.1:80`
+	Target string `json:"target"`
+	// Hostname of the target, if known
+	Hostname string `json:"hostname,omitempty"`
+	// Reason the target was removed
+	Reason RemovalReason `json:"reason,omitempty"`
+}
+
+// HistoryQuery selects the records returned by `History.Query`
+type HistoryQuery struct {
+	// Name of the sync pair, all pairs if empty
+	Name string
+	// Since and Until bound the time of the records, either may be zero
+	Since time.Time
+	Until time.Time
+	// Target limits the records to the target's key or IP, if set
+	Target string
+	// Limit is the most records returned, the newest are kept
+	Limit int
+}
+
+// NewHistory opens the history database at the configured path, forwarding
+// the events to `next` (or logging them if nil)
+func NewHistory(cfg *HistoryConfig, next EventSink) (*History, error) {
+	if next == nil {
+		next = LogEventSink{}
+	}
+	db, err := bolt.Open(cfg.Path, 0600, &bolt.Options{Timeout: 10 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("Error opening history %s: %v", cfg.Path, err)
+	}
+	return &History{
+		cfg:     cfg,
+		next:    next,
+		db:      db,
+		pending: make(chan []*HistoryRecord, maxPendingHistory),
+	}, nil
+}
+
+// History is an EventSink which keeps a compact local history of the
+// targets added to and removed from each pair's destination, so operators can
+// see when a target dropped out without external logging. The records are
+// stored in a bbolt database, in a bucket per pair keyed by time, and are
+// written in the background by `Run`.
+type History struct {
+	cfg  *HistoryConfig
+	next EventSink
+	db   *bolt.DB
+
+	pending chan []*HistoryRecord
+}
+
+// Emit queues the targets of added and removed events to be recorded, and
+// sends the event to the next EventSink. Changes are dropped rather than
+// blocking the Syncer if the history isn't keeping up.
+func (h *History) Emit(e Event) {
+	h.next.Emit(e)
+
+	var change string
+	switch e.Type {
+	case EventTargetsAdded:
+		change = HistoryAdded
+	case EventTargetsRemoved:
+		change = HistoryRemoved
+	default:
+		return
+	}
+	records := make([]*HistoryRecord, len(e.Targets))
+	for i, target := range e.Targets {
+		records[i] = &HistoryRecord{
+			Time:     e.Time,
+			Name:     e.Name,
+			Change:   change,
+			Target:   target.Key(),
+			Hostname: target.Meta[MetaHostname],
+		}
+		if change == HistoryRemoved {
+			records[i].Reason = removalReason(target)
+		}
+	}
+	select {
+	case h.pending <- records:
+	default:
+		logger.Warnf("History isn't keeping up, dropping %d %s changes for %s", len(records), change, e.Name)
+	}
+}
+
+// Run writes the queued changes and prunes those older than the retention
+// until the context is done, then closes the database
+func (h *History) Run(ctx context.Context) {
+	defer h.db.Close()
+	h.prune()
+	t := time.NewTicker(historyPruneInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case records := <-h.pending:
+			// Write everything queued in a single transaction
+			for more := true; more; {
+				select {
+				case next := <-h.pending:
+					records = append(records, next...)
+				default:
+					more = false
+				}
+			}
+			if err := h.write(records); err != nil {
+				logger.Errorf("Error writing %d changes to history: %v", len(records), err)
+			}
+		case <-t.C:
+			h.prune()
+		}
+	}
+}
+
+// historyKey returns the key of a record, its time followed by a sequence
+// number so records at the same time are kept in order
+func historyKey(t time.Time, seq uint64) []byte {
+	key := make([]byte, 16)
+	binary.BigEndian.PutUint64(key, uint64(t.UnixNano()))
+	binary.BigEndian.PutUint64(key[8:], seq)
+	return key
+}
+
+// historyValue is the stored form of a record, without the time and name
+// which are in its key and bucket
+type historyValue struct {
+	Change   string        `json:"c"`
+	Target   string        `json:"t"`
+	Hostname string        `json:"h,omitempty"`
+	Reason   RemovalReason `json:"r,omitempty"`
+}
+
+func (h *History) write(records []*HistoryRecord) error {
+	return h.db.Update(func(tx *bolt.Tx) error {
+		for _, record := range records {
+			bucket, err := tx.CreateBucketIfNotExists([]byte(record.Name))
+			if err != nil {
+				return err
+			}
+			seq, err := bucket.NextSequence()
+			if err != nil {
+				return err
+			}
+			value, err := json.Marshal(&historyValue{
+				Change:   record.Change,
+				Target:   record.Target,
+				Hostname: record.Hostname,
+				Reason:   record.Reason,
+			})
+			if err != nil {
+				return err
+			}
+			if err := bucket.Put(historyKey(record.Time, seq), value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// prune deletes the changes older than the retention
+func (h *History) prune() {
+	retention := h.cfg.Retention
+	if retention <= 0 {
+		retention = defaultHistoryRetention
+	}
+	cutoff := historyKey(time.Now().Add(-retention), 0)
+	deleted := 0
+	err := h.db.Update(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(_ []byte, bucket *bolt.Bucket) error {
+			// Collected first, as deleting while iterating skips keys
+			var keys [][]byte
+			c := bucket.Cursor()
+			for k, _ := c.First(); k != nil && bytes.Compare(k, cutoff) < 0; k, _ = c.Next() {
+				keys = append(keys, k)
+			}
+			for _, k := range keys {
+				if err := bucket.Delete(k); err != nil {
+					return err
+				}
+			}
+			deleted += len(keys)
+			return nil
+		})
+	})
+	if err != nil {
+		logger.Errorf("Error pruning history: %v", err)
+		return
+	}
+	if deleted > 0 {
+		logger.Debugf("Pruned %d changes older than %v from history", deleted, retention)
+	}
+}
+
+// Query returns the recorded changes matching the query, oldest first
+func (h *History) Query(q HistoryQuery) ([]*HistoryRecord, error) {
+	limit := q.Limit
+	if limit <= 0 {
+		limit = defaultHistoryLimit
+	}
+	var records []*HistoryRecord
+	err := h.db.View(func(tx *bolt.Tx) error {
+		query := func(name []byte, bucket *bolt.Bucket) error {
+			c := bucket.Cursor()
+			k, v := c.First()
+			if !q.Since.IsZero() {
+				k, v = c.Seek(historyKey(q.Since, 0))
+			}
+			for ; k != nil; k, v = c.Next() {
+				t := time.Unix(0, int64(binary.BigEndian.Uint64(k)))
+				if !q.Until.IsZero() && t.After(q.Until) {
+					break
+				}
+				var value historyValue
+				if err := json.Unmarshal(v, &value); err != nil {
+					return err
+				}
+				if q.Target != "" && value.Target != q.Target && !strings.HasPrefix(value.Target, q.Target+":") {
+					continue
+				}
+				records = append(records, &HistoryRecord{
+					Time:     t,
+					Name:     string(name),
+					Change:   value.Change,
+					Target:   value.Target,
+					Hostname: value.Hostname,
+					Reason:   value.Reason,
+				})
+			}
+			return nil
+		}
+		if q.Name != "" {
+			bucket := tx.Bucket([]byte(q.Name))
+			if bucket == nil {
+				return nil
+			}
+			return query([]byte(q.Name), bucket)
+		}
+		return tx.ForEach(query)
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Records of different pairs are merged by time
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Time.Before(records[j].Time)
+	})
+	if len(records) > limit {
+		records = records[len(records)-limit:]
+	}
+	return records, nil
+}
+
+// ServeHTTP returns the changes as JSON, selected by the `pair`, `since`,
+// `until`, `target` and `limit` query parameters. `since` and `until` are
+// RFC3339 times or durations before now, e.g. `168h`.
+func (h *History) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	params := r.URL.Query()
+	q := HistoryQuery{
+		Name:   params.Get("pair"),
+		Target: params.Get("target"),
+	}
+	var err error
+	if q.Since, err = parseHistoryTime(params.Get("since")); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid since: %v", err), http.StatusBadRequest)
+		return
+	}
+	if q.Until, err = parseHistoryTime(params.Get("until")); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid until: %v", err), http.StatusBadRequest)
+		return
+	}
+	if limit := params.Get("limit"); limit != "" {
+		if q.Limit, err = strconv.Atoi(limit); err != nil || q.Limit <= 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+
+	records, err := h.Query(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if records == nil {
+		records = []*HistoryRecord{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(records)
+}
+
+// parseHistoryTime parses an RFC3339 time or a duration before now, an empty
+// string is the zero time
+func parseHistoryTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
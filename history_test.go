@@ -0,0 +1,75 @@
+package targetsync
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHistory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "history")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	events := make(chanSink, 10)
+	history, err := NewHistory(&HistoryConfig{Path: filepath.Join(dir, "history.db")}, events)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go history.Run(ctx)
+
+	start := time.Now()
+	a := &Target{IP: "10.0.0.1", Port: 80}
+	b := &Target{IP: "10.0.0.2", Port: 80}
+	history.Emit(Event{Type: EventTargetsAdded, Name: "a", Time: start, Targets: []*Target{a, b}})
+	history.Emit(Event{Type: EventLockAcquired, Name: "a", Time: start})
+	removed := &Target{IP: a.IP, Port: a.Port, Meta: map[string]string{MetaRemovalReason: string(RemovalExpired)}}
+	history.Emit(Event{Type: EventTargetsRemoved, Name: "a", Time: start.Add(time.Minute), Targets: []*Target{removed}})
+	history.Emit(Event{Type: EventTargetsAdded, Name: "b", Time: start.Add(2 * time.Minute), Targets: []*Target{a}})
+	if len(events) != 4 {
+		t.Fatalf("Expected the events to be forwarded, got %d", len(events))
+	}
+
+	var records []*HistoryRecord
+	for deadline := time.Now().Add(5 * time.Second); len(records) != 4; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Changes weren't recorded, got %v", records)
+		}
+		if records, err = history.Query(HistoryQuery{}); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	// Changes across pairs are ordered by time
+	if records[2].Change != HistoryRemoved || records[2].Reason != RemovalExpired || records[3].Name != "b" {
+		t.Fatalf("Unexpected records %+v %+v", records[2], records[3])
+	}
+
+	// When did 10.0.0.1 drop out of a?
+	srv := httptest.NewServer(history)
+	defer srv.Close()
+	resp, err := srv.Client().Get(srv.URL + "?pair=a&target=10.0.0.1&since=" + start.Add(time.Second).Format(time.RFC3339Nano))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+	records = nil
+	if err := json.NewDecoder(resp.Body).Decode(&records); err != nil {
+		t.Fatalf("Error decoding response: %v", err)
+	}
+	if len(records) != 1 || records[0].Target != a.Key() || records[0].Change != HistoryRemoved || !records[0].Time.Equal(start.Add(time.Minute)) {
+		t.Fatalf("Expected the removal of %s, got %+v", a.Key(), records)
+	}
+
+	if records, _ := history.Query(HistoryQuery{Limit: 1}); len(records) != 1 || records[0].Name != "b" {
+		t.Fatalf("Expected only the newest record, got %+v", records)
+	}
+}
@@ -0,0 +1,102 @@
+package targetsync
+
+import (
+	"context"
+	"time"
+)
+
+// SyncerState is the state of a Syncer's Run loop
+type SyncerState string
+
+const (
+	// SyncerStateStarting is waiting for the source (and to add LocalAddr)
+	SyncerStateStarting SyncerState = "starting"
+	// SyncerStateFollower is running without holding the lock
+	SyncerStateFollower SyncerState = "follower"
+	// SyncerStateLeader holds the lock and is syncing the destination
+	SyncerStateLeader SyncerState = "leader"
+	// SyncerStateStopped has returned from Run
+	SyncerStateStopped SyncerState = "stopped"
+)
+
+// SyncResult describes a single sync of the destination
+type SyncResult struct {
+	// Full is whether the sync diffed the whole source against the
+	// destination, rather than applying a delta
+	Full    bool
+	Added   []*Target
+	Removed []*Target
+	// Unchanged is the number of targets left as they are
+	Unchanged int
+	Duration  time.Duration
+	// Err is set if the sync failed
+	Err error
+}
+
+// SyncerHooks are callbacks for embedders to add behavior to a Syncer without
+// copying its loops, any of them may be nil. They are called synchronously
+// from the loops, so shouldn't block for long.
+type SyncerHooks struct {
+	// OnLeadershipChange is called when the lock is acquired, before the
+	// leader actions start, and when it is lost, after they are stopped. An
+	// error when acquired (e.g. failing to warm a cache) keeps the leader
+	// actions from starting until the lock is acquired again
+	OnLeadershipChange func(ctx context.Context, leader bool) error
+	// BeforeSync is called with the source targets before each sync, an
+	// error skips the sync until the next source update
+	BeforeSync func(ctx context.Context, targets []*Target) error
+	// AfterSync is called with the result of each sync
+	AfterSync func(ctx context.Context, result *SyncResult)
+}
+
+// State returns the current state of the Syncer
+func (s *Syncer) State() SyncerState {
+	s.statusLock.Lock()
+	defer s.statusLock.Unlock()
+	if s.status.State == "" {
+		return SyncerStateStarting
+	}
+	return s.status.State
+}
+
+// setState records the state of the Run loop. The registrations are only
+// known while leader, so are forgotten otherwise.
+func (s *Syncer) setState(state SyncerState) {
+	s.statusLock.Lock()
+	defer s.statusLock.Unlock()
+	s.status.State = state
+	s.status.Leader = state == SyncerStateLeader
+	if !s.status.Leader {
+		s.registrations = nil
+	}
+}
+
+// leadershipChanged calls the OnLeadershipChange hook
+func (s *Syncer) leadershipChanged(ctx context.Context, leader bool) error {
+	if s.Hooks == nil || s.Hooks.OnLeadershipChange == nil {
+		return nil
+	}
+	return s.Hooks.OnLeadershipChange(ctx, leader)
+}
+
+// beforeSync calls the BeforeSync hook, returning whether to go ahead with the
+// sync
+func (s *Syncer) beforeSync(ctx context.Context, targets []*Target) bool {
+	if s.Hooks == nil || s.Hooks.BeforeSync == nil {
+		return true
+	}
+	if err := s.Hooks.BeforeSync(ctx, targets); err != nil {
+		s.log().Warnf("Skipping sync, rejected by BeforeSync hook: %v", err)
+		return false
+	}
+	return true
+}
+
+// afterSync tracks sync failures and calls the AfterSync hook
+func (s *Syncer) afterSync(ctx context.Context, result *SyncResult) {
+	s.trackFailure(result.Err)
+	if s.Hooks == nil || s.Hooks.AfterSync == nil {
+		return
+	}
+	s.Hooks.AfterSync(ctx, result)
+}
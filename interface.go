@@ -6,10 +6,45 @@ import (
 	"time"
 )
 
+// MetaRemoveDelay is the target metadata key overriding the `RemoveDelay`
+// for the target (e.g. consul service meta `targetsync/remove-delay=0s`)
+const MetaRemoveDelay = "targetsync/remove-delay"
+
+// MetaTTL is the target metadata key for the target's TTL, the target is
+// expired if the source doesn't send it again within the TTL
+const MetaTTL = "targetsync/ttl"
+
+// MetaWeight is the target metadata key for the target's weight, overriding
+// the weight configured for destinations which support weights
+const MetaWeight = "targetsync/weight"
+
+// MetaHostname is the target metadata key for the target's hostname (e.g.
+// consul node name), used to identify targets in logs
+const MetaHostname = "targetsync/hostname"
+
+// MetaRemovalReason is the target metadata key for why the target is being
+// removed (see `RemovalReason`), set on the targets passed to `RemoveTargets`
+// and in the removal events
+const MetaRemovalReason = "targetsync/removal-reason"
+
 // Target represents a single IP+Port pair
 type Target struct {
-	IP   string
-	Port int
+	IP   string `json:"ip"`
+	Port int    `json:"port"`
+	// Meta is arbitrary metadata about the target from the source
+	Meta map[string]string `json:"meta,omitempty"`
+	// Health is the health of the target as reported by the destination,
+	// nil if the destination doesn't report health
+	Health *TargetHealth `json:"health,omitempty"`
+}
+
+// TargetHealth is the health of a target within the destination
+type TargetHealth struct {
+	// State of the target (e.g. healthy, unhealthy, draining)
+	State string `json:"state"`
+	// Reason code for the state, if any
+	Reason      string `json:"reason,omitempty"`
+	Description string `json:"description,omitempty"`
 }
 
 // Key returns a unique key identifying this specific target
@@ -17,12 +52,67 @@ func (t *Target) Key() string {
 	return fmt.Sprintf("%s:%d", t.IP, t.Port)
 }
 
+// displayName returns the target's hostname (if known) and key for logging
+func (t *Target) displayName() string {
+	if hostname := t.Meta[MetaHostname]; hostname != "" {
+		return hostname + "(" + t.Key() + ")"
+	}
+	return t.Key()
+}
+
+// chunkTargets splits the targets into chunks of at most `size` targets, the
+// chunks share the backing array of `targets`
+func chunkTargets(targets []*Target, size int) [][]*Target {
+	chunks := make([][]*Target, 0, (len(targets)+size-1)/size)
+	for size < len(targets) {
+		targets, chunks = targets[size:], append(chunks, targets[:size:size])
+	}
+	if len(targets) > 0 {
+		chunks = append(chunks, targets)
+	}
+	return chunks
+}
+
 // TargetSource is an interface for getting targets for a given config
 // TODO: plugin etc.
 type TargetSource interface {
 	Subscribe(context.Context) (chan []*Target, error)
 }
 
+// TargetDelta is an incremental change to the set of targets
+type TargetDelta struct {
+	Added   []*Target
+	Removed []*Target
+}
+
+// TargetDeltaSource is a TargetSource which can also emit incremental changes
+// instead of full snapshots. The first delta on the channel must contain the
+// complete initial set of targets as `Added`.
+type TargetDeltaSource interface {
+	TargetSource
+	SubscribeDeltas(context.Context) (chan *TargetDelta, error)
+}
+
+// HealthChecker is implemented by sources which can report their health
+type HealthChecker interface {
+	// Healthy returns an error describing why the source is unhealthy
+	Healthy() error
+}
+
+// SelfTester is implemented by sources and destinations with checks, beyond
+// listing their targets, that they are usable (e.g. that the resource
+// exists). The checks must not change anything.
+type SelfTester interface {
+	// SelfTest returns an error describing why the backend isn't usable
+	SelfTest(context.Context) error
+}
+
+// ReconcileTrigger is an interface for external signals to reconcile the
+// destination immediately (e.g. after a deploy)
+type ReconcileTrigger interface {
+	Subscribe(context.Context) (<-chan struct{}, error)
+}
+
 // TargetDestination is a place to apply targets to (e.g. TargetGroup)
 type TargetDestination interface {
 	// GetTargets returns the current set of targets at the destination
@@ -33,10 +123,48 @@ type TargetDestination interface {
 	RemoveTargets(context.Context, []*Target) error
 }
 
+// TargetAvailabilityDestination is a TargetDestination which can disable
+// targets, leaving them registered but not sent any traffic. Disabled targets
+// must not be returned by GetTargets, and AddTargets must re-enable them.
+type TargetAvailabilityDestination interface {
+	TargetDestination
+	// DisableTargets disables the targets described
+	DisableTargets(context.Context, []*Target) error
+}
+
+// PartialDestination is a TargetDestination made up of several target sets
+// which can drift apart (e.g. the target groups of mirrored regions).
+// GetTargets only returns the targets registered in all of them, and
+// PartialTargets the targets registered in only some of them as of the last
+// GetTargets. Partial targets are added (to the rest of the sets) if they are
+// in the source, and removed otherwise.
+type PartialDestination interface {
+	TargetDestination
+	PartialTargets() []*Target
+}
+
 // LockOptions holds the options for locking/leader-election
 type LockOptions struct {
-	Key string        `yaml:"key"`
-	TTL time.Duration `yaml:"ttl"`
+	// Key of the lock, if unset it is generated from the destination (e.g.
+	// the target group ARN) with the `KeyTemplate`, so every config pointed
+	// at the same destination uses the same lock
+	Key string `yaml:"key"`
+	// KeyTemplate is the text/template the key is generated with, given the
+	// `LockKeyData`. Defaults to `targetsync/{{.Type}}/{{.Hash}}`
+	KeyTemplate string        `yaml:"key_template"`
+	TTL         time.Duration `yaml:"ttl"`
+	// Identity of this process, stored as the lock holder
+	Identity string `yaml:"identity"`
+	// Name of the sync pair using the lock, set by the Syncer
+	Name string `yaml:"-"`
+}
+
+// name returns the name used to label the lock's metrics and events
+func (o *LockOptions) name() string {
+	if o.Name != "" {
+		return o.Name
+	}
+	return o.Key
 }
 
 // Locker is an interface for locking/leader-election
@@ -46,6 +174,79 @@ type Locker interface {
 	Lock(context.Context, *LockOptions) (<-chan bool, error)
 }
 
+// FencingLocker is a Locker which can provide a fencing token for the lock,
+// the token must change every time the lock changes hands
+type FencingLocker interface {
+	Locker
+	FencingToken(context.Context, *LockOptions) (uint64, error)
+}
+
+// LockInfo describes the current holder of a lock
+type LockInfo struct {
+	// Held is whether the lock is currently held
+	Held bool `json:"held"`
+	// Holder is the `Identity` of the holder
+	Holder string `json:"holder,omitempty"`
+	// Session is the locker specific session holding the lock (e.g. consul
+	// session ID)
+	Session string `json:"session,omitempty"`
+	// FencingToken of the lock, if the locker supports fencing
+	FencingToken uint64 `json:"fencing_token,omitempty"`
+}
+
+// TryLocker is a Locker which can also be acquired and released explicitly,
+// for library users which need more control than the channel from `Lock`
+type TryLocker interface {
+	Locker
+	// TryLock attempts to acquire the lock without blocking, returning
+	// whether it was acquired. The lock is held until `Unlock` is called
+	TryLock(context.Context, *LockOptions) (bool, error)
+	// Unlock releases a lock acquired with `TryLock`
+	Unlock(context.Context, *LockOptions) error
+	// LockInfo returns who currently holds the lock
+	LockInfo(context.Context, *LockOptions) (*LockInfo, error)
+}
+
+// SettingsDestination is a TargetDestination with settings beyond its
+// membership (e.g. target group attributes), which are reconciled on full
+// syncs
+type SettingsDestination interface {
+	TargetDestination
+	// ReconcileSettings resets any settings which have drifted from the
+	// config
+	ReconcileSettings(context.Context) error
+}
+
+// LockAttemptReporter is a Locker which reports when it last attempted to
+// acquire (or checked) the lock, so followers can show they are still
+// contending for it
+type LockAttemptReporter interface {
+	Locker
+	LastLockAttempt(*LockOptions) time.Time
+}
+
+// StandbyRegistry is a Locker which can also store a heartbeat of each
+// instance contending for the lock, for counting the standbys of the lock
+// across all instances
+type StandbyRegistry interface {
+	Locker
+	// PutStandby records the instance's heartbeat
+	PutStandby(context.Context, *LockOptions, *StandbyHeartbeat) error
+	// DeleteStandby removes the heartbeat of the identity
+	DeleteStandby(ctx context.Context, opts *LockOptions, identity string) error
+	// Standbys returns the heartbeats of all instances
+	Standbys(context.Context, *LockOptions) ([]*StandbyHeartbeat, error)
+}
+
+// StateStore persists the runtime state of the sync pairs, so it survives
+// restarts and moves with the lock between instances
+type StateStore interface {
+	// LoadState returns the pair's state, or nil if none has been saved
+	LoadState(ctx context.Context, name string) (*PersistedState, error)
+	// SaveState replaces the pair's state
+	SaveState(ctx context.Context, name string, state *PersistedState) error
+}
+
 type TargetSourceLocker interface {
 	Locker
 	TargetSource
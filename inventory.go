@@ -0,0 +1,39 @@
+package targetsync
+
+import (
+	"context"
+	"time"
+)
+
+// Inventory is the full current view of a sync pair's source and destination
+// targets, including their metadata and destination health
+type Inventory struct {
+	// Name of the sync pair
+	Name string    `json:"name"`
+	Time time.Time `json:"time"`
+	// Source targets, with the transforms applied as they would be synced
+	Source []*Target `json:"source"`
+	// Destination targets, with their health if the destination reports it
+	Destination []*Target `json:"destination"`
+	// Error is set if the inventory couldn't be taken
+	Error string `json:"error,omitempty"`
+}
+
+// Inventory returns the targets currently in the source and the destination,
+// without changing the destination
+func (s *Syncer) Inventory(ctx context.Context) (*Inventory, error) {
+	srcTargets, err := s.currentSource(ctx)
+	if err != nil {
+		return nil, err
+	}
+	dstTargets, err := s.getTargets(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &Inventory{
+		Name:        s.name(),
+		Time:        time.Now(),
+		Source:      srcTargets,
+		Destination: dstTargets,
+	}, nil
+}
@@ -0,0 +1,46 @@
+package targetsync
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestInventory(t *testing.T) {
+	src := newmockSource()
+	dst := newmockDestination()
+	dst.targets = []*Target{
+		{IP: "2", Port: 80, Health: &TargetHealth{State: "healthy"}},
+	}
+	s := &Syncer{
+		Name: "a",
+		Config: &SyncConfig{
+			LockOptions: LockOptions{Key: "a"},
+			Transform:   TransformConfig{StaticPort: 80},
+		},
+		Src: src,
+		Dst: dst,
+	}
+	go func() {
+		src.ch <- []*Target{{IP: "1", Meta: map[string]string{"env": "prod"}}}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	inventory, err := s.Inventory(ctx)
+	if err != nil {
+		t.Fatalf("Error taking inventory: %v", err)
+	}
+	if inventory.Name != "a" {
+		t.Fatalf("Unexpected name: %s", inventory.Name)
+	}
+	if err := equalTargets(inventory.Source, []*Target{{IP: "1", Port: 80}}); err != nil {
+		t.Fatalf("Unexpected source targets: %v", err)
+	}
+	if inventory.Source[0].Meta["env"] != "prod" {
+		t.Fatalf("Expected source meta to be kept: %v", inventory.Source[0].Meta)
+	}
+	if len(inventory.Destination) != 1 || inventory.Destination[0].Health == nil || inventory.Destination[0].Health.State != "healthy" {
+		t.Fatalf("Unexpected destination targets: %+v", inventory.Destination)
+	}
+}
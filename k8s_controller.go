@@ -0,0 +1,339 @@
+package targetsync
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Annotations of the Kubernetes Services synced by the K8sServiceController
+const (
+	// K8sAnnotationTargetGroupARN is the target group the Service's endpoints
+	// are synced to, only Services with it are synced
+	K8sAnnotationTargetGroupARN = "targetsync.io/target-group-arn"
+	// K8sAnnotationRegion is the region of the target group, if it isn't the
+	// default region
+	K8sAnnotationRegion = "targetsync.io/region"
+	// K8sAnnotationPort is the port of the targets, defaulting to the target
+	// port of a Service with a single port
+	K8sAnnotationPort = "targetsync.io/port"
+)
+
+const (
+	// defaultK8sControllerInterval is how often the Services are listed if
+	// `Interval` isn't set
+	defaultK8sControllerInterval = 30 * time.Second
+	// defaultK8sControllerKeyTemplate is the lock key template of the pairs
+	// if the defaults don't set a key template, as the keys are the names of
+	// the ConfigMaps the pairs lock with
+	defaultK8sControllerKeyTemplate = "targetsync-{{.Type}}-{{.Hash}}"
+)
+
+// K8sControllerConfig is the configuration of the Kubernetes controller mode,
+// where a sync pair is run for each Service annotated with a target group
+type K8sControllerConfig struct {
+	Enabled   bool `yaml:"enabled"`
+	K8sConfig `yaml:"k8s"`
+	// Namespace the Services are watched in, defaults to all namespaces
+	Namespace string `yaml:"namespace"`
+	// Interval is how often the Services are listed, defaults to 30s. The
+	// Services are polled rather than watched, so changes to them are only
+	// applied by the next list.
+	Interval time.Duration `yaml:"interval"`
+	// Defaults are the options of every pair, e.g. `syncer` or `credentials`.
+	// The source and destination are set from the Service.
+	Defaults yaml.MapSlice `yaml:"defaults"`
+}
+
+// Validate checks the K8sControllerConfig for errors
+func (c *K8sControllerConfig) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.Interval < 0 {
+		return fmt.Errorf("k8s_controller interval must be >=0")
+	}
+	if _, err := c.defaults(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// defaults returns a pair with the default options
+func (c *K8sControllerConfig) defaults() (*PairConfig, error) {
+	b, err := yaml.Marshal(c.Defaults)
+	if err != nil {
+		return nil, err
+	}
+	pair := &PairConfig{}
+	if err := yaml.Unmarshal(b, pair); err != nil {
+		return nil, fmt.Errorf("Error unmarshaling k8s_controller defaults: %v", err)
+	}
+	if err := pair.SyncConfig.Validate(); err != nil {
+		return nil, fmt.Errorf("Invalid k8s_controller defaults: %v", err)
+	}
+	return pair, nil
+}
+
+// servicePair returns the sync pair of the Service, and its spec which
+// changes whenever the pair does. Services without a target group aren't
+// synced, for which nil is returned.
+func (c *K8sControllerConfig) servicePair(svc *corev1.Service) (*PairConfig, string, error) {
+	arn := svc.Annotations[K8sAnnotationTargetGroupARN]
+	if arn == "" {
+		return nil, "", nil
+	}
+	port, err := servicePort(svc)
+	if err != nil {
+		return nil, "", err
+	}
+
+	pair, err := c.defaults()
+	if err != nil {
+		return nil, "", err
+	}
+	pair.Name = "k8s/" + svc.Namespace + "/" + svc.Name
+	pair.K8sEndpointsConfig = K8sEndpointsConfig{
+		K8sConfig: c.K8sConfig,
+		Name:      svc.Name,
+		Namespace: svc.Namespace,
+		Port:      port,
+	}
+	pair.AWSConfig.TargetGroupARN = arn
+	pair.AWSConfig.Region = svc.Annotations[K8sAnnotationRegion]
+	pair.AWSConfig.Regions = nil
+	pair.applyCredentials()
+	if pair.SyncConfig.LockOptions.Key == "" && pair.SyncConfig.LockOptions.KeyTemplate == "" {
+		pair.SyncConfig.LockOptions.KeyTemplate = defaultK8sControllerKeyTemplate
+	}
+	if err := pair.resolveLockKey(); err != nil {
+		return nil, "", err
+	}
+	if err := pair.Validate(); err != nil {
+		return nil, "", err
+	}
+	spec := strings.Join([]string{arn, pair.AWSConfig.Region, strconv.Itoa(port)}, "|")
+	return pair, spec, nil
+}
+
+// servicePort returns the port of the Service's targets, from its annotation
+// or the target port of its only port
+func servicePort(svc *corev1.Service) (int, error) {
+	if p := svc.Annotations[K8sAnnotationPort]; p != "" {
+		port, err := strconv.Atoi(p)
+		if err != nil || port <= 0 || port > 65535 {
+			return 0, fmt.Errorf("Invalid %s annotation %q", K8sAnnotationPort, p)
+		}
+		return port, nil
+	}
+	if len(svc.Spec.Ports) != 1 {
+		return 0, fmt.Errorf("Service has %d ports, the %s annotation must be set", len(svc.Spec.Ports), K8sAnnotationPort)
+	}
+	servicePort := svc.Spec.Ports[0]
+	switch {
+	case servicePort.TargetPort.Type == intstr.Int && servicePort.TargetPort.IntVal != 0:
+		return int(servicePort.TargetPort.IntVal), nil
+	case servicePort.TargetPort.Type == intstr.String && servicePort.TargetPort.StrVal != "":
+		return 0, fmt.Errorf("Service's target port is named %q, the %s annotation must be set", servicePort.TargetPort.StrVal, K8sAnnotationPort)
+	default:
+		return int(servicePort.Port), nil
+	}
+}
+
+// NewK8sServiceController returns a K8sServiceController creating the Syncers
+// of the pairs with `newSyncer`
+func NewK8sServiceController(cfg *K8sControllerConfig, newSyncer func(*PairConfig) (*Syncer, error)) (*K8sServiceController, error) {
+	config, err := k8sRestConfig(&cfg.K8sConfig)
+	if err != nil {
+		return nil, err
+	}
+	c, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return &K8sServiceController{
+		cfg:       cfg,
+		newSyncer: newSyncer,
+		list: func() ([]corev1.Service, error) {
+			services, err := c.CoreV1().Services(cfg.Namespace).List(metav1.ListOptions{})
+			if err != nil {
+				return nil, err
+			}
+			return services.Items, nil
+		},
+		pairs: make(map[string]*controllerPair),
+	}, nil
+}
+
+// K8sServiceController runs a sync pair for each Kubernetes Service annotated
+// with a target group, syncing the Service's endpoints to it. The Services are
+// listed every `Interval`, pairs are started as Services are annotated,
+// restarted when their annotations change or their sync stops, and stopped
+// once the Service or its annotation is removed. The targets of
+// stopped pairs are left in their target groups.
+type K8sServiceController struct {
+	cfg       *K8sControllerConfig
+	newSyncer func(*PairConfig) (*Syncer, error)
+	list      func() ([]corev1.Service, error)
+
+	l     sync.Mutex
+	pairs map[string]*controllerPair
+}
+
+// controllerPair is a running pair of the controller
+type controllerPair struct {
+	spec   string
+	syncer *Syncer
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// stopped returns whether the pair's Syncer has returned
+func (p *controllerPair) stopped() bool {
+	select {
+	case <-p.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Syncers returns the Syncers of the running pairs, sorted by name
+func (c *K8sServiceController) Syncers() []*Syncer {
+	c.l.Lock()
+	defer c.l.Unlock()
+	syncers := make([]*Syncer, 0, len(c.pairs))
+	for _, pair := range c.pairs {
+		syncers = append(syncers, pair.syncer)
+	}
+	sort.Slice(syncers, func(i, j int) bool {
+		return syncers[i].Name < syncers[j].Name
+	})
+	return syncers
+}
+
+// Run lists the Services every `Interval`, starting and stopping their pairs,
+// until the context is done. The pairs are stopped before returning.
+func (c *K8sServiceController) Run(ctx context.Context) {
+	interval := c.cfg.Interval
+	if interval <= 0 {
+		interval = defaultK8sControllerInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		services, err := c.list()
+		if err != nil {
+			logger.Errorf("Error listing k8s services: %v", err)
+		} else {
+			c.reconcile(ctx, services)
+		}
+		select {
+		case <-ctx.Done():
+			c.reconcile(ctx, nil)
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// reconcile starts the pairs of new and changed Services, and stops those of
+// changed and removed ones. Pairs whose Syncer has returned are restarted.
+// Pairs of Services whose annotations are invalid are left running as they
+// were.
+func (c *K8sServiceController) reconcile(ctx context.Context, services []corev1.Service) {
+	desired := make(map[string]struct{}, len(services))
+	var start []*PairConfig
+	var specs []string
+	for i := range services {
+		svc := &services[i]
+		name := "k8s/" + svc.Namespace + "/" + svc.Name
+		pair, spec, err := c.cfg.servicePair(svc)
+		if err != nil {
+			logger.Errorf("Invalid targetsync annotations on k8s service %s/%s: %v", svc.Namespace, svc.Name, err)
+			c.l.Lock()
+			if _, ok := c.pairs[name]; ok {
+				desired[name] = struct{}{}
+			}
+			c.l.Unlock()
+			continue
+		}
+		if pair == nil {
+			continue
+		}
+		desired[name] = struct{}{}
+		c.l.Lock()
+		running, ok := c.pairs[name]
+		c.l.Unlock()
+		if ok && running.spec == spec {
+			if !running.stopped() {
+				continue
+			}
+			logger.Warnf("Sync pair %s of k8s service stopped, restarting it", name)
+		}
+		start = append(start, pair)
+		specs = append(specs, spec)
+	}
+
+	// Changed pairs are stopped before being started again, so they don't
+	// contend with themselves for the lock
+	c.l.Lock()
+	var stop []*controllerPair
+	restart := make(map[string]struct{}, len(start))
+	for _, pairCfg := range start {
+		restart[pairCfg.Name] = struct{}{}
+	}
+	for name, pair := range c.pairs {
+		_, ok := desired[name]
+		_, changed := restart[name]
+		if !ok || changed {
+			stop = append(stop, pair)
+			delete(c.pairs, name)
+		}
+	}
+	c.l.Unlock()
+	for _, pair := range stop {
+		logger.Infof("Stopping sync pair %s of k8s service", pair.syncer.Name)
+		pair.cancel()
+		<-pair.done
+	}
+
+	for i, pairCfg := range start {
+		if ctx.Err() != nil {
+			return
+		}
+		syncer, err := c.newSyncer(pairCfg)
+		if err != nil {
+			logger.Errorf("Error creating sync pair %s of k8s service: %v", pairCfg.Name, err)
+			continue
+		}
+		logger.Infof("Starting sync pair %s of k8s service, syncing to %s", pairCfg.Name, pairCfg.Destination())
+		pairCtx, cancel := context.WithCancel(ctx)
+		pair := &controllerPair{
+			spec:   specs[i],
+			syncer: syncer,
+			cancel: cancel,
+			done:   make(chan struct{}),
+		}
+		go func() {
+			defer close(pair.done)
+			if err := syncer.Run(pairCtx); err != nil {
+				syncer.log().Errorf("Error running sync pair of k8s service: %v", err)
+			}
+		}()
+		c.l.Lock()
+		c.pairs[pairCfg.Name] = pair
+		c.l.Unlock()
+	}
+	k8sControllerPairs.Set(float64(len(c.Syncers())))
+}
@@ -0,0 +1,185 @@
+package targetsync
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+func testService(name string, annotations map[string]string, ports ...corev1.ServicePort) corev1.Service {
+	return corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   "default",
+			Annotations: annotations,
+		},
+		Spec: corev1.ServiceSpec{Ports: ports},
+	}
+}
+
+func TestK8sServicePair(t *testing.T) {
+	cfg := &K8sControllerConfig{Enabled: true}
+	if err := yaml.Unmarshal([]byte("syncer:\n  remove_delay: 1m\n"), &cfg.Defaults); err != nil {
+		t.Fatalf("Error unmarshaling defaults: %v", err)
+	}
+	arn := "arn:aws:elasticloadbalancing:us-east-1:123456789012:targetgroup/web/abc"
+
+	tests := []struct {
+		name string
+		svc  corev1.Service
+		port int
+		err  bool
+	}{
+		{
+			name: "not annotated",
+			svc:  testService("plain", nil, corev1.ServicePort{Port: 80}),
+		},
+		{
+			name: "target port",
+			svc: testService("web", map[string]string{K8sAnnotationTargetGroupARN: arn},
+				corev1.ServicePort{Port: 80, TargetPort: intstr.FromInt(8080)}),
+			port: 8080,
+		},
+		{
+			name: "service port",
+			svc:  testService("web", map[string]string{K8sAnnotationTargetGroupARN: arn}, corev1.ServicePort{Port: 80}),
+			port: 80,
+		},
+		{
+			name: "port annotation",
+			svc: testService("web", map[string]string{K8sAnnotationTargetGroupARN: arn, K8sAnnotationPort: "9000"},
+				corev1.ServicePort{Port: 80}, corev1.ServicePort{Port: 443}),
+			port: 9000,
+		},
+		{
+			name: "multiple ports",
+			svc: testService("web", map[string]string{K8sAnnotationTargetGroupARN: arn},
+				corev1.ServicePort{Port: 80}, corev1.ServicePort{Port: 443}),
+			err: true,
+		},
+		{
+			name: "named target port",
+			svc: testService("web", map[string]string{K8sAnnotationTargetGroupARN: arn},
+				corev1.ServicePort{Port: 80, TargetPort: intstr.FromString("http")}),
+			err: true,
+		},
+		{
+			name: "invalid port annotation",
+			svc:  testService("web", map[string]string{K8sAnnotationTargetGroupARN: arn, K8sAnnotationPort: "http"}),
+			err:  true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pair, _, err := cfg.servicePair(&test.svc)
+			if test.err {
+				if err == nil {
+					t.Fatalf("Expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if test.port == 0 {
+				if pair != nil {
+					t.Fatalf("Expected no pair, got %s", pair.Name)
+				}
+				return
+			}
+			if pair.Name != "k8s/default/web" {
+				t.Fatalf("Unexpected name %q", pair.Name)
+			}
+			if pair.K8sEndpointsConfig.Name != "web" || pair.K8sEndpointsConfig.Namespace != "default" || pair.K8sEndpointsConfig.Port != test.port {
+				t.Fatalf("Unexpected source %+v", pair.K8sEndpointsConfig)
+			}
+			if pair.AWSConfig.TargetGroupARN != arn {
+				t.Fatalf("Unexpected target group %q", pair.AWSConfig.TargetGroupARN)
+			}
+			if pair.SyncConfig.RemoveDelay != time.Minute {
+				t.Fatalf("Expected the default remove_delay, got %v", pair.SyncConfig.RemoveDelay)
+			}
+			if key := pair.SyncConfig.LockOptions.Key; !strings.HasPrefix(key, "targetsync-aws-") {
+				t.Fatalf("Unexpected lock key %q", key)
+			}
+		})
+	}
+}
+
+func TestK8sServiceControllerReconcile(t *testing.T) {
+	arn := "arn:aws:elasticloadbalancing:us-east-1:123456789012:targetgroup/web/abc"
+	started := make(map[string]int)
+	c := &K8sServiceController{
+		cfg: &K8sControllerConfig{Enabled: true},
+		newSyncer: func(cfg *PairConfig) (*Syncer, error) {
+			started[cfg.Name]++
+			src := NewFakeSource(&FakeSourceConfig{Targets: 1, Port: cfg.K8sEndpointsConfig.Port})
+			return &Syncer{
+				Name:   cfg.Name,
+				Config: &cfg.SyncConfig,
+				Locker: src,
+				Src:    src,
+				Dst:    NewFakeDestination(&FakeDestinationConfig{}),
+			}, nil
+		},
+		pairs: make(map[string]*controllerPair),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	names := func() []string {
+		var names []string
+		for _, syncer := range c.Syncers() {
+			names = append(names, syncer.Name)
+		}
+		return names
+	}
+
+	web := testService("web", map[string]string{K8sAnnotationTargetGroupARN: arn}, corev1.ServicePort{Port: 80})
+	plain := testService("plain", nil, corev1.ServicePort{Port: 80})
+	c.reconcile(ctx, []corev1.Service{web, plain})
+	if n := names(); len(n) != 1 || n[0] != "k8s/default/web" {
+		t.Fatalf("Expected only the annotated service's pair, got %v", n)
+	}
+
+	// Unchanged services aren't restarted
+	c.reconcile(ctx, []corev1.Service{web, plain})
+	if started["k8s/default/web"] != 1 {
+		t.Fatalf("Expected the pair to be started once, started %d times", started["k8s/default/web"])
+	}
+
+	// Changed annotations restart the pair
+	web.Annotations[K8sAnnotationPort] = "8080"
+	c.reconcile(ctx, []corev1.Service{web, plain})
+	if started["k8s/default/web"] != 2 {
+		t.Fatalf("Expected the pair to be restarted, started %d times", started["k8s/default/web"])
+	}
+
+	// Pairs whose sync stopped are restarted
+	pair := c.pairs["k8s/default/web"]
+	pair.cancel()
+	<-pair.done
+	c.reconcile(ctx, []corev1.Service{web, plain})
+	if n := names(); len(n) != 1 || started["k8s/default/web"] != 3 {
+		t.Fatalf("Expected the stopped pair to be restarted, started %d times", started["k8s/default/web"])
+	}
+
+	// Invalid annotations leave the pair running
+	web.Annotations[K8sAnnotationPort] = "http"
+	c.reconcile(ctx, []corev1.Service{web, plain})
+	if n := names(); len(n) != 1 || started["k8s/default/web"] != 3 {
+		t.Fatalf("Expected the pair to be left running, got %v", n)
+	}
+
+	// Removed services stop the pair
+	c.reconcile(ctx, []corev1.Service{plain})
+	if n := names(); len(n) != 0 {
+		t.Fatalf("Expected no pairs, got %v", n)
+	}
+}
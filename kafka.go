@@ -0,0 +1,79 @@
+package targetsync
+
+import (
+	"encoding/json"
+
+	"github.com/Shopify/sarama"
+)
+
+// NewKafkaEventSink returns a new KafkaEventSink
+func NewKafkaEventSink(cfg *KafkaConfig) (*KafkaEventSink, error) {
+	saramaCfg := sarama.NewConfig()
+	if cfg.ClientID != "" {
+		saramaCfg.ClientID = cfg.ClientID
+	}
+	saramaCfg.Producer.Return.Errors = true
+
+	producer, err := sarama.NewAsyncProducer(cfg.Brokers, saramaCfg)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &KafkaEventSink{
+		cfg:      cfg,
+		producer: producer,
+		doneCh:   make(chan struct{}),
+	}
+	go s.logErrors()
+	return s, nil
+}
+
+// KafkaEventSink is an EventSink which publishes the events as JSON to a
+// kafka topic
+type KafkaEventSink struct {
+	cfg      *KafkaConfig
+	producer sarama.AsyncProducer
+	doneCh   chan struct{}
+}
+
+// Emit publishes the event to the topic. This never blocks the caller, if the
+// producer is backed up the event is dropped.
+func (s *KafkaEventSink) Emit(e Event) {
+	b, err := json.Marshal(e)
+	if err != nil {
+		logger.Errorf("Error marshaling event for kafka: %v", err)
+		return
+	}
+
+	msg := &sarama.ProducerMessage{
+		Topic: s.cfg.Topic,
+		Value: sarama.ByteEncoder(b),
+	}
+	switch s.cfg.KeyScheme {
+	case KafkaKeyEventType:
+		msg.Key = sarama.StringEncoder(e.Type)
+	case KafkaKeyLockKey, "":
+		msg.Key = sarama.StringEncoder(e.Key)
+	}
+
+	select {
+	case s.producer.Input() <- msg:
+	default:
+		logger.Warnf("Kafka producer backed up, dropping event: %s", e.Message)
+	}
+}
+
+// Close flushes any pending events and closes the producer
+func (s *KafkaEventSink) Close() error {
+	err := s.producer.Close()
+	<-s.doneCh
+	return err
+}
+
+// logErrors logs the errors from the producer until it is closed
+func (s *KafkaEventSink) logErrors() {
+	defer close(s.doneCh)
+	for err := range s.producer.Errors() {
+		logger.Errorf("Error publishing event to kafka: %v", err)
+	}
+}
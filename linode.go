@@ -0,0 +1,202 @@
+package targetsync
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/linode/linodego"
+	"golang.org/x/oauth2"
+)
+
+// NewLinodeNodeBalancer returns a new Linode NodeBalancer destination
+func NewLinodeNodeBalancer(cfg *LinodeConfig) (*LinodeNodeBalancer, error) {
+	token := cfg.Token
+	if token == "" {
+		token = os.Getenv("LINODE_TOKEN")
+	}
+	if token == "" {
+		return nil, fmt.Errorf("Linode API token must be set")
+	}
+
+	tokenSource := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token})
+	client := linodego.NewClient(&http.Client{
+		Transport: &oauth2.Transport{Source: tokenSource},
+	})
+
+	return &LinodeNodeBalancer{
+		client: &client,
+		cfg:    cfg,
+	}, nil
+}
+
+// LinodeNodeBalancer is a TargetDestination implementation for the backend
+// nodes of a Linode NodeBalancer config
+type LinodeNodeBalancer struct {
+	client *linodego.Client
+	cfg    *LinodeConfig
+}
+
+// log returns the destination's Logger
+func (n *LinodeNodeBalancer) log() Logger {
+	return loggerOr(n.cfg.Logger)
+}
+
+// nodes returns all backend nodes of the NodeBalancer config by target key
+func (n *LinodeNodeBalancer) nodes(ctx context.Context) (map[string]linodego.NodeBalancerNode, error) {
+	nodes, err := n.client.ListNodeBalancerNodes(ctx, n.cfg.NodeBalancerID, n.cfg.ConfigID, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	nodeMap := make(map[string]linodego.NodeBalancerNode, len(nodes))
+	for _, node := range nodes {
+		host, portStr, err := net.SplitHostPort(node.Address)
+		if err != nil {
+			n.log().Warnf("Skipping unparseable nodebalancer node %s: %v", node.Address, err)
+			continue
+		}
+		port, err := strconv.Atoi(portStr)
+		if err != nil {
+			n.log().Warnf("Skipping unparseable nodebalancer node %s: %v", node.Address, err)
+			continue
+		}
+		nodeMap[(&Target{IP: host, Port: port}).Key()] = node
+	}
+	return nodeMap, nil
+}
+
+// GetTargets returns the current set of targets at the destination, drained
+// nodes are not included
+func (n *LinodeNodeBalancer) GetTargets(ctx context.Context) ([]*Target, error) {
+	nodes, err := n.nodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	targets := make([]*Target, 0, len(nodes))
+	for _, node := range nodes {
+		if node.Mode == linodego.ModeDrain {
+			continue
+		}
+		host, portStr, _ := net.SplitHostPort(node.Address)
+		port, _ := strconv.Atoi(portStr)
+
+		state := strings.ToLower(node.Status)
+		switch state {
+		case "up":
+			state = "healthy"
+		case "down":
+			state = healthStateUnhealthy
+		}
+		targets = append(targets, &Target{
+			IP:     host,
+			Port:   port,
+			Health: &TargetHealth{State: state},
+		})
+	}
+	return targets, nil
+}
+
+// AddTargets creates a node for each target, nodes which where drained are
+// set back to accepting traffic
+func (n *LinodeNodeBalancer) AddTargets(ctx context.Context, targets []*Target) error {
+	nodes, err := n.nodes(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, target := range targets {
+		if node, ok := nodes[target.Key()]; ok {
+			if node.Mode == linodego.ModeAccept {
+				continue
+			}
+			if _, err := n.client.UpdateNodeBalancerNode(ctx, n.cfg.NodeBalancerID, n.cfg.ConfigID, node.ID, linodego.NodeBalancerNodeUpdateOptions{
+				Mode: linodego.ModeAccept,
+			}); err != nil {
+				return fmt.Errorf("Error updating node %s: %v", target.Key(), err)
+			}
+			continue
+		}
+
+		opts := linodego.NodeBalancerNodeCreateOptions{
+			Address: target.Key(),
+			Label:   linodeNodeLabel(target),
+			Mode:    linodego.ModeAccept,
+			Weight:  targetWeight(target, n.cfg.Weight),
+		}
+		if _, err := n.client.CreateNodeBalancerNode(ctx, n.cfg.NodeBalancerID, n.cfg.ConfigID, opts); err != nil {
+			return fmt.Errorf("Error creating node %s: %v", target.Key(), err)
+		}
+	}
+	return nil
+}
+
+// RemoveTargets deletes (or drains, depending on the `RemoveMode`) the nodes
+// matching the targets
+func (n *LinodeNodeBalancer) RemoveTargets(ctx context.Context, targets []*Target) error {
+	nodes, err := n.nodes(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, target := range targets {
+		node, ok := nodes[target.Key()]
+		if !ok {
+			n.log().Debugf("Target not a nodebalancer node, skipping removal: %v", target)
+			continue
+		}
+		if n.cfg.RemoveMode == LinodeRemoveModeDrain {
+			if err := n.drainNode(ctx, node); err != nil {
+				return fmt.Errorf("Error draining node %s: %v", target.Key(), err)
+			}
+			continue
+		}
+		if err := n.client.DeleteNodeBalancerNode(ctx, n.cfg.NodeBalancerID, n.cfg.ConfigID, node.ID); err != nil {
+			return fmt.Errorf("Error deleting node %s: %v", target.Key(), err)
+		}
+	}
+	return nil
+}
+
+// DisableTargets drains the nodes matching the targets, they are set back to
+// accepting traffic by AddTargets
+func (n *LinodeNodeBalancer) DisableTargets(ctx context.Context, targets []*Target) error {
+	nodes, err := n.nodes(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, target := range targets {
+		node, ok := nodes[target.Key()]
+		if !ok {
+			n.log().Debugf("Target not a nodebalancer node, skipping disable: %v", target)
+			continue
+		}
+		if err := n.drainNode(ctx, node); err != nil {
+			return fmt.Errorf("Error draining node %s: %v", target.Key(), err)
+		}
+	}
+	return nil
+}
+
+func (n *LinodeNodeBalancer) drainNode(ctx context.Context, node linodego.NodeBalancerNode) error {
+	_, err := n.client.UpdateNodeBalancerNode(ctx, n.cfg.NodeBalancerID, n.cfg.ConfigID, node.ID, linodego.NodeBalancerNodeUpdateOptions{
+		Mode: linodego.ModeDrain,
+	})
+	return err
+}
+
+// linodeNodeLabel returns the label for the target's node, labels are limited
+// to 32 characters of [a-zA-Z0-9-_.]
+func linodeNodeLabel(target *Target) string {
+	label := "ts-" + strings.NewReplacer(":", "-").Replace(target.Key())
+	if len(label) > 32 {
+		label = label[:32]
+	}
+	return label
+}
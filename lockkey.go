@@ -0,0 +1,136 @@
+package targetsync
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// defaultLockKeyTemplate is the template of lock keys generated from the
+// destination if `KeyTemplate` isn't set
+const defaultLockKeyTemplate = "targetsync/{{.Type}}/{{.Hash}}"
+
+// LockKeyData is the data lock key templates are executed with
+type LockKeyData struct {
+	// Type of the destination, e.g. `aws`
+	Type string
+	// ID of the destination within its type, e.g. the target group ARN
+	ID string
+	// Hash is a short hash of the type and ID, safe to use in any key
+	Hash string
+}
+
+// destinationIdentity returns the type and ID of the pair's destination, which
+// are the same for every config pointed at the same destination. The ID is
+// empty for destinations without an identity (e.g. the fake destination).
+func (c *PairConfig) destinationIdentity() (string, string) {
+	switch {
+	case len(c.Chain) > 0:
+		members := make([]string, len(c.Chain))
+		for i, member := range c.Chain {
+			members[i] = member.Destination()
+		}
+		return "chain", strings.Join(members, ",")
+	case c.FakeDestinationConfig.Enabled:
+		return "fake", ""
+	case c.TraefikConfig.ServiceName != "":
+		return "traefik", c.TraefikConfig.FilePath + c.TraefikConfig.HTTPPath + "/" + c.TraefikConfig.ServiceName
+	case c.ConsulDestinationConfig.ServiceName != "":
+		return "consul", strings.Join([]string{c.ConsulDestinationConfig.Partition, c.ConsulDestinationConfig.Namespace, c.ConsulDestinationConfig.ServiceName}, "/")
+	case c.K8sServiceEntryConfig.Name != "":
+		return "k8s_service_entry", c.K8sServiceEntryConfig.Namespace + "/" + c.K8sServiceEntryConfig.Name
+	case c.GCEConfig.InstanceGroup != "":
+		return "gce", strings.Join([]string{c.GCEConfig.Project, c.GCEConfig.Zone, c.GCEConfig.InstanceGroup}, "/")
+	case c.LinodeConfig.NodeBalancerID != 0:
+		return "linode", fmt.Sprintf("%d/%d", c.LinodeConfig.NodeBalancerID, c.LinodeConfig.ConfigID)
+	case c.HetznerConfig.LoadBalancerID != 0:
+		return "hetzner", fmt.Sprintf("%d/%d", c.HetznerConfig.LoadBalancerID, c.HetznerConfig.ListenPort)
+	case c.ScalewayConfig.BackendID != "":
+		return "scaleway", c.ScalewayConfig.Region + "/" + c.ScalewayConfig.BackendID
+	case c.OVHConfig.ServiceName != "":
+		return "ovh", fmt.Sprintf("%s/%s/%d", c.OVHConfig.ServiceName, c.OVHConfig.FarmType, c.OVHConfig.FarmID)
+	case c.OctaviaConfig.PoolID != "":
+		return "octavia", c.OctaviaConfig.Region + "/" + c.OctaviaConfig.PoolID
+	case c.RFC2136Config.Server != "":
+		return "rfc2136", strings.Join([]string{c.RFC2136Config.Zone, c.RFC2136Config.Name, string(c.RFC2136Config.RecordType)}, "/")
+	case c.GlobalAcceleratorConfig.EndpointGroupARN != "":
+		return "global_accelerator", c.GlobalAcceleratorConfig.EndpointGroupARN
+	case len(c.AWSConfig.Regions) > 0:
+		arns := make([]string, len(c.AWSConfig.Regions))
+		for i, region := range c.AWSConfig.Regions {
+			arns[i] = region.TargetGroupARN
+		}
+		sort.Strings(arns)
+		return "aws", strings.Join(arns, ",")
+	default:
+		return "aws", c.AWSConfig.TargetGroupARN
+	}
+}
+
+// Destination returns the identity of the pair's destination as `type:id`, or
+// just the type for destinations without an ID
+func (c *PairConfig) Destination() string {
+	typ, id := c.destinationIdentity()
+	if id == "" {
+		return typ
+	}
+	return typ + ":" + id
+}
+
+// resolveLockKey generates the lock key from the destination's identity with
+// the `KeyTemplate`, unless the key is set
+func (c *PairConfig) resolveLockKey() error {
+	opts := &c.SyncConfig.LockOptions
+	if opts.Key != "" {
+		return nil
+	}
+	typ, id := c.destinationIdentity()
+	if id == "" {
+		if opts.KeyTemplate != "" {
+			return fmt.Errorf("Lock key_template can't be used with the %s destination, set a key", typ)
+		}
+		return nil
+	}
+
+	text := opts.KeyTemplate
+	if text == "" {
+		text = defaultLockKeyTemplate
+	}
+	tmpl, err := template.New("key_template").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return fmt.Errorf("Invalid lock key_template: %v", err)
+	}
+	sum := sha256.Sum256([]byte(typ + ":" + id))
+	var key bytes.Buffer
+	if err := tmpl.Execute(&key, LockKeyData{
+		Type: typ,
+		ID:   id,
+		Hash: hex.EncodeToString(sum[:8]),
+	}); err != nil {
+		return fmt.Errorf("Error executing lock key_template: %v", err)
+	}
+	if key.Len() == 0 {
+		return fmt.Errorf("Lock key_template %q generated an empty key", text)
+	}
+	opts.Key = key.String()
+	return nil
+}
+
+// resolveLockKeys generates the lock keys of the pairs without one
+func (c *Config) resolveLockKeys() error {
+	for i, pair := range c.SyncPairs() {
+		if err := pair.resolveLockKey(); err != nil {
+			name := pair.Name
+			if name == "" {
+				name = strconv.Itoa(i)
+			}
+			return fmt.Errorf("Invalid config for pair %s: %v", name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package targetsync
+
+import "github.com/sirupsen/logrus"
+
+// Logger is the logging interface used within targetsync. This is satisfied by
+// logrus' `FieldLogger`, allowing embedders to route logs into their own
+// logging system.
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+// logger is the Logger used by the sources, destinations and sinks
+var logger Logger = logrus.StandardLogger()
+
+// SetLogger sets the Logger used by the sources, destinations and sinks, as
+// well as any Syncer, without their own Logger. This must be called before any
+// of them are created.
+func SetLogger(l Logger) {
+	logger = l
+}
+
+// loggerOr returns `l`, or the package Logger if it isn't set
+func loggerOr(l Logger) Logger {
+	if l != nil {
+		return l
+	}
+	return logger
+}
+
+// LoggerConfig is embedded in the source and destination configs to set
+// their Logger
+type LoggerConfig struct {
+	// Logger is used instead of the package Logger (see `SetLogger`), if set
+	Logger Logger `yaml:"-"`
+}
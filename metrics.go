@@ -0,0 +1,236 @@
+package targetsync
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	convergenceSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "targetsync",
+		Name:      "convergence_seconds",
+		Help:      "Time from a target appearing in the source until it is registered in the destination",
+		Buckets:   prometheus.ExponentialBuckets(0.5, 2, 12),
+	}, []string{"name"})
+
+	poolQueueWaitSeconds = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "targetsync",
+		Name:      "pool_queue_wait_seconds",
+		Help:      "Time destination mutations spent waiting for a slot in the worker pool",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 4, 10),
+	})
+
+	workQueueDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "targetsync",
+		Name:      "work_queue_depth",
+		Help:      "Number of sync pairs waiting for a work queue worker to reconcile them",
+	})
+
+	reconcileRetriesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "targetsync",
+		Name:      "reconcile_retries_total",
+		Help:      "Number of failed reconciles requeued for a retry",
+	}, []string{"name"})
+
+	destinationTargets = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "targetsync",
+		Name:      "destination_targets",
+		Help:      "Number of targets in the destination by health state, as of the last full sync",
+	}, []string{"name", "state"})
+
+	destinationTargetAge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "targetsync",
+		Name:      "destination_target_age_seconds",
+		Help:      "Age of the oldest and newest targets in the destination, since added (or first seen) by targetsync",
+	}, []string{"name", "target"})
+
+	registrationRefreshesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "targetsync",
+		Name:      "registration_refreshes_total",
+		Help:      "Number of targets re-registered in the destination for exceeding the max registration age",
+	}, []string{"name"})
+
+	sourceTargets = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "targetsync",
+		Name:      "source_targets",
+		Help:      "Number of targets in the last update from the source",
+	}, []string{"name"})
+
+	sourceStalenessSeconds = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "targetsync",
+		Name:      "source_staleness_seconds",
+		Help:      "How long the source has been unhealthy or unsubscribed, 0 if it is fresh",
+	}, []string{"name"})
+
+	sourceTargetAnomaliesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "targetsync",
+		Name:      "source_target_anomalies_total",
+		Help:      "Number of source updates whose target count deviated too far from the baseline",
+	}, []string{"name"})
+
+	destinationTimeoutsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "targetsync",
+		Name:      "destination_timeouts_total",
+		Help:      "Number of destination calls which exceeded the destination timeout",
+	}, []string{"name", "op"})
+
+	lockAttemptsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "targetsync",
+		Name:      "lock_attempts_total",
+		Help:      "Number of attempts to acquire the lock",
+	}, []string{"name"})
+
+	lockHeld = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "targetsync",
+		Name:      "lock_held",
+		Help:      "Whether this process currently holds the lock",
+	}, []string{"name"})
+
+	lockQuorumHeld = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "targetsync",
+		Name:      "lock_quorum_held",
+		Help:      "Number of the quorum's locks this process currently holds",
+	}, []string{"name"})
+
+	lockAcquiredTimestamp = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "targetsync",
+		Name:      "lock_acquired_timestamp_seconds",
+		Help:      "Unix time the lock was last acquired by this process",
+	}, []string{"name"})
+
+	targetGroupAttributeDriftTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "targetsync",
+		Name:      "target_group_attribute_drift_total",
+		Help:      "Number of times a managed target group attribute was found drifted and reset",
+	}, []string{"target_group", "attribute"})
+
+	mutationBudgetUsed = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "targetsync",
+		Name:      "mutation_budget_used",
+		Help:      "Number of targets mutated within the mutation budget's window, as of the last mutation",
+	}, []string{"name"})
+
+	mutationsPaused = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "targetsync",
+		Name:      "mutations_paused",
+		Help:      "Whether mutations are paused by the mutation budget until resumed",
+	}, []string{"name"})
+
+	lockAttemptTimestamp = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "targetsync",
+		Name:      "lock_attempt_timestamp_seconds",
+		Help:      "Unix time this process last attempted (or checked) the lock, if the locker reports it",
+	}, []string{"name"})
+
+	standbyHealthy = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "targetsync",
+		Name:      "standby_healthy",
+		Help:      "Whether this process is a healthy standby, ready to take over the lock",
+	}, []string{"name"})
+
+	standbyCount = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "targetsync",
+		Name:      "standbys",
+		Help:      "Number of healthy standbys of the lock across all instances, from their heartbeats",
+	}, []string{"name"})
+
+	lockHolder = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "targetsync",
+		Name:      "lock_holder",
+		Help:      "Identity of the current lock holder, as seen by this process",
+	}, []string{"name", "identity"})
+
+	sessionRenewalsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "targetsync",
+		Name:      "session_renewals_total",
+		Help:      "Number of successful lock session renewals",
+	}, []string{"name"})
+
+	sessionRenewalFailuresTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "targetsync",
+		Name:      "session_renewal_failures_total",
+		Help:      "Number of failed lock session renewals",
+	}, []string{"name"})
+
+	k8sControllerPairs = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "targetsync",
+		Name:      "k8s_controller_pairs",
+		Help:      "Number of sync pairs run for annotated k8s services",
+	})
+
+	orchestrationHookDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "targetsync",
+		Name:      "orchestration_hook_duration_seconds",
+		Help:      "Time taken by the orchestration hooks to respond",
+		Buckets:   prometheus.ExponentialBuckets(0.05, 2, 12),
+	}, []string{"name", "hook"})
+
+	awsAPICallsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "targetsync",
+		Name:      "aws_api_calls_total",
+		Help:      "Number of AWS API calls by service, operation and error code (ok if successful)",
+	}, []string{"service", "operation", "code"})
+
+	awsAPICallDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "targetsync",
+		Name:      "aws_api_call_duration_seconds",
+		Help:      "Latency of AWS API calls, including retries",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 2, 12),
+	}, []string{"service", "operation"})
+
+	awsAPIRetriesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "targetsync",
+		Name:      "aws_api_retries_total",
+		Help:      "Number of AWS API call attempts retried by the sdk",
+	}, []string{"service", "operation"})
+
+	awsAPIThrottlesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "targetsync",
+		Name:      "aws_api_throttles_total",
+		Help:      "Number of AWS API call attempts which were throttled",
+	}, []string{"service", "operation"})
+)
+
+// setLockHolder updates the lock_holder metric from the old to the new holder
+func setLockHolder(name, oldIdentity, newIdentity string) {
+	if oldIdentity != "" {
+		lockHolder.DeleteLabelValues(name, oldIdentity)
+	}
+	if newIdentity != "" {
+		lockHolder.WithLabelValues(name, newIdentity).Set(1)
+	}
+}
+
+func init() {
+	prometheus.MustRegister(
+		convergenceSeconds,
+		poolQueueWaitSeconds,
+		workQueueDepth,
+		reconcileRetriesTotal,
+		destinationTargets,
+		destinationTargetAge,
+		registrationRefreshesTotal,
+		sourceTargets,
+		sourceStalenessSeconds,
+		sourceTargetAnomaliesTotal,
+		destinationTimeoutsTotal,
+		lockAttemptsTotal,
+		lockHeld,
+		lockQuorumHeld,
+		lockAcquiredTimestamp,
+		lockHolder,
+		lockAttemptTimestamp,
+		mutationBudgetUsed,
+		mutationsPaused,
+		targetGroupAttributeDriftTotal,
+		standbyHealthy,
+		standbyCount,
+		sessionRenewalsTotal,
+		sessionRenewalFailuresTotal,
+		k8sControllerPairs,
+		orchestrationHookDuration,
+		awsAPICallsTotal,
+		awsAPICallDuration,
+		awsAPIRetriesTotal,
+		awsAPIThrottlesTotal,
+	)
+}
@@ -0,0 +1,95 @@
+package targetsync
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// waitForEvent waits for an event of the type, skipping any others
+func waitForEvent(t *testing.T, events chanSink, eventType EventType) Event {
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case e := <-events:
+			if e.Type == eventType {
+				return e
+			}
+		case <-timeout:
+			t.Fatalf("Timed out waiting for a %s event", eventType)
+		}
+	}
+}
+
+func TestLockMetrics(t *testing.T) {
+	locker := newTestLocker()
+	events := make(chanSink, 100)
+	src := newmockSource()
+	syncer := &Syncer{
+		Config: &SyncConfig{
+			LockOptions: LockOptions{
+				Key:      "lock-metrics",
+				Identity: "a",
+				TTL:      time.Second,
+			},
+		},
+		Locker: locker,
+		Src:    src,
+		Dst:    newmockDestination(),
+		Events: events,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go syncer.Run(ctx)
+	src.ch <- []*Target{{IP: "1"}}
+	waitFor(t, "the lock to be requested", func() bool {
+		calls, _ := locker.state()
+		return calls == 1
+	})
+	if held := testutil.ToFloat64(lockHeld.WithLabelValues("lock-metrics")); held != 0 {
+		t.Fatalf("Lock held before it was acquired: %v", held)
+	}
+
+	locker.send(true)
+	waitForEvent(t, events, EventLockAcquired)
+	if held := testutil.ToFloat64(lockHeld.WithLabelValues("lock-metrics")); held != 1 {
+		t.Fatalf("Lock not held once acquired: %v", held)
+	}
+	if acquired := testutil.ToFloat64(lockAcquiredTimestamp.WithLabelValues("lock-metrics")); acquired <= 0 {
+		t.Fatalf("Lock acquired time not set: %v", acquired)
+	}
+
+	locker.send(false)
+	waitForEvent(t, events, EventLockLost)
+	if held := testutil.ToFloat64(lockHeld.WithLabelValues("lock-metrics")); held != 0 {
+		t.Fatalf("Lock still held once lost: %v", held)
+	}
+	if state := syncer.State(); state != SyncerStateFollower {
+		t.Fatalf("Unexpected state once the lock was lost: %s", state)
+	}
+}
+
+func TestSetLockHolder(t *testing.T) {
+	setLockHolder("lock-holder", "", "a")
+	if holder := testutil.ToFloat64(lockHolder.WithLabelValues("lock-holder", "a")); holder != 1 {
+		t.Fatalf("Holder a not set: %v", holder)
+	}
+
+	// The old holder's series is removed when the holder changes
+	setLockHolder("lock-holder", "a", "b")
+	if holder := testutil.ToFloat64(lockHolder.WithLabelValues("lock-holder", "b")); holder != 1 {
+		t.Fatalf("Holder b not set: %v", holder)
+	}
+	if lockHolder.DeleteLabelValues("lock-holder", "a") {
+		t.Fatalf("Holder a not removed when the holder changed")
+	}
+
+	// Losing the holder removes its series without setting another
+	setLockHolder("lock-holder", "b", "")
+	if lockHolder.DeleteLabelValues("lock-holder", "b") {
+		t.Fatalf("Holder b not removed when the holder was lost")
+	}
+}
@@ -15,17 +15,67 @@ func (m *mockLocker) Lock(context.Context, *LockOptions) (<-chan bool, error) {
 }
 
 func newmockSource() *mockSource {
-	return &mockSource{
-		ch: make(chan []*Target),
+	m := &mockSource{
+		ch:   make(chan []*Target),
+		subs: make(map[chan []*Target]struct{}),
 	}
+	go m.broadcast()
+	return m
 }
 
+// mockSource sends the targets sent on `ch` to all subscribers, like a real
+// source new subscribers get the latest targets
 type mockSource struct {
 	ch chan []*Target
+
+	l    sync.Mutex
+	last []*Target
+	subs map[chan []*Target]struct{}
 }
 
-func (m *mockSource) Subscribe(context.Context) (chan []*Target, error) {
-	return m.ch, nil
+func (m *mockSource) broadcast() {
+	for targets := range m.ch {
+		m.l.Lock()
+		m.last = targets
+		for sub := range m.subs {
+			sub <- targets
+		}
+		m.l.Unlock()
+	}
+}
+
+func (m *mockSource) Subscribe(ctx context.Context) (chan []*Target, error) {
+	sub := make(chan []*Target, 100)
+	m.l.Lock()
+	if m.last != nil {
+		sub <- m.last
+	}
+	m.subs[sub] = struct{}{}
+	m.l.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		m.l.Lock()
+		delete(m.subs, sub)
+		m.l.Unlock()
+	}()
+	return sub, nil
+}
+
+func newmockDeltaSource() *mockDeltaSource {
+	return &mockDeltaSource{newmockSource()}
+}
+
+type mockDeltaSource struct {
+	*mockSource
+}
+
+func (m *mockDeltaSource) SubscribeDeltas(ctx context.Context) (chan *TargetDelta, error) {
+	ch, err := m.Subscribe(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return deltasFromSnapshots(ctx, ch), nil
 }
 
 func newmockDestination() *mockDestination {
@@ -74,3 +124,11 @@ func (m *mockDestination) RemoveTargets(_ context.Context, tgts []*Target) error
 	}
 	return nil
 }
+
+type mockTrigger struct {
+	ch chan struct{}
+}
+
+func (m *mockTrigger) Subscribe(context.Context) (<-chan struct{}, error) {
+	return m.ch, nil
+}
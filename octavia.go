@@ -0,0 +1,208 @@
+package targetsync
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack"
+	"github.com/gophercloud/gophercloud/openstack/loadbalancer/v2/pools"
+)
+
+// NewOctaviaPool returns a new OpenStack Octavia pool destination
+func NewOctaviaPool(cfg *OctaviaConfig) (*OctaviaPool, error) {
+	var authOpts gophercloud.AuthOptions
+	if cfg.AuthURL == "" {
+		// Fallback to the standard OS_* environment variables
+		opts, err := openstack.AuthOptionsFromEnv()
+		if err != nil {
+			return nil, err
+		}
+		authOpts = opts
+	} else {
+		authOpts = gophercloud.AuthOptions{
+			IdentityEndpoint: cfg.AuthURL,
+			Username:         cfg.Username,
+			Password:         cfg.Password,
+			TenantID:         cfg.ProjectID,
+			TenantName:       cfg.ProjectName,
+			DomainName:       cfg.DomainName,
+		}
+	}
+	authOpts.AllowReauth = true
+
+	provider, err := openstack.AuthenticatedClient(authOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := openstack.NewLoadBalancerV2(provider, gophercloud.EndpointOpts{
+		Region: cfg.Region,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &OctaviaPool{
+		client: client,
+		cfg:    cfg,
+	}, nil
+}
+
+// OctaviaPool is a TargetDestination implementation for OpenStack Octavia
+// load balancer pools
+type OctaviaPool struct {
+	client *gophercloud.ServiceClient
+	cfg    *OctaviaConfig
+}
+
+// log returns the destination's Logger
+func (p *OctaviaPool) log() Logger {
+	return loggerOr(p.cfg.Logger)
+}
+
+// clientWithContext returns a copy of the client whose requests are made with
+// the context, so they are cancelled with it
+func (p *OctaviaPool) clientWithContext(ctx context.Context) *gophercloud.ServiceClient {
+	provider := *p.client.ProviderClient
+	provider.Context = ctx
+	client := *p.client
+	client.ProviderClient = &provider
+	return &client
+}
+
+// members returns all members of the pool
+func (p *OctaviaPool) members(ctx context.Context) ([]pools.Member, error) {
+	pages, err := pools.ListMembers(p.clientWithContext(ctx), p.cfg.PoolID, pools.ListMembersOpts{}).AllPages()
+	if err != nil {
+		return nil, err
+	}
+	return pools.ExtractMembers(pages)
+}
+
+// memberMap returns all members of the pool by target key
+func (p *OctaviaPool) memberMap(ctx context.Context) (map[string]pools.Member, error) {
+	members, err := p.members(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	memberMap := make(map[string]pools.Member, len(members))
+	for _, member := range members {
+		memberMap[(&Target{IP: member.Address, Port: member.ProtocolPort}).Key()] = member
+	}
+	return memberMap, nil
+}
+
+// setAdminState sets the admin state of the member
+func (p *OctaviaPool) setAdminState(ctx context.Context, member pools.Member, up bool) error {
+	return pools.UpdateMember(p.clientWithContext(ctx), p.cfg.PoolID, member.ID, pools.UpdateMemberOpts{
+		AdminStateUp: &up,
+	}).Err
+}
+
+// GetTargets returns the current set of targets at the destination, disabled
+// members are not included
+func (p *OctaviaPool) GetTargets(ctx context.Context) ([]*Target, error) {
+	members, err := p.members(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	targets := make([]*Target, 0, len(members))
+	for _, member := range members {
+		if !member.AdminStateUp {
+			continue
+		}
+		targets = append(targets, &Target{
+			IP:   member.Address,
+			Port: member.ProtocolPort,
+		})
+	}
+	return targets, nil
+}
+
+// AddTargets creates a pool member for each target, existing members are
+// enabled again and updated to the target's weight
+func (p *OctaviaPool) AddTargets(ctx context.Context, targets []*Target) error {
+	members, err := p.memberMap(ctx)
+	if err != nil {
+		return err
+	}
+
+	client := p.clientWithContext(ctx)
+	for _, target := range targets {
+		weight := targetWeight(target, p.cfg.Weight)
+		if member, ok := members[target.Key()]; ok {
+			var opts pools.UpdateMemberOpts
+			if !member.AdminStateUp {
+				up := true
+				opts.AdminStateUp = &up
+			}
+			if weight > 0 && weight != member.Weight {
+				opts.Weight = &weight
+			}
+			if opts.AdminStateUp == nil && opts.Weight == nil {
+				continue
+			}
+			if err := pools.UpdateMember(client, p.cfg.PoolID, member.ID, opts).Err; err != nil {
+				return fmt.Errorf("Error updating member %s: %v", target.Key(), err)
+			}
+			continue
+		}
+
+		opts := pools.CreateMemberOpts{
+			Address:      target.IP,
+			ProtocolPort: target.Port,
+			SubnetID:     p.cfg.SubnetID,
+		}
+		if weight > 0 {
+			opts.Weight = &weight
+		}
+		if _, err := pools.CreateMember(client, p.cfg.PoolID, opts).Extract(); err != nil {
+			return fmt.Errorf("Error creating member %s: %v", target.Key(), err)
+		}
+	}
+	return nil
+}
+
+// RemoveTargets deletes the pool members matching the targets
+func (p *OctaviaPool) RemoveTargets(ctx context.Context, targets []*Target) error {
+	members, err := p.memberMap(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, target := range targets {
+		member, ok := members[target.Key()]
+		if !ok {
+			p.log().Debugf("Target not a member of pool, skipping removal: %v", target)
+			continue
+		}
+		if err := pools.DeleteMember(p.clientWithContext(ctx), p.cfg.PoolID, member.ID).ExtractErr(); err != nil {
+			return fmt.Errorf("Error deleting member %s: %v", target.Key(), err)
+		}
+	}
+	return nil
+}
+
+// DisableTargets sets the admin state of the pool members matching the
+// targets to down, they are enabled again by AddTargets
+func (p *OctaviaPool) DisableTargets(ctx context.Context, targets []*Target) error {
+	members, err := p.memberMap(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, target := range targets {
+		member, ok := members[target.Key()]
+		if !ok {
+			p.log().Debugf("Target not a member of pool, skipping disable: %v", target)
+			continue
+		}
+		if err := p.setAdminState(ctx, member, false); err != nil {
+			return fmt.Errorf("Error disabling member %s: %v", target.Key(), err)
+		}
+	}
+	return nil
+}
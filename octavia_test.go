@@ -0,0 +1,170 @@
+package targetsync
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gophercloud/gophercloud"
+)
+
+// fakeOctaviaMember is a member of the fake octavia pool
+type fakeOctaviaMember struct {
+	ID           string `json:"id"`
+	Address      string `json:"address"`
+	ProtocolPort int    `json:"protocol_port"`
+	Weight       int    `json:"weight"`
+	AdminStateUp bool   `json:"admin_state_up"`
+}
+
+// fakeOctavia serves the member API of a single octavia pool
+type fakeOctavia struct {
+	l       sync.Mutex
+	members map[string]*fakeOctaviaMember
+	nextID  int
+}
+
+func (f *fakeOctavia) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.l.Lock()
+	defer f.l.Unlock()
+	const prefix = "/v2.0/lbaas/pools/pool/members"
+	if !strings.HasPrefix(r.URL.Path, prefix) {
+		http.NotFound(w, r)
+		return
+	}
+	id := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, prefix), "/")
+
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case r.Method == http.MethodGet && id == "":
+		members := make([]*fakeOctaviaMember, 0, len(f.members))
+		for _, member := range f.members {
+			members = append(members, member)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"members": members})
+	case r.Method == http.MethodPost && id == "":
+		var body struct {
+			Member struct {
+				Address      string `json:"address"`
+				ProtocolPort int    `json:"protocol_port"`
+				Weight       *int   `json:"weight"`
+			} `json:"member"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		f.nextID++
+		member := &fakeOctaviaMember{
+			ID:           fmt.Sprintf("m%d", f.nextID),
+			Address:      body.Member.Address,
+			ProtocolPort: body.Member.ProtocolPort,
+			Weight:       1,
+			AdminStateUp: true,
+		}
+		if body.Member.Weight != nil {
+			member.Weight = *body.Member.Weight
+		}
+		f.members[member.ID] = member
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{"member": member})
+	case r.Method == http.MethodPut && f.members[id] != nil:
+		var body struct {
+			Member struct {
+				Weight       *int  `json:"weight"`
+				AdminStateUp *bool `json:"admin_state_up"`
+			} `json:"member"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		member := f.members[id]
+		if body.Member.Weight != nil {
+			member.Weight = *body.Member.Weight
+		}
+		if body.Member.AdminStateUp != nil {
+			member.AdminStateUp = *body.Member.AdminStateUp
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"member": member})
+	case r.Method == http.MethodDelete && f.members[id] != nil:
+		delete(f.members, id)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (f *fakeOctavia) member(address string) *fakeOctaviaMember {
+	f.l.Lock()
+	defer f.l.Unlock()
+	for _, member := range f.members {
+		if member.Address == address {
+			copied := *member
+			return &copied
+		}
+	}
+	return nil
+}
+
+func TestOctaviaPool(t *testing.T) {
+	fake := &fakeOctavia{members: map[string]*fakeOctaviaMember{
+		"up":   {ID: "up", Address: "10.0.0.1", ProtocolPort: 80, Weight: 1, AdminStateUp: true},
+		"down": {ID: "down", Address: "10.0.0.2", ProtocolPort: 80, Weight: 1, AdminStateUp: false},
+	}}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	pool := &OctaviaPool{
+		client: &gophercloud.ServiceClient{
+			ProviderClient: &gophercloud.ProviderClient{},
+			ResourceBase:   srv.URL + "/v2.0/",
+		},
+		cfg: &OctaviaConfig{PoolID: "pool", Weight: 1},
+	}
+	ctx := context.Background()
+
+	// Disabled members aren't returned
+	targets, err := pool.GetTargets(ctx)
+	if err != nil {
+		t.Fatalf("Error getting targets: %v", err)
+	}
+	if err := equalTargets(targets, []*Target{{IP: "10.0.0.1", Port: 80}}); err != nil {
+		t.Fatalf("Unexpected targets: %v", err)
+	}
+
+	// Existing members are enabled and reweighted, new ones are created
+	err = pool.AddTargets(ctx, []*Target{
+		{IP: "10.0.0.1", Port: 80, Meta: map[string]string{MetaWeight: "5"}},
+		{IP: "10.0.0.2", Port: 80},
+		{IP: "10.0.0.3", Port: 80, Meta: map[string]string{MetaWeight: "3"}},
+	})
+	if err != nil {
+		t.Fatalf("Error adding targets: %v", err)
+	}
+	for address, weight := range map[string]int{"10.0.0.1": 5, "10.0.0.2": 1, "10.0.0.3": 3} {
+		member := fake.member(address)
+		if member == nil || !member.AdminStateUp || member.Weight != weight {
+			t.Fatalf("Unexpected member for %s: %+v", address, member)
+		}
+	}
+
+	if err := pool.DisableTargets(ctx, []*Target{{IP: "10.0.0.2", Port: 80}}); err != nil {
+		t.Fatalf("Error disabling targets: %v", err)
+	}
+	if member := fake.member("10.0.0.2"); member.AdminStateUp {
+		t.Fatalf("Member not disabled: %+v", member)
+	}
+	if err := pool.RemoveTargets(ctx, []*Target{{IP: "10.0.0.3", Port: 80}}); err != nil {
+		t.Fatalf("Error removing targets: %v", err)
+	}
+	if member := fake.member("10.0.0.3"); member != nil {
+		t.Fatalf("Member not deleted: %+v", member)
+	}
+
+	// Requests are cancelled with the context
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := pool.GetTargets(cancelled); err == nil {
+		t.Fatalf("Expected an error with a cancelled context")
+	}
+}
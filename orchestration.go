@@ -0,0 +1,146 @@
+package targetsync
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// defaultOrchestrationTimeout is how long to wait for a hook to respond if
+// `Timeout` isn't set
+const defaultOrchestrationTimeout = 30 * time.Second
+
+// Orchestration hooks called by the syncer
+const (
+	OrchestrationHookPreRemove = "pre_remove"
+	OrchestrationHookPostAdd   = "post_add"
+)
+
+// OrchestrationConfig configures webhooks for deploy orchestration systems,
+// called before targets are removed from the destination and after they are
+// added to it
+type OrchestrationConfig struct {
+	// PreRemoveURL is POSTed the targets before they are removed, a 2xx
+	// response acknowledges the removal. Any other response (or a timeout)
+	// holds the removal, which is retried with the `remove_retry` backoff
+	PreRemoveURL string `yaml:"pre_remove_url"`
+	// PostAddURL is POSTed the targets once they have been added, errors
+	// are only logged
+	PostAddURL string `yaml:"post_add_url"`
+	// Headers are set on every request, e.g. an Authorization header
+	Headers map[string]string `yaml:"headers"`
+	// Timeout is how long to wait for each hook to respond, defaults to 30s
+	Timeout time.Duration `yaml:"timeout"`
+	// ProceedOnTimeout removes the targets anyways if the pre-remove hook
+	// times out, rather than holding the removal
+	ProceedOnTimeout bool `yaml:"proceed_on_timeout"`
+}
+
+// Validate checks the OrchestrationConfig for errors
+func (c *OrchestrationConfig) Validate() error {
+	for _, u := range []string{c.PreRemoveURL, c.PostAddURL} {
+		if u == "" {
+			continue
+		}
+		parsed, err := url.Parse(u)
+		if err != nil {
+			return fmt.Errorf("Invalid orchestration url %q: %v", u, err)
+		}
+		if parsed.Scheme != "http" && parsed.Scheme != "https" {
+			return fmt.Errorf("Invalid orchestration url %q: scheme must be http or https", u)
+		}
+	}
+	if c.Timeout < 0 {
+		return fmt.Errorf("Timeout for orchestration must be >=0")
+	}
+	return nil
+}
+
+// OrchestrationRequest is the body POSTed to the orchestration hooks
+type OrchestrationRequest struct {
+	// Hook is pre_remove or post_add
+	Hook string `json:"hook"`
+	// Name of the sync pair
+	Name    string    `json:"name"`
+	Targets []*Target `json:"targets"`
+}
+
+// preRemove calls the pre-remove hook for the targets, returning an
+// ErrHookRejected error unless the removal is acknowledged
+func (s *Syncer) preRemove(ctx context.Context, targets []*Target) error {
+	cfg := &s.Config.Orchestration
+	if cfg.PreRemoveURL == "" {
+		return nil
+	}
+	err := s.callOrchestrationHook(ctx, OrchestrationHookPreRemove, cfg.PreRemoveURL, targets)
+	if err == nil {
+		return nil
+	}
+	if cfg.ProceedOnTimeout && err == context.DeadlineExceeded && ctx.Err() == nil {
+		s.log().Warnf("Pre-remove hook timed out, removing %d targets anyways", len(targets))
+		return nil
+	}
+	return wrapError(ErrHookRejected, fmt.Errorf("Pre-remove hook didn't acknowledge the removal of %d targets: %v", len(targets), err))
+}
+
+// postAdd calls the post-add hook for the targets, failures are only logged
+func (s *Syncer) postAdd(ctx context.Context, targets []*Target) {
+	cfg := &s.Config.Orchestration
+	if cfg.PostAddURL == "" {
+		return
+	}
+	if err := s.callOrchestrationHook(ctx, OrchestrationHookPostAdd, cfg.PostAddURL, targets); err != nil {
+		s.log().Warnf("Error calling post-add hook for %d targets: %v", len(targets), err)
+	}
+}
+
+// callOrchestrationHook POSTs the targets to the hook and waits for a 2xx
+// response, up to the `Timeout`
+func (s *Syncer) callOrchestrationHook(ctx context.Context, hook, u string, targets []*Target) error {
+	cfg := &s.Config.Orchestration
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = defaultOrchestrationTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	b, err := json.Marshal(&OrchestrationRequest{
+		Hook:    hook,
+		Name:    s.name(),
+		Targets: targets,
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, u, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range cfg.Headers {
+		req.Header.Set(k, v)
+	}
+
+	start := time.Now()
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	orchestrationHookDuration.WithLabelValues(s.name(), hook).Observe(time.Since(start).Seconds())
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return ctx.Err()
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("Unexpected status from %s hook: %s: %s", hook, resp.Status, bytes.TrimSpace(body))
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package targetsync
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestOrchestrationHooks(t *testing.T) {
+	var requests []OrchestrationRequest
+	acknowledge := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req OrchestrationRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("Error decoding hook request: %v", err)
+		}
+		if r.Header.Get("Authorization") != "Bearer token" {
+			t.Errorf("Expected the configured headers, got %v", r.Header)
+		}
+		requests = append(requests, req)
+		if req.Hook == OrchestrationHookPreRemove && !acknowledge {
+			http.Error(w, "deploy in progress", http.StatusConflict)
+		}
+	}))
+	defer srv.Close()
+
+	dst := newmockDestination()
+	syncer := &Syncer{
+		Name: "a",
+		Config: &SyncConfig{
+			LockOptions: LockOptions{Key: "a"},
+			Orchestration: OrchestrationConfig{
+				PreRemoveURL: srv.URL + "/pre-remove",
+				PostAddURL:   srv.URL + "/post-add",
+				Headers:      map[string]string{"Authorization": "Bearer token"},
+			},
+		},
+		Dst: dst,
+	}
+	ctx := context.Background()
+	target := &Target{IP: "1", Port: 80}
+
+	if err := syncer.addTargets(ctx, []*Target{target}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(requests) != 1 || requests[0].Hook != OrchestrationHookPostAdd || requests[0].Name != "a" || len(requests[0].Targets) != 1 {
+		t.Fatalf("Expected a post-add request, got %+v", requests)
+	}
+
+	// The removal is held until acknowledged
+	if err := syncer.removeTargets(ctx, []*Target{target}); !IsErrorClass(err, ErrHookRejected) {
+		t.Fatalf("Expected the removal to be rejected, got %v", err)
+	}
+	if targets, _ := dst.GetTargets(ctx); len(targets) != 1 {
+		t.Fatalf("Expected the target to be kept, got %v", targets)
+	}
+
+	acknowledge = true
+	if err := syncer.removeTargets(ctx, []*Target{target}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if targets, _ := dst.GetTargets(ctx); len(targets) != 0 {
+		t.Fatalf("Expected the target to be removed, got %v", targets)
+	}
+	if len(requests) != 3 || requests[2].Hook != OrchestrationHookPreRemove {
+		t.Fatalf("Expected pre-remove requests, got %+v", requests)
+	}
+}
@@ -0,0 +1,167 @@
+package targetsync
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"github.com/ovh/go-ovh/ovh"
+)
+
+// NewOVHFarm returns a new OVHcloud IP Load Balancer farm destination
+func NewOVHFarm(cfg *OVHConfig) (*OVHFarm, error) {
+	endpoint := cfg.Endpoint
+	if endpoint == "" {
+		endpoint = ovh.OvhEU
+	}
+	// Empty credentials fall back to the OVH_* environment variables and
+	// ovh.conf files
+	client, err := ovh.NewClient(endpoint, cfg.ApplicationKey, cfg.ApplicationSecret, cfg.ConsumerKey)
+	if err != nil {
+		return nil, fmt.Errorf("Error creating ovh client: %v", err)
+	}
+
+	return &OVHFarm{
+		client: client,
+		cfg:    cfg,
+	}, nil
+}
+
+// OVHFarm is a TargetDestination implementation for the servers of an
+// OVHcloud IP Load Balancer farm. Changes are applied to the load balancer
+// with a refresh after each batch.
+type OVHFarm struct {
+	client *ovh.Client
+	cfg    *OVHConfig
+}
+
+// ovhFarmServer is a server of an IPLB farm
+type ovhFarmServer struct {
+	ServerID    int64  `json:"serverId,omitempty"`
+	Address     string `json:"address"`
+	Port        int    `json:"port,omitempty"`
+	Status      string `json:"status"`
+	DisplayName string `json:"displayName,omitempty"`
+	Weight      int    `json:"weight,omitempty"`
+}
+
+// farmPath returns the API path of the farm
+func (f *OVHFarm) farmPath() string {
+	farmType := f.cfg.FarmType
+	if farmType == "" {
+		farmType = OVHFarmTypeHTTP
+	}
+	return fmt.Sprintf("/ipLoadbalancing/%s/%s/farm/%d", url.PathEscape(f.cfg.ServiceName), farmType, f.cfg.FarmID)
+}
+
+// servers returns all servers of the farm by target key
+func (f *OVHFarm) servers(ctx context.Context) (map[string]*ovhFarmServer, error) {
+	var ids []int64
+	if err := f.client.GetWithContext(ctx, f.farmPath()+"/server", &ids); err != nil {
+		return nil, err
+	}
+
+	servers := make(map[string]*ovhFarmServer, len(ids))
+	for _, id := range ids {
+		var server ovhFarmServer
+		if err := f.client.GetWithContext(ctx, fmt.Sprintf("%s/server/%d", f.farmPath(), id), &server); err != nil {
+			return nil, err
+		}
+		servers[(&Target{IP: server.Address, Port: server.Port}).Key()] = &server
+	}
+	return servers, nil
+}
+
+// refresh applies the pending changes to the load balancer
+func (f *OVHFarm) refresh(ctx context.Context) error {
+	path := fmt.Sprintf("/ipLoadbalancing/%s/refresh", url.PathEscape(f.cfg.ServiceName))
+	if err := f.client.PostWithContext(ctx, path, nil, nil); err != nil {
+		return fmt.Errorf("Error refreshing load balancer: %v", err)
+	}
+	return nil
+}
+
+// GetTargets returns the active servers of the farm
+func (f *OVHFarm) GetTargets(ctx context.Context) ([]*Target, error) {
+	servers, err := f.servers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	targets := make([]*Target, 0, len(servers))
+	for _, server := range servers {
+		if server.Status != "active" {
+			continue
+		}
+		targets = append(targets, &Target{
+			IP:   server.Address,
+			Port: server.Port,
+		})
+	}
+	return targets, nil
+}
+
+// AddTargets creates a server for each target, inactive servers are set back
+// to active
+func (f *OVHFarm) AddTargets(ctx context.Context, targets []*Target) error {
+	servers, err := f.servers(ctx)
+	if err != nil {
+		return err
+	}
+
+	changed := false
+	for _, target := range targets {
+		if server, ok := servers[target.Key()]; ok {
+			if server.Status == "active" {
+				continue
+			}
+			changed = true
+			path := fmt.Sprintf("%s/server/%d", f.farmPath(), server.ServerID)
+			if err := f.client.PutWithContext(ctx, path, map[string]string{"status": "active"}, nil); err != nil {
+				return fmt.Errorf("Error activating server %s: %v", target.Key(), err)
+			}
+			continue
+		}
+
+		server := &ovhFarmServer{
+			Address:     target.IP,
+			Port:        target.Port,
+			Status:      "active",
+			DisplayName: target.displayName(),
+			Weight:      targetWeight(target, f.cfg.Weight),
+		}
+		changed = true
+		if err := f.client.PostWithContext(ctx, f.farmPath()+"/server", server, nil); err != nil {
+			return fmt.Errorf("Error creating server %s: %v", target.Key(), err)
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return f.refresh(ctx)
+}
+
+// RemoveTargets deletes the servers matching the targets
+func (f *OVHFarm) RemoveTargets(ctx context.Context, targets []*Target) error {
+	servers, err := f.servers(ctx)
+	if err != nil {
+		return err
+	}
+
+	changed := false
+	for _, target := range targets {
+		server, ok := servers[target.Key()]
+		if !ok {
+			continue
+		}
+		changed = true
+		path := fmt.Sprintf("%s/server/%d", f.farmPath(), server.ServerID)
+		if err := f.client.DeleteWithContext(ctx, path, nil); err != nil {
+			return fmt.Errorf("Error deleting server %s: %v", target.Key(), err)
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return f.refresh(ctx)
+}
@@ -0,0 +1,125 @@
+package targetsync
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	consulApi "github.com/hashicorp/consul/api"
+)
+
+// OwnershipTag is the tag (or key) holding the owner of a destination
+const OwnershipTag = "managed-by"
+
+// OwnershipConfig configures claiming the destination for this sync pair, so
+// two misconfigured deployments don't fight over the same destination
+type OwnershipConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Owner recorded on the destination, defaults to `targetsync/<pair>`
+	Owner string `yaml:"owner"`
+	// ConsulKey records the owner in this consul KV key, instead of on the
+	// destination (e.g. as a target group tag)
+	ConsulKey string `yaml:"consul_key"`
+	// Force takes over destinations claimed by another owner
+	Force bool `yaml:"force"`
+}
+
+// OwnershipMarker records which sync pair owns a destination, destinations
+// which can be tagged should implement this
+type OwnershipMarker interface {
+	// ClaimOwnership records `owner` as the owner, unless the destination is
+	// already claimed by another owner (and `force` isn't set) in which case
+	// that owner is returned
+	ClaimOwnership(ctx context.Context, owner string, force bool) (string, error)
+}
+
+// owner returns the owner to record on the destination
+func (s *Syncer) owner() string {
+	if s.Config.Ownership.Owner != "" {
+		return s.Config.Ownership.Owner
+	}
+	return "targetsync/" + s.name()
+}
+
+// claimOwnership claims the destination with the `Ownership` marker, or the
+// destination itself if it is an OwnershipMarker
+func (s *Syncer) claimOwnership(ctx context.Context) error {
+	if !s.Config.Ownership.Enabled {
+		return nil
+	}
+	marker := s.Ownership
+	if marker == nil {
+		var ok bool
+		if marker, ok = s.Dst.(OwnershipMarker); !ok {
+			return fmt.Errorf("Destination doesn't support ownership markers, set a consul_key")
+		}
+	}
+
+	owner := s.owner()
+	other, err := marker.ClaimOwnership(ctx, owner, s.Config.Ownership.Force)
+	if err != nil {
+		return fmt.Errorf("Error claiming destination ownership: %v", err)
+	}
+	if other != "" {
+		s.emit(Event{
+			Type:    EventOwnershipConflict,
+			Time:    time.Now(),
+			Message: fmt.Sprintf("Destination is owned by %s, not %s, refusing to sync (use --force to take over)", other, owner),
+		})
+		return wrapError(ErrOwnershipConflict, fmt.Errorf("Destination is owned by %s", other))
+	}
+	s.log().Debugf("Claimed destination as %s", owner)
+	return nil
+}
+
+// NewConsulOwnershipMarker returns an OwnershipMarker recording the owner in
+// the consul KV key
+func NewConsulOwnershipMarker(cfg *ConsulConfig, key string) (OwnershipMarker, error) {
+	client, err := consulClient(cfg.ClientConfig, &cfg.TLS, cfg.Namespace, cfg.Partition)
+	if err != nil {
+		return nil, err
+	}
+	return &consulOwnershipMarker{kv: client.KV(), key: key}, nil
+}
+
+// consulOwnershipMarker is an OwnershipMarker recording the owner in a consul
+// KV key, claims use check-and-set so concurrent claims can't both succeed
+type consulOwnershipMarker struct {
+	kv  *consulApi.KV
+	key string
+}
+
+// ClaimOwnership to implement the `OwnershipMarker` interface
+func (m *consulOwnershipMarker) ClaimOwnership(ctx context.Context, owner string, force bool) (string, error) {
+	queryOpts := &consulApi.QueryOptions{RequireConsistent: true}
+	pair, _, err := m.kv.Get(m.key, queryOpts.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	var index uint64
+	if pair != nil {
+		current := string(pair.Value)
+		if current == owner {
+			return "", nil
+		}
+		if !force {
+			return current, nil
+		}
+		logger.Warnf("Taking over ownership of %s from %s", m.key, current)
+		index = pair.ModifyIndex
+	}
+
+	ok, _, err := m.kv.CAS(&consulApi.KVPair{
+		Key:         m.key,
+		Value:       []byte(owner),
+		ModifyIndex: index,
+	}, (&consulApi.WriteOptions{}).WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		// Claimed by someone else since we read it
+		return m.ClaimOwnership(ctx, owner, force)
+	}
+	return "", nil
+}
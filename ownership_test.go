@@ -0,0 +1,42 @@
+package targetsync
+
+import (
+	"context"
+	"testing"
+)
+
+type mockOwnershipMarker struct {
+	owner string
+}
+
+func (m *mockOwnershipMarker) ClaimOwnership(_ context.Context, owner string, force bool) (string, error) {
+	if m.owner != "" && m.owner != owner && !force {
+		return m.owner, nil
+	}
+	m.owner = owner
+	return "", nil
+}
+
+func TestClaimOwnership(t *testing.T) {
+	marker := &mockOwnershipMarker{owner: "targetsync/other"}
+	syncer := &Syncer{
+		Name: "a",
+		Config: &SyncConfig{
+			Ownership: OwnershipConfig{Enabled: true},
+		},
+		Ownership: marker,
+		Events:    make(chanSink, 10),
+	}
+
+	if err := syncer.claimOwnership(context.Background()); !IsErrorClass(err, ErrOwnershipConflict) {
+		t.Fatalf("Expected ownership conflict, got: %v", err)
+	}
+
+	syncer.Config.Ownership.Force = true
+	if err := syncer.claimOwnership(context.Background()); err != nil {
+		t.Fatalf("Unexpected error forcing ownership: %v", err)
+	}
+	if marker.owner != "targetsync/a" {
+		t.Fatalf("Unexpected owner: %s", marker.owner)
+	}
+}
@@ -0,0 +1,107 @@
+package targetsync
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/jacksontj/lane"
+)
+
+// NewWorkerPool returns a WorkerPool allowing `size` concurrent jobs
+func NewWorkerPool(size int) *WorkerPool {
+	return &WorkerPool{
+		free:    size,
+		waiters: lane.NewPQueue(lane.MAXPQ),
+	}
+}
+
+// WorkerPool limits the number of concurrent destination mutations across
+// all Syncers sharing it. When the pool is full, jobs are started in order of
+// priority (highest first) and then in the order they were queued.
+type WorkerPool struct {
+	l       sync.Mutex
+	free    int
+	seq     int64
+	waiters *lane.PQueue
+}
+
+type poolWaiter struct {
+	ch chan struct{}
+}
+
+// acquire blocks until a slot in the pool is available for us
+func (p *WorkerPool) acquire(ctx context.Context, priority int) error {
+	p.l.Lock()
+	if p.free > 0 && p.waiters.Size() == 0 {
+		p.free--
+		p.l.Unlock()
+		return nil
+	}
+
+	w := &poolWaiter{ch: make(chan struct{})}
+	// Order by priority, then FIFO within the same priority
+	p.seq++
+	item := p.waiters.Push(w, int64(priority)<<32-p.seq)
+	p.l.Unlock()
+
+	select {
+	case <-w.ch:
+		return nil
+	case <-ctx.Done():
+		p.l.Lock()
+		defer p.l.Unlock()
+		select {
+		case <-w.ch:
+			// We were handed a slot while giving up, pass it on
+			p.releaseLocked()
+		default:
+			p.waiters.Remove(item)
+		}
+		return ctx.Err()
+	}
+}
+
+// release returns a slot to the pool
+func (p *WorkerPool) release() {
+	p.l.Lock()
+	defer p.l.Unlock()
+	p.releaseLocked()
+}
+
+func (p *WorkerPool) releaseLocked() {
+	if head, _ := p.waiters.Head(); head != nil {
+		p.waiters.Pop()
+		close(head.(*poolWaiter).ch)
+		return
+	}
+	p.free++
+}
+
+// Do runs `fn` once a slot in the pool is available
+func (p *WorkerPool) Do(ctx context.Context, priority int, fn func() error) error {
+	start := time.Now()
+	if err := p.acquire(ctx, priority); err != nil {
+		return err
+	}
+	poolQueueWaitSeconds.Observe(time.Since(start).Seconds())
+	defer p.release()
+	return fn()
+}
+
+// runJob runs `fn` subject to the Syncer's concurrency limit and the shared
+// WorkerPool (if any)
+func (s *Syncer) runJob(ctx context.Context, fn func() error) error {
+	if s.sem != nil {
+		select {
+		case s.sem <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		defer func() { <-s.sem }()
+	}
+	if s.Pool == nil {
+		return fn()
+	}
+	return s.Pool.Do(ctx, s.Config.Priority, fn)
+}
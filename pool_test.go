@@ -0,0 +1,80 @@
+package targetsync
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWorkerPoolPriority(t *testing.T) {
+	pool := NewWorkerPool(1)
+	ctx := context.Background()
+
+	// Hold the only slot so everything else queues
+	blockCh := make(chan struct{})
+	go pool.Do(ctx, 0, func() error {
+		<-blockCh
+		return nil
+	})
+	time.Sleep(50 * time.Millisecond)
+
+	var (
+		l     sync.Mutex
+		order []int
+		wg    sync.WaitGroup
+	)
+	for _, priority := range []int{1, 3, 2} {
+		wg.Add(1)
+		go func(priority int) {
+			defer wg.Done()
+			pool.Do(ctx, priority, func() error {
+				l.Lock()
+				order = append(order, priority)
+				l.Unlock()
+				return nil
+			})
+		}(priority)
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	close(blockCh)
+	wg.Wait()
+
+	expected := []int{3, 2, 1}
+	for i := range expected {
+		if order[i] != expected[i] {
+			t.Fatalf("jobs ran out of priority order: %v", order)
+		}
+	}
+}
+
+func TestWorkerPoolCancel(t *testing.T) {
+	pool := NewWorkerPool(1)
+
+	blockCh := make(chan struct{})
+	go pool.Do(context.Background(), 0, func() error {
+		<-blockCh
+		return nil
+	})
+	time.Sleep(50 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := pool.Do(ctx, 0, func() error { return nil }); err != context.DeadlineExceeded {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+
+	// The cancelled waiter must not hold on to the slot
+	close(blockCh)
+	done := make(chan struct{})
+	go func() {
+		pool.Do(context.Background(), 0, func() error { return nil })
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("slot leaked by cancelled waiter")
+	}
+}
@@ -0,0 +1,123 @@
+package targetsync
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// defaultProbeInterval is how often the destination is polled by the
+// convergence probe if `ProbeConfig.Interval` isn't set
+const defaultProbeInterval = 5 * time.Second
+
+// convergenceProbe tracks when targets first appear in the source so we can
+// measure how long it takes until they are registered in the destination
+type convergenceProbe struct {
+	name    string
+	log     Logger
+	l       sync.Mutex
+	pending map[string]time.Time
+	seen    map[string]struct{}
+}
+
+func newConvergenceProbe(name string, log Logger) *convergenceProbe {
+	return &convergenceProbe{
+		name:    name,
+		log:     log,
+		pending: make(map[string]time.Time),
+		seen:    make(map[string]struct{}),
+	}
+}
+
+// observeSource records the current set of source targets, starting the clock
+// on any new ones and dropping any which left before converging
+func (p *convergenceProbe) observeSource(targets []*Target) {
+	p.l.Lock()
+	defer p.l.Unlock()
+
+	now := time.Now()
+	current := make(map[string]struct{}, len(targets))
+	for _, target := range targets {
+		key := target.Key()
+		current[key] = struct{}{}
+		if _, ok := p.seen[key]; !ok {
+			p.pending[key] = now
+		}
+	}
+	for key := range p.pending {
+		if _, ok := current[key]; !ok {
+			delete(p.pending, key)
+		}
+	}
+	p.seen = current
+}
+
+// observeDestination records the convergence time of any pending targets that
+// are now present in the destination
+func (p *convergenceProbe) observeDestination(targets []*Target) {
+	p.l.Lock()
+	defer p.l.Unlock()
+
+	now := time.Now()
+	for _, target := range targets {
+		key := target.Key()
+		if start, ok := p.pending[key]; ok {
+			d := now.Sub(start)
+			p.log.Debugf("Target converged in %v: %v", d, target)
+			convergenceSeconds.WithLabelValues(p.name).Observe(d.Seconds())
+			delete(p.pending, key)
+		}
+	}
+}
+
+// seed records the targets already in the destination as seen, so they
+// aren't measured as converging once they are in the source
+func (p *convergenceProbe) seed(targets []*Target) {
+	p.l.Lock()
+	defer p.l.Unlock()
+
+	for _, target := range targets {
+		key := target.Key()
+		delete(p.pending, key)
+		p.seen[key] = struct{}{}
+	}
+}
+
+// hasPending returns whether there are any targets waiting to converge
+func (p *convergenceProbe) hasPending() bool {
+	p.l.Lock()
+	defer p.l.Unlock()
+	return len(p.pending) > 0
+}
+
+// runProbe periodically polls the destination for pending targets. The
+// first poll seeds the probe with the targets already in the destination.
+func (s *Syncer) runProbe(ctx context.Context, p *convergenceProbe) {
+	interval := s.Config.Probe.Interval
+	if interval <= 0 {
+		interval = defaultProbeInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	seeded := false
+	for {
+		if !seeded || p.hasPending() {
+			targets, err := s.getTargets(ctx)
+			if err != nil {
+				s.log().Warnf("Convergence probe unable to get destination targets: %v", err)
+			} else if !seeded {
+				p.seed(targets)
+				seeded = true
+			} else {
+				p.observeDestination(targets)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
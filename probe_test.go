@@ -0,0 +1,33 @@
+package targetsync
+
+import (
+	"testing"
+)
+
+func TestConvergenceProbeSeed(t *testing.T) {
+	p := newConvergenceProbe("test", logger)
+	existing, added := &Target{IP: "1", Port: 80}, &Target{IP: "2", Port: 80}
+
+	// Targets already in the destination when the probe starts haven't
+	// converged, whether the source or destination is read first
+	p.observeSource([]*Target{existing})
+	p.seed([]*Target{existing})
+	if p.hasPending() {
+		t.Fatalf("Existing destination target pending convergence")
+	}
+
+	p = newConvergenceProbe("test", logger)
+	p.seed([]*Target{existing})
+	p.observeSource([]*Target{existing, added})
+	p.l.Lock()
+	_, existingPending := p.pending[existing.Key()]
+	_, addedPending := p.pending[added.Key()]
+	p.l.Unlock()
+	if existingPending || !addedPending {
+		t.Fatalf("Expected only the new target to be pending, got %v", p.pending)
+	}
+	p.observeDestination([]*Target{existing, added})
+	if p.hasPending() {
+		t.Fatalf("Added target not converged")
+	}
+}
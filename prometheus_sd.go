@@ -0,0 +1,264 @@
+package targetsync
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+const (
+	// defaultPrometheusSDRefreshInterval is how often the targets are
+	// fetched if `RefreshInterval` isn't set
+	defaultPrometheusSDRefreshInterval = 30 * time.Second
+	// defaultPrometheusSDTimeout is the timeout for fetching the HTTP SD
+	// endpoint if `Timeout` isn't set
+	defaultPrometheusSDTimeout = 10 * time.Second
+)
+
+// PrometheusSDGroup is a target group in the format of Prometheus HTTP SD
+// responses and file_sd files
+type PrometheusSDGroup struct {
+	// Targets are host:port addresses, the host may be a hostname
+	Targets []string          `json:"targets" yaml:"targets"`
+	Labels  map[string]string `json:"labels" yaml:"labels"`
+}
+
+// NewPrometheusSDSource returns a new source for the targets of a Prometheus
+// HTTP SD endpoint or file_sd files
+func NewPrometheusSDSource(cfg *PrometheusSDConfig) *PrometheusSDSource {
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = defaultPrometheusSDTimeout
+	}
+	return &PrometheusSDSource{
+		cfg:         cfg,
+		client:      &http.Client{Timeout: timeout},
+		resolver:    net.DefaultResolver,
+		lastSuccess: time.Now(),
+	}
+}
+
+// PrometheusSDSource is a TargetSource implementation for the targets of a
+// Prometheus HTTP SD endpoint or file_sd files, so existing Prometheus
+// discovery configs can be reused. The labels of each group are set as the
+// meta of its targets.
+type PrometheusSDSource struct {
+	cfg      *PrometheusSDConfig
+	client   *http.Client
+	resolver *net.Resolver
+
+	l sync.Mutex
+	// lastSuccess is the time of the last successful refresh
+	lastSuccess time.Time
+	lastErr     error
+}
+
+// log returns the source's Logger
+func (s *PrometheusSDSource) log() Logger {
+	return loggerOr(s.cfg.Logger)
+}
+
+// Healthy to implement the `HealthChecker` interface, the source is unhealthy
+// if refreshing the targets has been failing for longer than `UnhealthyAfter`
+func (s *PrometheusSDSource) Healthy() error {
+	if s.cfg.UnhealthyAfter <= 0 {
+		return nil
+	}
+	s.l.Lock()
+	defer s.l.Unlock()
+	if since := time.Since(s.lastSuccess); since > s.cfg.UnhealthyAfter {
+		return fmt.Errorf("No successful Prometheus SD refresh in %v: %v", since, s.lastErr)
+	}
+	return nil
+}
+
+// Subscribe fetches the targets every `RefreshInterval`
+func (s *PrometheusSDSource) Subscribe(ctx context.Context) (chan []*Target, error) {
+	interval := s.cfg.RefreshInterval
+	if interval <= 0 {
+		interval = defaultPrometheusSDRefreshInterval
+	}
+
+	// TODO: configurable size?
+	ch := make(chan []*Target, 100)
+	go func() {
+		defer close(ch)
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			targets, err := s.targets(ctx)
+			s.l.Lock()
+			s.lastErr = err
+			if err == nil {
+				s.lastSuccess = time.Now()
+			}
+			s.l.Unlock()
+
+			if err != nil {
+				s.log().Errorf("Error refreshing Prometheus SD targets: %v", err)
+			} else {
+				select {
+				case ch <- targets:
+				case <-ctx.Done():
+					return
+				}
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// targets returns the targets of all groups from the endpoint and files. A
+// failure to fetch or parse any of them fails the refresh, rather than
+// removing their targets.
+func (s *PrometheusSDSource) targets(ctx context.Context) ([]*Target, error) {
+	var groups []*PrometheusSDGroup
+	if s.cfg.URL != "" {
+		fetched, err := s.fetch(ctx)
+		if err != nil {
+			return nil, wrapError(ErrSourceUnavailable, fmt.Errorf("Error fetching %s: %v", s.cfg.URL, err))
+		}
+		groups = append(groups, fetched...)
+	}
+	for _, pattern := range s.cfg.Files {
+		paths, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		// Sorted, so targets in multiple files get the labels of the first
+		sort.Strings(paths)
+		for _, path := range paths {
+			read, err := readPrometheusSDFile(path)
+			if err != nil {
+				return nil, wrapError(ErrSourceUnavailable, fmt.Errorf("Error reading %s: %v", path, err))
+			}
+			groups = append(groups, read...)
+		}
+	}
+
+	targets := make([]*Target, 0)
+	seen := make(map[string]struct{})
+	for _, group := range groups {
+		for _, addr := range group.Targets {
+			resolved, err := s.resolve(ctx, addr, group.Labels)
+			if err != nil {
+				return nil, wrapError(ErrSourceUnavailable, err)
+			}
+			for _, target := range resolved {
+				if _, ok := seen[target.Key()]; ok {
+					continue
+				}
+				seen[target.Key()] = struct{}{}
+				targets = append(targets, target)
+			}
+		}
+	}
+	return targets, nil
+}
+
+// fetch returns the groups from the HTTP SD endpoint
+func (s *PrometheusSDSource) fetch(ctx context.Context) ([]*PrometheusSDGroup, error) {
+	req, err := http.NewRequest(http.MethodGet, s.cfg.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	interval := s.cfg.RefreshInterval
+	if interval <= 0 {
+		interval = defaultPrometheusSDRefreshInterval
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("X-Prometheus-Refresh-Interval-Seconds", strconv.Itoa(int(interval.Seconds())))
+	for k, v := range s.cfg.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := s.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Unexpected status: %s", resp.Status)
+	}
+	var groups []*PrometheusSDGroup
+	if err := json.NewDecoder(resp.Body).Decode(&groups); err != nil {
+		return nil, fmt.Errorf("Error decoding response: %v", err)
+	}
+	return groups, nil
+}
+
+// readPrometheusSDFile returns the groups of a file_sd file, in JSON or YAML
+func readPrometheusSDFile(path string) ([]*PrometheusSDGroup, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var groups []*PrometheusSDGroup
+	switch filepath.Ext(path) {
+	case ".json":
+		err = json.Unmarshal(b, &groups)
+	case ".yml", ".yaml":
+		err = yaml.Unmarshal(b, &groups)
+	default:
+		return nil, fmt.Errorf("Unknown file_sd file extension %q, must be .json, .yml or .yaml", filepath.Ext(path))
+	}
+	return groups, err
+}
+
+// resolve returns the targets of a host:port address, with the labels as
+// their meta. A hostname is resolved to a target for each of its IPs, with
+// the hostname set as their MetaHostname.
+func (s *PrometheusSDSource) resolve(ctx context.Context, addr string, labels map[string]string) ([]*Target, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		// Prometheus allows addresses without a port
+		host, portStr = addr, ""
+	}
+	port := s.cfg.Port
+	if portStr != "" {
+		if port, err = strconv.Atoi(portStr); err != nil {
+			return nil, fmt.Errorf("Invalid port in target %q", addr)
+		}
+	}
+	if port <= 0 {
+		return nil, fmt.Errorf("Target %q has no port and no default port is set", addr)
+	}
+
+	newTarget := func(ip string) *Target {
+		meta := make(map[string]string, len(labels)+1)
+		for k, v := range labels {
+			meta[k] = v
+		}
+		if ip != host {
+			meta[MetaHostname] = host
+		}
+		return &Target{IP: ip, Port: port, Meta: meta}
+	}
+	if net.ParseIP(host) != nil {
+		return []*Target{newTarget(host)}, nil
+	}
+	addrs, err := s.resolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, fmt.Errorf("Error resolving target %q: %v", addr, err)
+	}
+	targets := make([]*Target, len(addrs))
+	for i, ipAddr := range addrs {
+		targets[i] = newTarget(ipAddr.IP.String())
+	}
+	return targets, nil
+}
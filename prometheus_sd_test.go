@@ -0,0 +1,66 @@
+package targetsync
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPrometheusSDSource(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Prometheus-Refresh-Interval-Seconds") != "30" {
+			t.Errorf("Expected the refresh interval header, got %v", r.Header)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`[{"targets": ["10.0.0.1:8080", "10.0.0.2"], "labels": {"env": "prod"}}]`))
+	}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "file_sd")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	file := `
+- targets: ["10.0.0.3:9090", "10.0.0.1:8080"]
+  labels:
+    env: canary
+`
+	if err := ioutil.WriteFile(filepath.Join(dir, "targets.yml"), []byte(file), 0644); err != nil {
+		t.Fatalf("Error writing file: %v", err)
+	}
+
+	src := NewPrometheusSDSource(&PrometheusSDConfig{
+		URL:   srv.URL,
+		Files: []string{filepath.Join(dir, "*.yml")},
+		Port:  80,
+	})
+	targets, err := src.targets(context.Background())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// Duplicates keep the labels of the endpoint, which is read first
+	expected := []*Target{{IP: "10.0.0.1", Port: 8080}, {IP: "10.0.0.2", Port: 80}, {IP: "10.0.0.3", Port: 9090}}
+	if err := equalTargets(targets, expected); err != nil {
+		t.Fatalf("Unexpected targets %v: %v", targets, err)
+	}
+	for _, target := range targets {
+		env := "prod"
+		if target.IP == "10.0.0.3" {
+			env = "canary"
+		}
+		if target.Meta["env"] != env {
+			t.Fatalf("Expected the env label %q on %v, got %v", env, target, target.Meta)
+		}
+	}
+
+	// A target without a port fails without a default port
+	src.cfg.Port = 0
+	if _, err := src.targets(context.Background()); !IsErrorClass(err, ErrSourceUnavailable) {
+		t.Fatalf("Expected an error for a target without a port, got %v", err)
+	}
+}
@@ -0,0 +1,230 @@
+package targetsync
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+)
+
+// PushRegistration is the body of a registration request to the PushSource
+type PushRegistration struct {
+	IP   string `json:"ip"`
+	Port int    `json:"port"`
+	// TTL is how long the registration is valid for (e.g. "30s"), the
+	// target must register again before it lapses. Defaults to the
+	// `DefaultTTL`
+	TTL    string            `json:"ttl,omitempty"`
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// NewPushSource returns a new source for targets registered over HTTP
+func NewPushSource(cfg *PushConfig) *PushSource {
+	return &PushSource{
+		cfg:     cfg,
+		targets: make(map[string]*pushTarget),
+		removed: make(map[string]pushRemoval),
+		subs:    make(map[chan []*Target]struct{}),
+	}
+}
+
+// PushSource is a TargetSource implementation for targets which register
+// themselves over HTTP (see `ServeHTTP`), registrations expire unless they
+// are renewed within their TTL
+type PushSource struct {
+	cfg *PushConfig
+
+	l       sync.Mutex
+	targets map[string]*pushTarget
+	// removed holds why targets were removed by IP, until they register
+	// again or the `pushRemovalRetention` passes
+	removed map[string]pushRemoval
+	subs    map[chan []*Target]struct{}
+}
+
+// log returns the source's Logger
+func (s *PushSource) log() Logger {
+	return loggerOr(s.cfg.Logger)
+}
+
+type pushTarget struct {
+	target  *Target
+	expires time.Time
+}
+
+// pushRemovalRetention is how long the reason a target was removed is kept
+const pushRemovalRetention = 10 * time.Minute
+
+type pushRemoval struct {
+	reason RemovalReason
+	at     time.Time
+}
+
+// Register registers (or renews) the target for the TTL
+func (s *PushSource) Register(target *Target, ttl time.Duration) {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	now := time.Now()
+	key := target.Key()
+	existing, ok := s.targets[key]
+	delete(s.removed, target.IP)
+	s.targets[key] = &pushTarget{
+		target:  target,
+		expires: now.Add(ttl),
+	}
+	// Only changes to the targets are sent, renewals are not
+	if !ok || !existing.expires.After(now) || !reflect.DeepEqual(existing.target.Meta, target.Meta) {
+		s.log().Debugf("Target registered: %v", target)
+		s.broadcastLocked()
+	}
+}
+
+// Deregister removes the target
+func (s *PushSource) Deregister(target *Target) {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	if _, ok := s.targets[target.Key()]; ok {
+		s.log().Debugf("Target deregistered: %v", target)
+		delete(s.targets, target.Key())
+		s.removed[target.IP] = pushRemoval{reason: RemovalManual, at: time.Now()}
+		s.broadcastLocked()
+	}
+}
+
+// expire removes all targets whose registration has lapsed
+func (s *PushSource) expire() {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	now := time.Now()
+	expired := false
+	for key, t := range s.targets {
+		if !t.expires.After(now) {
+			s.log().Debugf("Target registration expired: %v", t.target)
+			delete(s.targets, key)
+			s.removed[t.target.IP] = pushRemoval{reason: RemovalExpired, at: now}
+			expired = true
+		}
+	}
+	for ip, removal := range s.removed {
+		if now.Sub(removal.at) > pushRemovalRetention {
+			delete(s.removed, ip)
+		}
+	}
+	if expired {
+		s.broadcastLocked()
+	}
+}
+
+// RemovalReason to implement the `RemovalReasonSource` interface, targets are
+// removed manually when deregistered or expire when not renewed
+func (s *PushSource) RemovalReason(ip string) RemovalReason {
+	s.l.Lock()
+	defer s.l.Unlock()
+	return s.removed[ip].reason
+}
+
+// targetsLocked returns the unexpired targets, sorted by key
+func (s *PushSource) targetsLocked() []*Target {
+	now := time.Now()
+	targets := make([]*Target, 0, len(s.targets))
+	for _, t := range s.targets {
+		if t.expires.After(now) {
+			targets = append(targets, t.target)
+		}
+	}
+	sort.Slice(targets, func(i, j int) bool {
+		return targets[i].Key() < targets[j].Key()
+	})
+	return targets
+}
+
+// broadcastLocked sends the current targets to all subscribers, replacing any
+// targets they haven't received yet as only the latest matter
+func (s *PushSource) broadcastLocked() {
+	targets := s.targetsLocked()
+	for sub := range s.subs {
+		select {
+		case <-sub:
+		default:
+		}
+		sub <- targets
+	}
+}
+
+// Subscribe sends the registered targets whenever they change
+func (s *PushSource) Subscribe(ctx context.Context) (chan []*Target, error) {
+	sub := make(chan []*Target, 1)
+	s.l.Lock()
+	sub <- s.targetsLocked()
+	s.subs[sub] = struct{}{}
+	s.l.Unlock()
+
+	go func() {
+		t := time.NewTicker(time.Second)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				s.l.Lock()
+				delete(s.subs, sub)
+				s.l.Unlock()
+				return
+			case <-t.C:
+				s.expire()
+			}
+		}
+	}()
+	return sub, nil
+}
+
+// ServeHTTP registers (POST) or deregisters (DELETE) the target in the
+// PushRegistration body
+func (s *PushSource) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	var reg PushRegistration
+	if err := json.NewDecoder(r.Body).Decode(&reg); err != nil {
+		http.Error(w, fmt.Sprintf("Error decoding registration: %v", err), http.StatusBadRequest)
+		return
+	}
+	if reg.IP == "" || reg.Port <= 0 || reg.Port > 65535 {
+		http.Error(w, "ip and port must be set", http.StatusBadRequest)
+		return
+	}
+	target := &Target{
+		IP:   reg.IP,
+		Port: reg.Port,
+		Meta: reg.Labels,
+	}
+
+	if r.Method == http.MethodDelete {
+		s.Deregister(target)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	ttl := s.cfg.DefaultTTL
+	if reg.TTL != "" {
+		var err error
+		ttl, err = time.ParseDuration(reg.TTL)
+		if err != nil || ttl <= 0 {
+			http.Error(w, fmt.Sprintf("Invalid ttl %q", reg.TTL), http.StatusBadRequest)
+			return
+		}
+	}
+	if s.cfg.MaxTTL > 0 && ttl > s.cfg.MaxTTL {
+		ttl = s.cfg.MaxTTL
+	}
+	s.Register(target, ttl)
+	w.WriteHeader(http.StatusNoContent)
+}
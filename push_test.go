@@ -0,0 +1,93 @@
+package targetsync
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPushSource(t *testing.T) {
+	s := NewPushSource(&PushConfig{DefaultTTL: time.Minute})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ch, err := s.Subscribe(ctx)
+	if err != nil {
+		t.Fatalf("Error subscribing: %v", err)
+	}
+	if targets := <-ch; len(targets) != 0 {
+		t.Fatalf("Expected no targets, got %v", targets)
+	}
+
+	send := func(method, body string) int {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(method, "/", bytes.NewBufferString(body)))
+		return w.Code
+	}
+
+	if code := send(http.MethodPost, `{"ip": "10.0.0.1"}`); code != http.StatusBadRequest {
+		t.Fatalf("Expected invalid registration to be rejected, got %d", code)
+	}
+	if code := send(http.MethodPost, `{"ip": "10.0.0.1", "port": 80, "ttl": "1s"}`); code != http.StatusNoContent {
+		t.Fatalf("Unexpected status registering: %d", code)
+	}
+	if targets := <-ch; len(targets) != 1 || targets[0].IP != "10.0.0.1" {
+		t.Fatalf("Expected registered target, got %v", targets)
+	}
+
+	// the registration expires without being renewed
+	select {
+	case targets := <-ch:
+		if len(targets) != 0 {
+			t.Fatalf("Expected target to expire, got %v", targets)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Registration didn't expire")
+	}
+	if reason := s.RemovalReason("10.0.0.1"); reason != RemovalExpired {
+		t.Fatalf("Expected expired removal reason, got %q", reason)
+	}
+
+	send(http.MethodPost, `{"ip": "10.0.0.2", "port": 80}`)
+	<-ch
+	if code := send(http.MethodDelete, `{"ip": "10.0.0.2", "port": 80}`); code != http.StatusNoContent {
+		t.Fatalf("Unexpected status deregistering: %d", code)
+	}
+	if targets := <-ch; len(targets) != 0 {
+		t.Fatalf("Expected target to be deregistered, got %v", targets)
+	}
+	if reason := s.RemovalReason("10.0.0.2"); reason != RemovalManual {
+		t.Fatalf("Expected manual removal reason, got %q", reason)
+	}
+}
+
+// recordingLogger is a Logger recording the messages logged
+type recordingLogger struct {
+	l        sync.Mutex
+	messages []string
+}
+
+func (r *recordingLogger) record(format string, args ...interface{}) {
+	r.l.Lock()
+	defer r.l.Unlock()
+	r.messages = append(r.messages, fmt.Sprintf(format, args...))
+}
+
+func (r *recordingLogger) Debugf(format string, args ...interface{}) { r.record(format, args...) }
+func (r *recordingLogger) Infof(format string, args ...interface{})  { r.record(format, args...) }
+func (r *recordingLogger) Warnf(format string, args ...interface{})  { r.record(format, args...) }
+func (r *recordingLogger) Errorf(format string, args ...interface{}) { r.record(format, args...) }
+
+func TestPushSourceLogger(t *testing.T) {
+	log := &recordingLogger{}
+	s := NewPushSource(&PushConfig{DefaultTTL: time.Minute, LoggerConfig: LoggerConfig{Logger: log}})
+	s.Register(&Target{IP: "10.0.0.1", Port: 80}, time.Minute)
+	if len(log.messages) != 1 {
+		t.Fatalf("Expected the registration to be logged to the source's Logger, got %v", log.messages)
+	}
+}
@@ -0,0 +1,170 @@
+package targetsync
+
+import (
+	"context"
+	"fmt"
+
+	consulApi "github.com/hashicorp/consul/api"
+)
+
+// LockBackendConfig is an additional backend the lock must also be held in,
+// see `PairConfig.LockQuorum`. Consul is the only backend type so far, the
+// quorum is across independent consul clusters rather than different
+// coordination systems.
+type LockBackendConfig struct {
+	// Consul locks in another (independent) consul cluster
+	Consul *ConsulLockConfig `yaml:"consul"`
+}
+
+// Validate checks the LockBackendConfig for errors
+func (c *LockBackendConfig) Validate() error {
+	if c.Consul == nil {
+		return fmt.Errorf("Lock quorum backends must set a backend (consul)")
+	}
+	return c.Consul.TLS.Validate()
+}
+
+// ConsulLockConfig is a consul cluster to lock in
+type ConsulLockConfig struct {
+	ClientConfig *consulApi.Config `yaml:"client"`
+	// TLS connects to consul over (mutual) TLS
+	TLS TLSConfig `yaml:"tls"`
+	// Namespace and admin Partition (consul enterprise) of the lock
+	Namespace string `yaml:"namespace"`
+	Partition string `yaml:"partition"`
+}
+
+// NewLockBackend returns the Locker of the backend
+func NewLockBackend(cfg *LockBackendConfig) (Locker, error) {
+	return NewConsulSource(&ConsulConfig{
+		ClientConfig: cfg.Consul.ClientConfig,
+		TLS:          cfg.Consul.TLS,
+		Namespace:    cfg.Consul.Namespace,
+		Partition:    cfg.Consul.Partition,
+	})
+}
+
+// NewQuorumLocker returns a Locker which is only held while all of the
+// lockers are. If the first locker is a FencingLocker, so is the returned
+// Locker, with its fencing tokens.
+func NewQuorumLocker(lockers []Locker) Locker {
+	l := &QuorumLocker{lockers: lockers}
+	if fencing, ok := lockers[0].(FencingLocker); ok {
+		return &fencingQuorumLocker{QuorumLocker: l, fencing: fencing}
+	}
+	return l
+}
+
+// QuorumLocker is a Locker requiring the lock to be held in multiple
+// independent backends (e.g. consul clusters in different regions) before
+// declaring leadership, so a single backend's failure (e.g. a partitioned
+// consul cluster electing another leader) can't cause split-brain. The locks
+// are acquired in order, so contending processes can't deadlock each holding
+// some of them, and if any is lost all are released and acquired again.
+type QuorumLocker struct {
+	lockers []Locker
+}
+
+// quorumUpdate is an update from the Lock channel of the locker at the index
+type quorumUpdate struct {
+	i      int
+	held   bool
+	closed bool
+}
+
+// Lock to implement the Locker interface, the channel is closed once the
+// context is done or any of the lockers' channels close
+func (l *QuorumLocker) Lock(ctx context.Context, opts *LockOptions) (<-chan bool, error) {
+	lockedCh := make(chan bool, 1)
+	go func() {
+		defer close(lockedCh)
+		defer lockQuorumHeld.WithLabelValues(opts.name()).Set(0)
+		for l.acquire(ctx, opts, lockedCh) {
+			logger.Infof("Lock quorum %s lost, releasing all of its locks", opts.Key)
+		}
+	}()
+	return lockedCh, nil
+}
+
+// acquire acquires the locks in order, sending true once all are held. If any
+// lock is then lost it sends false and releases all of them, returning
+// whether to acquire them again.
+func (l *QuorumLocker) acquire(ctx context.Context, opts *LockOptions, lockedCh chan<- bool) bool {
+	roundCtx, cancel := context.WithCancel(ctx)
+	// Cancelling releases the locks
+	defer cancel()
+
+	updates := make(chan quorumUpdate)
+	start := func(i int) bool {
+		ch, err := l.lockers[i].Lock(roundCtx, opts)
+		if err != nil {
+			logger.Errorf("Error locking %s in lock quorum backend %d: %v", opts.Key, i, err)
+			return false
+		}
+		go func() {
+			for held := range ch {
+				select {
+				case updates <- quorumUpdate{i: i, held: held}:
+				case <-roundCtx.Done():
+					return
+				}
+			}
+			select {
+			case updates <- quorumUpdate{i: i, closed: true}:
+			case <-roundCtx.Done():
+			}
+		}()
+		return true
+	}
+	if !start(0) {
+		return false
+	}
+
+	held := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case update := <-updates:
+			if update.closed {
+				logger.Errorf("Lock quorum backend %d stopped locking %s", update.i, opts.Key)
+				if held == len(l.lockers) {
+					lockedCh <- false
+				}
+				return false
+			}
+			// Only the last lock acquired can be newly held
+			if update.held && update.i == held {
+				held++
+				lockQuorumHeld.WithLabelValues(opts.name()).Set(float64(held))
+				logger.Infof("Holding %d/%d locks of lock quorum %s", held, len(l.lockers), opts.Key)
+				if held == len(l.lockers) {
+					lockedCh <- true
+				} else if !start(held) {
+					return false
+				}
+				continue
+			}
+			if !update.held && update.i < held {
+				if held == len(l.lockers) {
+					lockedCh <- false
+				}
+				lockQuorumHeld.WithLabelValues(opts.name()).Set(0)
+				return true
+			}
+		}
+	}
+}
+
+// fencingQuorumLocker is a QuorumLocker whose first locker can provide
+// fencing tokens
+type fencingQuorumLocker struct {
+	*QuorumLocker
+	fencing FencingLocker
+}
+
+// FencingToken to implement the `FencingLocker` interface, returning the
+// token of the first locker
+func (l *fencingQuorumLocker) FencingToken(ctx context.Context, opts *LockOptions) (uint64, error) {
+	return l.fencing.FencingToken(ctx, opts)
+}
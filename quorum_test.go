@@ -0,0 +1,132 @@
+package targetsync
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testLocker is a Locker whose lock is held and lost by the test, counting
+// the Lock calls and whether the latest is still running
+type testLocker struct {
+	l       sync.Mutex
+	ch      chan bool
+	calls   int
+	running bool
+}
+
+func newTestLocker() *testLocker {
+	return &testLocker{}
+}
+
+func (m *testLocker) Lock(ctx context.Context, opts *LockOptions) (<-chan bool, error) {
+	ch := make(chan bool, 1)
+	m.l.Lock()
+	m.ch = ch
+	m.calls++
+	m.running = true
+	m.l.Unlock()
+	go func() {
+		<-ctx.Done()
+		m.l.Lock()
+		if m.ch == ch {
+			m.running = false
+		}
+		m.l.Unlock()
+	}()
+	return ch, nil
+}
+
+func (m *testLocker) send(held bool) {
+	m.l.Lock()
+	ch := m.ch
+	m.l.Unlock()
+	ch <- held
+}
+
+func (m *testLocker) state() (calls int, running bool) {
+	m.l.Lock()
+	defer m.l.Unlock()
+	return m.calls, m.running
+}
+
+func waitFor(t *testing.T, msg string, f func() bool) {
+	for start := time.Now(); !f(); time.Sleep(time.Millisecond) {
+		if time.Since(start) > time.Second {
+			t.Fatalf("Timed out waiting for %s", msg)
+		}
+	}
+}
+
+func TestQuorumLocker(t *testing.T) {
+	a, b := newTestLocker(), newTestLocker()
+	locker := NewQuorumLocker([]Locker{a, b})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ch, err := locker.Lock(ctx, &LockOptions{Key: "key"})
+	if err != nil {
+		t.Fatalf("Error locking: %v", err)
+	}
+	expectNone := func() {
+		select {
+		case held := <-ch:
+			t.Fatalf("Unexpected lock update: %v", held)
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+	expect := func(expected bool) {
+		select {
+		case held := <-ch:
+			if held != expected {
+				t.Fatalf("Expected lock held=%v", expected)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Timed out waiting for lock held=%v", expected)
+		}
+	}
+
+	// The second lock is only contended for once the first is held
+	waitFor(t, "the first lock", func() bool { _, running := a.state(); return running })
+	if calls, _ := b.state(); calls != 0 {
+		t.Fatalf("Second lock contended for before the first was held")
+	}
+	a.send(true)
+	waitFor(t, "the second lock", func() bool { _, running := b.state(); return running })
+	expectNone()
+	b.send(true)
+	expect(true)
+
+	// Losing either lock releases both, and they are acquired again in order
+	b.send(false)
+	expect(false)
+	waitFor(t, "the locks to be acquired again", func() bool {
+		aCalls, aRunning := a.state()
+		_, bRunning := b.state()
+		return aCalls == 2 && aRunning && !bRunning
+	})
+	a.send(true)
+	waitFor(t, "the second lock again", func() bool { calls, running := b.state(); return calls == 2 && running })
+	b.send(true)
+	expect(true)
+
+	cancel()
+	select {
+	case _, ok := <-ch:
+		if ok {
+			t.Fatalf("Expected the lock channel to close")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out waiting for the lock channel to close")
+	}
+}
+
+func TestQuorumLockerFencing(t *testing.T) {
+	if _, ok := NewQuorumLocker([]Locker{newTestLocker()}).(FencingLocker); ok {
+		t.Fatalf("Quorum of lockers without fencing shouldn't be a FencingLocker")
+	}
+	if _, ok := NewQuorumLocker([]Locker{&ConsulSource{}, newTestLocker()}).(FencingLocker); !ok {
+		t.Fatalf("Expected a FencingLocker for a quorum led by a FencingLocker")
+	}
+}
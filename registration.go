@@ -0,0 +1,162 @@
+package targetsync
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	consulApi "github.com/hashicorp/consul/api"
+)
+
+// defaultRegistrationTTL is the TTL of the registration's health check if
+// `ConsulRegistration.TTL` isn't set
+const defaultRegistrationTTL = 30 * time.Second
+
+// ConsulRegistrationConfig configures registering targetsync itself as a
+// consul service, so the syncers can be discovered and monitored through the
+// catalog. The service has a TTL check which passes while all of the syncers
+// are healthy.
+type ConsulRegistrationConfig struct {
+	Enabled      bool              `yaml:"enabled"`
+	ClientConfig *consulApi.Config `yaml:"client"`
+	// TLS connects to consul over (mutual) TLS
+	TLS       TLSConfig `yaml:"tls"`
+	Namespace string    `yaml:"namespace"`
+	Partition string    `yaml:"partition"`
+	// ServiceName defaults to targetsync
+	ServiceName string `yaml:"service_name"`
+	// ServiceID defaults to `<service_name>-<hostname>`
+	ServiceID string   `yaml:"service_id"`
+	Tags      []string `yaml:"tags"`
+	// Address and Port to register, e.g. of the admin API. The address
+	// defaults to the agent's
+	Address string `yaml:"address"`
+	Port    int    `yaml:"port"`
+	// TTL of the health check, which is updated every TTL/2. Defaults to 30s
+	TTL time.Duration `yaml:"ttl"`
+	// DeregisterAfter deregisters the service once its check has been
+	// critical for this long (e.g. targetsync was killed), 0 never does
+	DeregisterAfter time.Duration `yaml:"deregister_after"`
+}
+
+// Validate checks the ConsulRegistrationConfig for errors
+func (c *ConsulRegistrationConfig) Validate() error {
+	if c.TTL < 0 || c.DeregisterAfter < 0 {
+		return fmt.Errorf("Consul registration ttl and deregister_after must be >=0")
+	}
+	if c.Port < 0 || c.Port > 65535 {
+		return fmt.Errorf("Invalid consul registration port %d", c.Port)
+	}
+	return c.TLS.Validate()
+}
+
+// ttl returns the TTL of the health check
+func (c *ConsulRegistrationConfig) ttl() time.Duration {
+	if c.TTL <= 0 {
+		return defaultRegistrationTTL
+	}
+	return c.TTL
+}
+
+// registration returns the service registration
+func (c *ConsulRegistrationConfig) registration() (*consulApi.AgentServiceRegistration, error) {
+	name := c.ServiceName
+	if name == "" {
+		name = "targetsync"
+	}
+	id := c.ServiceID
+	if id == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("Unable to determine hostname for service id: %v", err)
+		}
+		id = name + "-" + hostname
+	}
+	check := &consulApi.AgentServiceCheck{
+		TTL: c.ttl().String(),
+	}
+	if c.DeregisterAfter > 0 {
+		check.DeregisterCriticalServiceAfter = c.DeregisterAfter.String()
+	}
+	return &consulApi.AgentServiceRegistration{
+		ID:      id,
+		Name:    name,
+		Tags:    c.Tags,
+		Address: c.Address,
+		Port:    c.Port,
+		Check:   check,
+	}, nil
+}
+
+// syncersHealth returns the consul health status of the syncers, and the check
+// output describing it. The check is critical if any syncer's loops are stuck,
+// and warning if any isn't ready (e.g. its source is unhealthy).
+func syncersHealth(syncers []*Syncer, maxAge time.Duration) (string, string) {
+	var dead, notReady []string
+	leading := 0
+	for _, syncer := range syncers {
+		status := syncer.Status()
+		if !syncer.Alive(maxAge) {
+			dead = append(dead, status.Name)
+		} else if !status.IsReady() {
+			notReady = append(notReady, status.Name)
+		}
+		if status.Leader {
+			leading++
+		}
+	}
+	switch {
+	case len(dead) > 0:
+		return consulApi.HealthCritical, fmt.Sprintf("Syncers not alive: %s", strings.Join(dead, ", "))
+	case len(notReady) > 0:
+		return consulApi.HealthWarning, fmt.Sprintf("Syncers not ready: %s", strings.Join(notReady, ", "))
+	default:
+		return consulApi.HealthPassing, fmt.Sprintf("%d syncers healthy, leading %d", len(syncers), leading)
+	}
+}
+
+// RunConsulRegistration registers targetsync as a consul service, and updates
+// its health check from the health of the syncers until the context is done,
+// when it is deregistered
+func RunConsulRegistration(ctx context.Context, cfg *ConsulRegistrationConfig, syncers []*Syncer) error {
+	client, err := consulClient(cfg.ClientConfig, &cfg.TLS, cfg.Namespace, cfg.Partition)
+	if err != nil {
+		return err
+	}
+	agent := client.Agent()
+	reg, err := cfg.registration()
+	if err != nil {
+		return err
+	}
+	if err := agent.ServiceRegister(reg); err != nil {
+		return fmt.Errorf("Error registering consul service: %v", err)
+	}
+	logger.Infof("Registered consul service %s as %s", reg.Name, reg.ID)
+	defer func() {
+		if err := agent.ServiceDeregister(reg.ID); err != nil {
+			logger.Warnf("Error deregistering consul service %s: %v", reg.ID, err)
+		}
+	}()
+
+	checkID := "service:" + reg.ID
+	ttl := cfg.ttl()
+	ticker := time.NewTicker(ttl / 2)
+	defer ticker.Stop()
+	for {
+		status, output := syncersHealth(syncers, ttl)
+		if err := agent.UpdateTTL(checkID, output, status); err != nil {
+			// The agent may have lost the registration (e.g. it restarted)
+			logger.Warnf("Error updating consul service check, registering again: %v", err)
+			if err := agent.ServiceRegister(reg); err != nil {
+				logger.Errorf("Error registering consul service: %v", err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
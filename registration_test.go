@@ -0,0 +1,38 @@
+package targetsync
+
+import (
+	"testing"
+	"time"
+
+	consulApi "github.com/hashicorp/consul/api"
+)
+
+func TestSyncersHealth(t *testing.T) {
+	newSyncer := func(name string) *Syncer {
+		s := &Syncer{
+			Name:    name,
+			Config:  &SyncConfig{LockOptions: LockOptions{Key: name}},
+			Started: true,
+		}
+		s.beat(false)
+		return s
+	}
+	a, b := newSyncer("a"), newSyncer("b")
+	syncers := []*Syncer{a, b}
+
+	if status, output := syncersHealth(syncers, time.Minute); status != consulApi.HealthPassing {
+		t.Fatalf("Expected passing, got %s: %s", status, output)
+	}
+
+	b.Started = false
+	if status, output := syncersHealth(syncers, time.Minute); status != consulApi.HealthWarning || output != "Syncers not ready: b" {
+		t.Fatalf("Expected warning, got %s: %s", status, output)
+	}
+
+	a.statusLock.Lock()
+	a.runHeartbeat = time.Now().Add(-time.Hour)
+	a.statusLock.Unlock()
+	if status, output := syncersHealth(syncers, time.Minute); status != consulApi.HealthCritical || output != "Syncers not alive: a" {
+		t.Fatalf("Expected critical, got %s: %s", status, output)
+	}
+}
@@ -0,0 +1,157 @@
+package targetsync
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// RegistrationRefreshConfig configures re-registering targets in destinations
+// whose registrations can expire (e.g. DNS records or service registrations
+// with a TTL)
+type RegistrationRefreshConfig struct {
+	// MaxAge re-registers (adds again) the targets registered longer ago on
+	// full syncs, 0 never refreshes them
+	MaxAge time.Duration `yaml:"max_age"`
+}
+
+// Validate checks the RegistrationRefreshConfig for errors
+func (c *RegistrationRefreshConfig) Validate() error {
+	if c.MaxAge < 0 {
+		return fmt.Errorf("Registration refresh max_age must be >=0")
+	}
+	return nil
+}
+
+// TargetRegistration is when a target in the destination was added and last
+// seen by targetsync
+type TargetRegistration struct {
+	// Added is when targetsync added the target, or first saw it in the
+	// destination if it didn't
+	Added time.Time `json:"added"`
+	// Registered is when targetsync last added (or refreshed) the target,
+	// zero if it hasn't
+	Registered time.Time `json:"registered,omitempty"`
+	// LastSeen is when the target was last seen in the destination on a full
+	// sync, zero if it hasn't been since being added
+	LastSeen time.Time `json:"last_seen,omitempty"`
+}
+
+// registeredAt returns when the target was last registered, as far as we
+// know
+func (r *TargetRegistration) registeredAt() time.Time {
+	if !r.Registered.IsZero() {
+		return r.Registered
+	}
+	return r.Added
+}
+
+// recordAdded records the targets as added (or refreshed) now
+func (s *Syncer) recordAdded(targets []*Target) {
+	now := time.Now()
+	s.statusLock.Lock()
+	defer s.statusLock.Unlock()
+	if s.registrations == nil {
+		s.registrations = make(map[string]*TargetRegistration)
+	}
+	for _, target := range targets {
+		reg, ok := s.registrations[target.Key()]
+		if !ok {
+			reg = &TargetRegistration{Added: now}
+			s.registrations[target.Key()] = reg
+		}
+		reg.Registered = now
+	}
+}
+
+// recordRemoved forgets the registrations of the removed targets
+func (s *Syncer) recordRemoved(targets []*Target) {
+	s.statusLock.Lock()
+	defer s.statusLock.Unlock()
+	for _, target := range targets {
+		delete(s.registrations, target.Key())
+	}
+}
+
+// recordSeen records the targets fetched from the destination as seen now,
+// forgetting the registrations of any other targets. Called with the
+// statusLock held.
+func (s *Syncer) recordSeen(targets []*Target) {
+	now := time.Now()
+	registrations := make(map[string]*TargetRegistration, len(targets))
+	for _, target := range targets {
+		reg, ok := s.registrations[target.Key()]
+		if !ok {
+			reg = &TargetRegistration{Added: now}
+		}
+		reg.LastSeen = now
+		registrations[target.Key()] = reg
+	}
+	s.registrations = registrations
+}
+
+// staleRegistrations returns the targets last registered longer than the
+// `RegistrationRefresh.MaxAge` ago
+func (s *Syncer) staleRegistrations(targets []*Target) []*Target {
+	maxAge := s.Config.RegistrationRefresh.MaxAge
+	if maxAge <= 0 {
+		return nil
+	}
+	now := time.Now()
+	s.statusLock.Lock()
+	defer s.statusLock.Unlock()
+	var stale []*Target
+	for _, target := range targets {
+		if reg, ok := s.registrations[target.Key()]; ok && now.Sub(reg.registeredAt()) > maxAge {
+			stale = append(stale, target)
+		}
+	}
+	return stale
+}
+
+// refreshRegistrations adds the targets to the destination again, so their
+// registrations don't expire. Refreshes don't change the membership, so
+// aren't counted against the mutation budget or reported as added.
+func (s *Syncer) refreshRegistrations(ctx context.Context, targets []*Target) error {
+	return s.runJob(ctx, func() error {
+		if err := s.verifyFencingToken(ctx); err != nil {
+			return err
+		}
+		if err := s.callDestination(ctx, "refresh_targets", func(ctx context.Context) error {
+			return s.Dst.AddTargets(ctx, targets)
+		}); err != nil {
+			return err
+		}
+		s.log().Infof("Refreshed the registrations of %d targets older than %v: %s", len(targets), s.Config.RegistrationRefresh.MaxAge, summarizeTargets(targets))
+		registrationRefreshesTotal.WithLabelValues(s.name()).Add(float64(len(targets)))
+		s.recordAdded(targets)
+		return nil
+	})
+}
+
+// observeTargetAges sets the ages of the oldest and newest targets in the
+// destination, which are only known while leader
+func (s *Syncer) observeTargetAges() {
+	s.statusLock.Lock()
+	defer s.statusLock.Unlock()
+	var oldest, newest time.Time
+	if s.status.Leader {
+		for _, reg := range s.registrations {
+			if oldest.IsZero() || reg.Added.Before(oldest) {
+				oldest = reg.Added
+			}
+			if newest.IsZero() || reg.Added.After(newest) {
+				newest = reg.Added
+			}
+		}
+	}
+	name := s.name()
+	if oldest.IsZero() {
+		destinationTargetAge.WithLabelValues(name, "oldest").Set(0)
+		destinationTargetAge.WithLabelValues(name, "newest").Set(0)
+		return
+	}
+	now := time.Now()
+	destinationTargetAge.WithLabelValues(name, "oldest").Set(now.Sub(oldest).Seconds())
+	destinationTargetAge.WithLabelValues(name, "newest").Set(now.Sub(newest).Seconds())
+}
@@ -0,0 +1,76 @@
+package targetsync
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTargetRegistrations(t *testing.T) {
+	dst := newmockDestination()
+	syncer := &Syncer{
+		Config: &SyncConfig{
+			LockOptions:         LockOptions{Key: "a"},
+			RegistrationRefresh: RegistrationRefreshConfig{MaxAge: time.Hour},
+		},
+		Dst: dst,
+	}
+	ctx := context.Background()
+
+	added := []*Target{{IP: "10.0.0.1", Port: 80}}
+	if err := syncer.addTargets(ctx, added); err != nil {
+		t.Fatalf("Error adding targets: %v", err)
+	}
+	existing := &Target{IP: "10.0.0.2", Port: 80}
+	dst.AddTargets(ctx, []*Target{existing})
+	dstTargets, _ := dst.GetTargets(ctx)
+	syncer.observeDestination(dstTargets)
+
+	registrations := syncer.Status().Registrations
+	if len(registrations) != 2 {
+		t.Fatalf("Expected 2 registrations, got %v", registrations)
+	}
+	reg := registrations[added[0].Key()]
+	if reg.Registered.IsZero() || reg.LastSeen.IsZero() || reg.Added != reg.Registered {
+		t.Fatalf("Unexpected registration of an added target: %+v", reg)
+	}
+	if reg := registrations[existing.Key()]; !reg.Registered.IsZero() || reg.Added != reg.LastSeen {
+		t.Fatalf("Unexpected registration of an existing target: %+v", reg)
+	}
+
+	// Only registrations older than the max age are refreshed
+	targets := append(added, existing)
+	if stale := syncer.staleRegistrations(targets); len(stale) != 0 {
+		t.Fatalf("Unexpected stale registrations: %v", stale)
+	}
+	syncer.statusLock.Lock()
+	syncer.registrations[existing.Key()].Added = time.Now().Add(-2 * time.Hour)
+	syncer.statusLock.Unlock()
+	stale := syncer.staleRegistrations(targets)
+	if err := equalTargets(stale, []*Target{existing}); err != nil {
+		t.Fatalf("Unexpected stale registrations: %v", err)
+	}
+	if err := syncer.refreshRegistrations(ctx, stale); err != nil {
+		t.Fatalf("Error refreshing registrations: %v", err)
+	}
+	if stale := syncer.staleRegistrations(targets); len(stale) != 0 {
+		t.Fatalf("Registrations not refreshed: %v", stale)
+	}
+	if reg := syncer.Status().Registrations[existing.Key()]; time.Since(reg.Added) < time.Hour {
+		t.Fatalf("Refreshing changed when the target was added: %+v", reg)
+	}
+
+	if err := syncer.removeTargets(ctx, added); err != nil {
+		t.Fatalf("Error removing targets: %v", err)
+	}
+	if _, ok := syncer.Status().Registrations[added[0].Key()]; ok {
+		t.Fatalf("Registration of a removed target kept")
+	}
+
+	// Registrations aren't kept once leadership is lost
+	syncer.setState(SyncerStateLeader)
+	syncer.setState(SyncerStateFollower)
+	if registrations := syncer.Status().Registrations; len(registrations) != 0 {
+		t.Fatalf("Registrations kept after leadership was lost: %v", registrations)
+	}
+}
@@ -0,0 +1,79 @@
+package targetsync
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// RemovalReason is why a target is removed from the destination, it is
+// carried with the target through the removal queue as its
+// `MetaRemovalReason` and reported in the removal events
+type RemovalReason string
+
+const (
+	// RemovalAbsentFromSource is a target which is no longer in the source
+	RemovalAbsentFromSource RemovalReason = "absent_from_source"
+	// RemovalExpired is a target the source didn't send again within its
+	// TTL (see `MetaTTL`), or whose registration lapsed
+	RemovalExpired RemovalReason = "expired"
+	// RemovalManual is a target removed by an operator, e.g. deregistered
+	// with the push API
+	RemovalManual RemovalReason = "manual"
+	// RemovalUnknown is a target queued for removal without a reason
+	RemovalUnknown RemovalReason = "unknown"
+)
+
+// RemovalReasonSource is implemented by sources which know why a target
+// stopped being sent (e.g. deregistered vs expired), targets are otherwise
+// removed as absent from the source
+type RemovalReasonSource interface {
+	// RemovalReason returns why the target with the IP was removed, or ""
+	// if it isn't known
+	RemovalReason(ip string) RemovalReason
+}
+
+// withRemovalReason returns a copy of the target with the removal reason set
+func withRemovalReason(target *Target, reason RemovalReason) *Target {
+	t := *target
+	t.Meta = make(map[string]string, len(target.Meta)+1)
+	for k, v := range target.Meta {
+		t.Meta[k] = v
+	}
+	t.Meta[MetaRemovalReason] = string(reason)
+	return &t
+}
+
+// removalReason returns the reason the target is being removed
+func removalReason(target *Target) RemovalReason {
+	if reason := target.Meta[MetaRemovalReason]; reason != "" {
+		return RemovalReason(reason)
+	}
+	return RemovalUnknown
+}
+
+// absentReason returns the reason for removing a target which is no longer
+// in the source, from the source if it knows
+func (s *Syncer) absentReason(target *Target) RemovalReason {
+	if src, ok := s.Src.(RemovalReasonSource); ok {
+		if reason := src.RemovalReason(target.IP); reason != "" {
+			return reason
+		}
+	}
+	return RemovalAbsentFromSource
+}
+
+// summarizeReasons returns the number of targets removed for each reason,
+// e.g. `absent_from_source=2 expired=1`
+func summarizeReasons(targets []*Target) string {
+	counts := make(map[RemovalReason]int)
+	for _, target := range targets {
+		counts[removalReason(target)]++
+	}
+	reasons := make([]string, 0, len(counts))
+	for reason, count := range counts {
+		reasons = append(reasons, fmt.Sprintf("%s=%d", reason, count))
+	}
+	sort.Strings(reasons)
+	return strings.Join(reasons, " ")
+}
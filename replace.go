@@ -0,0 +1,85 @@
+package targetsync
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// healthStateHealthy is the destination health state new targets must reach
+// before the targets they replace are removed
+const healthStateHealthy = "healthy"
+
+// defaultReplacePollInterval is how often the destination is polled for the
+// health of new targets if `Replace.PollInterval` isn't set
+const defaultReplacePollInterval = 5 * time.Second
+
+// ReplaceConfig configures holding removals until the targets added in the
+// same sync are healthy, so capacity is kept during rolling replacements
+type ReplaceConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Timeout is the max time to hold the removals, after which they are
+	// made regardless of the new targets' health
+	Timeout time.Duration `yaml:"timeout"`
+	// PollInterval is how often the destination is polled for the health of
+	// the new targets, defaults to 5s
+	PollInterval time.Duration `yaml:"poll_interval"`
+}
+
+// Validate checks the ReplaceConfig for errors
+func (c *ReplaceConfig) Validate() error {
+	if c.Enabled && c.Timeout <= 0 {
+		return fmt.Errorf("Replace timeout must be >0")
+	}
+	return nil
+}
+
+// waitReplacements waits (up to `Replace.Timeout`) for the added targets to
+// be healthy in the destination before the removed targets they replace are
+// removed. Destinations which don't report health are always healthy.
+func (s *Syncer) waitReplacements(ctx context.Context, added, removed []*Target, state *leaderState) error {
+	cfg := s.Config.Replace
+	if !cfg.Enabled || len(added) == 0 || len(removed) == 0 {
+		return nil
+	}
+
+	// Targets from aborted rollouts were never added, so won't go healthy
+	pending := make(map[string]struct{}, len(added))
+	for _, target := range added {
+		if _, ok := state.aborted[target.Key()]; !ok {
+			pending[target.Key()] = struct{}{}
+		}
+	}
+	interval := cfg.PollInterval
+	if interval <= 0 {
+		interval = defaultReplacePollInterval
+	}
+
+	s.log().Infof("Holding removal of %d targets until %d replacements are healthy", len(removed), len(pending))
+	deadline := time.Now().Add(cfg.Timeout)
+	for len(pending) > 0 {
+		dstTargets, err := s.getTargets(ctx)
+		if err != nil {
+			return err
+		}
+		for _, target := range dstTargets {
+			if target.Health == nil || target.Health.State == healthStateHealthy {
+				delete(pending, target.Key())
+			}
+		}
+		if len(pending) == 0 {
+			break
+		}
+		if !time.Now().Before(deadline) {
+			s.log().Warnf("%d replacement targets not healthy after %v, removing %d targets anyway", len(pending), cfg.Timeout, len(removed))
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+	s.log().Debugf("Replacement targets healthy, removing %d targets", len(removed))
+	return nil
+}
@@ -0,0 +1,229 @@
+package targetsync
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// RecordConfig configures recording the updates from a pair's source, to
+// replay them later with the replay source
+type RecordConfig struct {
+	// Path of the file the updates are appended to, recording is disabled if
+	// unset
+	Path string `yaml:"path"`
+}
+
+// ReplaySourceConfig holds the configuration for the replay source, which
+// replays recorded source updates to reproduce incidents offline (e.g. with a
+// fake destination, to tune the dampening)
+type ReplaySourceConfig struct {
+	// Path of a file recorded with `record`, the replay source is disabled
+	// if unset
+	Path string `yaml:"path"`
+	// Speed the updates are replayed at relative to how they were recorded,
+	// e.g. 10 replays an hour of updates in 6 minutes. Defaults to 1
+	Speed float64 `yaml:"speed"`
+	// Loop replays the updates again from the start once done
+	Loop bool `yaml:"loop"`
+
+	LoggerConfig `yaml:",inline"`
+}
+
+// Enabled returns whether the replay source is configured
+func (c *ReplaySourceConfig) Enabled() bool {
+	return c.Path != ""
+}
+
+// Validate checks the ReplaySourceConfig for errors
+func (c *ReplaySourceConfig) Validate() error {
+	if c.Speed < 0 {
+		return fmt.Errorf("Replay source speed must be >=0")
+	}
+	return nil
+}
+
+// RecordedUpdate is a single update from a source, the recordings are a JSON
+// encoded update per line
+type RecordedUpdate struct {
+	Time    time.Time `json:"time"`
+	Targets []*Target `json:"targets"`
+}
+
+// NewRecordingSource returns a source recording every update from `src` to
+// the file at the path, appending to it if it exists
+func NewRecordingSource(src TargetSource, path string) (*RecordingSource, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("Error opening recording %s: %v", path, err)
+	}
+	return &RecordingSource{TargetSource: src, f: f}, nil
+}
+
+// RecordingSource is a TargetSource which records the updates of the source
+// it wraps as they are received, for replaying them with a ReplaySource
+type RecordingSource struct {
+	TargetSource
+
+	l sync.Mutex
+	f *os.File
+}
+
+// Healthy to implement the `HealthChecker` interface, passing through the
+// health of the recorded source
+func (s *RecordingSource) Healthy() error {
+	if checker, ok := s.TargetSource.(HealthChecker); ok {
+		return checker.Healthy()
+	}
+	return nil
+}
+
+// RemovalReason to implement the `RemovalReasonSource` interface, passing
+// through the reasons of the recorded source
+func (s *RecordingSource) RemovalReason(ip string) RemovalReason {
+	if src, ok := s.TargetSource.(RemovalReasonSource); ok {
+		return src.RemovalReason(ip)
+	}
+	return ""
+}
+
+// Subscribe subscribes to the recorded source, recording each update before
+// passing it on
+func (s *RecordingSource) Subscribe(ctx context.Context) (chan []*Target, error) {
+	srcCh, err := s.TargetSource.Subscribe(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ch := make(chan []*Target, cap(srcCh))
+	go func() {
+		defer close(ch)
+		for targets := range srcCh {
+			if err := s.record(targets); err != nil {
+				logger.Errorf("Error recording source update: %v", err)
+			}
+			select {
+			case ch <- targets:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// record appends the update to the recording
+func (s *RecordingSource) record(targets []*Target) error {
+	b, err := json.Marshal(&RecordedUpdate{Time: time.Now(), Targets: targets})
+	if err != nil {
+		return err
+	}
+	s.l.Lock()
+	defer s.l.Unlock()
+	_, err = s.f.Write(append(b, '\n'))
+	return err
+}
+
+// ReadRecording returns the updates of a recording
+func ReadRecording(path string) ([]*RecordedUpdate, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var updates []*RecordedUpdate
+	scanner := bufio.NewScanner(f)
+	// Updates of large services don't fit the default max line length
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		update := &RecordedUpdate{}
+		if err := json.Unmarshal(scanner.Bytes(), update); err != nil {
+			return nil, fmt.Errorf("Error decoding line %d of %s: %v", line, path, err)
+		}
+		updates = append(updates, update)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return updates, nil
+}
+
+// NewReplaySource returns a new source replaying the recorded updates
+func NewReplaySource(cfg *ReplaySourceConfig) (*ReplaySource, error) {
+	updates, err := ReadRecording(cfg.Path)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading recording %s: %v", cfg.Path, err)
+	}
+	if len(updates) == 0 {
+		return nil, fmt.Errorf("Recording %s has no updates", cfg.Path)
+	}
+	return &ReplaySource{cfg: cfg, updates: updates}, nil
+}
+
+// ReplaySource is a TargetSource and Locker implementation which sends the
+// recorded updates with the same time between them (scaled by `Speed`). Once
+// done the last update's targets are kept, unless looping. It always holds the
+// lock.
+type ReplaySource struct {
+	cfg     *ReplaySourceConfig
+	updates []*RecordedUpdate
+}
+
+// log returns the source's Logger
+func (s *ReplaySource) log() Logger {
+	return loggerOr(s.cfg.Logger)
+}
+
+// Subscribe replays the updates
+func (s *ReplaySource) Subscribe(ctx context.Context) (chan []*Target, error) {
+	speed := s.cfg.Speed
+	if speed <= 0 {
+		speed = 1
+	}
+
+	ch := make(chan []*Target, 1)
+	go func() {
+		defer close(ch)
+		for {
+			for i, update := range s.updates {
+				if i > 0 {
+					delay := time.Duration(float64(update.Time.Sub(s.updates[i-1].Time)) / speed)
+					if delay > 0 {
+						select {
+						case <-time.After(delay):
+						case <-ctx.Done():
+							return
+						}
+					}
+				}
+				select {
+				case ch <- update.Targets:
+				case <-ctx.Done():
+					return
+				}
+			}
+			if !s.cfg.Loop {
+				break
+			}
+			s.log().Infof("Replay of %s done, replaying again", s.cfg.Path)
+		}
+		s.log().Infof("Replay of %s done", s.cfg.Path)
+		// The subscription is kept open so the syncer doesn't stop
+		<-ctx.Done()
+	}()
+	return ch, nil
+}
+
+// Lock always acquires the lock immediately
+func (s *ReplaySource) Lock(ctx context.Context, opts *LockOptions) (<-chan bool, error) {
+	ch := make(chan bool, 1)
+	ch <- true
+	return ch, nil
+}
@@ -0,0 +1,83 @@
+package targetsync
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "targetsync")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "recording")
+
+	updates := [][]*Target{
+		{{IP: "10.0.0.1", Port: 80, Meta: map[string]string{"role": "web"}}},
+		{{IP: "10.0.0.1", Port: 80}, {IP: "10.0.0.2", Port: 80}},
+		{},
+	}
+
+	src := newmockSource()
+	recorder, err := NewRecordingSource(src, path)
+	if err != nil {
+		t.Fatalf("Error creating recording source: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := recorder.Subscribe(ctx)
+	if err != nil {
+		t.Fatalf("Error subscribing: %v", err)
+	}
+	for _, update := range updates {
+		src.ch <- update
+		<-ch
+	}
+
+	recorded, err := ReadRecording(path)
+	if err != nil {
+		t.Fatalf("Error reading recording: %v", err)
+	}
+	if len(recorded) != len(updates) {
+		t.Fatalf("Expected %d recorded updates, got %d", len(updates), len(recorded))
+	}
+	if recorded[0].Targets[0].Meta["role"] != "web" {
+		t.Fatalf("Target meta not recorded: %v", recorded[0].Targets[0])
+	}
+
+	// Space the updates out, a second apart
+	start := time.Now()
+	for i, update := range recorded {
+		update.Time = start.Add(time.Duration(i) * time.Second)
+	}
+	replay := &ReplaySource{cfg: &ReplaySourceConfig{Path: path, Speed: 100}, updates: recorded}
+	ch, err = replay.Subscribe(ctx)
+	if err != nil {
+		t.Fatalf("Error subscribing to replay: %v", err)
+	}
+	for i, update := range updates {
+		select {
+		case targets := <-ch:
+			if err := equalTargets(targets, update); err != nil {
+				t.Fatalf("Unexpected targets in update %d: %v", i, err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Timed out waiting for update %d", i)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Fatalf("Updates replayed without the time between them: %v", elapsed)
+	}
+
+	// The subscription is kept open once done
+	select {
+	case targets, ok := <-ch:
+		t.Fatalf("Unexpected update after the replay: %v %v", targets, ok)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,359 @@
+package targetsync
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// defaultRFC2136TTL is the TTL of the records if `TTL` isn't set
+const defaultRFC2136TTL = time.Minute
+
+// MetaPublicIP is the source meta of a target's public IP, written to the
+// public zone of split-horizon DNS by default
+const MetaPublicIP = "targetsync/public-ip"
+
+// algorithm returns the fully qualified TSIG algorithm, defaulting to
+// hmac-sha256
+func (c *TSIGConfig) algorithm() string {
+	if c.Algorithm == "" {
+		return dns.HmacSHA256
+	}
+	return dns.Fqdn(strings.ToLower(c.Algorithm))
+}
+
+// NewRFC2136Destination returns a new RFC2136 dynamic DNS destination
+func NewRFC2136Destination(cfg *RFC2136Config) (*RFC2136Destination, error) {
+	client := &dns.Client{
+		Net:     cfg.Transport,
+		Timeout: cfg.Timeout,
+	}
+	keyName := ""
+	if cfg.TSIG.KeyName != "" {
+		keyName = dns.Fqdn(cfg.TSIG.KeyName)
+		client.TsigSecret = map[string]string{keyName: cfg.TSIG.Secret}
+	}
+
+	d := &RFC2136Destination{
+		client:  client,
+		cfg:     cfg,
+		zone:    dns.Fqdn(cfg.Zone),
+		name:    dns.Fqdn(cfg.Name),
+		keyName: keyName,
+	}
+	if cfg.Public.Zone != "" {
+		public := *cfg
+		public.Public = RFC2136PublicConfig{}
+		public.Zone = cfg.Public.Zone
+		public.Name = cfg.Public.Name
+		if cfg.Public.Server != "" {
+			public.Server = cfg.Public.Server
+		}
+		if cfg.Public.TTL > 0 {
+			public.TTL = cfg.Public.TTL
+		}
+		if cfg.Public.TSIG.KeyName != "" {
+			public.TSIG = cfg.Public.TSIG
+		}
+		var err error
+		if d.public, err = NewRFC2136Destination(&public); err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+// RFC2136Destination is a TargetDestination implementation maintaining DNS
+// records with (optionally TSIG signed) RFC2136 dynamic updates, against any
+// compliant server (e.g. BIND, CoreDNS, Windows DNS).
+//
+// With the A record type each target is an A (or AAAA) record of `Name`, and
+// all targets share `Port`. With the SRV record type each target is an SRV
+// record of `Name`, pointing at an A (or AAAA) record named after the
+// target's IP under `Name`.
+//
+// With a `Public` zone (split-horizon DNS) the targets' public IPs, from their
+// source meta, are also written to the public zone. Each target's public IP
+// is recorded in a TXT record under `Name` in the internal zone, so it is
+// removed from the public zone even if the target no longer has its meta.
+type RFC2136Destination struct {
+	client  *dns.Client
+	cfg     *RFC2136Config
+	zone    string
+	name    string
+	keyName string
+	// public is the destination of the public zone, if enabled
+	public *RFC2136Destination
+}
+
+// log returns the destination's Logger
+func (d *RFC2136Destination) log() Logger {
+	return loggerOr(d.cfg.Logger)
+}
+
+// ttl returns the TTL of the records in seconds
+func (d *RFC2136Destination) ttl() uint32 {
+	if d.cfg.TTL <= 0 {
+		return uint32(defaultRFC2136TTL.Seconds())
+	}
+	return uint32(d.cfg.TTL.Seconds())
+}
+
+// query returns the records of the name and type from the server
+func (d *RFC2136Destination) query(ctx context.Context, name string, rrType uint16) ([]dns.RR, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(name, rrType)
+	r, _, err := d.client.ExchangeContext(ctx, m, d.cfg.Server)
+	if err != nil {
+		return nil, err
+	}
+	switch r.Rcode {
+	case dns.RcodeSuccess:
+		return r.Answer, nil
+	case dns.RcodeNameError:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("Error querying %s %s: %s", name, dns.TypeToString[rrType], dns.RcodeToString[r.Rcode])
+	}
+}
+
+// addresses returns the A and AAAA record IPs of the name
+func (d *RFC2136Destination) addresses(ctx context.Context, name string) ([]string, error) {
+	var ips []string
+	for _, rrType := range []uint16{dns.TypeA, dns.TypeAAAA} {
+		rrs, err := d.query(ctx, name, rrType)
+		if err != nil {
+			return nil, err
+		}
+		for _, rr := range rrs {
+			switch rr := rr.(type) {
+			case *dns.A:
+				ips = append(ips, rr.A.String())
+			case *dns.AAAA:
+				ips = append(ips, rr.AAAA.String())
+			}
+		}
+	}
+	return ips, nil
+}
+
+// GetTargets returns the targets from the records of `Name`
+func (d *RFC2136Destination) GetTargets(ctx context.Context) ([]*Target, error) {
+	if d.cfg.RecordType != RFC2136RecordTypeSRV {
+		ips, err := d.addresses(ctx, d.name)
+		if err != nil {
+			return nil, err
+		}
+		targets := make([]*Target, len(ips))
+		for i, ip := range ips {
+			targets[i] = &Target{IP: ip, Port: d.cfg.Port}
+		}
+		return targets, nil
+	}
+
+	rrs, err := d.query(ctx, d.name, dns.TypeSRV)
+	if err != nil {
+		return nil, err
+	}
+	targets := make([]*Target, 0, len(rrs))
+	for _, rr := range rrs {
+		srv, ok := rr.(*dns.SRV)
+		if !ok {
+			continue
+		}
+		ips, err := d.addresses(ctx, srv.Target)
+		if err != nil {
+			return nil, err
+		}
+		if len(ips) == 0 {
+			d.log().Warnf("Skipping SRV record %s with no address", srv.Target)
+			continue
+		}
+		targets = append(targets, &Target{IP: ips[0], Port: int(srv.Port)})
+	}
+	return targets, nil
+}
+
+// addressRecord returns the A (or AAAA) record of the target's IP
+func (d *RFC2136Destination) addressRecord(name string, target *Target) (dns.RR, error) {
+	ip := net.ParseIP(target.IP)
+	if ip == nil {
+		return nil, fmt.Errorf("Target %s is not an IP", target.IP)
+	}
+	hdr := dns.RR_Header{Name: name, Class: dns.ClassINET, Ttl: d.ttl()}
+	if ip4 := ip.To4(); ip4 != nil {
+		hdr.Rrtype = dns.TypeA
+		return &dns.A{Hdr: hdr, A: ip4}, nil
+	}
+	hdr.Rrtype = dns.TypeAAAA
+	return &dns.AAAA{Hdr: hdr, AAAA: ip}, nil
+}
+
+// hostName returns the name of the address record an SRV record for the
+// target points at
+func (d *RFC2136Destination) hostName(target *Target) string {
+	label := strings.NewReplacer(".", "-", ":", "-").Replace(target.IP)
+	return label + "." + d.name
+}
+
+// publicName returns the name of the TXT record of the target's public IP
+func (d *RFC2136Destination) publicName(target *Target) string {
+	return "_public." + d.hostName(target)
+}
+
+// publicIPKey returns the source meta key of the targets' public IPs
+func (d *RFC2136Destination) publicIPKey() string {
+	if d.cfg.Public.IPMetaKey != "" {
+		return d.cfg.Public.IPMetaKey
+	}
+	return MetaPublicIP
+}
+
+// publicTargets returns the public zone targets of the targets with public
+// IPs by the targets' keys. With `lookup` the public IPs of targets without
+// the meta are looked up from their TXT records.
+func (d *RFC2136Destination) publicTargets(ctx context.Context, targets []*Target, lookup bool) (map[string]*Target, error) {
+	public := make(map[string]*Target)
+	for _, target := range targets {
+		ip := target.Meta[d.publicIPKey()]
+		if ip == "" && lookup {
+			rrs, err := d.query(ctx, d.publicName(target), dns.TypeTXT)
+			if err != nil {
+				return nil, err
+			}
+			for _, rr := range rrs {
+				if txt, ok := rr.(*dns.TXT); ok && len(txt.Txt) > 0 {
+					ip = txt.Txt[0]
+				}
+			}
+		}
+		if ip == "" {
+			continue
+		}
+		if net.ParseIP(ip) == nil {
+			d.log().Warnf("Ignoring invalid public IP %q of target %v", ip, target)
+			continue
+		}
+		public[target.Key()] = &Target{IP: ip, Port: target.Port, Meta: target.Meta}
+	}
+	return public, nil
+}
+
+// publicList returns the targets of the map
+func publicList(public map[string]*Target) []*Target {
+	targets := make([]*Target, 0, len(public))
+	for _, target := range public {
+		targets = append(targets, target)
+	}
+	return targets
+}
+
+// records returns the records for the targets, and the address records the
+// SRV records point at along with the TXT records of the targets' public IPs
+func (d *RFC2136Destination) records(targets []*Target, public map[string]*Target) ([]dns.RR, []dns.RR, error) {
+	var rrs, hosts []dns.RR
+	for _, target := range targets {
+		if publicTarget, ok := public[target.Key()]; ok {
+			hosts = append(hosts, &dns.TXT{
+				Hdr: dns.RR_Header{Name: d.publicName(target), Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: d.ttl()},
+				Txt: []string{publicTarget.IP},
+			})
+		}
+
+		if d.cfg.RecordType != RFC2136RecordTypeSRV {
+			rr, err := d.addressRecord(d.name, target)
+			if err != nil {
+				return nil, nil, err
+			}
+			rrs = append(rrs, rr)
+			continue
+		}
+
+		host, err := d.addressRecord(d.hostName(target), target)
+		if err != nil {
+			return nil, nil, err
+		}
+		hosts = append(hosts, host)
+		rrs = append(rrs, &dns.SRV{
+			Hdr:      dns.RR_Header{Name: d.name, Rrtype: dns.TypeSRV, Class: dns.ClassINET, Ttl: d.ttl()},
+			Priority: uint16(d.cfg.Priority),
+			Weight:   uint16(targetWeight(target, d.cfg.Weight)),
+			Port:     uint16(target.Port),
+			Target:   host.Header().Name,
+		})
+	}
+	return rrs, hosts, nil
+}
+
+// update sends the dynamic update to the server
+func (d *RFC2136Destination) update(ctx context.Context, m *dns.Msg) error {
+	if d.keyName != "" {
+		m.SetTsig(d.keyName, d.cfg.TSIG.algorithm(), 300, time.Now().Unix())
+	}
+	r, _, err := d.client.ExchangeContext(ctx, m, d.cfg.Server)
+	if err != nil {
+		return err
+	}
+	if r.Rcode != dns.RcodeSuccess {
+		return fmt.Errorf("Dynamic update of %s rejected: %s", d.zone, dns.RcodeToString[r.Rcode])
+	}
+	return nil
+}
+
+// AddTargets adds the targets' records, the public records are added first
+// so targets are only in the internal zone once they are in both
+func (d *RFC2136Destination) AddTargets(ctx context.Context, targets []*Target) error {
+	var public map[string]*Target
+	if d.public != nil {
+		var err error
+		if public, err = d.publicTargets(ctx, targets, false); err != nil {
+			return err
+		}
+		if len(public) > 0 {
+			if err := d.public.AddTargets(ctx, publicList(public)); err != nil {
+				return fmt.Errorf("Error adding public records: %v", err)
+			}
+		}
+	}
+	rrs, hosts, err := d.records(targets, public)
+	if err != nil {
+		return err
+	}
+	m := new(dns.Msg)
+	m.SetUpdate(d.zone)
+	m.Insert(append(hosts, rrs...))
+	return d.update(ctx, m)
+}
+
+// RemoveTargets removes the targets' records, the public records are removed
+// first so targets are only removed from the internal zone once they are
+// removed from both
+func (d *RFC2136Destination) RemoveTargets(ctx context.Context, targets []*Target) error {
+	var public map[string]*Target
+	if d.public != nil {
+		var err error
+		if public, err = d.publicTargets(ctx, targets, true); err != nil {
+			return err
+		}
+		if len(public) > 0 {
+			if err := d.public.RemoveTargets(ctx, publicList(public)); err != nil {
+				return fmt.Errorf("Error removing public records: %v", err)
+			}
+		}
+	}
+	rrs, hosts, err := d.records(targets, public)
+	if err != nil {
+		return err
+	}
+	m := new(dns.Msg)
+	m.SetUpdate(d.zone)
+	m.Remove(rrs)
+	if len(hosts) > 0 {
+		m.RemoveRRset(hosts)
+	}
+	return d.update(ctx, m)
+}
@@ -0,0 +1,52 @@
+package targetsync
+
+import (
+	"context"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestRFC2136PublicRecords(t *testing.T) {
+	d, err := NewRFC2136Destination(&RFC2136Config{
+		Server: "127.0.0.1:53",
+		Zone:   "internal.example.com",
+		Name:   "web.internal.example.com",
+		Public: RFC2136PublicConfig{
+			Zone: "example.com",
+			Name: "web.example.com",
+		},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if d.public == nil || d.public.name != "web.example.com." {
+		t.Fatalf("Expected the public zone to be configured, got %+v", d.public)
+	}
+
+	withPublic := &Target{IP: "10.0.0.1", Port: 80, Meta: map[string]string{MetaPublicIP: "203.0.113.1"}}
+	internalOnly := &Target{IP: "10.0.0.2", Port: 80}
+	invalid := &Target{IP: "10.0.0.3", Port: 80, Meta: map[string]string{MetaPublicIP: "public"}}
+	targets := []*Target{withPublic, internalOnly, invalid}
+
+	public, err := d.publicTargets(context.Background(), targets, false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(public) != 1 || public[withPublic.Key()].IP != "203.0.113.1" {
+		t.Fatalf("Expected only the public IP of %s, got %v", withPublic.Key(), public)
+	}
+
+	// The internal zone records the target's public IP
+	rrs, hosts, err := d.records(targets, public)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(rrs) != 3 || len(hosts) != 1 {
+		t.Fatalf("Expected 3 records and 1 TXT record, got %v and %v", rrs, hosts)
+	}
+	txt, ok := hosts[0].(*dns.TXT)
+	if !ok || txt.Hdr.Name != "_public.10-0-0-1.web.internal.example.com." || txt.Txt[0] != "203.0.113.1" {
+		t.Fatalf("Unexpected TXT record %v", hosts[0])
+	}
+}
@@ -0,0 +1,170 @@
+package targetsync
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// healthStateUnhealthy is the destination health state which aborts rollouts
+const healthStateUnhealthy = "unhealthy"
+
+// RolloutConfig configures gradual registration of large batches of targets
+type RolloutConfig struct {
+	// StepPercent is the percentage of the batch to add per step, 0 disables
+	// gradual rollouts
+	StepPercent int `yaml:"step_percent"`
+	// MinTargets is the minimum batch size which is rolled out gradually,
+	// smaller batches are added at once
+	MinTargets int `yaml:"min_targets"`
+	// Pause between steps, after which the health of the targets added so
+	// far is checked
+	Pause time.Duration `yaml:"pause"`
+	// MaxUnhealthyPercent of the targets added so far which may be unhealthy
+	// before the rollout is aborted
+	MaxUnhealthyPercent int `yaml:"max_unhealthy_percent"`
+	// ZoneSpread orders the targets added across their availability zones
+	// (one from each zone in turn), so the first steps and destination
+	// batches of a large scale-up restore capacity in every zone
+	ZoneSpread bool `yaml:"zone_spread"`
+	// ZoneMetaKey is the source meta key holding the target's zone, defaults
+	// to `MetaAvailabilityZone`
+	ZoneMetaKey string `yaml:"zone_meta_key"`
+}
+
+// Validate checks the RolloutConfig for errors
+func (c *RolloutConfig) Validate() error {
+	if c.StepPercent < 0 || c.StepPercent > 100 {
+		return fmt.Errorf("Rollout step_percent must be between 0 and 100")
+	}
+	if c.MaxUnhealthyPercent < 0 || c.MaxUnhealthyPercent > 100 {
+		return fmt.Errorf("Rollout max_unhealthy_percent must be between 0 and 100")
+	}
+	return nil
+}
+
+// rolloutTargets adds the targets to the destination, in steps if the batch is
+// large enough for a gradual rollout. If the targets added go unhealthy the
+// rollout is aborted and the remaining targets won't be added until they are
+// removed from the source.
+func (s *Syncer) rolloutTargets(ctx context.Context, targets []*Target, state *leaderState) error {
+	cfg := s.Config.Rollout
+
+	pending := make([]*Target, 0, len(targets))
+	for _, target := range targets {
+		if _, ok := state.aborted[target.Key()]; ok {
+			s.log().Debugf("Not adding target from aborted rollout: %v", target)
+			continue
+		}
+		pending = append(pending, target)
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+	if cfg.ZoneSpread {
+		pending = spreadZones(pending, cfg.ZoneMetaKey)
+	}
+
+	if cfg.StepPercent <= 0 || cfg.StepPercent >= 100 || len(pending) < cfg.MinTargets {
+		return s.addTargets(ctx, pending)
+	}
+
+	stepSize := (len(pending)*cfg.StepPercent + 99) / 100
+	s.log().Infof("Rolling out %d targets in steps of %d", len(pending), stepSize)
+	for i := 0; i < len(pending); i += stepSize {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(cfg.Pause):
+			}
+
+			unhealthy, err := s.countUnhealthy(ctx, pending[:i])
+			if err != nil {
+				return err
+			}
+			if unhealthy*100 > i*cfg.MaxUnhealthyPercent {
+				for _, target := range pending[i:] {
+					state.aborted[target.Key()] = struct{}{}
+				}
+				s.emit(Event{
+					Type:    EventRolloutAborted,
+					Time:    time.Now(),
+					Message: fmt.Sprintf("Aborting rollout, %d of %d added targets are unhealthy, not adding %d targets", unhealthy, i, len(pending)-i),
+					Targets: pending[i:],
+				})
+				return nil
+			}
+		}
+
+		end := i + stepSize
+		if end > len(pending) {
+			end = len(pending)
+		}
+		s.log().Debugf("Rollout step adding targets %d-%d of %d", i, end, len(pending))
+		if err := s.addTargets(ctx, pending[i:end]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// countUnhealthy returns how many of the targets are unhealthy in the
+// destination. Destinations which don't report health are always healthy.
+func (s *Syncer) countUnhealthy(ctx context.Context, targets []*Target) (int, error) {
+	dstTargets, err := s.getTargets(ctx)
+	if err != nil {
+		return 0, err
+	}
+	unhealthy := make(map[string]struct{})
+	for _, target := range dstTargets {
+		if target.Health != nil && target.Health.State == healthStateUnhealthy {
+			unhealthy[target.Key()] = struct{}{}
+		}
+	}
+
+	count := 0
+	for _, target := range targets {
+		if _, ok := unhealthy[target.Key()]; ok {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// spreadZones orders the targets by taking one from each zone in turn, so any
+// prefix of them is spread as evenly as possible across the zones. Targets
+// without a zone are treated as their own zone.
+func spreadZones(targets []*Target, metaKey string) []*Target {
+	if metaKey == "" {
+		metaKey = MetaAvailabilityZone
+	}
+	byZone := make(map[string][]*Target)
+	for _, target := range targets {
+		zone := target.Meta[metaKey]
+		byZone[zone] = append(byZone[zone], target)
+	}
+	if len(byZone) < 2 {
+		return targets
+	}
+
+	zones := make([]string, 0, len(byZone))
+	for zone, zoneTargets := range byZone {
+		zones = append(zones, zone)
+		sort.Slice(zoneTargets, func(i, j int) bool {
+			return zoneTargets[i].Key() < zoneTargets[j].Key()
+		})
+	}
+	sort.Strings(zones)
+
+	spread := make([]*Target, 0, len(targets))
+	for i := 0; len(spread) < len(targets); i++ {
+		for _, zone := range zones {
+			if i < len(byZone[zone]) {
+				spread = append(spread, byZone[zone][i])
+			}
+		}
+	}
+	return spread
+}
@@ -0,0 +1,36 @@
+package targetsync
+
+import (
+	"testing"
+)
+
+func TestSpreadZones(t *testing.T) {
+	zoned := func(ip, zone string) *Target {
+		return &Target{IP: ip, Meta: map[string]string{MetaAvailabilityZone: zone}}
+	}
+	targets := []*Target{
+		zoned("10.0.0.1", "us-west-2a"),
+		zoned("10.0.0.2", "us-west-2a"),
+		zoned("10.0.0.3", "us-west-2a"),
+		zoned("10.0.1.1", "us-west-2b"),
+		zoned("10.0.1.2", "us-west-2b"),
+		zoned("10.0.2.1", "us-west-2c"),
+		{IP: "10.0.3.1"},
+	}
+	spread := spreadZones(targets, "")
+	expected := []string{"10.0.3.1", "10.0.0.1", "10.0.1.1", "10.0.2.1", "10.0.0.2", "10.0.1.2", "10.0.0.3"}
+	if len(spread) != len(expected) {
+		t.Fatalf("Expected %d targets, got %d", len(expected), len(spread))
+	}
+	for i, ip := range expected {
+		if spread[i].IP != ip {
+			t.Fatalf("Unexpected order at %d expected=%s actual=%s ", i, ip, spread[i].IP)
+		}
+	}
+
+	// Targets in a single zone are left in order
+	single := targets[:3]
+	if spread := spreadZones(single, ""); spread[0] != single[0] || spread[2] != single[2] {
+		t.Fatalf("Expected targets in a single zone to be unchanged")
+	}
+}
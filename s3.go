@@ -0,0 +1,152 @@
+package targetsync
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// s3Scheme is the scheme of S3 URLs, e.g. `s3://bucket/targetsync.yaml`
+const s3Scheme = "s3://"
+
+// isS3URL returns whether the path is an S3 URL
+func isS3URL(p string) bool {
+	return strings.HasPrefix(p, s3Scheme)
+}
+
+// parseS3URL returns the bucket and key of an S3 URL
+func parseS3URL(u string) (string, string, error) {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return "", "", fmt.Errorf("Invalid S3 URL %q: %v", u, err)
+	}
+	if parsed.Scheme != "s3" || parsed.Host == "" {
+		return "", "", fmt.Errorf("Invalid S3 URL %q: must be s3://bucket/key", u)
+	}
+	return parsed.Host, strings.TrimPrefix(parsed.Path, "/"), nil
+}
+
+// getS3Object returns the contents of the object at the S3 URL, using the
+// default region and credentials
+func getS3Object(ctx context.Context, u string) ([]byte, error) {
+	bucket, key, err := parseS3URL(u)
+	if err != nil {
+		return nil, err
+	}
+	if key == "" {
+		return nil, fmt.Errorf("Invalid S3 URL %q: the key must be set", u)
+	}
+	sess, err := awsSession("", AWSCredentialsConfig{})
+	if err != nil {
+		return nil, err
+	}
+	result, err := s3.New(sess).GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer result.Body.Close()
+	return ioutil.ReadAll(result.Body)
+}
+
+// StateConfig configures where the runtime state of the sync pairs (pending
+// removals, adopted targets and the last applied targets) is persisted
+type StateConfig struct {
+	// URL of the S3 prefix the state is stored under, as an object per pair
+	// e.g. `s3://bucket/targetsync/state`
+	URL    string `yaml:"url"`
+	Region string `yaml:"region"`
+	// Credentials are the AWS role to assume, if unset the default
+	// credential chain is used
+	Credentials AWSCredentialsConfig `yaml:"credentials"`
+}
+
+// Validate checks the StateConfig for errors
+func (c *StateConfig) Validate() error {
+	if c.URL == "" {
+		return nil
+	}
+	if _, _, err := parseS3URL(c.URL); err != nil {
+		return fmt.Errorf("Invalid state url: %v", err)
+	}
+	if c.Credentials.ExternalID != "" && c.Credentials.RoleARN == "" {
+		return fmt.Errorf("State role_arn must be set to use an external_id")
+	}
+	return nil
+}
+
+// NewS3StateStore returns a StateStore saving the state in S3
+func NewS3StateStore(cfg *StateConfig) (*S3StateStore, error) {
+	bucket, prefix, err := parseS3URL(cfg.URL)
+	if err != nil {
+		return nil, err
+	}
+	sess, err := awsSession(cfg.Region, cfg.Credentials)
+	if err != nil {
+		return nil, err
+	}
+	return &S3StateStore{
+		svc:    s3.New(sess),
+		bucket: bucket,
+		prefix: prefix,
+	}, nil
+}
+
+// S3StateStore is a StateStore saving the state of each pair as a JSON object
+// named `<prefix>/<pair name>.json`
+type S3StateStore struct {
+	svc    *s3.S3
+	bucket string
+	prefix string
+}
+
+// key returns the key of the pair's state object
+func (s *S3StateStore) key(name string) string {
+	return path.Join(s.prefix, name+".json")
+}
+
+// LoadState to implement the `StateStore` interface
+func (s *S3StateStore) LoadState(ctx context.Context, name string) (*PersistedState, error) {
+	result, err := s.svc.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key(name)),
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchKey {
+			return nil, nil
+		}
+		return nil, wrapAWSError(err)
+	}
+	defer result.Body.Close()
+	state := &PersistedState{}
+	if err := json.NewDecoder(result.Body).Decode(state); err != nil {
+		return nil, fmt.Errorf("Error decoding state s3://%s/%s: %v", s.bucket, s.key(name), err)
+	}
+	return state, nil
+}
+
+// SaveState to implement the `StateStore` interface
+func (s *S3StateStore) SaveState(ctx context.Context, name string, state *PersistedState) error {
+	b, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	_, err = s.svc.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(s.key(name)),
+		Body:        bytes.NewReader(b),
+		ContentType: aws.String("application/json"),
+	})
+	return wrapAWSError(err)
+}
@@ -0,0 +1,139 @@
+package targetsync
+
+import (
+	"context"
+	"fmt"
+
+	lb "github.com/scaleway/scaleway-sdk-go/api/lb/v1"
+	"github.com/scaleway/scaleway-sdk-go/scw"
+)
+
+// NewScalewayBackend returns a new Scaleway Load Balancer backend destination
+func NewScalewayBackend(cfg *ScalewayConfig) (*ScalewayBackend, error) {
+	// Credentials and the region fall back to the SCW_* environment variables
+	opts := []scw.ClientOption{scw.WithEnv(), scw.WithUserAgent("targetsync")}
+	if cfg.AccessKey != "" || cfg.SecretKey != "" {
+		opts = append(opts, scw.WithAuth(cfg.AccessKey, cfg.SecretKey))
+	}
+	if cfg.Region != "" {
+		region, err := scw.ParseRegion(cfg.Region)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, scw.WithDefaultRegion(region))
+	}
+	client, err := scw.NewClient(opts...)
+	if err != nil {
+		return nil, fmt.Errorf("Error creating scaleway client: %v", err)
+	}
+
+	return &ScalewayBackend{
+		api: lb.NewAPI(client),
+		cfg: cfg,
+	}, nil
+}
+
+// ScalewayBackend is a TargetDestination implementation for the servers of a
+// Scaleway Load Balancer backend. The backend's servers are IPs which share
+// its forward port, so targets are added by IP.
+type ScalewayBackend struct {
+	api *lb.API
+	cfg *ScalewayConfig
+}
+
+// log returns the destination's Logger
+func (b *ScalewayBackend) log() Logger {
+	return loggerOr(b.cfg.Logger)
+}
+
+// health returns the health of the backend's servers by IP, from the last
+// health checks
+func (b *ScalewayBackend) health(ctx context.Context) (map[string]*TargetHealth, error) {
+	resp, err := b.api.ListBackendStats(&lb.ListBackendStatsRequest{
+		LBID: b.cfg.LoadBalancerID,
+	}, scw.WithContext(ctx), scw.WithAllPages())
+	if err != nil {
+		return nil, err
+	}
+
+	health := make(map[string]*TargetHealth, len(resp.BackendServersStats))
+	for _, stats := range resp.BackendServersStats {
+		if stats.BackendID != b.cfg.BackendID {
+			continue
+		}
+		switch stats.LastHealthCheckStatus {
+		case lb.BackendServerStatsHealthCheckStatusPassed, lb.BackendServerStatsHealthCheckStatusCondpass:
+			health[stats.IP] = &TargetHealth{State: healthStateHealthy}
+		case lb.BackendServerStatsHealthCheckStatusFailed:
+			health[stats.IP] = &TargetHealth{
+				State:       healthStateUnhealthy,
+				Description: fmt.Sprintf("Server is %s", stats.ServerState),
+			}
+		default:
+			health[stats.IP] = &TargetHealth{State: stats.LastHealthCheckStatus.String()}
+		}
+	}
+	return health, nil
+}
+
+// GetTargets returns the backend's servers, with their health if the
+// `LoadBalancerID` is set
+func (b *ScalewayBackend) GetTargets(ctx context.Context) ([]*Target, error) {
+	backend, err := b.api.GetBackend(&lb.GetBackendRequest{
+		BackendID: b.cfg.BackendID,
+	}, scw.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	var health map[string]*TargetHealth
+	if b.cfg.LoadBalancerID != "" {
+		if health, err = b.health(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	targets := make([]*Target, 0, len(backend.Pool))
+	for _, ip := range backend.Pool {
+		if health != nil && health[ip] == nil {
+			b.log().Debugf("No health checks for backend server %s", ip)
+		}
+		targets = append(targets, &Target{
+			IP:     ip,
+			Port:   int(backend.ForwardPort),
+			Health: health[ip],
+		})
+	}
+	return targets, nil
+}
+
+// targetIPs returns the IPs of the targets
+func (b *ScalewayBackend) targetIPs(targets []*Target) []string {
+	ips := make([]string, len(targets))
+	for i, target := range targets {
+		ips[i] = target.IP
+	}
+	return ips
+}
+
+// AddTargets adds the targets' IPs to the backend's servers
+func (b *ScalewayBackend) AddTargets(ctx context.Context, targets []*Target) error {
+	if _, err := b.api.AddBackendServers(&lb.AddBackendServersRequest{
+		BackendID: b.cfg.BackendID,
+		ServerIP:  b.targetIPs(targets),
+	}, scw.WithContext(ctx)); err != nil {
+		return fmt.Errorf("Error adding backend servers: %v", err)
+	}
+	return nil
+}
+
+// RemoveTargets removes the targets' IPs from the backend's servers
+func (b *ScalewayBackend) RemoveTargets(ctx context.Context, targets []*Target) error {
+	if _, err := b.api.RemoveBackendServers(&lb.RemoveBackendServersRequest{
+		BackendID: b.cfg.BackendID,
+		ServerIP:  b.targetIPs(targets),
+	}, scw.WithContext(ctx)); err != nil {
+		return fmt.Errorf("Error removing backend servers: %v", err)
+	}
+	return nil
+}
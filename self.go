@@ -0,0 +1,96 @@
+package targetsync
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws/ec2metadata"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+// SelfExclusionMode defines how the local instance is treated by the sync
+type SelfExclusionMode string
+
+const (
+	// SelfExclusionProtect never removes the local instance from the
+	// destination, but still adds it
+	SelfExclusionProtect SelfExclusionMode = "protect"
+	// SelfExclusionExclude leaves the local instance out of the sync
+	// entirely, it is neither added nor removed
+	SelfExclusionExclude SelfExclusionMode = "exclude"
+)
+
+// SelfExclusionConfig keeps the sync from deregistering the instance running
+// targetsync, e.g. when it runs on the instances of the service it syncs and
+// may otherwise remove itself during bootstrap races
+type SelfExclusionConfig struct {
+	Mode SelfExclusionMode `yaml:"mode"`
+	// IPs of the local instance, the `--local-address` is always included
+	IPs []string `yaml:"ips"`
+	// Metadata adds the private and public IPs of the local instance from the
+	// EC2 instance metadata, see `ResolveLocal`
+	Metadata bool `yaml:"metadata"`
+}
+
+// Validate checks the SelfExclusionConfig for errors
+func (c *SelfExclusionConfig) Validate() error {
+	switch c.Mode {
+	case "", SelfExclusionProtect, SelfExclusionExclude:
+	default:
+		return fmt.Errorf("Unknown self_exclusion mode %q", c.Mode)
+	}
+	return nil
+}
+
+// ResolveLocal adds the local IPs from the EC2 instance metadata to the IPs
+// if `Metadata` is set, this must be called before the config is used
+func (c *SelfExclusionConfig) ResolveLocal() error {
+	if c.Mode == "" || !c.Metadata {
+		return nil
+	}
+	sess, err := session.NewSession()
+	if err != nil {
+		return err
+	}
+	client := ec2metadata.New(sess)
+	ip, err := client.GetMetadata("local-ipv4")
+	if err != nil {
+		return fmt.Errorf("Error getting local IP from instance metadata: %v", err)
+	}
+	c.IPs = append(c.IPs, ip)
+	// Not all instances have a public IP
+	if publicIP, err := client.GetMetadata("public-ipv4"); err == nil && publicIP != "" {
+		c.IPs = append(c.IPs, publicIP)
+	}
+	return nil
+}
+
+// isLocal returns whether the IP is one of the local IPs
+func (c *SelfExclusionConfig) isLocal(ip, localAddr string) bool {
+	if c.Mode == "" {
+		return false
+	}
+	if localAddr != "" && ip == localAddr {
+		return true
+	}
+	for _, localIP := range c.IPs {
+		if ip == localIP {
+			return true
+		}
+	}
+	return false
+}
+
+// Filter returns the targets without the local instance if it is excluded
+// from the sync
+func (c *SelfExclusionConfig) Filter(targets []*Target, localAddr string) []*Target {
+	if c.Mode != SelfExclusionExclude {
+		return targets
+	}
+	filtered := make([]*Target, 0, len(targets))
+	for _, target := range targets {
+		if !c.isLocal(target.IP, localAddr) {
+			filtered = append(filtered, target)
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,33 @@
+package targetsync
+
+import "testing"
+
+func TestSelfExclusionFilter(t *testing.T) {
+	src := []*Target{
+		{IP: "10.0.0.1"},
+		{IP: "10.0.0.2"},
+		{IP: "10.0.0.3"},
+	}
+
+	tests := []struct {
+		cfg      SelfExclusionConfig
+		expected []string
+	}{
+		{cfg: SelfExclusionConfig{IPs: []string{"10.0.0.1"}}, expected: []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}},
+		{cfg: SelfExclusionConfig{Mode: SelfExclusionProtect, IPs: []string{"10.0.0.1"}}, expected: []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}},
+		// the local address is always excluded
+		{cfg: SelfExclusionConfig{Mode: SelfExclusionExclude, IPs: []string{"10.0.0.1"}}, expected: []string{"10.0.0.2"}},
+	}
+
+	for i, test := range tests {
+		targets := test.cfg.Filter(src, "10.0.0.3")
+		if len(targets) != len(test.expected) {
+			t.Fatalf("%d: expected %d targets, got %d", i, len(test.expected), len(targets))
+		}
+		for j, target := range targets {
+			if target.IP != test.expected[j] {
+				t.Fatalf("%d: mismatch at %d expected=%s actual=%s", i, j, test.expected[j], target.IP)
+			}
+		}
+	}
+}
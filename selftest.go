@@ -0,0 +1,103 @@
+package targetsync
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Self test check names, in the order they are run
+const (
+	SelfTestSource      = "source"
+	SelfTestDestination = "destination"
+	SelfTestLock        = "lock"
+)
+
+// selfTestLockSuffix is appended to the lock key to get the scratch key the
+// self test acquires, so the pair's lock isn't taken from its leader
+const selfTestLockSuffix = "-selftest"
+
+// SelfTestResult is the result of the self test of a sync pair
+type SelfTestResult struct {
+	// Name of the sync pair
+	Name   string          `json:"name"`
+	Passed bool            `json:"passed"`
+	Checks []SelfTestCheck `json:"checks"`
+}
+
+// SelfTestCheck is the result of a single check of the self test
+type SelfTestCheck struct {
+	Name string `json:"name"`
+	// Skipped is set if the backend doesn't support the check
+	Skipped  bool          `json:"skipped,omitempty"`
+	Error    string        `json:"error,omitempty"`
+	Duration time.Duration `json:"duration"`
+}
+
+// Passed returns whether the check passed (or was skipped)
+func (c SelfTestCheck) Passed() bool {
+	return c.Error == ""
+}
+
+// errSelfTestSkipped is returned by a check which isn't supported
+var errSelfTestSkipped = fmt.Errorf("skipped")
+
+// SelfTest runs non-destructive checks of the pair's backends: the targets
+// are read from the source and the destination (along with their
+// `SelfTester` checks), and a scratch lock next to the pair's lock is
+// acquired and released. Nothing is changed in the destination.
+func (s *Syncer) SelfTest(ctx context.Context) *SelfTestResult {
+	result := &SelfTestResult{Name: s.name(), Passed: true}
+	run := func(name string, check func() error) {
+		start := time.Now()
+		err := check()
+		c := SelfTestCheck{Name: name, Duration: time.Since(start)}
+		switch {
+		case err == errSelfTestSkipped:
+			c.Skipped = true
+		case err != nil:
+			c.Error = err.Error()
+			result.Passed = false
+		}
+		result.Checks = append(result.Checks, c)
+	}
+
+	run(SelfTestSource, func() error {
+		if _, err := s.currentSource(ctx); err != nil {
+			return err
+		}
+		return selfTest(ctx, s.Src)
+	})
+	run(SelfTestDestination, func() error {
+		if _, err := s.getTargets(ctx); err != nil {
+			return err
+		}
+		return selfTest(ctx, s.Dst)
+	})
+	run(SelfTestLock, func() error {
+		locker, ok := s.Locker.(TryLocker)
+		if !ok {
+			return errSelfTestSkipped
+		}
+		opts := s.Config.LockOptions
+		opts.Name = s.name()
+		opts.Key += selfTestLockSuffix
+		acquired, err := locker.TryLock(ctx, &opts)
+		if err != nil {
+			return err
+		}
+		if !acquired {
+			return fmt.Errorf("Scratch lock %s is held by another process", opts.Key)
+		}
+		return locker.Unlock(ctx, &opts)
+	})
+	return result
+}
+
+// selfTest runs the backend's SelfTester checks, if it has any
+func selfTest(ctx context.Context, backend interface{}) error {
+	if tester, ok := backend.(SelfTester); ok {
+		return tester.SelfTest(ctx)
+	}
+	return nil
+}
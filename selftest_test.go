@@ -0,0 +1,78 @@
+package targetsync
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// selfTestLocker is a TryLocker recording the keys it was locked with
+type selfTestLocker struct {
+	locked   []string
+	unlocked []string
+}
+
+func (l *selfTestLocker) Lock(context.Context, *LockOptions) (<-chan bool, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+func (l *selfTestLocker) TryLock(_ context.Context, opts *LockOptions) (bool, error) {
+	l.locked = append(l.locked, opts.Key)
+	return true, nil
+}
+
+func (l *selfTestLocker) Unlock(_ context.Context, opts *LockOptions) error {
+	l.unlocked = append(l.unlocked, opts.Key)
+	return nil
+}
+
+// failingSelfTestDestination is a destination whose SelfTest fails
+type failingSelfTestDestination struct {
+	*mockDestination
+}
+
+func (d failingSelfTestDestination) SelfTest(context.Context) error {
+	return fmt.Errorf("target group doesn't exist")
+}
+
+func TestSelfTest(t *testing.T) {
+	src := newmockSource()
+	go func() {
+		src.ch <- []*Target{{IP: "1", Port: 80}}
+	}()
+	locker := &selfTestLocker{}
+	s := &Syncer{
+		Name:   "a",
+		Config: &SyncConfig{LockOptions: LockOptions{Key: "service/a/leader"}},
+		Locker: locker,
+		Src:    src,
+		Dst:    failingSelfTestDestination{newmockDestination()},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	result := s.SelfTest(ctx)
+	if result.Passed {
+		t.Fatalf("Expected the self test to fail")
+	}
+	checks := make(map[string]SelfTestCheck)
+	for _, check := range result.Checks {
+		checks[check.Name] = check
+	}
+	if !checks[SelfTestSource].Passed() || checks[SelfTestDestination].Passed() || !checks[SelfTestLock].Passed() {
+		t.Fatalf("Unexpected checks: %+v", result.Checks)
+	}
+	// The scratch lock is used, rather than the pair's
+	if len(locker.locked) != 1 || locker.locked[0] != "service/a/leader-selftest" || len(locker.unlocked) != 1 {
+		t.Fatalf("Expected the scratch lock to be acquired and released, got %v and %v", locker.locked, locker.unlocked)
+	}
+
+	// Lockers which can't be tried are skipped
+	s.Locker = &mockLocker{}
+	s.Dst = newmockDestination()
+	result = s.SelfTest(ctx)
+	if !result.Passed || !result.Checks[2].Skipped {
+		t.Fatalf("Expected the lock check to be skipped, got %+v", result.Checks)
+	}
+}
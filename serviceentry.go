@@ -0,0 +1,183 @@
+package targetsync
+
+import (
+	"context"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/util/retry"
+)
+
+var serviceEntryGVR = schema.GroupVersionResource{
+	Group:    "networking.istio.io",
+	Version:  "v1alpha3",
+	Resource: "serviceentries",
+}
+
+// NewK8sServiceEntryDestination returns a new K8sServiceEntryDestination
+func NewK8sServiceEntryDestination(cfg *K8sServiceEntryConfig) (*K8sServiceEntryDestination, error) {
+	config, err := k8sRestConfig(&cfg.K8sConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &K8sServiceEntryDestination{
+		cfg:    cfg,
+		client: client.Resource(serviceEntryGVR).Namespace(cfg.Namespace),
+	}, nil
+}
+
+// K8sServiceEntryDestination is a TargetDestination which maintains the
+// endpoints of an istio ServiceEntry, making the targets routable from the mesh
+type K8sServiceEntryDestination struct {
+	cfg    *K8sServiceEntryConfig
+	client dynamic.ResourceInterface
+}
+
+// GetTargets returns the endpoints of the ServiceEntry
+func (d *K8sServiceEntryDestination) GetTargets(ctx context.Context) ([]*Target, error) {
+	obj, err := d.client.Get(d.cfg.Name, metav1.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return d.targetsFromObject(obj)
+}
+
+// targetsFromObject returns the targets from the ServiceEntry's endpoints
+func (d *K8sServiceEntryDestination) targetsFromObject(obj *unstructured.Unstructured) ([]*Target, error) {
+	endpoints, _, err := unstructured.NestedSlice(obj.Object, "spec", "endpoints")
+	if err != nil {
+		return nil, err
+	}
+	targets := make([]*Target, 0, len(endpoints))
+	for _, e := range endpoints {
+		endpoint, ok := e.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		address, _, _ := unstructured.NestedString(endpoint, "address")
+		ports, _, _ := unstructured.NestedMap(endpoint, "ports")
+		var port int
+		switch p := ports[d.cfg.PortName].(type) {
+		case int64:
+			port = int(p)
+		case float64:
+			port = int(p)
+		}
+		targets = append(targets, &Target{
+			IP:   address,
+			Port: port,
+		})
+	}
+	return targets, nil
+}
+
+// AddTargets adds the targets as endpoints of the ServiceEntry, creating it
+// if it doesn't exist
+func (d *K8sServiceEntryDestination) AddTargets(ctx context.Context, targets []*Target) error {
+	return d.update(func(current map[string]*Target) {
+		for _, target := range targets {
+			current[target.IP] = target
+		}
+	})
+}
+
+// RemoveTargets removes the targets from the endpoints of the ServiceEntry
+func (d *K8sServiceEntryDestination) RemoveTargets(ctx context.Context, targets []*Target) error {
+	return d.update(func(current map[string]*Target) {
+		for _, target := range targets {
+			delete(current, target.IP)
+		}
+	})
+}
+
+// update does a read-modify-write of the ServiceEntry endpoints, retrying on
+// conflicting writes
+func (d *K8sServiceEntryDestination) update(f func(map[string]*Target)) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		obj, err := d.client.Get(d.cfg.Name, metav1.GetOptions{})
+		create := false
+		if err != nil {
+			if !errors.IsNotFound(err) {
+				return err
+			}
+			create = true
+			obj = d.newServiceEntry()
+		}
+
+		targets, err := d.targetsFromObject(obj)
+		if err != nil {
+			return err
+		}
+		current := make(map[string]*Target, len(targets))
+		for _, target := range targets {
+			current[target.IP] = target
+		}
+		f(current)
+
+		endpoints := make([]interface{}, 0, len(current))
+		for _, target := range current {
+			endpoints = append(endpoints, map[string]interface{}{
+				"address": target.IP,
+				"ports": map[string]interface{}{
+					d.cfg.PortName: int64(target.Port),
+				},
+			})
+		}
+		if err := unstructured.SetNestedSlice(obj.Object, endpoints, "spec", "endpoints"); err != nil {
+			return err
+		}
+
+		if create {
+			_, err = d.client.Create(obj, metav1.CreateOptions{})
+		} else {
+			_, err = d.client.Update(obj, metav1.UpdateOptions{})
+		}
+		if err != nil {
+			return fmt.Errorf("Error writing ServiceEntry %s/%s: %v", d.cfg.Namespace, d.cfg.Name, err)
+		}
+		return nil
+	})
+}
+
+// newServiceEntry returns a ServiceEntry with no endpoints
+func (d *K8sServiceEntryDestination) newServiceEntry() *unstructured.Unstructured {
+	hosts := make([]interface{}, len(d.cfg.Hosts))
+	for i, host := range d.cfg.Hosts {
+		hosts[i] = host
+	}
+	return &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": serviceEntryGVR.GroupVersion().String(),
+			"kind":       "ServiceEntry",
+			"metadata": map[string]interface{}{
+				"name":      d.cfg.Name,
+				"namespace": d.cfg.Namespace,
+			},
+			"spec": map[string]interface{}{
+				"hosts":      hosts,
+				"location":   d.cfg.Location,
+				"resolution": "STATIC",
+				"ports": []interface{}{
+					map[string]interface{}{
+						"number":   int64(d.cfg.PortNumber),
+						"name":     d.cfg.PortName,
+						"protocol": d.cfg.Protocol,
+					},
+				},
+			},
+		},
+	}
+}
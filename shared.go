@@ -0,0 +1,158 @@
+package targetsync
+
+import (
+	"context"
+	"sync"
+)
+
+// NewSharedSource returns a SharedSource fanning out the source's targets
+func NewSharedSource(src TargetSource) *SharedSource {
+	return &SharedSource{
+		src:         src,
+		subscribers: make(map[chan []*Target]struct{}),
+	}
+}
+
+// SharedSource is a TargetSource sharing a single subscription to the
+// underlying source between all of its subscribers, so syncers of the same
+// source (e.g. a consul service synced to many destinations) don't each
+// watch it. The subscription is opened by the first subscriber and closed
+// once the last one is gone.
+//
+// Subscribers get the latest targets when they subscribe, and a subscriber
+// which falls behind only gets the latest targets rather than every update.
+type SharedSource struct {
+	src TargetSource
+
+	l sync.Mutex
+	// cancel stops the current subscription, nil if there is none
+	cancel context.CancelFunc
+	// generation identifies the current subscription
+	generation  int
+	latest      []*Target
+	received    bool
+	subscribers map[chan []*Target]struct{}
+}
+
+// Healthy to implement the `HealthChecker` interface, if the underlying
+// source does
+func (s *SharedSource) Healthy() error {
+	if checker, ok := s.src.(HealthChecker); ok {
+		return checker.Healthy()
+	}
+	return nil
+}
+
+// RemovalReason to implement the `RemovalReasonSource` interface, if the
+// underlying source does
+func (s *SharedSource) RemovalReason(ip string) RemovalReason {
+	if src, ok := s.src.(RemovalReasonSource); ok {
+		return src.RemovalReason(ip)
+	}
+	return ""
+}
+
+// Subscribe to implement the `TargetSource` interface, the channel is closed
+// when the context is done or the underlying subscription closes
+func (s *SharedSource) Subscribe(ctx context.Context) (chan []*Target, error) {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	if s.cancel == nil {
+		subCtx, cancel := context.WithCancel(context.Background())
+		srcCh, err := s.src.Subscribe(subCtx)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		s.cancel = cancel
+		s.generation++
+		go s.run(srcCh, s.generation)
+	}
+
+	ch := make(chan []*Target, 1)
+	if s.received {
+		ch <- s.latest
+	}
+	s.subscribers[ch] = struct{}{}
+
+	go func() {
+		<-ctx.Done()
+		s.unsubscribe(ch)
+	}()
+	return ch, nil
+}
+
+// SubscribeDeltas to implement the `TargetDeltaSource` interface
+func (s *SharedSource) SubscribeDeltas(ctx context.Context) (chan *TargetDelta, error) {
+	ch, err := s.Subscribe(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return deltasFromSnapshots(ctx, ch), nil
+}
+
+// run sends the targets from the underlying subscription to all subscribers,
+// replacing any targets they haven't received yet. Targets still buffered in
+// a stopped subscription are dropped, rather than sent to the subscribers of
+// the next one.
+func (s *SharedSource) run(srcCh chan []*Target, generation int) {
+	for targets := range srcCh {
+		s.l.Lock()
+		if s.generation != generation {
+			s.l.Unlock()
+			continue
+		}
+		s.latest = targets
+		s.received = true
+		for ch := range s.subscribers {
+			select {
+			case ch <- targets:
+			default:
+				select {
+				case <-ch:
+				default:
+				}
+				ch <- targets
+			}
+		}
+		s.l.Unlock()
+	}
+
+	// The subscription closed, if it wasn't stopped by the last subscriber
+	// leaving its subscribers are closed too
+	s.l.Lock()
+	defer s.l.Unlock()
+	if s.generation != generation || s.cancel == nil {
+		return
+	}
+	logger.Warnf("Shared source subscription closed, closing %d subscribers", len(s.subscribers))
+	for ch := range s.subscribers {
+		close(ch)
+		delete(s.subscribers, ch)
+	}
+	s.stop()
+}
+
+// unsubscribe removes the subscriber, stopping the subscription if it was the
+// last one
+func (s *SharedSource) unsubscribe(ch chan []*Target) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	if _, ok := s.subscribers[ch]; !ok {
+		return
+	}
+	close(ch)
+	delete(s.subscribers, ch)
+	if len(s.subscribers) == 0 {
+		s.stop()
+	}
+}
+
+// stop cancels the current subscription, the lock must be held
+func (s *SharedSource) stop() {
+	s.cancel()
+	s.cancel = nil
+	s.latest = nil
+	s.received = false
+}
@@ -0,0 +1,150 @@
+package targetsync
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// countingSource is a mockSource counting its open subscriptions
+type countingSource struct {
+	*mockSource
+
+	l    sync.Mutex
+	open int
+}
+
+func (c *countingSource) Subscribe(ctx context.Context) (chan []*Target, error) {
+	c.l.Lock()
+	c.open++
+	c.l.Unlock()
+	go func() {
+		<-ctx.Done()
+		c.l.Lock()
+		c.open--
+		c.l.Unlock()
+	}()
+	return c.mockSource.Subscribe(ctx)
+}
+
+func (c *countingSource) subscriptions() int {
+	c.l.Lock()
+	defer c.l.Unlock()
+	return c.open
+}
+
+func TestSharedSource(t *testing.T) {
+	src := &countingSource{mockSource: newmockSource()}
+	shared := NewSharedSource(src)
+
+	ctxA, cancelA := context.WithCancel(context.Background())
+	chA, err := shared.Subscribe(ctxA)
+	if err != nil {
+		t.Fatalf("Error subscribing: %v", err)
+	}
+	src.ch <- []*Target{{IP: "1"}}
+	if targets := <-chA; len(targets) != 1 {
+		t.Fatalf("Unexpected targets: %v", targets)
+	}
+
+	// A later subscriber gets the latest targets, over the same subscription
+	ctxB, cancelB := context.WithCancel(context.Background())
+	chB, err := shared.Subscribe(ctxB)
+	if err != nil {
+		t.Fatalf("Error subscribing: %v", err)
+	}
+	if targets := <-chB; len(targets) != 1 {
+		t.Fatalf("Unexpected targets: %v", targets)
+	}
+	if n := src.subscriptions(); n != 1 {
+		t.Fatalf("Expected 1 subscription to the source, got %d", n)
+	}
+
+	src.ch <- []*Target{{IP: "1"}, {IP: "2"}}
+	for _, ch := range []chan []*Target{chA, chB} {
+		if targets := <-ch; len(targets) != 2 {
+			t.Fatalf("Unexpected targets: %v", targets)
+		}
+	}
+
+	// The subscription is closed with the last subscriber
+	cancelA()
+	if _, ok := <-chA; ok {
+		t.Fatalf("Expected channel to be closed")
+	}
+	cancelB()
+	if _, ok := <-chB; ok {
+		t.Fatalf("Expected channel to be closed")
+	}
+	for i := 0; src.subscriptions() != 0; i++ {
+		if i > 100 {
+			t.Fatalf("Source subscription not closed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// channelsSource returns its channels to each subscription in turn, ignoring
+// the context like a source whose channel is buffered
+type channelsSource struct {
+	l   sync.Mutex
+	chs []chan []*Target
+}
+
+func (c *channelsSource) Subscribe(context.Context) (chan []*Target, error) {
+	c.l.Lock()
+	defer c.l.Unlock()
+	ch := c.chs[0]
+	c.chs = c.chs[1:]
+	return ch, nil
+}
+
+func TestSharedSourceStaleSubscription(t *testing.T) {
+	oldCh, newCh := make(chan []*Target, 10), make(chan []*Target, 10)
+	shared := NewSharedSource(&channelsSource{chs: []chan []*Target{oldCh, newCh}})
+
+	ctxA, cancelA := context.WithCancel(context.Background())
+	chA, err := shared.Subscribe(ctxA)
+	if err != nil {
+		t.Fatalf("Error subscribing: %v", err)
+	}
+	cancelA()
+	if _, ok := <-chA; ok {
+		t.Fatalf("Expected channel to be closed")
+	}
+
+	ctxB, cancelB := context.WithCancel(context.Background())
+	defer cancelB()
+	chB, err := shared.Subscribe(ctxB)
+	if err != nil {
+		t.Fatalf("Error subscribing: %v", err)
+	}
+
+	// Snapshots still buffered in the stopped subscription are dropped
+	oldCh <- []*Target{{IP: "stale"}}
+	oldCh <- []*Target{{IP: "stale"}}
+	for i := 0; len(oldCh) > 0; i++ {
+		if i > 100 {
+			t.Fatalf("Stopped subscription not drained")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case targets := <-chB:
+		t.Fatalf("Unexpected targets from the stopped subscription: %v", targets)
+	default:
+	}
+	shared.l.Lock()
+	received := shared.received
+	shared.l.Unlock()
+	if received {
+		t.Fatalf("Latest targets set from the stopped subscription")
+	}
+
+	newCh <- []*Target{{IP: "1"}}
+	if targets := <-chB; len(targets) != 1 || targets[0].IP != "1" {
+		t.Fatalf("Unexpected targets: %v", targets)
+	}
+}
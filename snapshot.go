@@ -0,0 +1,78 @@
+package targetsync
+
+import (
+	"context"
+	"time"
+)
+
+// DestinationSnapshot is the set of targets in a destination at a point in time
+type DestinationSnapshot struct {
+	// Key is the lock key identifying the sync pair
+	Key     string    `json:"key"`
+	Time    time.Time `json:"time"`
+	Targets []*Target `json:"targets"`
+}
+
+// TakeSnapshot returns a snapshot of the targets currently in the destination
+func TakeSnapshot(ctx context.Context, key string, dst TargetDestination) (*DestinationSnapshot, error) {
+	targets, err := dst.GetTargets(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := &DestinationSnapshot{
+		Key:     key,
+		Time:    time.Now(),
+		Targets: make([]*Target, len(targets)),
+	}
+	for i, target := range targets {
+		// health is point in time state of the destination, not worth keeping
+		t := *target
+		t.Health = nil
+		snapshot.Targets[i] = &t
+	}
+	return snapshot, nil
+}
+
+// RestoreSnapshot makes the destination's targets match the snapshot, if
+// addOnly is set targets not in the snapshot are left in the destination
+func RestoreSnapshot(ctx context.Context, dst TargetDestination, snapshot *DestinationSnapshot, addOnly bool) (added, removed []*Target, err error) {
+	current, err := dst.GetTargets(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	currentMap := make(map[string]*Target, len(current))
+	for _, target := range current {
+		currentMap[target.Key()] = target
+	}
+	snapshotMap := make(map[string]*Target, len(snapshot.Targets))
+	for _, target := range snapshot.Targets {
+		snapshotMap[target.Key()] = target
+	}
+
+	for key, target := range snapshotMap {
+		if _, ok := currentMap[key]; !ok {
+			added = append(added, target)
+		}
+	}
+	if !addOnly {
+		for key, target := range currentMap {
+			if _, ok := snapshotMap[key]; !ok {
+				removed = append(removed, target)
+			}
+		}
+	}
+
+	if len(added) > 0 {
+		if err := dst.AddTargets(ctx, added); err != nil {
+			return nil, nil, err
+		}
+	}
+	if len(removed) > 0 {
+		if err := dst.RemoveTargets(ctx, removed); err != nil {
+			return added, nil, err
+		}
+	}
+	return added, removed, nil
+}
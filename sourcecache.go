@@ -0,0 +1,94 @@
+package targetsync
+
+import (
+	"fmt"
+	"time"
+)
+
+// defaultSourceRetryInterval is how often a closed source subscription is
+// retried if `SourceCache.RetryInterval` isn't set
+const defaultSourceRetryInterval = 5 * time.Second
+
+// SourceCacheConfig configures riding out source outages with the last known
+// good targets, instead of stopping the sync when the source subscription
+// closes or silently syncing a stale view of the source
+type SourceCacheConfig struct {
+	// Enabled keeps the last known good targets when the source subscription
+	// closes, and resubscribes every RetryInterval
+	Enabled bool `yaml:"enabled"`
+	// MaxStaleness pauses syncing once the source has been unhealthy (see
+	// `HealthChecker`) or unsubscribed for longer, 0 never pauses
+	MaxStaleness time.Duration `yaml:"max_staleness"`
+	// RetryInterval is how often to resubscribe, defaults to 5s
+	RetryInterval time.Duration `yaml:"retry_interval"`
+}
+
+// Validate checks the SourceCacheConfig for errors
+func (c *SourceCacheConfig) Validate() error {
+	if c.MaxStaleness < 0 || c.RetryInterval < 0 {
+		return fmt.Errorf("Source cache max_staleness and retry_interval must be >=0")
+	}
+	return nil
+}
+
+// retryInterval returns how often to resubscribe to the source
+func (c *SourceCacheConfig) retryInterval() time.Duration {
+	if c.RetryInterval <= 0 {
+		return defaultSourceRetryInterval
+	}
+	return c.RetryInterval
+}
+
+// sourceFreshness tracks how long the source has been stale within a
+// runLeader loop, it is not safe for concurrent use
+type sourceFreshness struct {
+	// closed is whether the source subscription is closed
+	closed bool
+	// staleSince is when the source went stale, zero if it is fresh
+	staleSince time.Time
+	// paused is whether syncing is paused for exceeding `MaxStaleness`
+	paused bool
+}
+
+// checkFreshness updates the source's staleness from its health and the
+// subscription, and pauses or resumes syncing as `MaxStaleness` is crossed.
+// It returns whether syncing was resumed.
+func (s *Syncer) checkFreshness(f *sourceFreshness) bool {
+	stale := f.closed
+	var healthErr error
+	if checker, ok := s.Src.(HealthChecker); ok {
+		if healthErr = checker.Healthy(); healthErr != nil {
+			stale = true
+		}
+	}
+
+	if !stale {
+		sourceStalenessSeconds.WithLabelValues(s.name()).Set(0)
+		f.staleSince = time.Time{}
+		if f.paused {
+			f.paused = false
+			s.log().Infof("Source recovered, resuming sync")
+			return true
+		}
+		return false
+	}
+
+	now := time.Now()
+	if f.staleSince.IsZero() {
+		f.staleSince = now
+		s.log().Warnf("Source is stale, syncing last known targets: %v", healthErr)
+	}
+	staleness := now.Sub(f.staleSince)
+	sourceStalenessSeconds.WithLabelValues(s.name()).Set(staleness.Seconds())
+
+	maxStaleness := s.Config.SourceCache.MaxStaleness
+	if !f.paused && maxStaleness > 0 && staleness > maxStaleness {
+		f.paused = true
+		s.emit(Event{
+			Type:    EventSourceStale,
+			Time:    now,
+			Message: fmt.Sprintf("Source stale for %v (max %v), pausing sync until it recovers", staleness.Round(time.Second), maxStaleness),
+		})
+	}
+	return false
+}
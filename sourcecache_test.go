@@ -0,0 +1,47 @@
+package targetsync
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestCheckFreshness(t *testing.T) {
+	events := make(chanSink, 10)
+	src := NewFakeSource(&FakeSourceConfig{Targets: 1})
+	syncer := &Syncer{
+		Config: &SyncConfig{
+			LockOptions: LockOptions{Key: "a"},
+			SourceCache: SourceCacheConfig{Enabled: true, MaxStaleness: time.Millisecond},
+		},
+		Src:    src,
+		Events: events,
+	}
+	f := &sourceFreshness{}
+
+	if syncer.checkFreshness(f); f.paused || !f.staleSince.IsZero() {
+		t.Fatalf("Healthy source must not be stale: %+v", f)
+	}
+
+	src.lastErr = fmt.Errorf("down")
+	if syncer.checkFreshness(f); f.paused || f.staleSince.IsZero() {
+		t.Fatalf("Unhealthy source must be stale but not paused: %+v", f)
+	}
+	time.Sleep(2 * time.Millisecond)
+	if syncer.checkFreshness(f); !f.paused {
+		t.Fatalf("Source stale past max_staleness must be paused: %+v", f)
+	}
+	if e := <-events; e.Type != EventSourceStale {
+		t.Fatalf("Unexpected event: %+v", e)
+	}
+
+	src.lastErr = nil
+	f.closed = true
+	if syncer.checkFreshness(f) {
+		t.Fatalf("Closed subscription must not resume the sync")
+	}
+	f.closed = false
+	if !syncer.checkFreshness(f) || f.paused {
+		t.Fatalf("Recovered source must resume the sync: %+v", f)
+	}
+}
@@ -0,0 +1,236 @@
+package targetsync
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+const (
+	// defaultStandbyInterval is how often followers read the destination if
+	// `Standby.Interval` isn't set
+	defaultStandbyInterval = 30 * time.Second
+	// defaultStandbyHeartbeatInterval is how often the standby heartbeat is
+	// recorded if `Standby.HeartbeatInterval` isn't set
+	defaultStandbyHeartbeatInterval = 10 * time.Second
+	// maxLockAttemptAge is how recently a follower must have attempted the
+	// lock to be a healthy standby
+	maxLockAttemptAge = time.Minute
+)
+
+// StandbyConfig configures followers to stay warm, so a follower acquiring the
+// lock reconciles the destination straight away instead of first waiting on
+// a new source subscription (e.g. consul watch) and cold destination clients
+type StandbyConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Interval to read the destination's targets while following, defaults
+	// to 30s
+	Interval time.Duration `yaml:"interval"`
+	// Heartbeat records whether this instance is a healthy standby with the
+	// locker (e.g. in consul KV) every HeartbeatInterval, so every instance
+	// can count the standbys of the lock. Requires a locker which is a
+	// `StandbyRegistry`, HeartbeatInterval defaults to 10s
+	Heartbeat         bool          `yaml:"heartbeat"`
+	HeartbeatInterval time.Duration `yaml:"heartbeat_interval"`
+}
+
+// Validate checks the StandbyConfig for errors
+func (c *StandbyConfig) Validate() error {
+	if c.Interval < 0 || c.HeartbeatInterval < 0 {
+		return fmt.Errorf("Standby interval and heartbeat_interval must be >=0")
+	}
+	return nil
+}
+
+// interval returns how often to read the destination
+func (c *StandbyConfig) interval() time.Duration {
+	if c.Interval <= 0 {
+		return defaultStandbyInterval
+	}
+	return c.Interval
+}
+
+// heartbeatInterval returns how often to record the standby heartbeat
+func (c *StandbyConfig) heartbeatInterval() time.Duration {
+	if c.HeartbeatInterval <= 0 {
+		return defaultStandbyHeartbeatInterval
+	}
+	return c.HeartbeatInterval
+}
+
+// StandbyHeartbeat is the state of an instance contending for a lock, as
+// recorded in the `StandbyRegistry`
+type StandbyHeartbeat struct {
+	// Identity of the instance, its `LockOptions.Identity`
+	Identity string `json:"identity"`
+	Leader   bool   `json:"leader"`
+	// Healthy is whether the instance is a healthy standby, ready to take
+	// over if the leader goes away
+	Healthy bool `json:"healthy"`
+	// Time the heartbeat was recorded
+	Time            time.Time `json:"time"`
+	LastLockAttempt time.Time `json:"last_lock_attempt,omitempty"`
+}
+
+// standbyHealthy returns whether the status is of a healthy standby: a
+// follower whose Run loop is alive, whose source is healthy and, if the locker
+// reports it, which has recently attempted the lock. The status lock must be
+// held.
+func (s *Syncer) standbyHealthy(status *SyncerStatus, now time.Time) bool {
+	if status.State != SyncerStateFollower || status.SourceError != "" {
+		return false
+	}
+	if now.Sub(s.runHeartbeat) > 3*heartbeatInterval {
+		return false
+	}
+	if _, ok := s.Locker.(LockAttemptReporter); ok && now.Sub(status.LastLockAttempt) > maxLockAttemptAge {
+		return false
+	}
+	return true
+}
+
+// observeStandby updates the standby metrics from the current status
+func (s *Syncer) observeStandby() {
+	status := s.Status()
+	name := s.name()
+	if status.Standby {
+		standbyHealthy.WithLabelValues(name).Set(1)
+	} else {
+		standbyHealthy.WithLabelValues(name).Set(0)
+	}
+	if !status.LastLockAttempt.IsZero() {
+		lockAttemptTimestamp.WithLabelValues(name).Set(float64(status.LastLockAttempt.UnixNano()) / 1e9)
+	}
+}
+
+// runStandbyHeartbeat records this instance's heartbeat in the locker every
+// `HeartbeatInterval` and counts the healthy standbys of the lock from all
+// heartbeats, until the context is done. The heartbeat is then removed.
+func (s *Syncer) runStandbyHeartbeat(ctx context.Context) {
+	registry, ok := s.Locker.(StandbyRegistry)
+	if !ok {
+		s.log().Warnf("Locker %T can't record standby heartbeats, not counting standbys", s.Locker)
+		return
+	}
+	opts := &s.Config.LockOptions
+	if opts.Identity == "" {
+		s.log().Warnf("Lock identity isn't set, not recording standby heartbeats")
+		return
+	}
+	defer func() {
+		// The context is done, so give the removal its own timeout
+		delCtx, cancel := context.WithTimeout(context.Background(), s.Config.Standby.heartbeatInterval())
+		defer cancel()
+		if err := registry.DeleteStandby(delCtx, opts, opts.Identity); err != nil {
+			s.log().Warnf("Error removing standby heartbeat: %v", err)
+		}
+	}()
+
+	interval := s.Config.Standby.heartbeatInterval()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		s.heartbeatStandby(ctx, registry, interval)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// heartbeatStandby records a single heartbeat and counts the standbys. The
+// leader also removes the heartbeats of instances long gone.
+func (s *Syncer) heartbeatStandby(ctx context.Context, registry StandbyRegistry, interval time.Duration) {
+	opts := &s.Config.LockOptions
+	status := s.Status()
+	now := time.Now()
+	if err := registry.PutStandby(ctx, opts, &StandbyHeartbeat{
+		Identity:        opts.Identity,
+		Leader:          status.Leader,
+		Healthy:         status.Standby,
+		Time:            now,
+		LastLockAttempt: status.LastLockAttempt,
+	}); err != nil {
+		s.log().Warnf("Error recording standby heartbeat: %v", err)
+		return
+	}
+	heartbeats, err := registry.Standbys(ctx, opts)
+	if err != nil {
+		s.log().Warnf("Error reading standby heartbeats: %v", err)
+		return
+	}
+
+	standbys := 0
+	for _, hb := range heartbeats {
+		age := now.Sub(hb.Time)
+		if age > 3*interval {
+			if status.Leader && age > 10*interval && hb.Identity != opts.Identity {
+				s.log().Infof("Removing standby heartbeat of %s, last seen %v ago", hb.Identity, age.Round(time.Second))
+				if err := registry.DeleteStandby(ctx, opts, hb.Identity); err != nil {
+					s.log().Warnf("Error removing standby heartbeat of %s: %v", hb.Identity, err)
+				}
+			}
+			continue
+		}
+		if hb.Healthy && !hb.Leader {
+			standbys++
+		}
+	}
+	standbyCount.WithLabelValues(s.name()).Set(float64(standbys))
+	s.statusLock.Lock()
+	s.standbys = &standbys
+	s.statusLock.Unlock()
+}
+
+// following returns whether the Syncer is a follower
+func (s *Syncer) following() bool {
+	s.statusLock.Lock()
+	defer s.statusLock.Unlock()
+	return s.status.State == SyncerStateFollower
+}
+
+// runStandby holds a subscription to the source for the lifetime of the
+// Syncer, so the leader loop's subscription (through the SharedSource) gets
+// the latest targets immediately, and reads the destination while following.
+// Nothing is changed in the destination.
+func (s *Syncer) runStandby(ctx context.Context) {
+	subscribe := func() chan []*Target {
+		ch, err := s.Src.Subscribe(ctx)
+		if err != nil {
+			s.log().Warnf("Error subscribing to source for standby, retrying in %v: %v", s.Config.Standby.interval(), err)
+			return nil
+		}
+		return ch
+	}
+	srcCh := subscribe()
+
+	ticker := time.NewTicker(s.Config.Standby.interval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case targets, ok := <-srcCh:
+			if !ok {
+				s.log().Debugf("Standby source subscription closed, resubscribing in %v", s.Config.Standby.interval())
+				srcCh = nil
+				continue
+			}
+			s.log().Debugf("Standby received %d targets from source", len(targets))
+		case <-ticker.C:
+			if srcCh == nil {
+				srcCh = subscribe()
+			}
+			if !s.following() {
+				continue
+			}
+			targets, err := s.getTargets(ctx)
+			if err != nil {
+				s.log().Warnf("Error reading destination for standby: %v", err)
+				continue
+			}
+			s.log().Debugf("Standby read %d targets from destination", len(targets))
+		}
+	}
+}
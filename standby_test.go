@@ -0,0 +1,126 @@
+package targetsync
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// followerLocker never acquires the lock
+type followerLocker struct{}
+
+func (followerLocker) Lock(context.Context, *LockOptions) (<-chan bool, error) {
+	return make(chan bool), nil
+}
+
+func TestStandby(t *testing.T) {
+	src := &countingSource{mockSource: newmockSource()}
+	syncer := &Syncer{
+		Config: &SyncConfig{
+			LockOptions: LockOptions{Key: "a", TTL: time.Second},
+			Standby:     StandbyConfig{Enabled: true, Interval: 10 * time.Millisecond},
+		},
+		Locker: followerLocker{},
+		Src:    src,
+		Dst:    newmockDestination(),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go syncer.Run(ctx)
+	src.ch <- []*Target{{IP: "1"}}
+	<-syncer.Ready()
+	time.Sleep(50 * time.Millisecond)
+
+	// The follower keeps a single subscription to the source open
+	if n := src.subscriptions(); n != 1 {
+		t.Fatalf("Expected 1 source subscription while following, got %d", n)
+	}
+	if _, ok := syncer.Src.(*SharedSource); !ok {
+		t.Fatalf("Expected the source to be shared, got %T", syncer.Src)
+	}
+
+	cancel()
+	time.Sleep(50 * time.Millisecond)
+	if n := src.subscriptions(); n != 0 {
+		t.Fatalf("Expected no source subscriptions once stopped, got %d", n)
+	}
+}
+
+// memoryRegistry is a StandbyRegistry holding the heartbeats in memory, which
+// never acquires the lock
+type memoryRegistry struct {
+	followerLocker
+	l          sync.Mutex
+	heartbeats map[string]StandbyHeartbeat
+}
+
+func (r *memoryRegistry) LastLockAttempt(*LockOptions) time.Time {
+	return time.Now()
+}
+
+func (r *memoryRegistry) PutStandby(_ context.Context, _ *LockOptions, hb *StandbyHeartbeat) error {
+	r.l.Lock()
+	defer r.l.Unlock()
+	r.heartbeats[hb.Identity] = *hb
+	return nil
+}
+
+func (r *memoryRegistry) DeleteStandby(_ context.Context, _ *LockOptions, identity string) error {
+	r.l.Lock()
+	defer r.l.Unlock()
+	delete(r.heartbeats, identity)
+	return nil
+}
+
+func (r *memoryRegistry) Standbys(context.Context, *LockOptions) ([]*StandbyHeartbeat, error) {
+	r.l.Lock()
+	defer r.l.Unlock()
+	heartbeats := make([]*StandbyHeartbeat, 0, len(r.heartbeats))
+	for _, hb := range r.heartbeats {
+		hb := hb
+		heartbeats = append(heartbeats, &hb)
+	}
+	return heartbeats, nil
+}
+
+func TestStandbyHeartbeat(t *testing.T) {
+	registry := &memoryRegistry{heartbeats: map[string]StandbyHeartbeat{
+		"leader": {Identity: "leader", Leader: true, Time: time.Now()},
+		"gone":   {Identity: "gone", Healthy: true, Time: time.Now().Add(-time.Hour)},
+	}}
+	src := newmockSource()
+	syncer := &Syncer{
+		Config: &SyncConfig{
+			LockOptions: LockOptions{Key: "a", TTL: time.Second, Identity: "b"},
+			Standby:     StandbyConfig{Heartbeat: true, HeartbeatInterval: 10 * time.Millisecond},
+		},
+		Locker: registry,
+		Src:    src,
+		Dst:    newmockDestination(),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go syncer.Run(ctx)
+	src.ch <- []*Target{{IP: "1"}}
+	<-syncer.Ready()
+	time.Sleep(50 * time.Millisecond)
+
+	// Only this follower is a standby, the leader and the stale heartbeat
+	// aren't counted
+	status := syncer.Status()
+	if !status.Standby || status.LastLockAttempt.IsZero() {
+		t.Fatalf("Expected a healthy standby, got %+v", status)
+	}
+	if status.Standbys == nil || *status.Standbys != 1 {
+		t.Fatalf("Expected 1 standby, got %v", status.Standbys)
+	}
+
+	cancel()
+	time.Sleep(50 * time.Millisecond)
+	registry.l.Lock()
+	defer registry.l.Unlock()
+	if _, ok := registry.heartbeats["b"]; ok {
+		t.Fatalf("Expected the heartbeat to be removed once stopped")
+	}
+}
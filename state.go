@@ -0,0 +1,230 @@
+package targetsync
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"sort"
+	"time"
+)
+
+const (
+	// stateSaveInterval is how often the leader saves the state, if it has
+	// changed
+	stateSaveInterval = 30 * time.Second
+	// stateTimeout is the timeout for loading and saving the state
+	stateTimeout = 10 * time.Second
+)
+
+// PersistedState is the runtime state of a Syncer persisted in the StateStore,
+// which is restored when the Syncer becomes leader
+type PersistedState struct {
+	// Time the state was saved
+	Time time.Time `json:"time"`
+	// Adoption are the adopted targets, if adoption is enabled
+	Adoption *AdoptionState `json:"adoption,omitempty"`
+	// Removals are the targets waiting to be removed from the destination
+	Removals []*PendingRemoval `json:"removals,omitempty"`
+	// LastApplied are the source targets of the last successful full sync
+	LastApplied *DestinationSnapshot `json:"last_applied,omitempty"`
+}
+
+// AdoptionState is the persisted state of the adopted targets
+type AdoptionState struct {
+	// Done is whether the targets have been adopted
+	Done    bool             `json:"done"`
+	Targets []*AdoptedTarget `json:"targets,omitempty"`
+}
+
+// PendingRemoval is a target waiting to be removed from the destination
+type PendingRemoval struct {
+	Target *Target `json:"target"`
+	// At is when the target is due to be removed
+	At time.Time `json:"at"`
+	// Cancelled is set when the removal was cancelled (e.g. by an operator),
+	// the target is kept until it is flushed or the source has it again
+	Cancelled bool `json:"cancelled,omitempty"`
+}
+
+// setPendingRemoval records the target as waiting to be removed at `at`
+func (s *Syncer) setPendingRemoval(target *Target, at time.Time) {
+	s.removalsLock.Lock()
+	defer s.removalsLock.Unlock()
+	if s.removals == nil {
+		s.removals = make(map[string]*PendingRemoval)
+	}
+	s.removals[target.Key()] = &PendingRemoval{Target: target, At: at}
+}
+
+// cancelPendingRemoval records the removal of the target as cancelled
+func (s *Syncer) cancelPendingRemoval(target *Target) {
+	s.removalsLock.Lock()
+	defer s.removalsLock.Unlock()
+	if s.removals == nil {
+		s.removals = make(map[string]*PendingRemoval)
+	}
+	removal := &PendingRemoval{Target: target, At: time.Now(), Cancelled: true}
+	if pending, ok := s.removals[target.Key()]; ok {
+		removal.At = pending.At
+	}
+	s.removals[target.Key()] = removal
+}
+
+// releaseCancelledRemovals forgets the cancelled removals of the targets the
+// source has again (by IP), so they are removed once they are absent again
+func (s *Syncer) releaseCancelledRemovals(srcMap map[string]*Target) {
+	s.removalsLock.Lock()
+	defer s.removalsLock.Unlock()
+	for key, removal := range s.removals {
+		if _, ok := srcMap[removal.Target.IP]; ok && removal.Cancelled {
+			delete(s.removals, key)
+		}
+	}
+}
+
+// pendingRemoval returns the pending removal of the target with the key, or
+// nil if it isn't waiting to be removed
+func (s *Syncer) pendingRemoval(key string) *PendingRemoval {
+	s.removalsLock.Lock()
+	defer s.removalsLock.Unlock()
+	return s.removals[key]
+}
+
+// clearPendingRemoval records the target with the key as no longer waiting to
+// be removed
+func (s *Syncer) clearPendingRemoval(key string) {
+	s.removalsLock.Lock()
+	defer s.removalsLock.Unlock()
+	delete(s.removals, key)
+}
+
+// pendingRemovals returns the targets waiting to be removed, sorted by when
+// they are due
+func (s *Syncer) pendingRemovals() []*PendingRemoval {
+	s.removalsLock.Lock()
+	removals := make([]*PendingRemoval, 0, len(s.removals))
+	for _, removal := range s.removals {
+		removals = append(removals, removal)
+	}
+	s.removalsLock.Unlock()
+	sort.Slice(removals, func(i, j int) bool {
+		if removals[i].At.Equal(removals[j].At) {
+			return removals[i].Target.Key() < removals[j].Target.Key()
+		}
+		return removals[i].At.Before(removals[j].At)
+	})
+	return removals
+}
+
+// setLastApplied records the source targets of a successful full sync
+func (s *Syncer) setLastApplied(targets []*Target) {
+	s.removalsLock.Lock()
+	defer s.removalsLock.Unlock()
+	s.lastApplied = &DestinationSnapshot{
+		Key:     s.Config.LockOptions.Key,
+		Time:    time.Now(),
+		Targets: targets,
+	}
+}
+
+// snapshotState returns the Syncer's current state
+func (s *Syncer) snapshotState() *PersistedState {
+	state := &PersistedState{Removals: s.pendingRemovals()}
+	s.removalsLock.Lock()
+	state.LastApplied = s.lastApplied
+	s.removalsLock.Unlock()
+
+	if s.Config.Adopt.Enabled {
+		s.adoptLock.Lock()
+		adoption := &AdoptionState{Done: s.adoption.done}
+		for _, adopted := range s.adoption.targets {
+			adoption.Targets = append(adoption.Targets, adopted)
+		}
+		s.adoptLock.Unlock()
+		sort.Slice(adoption.Targets, func(i, j int) bool {
+			return adoption.Targets[i].Target.Key() < adoption.Targets[j].Target.Key()
+		})
+		state.Adoption = adoption
+	}
+	return state
+}
+
+// restoreState replaces the Syncer's state with the persisted one, if any.
+// Errors are logged, starting without the state as if none had been saved.
+func (s *Syncer) restoreState(ctx context.Context) {
+	if s.StateStore == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, stateTimeout)
+	defer cancel()
+	state, err := s.StateStore.LoadState(ctx, s.name())
+	if err != nil {
+		s.log().Warnf("Error loading state, starting without it: %v", err)
+		return
+	}
+	if state == nil {
+		return
+	}
+
+	s.removalsLock.Lock()
+	s.removals = make(map[string]*PendingRemoval, len(state.Removals))
+	for _, removal := range state.Removals {
+		if removal.Target != nil {
+			s.removals[removal.Target.Key()] = removal
+		}
+	}
+	s.lastApplied = state.LastApplied
+	s.removalsLock.Unlock()
+
+	if state.Adoption != nil && s.Config.Adopt.Enabled {
+		s.adoptLock.Lock()
+		s.adoption.done = state.Adoption.Done
+		s.adoption.targets = make(map[string]*AdoptedTarget, len(state.Adoption.Targets))
+		for _, adopted := range state.Adoption.Targets {
+			if adopted.Target != nil {
+				s.adoption.targets[adopted.Target.IP] = adopted
+			}
+		}
+		s.adoptLock.Unlock()
+	}
+	s.log().Infof("Restored state saved at %v: %d pending removals", state.Time, len(state.Removals))
+}
+
+// runStateSaver saves the state every `stateSaveInterval` while it changes,
+// and once more when the context is done (e.g. the lock is lost)
+func (s *Syncer) runStateSaver(ctx context.Context) {
+	if s.StateStore == nil {
+		return
+	}
+	ticker := time.NewTicker(stateSaveInterval)
+	defer ticker.Stop()
+	var last []byte
+	save := func(ctx context.Context) {
+		state := s.snapshotState()
+		b, err := json.Marshal(state)
+		if err != nil {
+			s.log().Errorf("Error marshaling state: %v", err)
+			return
+		}
+		if bytes.Equal(b, last) {
+			return
+		}
+		state.Time = time.Now()
+		ctx, cancel := context.WithTimeout(ctx, stateTimeout)
+		defer cancel()
+		if err := s.StateStore.SaveState(ctx, s.name(), state); err != nil {
+			s.log().Errorf("Error saving state: %v", err)
+			return
+		}
+		last = b
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			save(context.Background())
+			return
+		case <-ticker.C:
+			save(ctx)
+		}
+	}
+}
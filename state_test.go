@@ -0,0 +1,101 @@
+package targetsync
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryStateStore is a StateStore holding the states in memory
+type memoryStateStore struct {
+	l      sync.Mutex
+	states map[string]*PersistedState
+}
+
+func (m *memoryStateStore) LoadState(_ context.Context, name string) (*PersistedState, error) {
+	m.l.Lock()
+	defer m.l.Unlock()
+	return m.states[name], nil
+}
+
+func (m *memoryStateStore) SaveState(_ context.Context, name string, state *PersistedState) error {
+	m.l.Lock()
+	defer m.l.Unlock()
+	m.states[name] = state
+	return nil
+}
+
+func TestRestoreStateRemovals(t *testing.T) {
+	dst := newmockDestination()
+	stale, later := &Target{IP: "1"}, &Target{IP: "2"}
+	dst.AddTargets(nil, []*Target{stale, later})
+
+	store := &memoryStateStore{states: map[string]*PersistedState{
+		"a": {
+			Removals: []*PendingRemoval{
+				{Target: stale, At: time.Now().Add(-time.Minute)},
+				{Target: later, At: time.Now().Add(time.Hour)},
+			},
+		},
+	}}
+	syncer := &Syncer{
+		Config:     &SyncConfig{LockOptions: LockOptions{Key: "a"}},
+		Dst:        dst,
+		StateStore: store,
+	}
+	syncer.restoreState(context.Background())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go syncer.runRemovalQueue(ctx, make(chan *Target), make(chan *Target))
+
+	// The overdue removal is resumed straight away, the other is kept
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		targets, _ := dst.GetTargets(nil)
+		if err := equalTargets(targets, []*Target{later}); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Restored removal wasn't resumed, destination has %v", targets)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	removals := syncer.pendingRemovals()
+	if len(removals) != 1 || removals[0].Target.Key() != later.Key() {
+		t.Fatalf("Expected only %s to be pending removal, got %v", later.Key(), removals)
+	}
+}
+
+func TestSnapshotStateAdoption(t *testing.T) {
+	adopted := &Target{IP: "1"}
+	syncer := &Syncer{
+		Config: &SyncConfig{
+			LockOptions: LockOptions{Key: "a"},
+			Adopt:       AdoptConfig{Enabled: true},
+		},
+	}
+	syncer.adoptTargets(map[string]*Target{}, map[string]*Target{adopted.IP: adopted})
+	syncer.setLastApplied([]*Target{{IP: "2"}})
+	state := syncer.snapshotState()
+
+	// A new Syncer restoring the state keeps the adopted target, without
+	// adopting again
+	store := &memoryStateStore{states: map[string]*PersistedState{"a": state}}
+	restored := &Syncer{
+		Config:     syncer.Config,
+		StateStore: store,
+	}
+	restored.restoreState(context.Background())
+	if !restored.isAdopted(adopted.IP) {
+		t.Fatalf("Expected %s to be adopted after restoring", adopted.IP)
+	}
+	restored.adoptTargets(map[string]*Target{}, map[string]*Target{"3": {IP: "3"}})
+	if restored.isAdopted("3") {
+		t.Fatalf("Expected targets not to be adopted again after restoring")
+	}
+	if restored.lastApplied == nil || len(restored.lastApplied.Targets) != 1 {
+		t.Fatalf("Expected the last applied targets to be restored, got %+v", restored.lastApplied)
+	}
+}
@@ -0,0 +1,152 @@
+package targetsync
+
+import "time"
+
+// healthStateUnknown is the state used for targets without health
+const healthStateUnknown = "unknown"
+
+// heartbeatInterval is how often the Syncer loops record a heartbeat
+const heartbeatInterval = time.Second
+
+// SyncerStatus is a point in time view of a Syncer
+type SyncerStatus struct {
+	// Name of the sync pair
+	Name string `json:"name"`
+	// Key is the lock key of the sync pair
+	Key     string `json:"key"`
+	Started bool   `json:"started"`
+	Leader  bool   `json:"leader"`
+	// State of the Syncer's Run loop
+	State SyncerState `json:"state"`
+	// SourceError is set if the source reports itself unhealthy
+	SourceError string `json:"source_error,omitempty"`
+	// LastSync is the time of the last full diff of the destination
+	LastSync time.Time `json:"last_sync,omitempty"`
+	// Targets in the destination (with their health) as of LastSync
+	Targets []*Target `json:"targets"`
+	// Registrations are when the targets in the destination were added and
+	// last seen, by target key. Only set while leader.
+	Registrations map[string]*TargetRegistration `json:"registrations,omitempty"`
+	// MutationsPaused is set while mutations are paused by the mutation
+	// budget, until resumed
+	MutationsPaused bool `json:"mutations_paused,omitempty"`
+	// Standby is whether this process is a healthy standby, ready to take
+	// over if the leader goes away
+	Standby bool `json:"standby"`
+	// LastLockAttempt is the last time this process attempted (or checked)
+	// the lock, if the locker reports it
+	LastLockAttempt time.Time `json:"last_lock_attempt,omitempty"`
+	// Standbys is the number of healthy standbys of the lock across all
+	// instances, if standby heartbeats are enabled
+	Standbys *int `json:"standbys,omitempty"`
+}
+
+// Status returns the current status of the Syncer
+func (s *Syncer) Status() SyncerStatus {
+	s.statusLock.Lock()
+	defer s.statusLock.Unlock()
+	status := s.status
+	status.Name = s.name()
+	status.Key = s.Config.LockOptions.Key
+	status.Started = s.Started
+	if checker, ok := s.Src.(HealthChecker); ok {
+		if err := checker.Healthy(); err != nil {
+			status.SourceError = err.Error()
+		}
+	}
+	if reporter, ok := s.Locker.(LockAttemptReporter); ok {
+		status.LastLockAttempt = reporter.LastLockAttempt(&s.Config.LockOptions)
+	}
+	status.Standby = s.standbyHealthy(&status, time.Now())
+	status.MutationsPaused = s.budgetPaused()
+	if s.standbys != nil {
+		standbys := *s.standbys
+		status.Standbys = &standbys
+	}
+	status.Registrations = make(map[string]*TargetRegistration, len(s.registrations))
+	for key, reg := range s.registrations {
+		r := *reg
+		status.Registrations[key] = &r
+	}
+	return status
+}
+
+// Ready returns a channel which is closed once the Syncer has received targets
+// from the source and made its initial attempt to acquire the lock
+func (s *Syncer) Ready() <-chan struct{} {
+	s.statusLock.Lock()
+	defer s.statusLock.Unlock()
+	if s.ready == nil {
+		s.ready = make(chan struct{})
+	}
+	return s.ready
+}
+
+// markReady closes the Ready channel
+func (s *Syncer) markReady() {
+	s.statusLock.Lock()
+	defer s.statusLock.Unlock()
+	if s.ready == nil {
+		s.ready = make(chan struct{})
+	}
+	select {
+	case <-s.ready:
+	default:
+		close(s.ready)
+	}
+}
+
+// Alive returns whether the Syncer's loops (including the leader loop if we
+// are the leader) have recorded a heartbeat within `maxAge`
+func (s *Syncer) Alive(maxAge time.Duration) bool {
+	s.statusLock.Lock()
+	defer s.statusLock.Unlock()
+	now := time.Now()
+	if now.Sub(s.runHeartbeat) > maxAge {
+		return false
+	}
+	return !s.status.Leader || now.Sub(s.leaderHeartbeat) <= maxAge
+}
+
+// beat records a heartbeat of the Run or leader loop
+func (s *Syncer) beat(leader bool) {
+	s.statusLock.Lock()
+	defer s.statusLock.Unlock()
+	if leader {
+		s.leaderHeartbeat = time.Now()
+	} else {
+		s.runHeartbeat = time.Now()
+	}
+}
+
+// observeDestination records the targets fetched from the destination and
+// updates the health metrics
+func (s *Syncer) observeDestination(targets []*Target) {
+	counts := make(map[string]int)
+	for _, target := range targets {
+		state := healthStateUnknown
+		if target.Health != nil && target.Health.State != "" {
+			state = target.Health.State
+		}
+		counts[state]++
+	}
+
+	s.statusLock.Lock()
+	defer s.statusLock.Unlock()
+	s.status.LastSync = time.Now()
+	s.status.Targets = targets
+	s.recordSeen(targets)
+
+	name := s.name()
+	// zero out states which no longer have any targets
+	for state := range s.healthStates {
+		if _, ok := counts[state]; !ok {
+			destinationTargets.WithLabelValues(name, state).Set(0)
+		}
+	}
+	s.healthStates = make(map[string]struct{}, len(counts))
+	for state, count := range counts {
+		destinationTargets.WithLabelValues(name, state).Set(float64(count))
+		s.healthStates[state] = struct{}{}
+	}
+}
@@ -3,52 +3,211 @@ package targetsync
 import (
 	"context"
 	"fmt"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/jacksontj/lane"
 	"github.com/sirupsen/logrus"
 )
 
+// defaultFullSyncInterval is how often a full diff is done for delta sources
+// if `FullSyncInterval` isn't set
+const defaultFullSyncInterval = 5 * time.Minute
+
+const (
+	// defaultRemoveRetryBackoff is the initial backoff for retrying failed
+	// removals if `RemoveRetry.InitialBackoff` isn't set
+	defaultRemoveRetryBackoff = time.Second
+	// defaultRemoveRetryMaxBackoff is the max backoff for retrying failed
+	// removals if `RemoveRetry.MaxBackoff` isn't set
+	defaultRemoveRetryMaxBackoff = time.Minute
+	// defaultRemoveMaxAttempts is how many times a removal is attempted if
+	// `RemoveRetry.MaxAttempts` isn't set
+	defaultRemoveMaxAttempts = 10
+	// defaultRemoveQueueSize is how many removals can be waiting to be
+	// queued if `RemoveQueueSize` isn't set
+	defaultRemoveQueueSize = 100
+)
+
+const (
+	// leaderStartBackoff is the initial time to wait before reacquiring the
+	// lock after the leader actions failed to start
+	leaderStartBackoff = time.Second
+	// leaderStartMaxBackoff is the max time to wait before reacquiring the
+	// lock after the leader actions failed to start
+	leaderStartMaxBackoff = time.Minute
+)
+
 // Syncer is the struct that uses the various interfaces to actually do the sync
 // TODO: metrics
 type Syncer struct {
-	Config    *SyncConfig
-	LocalAddr string
-	Locker    Locker
-	Src       TargetSource
-	Dst       TargetDestination
-	Started   bool
+	// Name of the sync pair, used to label metrics, logs and events.
+	// Defaults to the lock key
+	Name string
+	// Destination optionally identifies the destination in events
+	Destination string
+	Config      *SyncConfig
+	LocalAddr   string
+	Locker      Locker
+	Src         TargetSource
+	Dst         TargetDestination
+	Events      EventSink
+	// Trigger optionally forces a reconcile of the destination
+	Trigger ReconcileTrigger
+	// Hooks optionally add behavior to the sync and leadership changes
+	Hooks *SyncerHooks
+	// Ownership optionally records the owner of the destination, if unset
+	// and the destination is an OwnershipMarker it records its own owner
+	Ownership OwnershipMarker
+	// Logger to use, defaults to the package Logger (see `SetLogger`)
+	Logger Logger
+	// Pool optionally limits destination mutations across multiple Syncers
+	Pool *WorkerPool
+	// Queue optionally runs the reconciles of multiple Syncers on a shared
+	// pool of workers, if unset the Syncer runs its own single worker queue
+	Queue *WorkQueue
+	// State optionally persists the Syncer's runtime state (e.g. pending
+	// removals), restoring it when the Syncer becomes leader
+	StateStore StateStore
+	Started    bool
+
+	// sem limits our concurrent destination mutations to `MaxConcurrency`
+	sem chan struct{}
+
+	statusLock sync.Mutex
+	status     SyncerStatus
+	ready      chan struct{}
+	// heartbeats of the Run and leader loops
+	runHeartbeat    time.Time
+	leaderHeartbeat time.Time
+	// healthStates are the states in the destination_targets metric
+	healthStates map[string]struct{}
+	// standbys is the number of healthy standbys of the lock, as of the
+	// last standby heartbeat
+	standbys *int
+	// registrations of the targets in the destination, by key
+	registrations map[string]*TargetRegistration
+	// skippedRemovals are the IPs of the targets whose skipped removal has
+	// been reported, see `unreportedRemovals`
+	skippedRemovals map[string]struct{}
+
+	adoptLock sync.Mutex
+	adoption  adoption
+
+	budgetLock sync.Mutex
+	budget     budget
+
+	// removals are the targets waiting to be removed by the removalQueue
+	// (set while leader), and lastApplied the source targets of the last
+	// successful full sync
+	removalsLock sync.Mutex
+	removals     map[string]*PendingRemoval
+	removalQueue *DelayQueue
+	lastApplied  *DestinationSnapshot
+
+	// failingSince is the time of the first failed sync since the last
+	// successful one, and failureReported whether EventSyncFailing has been
+	// emitted for it. Guarded by statusLock
+	failingSince    time.Time
+	failureReported bool
+}
+
+// emit sends the event to the configured EventSink
+func (s *Syncer) emit(e Event) {
+	if e.Key == "" {
+		e.Key = s.Config.LockOptions.Key
+	}
+	if e.Name == "" {
+		e.Name = s.name()
+	}
+	if e.Destination == "" {
+		e.Destination = s.Destination
+	}
+	if s.Events == nil {
+		LogEventSink{}.Emit(e)
+		return
+	}
+	s.Events.Emit(e)
+}
+
+// name returns the name of the sync pair, defaulting to the lock key
+func (s *Syncer) name() string {
+	if s.Name != "" {
+		return s.Name
+	}
+	return s.Config.LockOptions.Key
+}
+
+// log returns the Logger to use, with the name of the sync pair as a field if
+// supported
+func (s *Syncer) log() Logger {
+	l := logger
+	if s.Logger != nil {
+		l = s.Logger
+	}
+	if fl, ok := l.(logrus.FieldLogger); ok {
+		return fl.WithField("pair", s.name())
+	}
+	return l
+}
+
+// waitForSource waits until the first targets are received from the source
+func (s *Syncer) waitForSource(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	srcCh, err := s.Src.Subscribe(ctx)
+	if err != nil {
+		return wrapError(ErrSourceUnavailable, err)
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case _, ok := <-srcCh:
+		if !ok {
+			return wrapError(ErrSourceUnavailable, fmt.Errorf("Source channel closed"))
+		}
+	}
+	return nil
 }
 
 // syncSelf simply syncs the LocalAddr from the souce to the target
 func (s *Syncer) syncSelf(ctx context.Context) error {
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
-	logrus.Infof("Local Addr %s -- waiting until added to target", s.LocalAddr)
+	s.log().Infof("Local Addr %s -- waiting until added to target", s.LocalAddr)
 	srcCh, err := s.Src.Subscribe(ctx)
 	if err != nil {
-		return err
+		return wrapError(ErrSourceUnavailable, err)
 	}
 
 	// Now we wait until our IP shows up in the source data, once it does
 	// we add ourselves to the target
 	for {
-		logrus.Debugf("Waiting for targets from source")
+		s.log().Debugf("Waiting for targets from source")
 		var srcTargets []*Target
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
-		case srcTargets = <-srcCh:
+		case targets, ok := <-srcCh:
+			if !ok {
+				return wrapError(ErrSourceUnavailable, fmt.Errorf("Source channel closed"))
+			}
+			srcTargets = targets
 		}
-		logrus.Debugf("Received targets from source: %+#v", srcTargets)
+		s.log().Debugf("Received targets from source: %+#v", srcTargets)
 
 		for _, target := range srcTargets {
 			if target.IP == s.LocalAddr {
 				// try adding ourselves
-				if err := s.Dst.AddTargets(ctx, []*Target{target}); err != nil {
-					return err
+				targets := s.transform([]*Target{target})
+				if len(targets) == 0 {
+					s.log().Infof("Local Addr %s dropped by transforms, not adding to target", s.LocalAddr)
+					return nil
 				}
-				return nil
+				return s.callDestination(ctx, "add_targets", func(ctx context.Context) error {
+					return s.Dst.AddTargets(ctx, targets)
+				})
 			}
 		}
 	}
@@ -57,196 +216,809 @@ func (s *Syncer) syncSelf(ctx context.Context) error {
 // Run is the main method for the syncer. This is responsible for calling
 // runLeader when the lock is held
 func (s *Syncer) Run(ctx context.Context) error {
-	// add ourselves if a LocalAddr was defined
+	if s.Config.RemoveMode == RemoveModeDisable {
+		if _, ok := s.Dst.(TargetAvailabilityDestination); !ok {
+			return wrapError(ErrConfigInvalid, fmt.Errorf("Destination doesn't support remove_mode %q", s.Config.RemoveMode))
+		}
+	}
+	if s.Config.MaxConcurrency > 0 {
+		s.sem = make(chan struct{}, s.Config.MaxConcurrency)
+	}
+	if s.Queue == nil {
+		s.Queue = NewWorkQueue(&WorkQueueConfig{})
+		go s.Queue.Run(ctx)
+	}
+	s.setState(SyncerStateStarting)
+	defer s.setState(SyncerStateStopped)
+
+	// Warm followers share their source subscription with the leader loop
+	if s.Config.Standby.Enabled {
+		if _, ok := s.Src.(*SharedSource); !ok {
+			s.Src = NewSharedSource(s.Src)
+		}
+		go s.runStandby(ctx)
+	}
+
+	// add ourselves if a LocalAddr was defined, otherwise just make sure we
+	// can get targets from the source
 	if s.LocalAddr != "" {
 		if err := s.syncSelf(ctx); err != nil {
 			return err
 		}
+	} else {
+		if err := s.waitForSource(ctx); err != nil {
+			return err
+		}
 	}
 
 	s.Started = true
-	logrus.Debugf("Syncer creating lock: %v", s.Config.LockOptions)
-	electedCh, err := s.Locker.Lock(ctx, &s.Config.LockOptions)
+	lockOpts := s.Config.LockOptions
+	lockOpts.Name = s.name()
+	// lock (re)creates the lock, releasing the previous one
+	var lockCancel context.CancelFunc
+	lock := func() (<-chan bool, error) {
+		if lockCancel != nil {
+			lockCancel()
+		}
+		var lockCtx context.Context
+		lockCtx, lockCancel = context.WithCancel(ctx)
+		s.log().Debugf("Syncer creating lock: %v", lockOpts)
+		return s.Locker.Lock(lockCtx, &lockOpts)
+	}
+	electedCh, err := lock()
+	defer func() { lockCancel() }()
 	if err != nil {
 		return err
 	}
+	s.setState(SyncerStateFollower)
+	s.markReady()
+	s.beat(false)
+	if s.Config.Standby.Heartbeat {
+		go s.runStandbyHeartbeat(ctx)
+	}
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
 
 	var leaderCtx context.Context
 	var leaderCtxCancel context.CancelFunc
+	lockKey := s.Config.LockOptions.Key
+	name := s.name()
+	// relock fires once the lock is to be reacquired, after it was released
+	// because the leader actions failed to start
+	var relock <-chan time.Time
+	startFailures := 0
+
+	// startLeader starts the leader actions, with the fencing token of the
+	// lock if supported, once the leadership change hook has succeeded
+	startLeader := func() error {
+		leaderCtx, leaderCtxCancel = context.WithCancel(ctx)
+		if fencingLocker, ok := s.Locker.(FencingLocker); ok {
+			token, err := fencingLocker.FencingToken(ctx, &s.Config.LockOptions)
+			if err != nil {
+				leaderCtxCancel()
+				leaderCtxCancel = nil
+				return wrapError(ErrLockLost, fmt.Errorf("Unable to get fencing token: %w", err))
+			}
+			s.log().Infof("Lock fencing token: %d", token)
+			leaderCtx = withFencingToken(leaderCtx, token)
+		}
+		if err := s.leadershipChanged(leaderCtx, true); err != nil {
+			leaderCtxCancel()
+			leaderCtxCancel = nil
+			return wrapError(ErrHookRejected, fmt.Errorf("Leadership change hook failed: %w", err))
+		}
+		go s.runLeader(leaderCtx)
+		return nil
+	}
+
+	// stopLeader stops the leader actions, if running
+	stopLeader := func() {
+		if leaderCtxCancel == nil {
+			return
+		}
+		leaderCtxCancel()
+		leaderCtxCancel = nil
+		if err := s.leadershipChanged(ctx, false); err != nil {
+			s.log().Warnf("Error from leadership change hook: %v", err)
+		}
+	}
 
 	for {
 		select {
 		case <-ctx.Done():
-			if leaderCtxCancel != nil {
-				leaderCtxCancel()
-			}
+			stopLeader()
+			lockHeld.WithLabelValues(name).Set(0)
 			return ctx.Err()
+		case <-heartbeat.C:
+			s.beat(false)
+			s.observeStandby()
+			s.observeTargetAges()
+		case <-relock:
+			relock = nil
+			if electedCh, err = lock(); err != nil {
+				return err
+			}
 		case elected, ok := <-electedCh:
 			if !ok {
-				return fmt.Errorf("Lock channel closed")
+				stopLeader()
+				lockHeld.WithLabelValues(name).Set(0)
+				return wrapError(ErrLockLost, fmt.Errorf("Lock channel closed"))
 			}
 			if elected {
-				leaderCtx, leaderCtxCancel = context.WithCancel(ctx)
-				logrus.Infof("Lock acquired, starting leader actions")
-				go s.runLeader(leaderCtx)
-			} else {
-				logrus.Infof("Lock lost, stopping leader actions")
-				if leaderCtxCancel != nil {
-					leaderCtxCancel()
+				// Release the lock if the leader actions can't be started, so
+				// a standby can take over
+				if err := startLeader(); err != nil {
+					startFailures++
+					backoff := leaderStartRetryBackoff(startFailures)
+					s.log().Errorf("Not starting leader actions, releasing the lock and reacquiring it in %v: %v", backoff, err)
+					lockCancel()
+					electedCh = nil
+					relock = time.After(backoff)
+					continue
 				}
+				startFailures = 0
+				lockHeld.WithLabelValues(name).Set(1)
+				s.setState(SyncerStateLeader)
+				s.beat(true)
+				lockAcquiredTimestamp.WithLabelValues(name).SetToCurrentTime()
+				s.emit(Event{
+					Type:    EventLockAcquired,
+					Time:    time.Now(),
+					Message: fmt.Sprintf("Lock %s acquired by %s", lockKey, s.Config.LockOptions.Identity),
+				})
+				s.log().Infof("Lock acquired, starting leader actions")
+			} else if leaderCtxCancel != nil {
+				s.log().Infof("Lock lost, stopping leader actions")
+				lockHeld.WithLabelValues(name).Set(0)
+				s.setState(SyncerStateFollower)
+				s.emit(Event{
+					Type:    EventLockLost,
+					Time:    time.Now(),
+					Message: fmt.Sprintf("Lock %s lost by %s", lockKey, s.Config.LockOptions.Identity),
+				})
+				stopLeader()
 			}
 		}
 	}
 }
 
-// bgRemove is a background goroutine responsible for removing targets from the destination
-// this exists to allow for a `RemoveDelay` on the removal of targets from the destination
-// to avoid issues where a target is "flapping" in the source
-func (s *Syncer) bgRemove(ctx context.Context, removeCh chan *Target, addCh chan *Target) {
-	itemMap := make(map[string]*lane.Item)
-	q := lane.NewPQueue(lane.MINPQ)
+// leaderStartRetryBackoff returns how long to wait before reacquiring the
+// lock after the leader actions failed to start `failures` times in a row,
+// doubling on each failure
+func leaderStartRetryBackoff(failures int) time.Duration {
+	backoff := leaderStartBackoff
+	for i := 1; i < failures && backoff < leaderStartMaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > leaderStartMaxBackoff {
+		backoff = leaderStartMaxBackoff
+	}
+	return backoff
+}
+
+// removeDelay returns how long to wait before removing the target, this is
+// `RemoveDelay` unless overridden by the target's metadata
+func (s *Syncer) removeDelay(target *Target) time.Duration {
+	if v, ok := target.Meta[MetaRemoveDelay]; ok {
+		d, err := time.ParseDuration(v)
+		if err == nil && d >= 0 {
+			return d
+		}
+		s.log().Warnf("Ignoring invalid %s %q on target %v", MetaRemoveDelay, v, target)
+	}
+	return s.Config.RemoveDelay
+}
+
+// removeRetryBackoff returns how long to wait before retrying a removal which
+// has failed `failures` times, doubling on each failure
+func (s *Syncer) removeRetryBackoff(failures int) time.Duration {
+	backoff := s.Config.RemoveRetry.InitialBackoff
+	if backoff <= 0 {
+		backoff = defaultRemoveRetryBackoff
+	}
+	maxBackoff := s.Config.RemoveRetry.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = defaultRemoveRetryMaxBackoff
+	}
+	for i := 1; i < failures && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+	return backoff
+}
+
+// leaderState is the state shared by the leader loops while we hold the lock
+type leaderState struct {
+	// l is held while syncing, as full syncs are run by the work queue's
+	// workers alongside the leader loop applying deltas
+	l        sync.Mutex
+	addCh    chan *Target
+	removeCh chan *Target
+	// known holds the last seen source version of each target by IP
+	known map[string]*Target
+	// aborted holds the keys of targets from aborted rollouts
+	aborted map[string]struct{}
+
+	desiredLock sync.Mutex
+	// desired are the source targets the next full sync reconciles the
+	// destination against
+	desired []*Target
+}
+
+// queueAdd tells the DelayQueue the target is (being) added, cancelling any
+// pending removal of it
+func (st *leaderState) queueAdd(ctx context.Context, target *Target) {
+	select {
+	case st.addCh <- target:
+	case <-ctx.Done():
+	}
+}
+
+// queueRemove schedules the removal of the target by the DelayQueue
+func (st *leaderState) queueRemove(ctx context.Context, target *Target) {
+	select {
+	case st.removeCh <- target:
+	case <-ctx.Done():
+	}
+}
+
+// setDesired sets the targets the next full sync reconciles against
+func (st *leaderState) setDesired(targets []*Target) {
+	st.desiredLock.Lock()
+	defer st.desiredLock.Unlock()
+	st.desired = targets
+}
+
+// enqueueSync queues a full sync of the destination against the targets, if
+// `retry` is set it is rate limited as a retry of a failed sync
+func (s *Syncer) enqueueSync(state *leaderState, targets []*Target, retry bool) {
+	state.setDesired(targets)
+	if retry {
+		s.Queue.AddRateLimited(s.name())
+	} else {
+		s.Queue.Add(s.name())
+	}
+}
+
+// reconcile runs a full sync of the destination against the desired targets,
+// it is run by the work queue which retries it if it fails
+func (s *Syncer) reconcile(ctx context.Context, state *leaderState) error {
+	state.l.Lock()
+	defer state.l.Unlock()
+	// No longer leader, there is nothing to retry
+	if ctx.Err() != nil {
+		return nil
+	}
+	state.desiredLock.Lock()
+	targets := state.desired
+	state.desiredLock.Unlock()
+	return s.syncSnapshot(ctx, targets, state)
+}
+
+// runLeader does the actual syncing from source to destination. This is called
+// after the leader election has been done, there should only be one of these per
+// unique destination running globally. Full syncs are queued on the work queue,
+// which retries them if they fail.
+func (s *Syncer) runLeader(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	if err := s.claimOwnership(ctx); err != nil {
+		s.log().Errorf("Not syncing destination: %v", err)
+		return err
+	}
+
+	queueSize := s.Config.RemoveQueueSize
+	if queueSize <= 0 {
+		queueSize = defaultRemoveQueueSize
+	}
+	state := &leaderState{
+		removeCh: make(chan *Target, queueSize),
+		addCh:    make(chan *Target, queueSize),
+		known:    make(map[string]*Target),
+		aborted:  make(map[string]struct{}),
+	}
+	s.restoreState(ctx)
+	go s.runStateSaver(ctx)
+	go s.runRemovalQueue(ctx, state.removeCh, state.addCh)
+
+	// Full syncs are run (and retried) by the work queue. The channels aren't
+	// closed, as a worker may still be finishing a sync once we return.
+	unregister := s.Queue.register(s.name(), func() error {
+		return s.reconcile(ctx, state)
+	})
+	defer unregister()
+
+	var probe *convergenceProbe
+	if s.Config.Probe.Enabled {
+		probe = newConvergenceProbe(s.name(), s.log())
+		go s.runProbe(ctx, probe)
+	}
+
+	anomalies := newAnomalyDetector(s.Config.Anomaly)
+	expiry := newExpiryTracker(s.log())
+	defer expiry.stop()
+
+	var triggerCh <-chan struct{}
+	if s.Trigger != nil {
+		var err error
+		if triggerCh, err = s.Trigger.Subscribe(ctx); err != nil {
+			return fmt.Errorf("Error subscribing to reconcile trigger: %w", err)
+		}
+	}
+
+	if deltaSrc, ok := s.Src.(TargetDeltaSource); ok {
+		return s.runLeaderDeltas(ctx, deltaSrc, probe, anomalies, expiry, triggerCh, state)
+	}
+
+	// get state from source
+	srcCh, err := s.Src.Subscribe(ctx)
+	if err != nil {
+		return wrapError(ErrSourceUnavailable, err)
+	}
+	srcCh = s.debounce(ctx, s.dampen(ctx, srcCh))
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
 
-	defaultDuration := time.Hour
+	// lastTargets are the last targets from the source, including any which
+	// have since expired
+	var lastTargets []*Target
+	// received is whether any targets have been received from the source
+	received := false
 
-	t := time.NewTimer(defaultDuration)
+	freshness := &sourceFreshness{}
+	// retryCh fires when the source should be resubscribed to
+	var retryCh <-chan time.Time
+
+	// Wait for an update, if we get one sync it
+	s.log().Debugf("Waiting for targets from source")
 	for {
+		var srcTargets []*Target
 		select {
 		case <-ctx.Done():
-			return
-		case toRemove, ok := <-removeCh:
-			if !ok {
+			return ctx.Err()
+		case <-heartbeat.C:
+			s.beat(true)
+			// Sync once the source recovers, or adopted targets are released
+			resumed := s.checkFreshness(freshness)
+			if (!resumed && !s.adoptionReleased()) || !received {
 				continue
 			}
-			logrus.Debugf("Scheduling target for removal from destination in %v: %v", s.Config.RemoveDelay, toRemove)
-			now := time.Now()
-			removeUnixTime := now.Add(s.Config.RemoveDelay).Unix()
-			if headItem, headAt := q.Head(); headItem == nil || removeUnixTime < headAt {
-				if !t.Stop() {
-					select {
-					case <-t.C:
-					default:
-					}
-				}
-				t.Reset(s.Config.RemoveDelay)
+			srcTargets = expiry.live(lastTargets)
+		case <-retryCh:
+			retryCh = nil
+			ch, err := s.Src.Subscribe(ctx)
+			if err != nil {
+				s.log().Warnf("Error resubscribing to source, retrying in %v: %v", s.Config.SourceCache.retryInterval(), err)
+				retryCh = time.After(s.Config.SourceCache.retryInterval())
+				continue
 			}
-			itemMap[toRemove.Key()] = q.Push(toRemove, removeUnixTime)
-		case toAdd, ok := <-addCh:
+			s.log().Infof("Resubscribed to source")
+			srcCh = s.debounce(ctx, s.dampen(ctx, ch))
+			freshness.closed = false
+			continue
+		case targets, ok := <-srcCh:
 			if !ok {
+				if !s.Config.SourceCache.Enabled {
+					return wrapError(ErrSourceUnavailable, fmt.Errorf("Source channel closed"))
+				}
+				s.log().Warnf("Source channel closed, keeping last known targets and resubscribing in %v", s.Config.SourceCache.retryInterval())
+				srcCh = nil
+				freshness.closed = true
+				retryCh = time.After(s.Config.SourceCache.retryInterval())
 				continue
 			}
-			key := toAdd.Key()
-			if item, ok := itemMap[key]; ok {
-				logrus.Debugf("Removing target from removal queue as it was re-added: %v", toAdd)
-				q.Remove(item)
-				delete(itemMap, key)
-			}
-		case <-t.C:
-			// Check if there is an item at head, and if the time is past then
-			// do the removal
-			headItem, headUnixTime := q.Head()
-			logrus.Debugf("Processing target removal: %v", headItem)
-			now := time.Now()
-			nowUnix := now.Unix()
-
-		DELETE_LOOP:
-			for headItem != nil {
-				// If we where woken before something is ready, just reschedule
-				if headUnixTime < nowUnix {
-					break DELETE_LOOP
-				} else {
-					target := headItem.(*Target)
-					if err := s.Dst.RemoveTargets(ctx, []*Target{target}); err == nil {
-						logrus.Debugf("Target removal successful: %v", target)
-						q.Pop()
-						delete(itemMap, target.Key())
-					} else {
-						break DELETE_LOOP
-					}
-				}
-				headItem, headUnixTime = q.Head()
-			}
-			// If there is still an item in the queue, reset the timer
-			if headItem != nil {
-				d := time.Unix(headUnixTime, 0).Sub(now)
-				if !t.Stop() {
-					select {
-					case <-t.C:
-					default:
-					}
-				}
-				t.Reset(d)
+			lastTargets = s.transform(targets)
+			expiry.observeSnapshot(lastTargets)
+			srcTargets = expiry.live(lastTargets)
+			received = true
+		case <-triggerCh:
+			if !received {
+				s.log().Debugf("Ignoring reconcile trigger, no targets received from source yet")
+				continue
 			}
+			s.log().Infof("Reconcile triggered")
+			srcTargets = expiry.live(lastTargets)
+		case <-expiry.C():
+			srcTargets = expiry.live(lastTargets)
+			expiry.reset()
+			s.log().Debugf("Expired %d targets", len(lastTargets)-len(srcTargets))
+		}
+		s.log().Debugf("Received targets from source: %+#v", srcTargets)
+		if freshness.paused {
+			s.log().Debugf("Not syncing, source is stale")
+			continue
+		}
+		if s.checkAnomaly(anomalies, srcTargets) {
+			s.log().Debugf("Waiting for targets from source")
+			continue
 		}
+		if probe != nil {
+			probe.observeSource(srcTargets)
+		}
+
+		s.enqueueSync(state, srcTargets, false)
+		s.log().Debugf("Waiting for targets from source")
 	}
 }
 
-// runLeader does the actual syncing from source to destination. This is called
-// after the leader election has been done, there should only be one of these per
-// unique destination running globally
-func (s *Syncer) runLeader(ctx context.Context) error {
-	ctx, cancel := context.WithCancel(ctx)
-	defer cancel()
+// runLeaderDeltas is the runLeader loop for sources which emit deltas. Deltas
+// are applied directly to the destination, with a full diff of the accumulated
+// source state against the destination every `FullSyncInterval`
+func (s *Syncer) runLeaderDeltas(ctx context.Context, src TargetDeltaSource, probe *convergenceProbe, anomalies *anomalyDetector, expiry *expiryTracker, triggerCh <-chan struct{}, state *leaderState) error {
+	deltaCh, err := src.SubscribeDeltas(ctx)
+	if err != nil {
+		return wrapError(ErrSourceUnavailable, err)
+	}
+	deltaCh = s.dampenDeltas(ctx, deltaCh)
 
-	removeCh := make(chan *Target, 100)
-	addCh := make(chan *Target, 100)
-	defer close(removeCh)
-	defer close(addCh)
-	go s.bgRemove(ctx, removeCh, addCh)
+	interval := s.Config.FullSyncInterval
+	if interval <= 0 {
+		interval = defaultFullSyncInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
 
-	// get state from source
-	srcCh, err := s.Src.Subscribe(ctx)
-	if err != nil {
-		return err
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	srcMap := make(map[string]*Target)
+	// blocked is whether the source target count is currently anomalous
+	blocked := false
+	freshness := &sourceFreshness{}
+	// retryCh fires when the source should be resubscribed to
+	var retryCh <-chan time.Time
+	// subscribed is whether the next delta is the complete set of targets
+	// from a new subscription, which the first one is too
+	subscribed := true
+	// snapshot returns the accumulated source state
+	snapshot := func() []*Target {
+		srcTargets := make([]*Target, 0, len(srcMap))
+		for _, target := range srcMap {
+			srcTargets = append(srcTargets, target)
+		}
+		return srcTargets
+	}
+	// fullSync queues a diff of the accumulated source state against the
+	// destination
+	fullSync := func(reason string) {
+		if blocked {
+			s.log().Debugf("Skipping %s full sync, source target count is anomalous", reason)
+			return
+		}
+		if freshness.paused {
+			s.log().Debugf("Skipping %s full sync, source is stale", reason)
+			return
+		}
+		srcTargets := snapshot()
+		s.log().Debugf("Queueing %s full sync of %d targets", reason, len(srcTargets))
+		s.enqueueSync(state, srcTargets, false)
 	}
 
-	// Wait for an update, if we get one sync it
+	s.log().Debugf("Waiting for deltas from source")
 	for {
-		logrus.Debugf("Waiting for targets from source")
-		var srcTargets []*Target
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
-		case srcTargets = <-srcCh:
+		case <-heartbeat.C:
+			s.beat(true)
+			reason := ""
+			if s.checkFreshness(freshness) {
+				reason = "recovered"
+			} else if s.adoptionReleased() {
+				reason = "adoption released"
+			}
+			if reason == "" {
+				continue
+			}
+			fullSync(reason)
+		case <-retryCh:
+			retryCh = nil
+			ch, err := src.SubscribeDeltas(ctx)
+			if err != nil {
+				s.log().Warnf("Error resubscribing to source, retrying in %v: %v", s.Config.SourceCache.retryInterval(), err)
+				retryCh = time.After(s.Config.SourceCache.retryInterval())
+				continue
+			}
+			s.log().Infof("Resubscribed to source")
+			deltaCh = s.dampenDeltas(ctx, ch)
+			subscribed = true
+			freshness.closed = false
+			continue
+		case <-ticker.C:
+			fullSync("periodic")
+		case <-triggerCh:
+			s.log().Infof("Reconcile triggered")
+			fullSync("triggered")
+		case <-expiry.C():
+			// Expired targets are removed as if the source removed them
+			expired := false
+			state.l.Lock()
+			for _, ip := range expiry.expire() {
+				target, ok := srcMap[ip]
+				if !ok {
+					continue
+				}
+				s.log().Debugf("Target expired: %v", target)
+				delete(srcMap, ip)
+				delete(state.aborted, target.Key())
+				if !blocked && !freshness.paused {
+					state.queueRemove(ctx, withRemovalReason(target, RemovalExpired))
+					expired = true
+				}
+			}
+			state.l.Unlock()
+			if expired {
+				state.setDesired(snapshot())
+			}
+		case delta, ok := <-deltaCh:
+			if !ok {
+				if !s.Config.SourceCache.Enabled {
+					return wrapError(ErrSourceUnavailable, fmt.Errorf("Source channel closed"))
+				}
+				s.log().Warnf("Source channel closed, keeping last known targets and resubscribing in %v", s.Config.SourceCache.retryInterval())
+				deltaCh = nil
+				freshness.closed = true
+				retryCh = time.After(s.Config.SourceCache.retryInterval())
+				continue
+			}
+			delta = &TargetDelta{
+				Added: s.transform(delta.Added),
+				// Removals aren't filtered by zone or meta, the removed
+				// targets may not carry their meta
+				Removed: s.rewrite(delta.Removed),
+			}
+			s.log().Debugf("Received delta from source: %+#v", delta)
+			s.forgetAdopted(delta.Added)
+
+			// The first delta of a new subscription replaces the cached
+			// targets, and is diffed against the destination
+			if subscribed {
+				subscribed = false
+				srcMap = make(map[string]*Target, len(delta.Added))
+				for _, target := range delta.Added {
+					srcMap[target.IP] = target
+				}
+				expiry.observe(delta.Added)
+				if !freshness.paused {
+					srcTargets := snapshot()
+					if blocked = s.checkAnomaly(anomalies, srcTargets); !blocked && probe != nil {
+						probe.observeSource(srcTargets)
+					}
+				}
+				fullSync("subscribed")
+				break
+			}
+
+			state.l.Lock()
+			for _, target := range delta.Removed {
+				delete(srcMap, target.IP)
+				delete(state.aborted, target.Key())
+				expiry.forget(target)
+			}
+			state.l.Unlock()
+			for _, target := range delta.Added {
+				srcMap[target.IP] = target
+			}
+			expiry.observe(delta.Added)
+			srcTargets := snapshot()
+			// If paused the delta is dropped, once the source recovers a
+			// full sync catches the destination up
+			if freshness.paused {
+				break
+			}
+			// If blocked the delta is dropped, once unblocked a full sync
+			// catches the destination up
+			wasBlocked := blocked
+			if blocked = s.checkAnomaly(anomalies, srcTargets); blocked {
+				break
+			}
+			if probe != nil {
+				probe.observeSource(srcTargets)
+			}
+			if wasBlocked {
+				s.enqueueSync(state, srcTargets, false)
+				break
+			}
+
+			// A failed delta is retried as a full sync, so the destination
+			// catches up with any deltas received in the meantime
+			if err := s.applyDelta(ctx, delta, srcTargets, len(srcMap)-len(delta.Added), state); err != nil {
+				s.log().Warnf("Error applying delta, queueing a full sync: %v", err)
+				s.enqueueSync(state, srcTargets, true)
+			}
 		}
-		logrus.Debugf("Received targets from source: %+#v", srcTargets)
+		s.log().Debugf("Waiting for deltas from source")
+	}
+}
+
+// applyDelta adds the delta's added targets to the destination and schedules
+// the removal of its removed targets
+func (s *Syncer) applyDelta(ctx context.Context, delta *TargetDelta, srcTargets []*Target, unchanged int, state *leaderState) (err error) {
+	state.l.Lock()
+	defer state.l.Unlock()
+	// Any full sync from now on reconciles against the delta too
+	state.setDesired(srcTargets)
+	if !s.beforeSync(ctx, srcTargets) || s.budgetPausedSync() {
+		return nil
+	}
+	start := time.Now()
+	result := &SyncResult{
+		Added:     delta.Added,
+		Removed:   delta.Removed,
+		Unchanged: unchanged,
+	}
+	defer func() {
+		result.Duration = time.Since(start)
+		result.Err = err
+		s.afterSync(ctx, result)
+	}()
 
-		// get current ones from dst
-		dstTargets, err := s.Dst.GetTargets(ctx)
-		if err != nil {
+	if len(delta.Added) > 0 {
+		for _, target := range delta.Added {
+			state.queueAdd(ctx, target)
+		}
+		s.log().Debugf("Adding targets to destination: %v", delta.Added)
+		if err := s.rolloutTargets(ctx, delta.Added, state); err != nil {
 			return err
 		}
-		logrus.Debugf("Fetched targets from destination: %+#v", dstTargets)
+	}
+	if err := s.waitReplacements(ctx, delta.Added, delta.Removed, state); err != nil {
+		return err
+	}
+	for _, target := range delta.Removed {
+		state.queueRemove(ctx, withRemovalReason(target, s.absentReason(target)))
+	}
+	s.logSummary(delta.Added, delta.Removed, unchanged, start)
+	return nil
+}
 
-		// TODO: compare ports and do something with them
-		srcMap := make(map[string]*Target)
-		for _, target := range srcTargets {
-			srcMap[target.IP] = target
-		}
-		dstMap := make(map[string]*Target)
-		for _, target := range dstTargets {
-			dstMap[target.IP] = target
+// maxSummaryTargets is the max number of targets named in a sync summary
+const maxSummaryTargets = 10
+
+// summarizeTargets returns the names of the targets, truncated to
+// `maxSummaryTargets`
+func summarizeTargets(targets []*Target) string {
+	names := make([]string, 0, maxSummaryTargets+1)
+	for i, target := range targets {
+		if i == maxSummaryTargets {
+			names = append(names, fmt.Sprintf("+%d more", len(targets)-i))
+			break
 		}
+		names = append(names, target.displayName())
+	}
+	return "[" + strings.Join(names, " ") + "]"
+}
+
+// logSummary logs a single line summary of the changes made by a sync
+func (s *Syncer) logSummary(added, removed []*Target, unchanged int, start time.Time) {
+	msg := fmt.Sprintf("Synced added=%d removed=%d unchanged=%d duration=%v", len(added), len(removed), unchanged, time.Since(start).Round(time.Millisecond))
+	if len(added) > 0 {
+		msg += " added_targets=" + summarizeTargets(added)
+	}
+	if len(removed) > 0 {
+		msg += " removed_targets=" + summarizeTargets(removed)
+	}
+	s.log().Infof("%s", msg)
+}
 
-		// Add hosts first
-		hostsToAdd := make([]*Target, 0)
-		for ip, target := range srcMap {
-			if _, ok := dstMap[ip]; !ok {
-				hostsToAdd = append(hostsToAdd, target)
-				addCh <- target
+// syncSnapshot diffs the full set of source targets against the destination
+// adding any missing targets and scheduling the removal of extra ones
+func (s *Syncer) syncSnapshot(ctx context.Context, srcTargets []*Target, state *leaderState) (err error) {
+	if !s.beforeSync(ctx, srcTargets) || s.budgetPausedSync() {
+		return nil
+	}
+	start := time.Now()
+	result := &SyncResult{Full: true}
+	defer func() {
+		result.Duration = time.Since(start)
+		result.Err = err
+		s.afterSync(ctx, result)
+	}()
+
+	// get current ones from dst
+	dstTargets, err := s.getTargets(ctx)
+	if err != nil {
+		return err
+	}
+	s.log().Debugf("Fetched targets from destination: %+#v", dstTargets)
+	s.observeDestination(dstTargets)
+	s.reconcileSettings(ctx)
+
+	// TODO: compare ports and do something with them
+	srcMap := make(map[string]*Target, len(srcTargets))
+	for _, target := range srcTargets {
+		srcMap[target.IP] = target
+		state.known[target.IP] = target
+	}
+	// Targets from aborted rollouts may be added again once they have been
+	// removed from the source
+	if len(state.aborted) > 0 {
+		srcKeys := make(map[string]struct{}, len(srcTargets))
+		for _, target := range srcTargets {
+			srcKeys[target.Key()] = struct{}{}
+		}
+		for key := range state.aborted {
+			if _, ok := srcKeys[key]; !ok {
+				delete(state.aborted, key)
 			}
 		}
-		if len(hostsToAdd) > 0 {
-			logrus.Debugf("Adding targets to destination: %v", hostsToAdd)
-			if err := s.Dst.AddTargets(ctx, hostsToAdd); err != nil {
-				return err
+	}
+	dstMap := make(map[string]*Target, len(dstTargets))
+	for _, target := range dstTargets {
+		dstMap[target.IP] = target
+	}
+	// Targets registered in only part of the destination aren't returned by
+	// GetTargets, so are added if in the source, and must be removed if not
+	if partial, ok := s.Dst.(PartialDestination); ok {
+		for _, target := range partial.PartialTargets() {
+			if _, ok := srcMap[target.IP]; !ok {
+				dstMap[target.IP] = target
 			}
 		}
+	}
+	s.adoptTargets(srcMap, dstMap)
+	s.releaseCancelledRemovals(srcMap)
+	s.forgetSkippedRemovals(srcMap, dstMap)
+
+	// Refresh the registrations of targets staying in the destination
+	var hostsToRefresh []*Target
+	for ip, target := range srcMap {
+		if _, ok := dstMap[ip]; ok {
+			hostsToRefresh = append(hostsToRefresh, target)
+		}
+	}
+	if stale := s.staleRegistrations(hostsToRefresh); len(stale) > 0 {
+		if err := s.refreshRegistrations(ctx, stale); err != nil {
+			return err
+		}
+	}
 
-		// Remove hosts last
-		for ip, target := range dstMap {
-			if _, ok := srcMap[ip]; !ok {
-				removeCh <- target
+	// Add hosts first
+	hostsToAdd := make([]*Target, 0)
+	for ip, target := range srcMap {
+		if _, ok := dstMap[ip]; !ok {
+			hostsToAdd = append(hostsToAdd, target)
+			state.queueAdd(ctx, target)
+		}
+	}
+	result.Added = hostsToAdd
+	if len(hostsToAdd) > 0 {
+		s.log().Debugf("Adding targets to destination: %v", hostsToAdd)
+		if err := s.rolloutTargets(ctx, hostsToAdd, state); err != nil {
+			return err
+		}
+	}
+
+	// Remove hosts last
+	var hostsToRemove []*Target
+	for ip, target := range dstMap {
+		if _, ok := srcMap[ip]; !ok {
+			if s.isAdopted(ip) {
+				continue
+			}
+			// Use the target as last seen in the source, if we have, as
+			// the destination doesn't carry the source metadata
+			if known, ok := state.known[ip]; ok {
+				target = known
+				delete(state.known, ip)
 			}
+			hostsToRemove = append(hostsToRemove, target)
 		}
 	}
+	if err := s.waitReplacements(ctx, hostsToAdd, hostsToRemove, state); err != nil {
+		return err
+	}
+	for _, target := range hostsToRemove {
+		state.queueRemove(ctx, withRemovalReason(target, s.absentReason(target)))
+	}
+	result.Removed = hostsToRemove
+	result.Unchanged = len(dstMap) - len(hostsToRemove)
+	s.logSummary(hostsToAdd, hostsToRemove, result.Unchanged, start)
+	s.setLastApplied(srcTargets)
+	return nil
 }
@@ -3,6 +3,8 @@ package targetsync
 import (
 	"context"
 	"fmt"
+	"io/ioutil"
+	"strings"
 	"testing"
 	"time"
 
@@ -89,3 +91,589 @@ func TestSyncer(t *testing.T) {
 		t.Fatalf("Mismatch in targets err=%v expected=%+v actual=%+v", err, empty, tgts)
 	}
 }
+
+func TestSyncerDeltas(t *testing.T) {
+	cfg := &SyncConfig{
+		LockOptions: LockOptions{
+			Key: "a",
+			TTL: time.Second,
+		},
+		RemoveDelay:      time.Second,
+		FullSyncInterval: time.Second,
+	}
+
+	src := newmockDeltaSource()
+	dst := newmockDestination()
+	syncer := &Syncer{
+		Config: cfg,
+		Locker: &mockLocker{},
+		Src:    src,
+		Dst:    dst,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go syncer.Run(ctx)
+
+	targets := []*Target{
+		{IP: "1"},
+		{IP: "2"},
+	}
+	src.ch <- targets
+	time.Sleep(time.Second)
+
+	tgts, _ := dst.GetTargets(nil)
+	if err := equalTargets(targets, tgts); err != nil {
+		t.Fatalf("Mismatch in targets err=%v expected=%+v actual=%+v", err, targets, tgts)
+	}
+
+	// Targets added to the destination out of band are removed by the full sync
+	dst.AddTargets(nil, []*Target{{IP: "3"}})
+	removed := []*Target{targets[0]}
+	src.ch <- removed
+	time.Sleep(time.Second * 3)
+
+	tgts, _ = dst.GetTargets(nil)
+	if err := equalTargets(removed, tgts); err != nil {
+		t.Fatalf("Mismatch in targets err=%v expected=%+v actual=%+v", err, removed, tgts)
+	}
+}
+
+func TestSyncerDeltasInitialSync(t *testing.T) {
+	cfg := &SyncConfig{
+		LockOptions: LockOptions{
+			Key: "a",
+			TTL: time.Second,
+		},
+		RemoveDelay:      time.Second,
+		FullSyncInterval: time.Hour,
+	}
+
+	src := newmockDeltaSource()
+	dst := newmockDestination()
+	dst.AddTargets(nil, []*Target{{IP: "3"}})
+	syncer := &Syncer{
+		Config: cfg,
+		Locker: &mockLocker{},
+		Src:    src,
+		Dst:    dst,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go syncer.Run(ctx)
+
+	// The first targets are diffed against the destination, removing the
+	// targets which aren't in the source
+	targets := []*Target{
+		{IP: "1"},
+		{IP: "2"},
+	}
+	src.ch <- targets
+	time.Sleep(time.Second * 3)
+
+	tgts, _ := dst.GetTargets(nil)
+	if err := equalTargets(targets, tgts); err != nil {
+		t.Fatalf("Mismatch in targets err=%v expected=%+v actual=%+v", err, targets, tgts)
+	}
+}
+
+func TestSyncerTrigger(t *testing.T) {
+	cfg := &SyncConfig{
+		LockOptions: LockOptions{
+			Key: "a",
+			TTL: time.Second,
+		},
+		RemoveDelay: time.Second,
+	}
+
+	src := newmockSource()
+	dst := newmockDestination()
+	trigger := &mockTrigger{ch: make(chan struct{})}
+	syncer := &Syncer{
+		Config:  cfg,
+		Locker:  &mockLocker{},
+		Src:     src,
+		Dst:     dst,
+		Trigger: trigger,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go syncer.Run(ctx)
+
+	targets := []*Target{
+		{IP: "1"},
+		{IP: "2"},
+	}
+	src.ch <- targets
+	time.Sleep(time.Second)
+
+	// Targets added to the destination out of band are removed once triggered
+	dst.AddTargets(nil, []*Target{{IP: "3"}})
+	trigger.ch <- struct{}{}
+	time.Sleep(time.Second * 2)
+
+	tgts, _ := dst.GetTargets(nil)
+	if err := equalTargets(targets, tgts); err != nil {
+		t.Fatalf("Mismatch in targets err=%v expected=%+v actual=%+v", err, targets, tgts)
+	}
+}
+
+func TestSyncerHooks(t *testing.T) {
+	cfg := &SyncConfig{
+		LockOptions: LockOptions{
+			Key: "a",
+			TTL: time.Second,
+		},
+		RemoveDelay: time.Second,
+	}
+
+	leaderCh := make(chan bool, 1)
+	resultCh := make(chan *SyncResult, 10)
+	src := newmockSource()
+	dst := newmockDestination()
+	syncer := &Syncer{
+		Config: cfg,
+		Locker: &mockLocker{},
+		Src:    src,
+		Dst:    dst,
+		Hooks: &SyncerHooks{
+			OnLeadershipChange: func(_ context.Context, leader bool) error {
+				leaderCh <- leader
+				return nil
+			},
+			AfterSync: func(_ context.Context, result *SyncResult) {
+				resultCh <- result
+			},
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go syncer.Run(ctx)
+
+	targets := []*Target{
+		{IP: "1"},
+		{IP: "2"},
+	}
+	src.ch <- targets
+
+	select {
+	case leader := <-leaderCh:
+		if !leader {
+			t.Fatalf("Expected leadership to be acquired")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Leadership change hook not called")
+	}
+	select {
+	case result := <-resultCh:
+		if !result.Full || result.Err != nil || len(result.Added) != len(targets) {
+			t.Fatalf("Unexpected sync result: %+v", result)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("AfterSync hook not called")
+	}
+	if state := syncer.State(); state != SyncerStateLeader {
+		t.Fatalf("Unexpected state: %s", state)
+	}
+}
+
+// failingFencingLocker is a testLocker whose fencing token can't be fetched
+// the first `failures` times
+type failingFencingLocker struct {
+	*testLocker
+	failures int
+}
+
+func (m *failingFencingLocker) FencingToken(context.Context, *LockOptions) (uint64, error) {
+	m.l.Lock()
+	defer m.l.Unlock()
+	if m.failures > 0 {
+		m.failures--
+		return 0, fmt.Errorf("fencing token unavailable")
+	}
+	return uint64(m.calls), nil
+}
+
+func TestSyncerFencingTokenFailure(t *testing.T) {
+	locker := &failingFencingLocker{testLocker: newTestLocker(), failures: 1}
+	events := make(chanSink, 100)
+	src := newmockSource()
+	syncer := &Syncer{
+		Config: &SyncConfig{
+			LockOptions: LockOptions{Key: "fencing-failure", TTL: time.Second},
+		},
+		Locker: locker,
+		Src:    src,
+		Dst:    newmockDestination(),
+		Events: events,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go syncer.Run(ctx)
+	src.ch <- []*Target{{IP: "1"}}
+	waitFor(t, "the lock to be requested", func() bool {
+		calls, _ := locker.state()
+		return calls == 1
+	})
+
+	// Without a fencing token the lock is released rather than held without
+	// leading
+	locker.send(true)
+	waitFor(t, "the lock to be released", func() bool {
+		_, running := locker.state()
+		return !running
+	})
+	if state := syncer.State(); state != SyncerStateFollower {
+		t.Fatalf("Unexpected state without a fencing token: %s", state)
+	}
+	for len(events) > 0 {
+		if e := <-events; e.Type == EventLockAcquired {
+			t.Fatalf("Lock reported acquired without a fencing token")
+		}
+	}
+
+	// The lock is reacquired after a backoff
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		if calls, running := locker.state(); calls == 2 && running {
+			break
+		}
+		if time.Since(start) > 3*time.Second {
+			t.Fatalf("Timed out waiting for the lock to be reacquired")
+		}
+	}
+	locker.send(true)
+	waitForEvent(t, events, EventLockAcquired)
+	if state := syncer.State(); state != SyncerStateLeader {
+		t.Fatalf("Unexpected state once leading: %s", state)
+	}
+}
+
+func TestSyncerLeadershipHookFailure(t *testing.T) {
+	locker := newTestLocker()
+	hookCalls := make(chan bool, 10)
+	failures := 1
+	src := newmockSource()
+	syncer := &Syncer{
+		Config: &SyncConfig{
+			LockOptions: LockOptions{Key: "hook-failure", TTL: time.Second},
+		},
+		Locker: locker,
+		Src:    src,
+		Dst:    newmockDestination(),
+		Hooks: &SyncerHooks{
+			OnLeadershipChange: func(_ context.Context, leader bool) error {
+				hookCalls <- leader
+				if leader && failures > 0 {
+					failures--
+					return fmt.Errorf("not ready")
+				}
+				return nil
+			},
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go syncer.Run(ctx)
+	src.ch <- []*Target{{IP: "1"}}
+	waitFor(t, "the lock to be requested", func() bool {
+		calls, _ := locker.state()
+		return calls == 1
+	})
+
+	// A failing hook releases the lock rather than holding it without
+	// leading
+	locker.send(true)
+	waitFor(t, "the lock to be released", func() bool {
+		_, running := locker.state()
+		return !running
+	})
+	if state := syncer.State(); state != SyncerStateFollower {
+		t.Fatalf("Unexpected state after the hook failed: %s", state)
+	}
+
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		if calls, running := locker.state(); calls == 2 && running {
+			break
+		}
+		if time.Since(start) > 3*time.Second {
+			t.Fatalf("Timed out waiting for the lock to be reacquired")
+		}
+	}
+	locker.send(true)
+	waitFor(t, "leadership", func() bool {
+		return syncer.State() == SyncerStateLeader
+	})
+	// The hook isn't told leadership was lost when it never started
+	var calls []bool
+	for len(hookCalls) > 0 {
+		calls = append(calls, <-hookCalls)
+	}
+	if len(calls) != 2 || !calls[0] || !calls[1] {
+		t.Fatalf("Unexpected leadership change hook calls: %v", calls)
+	}
+}
+
+type chanSink chan Event
+
+func (c chanSink) Emit(e Event) {
+	c <- e
+}
+
+func TestRemoveRetryBackoff(t *testing.T) {
+	syncer := &Syncer{Config: &SyncConfig{
+		RemoveRetry: RemoveRetryConfig{
+			InitialBackoff: time.Second,
+			MaxBackoff:     5 * time.Second,
+		},
+	}}
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i, backoff := range expected {
+		if actual := syncer.removeRetryBackoff(i + 1); actual != backoff {
+			t.Fatalf("Unexpected backoff after %d failures expected=%v actual=%v", i+1, backoff, actual)
+		}
+	}
+}
+
+func TestRemoveDeadLetter(t *testing.T) {
+	events := make(chanSink, 10)
+	syncer := &Syncer{
+		Config: &SyncConfig{
+			LockOptions: LockOptions{Key: "a"},
+			RemoveRetry: RemoveRetryConfig{MaxAttempts: 2},
+		},
+		Dst:    newmockDestination(),
+		Events: events,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	removeCh := make(chan *Target)
+	go syncer.runRemovalQueue(ctx, removeCh, make(chan *Target))
+
+	// The mock destination fails to remove targets it doesn't have
+	removeCh <- &Target{IP: "1"}
+	select {
+	case e := <-events:
+		if e.Type != EventRemovalFailed || len(e.Targets) != 1 {
+			t.Fatalf("Unexpected event: %+v", e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Removal was not given up on")
+	}
+}
+
+func TestRemoveModeNone(t *testing.T) {
+	events := make(chanSink, 10)
+	dst := newmockDestination()
+	syncer := &Syncer{
+		Config: &SyncConfig{
+			LockOptions: LockOptions{Key: "a"},
+			RemoveMode:  RemoveModeNone,
+		},
+		Dst:    dst,
+		Events: events,
+	}
+
+	targets := []*Target{{IP: "1"}}
+	dst.AddTargets(nil, targets)
+	if err := syncer.removeTargets(context.Background(), targets); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if e := <-events; e.Type != EventRemovalSkipped || len(e.Targets) != 1 {
+		t.Fatalf("Unexpected event: %+v", e)
+	}
+	tgts, _ := dst.GetTargets(nil)
+	if err := equalTargets(targets, tgts); err != nil {
+		t.Fatalf("Target was removed: %v", err)
+	}
+
+	// Removals already reported aren't reported again, until the target is
+	// back in the source
+	added := append(targets, &Target{IP: "2"})
+	dst.AddTargets(nil, added[1:])
+	if err := syncer.removeTargets(context.Background(), added); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if e := <-events; e.Type != EventRemovalSkipped || len(e.Targets) != 1 || e.Targets[0].IP != "2" {
+		t.Fatalf("Unexpected event: %+v", e)
+	}
+	syncer.forgetSkippedRemovals(map[string]*Target{"1": targets[0]}, map[string]*Target{"1": targets[0], "2": added[1]})
+	if err := syncer.removeTargets(context.Background(), added); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if e := <-events; e.Type != EventRemovalSkipped || len(e.Targets) != 1 || e.Targets[0].IP != "1" {
+		t.Fatalf("Unexpected event: %+v", e)
+	}
+	select {
+	case e := <-events:
+		t.Fatalf("Unexpected event: %+v", e)
+	default:
+	}
+}
+
+func TestRemovalReason(t *testing.T) {
+	events := make(chanSink, 10)
+	dst := newmockDestination()
+	syncer := &Syncer{
+		Config: &SyncConfig{LockOptions: LockOptions{Key: "a"}},
+		Dst:    dst,
+		Events: events,
+	}
+
+	target := &Target{IP: "1"}
+	removed := withRemovalReason(target, RemovalExpired)
+	if target.Meta != nil {
+		t.Fatalf("Removal reason modified the target: %v", target.Meta)
+	}
+	targets := []*Target{removed, withRemovalReason(&Target{IP: "2"}, RemovalAbsentFromSource), {IP: "3"}}
+	dst.AddTargets(nil, targets)
+	if err := syncer.removeTargets(context.Background(), targets); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	e := <-events
+	if e.Type != EventTargetsRemoved || e.Targets[0].Meta[MetaRemovalReason] != string(RemovalExpired) {
+		t.Fatalf("Unexpected event: %+v", e)
+	}
+	if !strings.Contains(e.Message, "(absent_from_source=1 expired=1 unknown=1)") {
+		t.Fatalf("Expected reasons in message: %s", e.Message)
+	}
+}
+
+func TestSummarizeTargets(t *testing.T) {
+	targets := []*Target{
+		{IP: "1", Port: 80, Meta: map[string]string{MetaHostname: "a"}},
+		{IP: "2", Port: 80},
+	}
+	if summary := summarizeTargets(targets); summary != "[a(1:80) 2:80]" {
+		t.Fatalf("Unexpected summary: %s", summary)
+	}
+
+	for i := 0; i < maxSummaryTargets; i++ {
+		targets = append(targets, &Target{IP: fmt.Sprintf("10.0.0.%d", i)})
+	}
+	if summary := summarizeTargets(targets); !strings.HasSuffix(summary, " +2 more]") {
+		t.Fatalf("Expected summary to be truncated: %s", summary)
+	}
+}
+
+func TestChunkTargets(t *testing.T) {
+	targets := make([]*Target, 5)
+	for i := range targets {
+		targets[i] = &Target{IP: fmt.Sprintf("10.0.0.%d", i)}
+	}
+	for _, c := range []struct{ size, chunks, last int }{
+		{2, 3, 1},
+		{5, 1, 5},
+		{10, 1, 5},
+	} {
+		chunks := chunkTargets(targets, c.size)
+		if len(chunks) != c.chunks || len(chunks[len(chunks)-1]) != c.last {
+			t.Fatalf("Unexpected chunks of size %d: %v", c.size, chunks)
+		}
+	}
+	if chunks := chunkTargets(nil, 2); len(chunks) != 0 {
+		t.Fatalf("Expected no chunks: %v", chunks)
+	}
+
+	// Appending to a chunk must not overwrite the next one
+	chunks := chunkTargets(targets, 2)
+	_ = append(chunks[0], &Target{IP: "other"})
+	if chunks[1][0] != targets[2] {
+		t.Fatalf("Appending to a chunk modified the next one")
+	}
+}
+
+// staticDestination always returns the same targets, ignoring changes
+type staticDestination []*Target
+
+func (d staticDestination) GetTargets(context.Context) ([]*Target, error)  { return d, nil }
+func (d staticDestination) AddTargets(context.Context, []*Target) error    { return nil }
+func (d staticDestination) RemoveTargets(context.Context, []*Target) error { return nil }
+
+func BenchmarkSyncSnapshot(b *testing.B) {
+	const size, changed = 5000, 50
+	srcTargets := make([]*Target, size)
+	dstTargets := make([]*Target, size)
+	for i := 0; i < size; i++ {
+		srcTargets[i] = &Target{IP: fmt.Sprintf("10.0.%d.%d", i/256, i%256), Port: 80}
+		dstTargets[i] = &Target{IP: fmt.Sprintf("10.0.%d.%d", (i+changed)/256, (i+changed)%256), Port: 80}
+	}
+
+	l := logrus.New()
+	l.Out = ioutil.Discard
+	s := &Syncer{
+		Config: &SyncConfig{LockOptions: LockOptions{Key: "a"}},
+		Dst:    staticDestination(dstTargets),
+		Logger: l,
+	}
+	state := &leaderState{
+		addCh:    make(chan *Target, changed),
+		removeCh: make(chan *Target, changed),
+		known:    make(map[string]*Target),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for _, ch := range []chan *Target{state.addCh, state.removeCh} {
+		go func(ch chan *Target) {
+			for {
+				select {
+				case <-ch:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(ch)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := s.syncSnapshot(ctx, srcTargets, state); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestRemoveDelayPerTarget(t *testing.T) {
+	cfg := &SyncConfig{
+		LockOptions: LockOptions{
+			Key: "a",
+			TTL: time.Second,
+		},
+		RemoveDelay: time.Second,
+	}
+
+	src := newmockSource()
+	dst := newmockDestination()
+	syncer := &Syncer{
+		Config: cfg,
+		Locker: &mockLocker{},
+		Src:    src,
+		Dst:    dst,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go syncer.Run(ctx)
+
+	short := &Target{IP: "1"}
+	long := &Target{IP: "2", Meta: map[string]string{MetaRemoveDelay: "1h"}}
+	kept := &Target{IP: "3"}
+	src.ch <- []*Target{short, long, kept}
+	time.Sleep(time.Second)
+
+	src.ch <- []*Target{kept}
+	time.Sleep(time.Second * 3)
+
+	// Only the target which is due is removed, not those due later
+	tgts, _ := dst.GetTargets(nil)
+	expected := []*Target{long, kept}
+	if err := equalTargets(expected, tgts); err != nil {
+		t.Fatalf("Mismatch in targets err=%v expected=%+v actual=%+v", err, expected, tgts)
+	}
+}
@@ -0,0 +1,351 @@
+// Package targetsyncclient is a client for the targetsync admin API
+package targetsyncclient
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wish/targetsync"
+)
+
+// ErrNotFound is returned when the requested sync pair doesn't exist
+var ErrNotFound = fmt.Errorf("sync pair not found")
+
+// ErrNotLeader is returned when the request must be made to the leader of the
+// sync pair
+var ErrNotLeader = fmt.Errorf("not the leader of the sync pair")
+
+// New returns a new Client for the targetsync at `addr` (e.g.
+// http://localhost:8080)
+func New(addr string) *Client {
+	return &Client{
+		Addr:       strings.TrimSuffix(addr, "/"),
+		HTTPClient: http.DefaultClient,
+	}
+}
+
+// Client queries the admin API of a targetsync process
+type Client struct {
+	Addr       string
+	HTTPClient *http.Client
+}
+
+// get decodes the response of the API path into `v`, the response is also
+// decoded for any of the `okCodes`
+func (c *Client) get(ctx context.Context, path string, v interface{}, okCodes ...int) error {
+	req, err := http.NewRequest(http.MethodGet, c.Addr+targetsync.APIPrefix+path, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.HTTPClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	ok := resp.StatusCode == http.StatusOK
+	for _, code := range okCodes {
+		if resp.StatusCode == code {
+			ok = true
+		}
+	}
+	if !ok {
+		if resp.StatusCode == http.StatusNotFound {
+			return ErrNotFound
+		}
+		return fmt.Errorf("Unexpected status from targetsync: %s", resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("Error decoding response: %v", err)
+	}
+	return nil
+}
+
+// send sends the registration to the push source of the named sync pair
+func (c *Client) send(ctx context.Context, method, name string, reg *targetsync.PushRegistration) error {
+	b, err := json.Marshal(reg)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(method, c.Addr+targetsync.APIPrefix+"/register/"+url.PathEscape(name), bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.HTTPClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNoContent, http.StatusOK:
+		return nil
+	case http.StatusNotFound:
+		return ErrNotFound
+	default:
+		return fmt.Errorf("Unexpected status from targetsync: %s", resp.Status)
+	}
+}
+
+// Register registers (or renews) a target with the push source of the named
+// sync pair, it must be renewed within its TTL
+func (c *Client) Register(ctx context.Context, name string, reg *targetsync.PushRegistration) error {
+	return c.send(ctx, http.MethodPost, name, reg)
+}
+
+// Deregister removes a target from the push source of the named sync pair
+func (c *Client) Deregister(ctx context.Context, name string, reg *targetsync.PushRegistration) error {
+	return c.send(ctx, http.MethodDelete, name, reg)
+}
+
+// Adopted returns the adopted targets of the named sync pair, ErrNotFound is
+// returned if it doesn't exist
+func (c *Client) Adopted(ctx context.Context, name string) ([]targetsync.AdoptedTarget, error) {
+	var adopted []targetsync.AdoptedTarget
+	if err := c.get(ctx, "/adopted/"+url.PathEscape(name), &adopted); err != nil {
+		return nil, err
+	}
+	return adopted, nil
+}
+
+// ReleaseAdopted releases the adopted target with the IP (or all adopted
+// targets if empty) of the named sync pair, so they are removed if they are
+// missing from the source
+func (c *Client) ReleaseAdopted(ctx context.Context, name, ip string) error {
+	path := c.Addr + targetsync.APIPrefix + "/adopted/" + url.PathEscape(name)
+	if ip != "" {
+		path += "?ip=" + url.QueryEscape(ip)
+	}
+	req, err := http.NewRequest(http.MethodDelete, path, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.HTTPClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNoContent, http.StatusOK:
+		return nil
+	case http.StatusNotFound:
+		return ErrNotFound
+	default:
+		return fmt.Errorf("Unexpected status from targetsync: %s", resp.Status)
+	}
+}
+
+// MutationBudget returns the mutation budget of the named sync pair,
+// ErrNotFound is returned if it doesn't exist
+func (c *Client) MutationBudget(ctx context.Context, name string) (*targetsync.MutationBudget, error) {
+	var budget targetsync.MutationBudget
+	if err := c.get(ctx, "/budget/"+url.PathEscape(name), &budget); err != nil {
+		return nil, err
+	}
+	return &budget, nil
+}
+
+// ResumeMutations resets the mutation budget of the named sync pair,
+// resuming its mutations if they were paused
+func (c *Client) ResumeMutations(ctx context.Context, name string) error {
+	req, err := http.NewRequest(http.MethodDelete, c.Addr+targetsync.APIPrefix+"/budget/"+url.PathEscape(name), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.HTTPClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNoContent, http.StatusOK:
+		return nil
+	case http.StatusNotFound:
+		return ErrNotFound
+	default:
+		return fmt.Errorf("Unexpected status from targetsync: %s", resp.Status)
+	}
+}
+
+// PendingRemovals returns the targets waiting to be removed from the
+// destination of the named sync pair, ErrNotFound is returned if it doesn't
+// exist
+func (c *Client) PendingRemovals(ctx context.Context, name string) ([]targetsync.PendingRemoval, error) {
+	var removals []targetsync.PendingRemoval
+	if err := c.get(ctx, "/removals/"+url.PathEscape(name), &removals); err != nil {
+		return nil, err
+	}
+	return removals, nil
+}
+
+// CancelRemoval cancels the pending removal of the target with the key (e.g.
+// `10.0.0.1:80`) of the named sync pair, so it is kept in the destination.
+// ErrNotFound is returned if the pair doesn't exist or the target isn't
+// waiting to be removed, and ErrNotLeader if this process isn't the leader.
+func (c *Client) CancelRemoval(ctx context.Context, name, key string) error {
+	return c.removals(ctx, http.MethodDelete, url.PathEscape(name)+"?key="+url.QueryEscape(key))
+}
+
+// FlushRemovals removes the target with the key (or all targets if empty)
+// waiting to be removed from the destination of the named sync pair now,
+// rather than after their remove delay
+func (c *Client) FlushRemovals(ctx context.Context, name, key string) error {
+	path := url.PathEscape(name) + "/flush"
+	if key != "" {
+		path += "?key=" + url.QueryEscape(key)
+	}
+	return c.removals(ctx, http.MethodPost, path)
+}
+
+// removals sends a request to the removals API path
+func (c *Client) removals(ctx context.Context, method, path string) error {
+	req, err := http.NewRequest(method, c.Addr+targetsync.APIPrefix+"/removals/"+path, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.HTTPClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNoContent, http.StatusOK:
+		return nil
+	case http.StatusNotFound:
+		return ErrNotFound
+	case http.StatusConflict:
+		return ErrNotLeader
+	default:
+		return fmt.Errorf("Unexpected status from targetsync: %s", resp.Status)
+	}
+}
+
+// Events streams the events of the named sync pair (or all pairs if empty)
+// until the context is done or the stream ends, when the channel is closed
+func (c *Client) Events(ctx context.Context, name string) (<-chan targetsync.Event, error) {
+	path := c.Addr + targetsync.APIPrefix + "/events/stream"
+	if name != "" {
+		path += "?name=" + url.QueryEscape(name)
+	}
+	req, err := http.NewRequest(http.MethodGet, path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := c.HTTPClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("Unexpected status from targetsync: %s", resp.Status)
+	}
+
+	ch := make(chan targetsync.Event)
+	go func() {
+		defer close(ch)
+		defer resp.Body.Close()
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if !strings.HasPrefix(line, "data: ") {
+				continue
+			}
+			var e targetsync.Event
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &e); err != nil {
+				continue
+			}
+			select {
+			case ch <- e:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// Ready returns whether all sync pairs are ready
+func (c *Client) Ready(ctx context.Context) (*targetsync.ReadyResponse, error) {
+	var ready targetsync.ReadyResponse
+	if err := c.get(ctx, "/ready", &ready, http.StatusServiceUnavailable); err != nil {
+		return nil, err
+	}
+	return &ready, nil
+}
+
+// Status returns the status of all sync pairs
+func (c *Client) Status(ctx context.Context) ([]targetsync.SyncerStatus, error) {
+	var statuses []targetsync.SyncerStatus
+	if err := c.get(ctx, "/status", &statuses); err != nil {
+		return nil, err
+	}
+	return statuses, nil
+}
+
+// PairStatus returns the status of the named sync pair, ErrNotFound is
+// returned if it doesn't exist
+func (c *Client) PairStatus(ctx context.Context, name string) (*targetsync.SyncerStatus, error) {
+	var status targetsync.SyncerStatus
+	if err := c.get(ctx, "/status/"+url.PathEscape(name), &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// Diff returns the difference between the source and destination of the named
+// sync pair (or all pairs if empty), ErrNotFound is returned if it doesn't
+// exist
+func (c *Client) Diff(ctx context.Context, name string) (*targetsync.DiffResponse, error) {
+	path := "/diff"
+	if name != "" {
+		path += "?pair=" + url.QueryEscape(name)
+	}
+	var diff targetsync.DiffResponse
+	if err := c.get(ctx, path, &diff, http.StatusServiceUnavailable); err != nil {
+		return nil, err
+	}
+	return &diff, nil
+}
+
+// History returns the changes to the destinations' targets selected by the
+// query, ErrNotFound is returned if the history isn't enabled
+func (c *Client) History(ctx context.Context, q targetsync.HistoryQuery) ([]targetsync.HistoryRecord, error) {
+	params := url.Values{}
+	if q.Name != "" {
+		params.Set("pair", q.Name)
+	}
+	if q.Target != "" {
+		params.Set("target", q.Target)
+	}
+	if !q.Since.IsZero() {
+		params.Set("since", q.Since.Format(time.RFC3339Nano))
+	}
+	if !q.Until.IsZero() {
+		params.Set("until", q.Until.Format(time.RFC3339Nano))
+	}
+	if q.Limit > 0 {
+		params.Set("limit", strconv.Itoa(q.Limit))
+	}
+	path := "/history"
+	if len(params) > 0 {
+		path += "?" + params.Encode()
+	}
+	var records []targetsync.HistoryRecord
+	if err := c.get(ctx, path, &records); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
@@ -0,0 +1,129 @@
+package targetsyncclient
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/wish/targetsync"
+)
+
+func TestClient(t *testing.T) {
+	syncers := []*targetsync.Syncer{
+		{
+			Name:    "a",
+			Config:  &targetsync.SyncConfig{LockOptions: targetsync.LockOptions{Key: "service/a/leader"}},
+			Started: true,
+		},
+		{
+			Config: &targetsync.SyncConfig{LockOptions: targetsync.LockOptions{Key: "service/b/leader"}},
+		},
+	}
+	srv := httptest.NewServer(targetsync.NewAPIHandler(syncers))
+	defer srv.Close()
+
+	c := New(srv.URL)
+	ctx := context.Background()
+
+	ready, err := c.Ready(ctx)
+	if err != nil {
+		t.Fatalf("Error getting ready: %v", err)
+	}
+	if ready.Ready || len(ready.NotReady) != 1 || ready.NotReady[0] != "service/b/leader" {
+		t.Fatalf("Unexpected ready response: %+v", ready)
+	}
+
+	statuses, err := c.Status(ctx)
+	if err != nil {
+		t.Fatalf("Error getting status: %v", err)
+	}
+	if len(statuses) != 2 {
+		t.Fatalf("Expected 2 statuses, got %d", len(statuses))
+	}
+
+	// pairs without a name are named by their lock key
+	status, err := c.PairStatus(ctx, "service/b/leader")
+	if err != nil {
+		t.Fatalf("Error getting pair status: %v", err)
+	}
+	if status.Key != "service/b/leader" || status.Started {
+		t.Fatalf("Unexpected pair status: %+v", status)
+	}
+
+	if _, err := c.PairStatus(ctx, "missing"); err != ErrNotFound {
+		t.Fatalf("Expected ErrNotFound, got %v", err)
+	}
+
+	removals, err := c.PendingRemovals(ctx, "a")
+	if err != nil {
+		t.Fatalf("Error getting pending removals: %v", err)
+	}
+	if len(removals) != 0 {
+		t.Fatalf("Expected no pending removals, got %+v", removals)
+	}
+	// Only the leader has a removal queue
+	if err := c.CancelRemoval(ctx, "a", "10.0.0.1:80"); err != ErrNotLeader {
+		t.Fatalf("Expected ErrNotLeader, got %v", err)
+	}
+	if err := c.FlushRemovals(ctx, "missing", ""); err != ErrNotFound {
+		t.Fatalf("Expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestClientDiff(t *testing.T) {
+	syncers := []*targetsync.Syncer{
+		{
+			Name:   "a",
+			Config: &targetsync.SyncConfig{},
+			Src:    targetsync.NewFakeSource(&targetsync.FakeSourceConfig{Targets: 2, Port: 80}),
+			Dst:    targetsync.NewFakeDestination(&targetsync.FakeDestinationConfig{}),
+		},
+	}
+	srv := httptest.NewServer(targetsync.NewAPIHandler(syncers))
+	defer srv.Close()
+
+	c := New(srv.URL)
+	ctx := context.Background()
+
+	diff, err := c.Diff(ctx, "")
+	if err != nil {
+		t.Fatalf("Error getting diff: %v", err)
+	}
+	if diff.Converged || len(diff.Pairs) != 1 || len(diff.Pairs[0].Add) != 2 {
+		t.Fatalf("Unexpected diff: %+v", diff)
+	}
+
+	if _, err := c.Diff(ctx, "missing"); err != ErrNotFound {
+		t.Fatalf("Expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestClientEvents(t *testing.T) {
+	stream := targetsync.NewEventStream(nil)
+	mux := http.NewServeMux()
+	mux.Handle(targetsync.APIPrefix+"/events/stream", stream)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := New(srv.URL).Events(ctx, "a")
+	if err != nil {
+		t.Fatalf("Error streaming events: %v", err)
+	}
+
+	// The stream is subscribed once Events returns
+	stream.Emit(targetsync.Event{Type: targetsync.EventLockAcquired, Name: "b"})
+	stream.Emit(targetsync.Event{Type: targetsync.EventLockLost, Name: "a"})
+
+	select {
+	case e := <-events:
+		if e.Type != targetsync.EventLockLost || e.Name != "a" {
+			t.Fatalf("Unexpected event: %+v", e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("No event received")
+	}
+}
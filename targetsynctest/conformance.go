@@ -0,0 +1,412 @@
+// Package targetsynctest provides conformance tests for implementations of
+// the targetsync interfaces, and in-memory fakes of them. Third-party sources,
+// destinations and lockers can run the suites from their own tests to check
+// they behave as the Syncer expects, e.g.
+//
+//	func TestMyDestination(t *testing.T) {
+//		targetsynctest.DestinationSuite{
+//			New: func(t *testing.T) targetsync.TargetDestination {
+//				return newTestDestination(t)
+//			},
+//		}.Run(t)
+//	}
+package targetsynctest
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wish/targetsync"
+)
+
+// defaultTimeout is how long the suites wait for changes to be visible if
+// `Timeout` isn't set
+const defaultTimeout = 10 * time.Second
+
+// pollInterval is how often the suites check for changes
+const pollInterval = 10 * time.Millisecond
+
+// defaultTargets are the targets used by the suites if `Targets` isn't set,
+// from the documentation range 192.0.2.0/24
+func defaultTargets() []*targetsync.Target {
+	return []*targetsync.Target{
+		{IP: "192.0.2.1", Port: 80},
+		{IP: "192.0.2.2", Port: 80},
+		{IP: "192.0.2.3", Port: 80},
+	}
+}
+
+// keys returns the sorted keys of the targets
+func keys(targets []*targetsync.Target) string {
+	k := make([]string, len(targets))
+	for i, target := range targets {
+		k[i] = target.Key()
+	}
+	sort.Strings(k)
+	return strings.Join(k, ",")
+}
+
+// eventually calls `check` until it returns nil, failing the test with its
+// last error once the timeout passes
+func eventually(t *testing.T, timeout time.Duration, check func() error) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for {
+		err := check()
+		if err == nil {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out after %v: %v", timeout, err)
+		}
+		time.Sleep(pollInterval)
+	}
+}
+
+// DestinationSuite checks a TargetDestination implementation:
+//   - adding targets is idempotent, re-adding a target doesn't duplicate it
+//   - removing targets is idempotent, removing absent targets isn't an error
+//   - empty adds and removes are no-ops
+//   - disabled targets (if a `TargetAvailabilityDestination`) aren't returned
+//     by GetTargets, and are re-enabled by adding them
+type DestinationSuite struct {
+	// New returns a destination without any targets, it is called for each
+	// test
+	New func(t *testing.T) targetsync.TargetDestination
+	// Targets to add and remove, at least 3. Defaults to targets in
+	// 192.0.2.0/24 on port 80
+	Targets []*targetsync.Target
+	// Timeout is how long changes may take to be returned by GetTargets,
+	// defaults to 10s
+	Timeout time.Duration
+}
+
+// Run runs the suite's tests as subtests of `t`
+func (s DestinationSuite) Run(t *testing.T) {
+	if s.Targets == nil {
+		s.Targets = defaultTargets()
+	}
+	if len(s.Targets) < 3 {
+		t.Fatalf("DestinationSuite needs at least 3 targets, got %d", len(s.Targets))
+	}
+	if s.Timeout <= 0 {
+		s.Timeout = defaultTimeout
+	}
+	t.Run("AddIdempotent", s.testAddIdempotent)
+	t.Run("RemoveIdempotent", s.testRemoveIdempotent)
+	t.Run("EmptyBatches", s.testEmptyBatches)
+	t.Run("DisableTargets", s.testDisableTargets)
+}
+
+// expectTargets waits for the destination's targets to match
+func (s DestinationSuite) expectTargets(t *testing.T, dst targetsync.TargetDestination, expected []*targetsync.Target) {
+	t.Helper()
+	eventually(t, s.Timeout, func() error {
+		targets, err := dst.GetTargets(context.Background())
+		if err != nil {
+			return fmt.Errorf("Error getting targets: %v", err)
+		}
+		if got, want := keys(targets), keys(expected); got != want {
+			return fmt.Errorf("Expected targets [%s], got [%s]", want, got)
+		}
+		return nil
+	})
+}
+
+func (s DestinationSuite) testAddIdempotent(t *testing.T) {
+	ctx := context.Background()
+	dst := s.New(t)
+	a, b := s.Targets[0], s.Targets[1]
+	if err := dst.AddTargets(ctx, []*targetsync.Target{a, b}); err != nil {
+		t.Fatalf("Error adding targets: %v", err)
+	}
+	s.expectTargets(t, dst, []*targetsync.Target{a, b})
+
+	if err := dst.AddTargets(ctx, []*targetsync.Target{a}); err != nil {
+		t.Fatalf("Error re-adding target: %v", err)
+	}
+	s.expectTargets(t, dst, []*targetsync.Target{a, b})
+}
+
+func (s DestinationSuite) testRemoveIdempotent(t *testing.T) {
+	ctx := context.Background()
+	dst := s.New(t)
+	a, b, absent := s.Targets[0], s.Targets[1], s.Targets[2]
+	if err := dst.AddTargets(ctx, []*targetsync.Target{a, b}); err != nil {
+		t.Fatalf("Error adding targets: %v", err)
+	}
+	s.expectTargets(t, dst, []*targetsync.Target{a, b})
+
+	if err := dst.RemoveTargets(ctx, []*targetsync.Target{a}); err != nil {
+		t.Fatalf("Error removing target: %v", err)
+	}
+	s.expectTargets(t, dst, []*targetsync.Target{b})
+	if err := dst.RemoveTargets(ctx, []*targetsync.Target{a}); err != nil {
+		t.Fatalf("Error removing target twice: %v", err)
+	}
+	if err := dst.RemoveTargets(ctx, []*targetsync.Target{absent}); err != nil {
+		t.Fatalf("Error removing target which was never added: %v", err)
+	}
+	s.expectTargets(t, dst, []*targetsync.Target{b})
+}
+
+func (s DestinationSuite) testEmptyBatches(t *testing.T) {
+	ctx := context.Background()
+	dst := s.New(t)
+	if err := dst.AddTargets(ctx, nil); err != nil {
+		t.Fatalf("Error adding no targets: %v", err)
+	}
+	if err := dst.RemoveTargets(ctx, nil); err != nil {
+		t.Fatalf("Error removing no targets: %v", err)
+	}
+	s.expectTargets(t, dst, nil)
+}
+
+func (s DestinationSuite) testDisableTargets(t *testing.T) {
+	ctx := context.Background()
+	dst, ok := s.New(t).(targetsync.TargetAvailabilityDestination)
+	if !ok {
+		t.Skip("Destination is not a TargetAvailabilityDestination")
+	}
+	a, b := s.Targets[0], s.Targets[1]
+	if err := dst.AddTargets(ctx, []*targetsync.Target{a, b}); err != nil {
+		t.Fatalf("Error adding targets: %v", err)
+	}
+	if err := dst.DisableTargets(ctx, []*targetsync.Target{a}); err != nil {
+		t.Fatalf("Error disabling target: %v", err)
+	}
+	s.expectTargets(t, dst, []*targetsync.Target{b})
+	if err := dst.AddTargets(ctx, []*targetsync.Target{a}); err != nil {
+		t.Fatalf("Error re-enabling target: %v", err)
+	}
+	s.expectTargets(t, dst, []*targetsync.Target{a, b})
+}
+
+// SourceSuite checks a TargetSource implementation:
+//   - subscribers get the current targets when they subscribe
+//   - every subscriber gets the latest targets after a change
+//   - the channel is closed once the subscription's context is done
+type SourceSuite struct {
+	// New returns a source with the given targets, and a func changing its
+	// targets. It is called for each test
+	New func(t *testing.T, targets []*targetsync.Target) (targetsync.TargetSource, func([]*targetsync.Target))
+	// Targets to set, at least 3. Defaults to targets in 192.0.2.0/24 on
+	// port 80
+	Targets []*targetsync.Target
+	// Timeout is how long changes may take to be sent to subscribers,
+	// defaults to 10s
+	Timeout time.Duration
+}
+
+// Run runs the suite's tests as subtests of `t`
+func (s SourceSuite) Run(t *testing.T) {
+	if s.Targets == nil {
+		s.Targets = defaultTargets()
+	}
+	if len(s.Targets) < 3 {
+		t.Fatalf("SourceSuite needs at least 3 targets, got %d", len(s.Targets))
+	}
+	if s.Timeout <= 0 {
+		s.Timeout = defaultTimeout
+	}
+	t.Run("InitialTargets", s.testInitialTargets)
+	t.Run("Updates", s.testUpdates)
+	t.Run("ClosedOnCancel", s.testClosedOnCancel)
+}
+
+// expectTargets waits for the subscription to send the targets, skipping any
+// other updates
+func (s SourceSuite) expectTargets(t *testing.T, ch chan []*targetsync.Target, expected []*targetsync.Target) {
+	t.Helper()
+	want := keys(expected)
+	timeout := time.After(s.Timeout)
+	var got string
+	for {
+		select {
+		case targets, ok := <-ch:
+			if !ok {
+				t.Fatalf("Subscription closed waiting for targets [%s]", want)
+			}
+			if got = keys(targets); got == want {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("Timed out after %v waiting for targets [%s], last got [%s]", s.Timeout, want, got)
+		}
+	}
+}
+
+func (s SourceSuite) testInitialTargets(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	initial := s.Targets[:2]
+	src, _ := s.New(t, initial)
+	ch, err := src.Subscribe(ctx)
+	if err != nil {
+		t.Fatalf("Error subscribing: %v", err)
+	}
+	s.expectTargets(t, ch, initial)
+}
+
+func (s SourceSuite) testUpdates(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	src, setTargets := s.New(t, s.Targets[:1])
+	chs := make([]chan []*targetsync.Target, 2)
+	for i := range chs {
+		ch, err := src.Subscribe(ctx)
+		if err != nil {
+			t.Fatalf("Error subscribing: %v", err)
+		}
+		s.expectTargets(t, ch, s.Targets[:1])
+		chs[i] = ch
+	}
+
+	setTargets(s.Targets[1:3])
+	for _, ch := range chs {
+		s.expectTargets(t, ch, s.Targets[1:3])
+	}
+	setTargets(nil)
+	for _, ch := range chs {
+		s.expectTargets(t, ch, nil)
+	}
+}
+
+func (s SourceSuite) testClosedOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	src, _ := s.New(t, s.Targets[:1])
+	ch, err := src.Subscribe(ctx)
+	if err != nil {
+		t.Fatalf("Error subscribing: %v", err)
+	}
+	cancel()
+	timeout := time.After(s.Timeout)
+	for {
+		select {
+		case _, ok := <-ch:
+			if !ok {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("Subscription not closed within %v of its context being done", s.Timeout)
+		}
+	}
+}
+
+// LockerSuite checks a Locker implementation:
+//   - only one of the Lockers contending for a lock is elected
+//   - the lock fails over to another Locker once the leader stops
+//   - the channel is closed once the context is done
+type LockerSuite struct {
+	// New returns a Locker contending for the same locks as the other
+	// Lockers returned in the same test, it is called (at least) twice for
+	// each test
+	New func(t *testing.T) targetsync.Locker
+	// Options are the options of the lock, the key defaults to a unique key
+	// per test and the TTL to 1s
+	Options targetsync.LockOptions
+	// Timeout is how long the lock may take to be acquired, defaults to 10s
+	Timeout time.Duration
+	// Settle is how long a second Locker is watched for wrongly acquiring
+	// the lock, defaults to 100ms
+	Settle time.Duration
+}
+
+// Run runs the suite's tests as subtests of `t`
+func (s LockerSuite) Run(t *testing.T) {
+	if s.Timeout <= 0 {
+		s.Timeout = defaultTimeout
+	}
+	if s.Settle <= 0 {
+		s.Settle = 100 * time.Millisecond
+	}
+	if s.Options.TTL <= 0 {
+		s.Options.TTL = time.Second
+	}
+	t.Run("Failover", s.testFailover)
+	t.Run("ClosedOnCancel", s.testClosedOnCancel)
+}
+
+// options returns the lock options for the test
+func (s LockerSuite) options(t *testing.T, identity string) *targetsync.LockOptions {
+	opts := s.Options
+	if opts.Key == "" {
+		opts.Key = fmt.Sprintf("targetsynctest/%s/%d", t.Name(), time.Now().UnixNano())
+	}
+	opts.Identity = identity
+	return &opts
+}
+
+// expectElected waits for the lock channel to send `elected`
+func (s LockerSuite) expectElected(t *testing.T, ch <-chan bool, name string) {
+	t.Helper()
+	select {
+	case elected, ok := <-ch:
+		if !ok {
+			t.Fatalf("Lock channel of %s closed waiting to be elected", name)
+		}
+		if !elected {
+			t.Fatalf("Expected %s to be elected, got not elected", name)
+		}
+	case <-time.After(s.Timeout):
+		t.Fatalf("%s not elected within %v", name, s.Timeout)
+	}
+}
+
+func (s LockerSuite) testFailover(t *testing.T) {
+	opts := s.options(t, "")
+	first, second := s.New(t), s.New(t)
+	firstOpts, secondOpts := *opts, *opts
+	firstOpts.Identity, secondOpts.Identity = "first", "second"
+
+	firstCtx, firstCancel := context.WithCancel(context.Background())
+	defer firstCancel()
+	firstCh, err := first.Lock(firstCtx, &firstOpts)
+	if err != nil {
+		t.Fatalf("Error locking: %v", err)
+	}
+	s.expectElected(t, firstCh, "first")
+
+	secondCtx, secondCancel := context.WithCancel(context.Background())
+	defer secondCancel()
+	secondCh, err := second.Lock(secondCtx, &secondOpts)
+	if err != nil {
+		t.Fatalf("Error locking: %v", err)
+	}
+	select {
+	case elected := <-secondCh:
+		if elected {
+			t.Fatalf("Second locker elected while the first holds the lock")
+		}
+	case <-time.After(s.Settle):
+	}
+
+	// The lock fails over once the leader stops
+	firstCancel()
+	s.expectElected(t, secondCh, "second")
+}
+
+func (s LockerSuite) testClosedOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	ch, err := s.New(t).Lock(ctx, s.options(t, "first"))
+	if err != nil {
+		t.Fatalf("Error locking: %v", err)
+	}
+	s.expectElected(t, ch, "first")
+	cancel()
+	timeout := time.After(s.Timeout)
+	for {
+		select {
+		case _, ok := <-ch:
+			if !ok {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("Lock channel not closed within %v of its context being done", s.Timeout)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package targetsynctest
+
+import (
+	"context"
+	"testing"
+
+	"github.com/wish/targetsync"
+)
+
+func TestDestinationSuite(t *testing.T) {
+	DestinationSuite{
+		New: func(t *testing.T) targetsync.TargetDestination {
+			return NewDestination()
+		},
+	}.Run(t)
+}
+
+func TestFakeDestinationSuite(t *testing.T) {
+	DestinationSuite{
+		New: func(t *testing.T) targetsync.TargetDestination {
+			return targetsync.NewFakeDestination(&targetsync.FakeDestinationConfig{Enabled: true})
+		},
+	}.Run(t)
+}
+
+func TestSourceSuite(t *testing.T) {
+	SourceSuite{
+		New: func(t *testing.T, targets []*targetsync.Target) (targetsync.TargetSource, func([]*targetsync.Target)) {
+			src := NewSource(targets...)
+			return src, src.SetTargets
+		},
+	}.Run(t)
+}
+
+func TestLockerSuite(t *testing.T) {
+	server := NewLockServer()
+	LockerSuite{
+		New: func(t *testing.T) targetsync.Locker {
+			return server.Locker()
+		},
+	}.Run(t)
+}
+
+func TestDestinationDisabled(t *testing.T) {
+	dst := NewDestination()
+	target := &targetsync.Target{IP: "192.0.2.1", Port: 80}
+	if err := dst.DisableTargets(context.Background(), []*targetsync.Target{target}); err != nil {
+		t.Fatalf("Error disabling target: %v", err)
+	}
+	if disabled := dst.Disabled(); len(disabled) != 0 {
+		t.Fatalf("Expected targets which were never added not to be disabled, got %v", disabled)
+	}
+	if err := dst.AddTargets(context.Background(), []*targetsync.Target{target}); err != nil {
+		t.Fatalf("Error adding target: %v", err)
+	}
+	if err := dst.DisableTargets(context.Background(), []*targetsync.Target{target}); err != nil {
+		t.Fatalf("Error disabling target: %v", err)
+	}
+	if disabled := dst.Disabled(); len(disabled) != 1 || disabled[0].Key() != target.Key() {
+		t.Fatalf("Expected %s to be disabled, got %v", target.Key(), disabled)
+	}
+}
@@ -0,0 +1,208 @@
+package targetsynctest
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/wish/targetsync"
+)
+
+// NewSource returns a Source with the given targets
+func NewSource(targets ...*targetsync.Target) *Source {
+	return &Source{
+		targets:     targets,
+		subscribers: make(map[chan []*targetsync.Target]struct{}),
+	}
+}
+
+// Source is an in-memory TargetSource whose targets are set by the test. Like
+// the real sources, subscribers get the current targets when they subscribe,
+// and a subscriber which falls behind only gets the latest targets.
+type Source struct {
+	l           sync.Mutex
+	targets     []*targetsync.Target
+	subscribers map[chan []*targetsync.Target]struct{}
+}
+
+// SetTargets replaces the targets, sending them to all subscribers
+func (s *Source) SetTargets(targets []*targetsync.Target) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	s.targets = targets
+	for ch := range s.subscribers {
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- targets
+	}
+}
+
+// Subscribe to implement the `TargetSource` interface, the channel is closed
+// when the context is done
+func (s *Source) Subscribe(ctx context.Context) (chan []*targetsync.Target, error) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	ch := make(chan []*targetsync.Target, 1)
+	ch <- s.targets
+	s.subscribers[ch] = struct{}{}
+	go func() {
+		<-ctx.Done()
+		s.l.Lock()
+		defer s.l.Unlock()
+		delete(s.subscribers, ch)
+		close(ch)
+	}()
+	return ch, nil
+}
+
+// NewDestination returns an empty Destination
+func NewDestination() *Destination {
+	return &Destination{
+		targets:  make(map[string]*targetsync.Target),
+		disabled: make(map[string]*targetsync.Target),
+	}
+}
+
+// Destination is an in-memory TargetAvailabilityDestination, adds and
+// removes are idempotent
+type Destination struct {
+	l        sync.Mutex
+	targets  map[string]*targetsync.Target
+	disabled map[string]*targetsync.Target
+}
+
+// GetTargets to implement the `TargetDestination` interface, the targets are
+// sorted by key
+func (d *Destination) GetTargets(ctx context.Context) ([]*targetsync.Target, error) {
+	d.l.Lock()
+	defer d.l.Unlock()
+	targets := make([]*targetsync.Target, 0, len(d.targets))
+	for _, target := range d.targets {
+		targets = append(targets, target)
+	}
+	sort.Slice(targets, func(i, j int) bool {
+		return targets[i].Key() < targets[j].Key()
+	})
+	return targets, nil
+}
+
+// AddTargets to implement the `TargetDestination` interface, disabled targets
+// are re-enabled
+func (d *Destination) AddTargets(ctx context.Context, targets []*targetsync.Target) error {
+	d.l.Lock()
+	defer d.l.Unlock()
+	for _, target := range targets {
+		delete(d.disabled, target.Key())
+		d.targets[target.Key()] = target
+	}
+	return nil
+}
+
+// RemoveTargets to implement the `TargetDestination` interface
+func (d *Destination) RemoveTargets(ctx context.Context, targets []*targetsync.Target) error {
+	d.l.Lock()
+	defer d.l.Unlock()
+	for _, target := range targets {
+		delete(d.targets, target.Key())
+		delete(d.disabled, target.Key())
+	}
+	return nil
+}
+
+// DisableTargets to implement the `TargetAvailabilityDestination` interface
+func (d *Destination) DisableTargets(ctx context.Context, targets []*targetsync.Target) error {
+	d.l.Lock()
+	defer d.l.Unlock()
+	for _, target := range targets {
+		if existing, ok := d.targets[target.Key()]; ok {
+			delete(d.targets, target.Key())
+			d.disabled[target.Key()] = existing
+		}
+	}
+	return nil
+}
+
+// Disabled returns the disabled targets
+func (d *Destination) Disabled() []*targetsync.Target {
+	d.l.Lock()
+	defer d.l.Unlock()
+	targets := make([]*targetsync.Target, 0, len(d.disabled))
+	for _, target := range d.disabled {
+		targets = append(targets, target)
+	}
+	return targets
+}
+
+// NewLockServer returns a LockServer without any locks held
+func NewLockServer() *LockServer {
+	return &LockServer{locks: make(map[string]*lockState)}
+}
+
+// LockServer holds in-memory locks shared by its Lockers, standing in for
+// e.g. consul so several Lockers can contend for the same lock
+type LockServer struct {
+	l     sync.Mutex
+	locks map[string]*lockState
+}
+
+// lockState is the holder and waiters of a lock, in order
+type lockState struct {
+	holder  chan bool
+	waiters []chan bool
+}
+
+// Locker returns a new Locker contending for the server's locks
+func (s *LockServer) Locker() *Locker {
+	return &Locker{server: s}
+}
+
+// Locker is a Locker backed by a LockServer. The lock is granted to callers
+// of `Lock` in order, and released when the holder's context is done.
+type Locker struct {
+	server *LockServer
+}
+
+// Lock to implement the `Locker` interface, the channel is closed when the
+// context is done
+func (l *Locker) Lock(ctx context.Context, opts *targetsync.LockOptions) (<-chan bool, error) {
+	s := l.server
+	ch := make(chan bool, 1)
+	s.l.Lock()
+	state, ok := s.locks[opts.Key]
+	if !ok {
+		state = &lockState{}
+		s.locks[opts.Key] = state
+	}
+	if state.holder == nil {
+		state.holder = ch
+		ch <- true
+	} else {
+		state.waiters = append(state.waiters, ch)
+	}
+	s.l.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		s.l.Lock()
+		defer s.l.Unlock()
+		if state.holder == ch {
+			state.holder = nil
+			if len(state.waiters) > 0 {
+				state.holder = state.waiters[0]
+				state.waiters = state.waiters[1:]
+				state.holder <- true
+			}
+		} else {
+			for i, waiter := range state.waiters {
+				if waiter == ch {
+					state.waiters = append(state.waiters[:i], state.waiters[i+1:]...)
+					break
+				}
+			}
+		}
+		close(ch)
+	}()
+	return ch, nil
+}
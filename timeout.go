@@ -0,0 +1,91 @@
+package targetsync
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// defaultDestinationTimeout is the timeout for destination calls if
+// `DestinationTimeout` isn't set
+const defaultDestinationTimeout = time.Minute
+
+// callDestination runs the destination call `fn` with a context limited to
+// the `DestinationTimeout`. As not all destinations respect the context, we
+// stop waiting for `fn` once the timeout passes so a hung call can't stall
+// the Syncer; the call itself is left to finish in the background.
+func (s *Syncer) callDestination(ctx context.Context, op string, fn func(context.Context) error) error {
+	timeout := s.Config.DestinationTimeout
+	if timeout <= 0 {
+		timeout = defaultDestinationTimeout
+	}
+	callCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	callCtx, stats := withAWSCallStats(callCtx)
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- fn(callCtx)
+	}()
+
+	var err error
+	select {
+	case err = <-errCh:
+	case <-callCtx.Done():
+		err = callCtx.Err()
+	}
+	s.reportThrottles(op, stats, err)
+	if err != nil && callCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+		destinationTimeoutsTotal.WithLabelValues(s.name(), op).Inc()
+		return fmt.Errorf("Destination %s timed out after %v: %w", op, timeout, err)
+	}
+	return err
+}
+
+// reportThrottles emits a destination_throttled event if any of the AWS calls
+// made by the destination call were throttled, even if the sdk's retries
+// succeeded
+func (s *Syncer) reportThrottles(op string, stats *awsCallStats, err error) {
+	throttles, retries := stats.get()
+	if throttles == 0 {
+		return
+	}
+	msg := fmt.Sprintf("Destination %s was throttled %d times (%d retries)", op, throttles, retries)
+	if err != nil {
+		msg += fmt.Sprintf(", failing: %v", err)
+	}
+	s.emit(Event{
+		Type:       EventDestinationThrottled,
+		Time:       time.Now(),
+		Message:    msg,
+		ErrorClass: ErrorClass(err),
+	})
+}
+
+// reconcileSettings reconciles the settings of the destination, if it has
+// any, subject to the `DestinationTimeout`. Errors are only logged, so they
+// don't hold up syncing the targets.
+func (s *Syncer) reconcileSettings(ctx context.Context) {
+	dst, ok := s.Dst.(SettingsDestination)
+	if !ok {
+		return
+	}
+	if err := s.callDestination(ctx, "reconcile_settings", dst.ReconcileSettings); err != nil {
+		s.log().Warnf("Error reconciling destination settings: %v", err)
+	}
+}
+
+// getTargets returns the targets from the destination, subject to the
+// `DestinationTimeout`
+func (s *Syncer) getTargets(ctx context.Context) ([]*Target, error) {
+	var targets []*Target
+	err := s.callDestination(ctx, "get_targets", func(ctx context.Context) error {
+		var err error
+		targets, err = s.Dst.GetTargets(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return targets, nil
+}
@@ -0,0 +1,32 @@
+package targetsync
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCallDestinationTimeout(t *testing.T) {
+	s := &Syncer{Config: &SyncConfig{DestinationTimeout: 50 * time.Millisecond}}
+
+	// A call which ignores the context must not block past the timeout
+	blockCh := make(chan struct{})
+	defer close(blockCh)
+	start := time.Now()
+	err := s.callDestination(context.Background(), "get_targets", func(context.Context) error {
+		<-blockCh
+		return nil
+	})
+	if err == nil {
+		t.Fatalf("Expected timeout error")
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("Call blocked for %v", d)
+	}
+
+	if err := s.callDestination(context.Background(), "get_targets", func(context.Context) error {
+		return nil
+	}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
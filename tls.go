@@ -0,0 +1,261 @@
+package targetsync
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// TLSConfig holds the files for a (mutual) TLS connection. The files are
+// reloaded whenever they are modified, so rotated certs (e.g. SPIFFE SVIDs
+// written by the spiffe-helper) are picked up without a restart.
+type TLSConfig struct {
+	// CAFile verifies the peer's certificate
+	CAFile   string `yaml:"ca_file"`
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	// ServerName is the SNI sent to, and verified against, the server. Only
+	// used by clients, it defaults to the host of the address connected to
+	ServerName string `yaml:"server_name"`
+	// SPIFFEIDs are the SPIFFE IDs (URI SANs) the peer's certificate must
+	// have one of, e.g. `spiffe://example.org/consul`. If set the server's
+	// name isn't verified, as SVIDs need not have any DNS SANs
+	SPIFFEIDs []string `yaml:"spiffe_ids"`
+}
+
+// Validate checks the TLSConfig for errors
+func (c *TLSConfig) Validate() error {
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return fmt.Errorf("TLS cert_file and key_file must be set together")
+	}
+	if len(c.SPIFFEIDs) > 0 && c.CAFile == "" {
+		return fmt.Errorf("TLS ca_file must be set to verify spiffe_ids")
+	}
+	return nil
+}
+
+// enabled returns whether any TLS options are set
+func (c *TLSConfig) enabled() bool {
+	return c.CAFile != "" || c.CertFile != "" || c.ServerName != "" || len(c.SPIFFEIDs) > 0
+}
+
+// key returns a comparable representation of the config, for caching clients
+func (c *TLSConfig) key() string {
+	if !c.enabled() {
+		return ""
+	}
+	return fmt.Sprintf("%q", []string{c.CAFile, c.CertFile, c.KeyFile, c.ServerName, fmt.Sprint(c.SPIFFEIDs)})
+}
+
+// NewServerTLSConfig returns the TLS config for a server, requiring client
+// certs signed by the `CAFile` (and with one of the `SPIFFEIDs`) if set
+func NewServerTLSConfig(cfg *TLSConfig) (*tls.Config, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if cfg.CertFile == "" || cfg.KeyFile == "" {
+		return nil, fmt.Errorf("TLS cert and key must be set")
+	}
+	r := &tlsReloader{cfg: cfg}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		// The config is returned per connection to use the current certs
+		GetConfigForClient: r.configForClient,
+	}, nil
+}
+
+// newClientTLSConfig returns the TLS config for a client connecting to
+// `host`, presenting the `CertFile` if set and verifying the server against
+// the `CAFile` if set (otherwise the system roots)
+func newClientTLSConfig(cfg *TLSConfig, host string) (*tls.Config, error) {
+	r := &tlsReloader{cfg: cfg}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	serverName := cfg.ServerName
+	if serverName == "" {
+		serverName = host
+	}
+	tlsCfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: serverName,
+	}
+	if cfg.CertFile != "" {
+		tlsCfg.GetClientCertificate = r.clientCertificate
+	}
+	if cfg.CAFile != "" {
+		// The CA may be rotated, so the server is verified against the
+		// current CA rather than the RootCAs
+		tlsCfg.InsecureSkipVerify = true
+		tlsCfg.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			return r.verify(rawCerts, serverName)
+		}
+	}
+	return tlsCfg, nil
+}
+
+// tlsReloader serves the certificate (and CAs) from files, reloading them
+// whenever the files are modified
+type tlsReloader struct {
+	cfg *TLSConfig
+
+	l       sync.Mutex
+	modTime time.Time
+	loaded  bool
+	cert    *tls.Certificate
+	cas     *x509.CertPool
+}
+
+// latestModTime returns the latest modification time of the files
+func (r *tlsReloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, path := range []string{r.cfg.CertFile, r.cfg.KeyFile, r.cfg.CAFile} {
+		if path == "" {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return latest, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// reload loads the files if they have been modified since they were last
+// loaded
+func (r *tlsReloader) reload() error {
+	modTime, err := r.latestModTime()
+	if err != nil {
+		return err
+	}
+
+	r.l.Lock()
+	defer r.l.Unlock()
+	if r.loaded && !modTime.After(r.modTime) {
+		return nil
+	}
+
+	var cert *tls.Certificate
+	if r.cfg.CertFile != "" {
+		c, err := tls.LoadX509KeyPair(r.cfg.CertFile, r.cfg.KeyFile)
+		if err != nil {
+			return fmt.Errorf("Error loading TLS cert: %v", err)
+		}
+		cert = &c
+	}
+	var cas *x509.CertPool
+	if r.cfg.CAFile != "" {
+		pem, err := ioutil.ReadFile(r.cfg.CAFile)
+		if err != nil {
+			return fmt.Errorf("Error loading TLS CA: %v", err)
+		}
+		cas = x509.NewCertPool()
+		if !cas.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("No certs found in TLS CA %s", r.cfg.CAFile)
+		}
+	}
+	if r.loaded {
+		logger.Infof("Reloaded TLS files %s %s", r.cfg.CertFile, r.cfg.CAFile)
+	}
+	r.modTime = modTime
+	r.loaded = true
+	r.cert = cert
+	r.cas = cas
+	return nil
+}
+
+// current reloads the files if modified and returns the current cert and
+// CAs, a failed reload keeps using the previous ones
+func (r *tlsReloader) current() (*tls.Certificate, *x509.CertPool) {
+	if err := r.reload(); err != nil {
+		logger.Errorf("Error reloading TLS files, using previous ones: %v", err)
+	}
+	r.l.Lock()
+	defer r.l.Unlock()
+	return r.cert, r.cas
+}
+
+// configForClient returns the server TLS config with the current certs
+func (r *tlsReloader) configForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	cert, cas := r.current()
+	cfg := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{*cert},
+	}
+	if cas != nil {
+		cfg.ClientCAs = cas
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+		if len(r.cfg.SPIFFEIDs) > 0 {
+			cfg.VerifyPeerCertificate = func(_ [][]byte, chains [][]*x509.Certificate) error {
+				if len(chains) == 0 || len(chains[0]) == 0 {
+					return fmt.Errorf("No verified client certificate")
+				}
+				return r.verifySPIFFEID(chains[0][0])
+			}
+		}
+	}
+	return cfg, nil
+}
+
+// clientCertificate returns the current cert to present to servers
+func (r *tlsReloader) clientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	cert, _ := r.current()
+	return cert, nil
+}
+
+// verify verifies the server's certificate chain against the current CAs,
+// and its name (or SPIFFE ID if `SPIFFEIDs` are set)
+func (r *tlsReloader) verify(rawCerts [][]byte, serverName string) error {
+	certs := make([]*x509.Certificate, len(rawCerts))
+	for i, raw := range rawCerts {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return fmt.Errorf("Error parsing server certificate: %v", err)
+		}
+		certs[i] = cert
+	}
+	if len(certs) == 0 {
+		return fmt.Errorf("No server certificate")
+	}
+
+	_, cas := r.current()
+	opts := x509.VerifyOptions{
+		Roots:         cas,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, cert := range certs[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	if len(r.cfg.SPIFFEIDs) == 0 {
+		opts.DNSName = serverName
+	}
+	if _, err := certs[0].Verify(opts); err != nil {
+		return err
+	}
+	if len(r.cfg.SPIFFEIDs) > 0 {
+		return r.verifySPIFFEID(certs[0])
+	}
+	return nil
+}
+
+// verifySPIFFEID checks the certificate has one of the allowed SPIFFE IDs
+func (r *tlsReloader) verifySPIFFEID(cert *x509.Certificate) error {
+	for _, uri := range cert.URIs {
+		for _, id := range r.cfg.SPIFFEIDs {
+			if uri.String() == id {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("Certificate has none of the allowed SPIFFE IDs %v", r.cfg.SPIFFEIDs)
+}
@@ -0,0 +1,133 @@
+package targetsync
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestCert writes a cert (signed by the parent, or self-signed CA if
+// nil) with the SPIFFE ID to dir/name.pem and dir/name-key.pem
+func writeTestCert(t *testing.T, dir, name, spiffeID string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	if spiffeID != "" {
+		u, _ := url.Parse(spiffeID)
+		tmpl.URIs = []*url.URL{u}
+	}
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatalf("Error creating cert: %v", err)
+	}
+	keyDer, _ := x509.MarshalECPrivateKey(key)
+	ioutil.WriteFile(filepath.Join(dir, name+".pem"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	ioutil.WriteFile(filepath.Join(dir, name+"-key.pem"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	cert, _ := x509.ParseCertificate(der)
+	return cert, key
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "targetsync")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca, caKey := writeTestCert(t, dir, "ca", "", nil, nil)
+	writeTestCert(t, dir, "server", "spiffe://example.org/consul", ca, caKey)
+	writeTestCert(t, dir, "client", "spiffe://example.org/targetsync", ca, caKey)
+	path := func(name string) string { return filepath.Join(dir, name) }
+
+	serverCfg, err := NewServerTLSConfig(&TLSConfig{
+		CAFile:    path("ca.pem"),
+		CertFile:  path("server.pem"),
+		KeyFile:   path("server-key.pem"),
+		SPIFFEIDs: []string{"spiffe://example.org/targetsync"},
+	})
+	if err != nil {
+		t.Fatalf("Error creating server config: %v", err)
+	}
+	l, err := tls.Listen("tcp", "127.0.0.1:0", serverCfg)
+	if err != nil {
+		t.Fatalf("Error listening: %v", err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.(*tls.Conn).Handshake()
+			conn.Write([]byte("ok"))
+			conn.Close()
+		}
+	}()
+
+	dial := func(cfg *TLSConfig) error {
+		clientCfg, err := newClientTLSConfig(cfg, "127.0.0.1")
+		if err != nil {
+			return err
+		}
+		conn, err := tls.Dial("tcp", l.Addr().String(), clientCfg)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		// client cert errors are only seen after the handshake
+		_, err = conn.Read(make([]byte, 2))
+		return err
+	}
+
+	clientCfg := &TLSConfig{
+		CAFile:    path("ca.pem"),
+		CertFile:  path("client.pem"),
+		KeyFile:   path("client-key.pem"),
+		SPIFFEIDs: []string{"spiffe://example.org/consul"},
+	}
+	if err := dial(clientCfg); err != nil {
+		t.Fatalf("Error connecting with mTLS: %v", err)
+	}
+
+	wrongServer := *clientCfg
+	wrongServer.SPIFFEIDs = []string{"spiffe://example.org/other"}
+	if err := dial(&wrongServer); err == nil {
+		t.Fatalf("Expected error connecting to server with unexpected SPIFFE ID")
+	}
+
+	// A client with a cert of another SPIFFE ID is rejected
+	writeTestCert(t, dir, "other", "spiffe://example.org/other", ca, caKey)
+	otherClient := *clientCfg
+	otherClient.CertFile, otherClient.KeyFile = path("other.pem"), path("other-key.pem")
+	if err := dial(&otherClient); err == nil {
+		t.Fatalf("Expected error connecting with unexpected client SPIFFE ID")
+	}
+}
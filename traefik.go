@@ -0,0 +1,266 @@
+package targetsync
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// NewTraefikDestination returns a new Traefik dynamic configuration destination
+func NewTraefikDestination(cfg *TraefikConfig) (*TraefikDestination, error) {
+	d := &TraefikDestination{
+		cfg:     cfg,
+		targets: make(map[string]*Target),
+	}
+
+	// Load any existing state from the file provider so we don't start from
+	// an empty set on restart
+	if cfg.FilePath != "" {
+		if err := d.load(); err != nil {
+			return nil, err
+		}
+	}
+
+	return d, nil
+}
+
+// TraefikDestination is a TargetDestination implementation which maintains
+// a Traefik dynamic configuration containing a single service. The config is
+// written to `FilePath` (for the file provider) and is served over HTTP (for
+// the HTTP provider) through `ServeHTTP`.
+type TraefikDestination struct {
+	cfg *TraefikConfig
+
+	l       sync.RWMutex
+	targets map[string]*Target
+
+	// writeLock serializes rendering and writing the configuration file, so
+	// an older configuration can't overwrite a newer one
+	writeLock sync.Mutex
+}
+
+// log returns the destination's Logger
+func (d *TraefikDestination) log() Logger {
+	return loggerOr(d.cfg.Logger)
+}
+
+type traefikDynamicConfig struct {
+	HTTP *traefikHTTPConfig `json:"http,omitempty" yaml:"http,omitempty"`
+	TCP  *traefikTCPConfig  `json:"tcp,omitempty" yaml:"tcp,omitempty"`
+}
+
+type traefikHTTPConfig struct {
+	Services map[string]traefikHTTPService `json:"services" yaml:"services"`
+}
+
+type traefikHTTPService struct {
+	LoadBalancer traefikHTTPLoadBalancer `json:"loadBalancer" yaml:"loadBalancer"`
+}
+
+type traefikHTTPLoadBalancer struct {
+	Servers []traefikHTTPServer `json:"servers" yaml:"servers"`
+}
+
+type traefikHTTPServer struct {
+	URL string `json:"url" yaml:"url"`
+}
+
+type traefikTCPConfig struct {
+	Services map[string]traefikTCPService `json:"services" yaml:"services"`
+}
+
+type traefikTCPService struct {
+	LoadBalancer traefikTCPLoadBalancer `json:"loadBalancer" yaml:"loadBalancer"`
+}
+
+type traefikTCPLoadBalancer struct {
+	Servers []traefikTCPServer `json:"servers" yaml:"servers"`
+}
+
+type traefikTCPServer struct {
+	Address string `json:"address" yaml:"address"`
+}
+
+// load reads the current targets from the configuration file, if it exists
+func (d *TraefikDestination) load() error {
+	b, err := ioutil.ReadFile(d.cfg.FilePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("Error loading traefik config: %v", err)
+	}
+
+	var dynamicCfg traefikDynamicConfig
+	if err := yaml.Unmarshal(b, &dynamicCfg); err != nil {
+		return fmt.Errorf("Error unmarshaling traefik config: %v", err)
+	}
+
+	var addrs []string
+	if dynamicCfg.HTTP != nil {
+		for _, server := range dynamicCfg.HTTP.Services[d.cfg.ServiceName].LoadBalancer.Servers {
+			addrs = append(addrs, server.URL)
+		}
+	}
+	if dynamicCfg.TCP != nil {
+		for _, server := range dynamicCfg.TCP.Services[d.cfg.ServiceName].LoadBalancer.Servers {
+			addrs = append(addrs, server.Address)
+		}
+	}
+
+	for _, addr := range addrs {
+		target, err := d.parseAddr(addr)
+		if err != nil {
+			d.log().Warnf("Skipping unparseable traefik server %s: %v", addr, err)
+			continue
+		}
+		d.targets[target.Key()] = target
+	}
+	return nil
+}
+
+// parseAddr converts a traefik server url/address back into a Target
+func (d *TraefikDestination) parseAddr(addr string) (*Target, error) {
+	if d.cfg.Protocol == TraefikProtocolHTTP {
+		prefix := d.cfg.Scheme + "://"
+		if len(addr) < len(prefix) || addr[:len(prefix)] != prefix {
+			return nil, fmt.Errorf("unexpected scheme")
+		}
+		addr = addr[len(prefix):]
+	}
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil, err
+	}
+	return &Target{IP: host, Port: port}, nil
+}
+
+// render generates the dynamic configuration for the current target set
+func (d *TraefikDestination) render() *traefikDynamicConfig {
+	d.l.RLock()
+	addrs := make([]string, 0, len(d.targets))
+	for _, target := range d.targets {
+		addrs = append(addrs, net.JoinHostPort(target.IP, strconv.Itoa(target.Port)))
+	}
+	d.l.RUnlock()
+	// Keep the output stable to avoid needless reloads in traefik
+	sort.Strings(addrs)
+
+	dynamicCfg := &traefikDynamicConfig{}
+	switch d.cfg.Protocol {
+	case TraefikProtocolTCP:
+		servers := make([]traefikTCPServer, len(addrs))
+		for i, addr := range addrs {
+			servers[i] = traefikTCPServer{Address: addr}
+		}
+		dynamicCfg.TCP = &traefikTCPConfig{
+			Services: map[string]traefikTCPService{
+				d.cfg.ServiceName: {LoadBalancer: traefikTCPLoadBalancer{Servers: servers}},
+			},
+		}
+	default:
+		servers := make([]traefikHTTPServer, len(addrs))
+		for i, addr := range addrs {
+			servers[i] = traefikHTTPServer{URL: d.cfg.Scheme + "://" + addr}
+		}
+		dynamicCfg.HTTP = &traefikHTTPConfig{
+			Services: map[string]traefikHTTPService{
+				d.cfg.ServiceName: {LoadBalancer: traefikHTTPLoadBalancer{Servers: servers}},
+			},
+		}
+	}
+	return dynamicCfg
+}
+
+// write atomically writes the dynamic configuration to `FilePath`
+func (d *TraefikDestination) write() error {
+	if d.cfg.FilePath == "" {
+		return nil
+	}
+	d.writeLock.Lock()
+	defer d.writeLock.Unlock()
+
+	b, err := yaml.Marshal(d.render())
+	if err != nil {
+		return err
+	}
+
+	// Keep the mode of an existing file, temp files are only readable by us
+	// which traefik may not be running as
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(d.cfg.FilePath); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	// Write to a temp file and rename so traefik never sees a partial file
+	tmp, err := ioutil.TempFile(filepath.Dir(d.cfg.FilePath), ".targetsync")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), d.cfg.FilePath)
+}
+
+// GetTargets returns the current set of targets at the destination
+func (d *TraefikDestination) GetTargets(context.Context) ([]*Target, error) {
+	d.l.RLock()
+	defer d.l.RUnlock()
+	targets := make([]*Target, 0, len(d.targets))
+	for _, target := range d.targets {
+		targets = append(targets, target)
+	}
+	return targets, nil
+}
+
+// AddTargets simply adds the targets described
+func (d *TraefikDestination) AddTargets(_ context.Context, targets []*Target) error {
+	d.l.Lock()
+	for _, target := range targets {
+		d.targets[target.Key()] = target
+	}
+	d.l.Unlock()
+	return d.write()
+}
+
+// RemoveTargets simply removes the targets described
+func (d *TraefikDestination) RemoveTargets(_ context.Context, targets []*Target) error {
+	d.l.Lock()
+	for _, target := range targets {
+		delete(d.targets, target.Key())
+	}
+	d.l.Unlock()
+	return d.write()
+}
+
+// ServeHTTP serves the dynamic configuration for traefik's HTTP provider
+func (d *TraefikDestination) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(d.render()); err != nil {
+		d.log().Errorf("Error encoding traefik config: %v", err)
+	}
+}
@@ -0,0 +1,123 @@
+package targetsync
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestTraefikRender(t *testing.T) {
+	targets := []*Target{
+		{IP: "10.0.0.2", Port: 80},
+		{IP: "10.0.0.1", Port: 80},
+		{IP: "fd00::1", Port: 8080},
+	}
+
+	d, err := NewTraefikDestination(&TraefikConfig{ServiceName: "web", Protocol: TraefikProtocolHTTP, Scheme: "http"})
+	if err != nil {
+		t.Fatalf("Error creating destination: %v", err)
+	}
+	d.AddTargets(context.Background(), targets)
+	servers := d.render().HTTP.Services["web"].LoadBalancer.Servers
+	expected := []traefikHTTPServer{
+		{URL: "http://10.0.0.1:80"},
+		{URL: "http://10.0.0.2:80"},
+		{URL: "http://[fd00::1]:8080"},
+	}
+	if !reflect.DeepEqual(servers, expected) {
+		t.Fatalf("Unexpected http servers: %v", servers)
+	}
+
+	d, err = NewTraefikDestination(&TraefikConfig{ServiceName: "web", Protocol: TraefikProtocolTCP})
+	if err != nil {
+		t.Fatalf("Error creating destination: %v", err)
+	}
+	d.AddTargets(context.Background(), targets)
+	tcpServers := d.render().TCP.Services["web"].LoadBalancer.Servers
+	expectedTCP := []traefikTCPServer{
+		{Address: "10.0.0.1:80"},
+		{Address: "10.0.0.2:80"},
+		{Address: "[fd00::1]:8080"},
+	}
+	if !reflect.DeepEqual(tcpServers, expectedTCP) {
+		t.Fatalf("Unexpected tcp servers: %v", tcpServers)
+	}
+}
+
+func TestTraefikWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "traefik")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	cfg := &TraefikConfig{
+		ServiceName: "web",
+		Protocol:    TraefikProtocolHTTP,
+		Scheme:      "http",
+		FilePath:    filepath.Join(dir, "web.yaml"),
+	}
+
+	d, err := NewTraefikDestination(cfg)
+	if err != nil {
+		t.Fatalf("Error creating destination: %v", err)
+	}
+	targets := []*Target{{IP: "10.0.0.1", Port: 80}, {IP: "fd00::1", Port: 80}}
+	if err := d.AddTargets(context.Background(), targets); err != nil {
+		t.Fatalf("Error adding targets: %v", err)
+	}
+	// Readable by traefik, which may run as another user
+	info, err := os.Stat(cfg.FilePath)
+	if err != nil {
+		t.Fatalf("Config not written: %v", err)
+	}
+	if mode := info.Mode().Perm(); mode != 0644 {
+		t.Fatalf("Unexpected config mode %v", mode)
+	}
+
+	// The mode of an existing file is kept
+	if err := os.Chmod(cfg.FilePath, 0640); err != nil {
+		t.Fatalf("Error changing mode: %v", err)
+	}
+	if err := d.RemoveTargets(context.Background(), targets[:1]); err != nil {
+		t.Fatalf("Error removing targets: %v", err)
+	}
+	if info, _ := os.Stat(cfg.FilePath); info.Mode().Perm() != 0640 {
+		t.Fatalf("Config mode not kept: %v", info.Mode().Perm())
+	}
+
+	// The targets are loaded back from the file
+	d, err = NewTraefikDestination(cfg)
+	if err != nil {
+		t.Fatalf("Error loading destination: %v", err)
+	}
+	loaded, _ := d.GetTargets(context.Background())
+	if err := equalTargets(loaded, targets[1:]); err != nil {
+		t.Fatalf("Unexpected loaded targets: %v", err)
+	}
+}
+
+func TestTraefikServeHTTP(t *testing.T) {
+	d, err := NewTraefikDestination(&TraefikConfig{ServiceName: "web", Protocol: TraefikProtocolHTTP, Scheme: "https"})
+	if err != nil {
+		t.Fatalf("Error creating destination: %v", err)
+	}
+	d.AddTargets(context.Background(), []*Target{{IP: "10.0.0.1", Port: 443}})
+
+	w := httptest.NewRecorder()
+	d.ServeHTTP(w, httptest.NewRequest("GET", "/traefik", nil))
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("Unexpected content type %s", ct)
+	}
+	var served traefikDynamicConfig
+	if err := json.NewDecoder(w.Body).Decode(&served); err != nil {
+		t.Fatalf("Error decoding config: %v", err)
+	}
+	if servers := served.HTTP.Services["web"].LoadBalancer.Servers; len(servers) != 1 || servers[0].URL != "https://10.0.0.1:443" {
+		t.Fatalf("Unexpected servers: %v", servers)
+	}
+}
@@ -0,0 +1,299 @@
+package targetsync
+
+import (
+	"fmt"
+	"math/big"
+	"net"
+	"sort"
+	"strconv"
+)
+
+// TransformConfig defines how targets are rewritten between the source and
+// the destination
+type TransformConfig struct {
+	// PortMap maps source ports to destination ports, ports not in the map
+	// are left as-is
+	PortMap map[int]int `yaml:"port_map"`
+	// StaticPort sets the port of all targets, this can't be used with PortMap
+	StaticPort int `yaml:"static_port"`
+	// IPMap maps source IPs to destination IPs (e.g. a NAT mapping table),
+	// mapping an IP to "" drops the target
+	IPMap map[string]string `yaml:"ip_map"`
+	// CIDRMap translates IPs within source CIDRs to the same host address
+	// within destination CIDRs (e.g. a VPC's private range to its range as
+	// seen from a peered VPC), mapping a CIDR to "" drops its targets. Both
+	// CIDRs must have as many host bits, so IPv4 ranges can be mapped into
+	// IPv6 ones (e.g. 10.0.0.0/8 to 64:ff9b::a00:0/104). The most specific
+	// source CIDR wins, and IPs in the IPMap aren't translated.
+	CIDRMap map[string]string `yaml:"cidr_map"`
+}
+
+// cidrRule translates IPs in `src` to `dst`, or drops them if `dst` is nil
+type cidrRule struct {
+	src, dst *net.IPNet
+}
+
+// cidrRules parses the CIDRMap, ordered from the most to least specific
+// source CIDR
+func (c *TransformConfig) cidrRules() ([]cidrRule, error) {
+	rules := make([]cidrRule, 0, len(c.CIDRMap))
+	for src, dst := range c.CIDRMap {
+		_, srcNet, err := net.ParseCIDR(src)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid cidr_map source %q: %v", src, err)
+		}
+		rule := cidrRule{src: srcNet}
+		if dst != "" {
+			_, dstNet, err := net.ParseCIDR(dst)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid cidr_map destination %q: %v", dst, err)
+			}
+			srcOnes, srcBits := srcNet.Mask.Size()
+			dstOnes, dstBits := dstNet.Mask.Size()
+			if srcBits-srcOnes != dstBits-dstOnes {
+				return nil, fmt.Errorf("cidr_map entry %s: %s must have as many host bits on both sides", src, dst)
+			}
+			rule.dst = dstNet
+		}
+		rules = append(rules, rule)
+	}
+	sort.Slice(rules, func(i, j int) bool {
+		iOnes, iBits := rules[i].src.Mask.Size()
+		jOnes, jBits := rules[j].src.Mask.Size()
+		// Compare host bits, so IPv4 and IPv6 CIDRs order consistently
+		if iBits-iOnes != jBits-jOnes {
+			return iBits-iOnes < jBits-jOnes
+		}
+		return rules[i].src.String() < rules[j].src.String()
+	})
+	return rules, nil
+}
+
+// translate returns the IP translated by the first matching rule, false if
+// the IP should be dropped
+func translate(rules []cidrRule, ip string) (string, bool) {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ip, true
+	}
+	for _, rule := range rules {
+		if !rule.src.Contains(parsed) {
+			continue
+		}
+		if rule.dst == nil {
+			return "", false
+		}
+		// Match the representation of the source CIDR, so the host bits
+		// are taken from the end of the address
+		addr := parsed.To4()
+		if len(rule.src.IP) == net.IPv6len || addr == nil {
+			addr = parsed.To16()
+		}
+		ones, bits := rule.src.Mask.Size()
+		host := new(big.Int).SetBytes(addr)
+		hostMask := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), uint(bits-ones)), big.NewInt(1))
+		host.And(host, hostMask)
+
+		out := new(big.Int).SetBytes(rule.dst.IP)
+		out.Or(out, host)
+		translated := make(net.IP, len(rule.dst.IP))
+		b := out.Bytes()
+		copy(translated[len(translated)-len(b):], b)
+		return translated.String(), true
+	}
+	return ip, true
+}
+
+// Validate checks the TransformConfig for errors
+func (c *TransformConfig) Validate() error {
+	if c.StaticPort != 0 && len(c.PortMap) > 0 {
+		return fmt.Errorf("Only one of static_port and port_map can be set")
+	}
+	if c.StaticPort < 0 || c.StaticPort > 65535 {
+		return fmt.Errorf("Invalid static_port %d", c.StaticPort)
+	}
+	for src, dst := range c.PortMap {
+		if src <= 0 || src > 65535 || dst <= 0 || dst > 65535 {
+			return fmt.Errorf("Invalid port_map entry %d: %d", src, dst)
+		}
+	}
+	if _, err := c.cidrRules(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// enabled returns whether there are any transforms configured
+func (c *TransformConfig) enabled() bool {
+	return c.StaticPort != 0 || len(c.PortMap) > 0 || len(c.IPMap) > 0 || len(c.CIDRMap) > 0
+}
+
+// transform filters the targets from the source by zone, runs them through
+// the filter chain and expressions, weighs them by capacity and applies the
+// transforms
+func (s *Syncer) transform(targets []*Target) []*Target {
+	targets = s.Config.ZoneAffinity.Filter(targets)
+	for _, filter := range s.Config.Filters {
+		targets = filter.Apply(targets)
+	}
+	targets = s.Config.Expr.Apply(targets)
+	targets = s.Config.CapacityWeight.Apply(targets)
+	return s.Config.SelfExclusion.Filter(s.Config.Transform.Apply(targets), s.LocalAddr)
+}
+
+// rewrite applies only the rewrites of the filter chain and the transforms,
+// for removed targets which may not carry the meta the filters match on
+func (s *Syncer) rewrite(targets []*Target) []*Target {
+	for _, filter := range s.Config.Filters {
+		targets = filter.TransformConfig.Apply(targets)
+	}
+	// Only the port is rewritten, the removed targets must not be matched
+	targets = (&ExprConfig{Port: s.Config.Expr.Port}).Apply(targets)
+	return s.Config.Transform.Apply(targets)
+}
+
+// Apply returns the targets with the transforms applied. The targets passed in
+// are never modified as they may be shared with the source.
+func (c *TransformConfig) Apply(targets []*Target) []*Target {
+	if !c.enabled() {
+		return targets
+	}
+
+	// The rules were checked by Validate
+	rules, _ := c.cidrRules()
+
+	transformed := make([]*Target, 0, len(targets))
+	for _, target := range targets {
+		t := *target
+		if ip, ok := c.IPMap[t.IP]; ok {
+			if ip == "" {
+				continue
+			}
+			t.IP = ip
+		} else if len(rules) > 0 {
+			ip, ok := translate(rules, t.IP)
+			if !ok {
+				continue
+			}
+			t.IP = ip
+		}
+		if c.StaticPort != 0 {
+			t.Port = c.StaticPort
+		} else if port, ok := c.PortMap[t.Port]; ok {
+			t.Port = port
+		}
+		transformed = append(transformed, &t)
+	}
+	return transformed
+}
+
+// FilterConfig is a reusable step of a pipeline's filter chain (see
+// `PipelineConfig`), the options are applied in the order below
+type FilterConfig struct {
+	// Meta keeps only the targets with all of the meta key/values (e.g.
+	// consul service meta or k8s labels)
+	Meta map[string]string `yaml:"meta"`
+	// Ports keeps only the targets on one of the ports
+	Ports []int `yaml:"ports"`
+	// TransformConfig rewrites the ports and IPs of the targets
+	TransformConfig `yaml:",inline"`
+	// WeightMap sets the weight (MetaWeight) of targets by the value of
+	// their WeightKey meta
+	WeightKey string         `yaml:"weight_key"`
+	WeightMap map[string]int `yaml:"weight_map"`
+}
+
+// Validate checks the FilterConfig for errors
+func (c *FilterConfig) Validate() error {
+	if len(c.WeightMap) > 0 && c.WeightKey == "" {
+		return fmt.Errorf("weight_key must be set to use a weight_map")
+	}
+	for value, weight := range c.WeightMap {
+		if weight < 0 {
+			return fmt.Errorf("Invalid weight_map entry %s: %d", value, weight)
+		}
+	}
+	for _, port := range c.Ports {
+		if port <= 0 || port > 65535 {
+			return fmt.Errorf("Invalid port %d", port)
+		}
+	}
+	return c.TransformConfig.Validate()
+}
+
+// Apply returns the targets which match the filter, with the rewrites
+// applied. The targets passed in are never modified.
+func (c *FilterConfig) Apply(targets []*Target) []*Target {
+	if len(c.Meta) > 0 {
+		matched := make([]*Target, 0, len(targets))
+		for _, target := range targets {
+			if metaMatches(target, c.Meta) {
+				matched = append(matched, target)
+			}
+		}
+		targets = matched
+	}
+	if len(c.Ports) > 0 {
+		matched := make([]*Target, 0, len(targets))
+		for _, target := range targets {
+			if portMatches(target, c.Ports) {
+				matched = append(matched, target)
+			}
+		}
+		targets = matched
+	}
+
+	targets = c.TransformConfig.Apply(targets)
+
+	if len(c.WeightMap) > 0 {
+		weighted := make([]*Target, len(targets))
+		for i, target := range targets {
+			weighted[i] = target
+			weight, ok := c.WeightMap[target.Meta[c.WeightKey]]
+			if !ok {
+				continue
+			}
+			t := *target
+			t.Meta = make(map[string]string, len(target.Meta)+1)
+			for k, v := range target.Meta {
+				t.Meta[k] = v
+			}
+			t.Meta[MetaWeight] = strconv.Itoa(weight)
+			weighted[i] = &t
+		}
+		targets = weighted
+	}
+	return targets
+}
+
+// metaMatches returns whether the target has all of the meta key/values
+func metaMatches(target *Target, meta map[string]string) bool {
+	for k, v := range meta {
+		if value, ok := target.Meta[k]; !ok || value != v {
+			return false
+		}
+	}
+	return true
+}
+
+// portMatches returns whether the target is on one of the ports
+func portMatches(target *Target, ports []int) bool {
+	for _, port := range ports {
+		if target.Port == port {
+			return true
+		}
+	}
+	return false
+}
+
+// targetWeight returns the weight from the target's MetaWeight, or `def` if
+// it isn't set
+func targetWeight(target *Target, def int) int {
+	if v, ok := target.Meta[MetaWeight]; ok {
+		if weight, err := strconv.Atoi(v); err == nil && weight >= 0 {
+			return weight
+		}
+		logger.Warnf("Ignoring invalid %s %q on target %v", MetaWeight, v, target)
+	}
+	return def
+}
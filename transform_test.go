@@ -0,0 +1,120 @@
+package targetsync
+
+import "testing"
+
+func TestTransform(t *testing.T) {
+	cfg := &TransformConfig{
+		PortMap: map[int]int{8080: 80},
+		IPMap: map[string]string{
+			"10.0.0.1": "192.168.0.1",
+			"10.0.0.2": "",
+		},
+	}
+	src := []*Target{
+		{IP: "10.0.0.1", Port: 8080},
+		{IP: "10.0.0.2", Port: 8080},
+		{IP: "10.0.0.3", Port: 9090},
+	}
+
+	targets := cfg.Apply(src)
+	expected := []Target{
+		{IP: "192.168.0.1", Port: 80},
+		{IP: "10.0.0.3", Port: 9090},
+	}
+	if len(targets) != len(expected) {
+		t.Fatalf("Expected %d targets, got %d", len(expected), len(targets))
+	}
+	for i, target := range targets {
+		if target.IP != expected[i].IP || target.Port != expected[i].Port {
+			t.Fatalf("Mismatch at %d expected=%v actual=%v", i, expected[i], target)
+		}
+	}
+	// the source targets must not be modified
+	if src[0].IP != "10.0.0.1" || src[0].Port != 8080 {
+		t.Fatalf("Source target was modified: %v", src[0])
+	}
+
+	cfg = &TransformConfig{StaticPort: 443}
+	if targets := cfg.Apply(src); targets[2].Port != 443 {
+		t.Fatalf("Expected static port to be applied, got %d", targets[2].Port)
+	}
+}
+
+func TestFilter(t *testing.T) {
+	cfg := &FilterConfig{
+		Meta:            map[string]string{"role": "web"},
+		TransformConfig: TransformConfig{StaticPort: 443},
+		WeightKey:       "version",
+		WeightMap:       map[string]int{"v2": 10},
+	}
+	src := []*Target{
+		{IP: "10.0.0.1", Port: 80, Meta: map[string]string{"role": "web", "version": "v1"}},
+		{IP: "10.0.0.2", Port: 80, Meta: map[string]string{"role": "web", "version": "v2"}},
+		{IP: "10.0.0.3", Port: 80, Meta: map[string]string{"role": "db"}},
+	}
+
+	targets := cfg.Apply(src)
+	if len(targets) != 2 {
+		t.Fatalf("Expected 2 targets, got %d", len(targets))
+	}
+	if targets[0].Port != 443 || targets[1].Port != 443 {
+		t.Fatalf("Expected static port to be applied: %v", targets)
+	}
+	if _, ok := targets[0].Meta[MetaWeight]; ok {
+		t.Fatalf("Unexpected weight on %v", targets[0])
+	}
+	if weight := targetWeight(targets[1], 1); weight != 10 {
+		t.Fatalf("Expected weight 10, got %d", weight)
+	}
+	if _, ok := src[1].Meta[MetaWeight]; ok {
+		t.Fatalf("Source target was modified: %v", src[1])
+	}
+}
+
+func TestTransformCIDRMap(t *testing.T) {
+	cfg := &TransformConfig{
+		IPMap: map[string]string{"10.0.0.1": "192.168.0.1"},
+		CIDRMap: map[string]string{
+			"10.0.0.0/16":   "100.64.0.0/16",
+			"10.0.5.0/24":   "100.65.5.0/24",
+			"10.0.9.0/24":   "",
+			"10.1.0.0/16":   "64:ff9b::a01:0/112",
+			"fd00::/64":     "2001:db8:1::/64",
+			"172.16.0.0/12": "172.16.0.0/12",
+		},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Unexpected validation error: %v", err)
+	}
+	for ip, expected := range map[string]string{
+		"10.0.0.1":     "192.168.0.1",
+		"10.0.1.2":     "100.64.1.2",
+		"10.0.5.7":     "100.65.5.7",
+		"10.0.9.1":     "",
+		"10.1.2.3":     "64:ff9b::a01:203",
+		"fd00::1:2":    "2001:db8:1::1:2",
+		"192.168.10.1": "192.168.10.1",
+	} {
+		targets := cfg.Apply([]*Target{{IP: ip, Port: 80}})
+		if expected == "" {
+			if len(targets) != 0 {
+				t.Fatalf("Expected %s to be dropped, got %v", ip, targets)
+			}
+			continue
+		}
+		if len(targets) != 1 || targets[0].IP != expected {
+			t.Fatalf("Expected %s to be translated to %s, got %v", ip, expected, targets)
+		}
+	}
+
+	for _, cidrMap := range []map[string]string{
+		{"10.0.0.0/16": "100.64.0.0/24"},
+		{"10.0.0.0": "100.64.0.0/16"},
+		{"10.0.0.0/16": "not a cidr"},
+	} {
+		cfg := &TransformConfig{CIDRMap: cidrMap}
+		if err := cfg.Validate(); err == nil {
+			t.Fatalf("Expected validation error for %v", cidrMap)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package targetsync
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// NewSQSTrigger returns a new trigger for the messages on an SQS queue
+func NewSQSTrigger(cfg *TriggerConfig) (*SQSTrigger, error) {
+	sess, err := awsSession(cfg.Region, cfg.Credentials)
+	if err != nil {
+		return nil, err
+	}
+	return &SQSTrigger{
+		cfg: cfg,
+		sqs: sqs.New(sess),
+	}, nil
+}
+
+// SQSTrigger is a ReconcileTrigger which fires whenever messages arrive on an
+// SQS queue (e.g. sent by a CI pipeline, or as the target of an EventBridge
+// rule). The messages are deleted once received, their content is ignored.
+type SQSTrigger struct {
+	cfg *TriggerConfig
+	sqs *sqs.SQS
+}
+
+// Subscribe fires the channel for each batch of messages received
+func (t *SQSTrigger) Subscribe(ctx context.Context) (<-chan struct{}, error) {
+	ch := make(chan struct{}, 1)
+	go watchSQS(ctx, t.sqs, t.cfg.QueueURL, "reconcile trigger", ch)
+	return ch, nil
+}
+
+// watchSQS receives the messages from the SQS queue, signalling `ch` for each
+// batch received. Messages are deleted once received.
+func watchSQS(ctx context.Context, client *sqs.SQS, queueURL, desc string, ch chan struct{}) {
+	for {
+		result, err := client.ReceiveMessageWithContext(ctx, &sqs.ReceiveMessageInput{
+			QueueUrl:            aws.String(queueURL),
+			MaxNumberOfMessages: aws.Int64(10),
+			WaitTimeSeconds:     aws.Int64(20),
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			logger.Errorf("Error receiving %s messages: %v", desc, err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(5 * time.Second):
+			}
+			continue
+		}
+		if len(result.Messages) == 0 {
+			continue
+		}
+
+		logger.Debugf("Received %d %s messages", len(result.Messages), desc)
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+
+		for _, msg := range result.Messages {
+			if _, err := client.DeleteMessageWithContext(ctx, &sqs.DeleteMessageInput{
+				QueueUrl:      aws.String(queueURL),
+				ReceiptHandle: msg.ReceiptHandle,
+			}); err != nil {
+				logger.Warnf("Error deleting %s message: %v", desc, err)
+			}
+		}
+	}
+}
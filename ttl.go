@@ -0,0 +1,124 @@
+package targetsync
+
+import (
+	"time"
+)
+
+// expiryTracker tracks when targets with a TTL (`MetaTTL`) expire, by IP
+type expiryTracker struct {
+	log     Logger
+	expires map[string]time.Time
+	timer   *time.Timer
+}
+
+func newExpiryTracker(log Logger) *expiryTracker {
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+	return &expiryTracker{
+		log:     log,
+		expires: make(map[string]time.Time),
+		timer:   timer,
+	}
+}
+
+// observe refreshes the expiry of the targets, targets without a TTL never
+// expire
+func (e *expiryTracker) observe(targets []*Target) {
+	now := time.Now()
+	for _, target := range targets {
+		v, ok := target.Meta[MetaTTL]
+		if !ok {
+			delete(e.expires, target.IP)
+			continue
+		}
+		ttl, err := time.ParseDuration(v)
+		if err != nil || ttl <= 0 {
+			e.log.Warnf("Ignoring invalid %s %q on target %v", MetaTTL, v, target)
+			delete(e.expires, target.IP)
+			continue
+		}
+		e.expires[target.IP] = now.Add(ttl)
+	}
+	e.reset()
+}
+
+// observeSnapshot refreshes the expiry of the targets, forgetting any targets
+// no longer in the source
+func (e *expiryTracker) observeSnapshot(targets []*Target) {
+	current := make(map[string]struct{}, len(targets))
+	for _, target := range targets {
+		current[target.IP] = struct{}{}
+	}
+	for ip := range e.expires {
+		if _, ok := current[ip]; !ok {
+			delete(e.expires, ip)
+		}
+	}
+	e.observe(targets)
+}
+
+// forget stops tracking the expiry of the target
+func (e *expiryTracker) forget(target *Target) {
+	delete(e.expires, target.IP)
+}
+
+// live returns the targets which haven't expired
+func (e *expiryTracker) live(targets []*Target) []*Target {
+	if len(e.expires) == 0 {
+		return targets
+	}
+	now := time.Now()
+	live := make([]*Target, 0, len(targets))
+	for _, target := range targets {
+		if expires, ok := e.expires[target.IP]; ok && !expires.After(now) {
+			continue
+		}
+		live = append(live, target)
+	}
+	return live
+}
+
+// expire returns the IPs of the targets which have expired, they are no
+// longer tracked
+func (e *expiryTracker) expire() []string {
+	now := time.Now()
+	var expired []string
+	for ip, expires := range e.expires {
+		if !expires.After(now) {
+			expired = append(expired, ip)
+			delete(e.expires, ip)
+		}
+	}
+	e.reset()
+	return expired
+}
+
+// C fires when the next target expires
+func (e *expiryTracker) C() <-chan time.Time {
+	return e.timer.C
+}
+
+// reset sets the timer to the next target expiry in the future
+func (e *expiryTracker) reset() {
+	if !e.timer.Stop() {
+		select {
+		case <-e.timer.C:
+		default:
+		}
+	}
+	now := time.Now()
+	var next time.Time
+	for _, expires := range e.expires {
+		if expires.After(now) && (next.IsZero() || expires.Before(next)) {
+			next = expires
+		}
+	}
+	if !next.IsZero() {
+		e.timer.Reset(next.Sub(now))
+	}
+}
+
+// stop stops the timer
+func (e *expiryTracker) stop() {
+	e.timer.Stop()
+}
@@ -0,0 +1,36 @@
+package targetsync
+
+import (
+	"testing"
+	"time"
+)
+
+func TestExpiryTracker(t *testing.T) {
+	e := newExpiryTracker(logger)
+	defer e.stop()
+
+	targets := []*Target{
+		{IP: "10.0.0.1", Meta: map[string]string{MetaTTL: "50ms"}},
+		{IP: "10.0.0.2"},
+	}
+	e.observeSnapshot(targets)
+	if live := e.live(targets); len(live) != 2 {
+		t.Fatalf("Expected 2 live targets, got %d", len(live))
+	}
+
+	select {
+	case <-e.C():
+	case <-time.After(time.Second):
+		t.Fatalf("Expiry timer didn't fire")
+	}
+	live := e.live(targets)
+	if len(live) != 1 || live[0].IP != "10.0.0.2" {
+		t.Fatalf("Expected only the target without a TTL to be live, got %v", live)
+	}
+
+	// sending the target again refreshes its TTL
+	e.observeSnapshot(targets)
+	if live := e.live(targets); len(live) != 2 {
+		t.Fatalf("Expected 2 live targets after refresh, got %d", len(live))
+	}
+}
@@ -0,0 +1,88 @@
+package targetsync
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// CapacityWeightConfig sets the weight (MetaWeight) of targets from their
+// capacity in the source meta, such as the instance type or vCPU count, so
+// heterogenous fleets get traffic in proportion to their capacity in
+// destinations which support weights
+type CapacityWeightConfig struct {
+	// Key is the source meta key holding the capacity, e.g. `aws/instance-type`
+	// or `aws/vcpus` (set by the asg source)
+	Key string `yaml:"key"`
+	// Map maps the meta values (e.g. instance types) to weights
+	Map map[string]int `yaml:"map"`
+	// PerUnit sets the weight of numeric meta values not in the Map (e.g. a
+	// vCPU count) to the value times PerUnit
+	PerUnit int `yaml:"per_unit"`
+	// Default is the weight of targets without a mapped value, if 0 their
+	// weight is left unset
+	Default int `yaml:"default"`
+}
+
+// Validate checks the CapacityWeightConfig for errors
+func (c *CapacityWeightConfig) Validate() error {
+	if c.Key == "" {
+		if len(c.Map) > 0 || c.PerUnit != 0 || c.Default != 0 {
+			return fmt.Errorf("Capacity weight key must be set")
+		}
+		return nil
+	}
+	for value, weight := range c.Map {
+		if weight < 0 {
+			return fmt.Errorf("Invalid capacity weight map entry %s: %d", value, weight)
+		}
+	}
+	if c.PerUnit < 0 || c.Default < 0 {
+		return fmt.Errorf("Capacity weight per_unit and default must be >=0")
+	}
+	return nil
+}
+
+// weight returns the weight of the capacity meta value, and whether it has one
+func (c *CapacityWeightConfig) weight(value string) (int, bool) {
+	if weight, ok := c.Map[value]; ok {
+		return weight, true
+	}
+	if c.PerUnit > 0 {
+		if units, err := strconv.Atoi(value); err == nil && units >= 0 {
+			return units * c.PerUnit, true
+		}
+	}
+	if c.Default > 0 {
+		return c.Default, true
+	}
+	return 0, false
+}
+
+// Apply returns the targets with their weight set from their capacity.
+// Targets which already have a MetaWeight keep it, and the targets passed in
+// are never modified.
+func (c *CapacityWeightConfig) Apply(targets []*Target) []*Target {
+	if c.Key == "" {
+		return targets
+	}
+
+	weighted := make([]*Target, len(targets))
+	for i, target := range targets {
+		weighted[i] = target
+		if _, ok := target.Meta[MetaWeight]; ok {
+			continue
+		}
+		weight, ok := c.weight(target.Meta[c.Key])
+		if !ok {
+			continue
+		}
+		t := *target
+		t.Meta = make(map[string]string, len(target.Meta)+1)
+		for k, v := range target.Meta {
+			t.Meta[k] = v
+		}
+		t.Meta[MetaWeight] = strconv.Itoa(weight)
+		weighted[i] = &t
+	}
+	return weighted
+}
@@ -0,0 +1,34 @@
+package targetsync
+
+import "testing"
+
+func TestCapacityWeight(t *testing.T) {
+	cfg := &CapacityWeightConfig{
+		Key:     MetaVCPUs,
+		Map:     map[string]int{"96": 50},
+		PerUnit: 10,
+		Default: 1,
+	}
+	src := []*Target{
+		{IP: "10.0.0.1", Meta: map[string]string{MetaVCPUs: "2"}},
+		{IP: "10.0.0.2", Meta: map[string]string{MetaVCPUs: "96"}},
+		{IP: "10.0.0.3"},
+		{IP: "10.0.0.4", Meta: map[string]string{MetaVCPUs: "8", MetaWeight: "5"}},
+	}
+
+	targets := cfg.Apply(src)
+	for i, expected := range []int{20, 50, 1, 5} {
+		if weight := targetWeight(targets[i], 0); weight != expected {
+			t.Fatalf("Mismatch at %d expected=%d actual=%d", i, expected, weight)
+		}
+	}
+	// the source targets must not be modified
+	if _, ok := src[0].Meta[MetaWeight]; ok {
+		t.Fatalf("Source target was modified: %v", src[0])
+	}
+
+	cfg = &CapacityWeightConfig{Map: map[string]int{"c5.large": 2}}
+	if err := cfg.Validate(); err == nil {
+		t.Fatalf("Expected error without a key")
+	}
+}
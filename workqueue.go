@@ -0,0 +1,287 @@
+package targetsync
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultWorkQueueRetryBackoff is the initial backoff for retrying failed
+	// reconciles if `RetryBackoff` isn't set
+	defaultWorkQueueRetryBackoff = time.Second
+	// defaultWorkQueueMaxRetryBackoff is the max backoff for retrying failed
+	// reconciles if `MaxRetryBackoff` isn't set
+	defaultWorkQueueMaxRetryBackoff = 5 * time.Minute
+)
+
+// WorkQueueConfig configures the work queue the syncers' reconciles are run
+// from
+type WorkQueueConfig struct {
+	// Workers is the number of reconciles run concurrently, a sync pair is
+	// only reconciled by one worker at a time. Defaults to one per sync pair
+	Workers int `yaml:"workers"`
+	// RetryBackoff is how long to wait before retrying a failed reconcile,
+	// doubling on each consecutive failure of the pair up to MaxRetryBackoff.
+	// Defaults to 1s and 5m
+	RetryBackoff    time.Duration `yaml:"retry_backoff"`
+	MaxRetryBackoff time.Duration `yaml:"max_retry_backoff"`
+}
+
+// Validate checks the WorkQueueConfig for errors
+func (c *WorkQueueConfig) Validate() error {
+	if c.Workers < 0 {
+		return fmt.Errorf("Work queue workers must be >=0")
+	}
+	if c.RetryBackoff < 0 || c.MaxRetryBackoff < 0 {
+		return fmt.Errorf("Work queue retry backoffs must be >=0")
+	}
+	return nil
+}
+
+// isSet returns whether any of the options are set
+func (c *WorkQueueConfig) isSet() bool {
+	return c.Workers != 0 || c.RetryBackoff != 0 || c.MaxRetryBackoff != 0
+}
+
+// backoff returns how long to wait before retrying a reconcile which has
+// failed `failures` times in a row
+func (c *WorkQueueConfig) backoff(failures int) time.Duration {
+	backoff := c.RetryBackoff
+	if backoff <= 0 {
+		backoff = defaultWorkQueueRetryBackoff
+	}
+	maxBackoff := c.MaxRetryBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = defaultWorkQueueMaxRetryBackoff
+	}
+	for i := 1; i < failures && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+	return backoff
+}
+
+// NewWorkQueue returns an empty WorkQueue, its workers are started by `Run`
+func NewWorkQueue(cfg *WorkQueueConfig) *WorkQueue {
+	return &WorkQueue{
+		cfg:         *cfg,
+		dirty:       make(map[string]struct{}),
+		processing:  make(map[string]struct{}),
+		failures:    make(map[string]int),
+		waiting:     make(map[string]*time.Timer),
+		reconcilers: make(map[string]*workQueueReconciler),
+		notify:      make(chan struct{}),
+	}
+}
+
+// WorkQueue runs the reconciles of Syncers on a pool of workers, keyed by the
+// name of the sync pair. Changes to a pair's targets queue its key, which is
+// only queued once however many changes are made before a worker gets to it.
+// A key is processed by one worker at a time, if it is queued again while
+// being processed it is processed again once done. Failed reconciles are
+// retried with a per key exponential backoff, rather than stopping the sync.
+type WorkQueue struct {
+	cfg WorkQueueConfig
+
+	l sync.Mutex
+	// queue holds the keys waiting for a worker, in order
+	queue []string
+	// dirty holds the keys which need processing, whether waiting for a
+	// worker or to be queued once their current processing is done
+	dirty      map[string]struct{}
+	processing map[string]struct{}
+	// failures is the number of consecutive failed reconciles of each key
+	failures map[string]int
+	// waiting holds the retry timers of failed keys
+	waiting map[string]*time.Timer
+	// reconcilers are the registered reconcile funcs of each key
+	reconcilers map[string]*workQueueReconciler
+	// notify is closed (and replaced) whenever a key is queued
+	notify chan struct{}
+}
+
+// workQueueReconciler is a registration of a reconcile func, registrations
+// are compared by pointer
+type workQueueReconciler struct {
+	fn func() error
+}
+
+// register sets the reconcile func run for the key, until the returned func
+// is called. Unregistering has no effect once the key has been registered
+// again, so an old registration can't remove its replacement.
+func (q *WorkQueue) register(key string, fn func() error) func() {
+	q.l.Lock()
+	defer q.l.Unlock()
+	reg := &workQueueReconciler{fn: fn}
+	q.reconcilers[key] = reg
+	return func() {
+		q.l.Lock()
+		defer q.l.Unlock()
+		if q.reconcilers[key] != reg {
+			return
+		}
+		delete(q.reconcilers, key)
+		delete(q.failures, key)
+		if t, ok := q.waiting[key]; ok {
+			t.Stop()
+			delete(q.waiting, key)
+		}
+	}
+}
+
+// Add queues the key to be reconciled, unless it is already queued. Keys
+// without a registered reconcile func are ignored.
+func (q *WorkQueue) Add(key string) {
+	q.l.Lock()
+	defer q.l.Unlock()
+	q.addLocked(key)
+}
+
+func (q *WorkQueue) addLocked(key string) {
+	if _, ok := q.reconcilers[key]; !ok {
+		return
+	}
+	// Queued now, so there is no need for a pending retry
+	if t, ok := q.waiting[key]; ok {
+		t.Stop()
+		delete(q.waiting, key)
+	}
+	if _, ok := q.dirty[key]; ok {
+		return
+	}
+	q.dirty[key] = struct{}{}
+	if _, ok := q.processing[key]; ok {
+		// Queued by `done` once the current reconcile finishes
+		return
+	}
+	q.push(key)
+}
+
+// push appends the key to the queue and wakes the workers, the lock must be
+// held
+func (q *WorkQueue) push(key string) {
+	q.queue = append(q.queue, key)
+	workQueueDepth.Set(float64(len(q.queue)))
+	close(q.notify)
+	q.notify = make(chan struct{})
+}
+
+// AddRateLimited queues the key once its retry backoff has passed, returning
+// the backoff. Each call (until the key is forgotten) doubles the backoff.
+func (q *WorkQueue) AddRateLimited(key string) time.Duration {
+	q.l.Lock()
+	defer q.l.Unlock()
+	q.failures[key]++
+	backoff := q.cfg.backoff(q.failures[key])
+	if _, ok := q.waiting[key]; ok {
+		return backoff
+	}
+	q.waiting[key] = time.AfterFunc(backoff, func() {
+		q.l.Lock()
+		defer q.l.Unlock()
+		delete(q.waiting, key)
+		q.addLocked(key)
+	})
+	return backoff
+}
+
+// Forget resets the retry backoff of the key, after a successful reconcile
+func (q *WorkQueue) Forget(key string) {
+	q.l.Lock()
+	defer q.l.Unlock()
+	delete(q.failures, key)
+}
+
+// Len returns the number of keys waiting for a worker
+func (q *WorkQueue) Len() int {
+	q.l.Lock()
+	defer q.l.Unlock()
+	return len(q.queue)
+}
+
+// get blocks until a key is queued, and marks it as processing. False is
+// returned if the context is done first.
+func (q *WorkQueue) get(ctx context.Context) (string, bool) {
+	for {
+		q.l.Lock()
+		if len(q.queue) > 0 {
+			key := q.queue[0]
+			q.queue = q.queue[1:]
+			workQueueDepth.Set(float64(len(q.queue)))
+			delete(q.dirty, key)
+			q.processing[key] = struct{}{}
+			// Processed now, so there is no need for a pending retry
+			if t, ok := q.waiting[key]; ok {
+				t.Stop()
+				delete(q.waiting, key)
+			}
+			q.l.Unlock()
+			return key, true
+		}
+		notify := q.notify
+		q.l.Unlock()
+
+		select {
+		case <-notify:
+		case <-ctx.Done():
+			return "", false
+		}
+	}
+}
+
+// done marks the key as no longer processing, queueing it again if it was
+// added while being processed
+func (q *WorkQueue) done(key string) {
+	q.l.Lock()
+	defer q.l.Unlock()
+	delete(q.processing, key)
+	if _, ok := q.dirty[key]; ok {
+		q.push(key)
+	}
+}
+
+// process runs the reconcile func of the key, retrying it if it fails
+func (q *WorkQueue) process(key string) {
+	defer q.done(key)
+	q.l.Lock()
+	reg := q.reconcilers[key]
+	q.l.Unlock()
+	if reg == nil {
+		return
+	}
+
+	if err := reg.fn(); err != nil {
+		reconcileRetriesTotal.WithLabelValues(key).Inc()
+		backoff := q.AddRateLimited(key)
+		logger.Warnf("Error reconciling %s, retrying in %v: %v", key, backoff, err)
+		return
+	}
+	q.Forget(key)
+}
+
+// Run runs the workers until the context is done
+func (q *WorkQueue) Run(ctx context.Context) {
+	workers := q.cfg.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				key, ok := q.get(ctx)
+				if !ok {
+					return
+				}
+				q.process(key)
+			}
+		}()
+	}
+	wg.Wait()
+}
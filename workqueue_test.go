@@ -0,0 +1,164 @@
+package targetsync
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestWorkQueueCoalesce(t *testing.T) {
+	q := NewWorkQueue(&WorkQueueConfig{})
+	calls := make(chan string, 10)
+	for _, key := range []string{"a", "b"} {
+		key := key
+		defer q.register(key, func() error {
+			calls <- key
+			return nil
+		})()
+	}
+
+	// Unregistered keys are ignored, and queued keys only queued once
+	q.Add("a")
+	q.Add("b")
+	q.Add("a")
+	q.Add("c")
+	if l := q.Len(); l != 2 {
+		t.Fatalf("Expected 2 queued keys, got %d", l)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go q.Run(ctx)
+	for _, expected := range []string{"a", "b"} {
+		select {
+		case key := <-calls:
+			if key != expected {
+				t.Fatalf("Expected %s to be reconciled, got %s", expected, key)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Timed out waiting for %s to be reconciled", expected)
+		}
+	}
+	select {
+	case key := <-calls:
+		t.Fatalf("Unexpected reconcile of %s", key)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestWorkQueueAddWhileProcessing(t *testing.T) {
+	q := NewWorkQueue(&WorkQueueConfig{Workers: 2})
+	started := make(chan struct{}, 10)
+	release := make(chan struct{})
+	defer q.register("a", func() error {
+		started <- struct{}{}
+		<-release
+		return nil
+	})()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go q.Run(ctx)
+
+	q.Add("a")
+	<-started
+	// Not processed by the other worker while the first is still running
+	q.Add("a")
+	select {
+	case <-started:
+		t.Fatalf("Key processed concurrently")
+	case <-time.After(50 * time.Millisecond):
+	}
+	release <- struct{}{}
+	select {
+	case <-started:
+	case <-time.After(time.Second):
+		t.Fatalf("Key added while processing wasn't processed again")
+	}
+	close(release)
+}
+
+func TestWorkQueueRetry(t *testing.T) {
+	q := NewWorkQueue(&WorkQueueConfig{RetryBackoff: 10 * time.Millisecond})
+	attempts := make(chan int, 10)
+	attempt := 0
+	defer q.register("a", func() error {
+		attempt++
+		attempts <- attempt
+		if attempt < 3 {
+			return fmt.Errorf("attempt %d failed", attempt)
+		}
+		return nil
+	})()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go q.Run(ctx)
+
+	q.Add("a")
+	for i := 1; i <= 3; i++ {
+		select {
+		case n := <-attempts:
+			if n != i {
+				t.Fatalf("Expected attempt %d, got %d", i, n)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Timed out waiting for attempt %d", i)
+		}
+	}
+
+	// The successful reconcile resets the backoff
+	time.Sleep(10 * time.Millisecond)
+	q.l.Lock()
+	failures := q.failures["a"]
+	q.l.Unlock()
+	if failures != 0 {
+		t.Fatalf("Expected failures to be forgotten, got %d", failures)
+	}
+}
+
+func TestWorkQueueBackoff(t *testing.T) {
+	cfg := &WorkQueueConfig{RetryBackoff: time.Second, MaxRetryBackoff: 5 * time.Second}
+	for failures, expected := range map[int]time.Duration{
+		1: time.Second,
+		2: 2 * time.Second,
+		3: 4 * time.Second,
+		4: 5 * time.Second,
+		9: 5 * time.Second,
+	} {
+		if backoff := cfg.backoff(failures); backoff != expected {
+			t.Fatalf("Expected backoff of %v after %d failures, got %v", expected, failures, backoff)
+		}
+	}
+}
+
+func TestWorkQueueReregister(t *testing.T) {
+	q := NewWorkQueue(&WorkQueueConfig{})
+	calls := make(chan string, 10)
+	unregisterOld := q.register("a", func() error {
+		calls <- "old"
+		return nil
+	})
+	// Registered again (e.g. by a new leader term) before the old
+	// registration is removed
+	defer q.register("a", func() error {
+		calls <- "new"
+		return nil
+	})()
+	unregisterOld()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go q.Run(ctx)
+
+	q.Add("a")
+	select {
+	case call := <-calls:
+		if call != "new" {
+			t.Fatalf("Expected the new registration to be reconciled, got %s", call)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Key dropped after the old registration was removed")
+	}
+}
@@ -0,0 +1,110 @@
+package targetsync
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/ec2metadata"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+// ZoneAffinityLocal defines which part of the local instance's placement
+// the targets are restricted to
+type ZoneAffinityLocal string
+
+const (
+	// ZoneAffinityLocalZone restricts targets to the local availability zone
+	ZoneAffinityLocalZone ZoneAffinityLocal = "zone"
+	// ZoneAffinityLocalRegion restricts targets to the local region
+	ZoneAffinityLocalRegion ZoneAffinityLocal = "region"
+)
+
+// ZoneAffinityConfig restricts the synced targets to a single availability
+// zone or region, based on the zone in the target's source meta. Targets
+// without a zone are dropped.
+type ZoneAffinityConfig struct {
+	// Zone only syncs targets in this availability zone
+	Zone string `yaml:"zone"`
+	// Region only syncs targets in the availability zones of this region
+	Region string `yaml:"region"`
+	// Local sets the Zone or Region from the EC2 instance metadata of the
+	// local instance, see `ResolveLocal`
+	Local ZoneAffinityLocal `yaml:"local"`
+	// MetaKey is the source meta key holding the target's zone, defaults to
+	// `MetaAvailabilityZone`
+	MetaKey string `yaml:"meta_key"`
+}
+
+// Validate checks the ZoneAffinityConfig for errors
+func (c *ZoneAffinityConfig) Validate() error {
+	switch c.Local {
+	case "", ZoneAffinityLocalZone, ZoneAffinityLocalRegion:
+	default:
+		return fmt.Errorf("Unknown zone_affinity local %q", c.Local)
+	}
+	set := 0
+	for _, v := range []string{c.Zone, c.Region, string(c.Local)} {
+		if v != "" {
+			set++
+		}
+	}
+	if set > 1 {
+		return fmt.Errorf("Only one of zone, region and local can be set for zone_affinity")
+	}
+	return nil
+}
+
+// ResolveLocal sets the Zone or Region (depending on `Local`) from the EC2
+// instance metadata, this must be called before the config is used
+func (c *ZoneAffinityConfig) ResolveLocal() error {
+	if c.Local == "" {
+		return nil
+	}
+	sess, err := session.NewSession()
+	if err != nil {
+		return err
+	}
+	doc, err := ec2metadata.New(sess).GetInstanceIdentityDocument()
+	if err != nil {
+		return fmt.Errorf("Error getting instance identity document: %v", err)
+	}
+	if c.Local == ZoneAffinityLocalZone {
+		c.Zone = doc.AvailabilityZone
+	} else {
+		c.Region = doc.Region
+	}
+	return nil
+}
+
+// enabled returns whether the targets are restricted to a zone or region
+func (c *ZoneAffinityConfig) enabled() bool {
+	return c.Zone != "" || c.Region != ""
+}
+
+// Filter returns the targets in the configured zone or region
+func (c *ZoneAffinityConfig) Filter(targets []*Target) []*Target {
+	if !c.enabled() {
+		return targets
+	}
+	key := c.MetaKey
+	if key == "" {
+		key = MetaAvailabilityZone
+	}
+
+	filtered := make([]*Target, 0, len(targets))
+	for _, target := range targets {
+		zone := target.Meta[key]
+		if zone == "" {
+			continue
+		}
+		if c.Zone != "" && zone != c.Zone {
+			continue
+		}
+		// availability zones are named after their region
+		if c.Region != "" && !strings.HasPrefix(zone, c.Region) {
+			continue
+		}
+		filtered = append(filtered, target)
+	}
+	return filtered
+}
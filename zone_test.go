@@ -0,0 +1,34 @@
+package targetsync
+
+import "testing"
+
+func TestZoneAffinityFilter(t *testing.T) {
+	src := []*Target{
+		{IP: "10.0.0.1", Meta: map[string]string{MetaAvailabilityZone: "us-west-2a"}},
+		{IP: "10.0.0.2", Meta: map[string]string{MetaAvailabilityZone: "us-west-2b"}},
+		{IP: "10.0.0.3", Meta: map[string]string{MetaAvailabilityZone: "us-east-1a"}},
+		{IP: "10.0.0.4"},
+	}
+
+	tests := []struct {
+		cfg      ZoneAffinityConfig
+		expected []string
+	}{
+		{cfg: ZoneAffinityConfig{}, expected: []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4"}},
+		{cfg: ZoneAffinityConfig{Zone: "us-west-2a"}, expected: []string{"10.0.0.1"}},
+		{cfg: ZoneAffinityConfig{Region: "us-west-2"}, expected: []string{"10.0.0.1", "10.0.0.2"}},
+		{cfg: ZoneAffinityConfig{Zone: "a", MetaKey: "az"}, expected: []string{}},
+	}
+
+	for i, test := range tests {
+		targets := test.cfg.Filter(src)
+		if len(targets) != len(test.expected) {
+			t.Fatalf("%d: expected %d targets, got %d", i, len(test.expected), len(targets))
+		}
+		for j, target := range targets {
+			if target.IP != test.expected[j] {
+				t.Fatalf("%d: mismatch at %d expected=%s actual=%s", i, j, test.expected[j], target.IP)
+			}
+		}
+	}
+}